@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+	"github.com/opencloudos/dedup-snapshotter/pkg/cri"
+	"github.com/opencloudos/dedup-snapshotter/pkg/socket"
+)
+
+var (
+	listen      = flag.String("listen", "/run/dedup-cri/cri.sock", "unix socket to serve the CRI proxy on (point kubelet --container-runtime-endpoint here)")
+	upstream    = flag.String("upstream", "/run/containerd/containerd.sock", "upstream CRI socket")
+	apiAddress  = flag.String("api", "127.0.0.1:8080", "dedup-snapshotter API address")
+	apiToken    = flag.String("api-token-file", "", "file containing the dedup-snapshotter api.token, needed when api.token is set")
+	pullTimeout = flag.Duration("pull-timeout", 30*time.Minute, "timeout of the dedup-aware pull before falling back to the upstream pull")
+	socketMode  = flag.String("socket-mode", "0600", "permission bits of the listen socket")
+	socketGID   = flag.Int("socket-gid", -1, "group owner of the listen socket, -1 keeps the process group")
+	allowedUIDs = flag.String("allowed-uids", "0", "comma-separated uids allowed to connect to the listen socket")
+	logLevel    = flag.String("log-level", "info", "log level (debug, info, warn, error)")
+	showVersion = flag.Bool("version", false, "show version and exit")
+)
+
+const (
+	version = "1.0.0"
+)
+
+func main() {
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Printf("dedup-cri version %s\n", version)
+		os.Exit(0)
+	}
+
+	setupLogging(*logLevel)
+
+	if err := run(); err != nil {
+		log.L.WithError(err).Fatal("failed to run dedup-cri")
+	}
+}
+
+func run() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	conn, err := cri.Dial(ctx, *upstream)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("failed to connect to upstream %s: %w", *upstream, err)
+	}
+	defer conn.Close()
+
+	token, err := readAPIToken(*apiToken)
+	if err != nil {
+		return err
+	}
+	puller := cri.NewAPIPuller(*apiAddress, token, &http.Client{Timeout: *pullTimeout})
+	proxy := cri.NewProxy(conn, puller)
+	proxy.SetStartNotifier(puller)
+
+	if err := os.MkdirAll(filepath.Dir(*listen), 0755); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(*listen); err != nil {
+		return fmt.Errorf("failed to remove socket: %w", err)
+	}
+	socketCfg, err := listenSocketConfig()
+	if err != nil {
+		return err
+	}
+	l, err := socket.Listen(*listen, socketCfg, nil)
+	if err != nil {
+		return err
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- proxy.Serve(l)
+	}()
+	log.L.Infof("dedup-cri proxying %s -> %s (version=%s)", *listen, *upstream, version)
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-sigCh:
+		log.L.Info("received signal, shutting down")
+		proxy.Stop()
+	}
+	return nil
+}
+
+// readAPIToken 读取 api.token 文件,path 为空时不带令牌
+func readAPIToken(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read API token: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// listenSocketConfig 返回监听 socket 的权限和允许连接的 UID,默认与快照服务的 socket 一样只允许 root
+func listenSocketConfig() (config.SocketConfig, error) {
+	cfg := config.SocketConfig{Mode: *socketMode, UID: -1, GID: *socketGID}
+	for _, s := range strings.Split(*allowedUIDs, ",") {
+		uid, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || uid < 0 {
+			return cfg, fmt.Errorf("invalid -allowed-uids %q", *allowedUIDs)
+		}
+		cfg.AllowedUIDs = append(cfg.AllowedUIDs, uid)
+	}
+	return cfg, nil
+}
+
+func setupLogging(level string) {
+	var logrusLevel log.Level
+	switch level {
+	case "debug":
+		logrusLevel = log.DebugLevel
+	case "info":
+		logrusLevel = log.InfoLevel
+	case "warn":
+		logrusLevel = log.WarnLevel
+	case "error":
+		logrusLevel = log.ErrorLevel
+	default:
+		logrusLevel = log.InfoLevel
+	}
+
+	log.L.Logger.SetLevel(logrusLevel)
+}
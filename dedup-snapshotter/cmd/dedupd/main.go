@@ -6,20 +6,28 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/internal/lazy"
 	"github.com/opencloudos/dedup-snapshotter/pkg/fscache"
+	"github.com/opencloudos/dedup-snapshotter/pkg/transport"
 )
 
 var (
-	rootDir     = flag.String("root", "/var/lib/dedup-snapshotter", "root directory for dedup snapshotter")
-	registry    = flag.String("registry", "https://registry-1.docker.io", "container registry URL")
-	workers     = flag.Int("workers", 4, "number of download workers")
-	logLevel    = flag.String("log-level", "info", "log level (debug, info, warn, error)")
-	showStats   = flag.Bool("stats", false, "show stats and exit")
-	showVersion = flag.Bool("version", false, "show version and exit")
+	rootDir      = flag.String("root", "/var/lib/dedup-snapshotter", "root directory for dedup snapshotter")
+	registry     = flag.String("registry", "https://registry-1.docker.io", "container registry URL")
+	workers      = flag.Int("workers", 4, "number of download workers")
+	contentRoot  = flag.String("content-store", lazy.DefaultContentStoreRoot, "containerd content store to read layer blobs from before the registry (empty to disable)")
+	mirrors      = flag.String("mirrors", "", "comma-separated read-only mirror URLs (dedup-snapshotter --mirror) to fetch from before the registry")
+	userAgent    = flag.String("user-agent", "dedupd/"+version, "User-Agent sent to registries and mirrors")
+	traceHeaders = flag.Bool("trace-headers", false, "send a W3C traceparent header with every registry request")
+	negativeTTL  = flag.Duration("negative-ttl", fscache.DefaultNegativeTTL, "how long blobs missing from a registry or mirror are not probed again")
+	logLevel     = flag.String("log-level", "info", "log level (debug, info, warn, error)")
+	showStats    = flag.Bool("stats", false, "show stats and exit")
+	showVersion  = flag.Bool("version", false, "show version and exit")
 )
 
 const (
@@ -44,6 +52,23 @@ func main() {
 		log.L.Fatalf("failed to create dedupd daemon: %v", err)
 	}
 
+	daemon.SetTransport(transport.New(nil, *userAgent, *traceHeaders))
+	if negative, err := fscache.OpenNegativeCache(*rootDir, *negativeTTL); err != nil {
+		log.L.WithError(err).Warn("negative lookup cache disabled")
+	} else {
+		daemon.SetNegativeCache(negative)
+	}
+
+	if *mirrors != "" {
+		daemon.UseMirrors(strings.Split(*mirrors, ","))
+	}
+
+	if *contentRoot != "" {
+		if err := daemon.UseContentStore(*contentRoot); err != nil {
+			log.L.WithError(err).Warn("content store read-through disabled")
+		}
+	}
+
 	if *showStats {
 		printStats(daemon)
 		os.Exit(0)
@@ -112,6 +137,21 @@ func printStats(daemon *fscache.DedupDaemon) {
 			stats.BackendStats.TotalSize,
 			float64(stats.BackendStats.TotalSize)/(1024*1024))
 	}
+
+	if stats.NegativeCache != nil {
+		fmt.Println("\n=== Negative Lookup Cache ===")
+		fmt.Printf("Entries: %d (ttl %s)\n", stats.NegativeCache.Entries, stats.NegativeCache.TTL)
+		fmt.Printf("Hits: %d, Misses: %d, Invalidations: %d\n",
+			stats.NegativeCache.Hits, stats.NegativeCache.Misses, stats.NegativeCache.Invalidations)
+	}
+
+	if stats.Kernel != nil {
+		fmt.Println("\n=== Kernel Fscache Statistics ===")
+		fmt.Printf("Cache Reads: %d, Misses: %d, Culled: %d\n",
+			stats.Kernel.CacheReads, stats.Kernel.CacheMisses, stats.Kernel.Culled)
+		fmt.Printf("On-demand Reads: %d (%d bytes)\n",
+			stats.Kernel.OnDemandReads, stats.Kernel.OnDemandReadBytes)
+	}
 }
 
 func statsReporter(ctx context.Context, daemon *fscache.DedupDaemon) {
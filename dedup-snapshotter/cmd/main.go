@@ -1,24 +1,50 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"flag"
 	"fmt"
-	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
+	contentapi "github.com/containerd/containerd/api/services/content/v1"
+	diffapi "github.com/containerd/containerd/api/services/diff/v1"
 	snapshotsapi "github.com/containerd/containerd/api/services/snapshots/v1"
+	contentproxy "github.com/containerd/containerd/content/proxy"
+	"github.com/containerd/containerd/contrib/diffservice"
 	"github.com/containerd/containerd/contrib/snapshotservice"
 	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/internal/faultinject"
+	"github.com/opencloudos/dedup-snapshotter/pkg/admission"
 	"github.com/opencloudos/dedup-snapshotter/pkg/api"
 	"github.com/opencloudos/dedup-snapshotter/pkg/audit"
+	"github.com/opencloudos/dedup-snapshotter/pkg/client"
 	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+	"github.com/opencloudos/dedup-snapshotter/pkg/erofs"
+	"github.com/opencloudos/dedup-snapshotter/pkg/jobs"
+	"github.com/opencloudos/dedup-snapshotter/pkg/layout"
+	"github.com/opencloudos/dedup-snapshotter/pkg/maintenance"
 	"github.com/opencloudos/dedup-snapshotter/pkg/metrics"
+	"github.com/opencloudos/dedup-snapshotter/pkg/mirror"
+	"github.com/opencloudos/dedup-snapshotter/pkg/proxy"
+	"github.com/opencloudos/dedup-snapshotter/pkg/retention"
+	"github.com/opencloudos/dedup-snapshotter/pkg/slowlog"
 	"github.com/opencloudos/dedup-snapshotter/pkg/snapshotter"
+	"github.com/opencloudos/dedup-snapshotter/pkg/socket"
+	"github.com/opencloudos/dedup-snapshotter/pkg/storage"
+	"github.com/opencloudos/dedup-snapshotter/pkg/storelock"
+	"github.com/opencloudos/dedup-snapshotter/pkg/transport"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 )
 
 const (
@@ -28,9 +54,82 @@ const (
 	defaultAPIAddress = ":8080"
 )
 
+// snapshotsServiceName 是健康检查中快照服务的名称,空服务名表示整个进程
+const snapshotsServiceName = "containerd.services.snapshots.v1.Snapshots"
+
 var globalMetrics = metrics.NewMetrics()
 
+var (
+	checkCompat  = flag.Bool("check-compat", false, "check whether this binary can use the on-disk format under ROOT and exit (non-zero if incompatible)")
+	downgradeTo  = flag.Int("downgrade-to", 0, "migrate the on-disk format under ROOT to an older format generation before rolling back, then exit")
+	rechunk      = flag.Bool("rechunk", false, "rebuild all EROFS images under ROOT with this binary's chunking parameters after they changed, then exit (the snapshotter must be stopped)")
+	mirrorMode   = flag.Bool("mirror", false, "serve chunks, EROFS images and layer blobs under ROOT read-only over HTTP (mirror.listen) instead of running the snapshotter")
+	baseline     = flag.String("baseline", "", "record, report or list store-wide dedup statistics baselines through the running snapshotter's API (API_ADDRESS, API_TOKEN), then exit")
+	baselineName = flag.String("baseline-name", "default", "name of the baseline to record or report against")
+	leakedMounts = flag.String("leaked-mounts", "", "list or clean mounts and loop devices not referenced by any snapshot through the running snapshotter's API (API_ADDRESS, API_TOKEN), then exit")
+	assumeYes    = flag.Bool("yes", false, "do not ask for confirmation before -leaked-mounts clean or -retention apply")
+	retentionCmd = flag.String("retention", "", "show the last image retention report, or run the retention policy now as dry-run or apply, through the running snapshotter's API (API_ADDRESS, API_TOKEN), then exit")
+	imageCmd     = flag.String("image", "", "inspect or verify the EROFS images given as arguments (paths, or image keys under ROOT) without mounting them, then exit (non-zero if verification fails)")
+)
+
 func main() {
+	flag.Parse()
+
+	if *checkCompat || *downgradeTo > 0 {
+		if err := runLayoutCommand(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *rechunk {
+		if err := runRechunkCommand(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *baseline != "" {
+		if err := runBaselineCommand(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *leakedMounts != "" {
+		if err := runLeakedMountsCommand(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *retentionCmd != "" {
+		if err := runRetentionCommand(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *imageCmd != "" {
+		if err := runImageCommand(flag.Args()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *mirrorMode {
+		if err := runMirror(); err != nil {
+			log.L.WithError(err).Fatal("failed to run mirror")
+		}
+		return
+	}
+
 	if err := run(); err != nil {
 		log.L.WithError(err).Fatal("failed to run snapshotter")
 	}
@@ -57,26 +156,27 @@ func run() error {
 		apiAddress = defaultAPIAddress
 	}
 
-	cfg := config.DefaultConfig(root)
-	if _, err := os.Stat(configPath); err == nil {
-		loadedCfg, err := config.LoadConfig(configPath)
-		if err != nil {
-			log.L.WithError(err).Warnf("failed to load config from %s, using defaults", configPath)
-		} else {
-			cfg = loadedCfg
-			log.L.Infof("loaded config from %s", configPath)
+	cfg := loadConfig(root, configPath)
+
+	// 不可变存储的 root 只读,审计库和磁盘告警改用 writable_dir
+	stateDir := root
+	if cfg.Store.Immutable {
+		stateDir = cfg.Store.WritableDir
+		if err := os.MkdirAll(stateDir, 0700); err != nil {
+			return fmt.Errorf("failed to create writable dir: %w", err)
 		}
-	} else {
-		log.L.Infof("no config file found at %s, using defaults", configPath)
 	}
 
-	auditLogger, err := audit.NewAuditLogger(filepath.Join(root, "audit.db"))
+	auditLogger, err := audit.NewAuditLogger(filepath.Join(stateDir, "audit.db"))
 	if err != nil {
 		return fmt.Errorf("failed to create audit logger: %w", err)
 	}
 	defer auditLogger.Close()
+	auditLogger.SetRedactor(audit.NewRedactor(cfg.Audit.RedactFields, !cfg.Audit.DisableDetails))
 
-	configWatcher, err := config.NewConfigWatcher(configPath, cfg)
+	// API、配置 watcher 和存储共用同一配置源,任一方的更新都会通知 watcher 的回调
+	configSource := config.NewSource(cfg)
+	configWatcher, err := config.NewConfigWatcherWithSource(configPath, configSource)
 	if err != nil {
 		log.L.WithError(err).Warn("failed to create config watcher")
 	} else {
@@ -87,12 +187,16 @@ func run() error {
 		configWatcher.AddCallback(func(oldConfig, newConfig *config.Config) error {
 			log.L.Info("config updated via file watcher")
 
+			auditLogger.SetRedactor(audit.NewRedactor(newConfig.Audit.RedactFields, !newConfig.Audit.DisableDetails))
+
 			ctx := audit.StartAudit(context.Background(), "config_reload", "config", "system", os.Getpid(), nil)
 			audit.FinishAudit(ctx, auditLogger, "success", nil)
 
 			if err := newConfig.ApplyKSMSettings(); err != nil {
 				log.L.WithError(err).Warn("failed to apply new KSM settings")
 			}
+			setupSlowLog(newConfig.SlowLog)
+			globalMetrics.SetLabelPolicy(newConfig.MetricsLabels.Enabled, newConfig.MetricsLabels.MaxSeries)
 			return nil
 		})
 	}
@@ -101,14 +205,21 @@ func run() error {
 		return fmt.Errorf("failed to setup logging: %w", err)
 	}
 
+	faultinject.Enable(cfg.FaultInjection.Enabled)
+	setupSlowLog(cfg.SlowLog)
+	globalMetrics.SetLabelPolicy(cfg.MetricsLabels.Enabled, cfg.MetricsLabels.MaxSeries)
+
 	if cfg.KSM.Enabled {
 		if err := cfg.ApplyKSMSettings(); err != nil {
 			log.L.WithError(err).Warn("failed to apply KSM settings")
 		}
 	}
 
-	if err := os.RemoveAll(address); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to remove socket: %w", err)
+	// 只读附着时 socket 属于存储所有者,不能删除
+	if !cfg.Store.ReadOnly {
+		if err := os.RemoveAll(address); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove socket: %w", err)
+		}
 	}
 
 	if err := os.MkdirAll(root, 0700); err != nil {
@@ -117,28 +228,130 @@ func run() error {
 
 	log.L.Infof("starting dedup-snapshotter with config: %s", cfg)
 
-	sn, err := snapshotter.NewSnapshotterWithAudit(root, auditLogger)
+	sn, err := snapshotter.NewSnapshotterWithSource(root, configSource, auditLogger, globalMetrics)
 	if err != nil {
 		return fmt.Errorf("failed to create snapshotter: %w", err)
 	}
 
+	if q := sn.Store().ConversionQueue(); q != nil {
+		slowlog.RegisterState("conversion_queue_depth", func() interface{} { return q.Depth() })
+	}
+
+	// 只读附着时任务由存储所有者执行
+	if !cfg.Store.ReadOnly {
+		jobManager, err := jobs.Open(filepath.Join(stateDir, "jobs.db"))
+		if err != nil {
+			return fmt.Errorf("failed to open job database: %w", err)
+		}
+		defer jobManager.Close()
+		if err := sn.Store().SetJobManager(jobManager); err != nil {
+			return fmt.Errorf("failed to restore jobs: %w", err)
+		}
+		jobManager.Start()
+	}
+
+	// 新节点加入时按集群镜像热度预热缓存,已预热过的节点直接跳过
+	if cfg.Warmup.Enabled && !cfg.Store.ReadOnly {
+		go func() {
+			if _, err := sn.Store().WarmFromPopularity(context.Background(), false); err != nil {
+				log.L.WithError(err).Warn("failed to warm cache from popularity feed")
+			}
+		}()
+	}
+
+	var retentionEngine *retention.Engine
+	if !cfg.Store.ReadOnly {
+		retentionEngine, err = startRetention(cfg.Retention, stateDir)
+		if err != nil {
+			return err
+		}
+		if retentionEngine != nil && configWatcher != nil {
+			configWatcher.AddCallback(func(oldConfig, newConfig *config.Config) error {
+				retentionEngine.SetPolicy(retentionPolicy(newConfig.Retention))
+				return nil
+			})
+		}
+	}
+
 	go startMetricsReporter()
-	go startAuditCleanup(auditLogger)
+	startMetricsPusher(cfg.MetricsPush)
+	alerter := startAlerter(cfg.Alerts, stateDir)
+	go startAuditCleanup(auditLogger, sn.Store().Maintenance())
+	go startStatsRecorder(auditLogger, cfg.StatsHistory, stateDir)
 
 	apiServer := api.NewAPIServer(apiAddress, auditLogger, cfg, configPath)
+	if configWatcher != nil {
+		apiServer.SetConfigWatcher(configWatcher)
+	} else {
+		apiServer.SetConfigSource(configSource)
+	}
+	apiServer.SetConversionQueue(sn.Store().ConversionQueue())
+	apiServer.SetStore(sn.Store())
+	apiServer.SetMetrics(globalMetrics)
+	apiServer.SetUsageReporter(sn)
+	apiServer.SetSnapshotFreezer(sn)
+	apiServer.SetMountCleaner(sn)
+	if binds := sn.Store().BindManager(); binds != nil {
+		apiServer.SetBindManager(binds)
+		go binds.Run(context.Background(), time.Duration(cfg.BindMounts.ReapInterval)*time.Second)
+	}
+	apiServer.SetAlerter(alerter)
+	if retentionEngine != nil {
+		apiServer.SetRetention(retentionEngine)
+	}
 	go func() {
 		if err := apiServer.Start(); err != nil {
 			log.L.WithError(err).Error("API server failed")
 		}
 	}()
 
-	rpc := grpc.NewServer()
+	if cfg.Store.ReadOnly {
+		// 快照元数据库由所有者独占,只读附着只提供 API 查询
+		log.L.Infof("store attached read-only, serving API on %s without snapshotter socket", apiAddress)
+		return waitReadOnly(apiServer)
+	}
+
+	pullProxy, err := startProxy(cfg, root, sn)
+	if err != nil {
+		return err
+	}
+
+	// 过载时 Usage、List 等可重试的 RPC 快速返回 RESOURCE_EXHAUSTED,不和 Prepare 争抢
+	admissionCtrl := admission.New(cfg.Admission)
+	admissionCtrl.SetMetrics(globalMetrics)
+	admissionCtrl.SetOverloadCheck(func() string {
+		if sn.Store().ConversionQueue().Saturated() {
+			return "conversion queue full"
+		}
+		return ""
+	})
+	if configWatcher != nil {
+		configWatcher.AddCallback(func(oldConfig, newConfig *config.Config) error {
+			admissionCtrl.SetConfig(newConfig.Admission)
+			return nil
+		})
+	}
+
+	rpc := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(admissionCtrl.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(admissionCtrl.StreamServerInterceptor()),
+	)
 	service := snapshotservice.FromSnapshotter(sn)
 	snapshotsapi.RegisterSnapshotsServer(rpc, service)
+	if cfg.Diff.Enabled {
+		if err := registerDiffService(rpc, sn, cfg.Diff); err != nil {
+			return err
+		}
+	}
+
+	// 健康检查和反射服务供 systemd/k8s 探针和 grpcurl 使用,无需调用快照 RPC
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(rpc, healthServer)
+	reflection.Register(rpc)
 
-	l, err := net.Listen("unix", address)
+	l, err := socket.Listen(address, cfg.Socket, auditLogger)
 	if err != nil {
-		return fmt.Errorf("failed to listen on %s: %w", address, err)
+		return err
 	}
 
 	log.L.Infof("snapshotter listening on %s", address)
@@ -151,6 +364,7 @@ func run() error {
 	go func() {
 		errCh <- rpc.Serve(l)
 	}()
+	healthServer.SetServingStatus(snapshotsServiceName, healthpb.HealthCheckResponse_SERVING)
 
 	select {
 	case err := <-errCh:
@@ -167,25 +381,454 @@ func run() error {
 				log.L.WithError(err).Error("failed to stop API server")
 			}
 		}()
+		if pullProxy != nil {
+			if err := pullProxy.Shutdown(ctx); err != nil {
+				log.L.WithError(err).Error("failed to stop pull-through proxy")
+			}
+			stats := pullProxy.Stats()
+			log.L.Infof("pull-through proxy served %d requests, converted %d layers", stats.Requests, stats.LayersConverted)
+		}
 
+		healthServer.Shutdown()
 		rpc.GracefulStop()
 	}
 
 	return nil
 }
 
-func startAuditCleanup(auditLogger *audit.AuditLogger) {
+// loadConfig 读取配置文件,不存在或无效时使用默认配置
+func loadConfig(root, configPath string) *config.Config {
+	cfg := config.DefaultConfig(root)
+	if _, err := os.Stat(configPath); err == nil {
+		loadedCfg, err := config.LoadConfig(configPath)
+		if err != nil {
+			log.L.WithError(err).Warnf("failed to load config from %s, using defaults", configPath)
+		} else {
+			cfg = loadedCfg
+			log.L.Infof("loaded config from %s", configPath)
+		}
+	} else {
+		log.L.Infof("no config file found at %s, using defaults", configPath)
+	}
+	return cfg
+}
+
+// runMirror 以只读镜像模式运行:不创建快照服务、不打开索引数据库,
+// 只通过 HTTP 提供 root 中的文件,供其他节点的 dedupd.mirrors 使用
+func runMirror() error {
+	root := os.Getenv("ROOT")
+	if root == "" {
+		root = defaultRoot
+	}
+
+	configPath := os.Getenv("CONFIG")
+	if configPath == "" {
+		configPath = defaultConfigPath
+	}
+
+	cfg := loadConfig(root, configPath)
+	if err := setupLogging(cfg.LogLevel); err != nil {
+		return fmt.Errorf("failed to setup logging: %w", err)
+	}
+
+	server, err := mirror.NewServer(root, cfg.Mirror.ContentRoot)
+	if err != nil {
+		return fmt.Errorf("failed to create mirror: %w", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.ListenAndServe(cfg.Mirror.Listen)
+	}()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-sigCh:
+		log.L.Info("received signal, shutting down")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stats := server.Stats()
+	log.L.Infof("mirror served %d requests, %d bytes", stats.Requests, stats.BytesSent)
+	return server.Shutdown(ctx)
+}
+
+// runLayoutCommand 执行 --check-compat 或 --downgrade-to。
+// 降级需要存储所有权,因此只能在快照服务停止后执行;检查可以在服务运行时进行
+func runLayoutCommand() error {
+	root := os.Getenv("ROOT")
+	if root == "" {
+		root = defaultRoot
+	}
+
+	if *checkCompat {
+		report, err := layout.Check(root)
+		if err != nil {
+			return err
+		}
+		fmt.Println(report)
+		if !report.Compatible() {
+			return layout.ErrIncompatible
+		}
+		configPath := os.Getenv("CONFIG")
+		if configPath == "" {
+			configPath = defaultConfigPath
+		}
+		_, err = layout.CheckFingerprint(root, storage.StoreFingerprint(loadConfig(root, configPath)))
+		return err
+	}
+
+	lock, err := storelock.Acquire(root, storelock.Store, "downgrade")
+	if err != nil {
+		return fmt.Errorf("stop the snapshotter before downgrading: %w", err)
+	}
+	defer lock.Release()
+
+	report, err := layout.Downgrade(root, *downgradeTo)
+	if report != nil {
+		fmt.Println(report)
+	}
+	return err
+}
+
+// runRechunkCommand 按本程序的切分参数重建 ROOT 下的镜像并更新指纹,与降级一样需要快照服务停止
+func runRechunkCommand() error {
+	root := os.Getenv("ROOT")
+	if root == "" {
+		root = defaultRoot
+	}
+	configPath := os.Getenv("CONFIG")
+	if configPath == "" {
+		configPath = defaultConfigPath
+	}
+
+	report, err := storage.Rechunk(context.Background(), root, config.NewSource(loadConfig(root, configPath)))
+	if report != nil {
+		fmt.Println(report)
+	}
+	if err != nil {
+		return err
+	}
+	if len(report.Failed) > 0 {
+		return fmt.Errorf("%d images could not be rechunked", len(report.Failed))
+	}
+	return nil
+}
+
+// runBaselineCommand 记录去重统计基线,或报告启用新功能(CDC、小块分层等)后相对基线的变化
+func runBaselineCommand() error {
+	apiAddress := os.Getenv("API_ADDRESS")
+	if apiAddress == "" {
+		apiAddress = defaultAPIAddress
+	}
+	c := client.New(apiAddress)
+	c.SetToken(os.Getenv("API_TOKEN"))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	switch *baseline {
+	case "record":
+		b, err := c.RecordBaseline(ctx, *baselineName)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("recorded baseline %s at %s\n", b.Name, b.Time.Format(time.RFC3339))
+		fmt.Printf("  logical %d bytes, stored %d bytes, zero %d bytes, images %d bytes, dedup ratio %.2f%%\n",
+			b.LogicalBytes, b.StoredBytes, b.ZeroBytes, b.ImageBytes, b.DedupRatio)
+	case "report":
+		r, err := c.CompareBaseline(ctx, *baselineName)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("since baseline %s (%s):\n", r.Baseline.Name, r.Baseline.Time.Format(time.RFC3339))
+		for key, value := range r.Changed {
+			fmt.Printf("  setting %s: %s -> %s\n", key, r.Baseline.Settings[key], value)
+		}
+		fmt.Printf("  logical %+d bytes, stored %+d bytes, zero %+d bytes, images %+d bytes\n",
+			r.LogicalBytes, r.StoredBytes, r.ZeroBytes, r.ImageBytes)
+		fmt.Printf("  dedup ratio %.2f%% -> %.2f%% (%+.2f), %d bytes saved versus the baseline ratio\n",
+			r.Baseline.DedupRatio, r.Current.DedupRatio, r.DedupRatioChange, r.SavedBytes)
+		for _, t := range r.Tiers {
+			fmt.Printf("  tier %s: logical %+d bytes, stored %+d bytes, dedup ratio %+.2f\n",
+				t.Tier, t.LogicalBytes, t.StoredBytes, t.DedupRatioChange)
+		}
+	case "list":
+		baselines, err := c.Baselines(ctx)
+		if err != nil {
+			return err
+		}
+		for _, b := range baselines {
+			fmt.Printf("%s\t%s\tlogical=%d\tstored=%d\tratio=%.2f%%\n",
+				b.Name, b.Time.Format(time.RFC3339), b.LogicalBytes, b.StoredBytes, b.DedupRatio)
+		}
+	default:
+		return fmt.Errorf("-baseline must be record, report or list")
+	}
+	return nil
+}
+
+// runLeakedMountsCommand 列出崩溃后遗留的泄漏挂载和 loop 设备,或在确认后强制卸载它们
+func runLeakedMountsCommand() error {
+	apiAddress := os.Getenv("API_ADDRESS")
+	if apiAddress == "" {
+		apiAddress = defaultAPIAddress
+	}
+	c := client.New(apiAddress)
+	c.SetToken(os.Getenv("API_TOKEN"))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	if *leakedMounts != "list" && *leakedMounts != "clean" {
+		return fmt.Errorf("-leaked-mounts must be list or clean")
+	}
+	leaks, err := c.LeakedMounts(ctx)
+	if err != nil {
+		return err
+	}
+	if len(leaks) == 0 {
+		fmt.Println("no leaked mounts")
+		return nil
+	}
+	paths := make([]string, 0, len(leaks))
+	for _, l := range leaks {
+		fmt.Printf("%s\t%s\t%s\t%s\n", l.Kind, l.Path, l.Source, l.Reason)
+		paths = append(paths, l.Path)
+	}
+	if *leakedMounts == "list" {
+		return nil
+	}
+
+	if !*assumeYes {
+		fmt.Printf("force-unmount %d leaked mounts? [y/N] ", len(leaks))
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			return fmt.Errorf("aborted")
+		}
+	}
+	cleaned, err := c.CleanupLeakedMounts(ctx, paths)
+	for _, l := range cleaned {
+		fmt.Printf("cleaned %s %s\n", l.Kind, l.Path)
+	}
+	return err
+}
+
+// runRetentionCommand 显示最近一次镜像保留规则检查的报告,或立即检查一次:dry-run 只报告,apply 在确认后删除
+func runRetentionCommand() error {
+	apiAddress := os.Getenv("API_ADDRESS")
+	if apiAddress == "" {
+		apiAddress = defaultAPIAddress
+	}
+	c := client.New(apiAddress)
+	c.SetToken(os.Getenv("API_TOKEN"))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	var report *client.RetentionReport
+	var err error
+	switch *retentionCmd {
+	case "status":
+		report, err = c.RetentionReport(ctx)
+	case "dry-run":
+		report, err = c.ApplyRetention(ctx, true)
+	case "apply":
+		if !*assumeYes {
+			fmt.Print("delete images selected by the retention policy? [y/N] ")
+			answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+			if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+				return fmt.Errorf("aborted")
+			}
+		}
+		report, err = c.ApplyRetention(ctx, false)
+	default:
+		return fmt.Errorf("-retention must be status, dry-run or apply")
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, d := range report.Images {
+		if d.Action != "delete" {
+			continue
+		}
+		result := "deleted"
+		switch {
+		case report.DryRun:
+			result = "would delete"
+		case d.Error != "":
+			result = "failed: " + d.Error
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\n", d.Digest, strings.Join(d.Names, ","), d.Reason, result)
+	}
+	fmt.Printf("%s: kept %d, deleted %d, failed %d (dry run: %v)\n",
+		report.Time.Format(time.RFC3339), report.Kept, report.Deleted, report.Failed, report.DryRun)
+	if report.Failed > 0 {
+		return fmt.Errorf("%d images could not be deleted", report.Failed)
+	}
+	return nil
+}
+
+// runImageCommand 不挂载镜像,直接解析 EROFS 超级块和元数据:inspect 打印元数据、压缩方式以及
+// ROOT 下 chunk 索引记录的 chunk 映射,verify 逐个校验镜像,适合在 CI 中检查构建出的镜像
+func runImageCommand(args []string) error {
+	if *imageCmd != "inspect" && *imageCmd != "verify" {
+		return fmt.Errorf("-image must be inspect or verify")
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("no images given")
+	}
+	root := os.Getenv("ROOT")
+	if root == "" {
+		root = defaultRoot
+	}
+
+	failed := 0
+	for _, arg := range args {
+		path, key := arg, ""
+		if !strings.ContainsRune(arg, '/') && !strings.HasSuffix(arg, erofs.ErofsImageExt) {
+			key = arg
+			path = filepath.Join(root, "images", key+erofs.ErofsImageExt)
+		}
+
+		info, err := erofs.VerifyImage(path)
+		if *imageCmd == "verify" {
+			if err != nil {
+				failed++
+				fmt.Printf("FAIL\t%s\t%v\n", arg, err)
+			} else {
+				fmt.Printf("ok\t%s\n", arg)
+			}
+			continue
+		}
+
+		if info == nil {
+			return err
+		}
+		fmt.Printf("%s\n", info.Path)
+		fmt.Printf("  uuid %s, volume %q, built %s\n", info.UUID, info.VolumeName, info.BuildTime.Format(time.RFC3339))
+		fmt.Printf("  %d bytes, %d blocks of %d bytes, %d inodes, root nid %d, metadata %d bytes\n",
+			info.Size, info.Blocks, info.BlockSize, info.Inodes, info.RootNid, info.MetadataBytes)
+		compression := "none"
+		if len(info.Compression) > 0 {
+			compression = strings.Join(info.Compression, ",")
+		}
+		fmt.Printf("  compression %s, features %s, checksum %#08x\n", compression, strings.Join(info.Features, ","), info.Checksum)
+		if err != nil {
+			failed++
+			fmt.Printf("  verification FAILED: %v\n", err)
+		} else {
+			fmt.Printf("  verification ok\n")
+		}
+
+		if key == "" {
+			continue
+		}
+		indexPath := filepath.Join(root, "chunk-index.db")
+		if _, err := os.Stat(indexPath); err != nil {
+			continue
+		}
+		indexer, err := erofs.NewChunkIndexer(indexPath)
+		if err != nil {
+			return fmt.Errorf("failed to open chunk index: %w", err)
+		}
+		chunks, err := indexer.ImageChunkMap(key)
+		indexer.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read chunk map of %s: %w", key, err)
+		}
+		fmt.Printf("  %d chunks:\n", len(chunks))
+		for _, c := range chunks {
+			fmt.Printf("    %d\t%s\t%d\t%s\trefs %d\n", c.Order, c.Hash, c.Size, c.Tier, c.RefCount)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d images failed verification", failed, len(args))
+	}
+	return nil
+}
+
+// waitReadOnly 在只读附着模式下等待退出信号后停止 API 服务
+func waitReadOnly(apiServer *api.APIServer) error {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	<-sigCh
+	log.L.Info("received signal, shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return apiServer.Stop(ctx)
+}
+
+// startAuditCleanup 每天清理一次过期审计日志并压缩审计库,压缩推迟到维护窗口内
+func startAuditCleanup(auditLogger *audit.AuditLogger, window *maintenance.Scheduler) {
 	ticker := time.NewTicker(24 * time.Hour)
 	defer ticker.Stop()
 
 	for range ticker.C {
 		ctx := context.Background()
+		window.Wait(ctx)
 		if err := auditLogger.Cleanup(ctx, 30); err != nil {
 			log.L.WithError(err).Error("failed to cleanup audit logs")
 		}
 	}
 }
 
+// startStatsRecorder 周期性把指标快照写入审计库,供 /api/v1/stats/history 查询趋势
+func startStatsRecorder(auditLogger *audit.AuditLogger, cfg config.StatsHistoryConfig, root string) {
+	if !cfg.Enabled {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(cfg.Interval) * time.Second)
+	defer ticker.Stop()
+	lastCleanup := time.Now()
+
+	for range ticker.C {
+		ctx := context.Background()
+		snapshot := globalMetrics.GetSnapshot()
+		sample := audit.StatsSample{
+			DedupRatio:   snapshot.DedupRatio,
+			CacheHitRate: snapshot.CacheHitRate,
+		}
+		if used, percent, err := metrics.DiskUsage(root); err == nil {
+			sample.StorageUsedBytes = used
+			sample.StorageUsagePercent = percent
+		}
+		if err := auditLogger.RecordStats(ctx, sample); err != nil {
+			log.L.WithError(err).Warn("failed to record stats sample")
+		}
+
+		if time.Since(lastCleanup) >= 24*time.Hour {
+			if err := auditLogger.CleanupStats(ctx, cfg.RetentionDays); err != nil {
+				log.L.WithError(err).Error("failed to cleanup stats samples")
+			}
+			lastCleanup = time.Now()
+		}
+	}
+}
+
+func setupSlowLog(cfg config.SlowLogConfig) {
+	if !cfg.Enabled {
+		slowlog.Disable()
+		return
+	}
+	ms := func(v int64) time.Duration { return time.Duration(v) * time.Millisecond }
+	slowlog.Configure(slowlog.Config{
+		Thresholds: map[string]time.Duration{
+			slowlog.OpPrepare:    ms(cfg.PrepareMs),
+			slowlog.OpMount:      ms(cfg.MountMs),
+			slowlog.OpConversion: ms(cfg.ConversionMs),
+			slowlog.OpDownload:   ms(cfg.DownloadMs),
+		},
+		MaxPerMinute: cfg.MaxPerMinute,
+		StackBytes:   cfg.StackKB * 1024,
+	})
+}
+
 func setupLogging(level string) error {
 	switch level {
 	case "debug":
@@ -211,6 +854,125 @@ func startMetricsReporter() {
 	}
 }
 
+// startMetricsPusher 在配置了推送时启动后台推送,node 默认取主机名
+func startMetricsPusher(cfg config.MetricsPushConfig) {
+	if !cfg.Enabled {
+		return
+	}
+
+	labels := metrics.Labels{}
+	for k, v := range cfg.Labels {
+		labels[k] = v
+	}
+	node := cfg.Node
+	if node == "" {
+		node, _ = os.Hostname()
+	}
+	if node != "" {
+		labels["node"] = node
+	}
+	if cfg.Cluster != "" {
+		labels["cluster"] = cfg.Cluster
+	}
+
+	pusher, err := metrics.NewPusher(globalMetrics, metrics.PushConfig{
+		Mode:     cfg.Mode,
+		URL:      cfg.URL,
+		Job:      cfg.Job,
+		Interval: time.Duration(cfg.Interval) * time.Second,
+		Labels:   labels,
+	})
+	if err != nil {
+		log.L.WithError(err).Warn("metrics push disabled")
+		return
+	}
+
+	log.L.Infof("pushing metrics to %s every %ds (%s)", cfg.URL, cfg.Interval, cfg.Mode)
+	go pusher.Run(context.Background())
+}
+
+// registerDiffService 在快照服务的 gRPC 服务上注册 diff 服务,层 blob 通过 containerd 的 content 服务读写。
+// 连接不阻塞,containerd 晚于本进程启动时在首次调用时建立
+func registerDiffService(rpc *grpc.Server, sn *snapshotter.Snapshotter, cfg config.DiffConfig) error {
+	conn, err := grpc.Dial("unix://"+cfg.ContainerdAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return fmt.Errorf("failed to connect to containerd at %s: %w", cfg.ContainerdAddress, err)
+	}
+	differ := snapshotter.NewDiffer(sn, contentproxy.NewContentStore(contentapi.NewContentClient(conn)))
+	diffapi.RegisterDiffServer(rpc, diffservice.FromApplierAndComparer(differ, differ))
+	log.L.Infof("diff service enabled, using containerd content store at %s", cfg.ContainerdAddress)
+	return nil
+}
+
+// retentionPolicy 从配置生成镜像保留规则
+func retentionPolicy(cfg config.RetentionConfig) retention.Policy {
+	return retention.Policy{KeepLastTags: cfg.KeepLastTags, UnusedDays: cfg.UnusedDays, Pinned: cfg.Pinned}
+}
+
+// startRetention 在 retention.enabled 时按间隔检查 containerd 中的镜像,连接不阻塞,containerd 晚于本进程启动时在首次检查时建立
+func startRetention(cfg config.RetentionConfig, stateDir string) (*retention.Engine, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	conn, err := grpc.Dial("unix://"+cfg.ContainerdAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to containerd at %s: %w", cfg.ContainerdAddress, err)
+	}
+	engine := retention.NewEngine(retention.NewContainerdStore(conn, cfg.Namespace), retentionPolicy(cfg), filepath.Join(stateDir, "retention.json"))
+	go engine.Run(context.Background(), time.Duration(cfg.Interval)*time.Second, cfg.DryRun)
+	log.L.Infof("image retention enabled for containerd namespace %s, checking every %ds (dry run: %v)", cfg.Namespace, cfg.Interval, cfg.DryRun)
+	return engine, nil
+}
+
+func startAlerter(cfg config.AlertsConfig, root string) *metrics.Alerter {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	alerter := metrics.NewAlerter(globalMetrics, metrics.AlertRules{
+		MinDedupRatio:   cfg.MinDedupRatio,
+		MinCacheHitRate: cfg.MinCacheHitRate,
+		MaxDiskUsage:    cfg.MaxDiskUsage,
+		DiskPath:        root,
+		WebhookURL:      cfg.WebhookURL,
+		Interval:        time.Duration(cfg.Interval) * time.Second,
+	})
+	go alerter.Run(context.Background())
+	return alerter
+}
+
+// startProxy 在 proxy.enabled 时启动镜像仓库拉取代理,经过代理的层转换后写入本节点的存储
+func startProxy(cfg *config.Config, root string, sn *snapshotter.Snapshotter) (*proxy.Server, error) {
+	if !cfg.Proxy.Enabled {
+		return nil, nil
+	}
+
+	scratch := cfg.Scratch.Dir
+	if scratch == "" {
+		scratch = root
+	}
+	t := transport.New(nil, cfg.RegistryClient.UserAgent, cfg.RegistryClient.TraceHeaders)
+	t.SetMetrics(globalMetrics)
+	server, err := proxy.NewServer(sn.Store(), proxy.Options{
+		Upstream:     cfg.Proxy.Upstream,
+		TempDir:      filepath.Join(scratch, "proxy"),
+		Workers:      cfg.Proxy.Workers,
+		MaxLayerSize: cfg.Proxy.MaxLayerMB << 20,
+		Client:       t.Client(0),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pull-through proxy: %w", err)
+	}
+
+	go func() {
+		if err := server.ListenAndServe(cfg.Proxy.Listen); err != nil && err != http.ErrServerClosed {
+			log.L.WithError(err).Error("pull-through proxy failed")
+		}
+	}()
+	return server, nil
+}
+
 func printMetrics() {
 	snapshot := globalMetrics.GetSnapshot()
 	log.L.Infof("\n%s", snapshot.String())
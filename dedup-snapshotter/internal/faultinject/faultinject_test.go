@@ -0,0 +1,57 @@
+package faultinject
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// TestInject 验证开关、触发次数、延迟和 SQLite busy 错误类型
+func TestInject(t *testing.T) {
+	defer ClearAll()
+	defer Enable(false)
+
+	if err := Set(Fault{Name: "unknown"}); err == nil {
+		t.Error("expected error for unknown injection point")
+	}
+
+	if err := Set(Fault{Name: MountFailure, Probability: 1, Count: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Inject(MountFailure); err != nil {
+		t.Errorf("fault must not trigger while disabled: %v", err)
+	}
+
+	Enable(true)
+	for i := 0; i < 2; i++ {
+		if err := Inject(MountFailure); !errors.Is(err, ErrInjected) {
+			t.Errorf("trigger %d: expected injected error, got %v", i, err)
+		}
+	}
+	if err := Inject(MountFailure); err != nil {
+		t.Errorf("fault should be removed after count exhausted: %v", err)
+	}
+	t.Logf("✓ mount_failure 触发 2 次后自动移除")
+
+	if err := Set(Fault{Name: SlowChunk, DelayMs: 20}); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := Inject(SlowChunk); err != nil {
+		t.Errorf("delay-only fault must not fail: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("expected at least 20ms delay, got %v", elapsed)
+	}
+
+	if err := Set(Fault{Name: SQLiteBusy, Probability: 1}); err != nil {
+		t.Fatal(err)
+	}
+	var sqliteErr sqlite3.Error
+	if err := Inject(SQLiteBusy); !errors.As(err, &sqliteErr) || sqliteErr.Code != sqlite3.ErrBusy {
+		t.Errorf("expected sqlite busy error, got %v", err)
+	}
+	t.Logf("✓ slow_chunk 延迟和 sqlite_busy 错误类型正确")
+}
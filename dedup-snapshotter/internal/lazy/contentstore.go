@@ -0,0 +1,121 @@
+// Package lazy 提供按需读取层数据的本地数据源,数据源在 blob 不存在时返回 ErrBlobNotFound,
+// 由调用方回退到下一个数据源。
+package lazy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	DefaultContentStoreRoot = "/var/lib/containerd/io.containerd.content.v1.content"
+)
+
+// ErrBlobNotFound 表示当前数据源中不存在请求的 blob,调用方应尝试下一个数据源
+var ErrBlobNotFound = errors.New("blob not found")
+
+// ContentStoreFetcher 从 containerd 本地 content store 中已下载的层 blob 还原块数据,
+// 避免对本地已有的层再次走网络
+type ContentStoreFetcher struct {
+	root  string
+	store content.Store
+}
+
+func NewContentStoreFetcher(root string) (*ContentStoreFetcher, error) {
+	if _, err := os.Stat(root); err != nil {
+		return nil, fmt.Errorf("content store not available at %s: %w", root, err)
+	}
+
+	store, err := local.NewStore(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open content store: %w", err)
+	}
+
+	return &ContentStoreFetcher{
+		root:  root,
+		store: store,
+	}, nil
+}
+
+func (c *ContentStoreFetcher) Fetch(ctx context.Context, imageID, layerDigest string, offset, size int64) ([]byte, error) {
+	dgst, err := digest.Parse(layerDigest)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", layerDigest, ErrBlobNotFound)
+	}
+
+	ra, err := c.store.ReaderAt(ctx, ocispec.Descriptor{Digest: dgst})
+	if err != nil {
+		if errdefs.IsNotFound(err) || errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%s: %w", layerDigest, ErrBlobNotFound)
+		}
+		return nil, err
+	}
+	defer ra.Close()
+
+	if offset >= ra.Size() {
+		return nil, fmt.Errorf("offset %d beyond blob %s size %d", offset, layerDigest, ra.Size())
+	}
+	if offset+size > ra.Size() {
+		size = ra.Size() - offset
+	}
+
+	data := make([]byte, size)
+	n, err := ra.ReadAt(data, offset)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read blob %s: %w", layerDigest, err)
+	}
+
+	return data[:n], nil
+}
+
+// FetchStream 直接打开 content store 中的 blob 文件,返回的流写入缓存对象时可由内核复制(copy_file_range)
+func (c *ContentStoreFetcher) FetchStream(ctx context.Context, imageID, layerDigest string, offset, size int64) (io.ReadCloser, error) {
+	dgst, err := digest.Parse(layerDigest)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", layerDigest, ErrBlobNotFound)
+	}
+
+	f, err := os.Open(filepath.Join(c.root, "blobs", dgst.Algorithm().String(), dgst.Encoded()))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%s: %w", layerDigest, ErrBlobNotFound)
+		}
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if offset >= info.Size() {
+		f.Close()
+		return nil, fmt.Errorf("offset %d beyond blob %s size %d", offset, layerDigest, info.Size())
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &FileSection{LimitedReader: io.LimitedReader{R: f, N: size}, file: f}, nil
+}
+
+// FileSection 是本地文件中的一段。写入缓存对象时解开为 *io.LimitedReader,
+// 使 os.File.ReadFrom 能够使用 copy_file_range 而不经过用户态缓冲
+type FileSection struct {
+	io.LimitedReader
+	file *os.File
+}
+
+func (s *FileSection) Close() error {
+	return s.file.Close()
+}
@@ -0,0 +1,200 @@
+// Package accounting 按层记录从镜像仓库下载和从本地缓存提供的字节数,供平台按租户分摊
+// 网络和存储成本。统计按窗口进行:重置时关闭当前窗口并保留为上一窗口,账本定期落盘,重启后继续累计
+package accounting
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/containerd/log"
+)
+
+// flushInterval 是账本落盘的间隔
+const flushInterval = time.Minute
+
+// Counters 是一个层在窗口内的流量
+type Counters struct {
+	DownloadedBytes  int64 `json:"downloaded_bytes"`
+	CacheServedBytes int64 `json:"cache_served_bytes"`
+}
+
+// Window 是一个统计窗口,进行中的窗口 End 为零值
+type Window struct {
+	Start  time.Time           `json:"start"`
+	End    time.Time           `json:"end,omitempty"`
+	Layers map[string]Counters `json:"layers"`
+}
+
+func newWindow(start time.Time) Window {
+	return Window{Start: start, Layers: make(map[string]Counters)}
+}
+
+func (w Window) clone() Window {
+	c := Window{Start: w.Start, End: w.End, Layers: make(map[string]Counters, len(w.Layers))}
+	for k, v := range w.Layers {
+		c.Layers[k] = v
+	}
+	return c
+}
+
+// Ledger 是按层记录流量的账本,nil 账本的记录方法不做任何事
+type Ledger struct {
+	path string
+
+	mu       sync.Mutex
+	current  Window
+	previous *Window
+	dirty    bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type ledgerState struct {
+	Current  Window  `json:"current"`
+	Previous *Window `json:"previous,omitempty"`
+}
+
+// Open 打开 path 处的账本,文件不存在或损坏时从新窗口开始
+func Open(path string) *Ledger {
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &Ledger{path: path, current: newWindow(time.Now()), ctx: ctx, cancel: cancel}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.L.WithError(err).Warnf("failed to read accounting ledger %s, starting a new window", path)
+		}
+		return l
+	}
+	var state ledgerState
+	if err := json.Unmarshal(data, &state); err != nil || state.Current.Start.IsZero() {
+		log.L.Warnf("ignoring corrupt accounting ledger %s", path)
+		return l
+	}
+	if state.Current.Layers == nil {
+		state.Current.Layers = make(map[string]Counters)
+	}
+	l.current, l.previous = state.Current, state.Previous
+	return l
+}
+
+// AddDownloaded 记录为层从镜像仓库下载的字节数
+func (l *Ledger) AddDownloaded(layer string, n int64) {
+	l.add(layer, Counters{DownloadedBytes: n})
+}
+
+// AddCacheServed 记录层使用本地已有数据而不必下载的字节数
+func (l *Ledger) AddCacheServed(layer string, n int64) {
+	l.add(layer, Counters{CacheServedBytes: n})
+}
+
+func (l *Ledger) add(layer string, delta Counters) {
+	if l == nil || layer == "" || delta == (Counters{}) {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c := l.current.Layers[layer]
+	c.DownloadedBytes += delta.DownloadedBytes
+	c.CacheServedBytes += delta.CacheServedBytes
+	l.current.Layers[layer] = c
+	l.dirty = true
+}
+
+// Current 返回进行中窗口的副本
+func (l *Ledger) Current() Window {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.current.clone()
+}
+
+// Previous 返回上一个已关闭窗口的副本,尚未重置过时返回 nil
+func (l *Ledger) Previous() *Window {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.previous == nil {
+		return nil
+	}
+	w := l.previous.clone()
+	return &w
+}
+
+// Reset 关闭进行中的窗口并开始新窗口,返回关闭的窗口
+func (l *Ledger) Reset() Window {
+	l.mu.Lock()
+	now := time.Now()
+	closed := l.current
+	closed.End = now
+	l.previous = &closed
+	l.current = newWindow(now)
+	l.dirty = true
+	l.mu.Unlock()
+
+	if err := l.Save(); err != nil {
+		log.L.WithError(err).Warn("failed to save accounting ledger")
+	}
+	log.L.Infof("accounting window %s - %s closed with %d layers", closed.Start.Format(time.RFC3339), now.Format(time.RFC3339), len(closed.Layers))
+	return closed.clone()
+}
+
+// Save 把账本写入文件
+func (l *Ledger) Save() error {
+	l.mu.Lock()
+	data, err := json.Marshal(ledgerState{Current: l.current, Previous: l.previous})
+	l.dirty = false
+	l.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path)
+}
+
+// Start 定期落盘账本;resetInterval 大于 0 时窗口到期后自动重置
+func (l *Ledger) Start(resetInterval time.Duration) {
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-l.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			l.mu.Lock()
+			expired := resetInterval > 0 && time.Since(l.current.Start) >= resetInterval
+			dirty := l.dirty
+			l.mu.Unlock()
+			switch {
+			case expired:
+				l.Reset()
+			case dirty:
+				if err := l.Save(); err != nil {
+					log.L.WithError(err).Warn("failed to save accounting ledger")
+				}
+			}
+		}
+	}()
+}
+
+// Close 停止定期落盘并保存账本
+func (l *Ledger) Close() {
+	l.cancel()
+	l.wg.Wait()
+	if err := l.Save(); err != nil {
+		log.L.WithError(err).Warn("failed to save accounting ledger")
+	}
+}
@@ -0,0 +1,47 @@
+package accounting
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestLedgerWindows 验证按层累计流量、重置窗口以及重启后从文件恢复
+func TestLedgerWindows(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounting.json")
+	l := Open(path)
+	l.AddDownloaded("layer1", 100)
+	l.AddDownloaded("layer1", 50)
+	l.AddCacheServed("layer1", 30)
+	l.AddCacheServed("layer2", 10)
+
+	cur := l.Current()
+	if got := cur.Layers["layer1"]; got.DownloadedBytes != 150 || got.CacheServedBytes != 30 {
+		t.Fatalf("unexpected layer1 counters: %+v", got)
+	}
+	if l.Previous() != nil {
+		t.Fatal("expected no previous window before reset")
+	}
+
+	closed := l.Reset()
+	if closed.End.IsZero() || len(closed.Layers) != 2 {
+		t.Fatalf("unexpected closed window: %+v", closed)
+	}
+	if len(l.Current().Layers) != 0 {
+		t.Error("expected empty window after reset")
+	}
+	l.AddDownloaded("layer3", 7)
+	l.Close()
+
+	reopened := Open(path)
+	if got := reopened.Current().Layers["layer3"].DownloadedBytes; got != 7 {
+		t.Errorf("expected layer3 downloaded 7 after reopen, got %d", got)
+	}
+	prev := reopened.Previous()
+	if prev == nil || prev.Layers["layer2"].CacheServedBytes != 10 {
+		t.Errorf("expected previous window to survive reopen, got %+v", prev)
+	}
+
+	var nilLedger *Ledger
+	nilLedger.AddDownloaded("layer1", 1)
+	t.Logf("✓ 账本按窗口累计并在重启后恢复")
+}
@@ -0,0 +1,220 @@
+// Package admission 为快照 gRPC 服务做准入控制:按规则限制各组 RPC 的并发,超过上限的请求排队一段时间,
+// 仍无空位时快速返回 RESOURCE_EXHAUSTED,而不是让 Usage、List 这类可以重试的查询和 Prepare 争抢数据库和 IO
+package admission
+
+import (
+	"context"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+	"github.com/opencloudos/dedup-snapshotter/pkg/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 拒绝原因,用于指标
+const (
+	ReasonLimit    = "limit"
+	ReasonOverload = "overload"
+)
+
+// limiter 是一条规则的并发计数,released 在有空位时关闭以唤醒排队的请求
+type limiter struct {
+	rule     config.AdmissionRule
+	mu       sync.Mutex
+	inFlight int
+	waiting  int
+	released chan struct{}
+}
+
+func newLimiter(rule config.AdmissionRule) *limiter {
+	return &limiter{rule: rule, released: make(chan struct{})}
+}
+
+// acquire 在 timeout 内等待空位,超时或 ctx 取消时返回 false
+func (l *limiter) acquire(ctx context.Context, timeout time.Duration) bool {
+	var timer *time.Timer
+	for {
+		l.mu.Lock()
+		if l.inFlight < l.rule.MaxConcurrent {
+			l.inFlight++
+			l.mu.Unlock()
+			if timer != nil {
+				timer.Stop()
+			}
+			return true
+		}
+		if timeout <= 0 {
+			l.mu.Unlock()
+			return false
+		}
+		if timer == nil {
+			timer = time.NewTimer(timeout)
+		}
+		l.waiting++
+		released := l.released
+		l.mu.Unlock()
+
+		ok := true
+		select {
+		case <-released:
+		case <-timer.C:
+			ok = false
+		case <-ctx.Done():
+			timer.Stop()
+			ok = false
+		}
+		l.mu.Lock()
+		l.waiting--
+		l.mu.Unlock()
+		if !ok {
+			return false
+		}
+	}
+}
+
+func (l *limiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+func (l *limiter) queued() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.waiting > 0
+}
+
+// Controller 按配置的规则对 RPC 做准入。nil 的 Controller 不做任何限制
+type Controller struct {
+	mu       sync.RWMutex
+	enabled  bool
+	limiters []*limiter
+	byMethod map[string]*limiter
+	// overloaded 报告存储是否过载,返回非空原因时可丢弃的 RPC 直接被拒绝
+	overloaded func() string
+	metrics    *metrics.Metrics
+}
+
+// New 按 cfg 创建准入控制器
+func New(cfg config.AdmissionConfig) *Controller {
+	c := &Controller{}
+	c.SetConfig(cfg)
+	return c
+}
+
+// SetConfig 替换准入规则。正在执行的请求在原规则上释放,新规则从零开始计数
+func (c *Controller) SetConfig(cfg config.AdmissionConfig) {
+	limiters := make([]*limiter, 0, len(cfg.Rules))
+	byMethod := make(map[string]*limiter)
+	for _, rule := range cfg.Rules {
+		l := newLimiter(rule)
+		limiters = append(limiters, l)
+		for _, m := range rule.Methods {
+			byMethod[m] = l
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.enabled = cfg.Enabled
+	c.limiters = limiters
+	c.byMethod = byMethod
+}
+
+// SetOverloadCheck 设置存储过载的判断,fn 返回非空原因时可丢弃的 RPC 不排队直接拒绝
+func (c *Controller) SetOverloadCheck(fn func() string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.overloaded = fn
+}
+
+// SetMetrics 按 RPC 和原因记录被拒绝的请求数
+func (c *Controller) SetMetrics(m *metrics.Metrics) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.metrics = m
+}
+
+// Admit 为 RPC method 申请执行,成功时返回的 release 必须在 RPC 结束后调用;
+// 被拒绝时返回 RESOURCE_EXHAUSTED 状态的错误
+func (c *Controller) Admit(ctx context.Context, method string) (func(), error) {
+	if c == nil {
+		return func() {}, nil
+	}
+	c.mu.RLock()
+	enabled, l := c.enabled, c.byMethod[method]
+	overloaded, m := c.overloaded, c.metrics
+	limiters := c.limiters
+	c.mu.RUnlock()
+	if !enabled || l == nil {
+		return func() {}, nil
+	}
+
+	if l.rule.Sheddable {
+		if reason := c.overloadReason(overloaded, limiters); reason != "" {
+			return nil, c.reject(ctx, m, method, ReasonOverload, "%s rejected: %s", method, reason)
+		}
+	}
+	if !l.acquire(ctx, time.Duration(l.rule.QueueTimeout)*time.Millisecond) {
+		if err := ctx.Err(); err != nil {
+			return nil, status.FromContextError(err).Err()
+		}
+		return nil, c.reject(ctx, m, method, ReasonLimit, "%s rejected: %d concurrent requests in flight", method, l.rule.MaxConcurrent)
+	}
+	return l.release, nil
+}
+
+// overloadReason 在转换队列已满或不可丢弃的 RPC 正在排队时返回原因
+func (c *Controller) overloadReason(overloaded func() string, limiters []*limiter) string {
+	if overloaded != nil {
+		if reason := overloaded(); reason != "" {
+			return reason
+		}
+	}
+	for _, l := range limiters {
+		if !l.rule.Sheddable && l.queued() {
+			return "critical requests are queued"
+		}
+	}
+	return ""
+}
+
+func (c *Controller) reject(ctx context.Context, m *metrics.Metrics, method, reason, format string, args ...interface{}) error {
+	if m != nil {
+		m.AddCounter("rpc_rejected", metrics.Labels{"method": method, "reason": reason}, 1)
+	}
+	err := status.Errorf(codes.ResourceExhausted, format, args...)
+	log.G(ctx).WithError(err).Debug("request shed by admission control")
+	return err
+}
+
+// UnaryServerInterceptor 返回对一元 RPC 做准入的拦截器,规则按 RPC 名(完整方法名的最后一段)匹配
+func (c *Controller) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		release, err := c.Admit(ctx, path.Base(info.FullMethod))
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor 返回对流式 RPC(如快照 List)做准入的拦截器
+func (c *Controller) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		release, err := c.Admit(ss.Context(), path.Base(info.FullMethod))
+		if err != nil {
+			return err
+		}
+		defer release()
+		return handler(srv, ss)
+	}
+}
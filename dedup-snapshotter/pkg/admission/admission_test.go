@@ -0,0 +1,110 @@
+package admission
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+	"github.com/opencloudos/dedup-snapshotter/pkg/metrics"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestAdmission 验证并发上限、排队超时、过载时丢弃可丢弃的 RPC 以及热更新规则
+func TestAdmission(t *testing.T) {
+	c := New(config.AdmissionConfig{
+		Enabled: true,
+		Rules: []config.AdmissionRule{
+			{Methods: []string{"Usage", "List"}, MaxConcurrent: 1, QueueTimeout: 20, Sheddable: true},
+			{Methods: []string{"Prepare"}, MaxConcurrent: 1, QueueTimeout: 1000},
+		},
+	})
+	m := metrics.NewMetrics()
+	c.SetMetrics(m)
+	ctx := context.Background()
+
+	release, err := c.Admit(ctx, "Usage")
+	if err != nil {
+		t.Fatalf("first Usage rejected: %v", err)
+	}
+	start := time.Now()
+	if _, err := c.Admit(ctx, "List"); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected RESOURCE_EXHAUSTED, got %v", err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatal("rejection should not wait past the queue timeout")
+	}
+	release()
+	t.Logf("✓ 超过并发上限的请求在排队超时后被拒绝")
+
+	// 排队中的请求在空位释放后获得执行
+	release, _ = c.Admit(ctx, "Prepare")
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		release()
+	}()
+	release2, err := c.Admit(ctx, "Prepare")
+	if err != nil {
+		t.Fatalf("queued Prepare rejected: %v", err)
+	}
+
+	// Prepare 排队时 Usage 直接被丢弃
+	done := make(chan error, 1)
+	go func() {
+		r, err := c.Admit(ctx, "Prepare")
+		if err == nil {
+			r()
+		}
+		done <- err
+	}()
+	for !c.byMethod["Prepare"].queued() {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := c.Admit(ctx, "Usage"); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected Usage to be shed while Prepare is queued, got %v", err)
+	}
+	release2()
+	if err := <-done; err != nil {
+		t.Fatalf("queued Prepare rejected: %v", err)
+	}
+	t.Logf("✓ 关键 RPC 排队时可丢弃的 RPC 立即被拒绝")
+
+	c.SetOverloadCheck(func() string { return "conversion queue full" })
+	if _, err := c.Admit(ctx, "Usage"); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected Usage to be shed on overload, got %v", err)
+	}
+	if r, err := c.Admit(ctx, "Prepare"); err != nil {
+		t.Fatalf("Prepare should not be shed on overload: %v", err)
+	} else {
+		r()
+	}
+	if r, err := c.Admit(ctx, "Stat"); err != nil {
+		t.Fatalf("unlisted methods should not be limited: %v", err)
+	} else {
+		r()
+	}
+	t.Logf("✓ 存储过载时只丢弃可丢弃的 RPC")
+
+	rejected := map[string]int64{}
+	for _, s := range m.GetSnapshot().Counters {
+		if s.Name == "rpc_rejected" {
+			rejected[s.Labels["method"]+"/"+s.Labels["reason"]] = s.Value
+		}
+	}
+	if rejected["List/"+ReasonLimit] != 1 || rejected["Usage/"+ReasonOverload] != 2 {
+		t.Fatalf("unexpected rejection counters: %v", rejected)
+	}
+
+	c.SetConfig(config.AdmissionConfig{Enabled: false})
+	if r, err := c.Admit(ctx, "Usage"); err != nil {
+		t.Fatalf("disabled controller rejected Usage: %v", err)
+	} else {
+		r()
+	}
+	var nilController *Controller
+	if _, err := nilController.Admit(ctx, "Usage"); err != nil {
+		t.Fatalf("nil controller rejected Usage: %v", err)
+	}
+	t.Logf("✓ 关闭准入控制后不再限制")
+}
@@ -3,47 +3,195 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/internal/faultinject"
+	"github.com/opencloudos/dedup-snapshotter/pkg/accounting"
 	"github.com/opencloudos/dedup-snapshotter/pkg/audit"
+	"github.com/opencloudos/dedup-snapshotter/pkg/client"
 	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+	"github.com/opencloudos/dedup-snapshotter/pkg/erofs"
+	"github.com/opencloudos/dedup-snapshotter/pkg/fscache"
+	"github.com/opencloudos/dedup-snapshotter/pkg/jobs"
+	"github.com/opencloudos/dedup-snapshotter/pkg/metrics"
+	"github.com/opencloudos/dedup-snapshotter/pkg/retention"
+	"github.com/opencloudos/dedup-snapshotter/pkg/storage"
 )
 
 type APIServer struct {
 	auditLogger *audit.AuditLogger
-	config      *config.Config
+	configs     *config.Source
+	watcher     *config.ConfigWatcher
 	configPath  string
+	conversions *storage.ConversionQueue
+	store       *storage.DedupStore
+	usage       UsageReporter
+	freezer     SnapshotFreezer
+	cleaner     MountCleaner
+	binds       *erofs.BindManager
+	metrics     *metrics.Metrics
+	alerter     *metrics.Alerter
+	retention   *retention.Engine
 	server      *http.Server
+	routes      *routeMux
+}
+
+// UsageReporter 按镜像和命名空间计算去重感知的空间占用和流量分摊,由快照服务实现
+type UsageReporter interface {
+	DedupUsage(ctx context.Context) (*storage.UsageReport, error)
+	Chargeback(ctx context.Context, previous bool) (*storage.ChargebackReport, error)
+	ResetChargeback() (*accounting.Window, error)
+}
+
+// ConvertRequest 指定源目录或按 digest 固定的镜像引用,二者选一。
+// Priority 为 low、normal 或 high,省略时使用镜像上的优先级注解
+type ConvertRequest struct {
+	Source   string `json:"source,omitempty"`
+	ImageID  string `json:"image_id,omitempty"`
+	ImageRef string `json:"image_ref,omitempty"`
+	Priority string `json:"priority,omitempty"`
+}
+
+// RelayoutRequest 按访问顺序重建镜像,Order 为按首次访问排序的文件路径,省略时使用已记录的顺序
+type RelayoutRequest struct {
+	ImageID string   `json:"image_id"`
+	Order   []string `json:"order,omitempty"`
+}
+
+// BindRequest 把已挂载的 EROFS 镜像只读绑定到 pod,Target 和 Propagation 可省略
+type BindRequest struct {
+	ImageID     string `json:"image_id"`
+	Target      string `json:"target,omitempty"`
+	Propagation string `json:"propagation,omitempty"`
+}
+
+// PushPlanRequest 指定节点上本地构建好的层 blob(tar 或压缩 tar)
+type PushPlanRequest struct {
+	LayerPath string `json:"layer_path"`
+}
+
+// PrefetchRequest 按 trace 预取已注册到 fscache 的镜像,Trace 为 prefetch.trace_dir 中的 trace ID。
+// Filter 为空时使用 prefetch.policy_file 中为镜像定义的过滤
+type PrefetchRequest struct {
+	ImageID string                  `json:"image_id"`
+	Trace   string                  `json:"trace"`
+	Filter  *fscache.PrefetchFilter `json:"filter,omitempty"`
+}
+
+// TraceMergeRequest 合并镜像多次运行的 trace,Traces 为 prefetch.trace_dir 中的 trace ID,
+// MinFrequency 为 chunk 保留所需的最小出现频率(0-1)
+type TraceMergeRequest struct {
+	ImageID      string   `json:"image_id"`
+	Traces       []string `json:"traces"`
+	MinFrequency float64  `json:"min_frequency,omitempty"`
+}
+
+// TraceProfileRequest 是 trace 配置中一个镜像的 trace,每项为一个 chunk 哈希,按预取顺序排列
+type TraceProfileRequest struct {
+	Chunks []string `json:"chunks"`
+}
+
+// BaselineRequest 指定要记录的去重统计基线名
+type BaselineRequest struct {
+	Name string `json:"name"`
+}
+
+// ImportRequest 指定节点上的 OCI layout 目录、OCI 归档或 docker save tar 包,Path 必须为绝对路径
+type ImportRequest struct {
+	Path string `json:"path"`
+}
+
+// PullRequest 指定要拉取并物化的镜像,供 dedup-cri 在转发 PullImage 前调用。
+// 每次拉取计入镜像仓库的热度,Priority 为 Pod 上的优先级提示,同时提升同一仓库排队中的转换任务
+type PullRequest struct {
+	ImageRef string `json:"image_ref"`
+	Priority string `json:"priority,omitempty"`
+}
+
+// UpgradeRequest 指定节点上运行的镜像 From 和将要升级到的 To,DryRun 为 true 时只计算差异不预取
+type UpgradeRequest struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	DryRun bool   `json:"dry_run"`
 }
 
 type Response struct {
 	Success bool        `json:"success"`
 	Data    interface{} `json:"data,omitempty"`
-	Error   string      `json:"error,omitempty"`
+	Error   *APIError   `json:"error,omitempty"`
 }
 
 func NewAPIServer(addr string, auditLogger *audit.AuditLogger, cfg *config.Config, configPath string) *APIServer {
 	api := &APIServer{
 		auditLogger: auditLogger,
-		config:      cfg,
+		configs:     config.NewSource(cfg),
 		configPath:  configPath,
 	}
 
-	mux := http.NewServeMux()
+	mux := newRouteMux()
 	mux.HandleFunc("/api/v1/audit/logs", api.handleAuditLogs)
 	mux.HandleFunc("/api/v1/audit/stats", api.handleAuditStats)
+	mux.HandleFunc("/api/v1/stats/history", api.handleStatsHistory)
+	mux.HandleFunc("/api/v1/stats/chargeback", api.handleChargeback)
+	mux.HandleFunc("/api/v1/stats/chargeback/reset", api.handleChargebackReset)
+	mux.HandleFunc("/api/v1/stats/baseline", api.handleBaseline)
+	mux.HandleFunc("/api/v1/stats/baseline/", api.handleBaseline)
 	mux.HandleFunc("/api/v1/config", api.handleConfig)
 	mux.HandleFunc("/api/v1/config/reload", api.handleConfigReload)
+	mux.HandleFunc("/api/v1/config/schema", api.handleConfigSchema)
 	mux.HandleFunc("/api/v1/health", api.handleHealth)
+	mux.HandleFunc("/metrics", api.handleMetrics)
+	if cfg.FaultInjection.Enabled {
+		mux.HandleFunc("/api/v1/debug/faults", api.handleFaults)
+	}
+	api.registerDebug(mux.ServeMux)
+	mux.HandleFunc("/api/v1/images/convert", api.handleConvert)
+	mux.HandleFunc("/api/v1/images/convert/", api.handleConvertJob)
+	mux.HandleFunc("/api/v1/images/relayout", api.handleRelayout)
+	mux.HandleFunc("/api/v1/push/plan", api.handlePushPlan)
+	mux.HandleFunc("/api/v1/images/pull", api.handlePull)
+	mux.HandleFunc("/api/v1/images/upgrade", api.handleUpgrade)
+	mux.HandleFunc("/api/v1/images/import", api.handleImport)
+	mux.HandleFunc("/api/v1/pods", api.handlePods)
+	mux.HandleFunc("/api/v1/pods/", api.handlePods)
+	mux.HandleFunc("/api/v1/startup", api.handleStartup)
+	mux.HandleFunc("/api/v1/startup/", api.handleStartup)
+	mux.HandleFunc("/api/v1/prefetch", api.handlePrefetch)
+	mux.HandleFunc("/api/v1/prefetch/merge", api.handleTraceMerge)
+	mux.HandleFunc("/api/v1/prefetch/profiles", api.handleTraceProfiles)
+	mux.HandleFunc("/api/v1/prefetch/profiles/", api.handleTraceProfiles)
+	mux.HandleFunc("/api/v1/gc/volumes", api.handleVolumeGC)
+	mux.HandleFunc("/api/v1/cache/negative", api.handleNegativeCache)
+	mux.HandleFunc("/api/v1/backends", api.handleBackends)
+	mux.HandleFunc("/api/v1/usage", api.handleUsage)
+	mux.HandleFunc("/api/v1/mounts", api.handleMounts)
+	mux.HandleFunc("/api/v1/mounts/", api.handleMounts)
+	mux.HandleFunc("/api/v1/mounts/leaked", api.handleLeakedMounts)
+	mux.HandleFunc("/api/v1/snapshots/frozen", api.handleFrozenSnapshots)
+	mux.HandleFunc("/api/v1/snapshots/freeze", api.handleSnapshotFreeze)
+	mux.HandleFunc("/api/v1/snapshots/thaw", api.handleSnapshotThaw)
+	mux.HandleFunc("/api/v1/webhooks/registry", api.handleRegistryWebhook)
+	mux.HandleFunc("/api/v1/jobs", api.handleJobs)
+	mux.HandleFunc("/api/v1/jobs/", api.handleJob)
+	mux.HandleFunc("/api/v1/retention", api.handleRetention)
+	mux.HandleFunc("/api/v1/warmup", api.handleWarmup)
+	mux.HandleFunc("/api/v1/maintenance", api.handleMaintenance)
+	mux.HandleFunc("/api/v1/durability", api.handleDurability)
+	mux.HandleFunc("/api/v1/openapi.json", api.handleOpenAPI)
+	mux.HandleFunc("/api/version", api.handleVersion)
 
+	api.routes = mux
 	api.server = &http.Server{
 		Addr:    addr,
-		Handler: mux,
+		Handler: api.withVersioning(withMiddleware(mux)),
 	}
 
 	return api
@@ -59,6 +207,30 @@ func (a *APIServer) Stop(ctx context.Context) error {
 	return a.server.Shutdown(ctx)
 }
 
+func (a *APIServer) SetConversionQueue(q *storage.ConversionQueue) {
+	a.conversions = q
+}
+
+func (a *APIServer) SetStore(store *storage.DedupStore) {
+	a.store = store
+}
+
+func (a *APIServer) SetUsageReporter(r UsageReporter) {
+	a.usage = r
+}
+
+func (a *APIServer) SetBindManager(b *erofs.BindManager) {
+	a.binds = b
+}
+
+func (a *APIServer) SetMetrics(m *metrics.Metrics) {
+	a.metrics = m
+}
+
+func (a *APIServer) SetAlerter(alerter *metrics.Alerter) {
+	a.alerter = alerter
+}
+
 func (a *APIServer) handleAuditLogs(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -66,7 +238,7 @@ func (a *APIServer) handleAuditLogs(w http.ResponseWriter, r *http.Request) {
 	case http.MethodGet:
 		a.getAuditLogs(w, r)
 	default:
-		a.respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		a.methodNotAllowed(w, r)
 	}
 }
 
@@ -85,17 +257,42 @@ func (a *APIServer) getAuditLogs(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// since 为相对当前时间的窗口,如 7d,覆盖 start_time
+	if sinceStr := r.URL.Query().Get("since"); sinceStr != "" {
+		since, err := parseWindow(sinceStr)
+		if err != nil || since <= 0 {
+			a.respondErrorDetails(w, http.StatusBadRequest, ErrCodeValidationFailed, "invalid since", map[string]string{"since": sinceStr})
+			return
+		}
+		startTime := time.Now().Add(-since)
+		filter.StartTime = &startTime
+	}
+
 	filter.Operation = r.URL.Query().Get("operation")
 	filter.Target = r.URL.Query().Get("target")
 	filter.User = r.URL.Query().Get("user")
 	filter.Result = r.URL.Query().Get("result")
+	filter.OperationID = r.URL.Query().Get("operation_id")
+	filter.Search = r.URL.Query().Get("q")
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		a.respondErrorDetails(w, http.StatusBadRequest, ErrCodeValidationFailed, "invalid format", map[string]string{"format": format})
+		return
+	}
+
+	if groupBy := r.URL.Query().Get("group_by"); groupBy != "" {
+		a.getAuditGroups(w, r, filter, groupBy, format)
+		return
+	}
 
 	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
 		if limit, err := strconv.Atoi(limitStr); err == nil && limit > 0 {
 			filter.Limit = limit
 		}
 	}
-	if filter.Limit == 0 {
+	// CSV 导出默认不分页
+	if filter.Limit == 0 && format != "csv" {
 		filter.Limit = 100
 	}
 
@@ -107,13 +304,53 @@ func (a *APIServer) getAuditLogs(w http.ResponseWriter, r *http.Request) {
 
 	logs, err := a.auditLogger.QueryLogs(r.Context(), filter)
 	if err != nil {
-		a.respondError(w, http.StatusInternalServerError, fmt.Sprintf("failed to query logs: %v", err))
+		a.respondErrorDetails(w, http.StatusInternalServerError, ErrCodeInternal, "failed to query logs", err.Error())
+		return
+	}
+
+	if format == "csv" {
+		writeCSVHeader(w, "audit-logs.csv")
+		if err := audit.WriteCSV(w, logs); err != nil {
+			log.L.WithError(err).Warn("failed to write audit log CSV")
+		}
 		return
 	}
 
 	a.respond(w, http.StatusOK, logs)
 }
 
+// getAuditGroups 按 operation、user、result、target_prefix 或 hour 聚合满足过滤条件的审计记录
+func (a *APIServer) getAuditGroups(w http.ResponseWriter, r *http.Request, filter *audit.QueryFilter, groupBy, format string) {
+	groups, err := a.auditLogger.Aggregate(r.Context(), filter, groupBy)
+	if errors.Is(err, audit.ErrUnsupportedGroupBy) {
+		a.respondErrorDetails(w, http.StatusBadRequest, ErrCodeValidationFailed, "invalid group_by", map[string]string{"group_by": groupBy})
+		return
+	}
+	if err != nil {
+		a.respondErrorDetails(w, http.StatusInternalServerError, ErrCodeInternal, "failed to aggregate logs", err.Error())
+		return
+	}
+
+	if format == "csv" {
+		writeCSVHeader(w, "audit-"+groupBy+".csv")
+		if err := audit.WriteGroupsCSV(w, groupBy, groups); err != nil {
+			log.L.WithError(err).Warn("failed to write audit group CSV")
+		}
+		return
+	}
+
+	a.respond(w, http.StatusOK, map[string]interface{}{
+		"group_by": groupBy,
+		"groups":   groups,
+	})
+}
+
+func writeCSVHeader(w http.ResponseWriter, filename string) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+}
+
 func (a *APIServer) handleAuditStats(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -121,13 +358,75 @@ func (a *APIServer) handleAuditStats(w http.ResponseWriter, r *http.Request) {
 	case http.MethodGet:
 		stats, err := a.auditLogger.GetStats(r.Context())
 		if err != nil {
-			a.respondError(w, http.StatusInternalServerError, fmt.Sprintf("failed to get stats: %v", err))
+			a.respondErrorDetails(w, http.StatusInternalServerError, ErrCodeInternal, "failed to get stats", err.Error())
 			return
 		}
 		a.respond(w, http.StatusOK, stats)
 	default:
-		a.respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		a.methodNotAllowed(w, r)
+	}
+}
+
+// maxHistoryPoints 是未指定 step 时每个序列返回的最大点数
+const maxHistoryPoints = 300
+
+// handleStatsHistory 返回 window 时间窗内降采样后的指标序列,step 省略时按窗口自动计算
+func (a *APIServer) handleStatsHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		a.methodNotAllowed(w, r)
+		return
+	}
+
+	window := 24 * time.Hour
+	if v := r.URL.Query().Get("window"); v != "" {
+		d, err := parseWindow(v)
+		if err != nil || d <= 0 {
+			a.respondErrorDetails(w, http.StatusBadRequest, ErrCodeValidationFailed, "invalid window", map[string]string{"window": v})
+			return
+		}
+		window = d
+	}
+
+	minStep := time.Duration(a.cfg().StatsHistory.Interval) * time.Second
+	step := window / maxHistoryPoints
+	if v := r.URL.Query().Get("step"); v != "" {
+		d, err := parseWindow(v)
+		if err != nil || d <= 0 {
+			a.respondErrorDetails(w, http.StatusBadRequest, ErrCodeValidationFailed, "invalid step", map[string]string{"step": v})
+			return
+		}
+		step = d
+	}
+	if step < minStep {
+		step = minStep
+	}
+	step = step.Truncate(time.Second)
+
+	points, err := a.auditLogger.StatsHistory(r.Context(), time.Now().Add(-window), step)
+	if err != nil {
+		a.respondErrorDetails(w, http.StatusInternalServerError, ErrCodeInternal, "failed to query stats history", err.Error())
+		return
+	}
+
+	a.respond(w, http.StatusOK, map[string]interface{}{
+		"window": window.String(),
+		"step":   step.String(),
+		"points": points,
+	})
+}
+
+// parseWindow 在 time.ParseDuration 基础上支持以天为单位,如 7d
+func parseWindow(v string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
 	}
+	return time.ParseDuration(v)
 }
 
 func (a *APIServer) handleConfig(w http.ResponseWriter, r *http.Request) {
@@ -135,33 +434,40 @@ func (a *APIServer) handleConfig(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case http.MethodGet:
-		a.respond(w, http.StatusOK, a.config)
+		a.respond(w, http.StatusOK, a.cfg().Redacted())
 	case http.MethodPut:
+		if !a.requireAdmin(w, r) {
+			return
+		}
 		a.updateConfig(w, r)
 	default:
-		a.respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		a.methodNotAllowed(w, r)
 	}
 }
 
 func (a *APIServer) updateConfig(w http.ResponseWriter, r *http.Request) {
 	var newConfig config.Config
-	if err := json.NewDecoder(r.Body).Decode(&newConfig); err != nil {
-		a.respondError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON: %v", err))
+	if !a.decodeJSON(w, r, &newConfig) {
 		return
 	}
+	newConfig.RestoreSecrets(a.cfg())
 
 	if err := newConfig.Validate(); err != nil {
-		a.respondError(w, http.StatusBadRequest, fmt.Sprintf("invalid config: %v", err))
+		a.respondErrorDetails(w, http.StatusBadRequest, ErrCodeValidationFailed, "invalid config", err.Error())
 		return
 	}
 
-	if err := newConfig.Save(a.configPath); err != nil {
-		a.respondError(w, http.StatusInternalServerError, fmt.Sprintf("failed to save config: %v", err))
+	var err error
+	if a.watcher != nil {
+		err = a.watcher.UpdateConfig(&newConfig)
+	} else if err = newConfig.Save(a.configPath); err == nil {
+		a.configs.Update(&newConfig)
+	}
+	if err != nil {
+		a.respondErrorDetails(w, http.StatusInternalServerError, ErrCodeInternal, "failed to save config", err.Error())
 		return
 	}
 
-	a.config = &newConfig
-
 	log.L.Info("configuration updated via API")
 
 	ctx := audit.StartAudit(r.Context(), "config_update", "config", "api", os.Getpid(), newConfig)
@@ -169,22 +475,37 @@ func (a *APIServer) updateConfig(w http.ResponseWriter, r *http.Request) {
 
 	a.respond(w, http.StatusOK, map[string]interface{}{
 		"message": "configuration updated successfully",
-		"config":  a.config,
+		"config":  newConfig.Redacted(),
 	})
 }
 
+// handleConfigSchema 返回配置的 schema,包含每个字段的默认值和取值范围
+func (a *APIServer) handleConfigSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		a.methodNotAllowed(w, r)
+		return
+	}
+
+	a.respond(w, http.StatusOK, config.Schema(a.cfg().Root))
+}
+
 func (a *APIServer) handleConfigReload(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	switch r.Method {
 	case http.MethodPost:
+		if !a.requireAdmin(w, r) {
+			return
+		}
 		newConfig, err := config.LoadConfig(a.configPath)
 		if err != nil {
-			a.respondError(w, http.StatusInternalServerError, fmt.Sprintf("failed to reload config: %v", err))
+			a.respondErrorDetails(w, http.StatusInternalServerError, ErrCodeInternal, "failed to reload config", err.Error())
 			return
 		}
 
-		a.config = newConfig
+		a.configs.Update(newConfig)
 		log.L.Info("configuration reloaded from file")
 
 		ctx := audit.StartAudit(r.Context(), "config_reload", "config", "api", os.Getpid(), nil)
@@ -192,50 +513,931 @@ func (a *APIServer) handleConfigReload(w http.ResponseWriter, r *http.Request) {
 
 		a.respond(w, http.StatusOK, map[string]interface{}{
 			"message": "configuration reloaded successfully",
-			"config":  a.config,
+			"config":  newConfig.Redacted(),
 		})
 	default:
-		a.respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		a.methodNotAllowed(w, r)
 	}
 }
 
-func (a *APIServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+func (a *APIServer) handleConvert(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	if r.Method != http.MethodGet {
-		a.respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+	if a.conversions == nil {
+		a.respondError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "image conversion not available")
 		return
 	}
 
-	health := map[string]interface{}{
-		"status":    "healthy",
-		"timestamp": time.Now(),
-		"version":   "1.0.0",
+	switch r.Method {
+	case http.MethodGet:
+		a.respond(w, http.StatusOK, a.conversions.ListJobs())
+	case http.MethodPost:
+		a.submitConversion(w, r)
+	default:
+		a.methodNotAllowed(w, r)
 	}
+}
 
-	a.respond(w, http.StatusOK, health)
+func (a *APIServer) submitConversion(w http.ResponseWriter, r *http.Request) {
+	var req ConvertRequest
+	if !a.decodeJSON(w, r, &req) {
+		return
+	}
+
+	if (req.Source == "") == (req.ImageRef == "") {
+		a.respondErrorDetails(w, http.StatusBadRequest, ErrCodeValidationFailed, "exactly one of source or image_ref is required", map[string][]string{
+			"fields": {"source", "image_ref"},
+		})
+		return
+	}
+	priority, err := storage.ParseConversionPriority(req.Priority)
+	if err != nil {
+		a.respondErrorDetails(w, http.StatusBadRequest, ErrCodeValidationFailed, err.Error(), map[string][]string{
+			"fields": {"priority"},
+		})
+		return
+	}
+
+	var job *storage.ConversionJob
+	if req.ImageRef != "" {
+		job, err = a.conversions.SubmitImageRef(req.ImageRef)
+	} else {
+		job, err = a.conversions.SubmitDirectory(req.Source, req.ImageID)
+	}
+	if err != nil {
+		a.submitError(w, "failed to submit conversion", err)
+		return
+	}
+	if req.Priority != "" {
+		job, _ = a.conversions.SetPriority(job.ID, priority)
+	}
+
+	ctx := audit.StartAudit(r.Context(), "image_convert", job.ImageID, "api", os.Getpid(), req)
+	audit.FinishAudit(ctx, a.auditLogger, "success", nil)
+
+	a.respond(w, http.StatusAccepted, job)
 }
 
-func (a *APIServer) respond(w http.ResponseWriter, status int, data interface{}) {
-	response := Response{
-		Success: status < 400,
-		Data:    data,
+// handleRelayout 提交重排任务,任务状态通过 /api/v1/images/convert/{id} 查询
+func (a *APIServer) handleRelayout(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if a.conversions == nil {
+		a.respondError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "image relayout not available")
+		return
+	}
+	if r.Method != http.MethodPost {
+		a.methodNotAllowed(w, r)
+		return
 	}
 
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(response)
+	var req RelayoutRequest
+	if !a.decodeJSON(w, r, &req) {
+		return
+	}
+	if req.ImageID == "" {
+		a.respondErrorDetails(w, http.StatusBadRequest, ErrCodeValidationFailed, "image_id is required", map[string][]string{
+			"fields": {"image_id"},
+		})
+		return
+	}
+
+	job, err := a.conversions.SubmitRelayout(req.ImageID, req.Order)
+	if err != nil {
+		a.submitError(w, "failed to submit relayout", err)
+		return
+	}
+
+	ctx := audit.StartAudit(r.Context(), "image_relayout", req.ImageID, "api", os.Getpid(), map[string]int{"order": len(req.Order)})
+	audit.FinishAudit(ctx, a.auditLogger, "success", nil)
+
+	a.respond(w, http.StatusAccepted, job)
 }
 
-func (a *APIServer) respondError(w http.ResponseWriter, status int, message string) {
-	response := Response{
-		Success: false,
-		Error:   message,
+// handleImport 提交从本地目录或 tar 包导入镜像的任务,任务状态通过 /api/v1/images/convert/{id} 查询
+func (a *APIServer) handleImport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if a.conversions == nil {
+		a.respondError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "image import not available")
+		return
+	}
+	if r.Method != http.MethodPost {
+		a.methodNotAllowed(w, r)
+		return
 	}
 
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(response)
+	var req ImportRequest
+	if !a.decodeJSON(w, r, &req) {
+		return
+	}
+	if !filepath.IsAbs(req.Path) {
+		a.respondErrorDetails(w, http.StatusBadRequest, ErrCodeValidationFailed, "path must be an absolute path", map[string][]string{
+			"fields": {"path"},
+		})
+		return
+	}
+
+	job, err := a.conversions.SubmitImport(req.Path)
+	if err != nil {
+		a.submitError(w, "failed to submit import", err)
+		return
+	}
+
+	ctx := audit.StartAudit(r.Context(), "image_import", req.Path, "api", os.Getpid(), req)
+	audit.FinishAudit(ctx, a.auditLogger, "success", nil)
+
+	a.respond(w, http.StatusAccepted, job)
 }
 
-func (a *APIServer) GetConfig() *config.Config {
-	return a.config
+// submitError 回应提交转换任务的失败:存储磁盘将满时返回 507,其他错误视为请求无效
+func (a *APIServer) submitError(w http.ResponseWriter, message string, err error) {
+	if errors.Is(err, storage.ErrDiskFull) {
+		a.respondErrorDetails(w, http.StatusInsufficientStorage, ErrCodeUnavailable, message, err.Error())
+		return
+	}
+	if errors.Is(err, storage.ErrSourceNotAllowed) {
+		a.respondErrorDetails(w, http.StatusForbidden, ErrCodeForbidden, message, err.Error())
+		return
+	}
+	a.respondErrorDetails(w, http.StatusBadRequest, ErrCodeValidationFailed, message, err.Error())
+}
+
+// handlePushPlan 报告本地层中哪些 chunk 区间已存在于镜像仓库或共享存储,供构建工具跳过上传
+func (a *APIServer) handlePushPlan(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		a.methodNotAllowed(w, r)
+		return
+	}
+	if a.store == nil {
+		a.respondError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "push planning not available")
+		return
+	}
+
+	var req PushPlanRequest
+	if !a.decodeJSON(w, r, &req) {
+		return
+	}
+	if !filepath.IsAbs(req.LayerPath) {
+		a.respondErrorDetails(w, http.StatusBadRequest, ErrCodeValidationFailed, "layer_path must be an absolute path", map[string][]string{
+			"fields": {"layer_path"},
+		})
+		return
+	}
+
+	plan, err := a.store.PlanPush(r.Context(), req.LayerPath)
+	if err != nil {
+		if errors.Is(err, storage.ErrSourceNotAllowed) {
+			a.respondErrorDetails(w, http.StatusForbidden, ErrCodeForbidden, "layer_path is not allowed", err.Error())
+			return
+		}
+		if errors.Is(err, os.ErrNotExist) {
+			a.respondErrorDetails(w, http.StatusNotFound, ErrCodeNotFound, "layer not found", err.Error())
+			return
+		}
+		a.respondErrorDetails(w, http.StatusInternalServerError, ErrCodeInternal, "failed to plan push", err.Error())
+		return
+	}
+
+	a.respond(w, http.StatusOK, plan)
+}
+
+// handlePull 同步拉取镜像,只下载本地缺少的层和 chunk
+func (a *APIServer) handlePull(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		a.methodNotAllowed(w, r)
+		return
+	}
+	if !a.requireAdmin(w, r) {
+		return
+	}
+	if a.store == nil {
+		a.respondError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "image pull not available")
+		return
+	}
+
+	var req PullRequest
+	if !a.decodeJSON(w, r, &req) {
+		return
+	}
+	if req.ImageRef == "" {
+		a.respondErrorDetails(w, http.StatusBadRequest, ErrCodeValidationFailed, "image_ref is required", map[string][]string{
+			"fields": {"image_ref"},
+		})
+		return
+	}
+	priority, err := storage.ParseConversionPriority(req.Priority)
+	if err != nil {
+		a.respondErrorDetails(w, http.StatusBadRequest, ErrCodeValidationFailed, err.Error(), map[string][]string{
+			"fields": {"priority"},
+		})
+		return
+	}
+	if a.conversions != nil {
+		a.conversions.NotePull(req.ImageRef, priority)
+	}
+
+	result, err := a.store.PullImage(r.Context(), req.ImageRef)
+	if err != nil {
+		a.respondErrorDetails(w, http.StatusBadGateway, ErrCodeInternal, "failed to pull image", err.Error())
+		return
+	}
+
+	a.respond(w, http.StatusOK, result)
+}
+
+// handleUpgrade 计算两个镜像 chunk 清单的差异,并只预取升级目标中新增的 chunk
+func (a *APIServer) handleUpgrade(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		a.methodNotAllowed(w, r)
+		return
+	}
+	if a.store == nil {
+		a.respondError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "image upgrade not available")
+		return
+	}
+
+	var req UpgradeRequest
+	if !a.decodeJSON(w, r, &req) {
+		return
+	}
+	var missing []string
+	if req.From == "" {
+		missing = append(missing, "from")
+	}
+	if req.To == "" {
+		missing = append(missing, "to")
+	}
+	if len(missing) > 0 {
+		a.respondErrorDetails(w, http.StatusBadRequest, ErrCodeValidationFailed, "from and to are required", map[string][]string{
+			"fields": missing,
+		})
+		return
+	}
+
+	if req.DryRun {
+		plan, err := a.store.PlanUpgrade(r.Context(), req.From, req.To)
+		if err != nil {
+			a.respondErrorDetails(w, http.StatusBadGateway, ErrCodeInternal, "failed to plan upgrade", err.Error())
+			return
+		}
+		a.respond(w, http.StatusOK, plan)
+		return
+	}
+
+	ctx := audit.StartAudit(r.Context(), "image_upgrade", req.To, "api", os.Getpid(), req)
+	plan, err := a.store.UpgradeImage(ctx, req.From, req.To)
+	if err != nil {
+		audit.FinishAudit(ctx, a.auditLogger, "failure", err)
+		a.respondErrorDetails(w, http.StatusBadGateway, ErrCodeInternal, "failed to prefetch upgrade", err.Error())
+		return
+	}
+	audit.FinishAudit(ctx, a.auditLogger, "success", nil)
+
+	a.respond(w, http.StatusOK, plan)
+}
+
+func (a *APIServer) handleConvertJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		a.methodNotAllowed(w, r)
+		return
+	}
+
+	if a.conversions == nil {
+		a.respondError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "image conversion not available")
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/images/convert/")
+	job, ok := a.conversions.GetJob(id)
+	if !ok {
+		a.respondErrorDetails(w, http.StatusNotFound, ErrCodeNotFound, "conversion job not found", map[string]string{"id": id})
+		return
+	}
+
+	a.respond(w, http.StatusOK, job)
+}
+
+// handlePods 管理 pod 的绑定挂载:
+// GET /api/v1/pods 列出全部绑定,POST /api/v1/pods/{pod}/binds 创建绑定,DELETE /api/v1/pods/{pod} 释放 pod 的全部绑定
+func (a *APIServer) handlePods(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if a.binds == nil {
+		a.respondError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "bind mounts not available")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/pods"), "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "" && r.Method == http.MethodGet:
+		a.respond(w, http.StatusOK, a.binds.List())
+	case len(parts) == 2 && parts[1] == "binds" && r.Method == http.MethodPost:
+		if !a.requireAdmin(w, r) {
+			return
+		}
+		a.bindPod(w, r, parts[0])
+	case len(parts) == 1 && parts[0] != "" && r.Method == http.MethodDelete:
+		if !a.requireAdmin(w, r) {
+			return
+		}
+		if err := a.binds.UnbindPod(parts[0]); err != nil {
+			a.respondErrorDetails(w, http.StatusInternalServerError, ErrCodeInternal, "failed to release bind mounts", err.Error())
+			return
+		}
+		ctx := audit.StartAudit(r.Context(), "pod_unbind", parts[0], "api", os.Getpid(), nil)
+		audit.FinishAudit(ctx, a.auditLogger, "success", nil)
+		a.respond(w, http.StatusOK, map[string]string{"pod_id": parts[0]})
+	case len(parts) <= 2:
+		a.methodNotAllowed(w, r)
+	default:
+		a.respondError(w, http.StatusNotFound, ErrCodeNotFound, "not found")
+	}
+}
+
+func (a *APIServer) bindPod(w http.ResponseWriter, r *http.Request, podID string) {
+	var req BindRequest
+	if !a.decodeJSON(w, r, &req) {
+		return
+	}
+	if req.ImageID == "" {
+		a.respondErrorDetails(w, http.StatusBadRequest, ErrCodeValidationFailed, "image_id is required", map[string][]string{
+			"fields": {"image_id"},
+		})
+		return
+	}
+
+	bind, err := a.binds.Bind(podID, req.ImageID, req.Target, req.Propagation)
+	if err != nil {
+		a.respondErrorDetails(w, http.StatusBadRequest, ErrCodeValidationFailed, "failed to create bind mount", err.Error())
+		return
+	}
+
+	ctx := audit.StartAudit(r.Context(), "pod_bind", podID, "api", os.Getpid(), req)
+	audit.FinishAudit(ctx, a.auditLogger, "success", nil)
+
+	a.respond(w, http.StatusCreated, bind)
+}
+
+// handleStartup 列出冷启动追踪,或以 POST /api/v1/startup/{key} 标记容器已启动
+func (a *APIServer) handleStartup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if a.store == nil {
+		a.respondError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "startup tracing not available")
+		return
+	}
+
+	key := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/startup"), "/")
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		a.respond(w, http.StatusOK, a.store.StartupTraces())
+	case key != "" && r.Method == http.MethodPost:
+		trace, err := a.store.MarkContainerStarted(key)
+		if err != nil {
+			a.respondErrorDetails(w, http.StatusNotFound, ErrCodeNotFound, "no startup trace", err.Error())
+			return
+		}
+		a.respond(w, http.StatusOK, trace)
+	default:
+		a.methodNotAllowed(w, r)
+	}
+}
+
+// handlePrefetch 列出进行中的预取任务,或按 trace 文件启动预取
+func (a *APIServer) handlePrefetch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if a.store == nil {
+		a.respondError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "prefetch not available")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		a.respond(w, http.StatusOK, a.store.PrefetchStatuses())
+	case http.MethodPost:
+		a.startPrefetch(w, r)
+	default:
+		a.methodNotAllowed(w, r)
+	}
+}
+
+func (a *APIServer) startPrefetch(w http.ResponseWriter, r *http.Request) {
+	var req PrefetchRequest
+	if !a.decodeJSON(w, r, &req) {
+		return
+	}
+	if req.ImageID == "" || req.Trace == "" {
+		a.respondErrorDetails(w, http.StatusBadRequest, ErrCodeValidationFailed, "image_id and trace are required", map[string][]string{
+			"fields": {"image_id", "trace"},
+		})
+		return
+	}
+	traceFile, err := a.store.TracePath(req.Trace)
+	if err != nil {
+		a.respondErrorDetails(w, http.StatusBadRequest, ErrCodeValidationFailed, "invalid trace", err.Error())
+		return
+	}
+	if req.Filter != nil {
+		if err := req.Filter.Validate(); err != nil {
+			a.respondErrorDetails(w, http.StatusBadRequest, ErrCodeValidationFailed, "invalid prefetch filter", err.Error())
+			return
+		}
+	}
+
+	// 有任务管理器时预取作为任务执行,进程重启后继续;否则预取在请求结束后继续进行
+	ctx := audit.StartAudit(r.Context(), "prefetch_start", req.ImageID, "api", os.Getpid(), req)
+	result := map[string]string{"image_id": req.ImageID}
+	if a.jobManager() != nil {
+		var job *jobs.Job
+		if job, err = a.store.SubmitPrefetch(req.ImageID, traceFile, req.Filter); err == nil {
+			result["job_id"] = job.ID
+		}
+	} else {
+		err = a.store.StartPrefetchWithFilter(context.WithoutCancel(r.Context()), req.ImageID, traceFile, req.Filter)
+	}
+	if err != nil {
+		audit.FinishAudit(ctx, a.auditLogger, "failure", err)
+		a.respondErrorDetails(w, http.StatusBadRequest, ErrCodeValidationFailed, "failed to start prefetch", err.Error())
+		return
+	}
+	audit.FinishAudit(ctx, a.auditLogger, "success", nil)
+
+	a.respond(w, http.StatusAccepted, result)
+}
+
+// handleTraceMerge 把多次运行的 trace 合并为预取计划
+func (a *APIServer) handleTraceMerge(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		a.methodNotAllowed(w, r)
+		return
+	}
+	if a.store == nil {
+		a.respondError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "prefetch not available")
+		return
+	}
+
+	var req TraceMergeRequest
+	if !a.decodeJSON(w, r, &req) {
+		return
+	}
+	if req.ImageID == "" || len(req.Traces) == 0 || req.MinFrequency < 0 || req.MinFrequency > 1 {
+		a.respondErrorDetails(w, http.StatusBadRequest, ErrCodeValidationFailed, "image_id, traces and a min_frequency between 0 and 1 are required", map[string][]string{
+			"fields": {"image_id", "traces", "min_frequency"},
+		})
+		return
+	}
+
+	ctx := audit.StartAudit(r.Context(), "trace_merge", req.ImageID, "api", os.Getpid(), req)
+	plan, err := a.store.MergeTraces(req.ImageID, req.Traces, req.MinFrequency)
+	if err != nil {
+		audit.FinishAudit(ctx, a.auditLogger, "failure", err)
+		a.respondErrorDetails(w, http.StatusBadRequest, ErrCodeValidationFailed, "failed to merge traces", err.Error())
+		return
+	}
+	audit.FinishAudit(ctx, a.auditLogger, "success", nil)
+
+	a.respond(w, http.StatusOK, plan)
+}
+
+// handleTraceProfiles 管理按名称区分的 trace 配置:GET 列出配置,PUT/DELETE {配置}/{镜像}
+// 保存或删除镜像的 trace,DELETE {配置} 删除整个配置。快照通过标签选择配置
+func (a *APIServer) handleTraceProfiles(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if a.store == nil {
+		a.respondError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "trace profiles not available")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/prefetch/profiles"), "/"), "/")
+	profile, imageID := parts[0], ""
+	if len(parts) == 2 {
+		imageID = parts[1]
+	} else if len(parts) > 2 {
+		a.respondError(w, http.StatusNotFound, ErrCodeNotFound, "not found")
+		return
+	}
+
+	switch {
+	case profile == "" && r.Method == http.MethodGet:
+		profiles, err := a.store.ListTraceProfiles()
+		if err != nil {
+			a.respondErrorDetails(w, http.StatusInternalServerError, ErrCodeInternal, "failed to list trace profiles", err.Error())
+			return
+		}
+		a.respond(w, http.StatusOK, profiles)
+	case profile != "" && imageID != "" && r.Method == http.MethodPut:
+		var req TraceProfileRequest
+		if !a.decodeJSON(w, r, &req) {
+			return
+		}
+		ctx := audit.StartAudit(r.Context(), "trace_profile_put", profile+"/"+imageID, "api", os.Getpid(), map[string]int{"chunks": len(req.Chunks)})
+		if err := a.store.PutTraceProfile(profile, imageID, req.Chunks); err != nil {
+			audit.FinishAudit(ctx, a.auditLogger, "failure", err)
+			a.respondErrorDetails(w, http.StatusBadRequest, ErrCodeValidationFailed, "failed to save trace", err.Error())
+			return
+		}
+		audit.FinishAudit(ctx, a.auditLogger, "success", nil)
+		a.respond(w, http.StatusOK, map[string]interface{}{"profile": profile, "image_id": imageID, "chunks": len(req.Chunks)})
+	case profile != "" && r.Method == http.MethodDelete:
+		ctx := audit.StartAudit(r.Context(), "trace_profile_delete", strings.TrimSuffix(profile+"/"+imageID, "/"), "api", os.Getpid(), nil)
+		if err := a.store.DeleteTraceProfile(profile, imageID); err != nil {
+			audit.FinishAudit(ctx, a.auditLogger, "failure", err)
+			if errors.Is(err, storage.ErrTraceProfileNotFound) {
+				a.respondErrorDetails(w, http.StatusNotFound, ErrCodeNotFound, "trace profile not found", err.Error())
+				return
+			}
+			a.respondErrorDetails(w, http.StatusBadRequest, ErrCodeValidationFailed, "failed to delete trace profile", err.Error())
+			return
+		}
+		audit.FinishAudit(ctx, a.auditLogger, "success", nil)
+		a.respond(w, http.StatusOK, map[string]string{"profile": profile, "image_id": imageID})
+	default:
+		a.methodNotAllowed(w, r)
+	}
+}
+
+// handleVolumeGC 立即清理不再对应镜像或快照的 fscache 卷,不必等待周期清理
+func (a *APIServer) handleVolumeGC(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		a.methodNotAllowed(w, r)
+		return
+	}
+	if a.store == nil {
+		a.respondError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "volume gc not available")
+		return
+	}
+
+	ctx := audit.StartAudit(r.Context(), "volume_gc", "fscache", "api", os.Getpid(), nil)
+	// async=true 时作为任务在后台清理,通过 /api/v1/jobs/{id} 查询结果
+	if r.URL.Query().Get("async") == "true" {
+		job, err := a.store.SubmitVolumeGC()
+		if err != nil {
+			audit.FinishAudit(ctx, a.auditLogger, "failure", err)
+			a.respondErrorDetails(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "failed to submit volume gc", err.Error())
+			return
+		}
+		audit.FinishAudit(ctx, a.auditLogger, "success", nil)
+		a.respond(w, http.StatusAccepted, job)
+		return
+	}
+	removed, err := a.store.CleanupFscacheVolumes(ctx)
+	if err != nil {
+		audit.FinishAudit(ctx, a.auditLogger, "failure", err)
+		a.respondErrorDetails(w, http.StatusInternalServerError, ErrCodeInternal, "failed to clean up volumes", err.Error())
+		return
+	}
+	audit.FinishAudit(ctx, a.auditLogger, "success", nil)
+
+	a.respond(w, http.StatusOK, map[string]int{"removed": removed})
+}
+
+// handleNegativeCache 返回镜像仓库负查找缓存的条目数和命中统计
+func (a *APIServer) handleNegativeCache(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		a.methodNotAllowed(w, r)
+		return
+	}
+	if a.store == nil {
+		a.respondError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "negative lookup cache not available")
+		return
+	}
+	stats := a.store.NegativeCacheStats()
+	if stats == nil {
+		a.respondError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "negative lookup cache not available")
+		return
+	}
+
+	a.respond(w, http.StatusOK, stats)
+}
+
+// handleUsage 按 chunk 引用计算镜像和命名空间的独占与共享空间,回答删除某个镜像实际能释放多少空间。
+// namespace 参数只返回该命名空间的条目
+func (a *APIServer) handleUsage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		a.methodNotAllowed(w, r)
+		return
+	}
+	if a.usage == nil {
+		a.respondError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "usage not available")
+		return
+	}
+
+	report, err := a.usage.DedupUsage(r.Context())
+	if err != nil {
+		a.respondErrorDetails(w, http.StatusInternalServerError, ErrCodeInternal, "failed to compute usage", err.Error())
+		return
+	}
+	if ns := r.URL.Query().Get("namespace"); ns != "" {
+		report.Images = filterUsage(report.Images, func(e storage.UsageEntry) bool { return e.Namespace == ns })
+		report.Namespaces = filterUsage(report.Namespaces, func(e storage.UsageEntry) bool { return e.Name == ns })
+	}
+	a.respond(w, http.StatusOK, report)
+}
+
+// handleChargeback 返回按镜像和命名空间的流量分摊报告。window=previous 返回上一个已关闭的窗口,
+// namespace 参数只返回该命名空间的条目
+func (a *APIServer) handleChargeback(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		a.methodNotAllowed(w, r)
+		return
+	}
+	if a.usage == nil {
+		a.respondError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "chargeback not available")
+		return
+	}
+
+	var previous bool
+	switch window := r.URL.Query().Get("window"); window {
+	case "", "current":
+	case "previous":
+		previous = true
+	default:
+		a.respondErrorDetails(w, http.StatusBadRequest, ErrCodeValidationFailed, "window must be current or previous", map[string]string{"window": window})
+		return
+	}
+
+	report, err := a.usage.Chargeback(r.Context(), previous)
+	if err != nil {
+		a.respondErrorDetails(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "failed to compute chargeback", err.Error())
+		return
+	}
+	if ns := r.URL.Query().Get("namespace"); ns != "" {
+		report.Images = filterChargeback(report.Images, func(e storage.ChargebackEntry) bool { return e.Namespace == ns })
+		report.Namespaces = filterChargeback(report.Namespaces, func(e storage.ChargebackEntry) bool { return e.Name == ns })
+	}
+	a.respond(w, http.StatusOK, report)
+}
+
+// handleChargebackReset 关闭进行中的统计窗口并开始新窗口,关闭的窗口可通过 window=previous 查询
+func (a *APIServer) handleChargebackReset(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		a.methodNotAllowed(w, r)
+		return
+	}
+	if a.usage == nil {
+		a.respondError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "chargeback not available")
+		return
+	}
+
+	ctx := audit.StartAudit(r.Context(), "chargeback_reset", "accounting", "api", os.Getpid(), nil)
+	window, err := a.usage.ResetChargeback()
+	if err != nil {
+		audit.FinishAudit(ctx, a.auditLogger, "failure", err)
+		a.respondErrorDetails(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "failed to reset chargeback window", err.Error())
+		return
+	}
+	audit.FinishAudit(ctx, a.auditLogger, "success", nil)
+
+	a.respond(w, http.StatusOK, map[string]interface{}{"start": window.Start, "end": window.End, "layers": len(window.Layers)})
+}
+
+// handleBaseline 列出和记录去重统计基线,/stats/baseline/{name} 返回当前统计相对基线的变化
+func (a *APIServer) handleBaseline(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if a.store == nil {
+		a.respondError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "dedup baselines not available")
+		return
+	}
+
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/stats/baseline"), "/")
+	if strings.Contains(name, "/") {
+		a.respondError(w, http.StatusNotFound, ErrCodeNotFound, "not found")
+		return
+	}
+
+	switch {
+	case name == "" && r.Method == http.MethodGet:
+		baselines, err := a.store.ListBaselines()
+		if err != nil {
+			a.respondErrorDetails(w, http.StatusInternalServerError, ErrCodeInternal, "failed to list baselines", err.Error())
+			return
+		}
+		a.respond(w, http.StatusOK, baselines)
+	case name == "" && r.Method == http.MethodPost:
+		var req BaselineRequest
+		if !a.decodeJSON(w, r, &req) {
+			return
+		}
+		if err := storage.ValidateBaselineName(req.Name); err != nil {
+			a.respondErrorDetails(w, http.StatusBadRequest, ErrCodeValidationFailed, err.Error(), map[string][]string{
+				"fields": {"name"},
+			})
+			return
+		}
+		ctx := audit.StartAudit(r.Context(), "stats_baseline", req.Name, "api", os.Getpid(), nil)
+		snap, err := a.store.RecordBaseline(req.Name)
+		if err != nil {
+			audit.FinishAudit(ctx, a.auditLogger, "failure", err)
+			a.respondErrorDetails(w, http.StatusInternalServerError, ErrCodeInternal, "failed to record baseline", err.Error())
+			return
+		}
+		audit.FinishAudit(ctx, a.auditLogger, "success", nil)
+		a.respond(w, http.StatusCreated, snap)
+	case name != "" && r.Method == http.MethodGet:
+		report, err := a.store.CompareBaseline(name)
+		if err != nil {
+			if errors.Is(err, storage.ErrBaselineNotFound) {
+				a.respondErrorDetails(w, http.StatusNotFound, ErrCodeNotFound, "baseline not found", map[string]string{"name": name})
+				return
+			}
+			a.respondErrorDetails(w, http.StatusInternalServerError, ErrCodeInternal, "failed to compare baseline", err.Error())
+			return
+		}
+		a.respond(w, http.StatusOK, report)
+	default:
+		a.methodNotAllowed(w, r)
+	}
+}
+
+func filterChargeback(entries []storage.ChargebackEntry, keep func(storage.ChargebackEntry) bool) []storage.ChargebackEntry {
+	filtered := []storage.ChargebackEntry{}
+	for _, e := range entries {
+		if keep(e) {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered
+}
+
+func filterUsage(entries []storage.UsageEntry, keep func(storage.UsageEntry) bool) []storage.UsageEntry {
+	filtered := []storage.UsageEntry{}
+	for _, e := range entries {
+		if keep(e) {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered
+}
+
+// BackendHealth 汇总各 chunk 存储后端的健康状态,任一后端不健康时 Status 为 degraded
+type BackendHealth struct {
+	Status   string                 `json:"status"`
+	Backends []metrics.BackendStats `json:"backends"`
+}
+
+// handleBackends 返回各 chunk 存储后端的延迟、错误率、命中率和健康状态,
+// 用于判断变慢来自本地磁盘还是远端数据源
+func (a *APIServer) handleBackends(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		a.methodNotAllowed(w, r)
+		return
+	}
+	if a.metrics == nil {
+		a.respondError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "metrics not available")
+		return
+	}
+
+	health := BackendHealth{Status: "healthy", Backends: a.metrics.Backends()}
+	for _, b := range health.Backends {
+		if !b.Healthy {
+			health.Status = "degraded"
+		}
+	}
+	a.respond(w, http.StatusOK, health)
+}
+
+// handleOpenAPI 返回管理 API 的 OpenAPI 3 描述,由 pkg/client 的类型生成
+func (a *APIServer) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		a.methodNotAllowed(w, r)
+		return
+	}
+
+	json.NewEncoder(w).Encode(client.OpenAPI(a.cfg().Root))
+}
+
+// handleMetrics 按 Accept 头返回 OpenMetrics 或 Prometheus 文本格式
+func (a *APIServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		a.methodNotAllowed(w, r)
+		return
+	}
+
+	if a.metrics == nil {
+		w.Header().Set("Content-Type", "application/json")
+		a.respondError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "metrics not available")
+		return
+	}
+
+	snapshot := a.metrics.GetSnapshot()
+	if strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text") {
+		w.Header().Set("Content-Type", metrics.OpenMetricsContentType)
+		fmt.Fprint(w, snapshot.OpenMetrics(nil))
+		return
+	}
+
+	w.Header().Set("Content-Type", metrics.TextContentType)
+	fmt.Fprint(w, snapshot.Text(nil))
+}
+
+// handleFaults 查看和切换故障注入点,只在配置启用故障注入时注册
+func (a *APIServer) handleFaults(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		a.respond(w, http.StatusOK, faultinject.List())
+	case http.MethodPut:
+		var fault faultinject.Fault
+		if !a.decodeJSON(w, r, &fault) {
+			return
+		}
+		if err := faultinject.Set(fault); err != nil {
+			a.respondError(w, http.StatusBadRequest, ErrCodeValidationFailed, err.Error())
+			return
+		}
+		a.respond(w, http.StatusOK, faultinject.List())
+	case http.MethodDelete:
+		if name := r.URL.Query().Get("name"); name != "" {
+			faultinject.Clear(name)
+		} else {
+			faultinject.ClearAll()
+		}
+		a.respond(w, http.StatusOK, faultinject.List())
+	default:
+		a.methodNotAllowed(w, r)
+	}
+}
+
+func (a *APIServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		a.methodNotAllowed(w, r)
+		return
+	}
+
+	health := map[string]interface{}{
+		"status":    "healthy",
+		"timestamp": time.Now(),
+		"version":   "1.0.0",
+	}
+
+	if a.alerter != nil {
+		if alerts := a.alerter.Firing(); len(alerts) > 0 {
+			health["status"] = "degraded"
+			health["alerts"] = alerts
+		}
+	}
+
+	a.respond(w, http.StatusOK, health)
+}
+
+func (a *APIServer) respond(w http.ResponseWriter, status int, data interface{}) {
+	response := Response{
+		Success: status < 400,
+		Data:    data,
+	}
+
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (a *APIServer) GetConfig() *config.Config {
+	return a.cfg()
+}
+
+// cfg 返回当前配置,返回的配置只读
+func (a *APIServer) cfg() *config.Config {
+	return a.configs.Get()
+}
+
+// SetConfigSource 与快照服务的其他组件共用配置源,API 的配置更新会通知源上的订阅者
+func (a *APIServer) SetConfigSource(source *config.Source) {
+	a.configs = source
+}
+
+// SetConfigWatcher 使用 watcher 的配置源,API 保存配置时经 watcher 写入文件,文件变更不会重复通知
+func (a *APIServer) SetConfigWatcher(watcher *config.ConfigWatcher) {
+	a.watcher = watcher
+	a.configs = watcher.Source()
 }
\ No newline at end of file
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// requireAdmin 校验修改配置、删除数据、拉取镜像等管理请求:设置了 api.token 时要求
+// Authorization: Bearer <api.token>,否则只接受来自本机回环地址的请求。
+// 未通过时写入 401/403 响应并返回 false
+func (a *APIServer) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if token := a.cfg().API.Token; token != "" {
+		if bearerMatches(r, token) {
+			return true
+		}
+		a.respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "invalid API credentials")
+		return false
+	}
+	if isLoopback(r.RemoteAddr) {
+		return true
+	}
+	a.respondError(w, http.StatusForbidden, ErrCodeForbidden, "admin endpoints only accept local requests unless api.token is set")
+	return false
+}
+
+// bearerMatches 以常量时间比较 Authorization: Bearer 头与 token
+func bearerMatches(r *http.Request, token string) bool {
+	auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(auth), []byte(token)) == 1
+}
+
+func isLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/audit"
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+)
+
+// TestConfigEndpointAuth 验证读取配置时屏蔽密钥,修改配置需要管理权限,且写回的占位符不会覆盖密钥
+func TestConfigEndpointAuth(t *testing.T) {
+	root := t.TempDir()
+	cfg := config.DefaultConfig(root)
+	cfg.Debug = config.DebugConfig{Enabled: true, Token: "t0ken"}
+	cfg.Webhook = config.WebhookConfig{Enabled: true, Secret: "hook"}
+	auditLogger, err := audit.NewAuditLogger(filepath.Join(root, "audit.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLogger.Close()
+	server := NewAPIServer(":0", auditLogger, cfg, filepath.Join(root, "config.json"))
+	handler := server.server.Handler
+
+	do := func(method, remoteAddr, token string, body []byte) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/api/v1/config", bytes.NewReader(body))
+		r.RemoteAddr = remoteAddr
+		if body != nil {
+			r.Header.Set("Content-Type", "application/json")
+		}
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do(http.MethodGet, "192.0.2.1:1234", "", nil)
+	var resp struct {
+		Data config.Config `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("get config: %d %s", w.Code, w.Body.String())
+	}
+	if resp.Data.Debug.Token != config.RedactedValue {
+		t.Errorf("debug.token should be redacted, got %q", resp.Data.Debug.Token)
+	}
+	if resp.Data.Webhook.Secret != config.RedactedValue {
+		t.Errorf("webhook.secret should be redacted, got %q", resp.Data.Webhook.Secret)
+	}
+
+	// 把读出的配置改掉 debug.token 并关闭 webhook 鉴权后从远程写回
+	update := resp.Data
+	update.Debug.Token = "stolen"
+	update.Webhook = config.WebhookConfig{Enabled: true, Insecure: true}
+	body, _ := json.Marshal(&update)
+	if w := do(http.MethodPut, "192.0.2.1:1234", "", body); w.Code != http.StatusForbidden {
+		t.Errorf("remote PUT without api.token: got %d", w.Code)
+	}
+	if got := server.cfg(); got.Debug.Token != "t0ken" || got.Webhook.Secret != "hook" || got.Webhook.Insecure {
+		t.Fatalf("secrets changed by remote request: %+v %+v", got.Debug, got.Webhook)
+	}
+
+	// 本机写回读出的配置,占位符保留原密钥
+	update.Debug.Token = config.RedactedValue
+	update.Webhook = resp.Data.Webhook
+	update.API.Token = "admin"
+	body, _ = json.Marshal(&update)
+	if w := do(http.MethodPut, "127.0.0.1:1234", "", body); w.Code != http.StatusOK {
+		t.Fatalf("local PUT: %d %s", w.Code, w.Body.String())
+	}
+	if got := server.cfg(); got.Debug.Token != "t0ken" || got.Webhook.Secret != "hook" || got.API.Token != "admin" {
+		t.Errorf("unexpected secrets after update: debug=%q webhook=%q api=%q", got.Debug.Token, got.Webhook.Secret, got.API.Token)
+	}
+
+	// 设置 api.token 后本机请求也需要令牌
+	if w := do(http.MethodPut, "127.0.0.1:1234", "", body); w.Code != http.StatusUnauthorized {
+		t.Errorf("local PUT without token: got %d", w.Code)
+	}
+	if w := do(http.MethodPut, "192.0.2.1:1234", "admin", body); w.Code != http.StatusOK {
+		t.Errorf("remote PUT with api.token: %d %s", w.Code, w.Body.String())
+	}
+	t.Logf("✓ 配置密钥已屏蔽,修改需要管理权限")
+}
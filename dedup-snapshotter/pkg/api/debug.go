@@ -0,0 +1,132 @@
+package api
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/metrics"
+	"time"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/storage"
+)
+
+// mutexWaitMetric 是所有 goroutine 在 sync.Mutex/RWMutex 上等待的累计时间
+const mutexWaitMetric = "/sync/mutex/wait/total:seconds"
+
+// RuntimeState 是进程运行时的概况
+type RuntimeState struct {
+	Goroutines           int     `json:"goroutines"`
+	GOMAXPROCS           int     `json:"gomaxprocs"`
+	HeapAllocBytes       uint64  `json:"heap_alloc_bytes"`
+	HeapObjects          uint64  `json:"heap_objects"`
+	NumGC                uint32  `json:"num_gc"`
+	LastGCPauseNs        uint64  `json:"last_gc_pause_ns"`
+	MutexWaitSeconds     float64 `json:"mutex_wait_seconds"`
+	MutexProfileFraction int     `json:"mutex_profile_fraction"`
+	BlockProfileRate     int     `json:"block_profile_rate"`
+}
+
+// DebugState 是 /api/v1/debug/state 的响应
+type DebugState struct {
+	Time    time.Time           `json:"time"`
+	Runtime RuntimeState        `json:"runtime"`
+	Store   *storage.DebugState `json:"store,omitempty"`
+}
+
+// registerDebug 在 debug.enabled 时注册诊断端点,所有端点都要求 debug.token。
+// 采样率在注册时设置,修改后需重启生效
+func (a *APIServer) registerDebug(mux *http.ServeMux) {
+	cfg := a.cfg().Debug
+	if !cfg.Enabled {
+		return
+	}
+	runtime.SetMutexProfileFraction(cfg.MutexProfileFraction)
+	runtime.SetBlockProfileRate(cfg.BlockProfileRate)
+
+	// pprof.Index 按 /debug/pprof/ 之后的路径选择 profile
+	profiles := http.StripPrefix("/api/v1", http.HandlerFunc(pprof.Index))
+	mux.Handle("/api/v1/debug/pprof/", a.debugAuth(profiles))
+	mux.Handle("/api/v1/debug/pprof/cmdline", a.debugAuth(http.HandlerFunc(pprof.Cmdline)))
+	mux.Handle("/api/v1/debug/pprof/profile", a.debugAuth(http.HandlerFunc(pprof.Profile)))
+	mux.Handle("/api/v1/debug/pprof/symbol", a.debugAuth(http.HandlerFunc(pprof.Symbol)))
+	mux.Handle("/api/v1/debug/pprof/trace", a.debugAuth(http.HandlerFunc(pprof.Trace)))
+	mux.Handle("/api/v1/debug/vars", a.debugAuth(expvar.Handler()))
+	mux.Handle("/api/v1/debug/goroutines", a.debugAuth(http.HandlerFunc(a.handleGoroutines)))
+	mux.Handle("/api/v1/debug/state", a.debugAuth(http.HandlerFunc(a.handleDebugState)))
+}
+
+// debugAuth 校验 Authorization: Bearer <debug.token>,每个请求读取当前配置中的 token
+func (a *APIServer) debugAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := a.cfg().Debug.Token
+		if token == "" || !bearerMatches(r, token) {
+			w.Header().Set("Content-Type", "application/json")
+			a.respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "invalid debug credentials")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleGoroutines 以文本返回所有 goroutine 的栈
+func (a *APIServer) handleGoroutines(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		a.methodNotAllowed(w, r)
+		return
+	}
+
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(buf)
+}
+
+// handleDebugState 返回运行时概况和存储状态:挂载表、进行中的任务、队列深度和锁等待时间
+func (a *APIServer) handleDebugState(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		a.methodNotAllowed(w, r)
+		return
+	}
+
+	debug := a.cfg().Debug
+	state := &DebugState{Time: time.Now(), Runtime: runtimeState(debug.MutexProfileFraction, debug.BlockProfileRate)}
+	if a.store != nil {
+		state.Store = a.store.DebugState()
+	}
+	a.respond(w, http.StatusOK, state)
+}
+
+func runtimeState(mutexFraction, blockRate int) RuntimeState {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	sample := []metrics.Sample{{Name: mutexWaitMetric}}
+	metrics.Read(sample)
+	var mutexWait float64
+	if sample[0].Value.Kind() == metrics.KindFloat64 {
+		mutexWait = sample[0].Value.Float64()
+	}
+
+	return RuntimeState{
+		Goroutines:           runtime.NumGoroutine(),
+		GOMAXPROCS:           runtime.GOMAXPROCS(0),
+		HeapAllocBytes:       ms.HeapAlloc,
+		HeapObjects:          ms.HeapObjects,
+		NumGC:                ms.NumGC,
+		LastGCPauseNs:        ms.PauseNs[(ms.NumGC+255)%256],
+		MutexWaitSeconds:     mutexWait,
+		MutexProfileFraction: mutexFraction,
+		BlockProfileRate:     blockRate,
+	}
+}
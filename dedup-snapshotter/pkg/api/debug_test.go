@@ -0,0 +1,56 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+)
+
+// TestDebugEndpoints 验证诊断端点需要令牌,且 pprof 和状态转储可用
+func TestDebugEndpoints(t *testing.T) {
+	cfg := config.DefaultConfig(t.TempDir())
+	cfg.Debug = config.DebugConfig{Enabled: true, Token: "t0ken"}
+	handler := NewAPIServer(":0", nil, cfg, "").server.Handler
+
+	get := func(path, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, path := range []string{"/api/v1/debug/state", "/api/v1/debug/pprof/", "/api/v1/debug/goroutines"} {
+		if w := get(path, ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s without token: got %d", path, w.Code)
+		}
+		if w := get(path, "wrong"); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s with wrong token: got %d", path, w.Code)
+		}
+	}
+
+	w := get("/api/v1/debug/state", "t0ken")
+	var resp struct {
+		Data DebugState `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("state: %d %s", w.Code, w.Body.String())
+	}
+	if resp.Data.Runtime.Goroutines == 0 {
+		t.Errorf("expected goroutine count in %+v", resp.Data.Runtime)
+	}
+
+	if w := get("/api/v1/debug/pprof/goroutine?debug=1", "t0ken"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "goroutine profile") {
+		t.Errorf("pprof goroutine: %d", w.Code)
+	}
+	if w := get("/api/v1/debug/goroutines", "t0ken"); !strings.Contains(w.Body.String(), "TestDebugEndpoints") {
+		t.Errorf("goroutine dump missing test frame")
+	}
+	t.Logf("✓ 诊断端点 %d 个 goroutine", resp.Data.Runtime.Goroutines)
+}
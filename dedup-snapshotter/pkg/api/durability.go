@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/audit"
+	"github.com/opencloudos/dedup-snapshotter/pkg/durability"
+)
+
+// handleDurability 返回 chunk 持久化层的配置和后端状态(GET),
+// 或提交补齐缺失副本和分片的后台任务(POST),通过 /api/v1/jobs/{id} 查询结果
+func (a *APIServer) handleDurability(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		a.methodNotAllowed(w, r)
+		return
+	}
+	var status *durability.Status
+	if a.store != nil {
+		status = a.store.DurabilityStatus()
+	}
+	if status == nil {
+		a.respondError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "durability tier not enabled")
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		a.respond(w, http.StatusOK, status)
+		return
+	}
+
+	ctx := audit.StartAudit(r.Context(), "durability_repair", status.Mode, "api", os.Getpid(), nil)
+	job, err := a.store.SubmitDurabilityRepair()
+	if err != nil {
+		audit.FinishAudit(ctx, a.auditLogger, "failure", err)
+		a.respondErrorDetails(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "failed to submit durability repair", err.Error())
+		return
+	}
+	audit.FinishAudit(ctx, a.auditLogger, "success", nil)
+	a.respond(w, http.StatusAccepted, job)
+}
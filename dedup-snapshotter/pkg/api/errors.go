@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// 错误码是 v1 API 的稳定约定,调用方应按 Code 而不是 Message 判断错误类型
+const (
+	ErrCodeInvalidRequest       = "invalid_request"
+	ErrCodeValidationFailed     = "validation_failed"
+	ErrCodeNotFound             = "not_found"
+	ErrCodeUnauthorized         = "unauthorized"
+	ErrCodeForbidden            = "forbidden"
+	ErrCodeMethodNotAllowed     = "method_not_allowed"
+	ErrCodePayloadTooLarge      = "payload_too_large"
+	ErrCodeUnsupportedMediaType = "unsupported_media_type"
+	ErrCodeUnavailable          = "unavailable"
+	ErrCodeInternal             = "internal_error"
+)
+
+// APIError 是所有失败响应中 error 字段的结构
+type APIError struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func (a *APIServer) respondError(w http.ResponseWriter, status int, code, message string) {
+	a.respondErrorDetails(w, status, code, message, nil)
+}
+
+func (a *APIServer) respondErrorDetails(w http.ResponseWriter, status int, code, message string, details interface{}) {
+	writeError(w, status, &APIError{Code: code, Message: message, Details: details})
+}
+
+func (a *APIServer) methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	a.respondErrorDetails(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "method not allowed", map[string]string{
+		"method": r.Method,
+	})
+}
+
+// decodeJSON 解码请求体并拒绝未知字段,失败时已写入错误响应并返回 false
+func (a *APIServer) decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			a.respondErrorDetails(w, http.StatusRequestEntityTooLarge, ErrCodePayloadTooLarge, "request body too large", map[string]int64{
+				"limit": tooLarge.Limit,
+			})
+			return false
+		}
+		a.respondErrorDetails(w, http.StatusBadRequest, ErrCodeInvalidRequest, "invalid JSON", err.Error())
+		return false
+	}
+	return true
+}
+
+// writeError 不依赖 APIServer,中间件在进入 handler 之前也使用它
+func writeError(w http.ResponseWriter, status int, apiErr *APIError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Response{
+		Success: false,
+		Error:   apiErr,
+	})
+}
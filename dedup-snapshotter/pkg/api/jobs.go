@@ -0,0 +1,96 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/audit"
+	"github.com/opencloudos/dedup-snapshotter/pkg/jobs"
+)
+
+// jobManager 返回存储的任务管理器,只读附着或未设置存储时为 nil
+func (a *APIServer) jobManager() *jobs.Manager {
+	if a.store == nil {
+		return nil
+	}
+	return a.store.Jobs()
+}
+
+// handleJobs 列出持久化的长时间任务,可按 type、state 过滤,limit 默认 100
+func (a *APIServer) handleJobs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		a.methodNotAllowed(w, r)
+		return
+	}
+	m := a.jobManager()
+	if m == nil {
+		a.respondError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "job manager not available")
+		return
+	}
+
+	filter := jobs.Filter{
+		Type:  r.URL.Query().Get("type"),
+		State: r.URL.Query().Get("state"),
+		Limit: 100,
+	}
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if limit, err := strconv.Atoi(limitStr); err == nil && limit > 0 {
+			filter.Limit = limit
+		}
+	}
+
+	list, err := m.List(filter)
+	if err != nil {
+		a.respondErrorDetails(w, http.StatusInternalServerError, ErrCodeInternal, "failed to list jobs", err.Error())
+		return
+	}
+	a.respond(w, http.StatusOK, list)
+}
+
+// handleJob 返回任务及其日志(GET),或取消排队中和运行中的任务(DELETE)
+func (a *APIServer) handleJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	m := a.jobManager()
+	if m == nil {
+		a.respondError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "job manager not available")
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/jobs/")
+
+	switch r.Method {
+	case http.MethodGet:
+		job, err := m.Get(id)
+		if errors.Is(err, jobs.ErrNotFound) {
+			a.respondErrorDetails(w, http.StatusNotFound, ErrCodeNotFound, "job not found", map[string]string{"id": id})
+			return
+		}
+		if err != nil {
+			a.respondErrorDetails(w, http.StatusInternalServerError, ErrCodeInternal, "failed to read job", err.Error())
+			return
+		}
+		a.respond(w, http.StatusOK, job)
+	case http.MethodDelete:
+		ctx := audit.StartAudit(r.Context(), "job_cancel", id, "api", os.Getpid(), nil)
+		job, err := m.Cancel(id)
+		if errors.Is(err, jobs.ErrNotFound) {
+			audit.FinishAudit(ctx, a.auditLogger, "failure", err)
+			a.respondErrorDetails(w, http.StatusNotFound, ErrCodeNotFound, "job not found", map[string]string{"id": id})
+			return
+		}
+		if err != nil {
+			audit.FinishAudit(ctx, a.auditLogger, "failure", err)
+			a.respondErrorDetails(w, http.StatusConflict, ErrCodeValidationFailed, "failed to cancel job", err.Error())
+			return
+		}
+		audit.FinishAudit(ctx, a.auditLogger, "success", nil)
+		a.respond(w, http.StatusAccepted, job)
+	default:
+		a.methodNotAllowed(w, r)
+	}
+}
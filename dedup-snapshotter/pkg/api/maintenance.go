@@ -0,0 +1,76 @@
+package api
+
+import (
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/audit"
+	"github.com/opencloudos/dedup-snapshotter/pkg/maintenance"
+)
+
+// 维护窗口的手动覆盖操作
+const (
+	MaintenanceActionOpen  = "open"
+	MaintenanceActionClose = "close"
+	MaintenanceActionClear = "clear"
+)
+
+// MaintenanceRequest 临时调整维护窗口:open 立即开启窗口 Duration 秒,close 关闭窗口 Duration 秒,
+// clear 取消手动覆盖,恢复按配置的窗口
+type MaintenanceRequest struct {
+	Action   string `json:"action"`
+	Duration int    `json:"duration"`
+}
+
+// handleMaintenance 返回维护窗口的状态(GET),或手动开启、关闭维护窗口(POST)
+func (a *APIServer) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		a.methodNotAllowed(w, r)
+		return
+	}
+	var scheduler *maintenance.Scheduler
+	if a.store != nil {
+		scheduler = a.store.Maintenance()
+	}
+	if scheduler == nil {
+		a.respondError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "maintenance windows not available")
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		a.respond(w, http.StatusOK, scheduler.Status())
+		return
+	}
+
+	var req MaintenanceRequest
+	if !a.decodeJSON(w, r, &req) {
+		return
+	}
+	switch req.Action {
+	case MaintenanceActionOpen, MaintenanceActionClose:
+		if req.Duration <= 0 {
+			a.respondErrorDetails(w, http.StatusBadRequest, ErrCodeValidationFailed, "duration must be positive", map[string][]string{
+				"duration": {"must be a positive number of seconds"},
+			})
+			return
+		}
+	case MaintenanceActionClear:
+	default:
+		a.respondErrorDetails(w, http.StatusBadRequest, ErrCodeValidationFailed, "invalid action", map[string][]string{
+			"action": {"must be open, close or clear"},
+		})
+		return
+	}
+
+	ctx := audit.StartAudit(r.Context(), "maintenance_"+req.Action, "maintenance", "api", os.Getpid(), req)
+	if req.Action == MaintenanceActionClear {
+		scheduler.ClearOverride()
+	} else {
+		scheduler.Override(req.Action == MaintenanceActionOpen, time.Duration(req.Duration)*time.Second)
+	}
+	audit.FinishAudit(ctx, a.auditLogger, "success", nil)
+	a.respond(w, http.StatusOK, scheduler.Status())
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"mime"
+	"net/http"
+	"runtime/debug"
+	"strings"
+
+	"github.com/containerd/log"
+)
+
+// MaxRequestBodyBytes 限制请求体大小,配置更新是目前最大的请求
+const MaxRequestBodyBytes = 1 << 20
+
+// withMiddleware 按从外到内的顺序包装:panic 恢复、请求体大小限制、Content-Type 校验
+func withMiddleware(next http.Handler) http.Handler {
+	return recoverPanics(limitBody(MaxRequestBodyBytes, requireJSON(next)))
+}
+
+// recoverPanics 把 handler 中的 panic 转换为 500 响应,避免单个请求拖垮整个服务
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+				log.L.Errorf("panic serving %s %s: %v\n%s", r.Method, r.URL.Path, rec, debug.Stack())
+				writeError(w, http.StatusInternalServerError, &APIError{
+					Code:    ErrCodeInternal,
+					Message: "internal server error",
+				})
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func limitBody(limit int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > limit {
+			writeError(w, http.StatusRequestEntityTooLarge, &APIError{
+				Code:    ErrCodePayloadTooLarge,
+				Message: "request body too large",
+				Details: map[string]int64{"limit": limit},
+			})
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireJSON 要求带请求体的写请求使用 application/json,无请求体的 POST(如 reload)不受影响
+func requireJSON(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch:
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.ContentLength == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		contentType := r.Header.Get("Content-Type")
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		// 镜像仓库的推送通知使用 application/vnd.docker.distribution.events.v1+json 等 +json 类型
+		if err != nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+			writeError(w, http.StatusUnsupportedMediaType, &APIError{
+				Code:    ErrCodeUnsupportedMediaType,
+				Message: "content type must be application/json",
+				Details: map[string]string{"content_type": contentType},
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestMiddleware 验证 Content-Type 校验、请求体大小限制和 panic 恢复都返回结构化错误
+func TestMiddleware(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		a := &APIServer{}
+		if !a.decodeJSON(w, r, &body) {
+			return
+		}
+		a.respond(w, http.StatusOK, body)
+	})
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	handler := withMiddleware(mux)
+
+	cases := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		status      int
+		code        string
+	}{
+		{"valid", http.MethodPost, "/echo", "application/json; charset=utf-8", `{"a":"b"}`, http.StatusOK, ""},
+		{"wrong content type", http.MethodPost, "/echo", "text/plain", `{"a":"b"}`, http.StatusUnsupportedMediaType, ErrCodeUnsupportedMediaType},
+		{"invalid json", http.MethodPost, "/echo", "application/json", `{"a":`, http.StatusBadRequest, ErrCodeInvalidRequest},
+		{"too large", http.MethodPost, "/echo", "application/json", `{"a":"` + strings.Repeat("x", MaxRequestBodyBytes) + `"}`, http.StatusRequestEntityTooLarge, ErrCodePayloadTooLarge},
+		{"panic", http.MethodGet, "/panic", "", "", http.StatusInternalServerError, ErrCodeInternal},
+	}
+
+	for _, tc := range cases {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		if tc.contentType != "" {
+			req.Header.Set("Content-Type", tc.contentType)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.name, tc.status, rec.Code)
+			continue
+		}
+		var resp Response
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Errorf("%s: invalid response body: %v", tc.name, err)
+			continue
+		}
+		if tc.code == "" {
+			if !resp.Success || resp.Error != nil {
+				t.Errorf("%s: expected success, got %+v", tc.name, resp.Error)
+			}
+			continue
+		}
+		if resp.Success || resp.Error == nil || resp.Error.Code != tc.code {
+			t.Errorf("%s: expected error code %s, got %+v", tc.name, tc.code, resp.Error)
+		}
+	}
+	t.Logf("✓ 中间件错误均以 code/message 结构返回")
+}
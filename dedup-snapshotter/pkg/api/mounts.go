@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/audit"
+	"github.com/opencloudos/dedup-snapshotter/pkg/storage"
+)
+
+// MountCleaner 对照快照元数据找出并强制卸载泄漏的挂载,由快照服务实现
+type MountCleaner interface {
+	LeakedMounts(ctx context.Context) ([]storage.LeakedMount, error)
+	CleanupLeakedMounts(ctx context.Context, paths []string) ([]storage.LeakedMount, error)
+}
+
+// CleanupLeakedMountsRequest 强制卸载泄漏的挂载。Paths 为列出泄漏时返回的、经确认要清理的路径,
+// Confirm 必须为 true;请求时已不再泄漏的路径被跳过
+type CleanupLeakedMountsRequest struct {
+	Paths   []string `json:"paths"`
+	Confirm bool     `json:"confirm"`
+}
+
+func (a *APIServer) SetMountCleaner(c MountCleaner) {
+	a.cleaner = c
+}
+
+// handleMounts 列出各快照每层实际使用的挂载方式和回退原因,或查询单个快照的挂载记录
+func (a *APIServer) handleMounts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		a.methodNotAllowed(w, r)
+		return
+	}
+	if a.store == nil {
+		a.respondError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "mount records not available")
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/mounts"), "/")
+	if id == "" {
+		a.respond(w, http.StatusOK, a.store.MountRecords())
+		return
+	}
+	record, ok := a.store.MountRecord(id)
+	if !ok {
+		a.respondError(w, http.StatusNotFound, ErrCodeNotFound, "no mount record for snapshot "+id)
+		return
+	}
+	a.respond(w, http.StatusOK, record)
+}
+
+// handleLeakedMounts 列出不被任何快照引用的挂载和 loop 设备,或强制卸载经确认的条目(需要管理权限)
+func (a *APIServer) handleLeakedMounts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		a.methodNotAllowed(w, r)
+		return
+	}
+	if a.cleaner == nil {
+		a.respondError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "leaked mount cleanup not available")
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		leaks, err := a.cleaner.LeakedMounts(r.Context())
+		if err != nil {
+			a.respondErrorDetails(w, http.StatusInternalServerError, ErrCodeInternal, "failed to list leaked mounts", err.Error())
+			return
+		}
+		a.respond(w, http.StatusOK, leaks)
+		return
+	}
+
+	if !a.requireAdmin(w, r) {
+		return
+	}
+	var req CleanupLeakedMountsRequest
+	if !a.decodeJSON(w, r, &req) {
+		return
+	}
+	if !req.Confirm || len(req.Paths) == 0 {
+		a.respondErrorDetails(w, http.StatusBadRequest, ErrCodeValidationFailed, "paths are required and confirm must be true", map[string][]string{
+			"fields": {"paths", "confirm"},
+		})
+		return
+	}
+
+	ctx := audit.StartAudit(r.Context(), "leaked_mounts_cleanup", strings.Join(req.Paths, ","), "api", os.Getpid(), req)
+	cleaned, err := a.cleaner.CleanupLeakedMounts(ctx, req.Paths)
+	if err != nil {
+		audit.FinishAudit(ctx, a.auditLogger, "failure", err)
+		a.respondErrorDetails(w, http.StatusInternalServerError, ErrCodeInternal, "failed to clean up leaked mounts", map[string]interface{}{
+			"error":   err.Error(),
+			"cleaned": cleaned,
+		})
+		return
+	}
+	audit.FinishAudit(ctx, a.auditLogger, "success", nil)
+	if cleaned == nil {
+		cleaned = []storage.LeakedMount{}
+	}
+	a.respond(w, http.StatusOK, cleaned)
+}
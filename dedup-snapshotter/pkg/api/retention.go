@@ -0,0 +1,59 @@
+package api
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/audit"
+	"github.com/opencloudos/dedup-snapshotter/pkg/retention"
+)
+
+// RetentionRequest 立即按保留规则检查一次镜像,DryRun 为 true 时只报告将被删除的镜像
+type RetentionRequest struct {
+	DryRun bool `json:"dry_run"`
+}
+
+func (a *APIServer) SetRetention(e *retention.Engine) {
+	a.retention = e
+}
+
+// handleRetention 返回最近一次保留规则检查的报告(GET),或立即检查一次(POST,需要管理权限)
+func (a *APIServer) handleRetention(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		a.methodNotAllowed(w, r)
+		return
+	}
+	if a.retention == nil {
+		a.respondError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "image retention not enabled")
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		report := a.retention.LastReport()
+		if report == nil {
+			a.respondError(w, http.StatusNotFound, ErrCodeNotFound, "no retention check has run yet")
+			return
+		}
+		a.respond(w, http.StatusOK, report)
+		return
+	}
+
+	if !a.requireAdmin(w, r) {
+		return
+	}
+	var req RetentionRequest
+	if !a.decodeJSON(w, r, &req) {
+		return
+	}
+	ctx := audit.StartAudit(r.Context(), "image_retention", "images", "api", os.Getpid(), req)
+	report, err := a.retention.Evaluate(ctx, req.DryRun)
+	if err != nil {
+		audit.FinishAudit(ctx, a.auditLogger, "failure", err)
+		a.respondErrorDetails(w, http.StatusBadGateway, ErrCodeInternal, "failed to apply retention policy", err.Error())
+		return
+	}
+	audit.FinishAudit(ctx, a.auditLogger, "success", nil)
+	a.respond(w, http.StatusOK, report)
+}
@@ -0,0 +1,18 @@
+package api
+
+import "net/http"
+
+// routeMux 在 ServeMux 之上记录注册的路由,用于核对 OpenAPI 描述与实际路由一致
+type routeMux struct {
+	*http.ServeMux
+	patterns []string
+}
+
+func newRouteMux() *routeMux {
+	return &routeMux{ServeMux: http.NewServeMux()}
+}
+
+func (m *routeMux) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	m.patterns = append(m.patterns, pattern)
+	m.ServeMux.HandleFunc(pattern, handler)
+}
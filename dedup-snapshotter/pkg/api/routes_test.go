@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/client"
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+)
+
+// TestOpenAPIMatchesRoutes 验证 OpenAPI 描述中的每个路径都有注册的路由,
+// 且每个管理 API 路由都出现在描述中
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	api := NewAPIServer(":0", nil, config.DefaultConfig(t.TempDir()), "")
+
+	// 不属于管理 API 描述的路由
+	undocumented := map[string]bool{
+		"/metrics":             true,
+		"/api/v1/openapi.json": true,
+	}
+	param := regexp.MustCompile(`\{[^}]+\}`)
+
+	paths := client.OpenAPI("/var/lib/dedup")["paths"].(map[string]interface{})
+	served := map[string]bool{}
+	for path := range paths {
+		target := path
+		if version, rest := splitAPIVersion(path); version == APIVersionV2 {
+			target = routePrefix + rest
+		}
+		target = param.ReplaceAllString(target, "x")
+		_, pattern := api.routes.Handler(httptest.NewRequest(http.MethodGet, target, nil))
+		if pattern == "" {
+			t.Errorf("OpenAPI path %s has no registered route", path)
+			continue
+		}
+		served[pattern] = true
+	}
+
+	for _, pattern := range api.routes.patterns {
+		if !undocumented[pattern] && !served[pattern] {
+			t.Errorf("route %s is missing from the OpenAPI description", pattern)
+		}
+	}
+	t.Logf("✓ OpenAPI 描述的 %d 个路径与 %d 个路由一致", len(paths), len(api.routes.patterns))
+}
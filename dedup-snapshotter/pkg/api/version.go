@@ -0,0 +1,178 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/metrics"
+)
+
+// 管理 API 的版本。v2 是当前版本,与 v1 的端点和请求响应格式相同;v1 已弃用,
+// 响应带 Deprecation、Sunset 和指向 v2 对应端点的 Link 头,到 Sunset 之后可能被移除
+const (
+	APIVersionV1      = "v1"
+	APIVersionV2      = "v2"
+	CurrentAPIVersion = APIVersionV2
+
+	// 不在版本前缀下的端点(/metrics、/api/version)在请求日志中的版本标签
+	apiVersionNone = "none"
+
+	// 处理器按 v1 路径注册,其他版本的请求在路由前改写为 v1 路径
+	routePrefix = "/api/" + APIVersionV1
+)
+
+// APICompatibilityPolicy 是 /api/version 返回的兼容性约定
+const APICompatibilityPolicy = "Within a version, endpoints are never removed and request and response " +
+	"fields are never removed, renamed or retyped; new optional fields and endpoints may be added. " +
+	"Breaking changes ship in a new version. A deprecated version is served until its sunset date " +
+	"and its responses carry Deprecation, Sunset and successor-version Link headers."
+
+var (
+	v1Deprecated = time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC)
+	v1Sunset     = time.Date(2027, time.October, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// APIVersionInfo 描述一个 API 版本,Status 为 "stable" 或 "deprecated"
+type APIVersionInfo struct {
+	Version    string     `json:"version"`
+	Prefix     string     `json:"prefix"`
+	Status     string     `json:"status"`
+	Deprecated *time.Time `json:"deprecated,omitempty"`
+	Sunset     *time.Time `json:"sunset,omitempty"`
+	Successor  string     `json:"successor,omitempty"`
+}
+
+// APIVersions 是 /api/version 的响应
+type APIVersions struct {
+	Current  string           `json:"current"`
+	Versions []APIVersionInfo `json:"versions"`
+	Policy   string           `json:"policy"`
+}
+
+func apiVersions() *APIVersions {
+	return &APIVersions{
+		Current: CurrentAPIVersion,
+		Versions: []APIVersionInfo{
+			{
+				Version:    APIVersionV1,
+				Prefix:     "/api/" + APIVersionV1,
+				Status:     "deprecated",
+				Deprecated: &v1Deprecated,
+				Sunset:     &v1Sunset,
+				Successor:  APIVersionV2,
+			},
+			{Version: APIVersionV2, Prefix: "/api/" + APIVersionV2, Status: "stable"},
+		},
+		Policy: APICompatibilityPolicy,
+	}
+}
+
+// handleVersion 返回支持的 API 版本和兼容性约定,供第三方客户端选择版本
+func (a *APIServer) handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		a.methodNotAllowed(w, r)
+		return
+	}
+	a.respond(w, http.StatusOK, apiVersions())
+}
+
+// withVersioning 解析请求路径中的 API 版本:v2 请求改写为内部注册的 v1 路径,v1 响应加上弃用头,
+// 未知版本返回 404。每个请求按版本记录日志和延迟
+func (a *APIServer) withVersioning(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		version, rest := splitAPIVersion(path)
+		switch version {
+		case apiVersionNone:
+		case APIVersionV1:
+			w.Header().Set("Deprecation", "@"+strconv.FormatInt(v1Deprecated.Unix(), 10))
+			w.Header().Set("Sunset", v1Sunset.Format(http.TimeFormat))
+			w.Header().Set("Link", fmt.Sprintf("</api/%s%s>; rel=\"successor-version\"", APIVersionV2, rest))
+		case APIVersionV2:
+			r2 := new(http.Request)
+			*r2 = *r
+			r2.URL = new(url.URL)
+			*r2.URL = *r.URL
+			r2.URL.Path = routePrefix + rest
+			r2.URL.RawPath = ""
+			r = r2
+		default:
+			writeError(w, http.StatusNotFound, &APIError{
+				Code:    ErrCodeNotFound,
+				Message: fmt.Sprintf("unsupported API version %s", version),
+				Details: map[string][]string{"supported": {APIVersionV1, APIVersionV2}},
+			})
+			a.logRequest(r.Method, path, version, http.StatusNotFound, 0)
+			return
+		}
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		a.logRequest(r.Method, path, version, rec.status, time.Since(start))
+	})
+}
+
+// splitAPIVersion 把 "/api/<version>/..." 拆分为版本和其后的路径,不在版本前缀下的路径返回 apiVersionNone
+func splitAPIVersion(path string) (string, string) {
+	rest, ok := strings.CutPrefix(path, "/api/")
+	if !ok {
+		return apiVersionNone, path
+	}
+	version, sub, nested := strings.Cut(rest, "/")
+	if len(version) < 2 || version[0] != 'v' {
+		return apiVersionNone, path
+	}
+	if _, err := strconv.Atoi(version[1:]); err != nil {
+		return apiVersionNone, path
+	}
+	if !nested {
+		return version, ""
+	}
+	return version, "/" + sub
+}
+
+// logRequest 记录请求日志,并按版本记录延迟直方图,用于追踪仍在使用已弃用版本的调用方。
+// 未知版本只记日志,避免任意路径产生新的标签值
+func (a *APIServer) logRequest(method, path, version string, status int, duration time.Duration) {
+	log.L.WithField("api_version", version).
+		WithField("method", method).
+		WithField("path", path).
+		WithField("status", status).
+		WithField("duration", duration).
+		Debug("api request")
+
+	if a.metrics != nil && (version == APIVersionV1 || version == APIVersionV2) {
+		a.metrics.ObserveHistogram("api_request_latency", metrics.Labels{
+			"version": version,
+			"method":  method,
+			"code":    strconv.Itoa(status/100) + "xx",
+		}, duration)
+	}
+}
+
+// statusRecorder 记录 handler 写出的状态码
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.status, s.wroteHeader = status, true
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap 供 http.ResponseController 访问底层的 ResponseWriter
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
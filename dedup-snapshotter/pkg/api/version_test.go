@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+	"github.com/opencloudos/dedup-snapshotter/pkg/metrics"
+)
+
+// TestAPIVersions 验证 v1 和 v2 前缀路由到相同的处理器、v1 响应带弃用头、未知版本返回 404,
+// 以及请求按版本计入延迟直方图
+func TestAPIVersions(t *testing.T) {
+	cfg := config.DefaultConfig(t.TempDir())
+	cfg.Debug = config.DebugConfig{Enabled: true, Token: "t0ken"}
+	api := NewAPIServer(":0", nil, cfg, "")
+	api.SetMetrics(metrics.NewMetrics())
+
+	get := func(path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("Authorization", "Bearer t0ken")
+		w := httptest.NewRecorder()
+		api.server.Handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("/api/v2/debug/pprof/goroutine?debug=1")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "goroutine profile") {
+		t.Fatalf("expected v2 pprof to be served, got %d", w.Code)
+	}
+	if w.Header().Get("Deprecation") != "" {
+		t.Errorf("v2 response must not be deprecated")
+	}
+	t.Logf("✓ v2 路由到已注册的处理器")
+
+	w = get("/api/v1/config/schema")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected v1 to keep working, got %d", w.Code)
+	}
+	if w.Header().Get("Deprecation") == "" || w.Header().Get("Sunset") == "" {
+		t.Errorf("expected deprecation headers on v1, got %v", w.Header())
+	}
+	if link := w.Header().Get("Link"); link != `</api/v2/config/schema>; rel="successor-version"` {
+		t.Errorf("unexpected successor link %q", link)
+	}
+	t.Logf("✓ v1 响应带弃用头")
+
+	if w := get("/api/v3/health"); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), ErrCodeNotFound) {
+		t.Errorf("expected unsupported version to return 404, got %d %s", w.Code, w.Body)
+	}
+
+	w = get("/api/version")
+	var resp struct {
+		Data APIVersions `json:"data"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Data.Current != APIVersionV2 || len(resp.Data.Versions) != 2 || resp.Data.Versions[0].Sunset == nil {
+		t.Errorf("unexpected version discovery: %+v", resp.Data)
+	}
+	t.Logf("✓ /api/version 返回 %s 和兼容性约定", resp.Data.Current)
+
+	versions := map[string]bool{}
+	for _, h := range api.metrics.GetSnapshot().Histograms {
+		if h.Name == "api_request_latency" {
+			versions[h.Labels["version"]] = true
+		}
+	}
+	if !versions[APIVersionV1] || !versions[APIVersionV2] || versions["v3"] {
+		t.Errorf("expected latency per API version, got %v", versions)
+	}
+	t.Logf("✓ 请求按版本计入延迟直方图")
+}
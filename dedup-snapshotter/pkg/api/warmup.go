@@ -0,0 +1,48 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/audit"
+	"github.com/opencloudos/dedup-snapshotter/pkg/storage"
+)
+
+// handleWarmup 返回节点缓存预热的结果(GET),或按当前热度列表重新预热(POST)
+func (a *APIServer) handleWarmup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		a.methodNotAllowed(w, r)
+		return
+	}
+	if a.store == nil {
+		a.respondError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "store not available")
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		status, err := a.store.WarmupStatus()
+		if errors.Is(err, storage.ErrWarmupNotRun) {
+			a.respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			a.respondErrorDetails(w, http.StatusInternalServerError, ErrCodeInternal, "failed to read warm-up state", err.Error())
+			return
+		}
+		a.respond(w, http.StatusOK, status)
+		return
+	}
+
+	ctx := audit.StartAudit(r.Context(), "cache_warmup", "popularity", "api", os.Getpid(), nil)
+	status, err := a.store.WarmFromPopularity(ctx, true)
+	if err != nil {
+		audit.FinishAudit(ctx, a.auditLogger, "failure", err)
+		a.respondErrorDetails(w, http.StatusBadGateway, ErrCodeInternal, "failed to warm cache", err.Error())
+		return
+	}
+	audit.FinishAudit(ctx, a.auditLogger, "success", nil)
+	a.respond(w, http.StatusAccepted, status)
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	refdocker "github.com/containerd/containerd/reference/docker"
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/audit"
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+	"github.com/opencloudos/dedup-snapshotter/pkg/storage"
+)
+
+// registryEvent 同时覆盖 Harbor webhook(type/event_data)和 distribution 通知(events)两种格式
+type registryEvent struct {
+	Type      string `json:"type"`
+	EventData struct {
+		Resources []struct {
+			Digest      string `json:"digest"`
+			Tag         string `json:"tag"`
+			ResourceURL string `json:"resource_url"`
+		} `json:"resources"`
+		Repository struct {
+			RepoFullName string `json:"repo_full_name"`
+		} `json:"repository"`
+	} `json:"event_data"`
+
+	Events []struct {
+		Action string `json:"action"`
+		Target struct {
+			MediaType  string `json:"mediaType"`
+			Repository string `json:"repository"`
+			Digest     string `json:"digest"`
+			Tag        string `json:"tag"`
+		} `json:"target"`
+		Request struct {
+			Host string `json:"host"`
+		} `json:"request"`
+	} `json:"events"`
+}
+
+// WebhookResult 列出推送通知触发的预拉取任务,Skipped 为不匹配 webhook.repositories 的引用
+type WebhookResult struct {
+	Jobs    []*storage.ConversionJob `json:"jobs"`
+	Skipped []string                 `json:"skipped,omitempty"`
+}
+
+// pushedRefs 返回事件中推送的镜像引用,有 digest 时按 digest 固定
+func (e *registryEvent) pushedRefs() []string {
+	var refs []string
+	switch e.Type {
+	case "PUSH_ARTIFACT", "pushImage":
+		for _, res := range e.EventData.Resources {
+			host, _, _ := strings.Cut(res.ResourceURL, "/")
+			repo := host + "/" + e.EventData.Repository.RepoFullName
+			if ref := pinnedRef(repo, res.Digest, res.Tag); ref != "" {
+				refs = append(refs, ref)
+			}
+		}
+	}
+	for _, ev := range e.Events {
+		// distribution 对每个层 blob 也会发送推送事件,只处理 manifest
+		if ev.Action != "push" || !(strings.Contains(ev.Target.MediaType, "manifest") || strings.Contains(ev.Target.MediaType, "image.index")) {
+			continue
+		}
+		if ref := pinnedRef(ev.Request.Host+"/"+ev.Target.Repository, ev.Target.Digest, ev.Target.Tag); ref != "" {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+func pinnedRef(repo, digest, tag string) string {
+	switch {
+	case digest != "":
+		return repo + "@" + digest
+	case tag != "":
+		return repo + ":" + tag
+	}
+	return ""
+}
+
+// webhookAuthorized 校验 Authorization 头,接受原值或 Bearer 形式。
+// 没有配置 secret 时只在显式设置 insecure 后接受通知
+func webhookAuthorized(r *http.Request, cfg config.WebhookConfig) bool {
+	if cfg.Secret == "" {
+		return cfg.Insecure
+	}
+	auth := r.Header.Get("Authorization")
+	auth = strings.TrimPrefix(auth, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(auth), []byte(cfg.Secret)) == 1
+}
+
+// repositoryAllowed 按规范化的仓库名(如 docker.io/library/nginx)匹配 webhook.repositories
+func repositoryAllowed(patterns []string, ref string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	named, err := refdocker.ParseNormalizedNamed(ref)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, named.Name()); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// handleRegistryWebhook 接收镜像仓库的推送通知,在后台预拉取并转换新镜像,任务状态通过
+// /api/v1/images/convert/{id} 查询。只有配置了 webhook.enabled 的节点接受通知
+func (a *APIServer) handleRegistryWebhook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		a.methodNotAllowed(w, r)
+		return
+	}
+	cfg := a.cfg().Webhook
+	if !cfg.Enabled || a.conversions == nil {
+		a.respondError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "registry webhook not enabled on this node")
+		return
+	}
+	if !webhookAuthorized(r, cfg) {
+		a.respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "invalid webhook credentials")
+		return
+	}
+
+	// 通知中有大量与预拉取无关的字段,不拒绝未知字段
+	var event registryEvent
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		a.respondErrorDetails(w, http.StatusBadRequest, ErrCodeInvalidRequest, "invalid JSON", err.Error())
+		return
+	}
+
+	result := WebhookResult{Jobs: []*storage.ConversionJob{}}
+	for _, ref := range event.pushedRefs() {
+		if !repositoryAllowed(cfg.Repositories, ref) {
+			result.Skipped = append(result.Skipped, ref)
+			continue
+		}
+		job, err := a.conversions.SubmitPull(ref)
+		ctx := audit.StartAudit(r.Context(), "webhook_pull", ref, "webhook", os.Getpid(), nil)
+		if err != nil {
+			audit.FinishAudit(ctx, a.auditLogger, "failure", err)
+			log.G(r.Context()).WithError(err).Warnf("failed to queue pre-pull of %s", ref)
+			continue
+		}
+		audit.FinishAudit(ctx, a.auditLogger, "success", nil)
+		result.Jobs = append(result.Jobs, job)
+	}
+
+	a.respond(w, http.StatusAccepted, result)
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+)
+
+// TestRegistryWebhookEvents 验证 Harbor 和 distribution 推送通知的解析、仓库过滤和鉴权
+func TestRegistryWebhookEvents(t *testing.T) {
+	harbor := `{"type":"PUSH_ARTIFACT","operator":"admin","event_data":{
+		"resources":[{"digest":"sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa","tag":"v2","resource_url":"harbor.example.com/apps/web:v2"}],
+		"repository":{"name":"web","namespace":"apps","repo_full_name":"apps/web"}}}`
+	distribution := `{"events":[
+		{"action":"push","target":{"mediaType":"application/vnd.docker.image.rootfs.diff.tar.gzip","repository":"apps/api","digest":"sha256:layer"},"request":{"host":"registry.local:5000"}},
+		{"action":"push","target":{"mediaType":"application/vnd.oci.image.manifest.v1+json","repository":"apps/api","digest":"sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb","tag":"latest"},"request":{"host":"registry.local:5000"}},
+		{"action":"pull","target":{"mediaType":"application/vnd.oci.image.manifest.v1+json","repository":"apps/api","digest":"sha256:cccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccc"},"request":{"host":"registry.local:5000"}}]}`
+
+	var refs []string
+	for _, body := range []string{harbor, distribution} {
+		var event registryEvent
+		if err := json.Unmarshal([]byte(body), &event); err != nil {
+			t.Fatal(err)
+		}
+		refs = append(refs, event.pushedRefs()...)
+	}
+	want := []string{"harbor.example.com/apps/web@sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", "registry.local:5000/apps/api@sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"}
+	if !reflect.DeepEqual(refs, want) {
+		t.Fatalf("expected %v, got %v", want, refs)
+	}
+
+	patterns := []string{"harbor.example.com/apps/*"}
+	if !repositoryAllowed(patterns, refs[0]) || repositoryAllowed(patterns, refs[1]) {
+		t.Errorf("repository filter %v mismatched %v", patterns, refs)
+	}
+
+	a := &APIServer{configs: config.NewSource(&config.Config{Webhook: config.WebhookConfig{Enabled: true, Secret: "s3cret"}})}
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/webhooks/registry", strings.NewReader(harbor))
+	r.Header.Set("Authorization", "Bearer s3cret")
+	if !webhookAuthorized(r, a.cfg().Webhook) {
+		t.Error("bearer secret rejected")
+	}
+	r.Header.Set("Authorization", "wrong")
+	if webhookAuthorized(r, a.cfg().Webhook) {
+		t.Error("wrong secret accepted")
+	}
+	if webhookAuthorized(r, config.WebhookConfig{Enabled: true}) {
+		t.Error("unauthenticated notification accepted without webhook.insecure")
+	}
+	if !webhookAuthorized(r, config.WebhookConfig{Enabled: true, Insecure: true}) {
+		t.Error("unauthenticated notification rejected with webhook.insecure")
+	}
+
+	// 没有转换队列(只读附着)的节点拒绝通知
+	w := httptest.NewRecorder()
+	a.handleRegistryWebhook(w, r)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 without conversion queue, got %d", w.Code)
+	}
+	t.Logf("✓ 推送通知解析为 %v", refs)
+}
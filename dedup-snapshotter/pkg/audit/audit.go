@@ -10,12 +10,15 @@ import (
 
 	"github.com/containerd/log"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/opencloudos/dedup-snapshotter/pkg/opid"
 )
 
 type AuditLogger struct {
 	db   *sql.DB
 	mu   sync.RWMutex
 	path string
+	// redactor 在写入和查询时屏蔽详情中的敏感字段
+	redactor *Redactor
 }
 
 type AuditEntry struct {
@@ -29,6 +32,8 @@ type AuditEntry struct {
 	Result    string    `json:"result"`
 	Error     string    `json:"error,omitempty"`
 	Duration  int64     `json:"duration_ms"`
+	// OperationID 与同一快照 RPC 的日志中的 op_id 字段相同
+	OperationID string  `json:"operation_id,omitempty"`
 }
 
 type QueryFilter struct {
@@ -38,6 +43,9 @@ type QueryFilter struct {
 	Target    string
 	User      string
 	Result    string
+	OperationID string
+	// Search 为全文检索词,空格分隔的每个词都需出现在 details 或 error 中
+	Search    string
 	Limit     int
 	Offset    int
 }
@@ -60,6 +68,13 @@ func NewAuditLogger(dbPath string) (*AuditLogger, error) {
 	return logger, nil
 }
 
+// SetRedactor 设置详情的屏蔽规则,对之后写入和查询的记录生效
+func (a *AuditLogger) SetRedactor(r *Redactor) {
+	a.mu.Lock()
+	a.redactor = r
+	a.mu.Unlock()
+}
+
 func (a *AuditLogger) init() error {
 	schema := `
 	CREATE TABLE IF NOT EXISTS audit_log (
@@ -83,8 +98,25 @@ func (a *AuditLogger) init() error {
 	CREATE INDEX IF NOT EXISTS idx_audit_result ON audit_log(result);
 	`
 
-	_, err := a.db.Exec(schema)
-	return err
+	if _, err := a.db.Exec(schema); err != nil {
+		return err
+	}
+
+	// 早期版本的数据库没有 operation_id 列
+	var exists int
+	if err := a.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('audit_log') WHERE name = 'operation_id'`).Scan(&exists); err != nil {
+		return err
+	}
+	if exists == 0 {
+		if _, err := a.db.Exec(`ALTER TABLE audit_log ADD COLUMN operation_id TEXT`); err != nil {
+			return err
+		}
+	}
+	if _, err := a.db.Exec(`CREATE INDEX IF NOT EXISTS idx_audit_operation_id ON audit_log(operation_id)`); err != nil {
+		return err
+	}
+
+	return a.initStats()
 }
 
 func (a *AuditLogger) LogOperation(ctx context.Context, operation, target, user string, pid int, details interface{}, result string, err error, duration time.Duration) {
@@ -92,9 +124,9 @@ func (a *AuditLogger) LogOperation(ctx context.Context, operation, target, user
 	defer a.mu.Unlock()
 
 	detailsJSON := ""
-	if details != nil {
+	if details != nil && (a.redactor == nil || !a.redactor.disabled) {
 		if data, jsonErr := json.Marshal(details); jsonErr == nil {
-			detailsJSON = string(data)
+			detailsJSON = a.redactor.RedactJSON(string(data))
 		}
 	}
 
@@ -113,13 +145,14 @@ func (a *AuditLogger) LogOperation(ctx context.Context, operation, target, user
 		Result:    result,
 		Error:     errorStr,
 		Duration:  duration.Milliseconds(),
+		OperationID: opid.FromContext(ctx),
 	}
 
 	_, dbErr := a.db.Exec(`
-		INSERT INTO audit_log (timestamp, operation, target, user, pid, details, result, error, duration_ms)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO audit_log (timestamp, operation, target, user, pid, details, result, error, duration_ms, operation_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`, entry.Timestamp, entry.Operation, entry.Target, entry.User, entry.PID,
-		entry.Details, entry.Result, entry.Error, entry.Duration)
+		entry.Details, entry.Result, entry.Error, entry.Duration, entry.OperationID)
 
 	if dbErr != nil {
 		log.L.WithError(dbErr).Error("failed to write audit log")
@@ -130,38 +163,8 @@ func (a *AuditLogger) QueryLogs(ctx context.Context, filter *QueryFilter) ([]Aud
 	a.mu.RLock()
 	defer a.mu.RUnlock()
 
-	query := `SELECT id, timestamp, operation, target, user, pid, details, result, error, duration_ms FROM audit_log WHERE 1=1`
-	var args []interface{}
-
-	if filter.StartTime != nil {
-		query += " AND timestamp >= ?"
-		args = append(args, filter.StartTime)
-	}
-
-	if filter.EndTime != nil {
-		query += " AND timestamp <= ?"
-		args = append(args, filter.EndTime)
-	}
-
-	if filter.Operation != "" {
-		query += " AND operation = ?"
-		args = append(args, filter.Operation)
-	}
-
-	if filter.Target != "" {
-		query += " AND target LIKE ?"
-		args = append(args, "%"+filter.Target+"%")
-	}
-
-	if filter.User != "" {
-		query += " AND user = ?"
-		args = append(args, filter.User)
-	}
-
-	if filter.Result != "" {
-		query += " AND result = ?"
-		args = append(args, filter.Result)
-	}
+	where, args := filter.where()
+	query := `SELECT id, timestamp, operation, target, user, pid, details, result, error, duration_ms, COALESCE(operation_id, '') FROM audit_log` + where
 
 	query += " ORDER BY timestamp DESC"
 
@@ -197,6 +200,7 @@ func (a *AuditLogger) QueryLogs(ctx context.Context, filter *QueryFilter) ([]Aud
 			&entry.Result,
 			&errorStr,
 			&entry.Duration,
+			&entry.OperationID,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
@@ -205,6 +209,8 @@ func (a *AuditLogger) QueryLogs(ctx context.Context, filter *QueryFilter) ([]Aud
 		if errorStr.Valid {
 			entry.Error = errorStr.String
 		}
+		// 屏蔽规则变更前写入的记录在导出时同样按当前规则屏蔽
+		entry.Details = a.redactor.RedactJSON(entry.Details)
 
 		entries = append(entries, entry)
 	}
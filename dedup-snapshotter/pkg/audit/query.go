@@ -0,0 +1,231 @@
+package audit
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 聚合维度
+const (
+	GroupByOperation    = "operation"
+	GroupByUser         = "user"
+	GroupByResult       = "result"
+	GroupByTargetPrefix = "target_prefix"
+	GroupByHour         = "hour"
+)
+
+// groupExprs 是可直接在 SQL 中分组的维度,hour 按 UTC 整点分桶
+var groupExprs = map[string]string{
+	GroupByOperation: "operation",
+	GroupByUser:      "user",
+	GroupByResult:    "result",
+	GroupByHour:      "strftime('%Y-%m-%dT%H:00:00Z', timestamp)",
+}
+
+// ErrUnsupportedGroupBy 表示不支持的聚合维度
+var ErrUnsupportedGroupBy = errors.New("unsupported group_by")
+
+// AuditGroup 是一个聚合桶,Failures 为 result 不是 success 的条数
+type AuditGroup struct {
+	Key      string    `json:"key"`
+	Count    int64     `json:"count"`
+	Failures int64     `json:"failures"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// where 把过滤条件转换为 WHERE 子句,不含排序和分页
+func (f *QueryFilter) where() (string, []interface{}) {
+	clause := " WHERE 1=1"
+	var args []interface{}
+
+	if f.StartTime != nil {
+		clause += " AND timestamp >= ?"
+		args = append(args, f.StartTime)
+	}
+
+	if f.EndTime != nil {
+		clause += " AND timestamp <= ?"
+		args = append(args, f.EndTime)
+	}
+
+	if f.Operation != "" {
+		clause += " AND operation = ?"
+		args = append(args, f.Operation)
+	}
+
+	if f.Target != "" {
+		clause += " AND target LIKE ?"
+		args = append(args, "%"+f.Target+"%")
+	}
+
+	if f.User != "" {
+		clause += " AND user = ?"
+		args = append(args, f.User)
+	}
+
+	if f.Result != "" {
+		clause += " AND result = ?"
+		args = append(args, f.Result)
+	}
+
+	if f.OperationID != "" {
+		clause += " AND operation_id = ?"
+		args = append(args, f.OperationID)
+	}
+
+	// 全文检索匹配请求详情和错误信息,不区分大小写,每个词都要出现
+	for _, term := range strings.Fields(f.Search) {
+		pattern := "%" + escapeLike(term) + "%"
+		clause += ` AND (COALESCE(details, '') LIKE ? ESCAPE '\' OR COALESCE(error, '') LIKE ? ESCAPE '\')`
+		args = append(args, pattern, pattern)
+	}
+
+	return clause, args
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// Aggregate 按 groupBy 统计满足 filter 的审计记录,结果按条数降序(hour 按时间升序),
+// filter 的 Limit/Offset 不生效
+func (a *AuditLogger) Aggregate(ctx context.Context, filter *QueryFilter, groupBy string) ([]AuditGroup, error) {
+	expr, ok := groupExprs[groupBy]
+	if groupBy == GroupByTargetPrefix {
+		expr, ok = "target", true
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedGroupBy, groupBy)
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	where, args := filter.where()
+	query := fmt.Sprintf(`SELECT %s, COUNT(*), SUM(CASE WHEN result = 'success' THEN 0 ELSE 1 END), MAX(timestamp)
+		FROM audit_log%s GROUP BY 1`, expr, where)
+
+	rows, err := a.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate audit logs: %w", err)
+	}
+	defer rows.Close()
+
+	groups := make(map[string]*AuditGroup)
+	for rows.Next() {
+		var key, lastSeen string
+		var count, failures int64
+		if err := rows.Scan(&key, &count, &failures, &lastSeen); err != nil {
+			return nil, fmt.Errorf("failed to scan audit group: %w", err)
+		}
+		if groupBy == GroupByTargetPrefix {
+			key = TargetPrefix(key)
+		}
+
+		g, ok := groups[key]
+		if !ok {
+			g = &AuditGroup{Key: key}
+			groups[key] = g
+		}
+		g.Count += count
+		g.Failures += failures
+		// MAX() 返回的是 sqlite 中保存的文本,不会被驱动转换为 time.Time
+		if t, err := parseTimestamp(lastSeen); err == nil && t.After(g.LastSeen) {
+			g.LastSeen = t
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]AuditGroup, 0, len(groups))
+	for _, g := range groups {
+		result = append(result, *g)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if groupBy == GroupByHour {
+			return result[i].Key < result[j].Key
+		}
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Key < result[j].Key
+	})
+	return result, nil
+}
+
+// TargetPrefix 取目标的第一段作为聚合键:镜像引用取仓库地址(docker.io/library/nginx → docker.io),
+// 摘要取算法(sha256:... → sha256),其他目标原样返回
+func TargetPrefix(target string) string {
+	if i := strings.IndexAny(target, "/:"); i > 0 {
+		return target[:i]
+	}
+	return target
+}
+
+func parseTimestamp(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999-07:00", time.RFC3339Nano, "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized timestamp %q", s)
+}
+
+var csvHeader = []string{"id", "timestamp", "operation", "target", "user", "pid", "result", "error", "duration_ms", "details", "operation_id"}
+
+// WriteCSV 把审计记录写为带表头的 CSV
+func WriteCSV(w io.Writer, entries []AuditEntry) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		record := []string{
+			strconv.FormatInt(e.ID, 10),
+			e.Timestamp.UTC().Format(time.RFC3339),
+			e.Operation,
+			e.Target,
+			e.User,
+			strconv.Itoa(e.PID),
+			e.Result,
+			e.Error,
+			strconv.FormatInt(e.Duration, 10),
+			e.Details,
+			e.OperationID,
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteGroupsCSV 把聚合结果写为带表头的 CSV,第一列为聚合维度名
+func WriteGroupsCSV(w io.Writer, groupBy string, groups []AuditGroup) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{groupBy, "count", "failures", "last_seen"}); err != nil {
+		return err
+	}
+	for _, g := range groups {
+		record := []string{
+			g.Key,
+			strconv.FormatInt(g.Count, 10),
+			strconv.FormatInt(g.Failures, 10),
+			g.LastSeen.UTC().Format(time.RFC3339),
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,99 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/opid"
+)
+
+// TestSearchAndAggregate 验证全文检索、按目标前缀和小时聚合以及 CSV 导出
+func TestSearchAndAggregate(t *testing.T) {
+	logger, err := NewAuditLogger(filepath.Join(t.TempDir(), "audit.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	ctx := context.Background()
+	logger.LogOperation(ctx, "image_remove", "docker.io/library/nginx:1.25", "alice", 1, map[string]string{"reason": "Disk Pressure"}, "success", nil, 0)
+	logger.LogOperation(ctx, "image_remove", "docker.io/library/redis:7", "bob", 1, map[string]string{"reason": "manual"}, "success", nil, 0)
+	logger.LogOperation(ctx, "image_convert", "sha256:abc", "api", 1, nil, "failed", errors.New("mkfs.erofs 100%_done"), 0)
+
+	entries, err := logger.QueryLogs(ctx, &QueryFilter{Search: "disk pressure"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].User != "alice" {
+		t.Fatalf("expected alice's removal, got %+v", entries)
+	}
+	// % 和 _ 按字面匹配
+	entries, err = logger.QueryLogs(ctx, &QueryFilter{Search: "100%_"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Operation != "image_convert" {
+		t.Fatalf("expected search to match error text, got %+v", entries)
+	}
+
+	groups, err := logger.Aggregate(ctx, &QueryFilter{}, GroupByTargetPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 || groups[0].Key != "docker.io" || groups[0].Count != 2 || groups[1].Failures != 1 {
+		t.Fatalf("unexpected target prefix groups: %+v", groups)
+	}
+
+	groups, err = logger.Aggregate(ctx, &QueryFilter{Operation: "image_remove"}, GroupByHour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 两条记录可能跨越整点
+	var hourly int64
+	for _, g := range groups {
+		hourly += g.Count
+		if g.LastSeen.IsZero() {
+			t.Errorf("expected last_seen for %s", g.Key)
+		}
+	}
+	if hourly != 2 {
+		t.Fatalf("unexpected hourly groups: %+v", groups)
+	}
+
+	if _, err := logger.Aggregate(ctx, &QueryFilter{}, "details"); !errors.Is(err, ErrUnsupportedGroupBy) {
+		t.Errorf("expected ErrUnsupportedGroupBy, got %v", err)
+	}
+
+	entries, err = logger.QueryLogs(ctx, &QueryFilter{Operation: "image_remove"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, entries); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[0][0] != "id" || records[1][4] != "bob" {
+		t.Fatalf("unexpected CSV: %v", records)
+	}
+	t.Logf("✓ 按小时聚合 %s 共 %d 条,CSV 导出 %d 行", groups[0].Key, groups[0].Count, len(records)-1)
+
+	// 快照 RPC 的审计记录带有 context 中的操作 ID
+	opCtx := opid.Start(ctx, "prepare")
+	logger.LogOperation(opCtx, "prepare_snapshot", "k1", "containerd", 1, nil, "success", nil, 0)
+	entries, err = logger.QueryLogs(ctx, &QueryFilter{OperationID: opid.FromContext(opCtx)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Target != "k1" || entries[0].OperationID != opid.FromContext(opCtx) {
+		t.Fatalf("expected the prepare entry by operation id, got %+v", entries)
+	}
+	t.Logf("✓ 按操作 ID %s 找到审计记录", entries[0].OperationID)
+}
@@ -0,0 +1,84 @@
+package audit
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// RedactedValue 替换被屏蔽字段的值
+const RedactedValue = "[REDACTED]"
+
+// Redactor 在审计详情写入和导出前屏蔽敏感字段。掩码中 * 匹配任意字符,不区分大小写,
+// 与字段名或以 . 连接的完整路径(如 labels.containerd.io/snapshot.ref)匹配时整个值被替换
+type Redactor struct {
+	masks    []*regexp.Regexp
+	disabled bool
+}
+
+// NewRedactor 创建 Redactor,captureDetails 为 false 时不记录任何详情
+func NewRedactor(masks []string, captureDetails bool) *Redactor {
+	r := &Redactor{disabled: !captureDetails}
+	for _, mask := range masks {
+		if mask == "" {
+			continue
+		}
+		pattern := strings.ReplaceAll(regexp.QuoteMeta(mask), `\*`, ".*")
+		r.masks = append(r.masks, regexp.MustCompile("(?i)^"+pattern+"$"))
+	}
+	return r
+}
+
+// RedactJSON 屏蔽 JSON 形式的详情,不是 JSON 对象或数组时原样返回
+func (r *Redactor) RedactJSON(details string) string {
+	if r == nil || details == "" {
+		return details
+	}
+	if r.disabled {
+		return ""
+	}
+	if len(r.masks) == 0 {
+		return details
+	}
+
+	var v interface{}
+	if err := json.Unmarshal([]byte(details), &v); err != nil {
+		return details
+	}
+	data, err := json.Marshal(r.redact(v, ""))
+	if err != nil {
+		return details
+	}
+	return string(data)
+}
+
+func (r *Redactor) redact(v interface{}, path string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			field := key
+			if path != "" {
+				field = path + "." + key
+			}
+			if r.match(key, field) {
+				v[key] = RedactedValue
+			} else {
+				v[key] = r.redact(value, field)
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = r.redact(value, path)
+		}
+	}
+	return v
+}
+
+func (r *Redactor) match(key, field string) bool {
+	for _, mask := range r.masks {
+		if mask.MatchString(key) || mask.MatchString(field) {
+			return true
+		}
+	}
+	return false
+}
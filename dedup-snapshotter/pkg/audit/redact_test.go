@@ -0,0 +1,52 @@
+package audit
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRedaction 验证详情写入前按掩码屏蔽,旧记录在查询时按当前规则屏蔽,关闭详情后不再记录
+func TestRedaction(t *testing.T) {
+	logger, err := NewAuditLogger(filepath.Join(t.TempDir(), "audit.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	ctx := context.Background()
+	logger.LogOperation(ctx, "prepare_snapshot", "k1", "containerd", 1, map[string]interface{}{
+		"labels": map[string]string{"containerd.io/snapshot.ref": "sha256:abc"},
+	}, "success", nil, 0)
+
+	logger.SetRedactor(NewRedactor([]string{"*secret*", "labels.*"}, true))
+	logger.LogOperation(ctx, "config_update", "config", "api", 1, map[string]interface{}{
+		"webhook": map[string]interface{}{"Secret": "hunter2", "enabled": true},
+		"keys":    []interface{}{map[string]string{"client_secret": "s3"}},
+	}, "success", nil, 0)
+
+	entries, err := logger.QueryLogs(ctx, &QueryFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.Contains(e.Details, "hunter2") || strings.Contains(e.Details, "s3") || strings.Contains(e.Details, "sha256:abc") {
+			t.Errorf("details not redacted: %s", e.Details)
+		}
+		if !strings.Contains(e.Details, RedactedValue) {
+			t.Errorf("expected redaction marker in %s", e.Details)
+		}
+	}
+	if entries, _ := logger.QueryLogs(ctx, &QueryFilter{Search: "hunter2"}); len(entries) != 0 {
+		t.Errorf("secret persisted in database: %+v", entries)
+	}
+
+	logger.SetRedactor(NewRedactor(nil, false))
+	logger.LogOperation(ctx, "remove_snapshot", "k2", "containerd", 1, map[string]string{"key": "k2"}, "success", nil, 0)
+	entries, _ = logger.QueryLogs(ctx, &QueryFilter{Operation: "remove_snapshot"})
+	if len(entries) != 1 || entries[0].Details != "" {
+		t.Errorf("expected details to be dropped, got %+v", entries)
+	}
+	t.Logf("✓ 审计详情已屏蔽")
+}
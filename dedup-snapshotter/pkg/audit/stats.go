@@ -0,0 +1,108 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/containerd/log"
+)
+
+// StatsSample 是一次周期性采样的关键指标,降采样后的点取各时间桶内的平均值
+type StatsSample struct {
+	Timestamp           time.Time `json:"timestamp"`
+	DedupRatio          float64   `json:"dedup_ratio"`
+	CacheHitRate        float64   `json:"cache_hit_rate"`
+	StorageUsedBytes    int64     `json:"storage_used_bytes"`
+	StorageUsagePercent float64   `json:"storage_usage_percent"`
+	Samples             int64     `json:"samples,omitempty"`
+}
+
+func (a *AuditLogger) initStats() error {
+	// ts 使用 unix 秒,便于在 SQL 中按时间桶聚合
+	_, err := a.db.Exec(`
+	CREATE TABLE IF NOT EXISTS stats_samples (
+		ts INTEGER NOT NULL,
+		dedup_ratio REAL NOT NULL,
+		cache_hit_rate REAL NOT NULL,
+		storage_used_bytes INTEGER NOT NULL,
+		storage_usage_percent REAL NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_stats_ts ON stats_samples(ts);
+	`)
+	return err
+}
+
+func (a *AuditLogger) RecordStats(ctx context.Context, sample StatsSample) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if sample.Timestamp.IsZero() {
+		sample.Timestamp = time.Now()
+	}
+
+	_, err := a.db.Exec(`
+		INSERT INTO stats_samples (ts, dedup_ratio, cache_hit_rate, storage_used_bytes, storage_usage_percent)
+		VALUES (?, ?, ?, ?, ?)
+	`, sample.Timestamp.Unix(), sample.DedupRatio, sample.CacheHitRate, sample.StorageUsedBytes, sample.StorageUsagePercent)
+	if err != nil {
+		return fmt.Errorf("failed to record stats sample: %w", err)
+	}
+	return nil
+}
+
+// StatsHistory 返回 since 之后的采样,按 step 对齐的时间桶取平均,按时间升序
+func (a *AuditLogger) StatsHistory(ctx context.Context, since time.Time, step time.Duration) ([]StatsSample, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	stepSecs := int64(step / time.Second)
+	if stepSecs <= 0 {
+		stepSecs = 1
+	}
+
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT (ts / ?) * ? AS bucket,
+			AVG(dedup_ratio), AVG(cache_hit_rate),
+			CAST(AVG(storage_used_bytes) AS INTEGER), AVG(storage_usage_percent),
+			COUNT(*)
+		FROM stats_samples
+		WHERE ts >= ?
+		GROUP BY bucket
+		ORDER BY bucket
+	`, stepSecs, stepSecs, since.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stats history: %w", err)
+	}
+	defer rows.Close()
+
+	samples := []StatsSample{}
+	for rows.Next() {
+		var bucket int64
+		var sample StatsSample
+		if err := rows.Scan(&bucket, &sample.DedupRatio, &sample.CacheHitRate,
+			&sample.StorageUsedBytes, &sample.StorageUsagePercent, &sample.Samples); err != nil {
+			return nil, fmt.Errorf("failed to scan stats sample: %w", err)
+		}
+		sample.Timestamp = time.Unix(bucket, 0).UTC()
+		samples = append(samples, sample)
+	}
+
+	return samples, rows.Err()
+}
+
+func (a *AuditLogger) CleanupStats(ctx context.Context, retentionDays int) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	cutoff := time.Now().AddDate(0, 0, -retentionDays).Unix()
+	result, err := a.db.Exec("DELETE FROM stats_samples WHERE ts < ?", cutoff)
+	if err != nil {
+		return fmt.Errorf("failed to cleanup stats samples: %w", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	log.L.Infof("cleaned up %d stats samples older than %d days", rowsAffected, retentionDays)
+	return nil
+}
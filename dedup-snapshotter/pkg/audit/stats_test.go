@@ -0,0 +1,46 @@
+package audit
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestStatsHistory 验证采样按时间桶取平均并排除窗口外的数据
+func TestStatsHistory(t *testing.T) {
+	logger, err := NewAuditLogger(filepath.Join(t.TempDir(), "audit.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	ctx := context.Background()
+	base := time.Now().Truncate(time.Hour).Add(-2 * time.Hour)
+	samples := []StatsSample{
+		{Timestamp: base.Add(-48 * time.Hour), DedupRatio: 99},
+		{Timestamp: base, DedupRatio: 10, StorageUsedBytes: 100},
+		{Timestamp: base.Add(10 * time.Minute), DedupRatio: 30, StorageUsedBytes: 300},
+		{Timestamp: base.Add(time.Hour), DedupRatio: 50, CacheHitRate: 80},
+	}
+	for _, s := range samples {
+		if err := logger.RecordStats(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	points, err := logger.StatsHistory(ctx, base.Add(-time.Minute), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 {
+		t.Fatalf("expected 2 hourly points, got %+v", points)
+	}
+	if points[0].DedupRatio != 20 || points[0].StorageUsedBytes != 200 || points[0].Samples != 2 {
+		t.Errorf("unexpected first bucket: %+v", points[0])
+	}
+	if !points[1].Timestamp.Equal(base.Add(time.Hour)) || points[1].CacheHitRate != 80 {
+		t.Errorf("unexpected second bucket: %+v", points[1])
+	}
+	t.Logf("✓ 历史采样按小时降采样为 %d 个点", len(points))
+}
@@ -0,0 +1,208 @@
+// Package background 让后台任务(镜像转换、chunk 校验、内存去重扫描)以低优先级运行,
+// 避免在资源紧张的节点上与业务 pod 争抢 CPU 和 IO。
+//
+// Linux 的 nice 和 IO 优先级以线程为单位,后台任务运行在独占的 OS 线程上并在结束时随线程一起退出,
+// 降低的优先级不会留给其他 goroutine。它们启动的外部命令(mkfs.erofs)继承同样的优先级,
+// 配置了 cgroup 时还会被放入该 cgroup,受 cpu.weight 和 io.max 约束。
+// 进程内的线程不能放入单独的 domain cgroup(io 控制器不支持线程模式),因此只用 nice/ionice
+package background
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+	"golang.org/x/sys/unix"
+)
+
+// ioprio_set 的参数,取值见 include/uapi/linux/ioprio.h
+const (
+	ioprioClassBE    = 2
+	ioprioClassIdle  = 3
+	ioprioClassShift = 13
+	ioprioWhoProcess = 1
+)
+
+// CgroupRoot 是 cgroup v2 的挂载点,相对的 cgroup 路径以此为根
+const CgroupRoot = "/sys/fs/cgroup"
+
+type Controller struct {
+	nice      int
+	ioprio    int
+	cgroupDir string
+	cgroupFd  int
+}
+
+// New 按配置创建控制器。cgroup 不可用(cgroup v1、没有权限、控制器未启用)时只记录告警,
+// 后台任务仍以降低的 nice/IO 优先级运行
+func New(cfg config.BackgroundConfig) *Controller {
+	c := &Controller{nice: cfg.Nice, cgroupFd: -1}
+
+	switch cfg.IOClass {
+	case config.IOClassIdle:
+		c.ioprio = ioprioClassIdle << ioprioClassShift
+	case config.IOClassBestEffort:
+		c.ioprio = ioprioClassBE<<ioprioClassShift | cfg.IOLevel
+	}
+
+	if cfg.Cgroup != "" {
+		if err := c.setupCgroup(cfg); err != nil {
+			log.L.WithError(err).Warnf("background cgroup %s disabled", cfg.Cgroup)
+		} else {
+			log.L.Infof("background commands run in cgroup %s (cpu.weight=%d)", c.cgroupDir, cfg.CPUWeight)
+		}
+	}
+	return c
+}
+
+func (c *Controller) setupCgroup(cfg config.BackgroundConfig) error {
+	dir := cfg.Cgroup
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(CgroupRoot, dir)
+	}
+	if _, err := os.Stat(filepath.Join(CgroupRoot, "cgroup.controllers")); err != nil {
+		return fmt.Errorf("cgroup v2 not mounted at %s: %w", CgroupRoot, err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	// 父 cgroup 自身有进程时不能启用 domain 控制器,失败时子 cgroup 的设置文件不存在
+	parent := filepath.Join(filepath.Dir(dir), "cgroup.subtree_control")
+	if err := os.WriteFile(parent, []byte("+cpu +io"), 0644); err != nil {
+		log.L.WithError(err).Debugf("failed to enable cpu and io controllers in %s", parent)
+	}
+
+	if cfg.CPUWeight > 0 {
+		if err := os.WriteFile(filepath.Join(dir, "cpu.weight"), []byte(strconv.Itoa(cfg.CPUWeight)), 0644); err != nil {
+			return fmt.Errorf("failed to set cpu.weight: %w", err)
+		}
+	}
+	for _, line := range cfg.IOMax {
+		if err := os.WriteFile(filepath.Join(dir, "io.max"), []byte(line), 0644); err != nil {
+			return fmt.Errorf("failed to set io.max %q: %w", line, err)
+		}
+	}
+
+	fd, err := unix.Open(dir, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+
+	// clone3 放入 cgroup 需要 5.7 以上内核和 cgroup.procs 写权限,先试启动一次,
+	// 避免到转换时才因 cgroup 启动失败
+	probe := exec.Command("true")
+	probe.SysProcAttr = &syscall.SysProcAttr{UseCgroupFD: true, CgroupFD: fd}
+	if err := probe.Run(); err != nil {
+		unix.Close(fd)
+		return fmt.Errorf("failed to start process in cgroup: %w", err)
+	}
+
+	c.cgroupDir = dir
+	c.cgroupFd = fd
+	return nil
+}
+
+// Go 在独占的低优先级线程上运行 fn,nil 控制器等同于普通的 go fn()
+func (c *Controller) Go(fn func()) {
+	go func() {
+		c.lowerThread()
+		fn()
+	}()
+}
+
+// Run 在独占的低优先级线程上运行 fn 并等待其结束
+func (c *Controller) Run(fn func()) {
+	done := make(chan struct{})
+	c.Go(func() {
+		defer close(done)
+		fn()
+	})
+	<-done
+}
+
+// lowerThread 降低当前线程的优先级。不调用 UnlockOSThread,
+// goroutine 结束时线程随之退出,不会被调度给其他 goroutine
+func (c *Controller) lowerThread() {
+	if c == nil {
+		return
+	}
+	runtime.LockOSThread()
+	if err := c.apply(unix.Gettid()); err != nil {
+		log.L.WithError(err).Debug("failed to lower background thread priority")
+	}
+}
+
+func (c *Controller) apply(tid int) error {
+	var errs []string
+	if c.nice > 0 {
+		if err := unix.Setpriority(unix.PRIO_PROCESS, tid, c.nice); err != nil {
+			errs = append(errs, fmt.Sprintf("setpriority: %v", err))
+		}
+	}
+	if c.ioprio > 0 {
+		if _, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(c.ioprio)); errno != 0 {
+			errs = append(errs, fmt.Sprintf("ioprio_set: %v", errno))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// PrepareCommand 在启动外部命令前设置其 cgroup,未配置 cgroup 时不做修改
+func (c *Controller) PrepareCommand(cmd *exec.Cmd) {
+	if c == nil || c.cgroupFd < 0 {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = c.cgroupFd
+}
+
+// ApplyProcess 降低已启动的外部命令的优先级。
+// 从低优先级线程 fork 的子进程已继承优先级,这里覆盖从普通线程启动的情况
+func (c *Controller) ApplyProcess(pid int) {
+	if c == nil {
+		return
+	}
+	if err := c.apply(pid); err != nil {
+		log.L.WithError(err).Debugf("failed to lower priority of pid %d", pid)
+	}
+}
+
+func (c *Controller) Close() error {
+	if c == nil || c.cgroupFd < 0 {
+		return nil
+	}
+	err := unix.Close(c.cgroupFd)
+	c.cgroupFd = -1
+	return err
+}
+
+type contextKey struct{}
+
+// WithController 让 ctx 下启动的外部命令使用后台优先级
+func WithController(ctx context.Context, c *Controller) context.Context {
+	if c == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, c)
+}
+
+// FromContext 返回 ctx 携带的控制器,没有时返回 nil(所有方法对 nil 安全)
+func FromContext(ctx context.Context) *Controller {
+	c, _ := ctx.Value(contextKey{}).(*Controller)
+	return c
+}
@@ -0,0 +1,52 @@
+package background
+
+import (
+	"testing"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+	"golang.org/x/sys/unix"
+)
+
+func threadPriority(t *testing.T) (nice int, ioprio int) {
+	// 内核返回 20-nice
+	prio, err := unix.Getpriority(unix.PRIO_PROCESS, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _, errno := unix.Syscall(unix.SYS_IOPRIO_GET, ioprioWhoProcess, 0, 0)
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	return 20 - prio, int(r)
+}
+
+// TestRunLowersOnlyBackgroundThread 验证后台任务所在线程被降低优先级,调用方线程不受影响
+func TestRunLowersOnlyBackgroundThread(t *testing.T) {
+	ctrl := New(config.BackgroundConfig{Nice: 19, IOClass: config.IOClassIdle})
+	defer ctrl.Close()
+
+	baseNice, baseIO := threadPriority(t)
+
+	var nice, ioprio int
+	ctrl.Run(func() {
+		nice, ioprio = threadPriority(t)
+	})
+	if nice != 19 {
+		t.Errorf("expected nice 19 in background, got %d", nice)
+	}
+	if ioprio != ioprioClassIdle<<ioprioClassShift {
+		t.Errorf("expected idle io class in background, got %#x", ioprio)
+	}
+
+	if n, io := threadPriority(t); n != baseNice || io != baseIO {
+		t.Errorf("caller thread priority changed: nice %d -> %d, ioprio %#x -> %#x", baseNice, n, baseIO, io)
+	}
+
+	var nilCtrl *Controller
+	ran := false
+	nilCtrl.Run(func() { ran = true })
+	if !ran {
+		t.Error("expected nil controller to run fn")
+	}
+	t.Logf("✓ 后台线程 nice=%d ioprio=%#x,调用方线程不变", nice, ioprio)
+}
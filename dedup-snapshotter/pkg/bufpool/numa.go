@@ -0,0 +1,72 @@
+package bufpool
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/containerd/log"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// nodeOnlinePath 列出在线的 NUMA 节点,如 0-1
+	nodeOnlinePath = "/sys/devices/system/node/online"
+	mpolInterleave = 3
+)
+
+// OnlineNodes 返回在线的 NUMA 节点编号,无法读取时视为只有节点 0
+func OnlineNodes() []int {
+	data, err := os.ReadFile(nodeOnlinePath)
+	if err != nil {
+		return []int{0}
+	}
+	nodes := parseNodeList(strings.TrimSpace(string(data)))
+	if len(nodes) == 0 {
+		return []int{0}
+	}
+	return nodes
+}
+
+// parseNodeList 解析内核的节点列表格式,如 0-3,6
+func parseNodeList(s string) []int {
+	var nodes []int
+	for _, part := range strings.Split(s, ",") {
+		lo, hi, isRange := strings.Cut(part, "-")
+		first, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.Atoi(hi); err != nil || last < first {
+				return nil
+			}
+		}
+		for n := first; n <= last; n++ {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// interleave 让尚未访问的 region 在各 NUMA 节点间交错分配,避免共用的缓冲池全部落在一个节点上。
+// 单节点主机上不做处理,失败时只记录日志
+func interleave(region []byte) {
+	nodes := OnlineNodes()
+	if len(nodes) < 2 {
+		return
+	}
+	maxNode := nodes[len(nodes)-1] + 1
+	mask := make([]uint64, maxNode/64+1)
+	for _, n := range nodes {
+		mask[n/64] |= 1 << (n % 64)
+	}
+	_, _, errno := unix.Syscall6(unix.SYS_MBIND,
+		uintptr(unsafe.Pointer(&region[0])), uintptr(len(region)), mpolInterleave,
+		uintptr(unsafe.Pointer(&mask[0])), uintptr(maxNode+1), 0)
+	if errno != 0 {
+		log.L.WithError(errno).Warn("failed to interleave buffer pool across NUMA nodes")
+	}
+}
@@ -0,0 +1,135 @@
+package bufpool
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// ChunkSize 是切分、哈希和重建时使用的 chunk 缓冲区大小,与 erofs.ChunkSize 一致
+	ChunkSize = 4 * 1024 * 1024
+	// Alignment 是缓冲区起始地址的对齐,满足 O_DIRECT 的要求
+	Alignment = 4096
+	// hugePageSize 是透明大页的大小,大页区域按它对齐
+	hugePageSize = 2 * 1024 * 1024
+)
+
+// Buffer 是从 Pool 取出的缓冲区,用完后必须 Put 回去,之后不可再访问 B
+type Buffer struct {
+	B    []byte
+	huge bool
+}
+
+// Pool 复用固定大小、按页对齐的缓冲区,避免每个 chunk 都分配新的 4MB 切片。
+// 普通缓冲区放在 sync.Pool 中,可被 GC 回收;开启大页后先从预先映射的大页区域分配,
+// 大页区域在多 NUMA 节点的主机上交错分布在各节点上,用完后不归还给系统
+type Pool struct {
+	size int
+	pool sync.Pool
+	huge chan *Buffer
+
+	gets   atomic.Int64
+	allocs atomic.Int64
+}
+
+// Stats 是缓冲池的使用统计,Allocs 为池中无可用缓冲区时新分配的次数
+type Stats struct {
+	Size      int   `json:"size"`
+	Gets      int64 `json:"gets"`
+	Allocs    int64 `json:"allocs"`
+	HugeTotal int   `json:"huge_total"`
+	HugeFree  int   `json:"huge_free"`
+}
+
+var defaultPool = New(ChunkSize)
+
+// Default 返回进程共用的 chunk 缓冲池
+func Default() *Pool {
+	return defaultPool
+}
+
+// New 创建缓冲区大小为 size 的缓冲池
+func New(size int) *Pool {
+	p := &Pool{size: size}
+	p.pool.New = func() interface{} {
+		p.allocs.Add(1)
+		return &Buffer{B: alignedSlice(size)}
+	}
+	return p
+}
+
+// EnableHugePages 预先映射 count 个缓冲区大小的匿名内存并建议内核使用透明大页,
+// 只能调用一次。大页不可用时返回错误,缓冲池继续使用普通内存
+func (p *Pool) EnableHugePages(count int) error {
+	if count <= 0 || p.huge != nil {
+		return nil
+	}
+	stride := (p.size + hugePageSize - 1) / hugePageSize * hugePageSize
+	region, err := unix.Mmap(-1, 0, stride*count, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		return fmt.Errorf("failed to map huge page region: %w", err)
+	}
+	if err := unix.Madvise(region, unix.MADV_HUGEPAGE); err != nil {
+		unix.Munmap(region)
+		return fmt.Errorf("transparent huge pages unavailable: %w", err)
+	}
+	interleave(region)
+
+	p.huge = make(chan *Buffer, count)
+	for i := 0; i < count; i++ {
+		p.huge <- &Buffer{B: region[i*stride : i*stride+p.size : i*stride+p.size], huge: true}
+	}
+	return nil
+}
+
+// Get 取出一个长度为池大小的缓冲区,内容未清零
+func (p *Pool) Get() *Buffer {
+	p.gets.Add(1)
+	select {
+	case buf := <-p.huge:
+		return buf
+	default:
+	}
+	return p.pool.Get().(*Buffer)
+}
+
+// Put 归还缓冲区,buf 为 nil 时忽略
+func (p *Pool) Put(buf *Buffer) {
+	if buf == nil {
+		return
+	}
+	if buf.huge {
+		p.huge <- buf
+		return
+	}
+	if cap(buf.B) != p.size {
+		return
+	}
+	buf.B = buf.B[:p.size]
+	p.pool.Put(buf)
+}
+
+// Stats 返回缓冲池的使用统计
+func (p *Pool) Stats() Stats {
+	return Stats{
+		Size:      p.size,
+		Gets:      p.gets.Load(),
+		Allocs:    p.allocs.Load(),
+		HugeTotal: cap(p.huge),
+		HugeFree:  len(p.huge),
+	}
+}
+
+// alignedSlice 分配起始地址按 Alignment 对齐的切片
+func alignedSlice(size int) []byte {
+	raw := make([]byte, size+Alignment)
+	off := 0
+	if rem := int(uintptr(unsafe.Pointer(&raw[0])) % Alignment); rem != 0 {
+		off = Alignment - rem
+	}
+	return raw[off : off+size : off+size]
+}
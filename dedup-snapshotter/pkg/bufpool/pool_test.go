@@ -0,0 +1,54 @@
+package bufpool
+
+import (
+	"slices"
+	"testing"
+	"unsafe"
+)
+
+// TestPoolReuse 验证缓冲区按页对齐、归还后被复用,大页缓冲区优先分配
+func TestPoolReuse(t *testing.T) {
+	p := New(64 << 10)
+	buf := p.Get()
+	if len(buf.B) != 64<<10 {
+		t.Fatalf("unexpected buffer length %d", len(buf.B))
+	}
+	if addr := uintptr(unsafe.Pointer(&buf.B[0])); addr%Alignment != 0 {
+		t.Errorf("buffer at %#x is not %d-byte aligned", addr, Alignment)
+	}
+	p.Put(buf)
+
+	if err := p.EnableHugePages(2); err != nil {
+		t.Skipf("huge pages unavailable: %v", err)
+	}
+	a, b, c := p.Get(), p.Get(), p.Get()
+	if !a.huge || !b.huge || c.huge {
+		t.Errorf("expected two huge buffers then a regular one: %v %v %v", a.huge, b.huge, c.huge)
+	}
+	p.Put(a)
+	p.Put(b)
+	p.Put(c)
+
+	stats := p.Stats()
+	if stats.HugeTotal != 2 || stats.HugeFree != 2 || stats.Gets != 4 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	t.Logf("✓ 缓冲池 %+v", stats)
+}
+
+// TestParseNodeList 验证 NUMA 节点列表的解析
+func TestParseNodeList(t *testing.T) {
+	cases := map[string][]int{
+		"0":       {0},
+		"0-3":     {0, 1, 2, 3},
+		"0-1,4,6": {0, 1, 4, 6},
+		"x":       nil,
+		"3-1":     nil,
+	}
+	for in, want := range cases {
+		if got := parseNodeList(in); !slices.Equal(got, want) {
+			t.Errorf("parseNodeList(%q) = %v, want %v", in, got, want)
+		}
+	}
+	t.Logf("✓ 节点列表解析正确")
+}
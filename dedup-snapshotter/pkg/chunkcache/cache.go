@@ -0,0 +1,160 @@
+package chunkcache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/metrics"
+)
+
+// Cache 是按字节数限制容量的 chunk LRU 缓存,放在本地 chunk 存储和 fscache 读取之前,
+// 使热点 chunk 的重复读取不再访问磁盘或远端。键为 chunk 哈希,缓存的数据调用方不可修改。
+// nil *Cache 表示不启用缓存,所有方法均可安全调用
+type Cache struct {
+	maxBytes int64
+
+	mu        sync.Mutex
+	lru       *list.List
+	entries   map[string]*list.Element
+	bytes     int64
+	hits      int64
+	misses    int64
+	evictions int64
+
+	metrics atomic.Pointer[metrics.Metrics]
+}
+
+type entry struct {
+	hash string
+	data []byte
+}
+
+// Stats 是缓存的容量和命中统计
+type Stats struct {
+	MaxBytes  int64   `json:"max_bytes"`
+	Bytes     int64   `json:"bytes"`
+	Entries   int     `json:"entries"`
+	Hits      int64   `json:"hits"`
+	Misses    int64   `json:"misses"`
+	Evictions int64   `json:"evictions"`
+	HitRate   float64 `json:"hit_rate"`
+}
+
+// New 创建容量为 maxBytes 的缓存,maxBytes 不大于 0 时返回 nil(不缓存)
+func New(maxBytes int64) *Cache {
+	if maxBytes <= 0 {
+		return nil
+	}
+	return &Cache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// SetMetrics 设置记录命中率和占用字节数的 Metrics
+func (c *Cache) SetMetrics(m *metrics.Metrics) {
+	if c == nil {
+		return
+	}
+	c.metrics.Store(m)
+}
+
+// Get 返回缓存的 chunk 数据并将其标为最近使用
+func (c *Cache) Get(hash string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	elem, ok := c.entries[hash]
+	if ok {
+		c.lru.MoveToFront(elem)
+		c.hits++
+	} else {
+		c.misses++
+	}
+	c.mu.Unlock()
+
+	if m := c.metrics.Load(); m != nil {
+		m.ObserveChunkCache(ok)
+	}
+	if !ok {
+		return nil, false
+	}
+	return elem.Value.(*entry).data, true
+}
+
+// Add 缓存 chunk 数据,超出容量时淘汰最久未使用的条目。大于总容量的 chunk 不缓存
+func (c *Cache) Add(hash string, data []byte) {
+	if c == nil || int64(len(data)) > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	if elem, ok := c.entries[hash]; ok {
+		c.lru.MoveToFront(elem)
+		c.mu.Unlock()
+		return
+	}
+	c.entries[hash] = c.lru.PushFront(&entry{hash: hash, data: data})
+	c.bytes += int64(len(data))
+
+	var evicted int64
+	for c.bytes > c.maxBytes {
+		oldest := c.lru.Back()
+		e := oldest.Value.(*entry)
+		c.lru.Remove(oldest)
+		delete(c.entries, e.hash)
+		c.bytes -= int64(len(e.data))
+		evicted++
+	}
+	c.evictions += evicted
+	bytes, entries := c.bytes, len(c.entries)
+	c.mu.Unlock()
+
+	if m := c.metrics.Load(); m != nil {
+		m.UpdateChunkCache(bytes, entries, evicted)
+	}
+}
+
+// Remove 删除 chunk 的缓存,用于本地 chunk 被删除或替换时
+func (c *Cache) Remove(hash string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	elem, ok := c.entries[hash]
+	if ok {
+		c.lru.Remove(elem)
+		delete(c.entries, hash)
+		c.bytes -= int64(len(elem.Value.(*entry).data))
+	}
+	bytes, entries := c.bytes, len(c.entries)
+	c.mu.Unlock()
+
+	if m := c.metrics.Load(); ok && m != nil {
+		m.UpdateChunkCache(bytes, entries, 0)
+	}
+}
+
+// Stats 返回当前的容量和命中统计
+func (c *Cache) Stats() Stats {
+	if c == nil {
+		return Stats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := Stats{
+		MaxBytes:  c.maxBytes,
+		Bytes:     c.bytes,
+		Entries:   len(c.entries),
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+	if c.hits+c.misses > 0 {
+		stats.HitRate = float64(c.hits) / float64(c.hits+c.misses) * 100
+	}
+	return stats
+}
@@ -0,0 +1,63 @@
+package chunkcache
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/metrics"
+)
+
+// TestCacheEviction 验证按字节数淘汰最久未使用的 chunk,并统计命中率
+func TestCacheEviction(t *testing.T) {
+	c := New(10)
+	m := metrics.NewMetrics()
+	c.SetMetrics(m)
+
+	c.Add("a", []byte("aaaa"))
+	c.Add("b", []byte("bbbb"))
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("expected hit for a")
+	}
+	// 加入 c 超出 10 字节,b 最久未使用被淘汰
+	c.Add("c", []byte("cccc"))
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("b should have been evicted")
+	}
+	if data, ok := c.Get("a"); !ok || !bytes.Equal(data, []byte("aaaa")) {
+		t.Errorf("unexpected a: %q %v", data, ok)
+	}
+
+	stats := c.Stats()
+	if stats.Bytes != 8 || stats.Entries != 2 || stats.Evictions != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if stats.Hits != 2 || stats.Misses != 1 {
+		t.Errorf("unexpected hits/misses: %+v", stats)
+	}
+
+	snap := m.GetSnapshot()
+	if snap.ChunkCacheHits != 2 || snap.ChunkCacheMisses != 1 || snap.ChunkCacheEvictions != 1 || snap.ChunkCacheBytes != 8 {
+		t.Errorf("unexpected metrics: %+v", snap)
+	}
+	t.Logf("✓ 缓存 %d 字节,命中率 %.2f%%", stats.Bytes, stats.HitRate)
+}
+
+// TestCacheLimits 验证超过容量的 chunk 不缓存,nil 缓存可安全使用
+func TestCacheLimits(t *testing.T) {
+	c := New(4)
+	c.Add("big", []byte("12345"))
+	if _, ok := c.Get("big"); ok {
+		t.Error("chunk larger than the cache should not be stored")
+	}
+
+	var disabled *Cache
+	if New(0) != nil {
+		t.Error("zero capacity should disable the cache")
+	}
+	disabled.Add("a", []byte("a"))
+	if _, ok := disabled.Get("a"); ok {
+		t.Error("disabled cache should never hit")
+	}
+	t.Logf("✓ 超限和禁用的缓存不保存数据")
+}
@@ -0,0 +1,149 @@
+package chunkcache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// ChunkMeta 是索引中一个 chunk 的元数据,索引只记录引用计数时 Size 和 Tier 为空
+type ChunkMeta struct {
+	Size     int64
+	Tier     string
+	RefCount int64
+}
+
+// MetaCache 是按条目数限制容量的 chunk 元数据 LRU 缓存,放在 SQLite 索引之前,
+// 转换时重复出现的 chunk 不必每次查询数据库。索引在写事务提交后同步更新或删除对应条目,
+// 缓存中存在的条目总与数据库一致。nil *MetaCache 表示不启用缓存,所有方法均可安全调用
+type MetaCache struct {
+	maxEntries int
+
+	mu        sync.Mutex
+	lru       *list.List
+	entries   map[string]*list.Element
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+type metaEntry struct {
+	hash string
+	meta ChunkMeta
+}
+
+// MetaStats 是元数据缓存的容量和命中统计
+type MetaStats struct {
+	MaxEntries int     `json:"max_entries"`
+	Entries    int     `json:"entries"`
+	Hits       int64   `json:"hits"`
+	Misses     int64   `json:"misses"`
+	Evictions  int64   `json:"evictions"`
+	HitRate    float64 `json:"hit_rate"`
+}
+
+// NewMetaCache 创建最多缓存 maxEntries 个 chunk 的元数据缓存,maxEntries 不大于 0 时返回 nil(不缓存)
+func NewMetaCache(maxEntries int) *MetaCache {
+	if maxEntries <= 0 {
+		return nil
+	}
+	return &MetaCache{
+		maxEntries: maxEntries,
+		lru:        list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// Get 返回缓存的 chunk 元数据并将其标为最近使用
+func (c *MetaCache) Get(hash string) (ChunkMeta, bool) {
+	if c == nil {
+		return ChunkMeta{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[hash]
+	if !ok {
+		c.misses++
+		return ChunkMeta{}, false
+	}
+	c.lru.MoveToFront(elem)
+	c.hits++
+	return elem.Value.(*metaEntry).meta, true
+}
+
+// Put 设置 chunk 的元数据,超出容量时淘汰最久未使用的条目
+func (c *MetaCache) Put(hash string, meta ChunkMeta) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[hash]; ok {
+		elem.Value.(*metaEntry).meta = meta
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[hash] = c.lru.PushFront(&metaEntry{hash: hash, meta: meta})
+	for len(c.entries) > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*metaEntry).hash)
+		c.evictions++
+	}
+}
+
+// AddRefs 调整已缓存 chunk 的引用计数,未缓存的 chunk 不做处理
+func (c *MetaCache) AddRefs(hash string, delta int64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[hash]; ok {
+		elem.Value.(*metaEntry).meta.RefCount += delta
+	}
+}
+
+// Remove 删除 chunk 的缓存,用于 chunk 从索引中删除时
+func (c *MetaCache) Remove(hash string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[hash]; ok {
+		c.lru.Remove(elem)
+		delete(c.entries, hash)
+	}
+}
+
+// Purge 清空缓存,用于索引被整体重建时
+func (c *MetaCache) Purge() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+// Stats 返回当前的容量和命中统计
+func (c *MetaCache) Stats() MetaStats {
+	if c == nil {
+		return MetaStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := MetaStats{
+		MaxEntries: c.maxEntries,
+		Entries:    len(c.entries),
+		Hits:       c.hits,
+		Misses:     c.misses,
+		Evictions:  c.evictions,
+	}
+	if c.hits+c.misses > 0 {
+		stats.HitRate = float64(c.hits) / float64(c.hits+c.misses) * 100
+	}
+	return stats
+}
@@ -0,0 +1,774 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+)
+
+// Client 是 dedup-snapshotter 管理 API(/api/v2)的客户端,方法与 API 端点一一对应
+type Client struct {
+	base   string
+	client *http.Client
+	token  string
+}
+
+// New 创建客户端,address 可以省略 http:// 前缀,如 "127.0.0.1:8080"
+func New(address string) *Client {
+	return NewWithHTTPClient(address, nil)
+}
+
+// NewWithHTTPClient 使用指定的 http.Client,为空时使用 http.DefaultClient
+func NewWithHTTPClient(address string, client *http.Client) *Client {
+	if !strings.HasPrefix(address, "http://") && !strings.HasPrefix(address, "https://") {
+		address = "http://" + address
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Client{
+		base:   strings.TrimSuffix(address, "/"),
+		client: client,
+	}
+}
+
+// SetToken 设置管理操作使用的 api.token,请求带 Authorization: Bearer <token>
+func (c *Client) SetToken(token string) {
+	c.token = token
+}
+
+// Error 是 API 返回的失败响应,调用方应按 Code 判断错误类型
+type Error struct {
+	StatusCode int             `json:"-"`
+	Code       string          `json:"code"`
+	Message    string          `json:"message"`
+	Details    json.RawMessage `json:"details,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if len(e.Details) > 0 {
+		return fmt.Sprintf("%s: %s: %s", e.Code, e.Message, e.Details)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// 与 pkg/api 中的错误码相同
+const (
+	CodeInvalidRequest   = "invalid_request"
+	CodeValidationFailed = "validation_failed"
+	CodeNotFound         = "not_found"
+	CodeUnauthorized     = "unauthorized"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeUnavailable      = "unavailable"
+	CodeInternal         = "internal_error"
+)
+
+// envelope 是所有 JSON 响应的外层结构
+type envelope struct {
+	Success bool            `json:"success"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Request, error) {
+	u := c.base + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return req, nil
+}
+
+// do 发送请求并把响应中的 data 解码到 out,out 为空时忽略 data
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	req, err := c.newRequest(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	var env envelope
+	if err := json.NewDecoder(resp.Body).Decode(&env); err != nil {
+		if resp.StatusCode >= 400 {
+			return &Error{StatusCode: resp.StatusCode, Code: CodeInternal, Message: resp.Status}
+		}
+		return fmt.Errorf("failed to decode response of %s: %w", path, err)
+	}
+	if resp.StatusCode >= 400 || !env.Success {
+		if env.Error == nil {
+			env.Error = &Error{Code: CodeInternal, Message: resp.Status}
+		}
+		env.Error.StatusCode = resp.StatusCode
+		return env.Error
+	}
+
+	if out == nil || len(env.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(env.Data, out)
+}
+
+// stream 发送请求并把原始响应体写入 w,用于 CSV 导出
+func (c *Client) stream(ctx context.Context, path string, query url.Values, w io.Writer) error {
+	req, err := c.newRequest(ctx, http.MethodGet, path, query, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("GET %s failed: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var env envelope
+		if json.NewDecoder(resp.Body).Decode(&env) == nil && env.Error != nil {
+			env.Error.StatusCode = resp.StatusCode
+			return env.Error
+		}
+		return &Error{StatusCode: resp.StatusCode, Code: CodeInternal, Message: resp.Status}
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// AuditQuery 是审计日志的查询条件,零值字段不参与过滤
+type AuditQuery struct {
+	StartTime time.Time
+	EndTime   time.Time
+	// Since 为相对当前时间的窗口,如 "24h"、"7d",覆盖 StartTime
+	Since     string
+	Operation string
+	Target    string
+	User      string
+	Result    string
+	// OperationID 为快照 RPC 的操作 ID,即日志中的 op_id 字段
+	OperationID string
+	// Search 为全文检索词,空格分隔的每个词都需出现在 details 或 error 中
+	Search string
+	Limit  int
+	Offset int
+}
+
+func (q *AuditQuery) values() url.Values {
+	v := url.Values{}
+	if !q.StartTime.IsZero() {
+		v.Set("start_time", q.StartTime.Format(time.RFC3339))
+	}
+	if !q.EndTime.IsZero() {
+		v.Set("end_time", q.EndTime.Format(time.RFC3339))
+	}
+	for key, value := range map[string]string{
+		"since":        q.Since,
+		"operation":    q.Operation,
+		"target":       q.Target,
+		"user":         q.User,
+		"result":       q.Result,
+		"operation_id": q.OperationID,
+		"q":            q.Search,
+	} {
+		if value != "" {
+			v.Set(key, value)
+		}
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if q.Offset > 0 {
+		v.Set("offset", strconv.Itoa(q.Offset))
+	}
+	return v
+}
+
+// AuditLogs 查询审计日志,未指定 Limit 时服务端最多返回 100 条
+func (c *Client) AuditLogs(ctx context.Context, q AuditQuery) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	err := c.do(ctx, http.MethodGet, "/api/v2/audit/logs", q.values(), nil, &entries)
+	return entries, err
+}
+
+// ExportAuditLogs 把满足条件的审计日志以 CSV 写入 w,未指定 Limit 时不分页
+func (c *Client) ExportAuditLogs(ctx context.Context, q AuditQuery, w io.Writer) error {
+	v := q.values()
+	v.Set("format", "csv")
+	return c.stream(ctx, "/api/v2/audit/logs", v, w)
+}
+
+// AuditGroups 按 groupBy(operation、user、result、target_prefix 或 hour)聚合审计日志
+func (c *Client) AuditGroups(ctx context.Context, q AuditQuery, groupBy string) ([]AuditGroup, error) {
+	v := q.values()
+	v.Set("group_by", groupBy)
+	var result struct {
+		Groups []AuditGroup `json:"groups"`
+	}
+	err := c.do(ctx, http.MethodGet, "/api/v2/audit/logs", v, nil, &result)
+	return result.Groups, err
+}
+
+// AuditStats 返回审计库的汇总统计
+func (c *Client) AuditStats(ctx context.Context) (map[string]interface{}, error) {
+	var stats map[string]interface{}
+	err := c.do(ctx, http.MethodGet, "/api/v2/audit/stats", nil, nil, &stats)
+	return stats, err
+}
+
+// StatsHistory 返回 window 时间窗内降采样后的指标序列,参数格式同 AuditQuery.Since,为空时使用服务端默认值
+func (c *Client) StatsHistory(ctx context.Context, window, step string) (*StatsHistory, error) {
+	v := url.Values{}
+	if window != "" {
+		v.Set("window", window)
+	}
+	if step != "" {
+		v.Set("step", step)
+	}
+	var history StatsHistory
+	if err := c.do(ctx, http.MethodGet, "/api/v2/stats/history", v, nil, &history); err != nil {
+		return nil, err
+	}
+	return &history, nil
+}
+
+// Config 返回服务当前使用的配置
+func (c *Client) Config(ctx context.Context) (*config.Config, error) {
+	var cfg config.Config
+	if err := c.do(ctx, http.MethodGet, "/api/v2/config", nil, nil, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// UpdateConfig 校验并保存配置,返回服务端补全默认值后的配置
+func (c *Client) UpdateConfig(ctx context.Context, cfg *config.Config) (*config.Config, error) {
+	var result configResult
+	if err := c.do(ctx, http.MethodPut, "/api/v2/config", nil, cfg, &result); err != nil {
+		return nil, err
+	}
+	return result.Config, nil
+}
+
+// ReloadConfig 让服务从配置文件重新加载配置
+func (c *Client) ReloadConfig(ctx context.Context) (*config.Config, error) {
+	var result configResult
+	if err := c.do(ctx, http.MethodPost, "/api/v2/config/reload", nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Config, nil
+}
+
+type configResult struct {
+	Message string         `json:"message"`
+	Config  *config.Config `json:"config"`
+}
+
+// ConfigSchema 返回配置的 JSON schema
+func (c *Client) ConfigSchema(ctx context.Context) (map[string]interface{}, error) {
+	var schema map[string]interface{}
+	err := c.do(ctx, http.MethodGet, "/api/v2/config/schema", nil, nil, &schema)
+	return schema, err
+}
+
+// Version 返回服务支持的 API 版本和兼容性约定
+func (c *Client) Version(ctx context.Context) (*APIVersions, error) {
+	var versions APIVersions
+	if err := c.do(ctx, http.MethodGet, "/api/version", nil, nil, &versions); err != nil {
+		return nil, err
+	}
+	return &versions, nil
+}
+
+// Health 返回服务健康状态,有告警触发时 Status 为 degraded
+func (c *Client) Health(ctx context.Context) (*Health, error) {
+	var health Health
+	if err := c.do(ctx, http.MethodGet, "/api/v2/health", nil, nil, &health); err != nil {
+		return nil, err
+	}
+	return &health, nil
+}
+
+// Convert 提交转换任务,任务在后台执行,用 ConversionJob 查询进度
+func (c *Client) Convert(ctx context.Context, req ConvertRequest) (*ConversionJob, error) {
+	var job ConversionJob
+	if err := c.do(ctx, http.MethodPost, "/api/v2/images/convert", nil, req, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ConversionJobs 列出所有转换和重排任务
+func (c *Client) ConversionJobs(ctx context.Context) ([]ConversionJob, error) {
+	var jobs []ConversionJob
+	err := c.do(ctx, http.MethodGet, "/api/v2/images/convert", nil, nil, &jobs)
+	return jobs, err
+}
+
+// ConversionJob 返回单个转换或重排任务
+func (c *Client) ConversionJob(ctx context.Context, id string) (*ConversionJob, error) {
+	var job ConversionJob
+	if err := c.do(ctx, http.MethodGet, "/api/v2/images/convert/"+url.PathEscape(id), nil, nil, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Relayout 提交按访问顺序重建镜像的任务,order 为空时使用已记录的顺序
+func (c *Client) Relayout(ctx context.Context, imageID string, order []string) (*ConversionJob, error) {
+	var job ConversionJob
+	if err := c.do(ctx, http.MethodPost, "/api/v2/images/relayout", nil, RelayoutRequest{ImageID: imageID, Order: order}, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Import 提交从节点上的 OCI layout 目录或镜像 tar 包导入镜像的任务,用于离线环境预置节点
+func (c *Client) Import(ctx context.Context, path string) (*ConversionJob, error) {
+	var job ConversionJob
+	if err := c.do(ctx, http.MethodPost, "/api/v2/images/import", nil, ImportRequest{Path: path}, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Pull 同步拉取并物化镜像,只下载本地缺少的层和 chunk
+func (c *Client) Pull(ctx context.Context, imageRef string) (*PullResult, error) {
+	return c.PullWithPriority(ctx, imageRef, "")
+}
+
+// PullWithPriority 同 Pull,priority 为 low、normal 或 high,同一仓库排队中的转换任务按它提升优先级
+func (c *Client) PullWithPriority(ctx context.Context, imageRef, priority string) (*PullResult, error) {
+	var result PullResult
+	if err := c.do(ctx, http.MethodPost, "/api/v2/images/pull", nil, PullRequest{ImageRef: imageRef, Priority: priority}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// PlanUpgrade 计算从 from 升级到 to 需要下载的 chunk,不下载数据
+func (c *Client) PlanUpgrade(ctx context.Context, from, to string) (*UpgradePlan, error) {
+	return c.upgrade(ctx, UpgradeRequest{From: from, To: to, DryRun: true})
+}
+
+// Upgrade 预取升级目标镜像,只下载 from 中没有的 chunk
+func (c *Client) Upgrade(ctx context.Context, from, to string) (*UpgradePlan, error) {
+	return c.upgrade(ctx, UpgradeRequest{From: from, To: to})
+}
+
+func (c *Client) upgrade(ctx context.Context, req UpgradeRequest) (*UpgradePlan, error) {
+	var plan UpgradePlan
+	if err := c.do(ctx, http.MethodPost, "/api/v2/images/upgrade", nil, req, &plan); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// PlanPush 计算节点上本地构建的层中已存在于镜像仓库或本地 chunk 存储的数据
+func (c *Client) PlanPush(ctx context.Context, layerPath string) (*PushPlan, error) {
+	var plan PushPlan
+	if err := c.do(ctx, http.MethodPost, "/api/v2/push/plan", nil, PushPlanRequest{LayerPath: layerPath}, &plan); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// Binds 列出 pod 的镜像绑定挂载
+func (c *Client) Binds(ctx context.Context) ([]BindMount, error) {
+	var binds []BindMount
+	err := c.do(ctx, http.MethodGet, "/api/v2/pods", nil, nil, &binds)
+	return binds, err
+}
+
+// BindPod 把镜像绑定挂载到 pod
+func (c *Client) BindPod(ctx context.Context, podID string, req BindRequest) (*BindMount, error) {
+	var bind BindMount
+	if err := c.do(ctx, http.MethodPost, "/api/v2/pods/"+url.PathEscape(podID)+"/binds", nil, req, &bind); err != nil {
+		return nil, err
+	}
+	return &bind, nil
+}
+
+// UnbindPod 释放 pod 的全部绑定挂载
+func (c *Client) UnbindPod(ctx context.Context, podID string) error {
+	return c.do(ctx, http.MethodDelete, "/api/v2/pods/"+url.PathEscape(podID), nil, nil, nil)
+}
+
+// StartupTraces 列出容器冷启动追踪
+func (c *Client) StartupTraces(ctx context.Context) ([]StartupTrace, error) {
+	var traces []StartupTrace
+	err := c.do(ctx, http.MethodGet, "/api/v2/startup", nil, nil, &traces)
+	return traces, err
+}
+
+// ContainerStarted 标记容器已启动,结束其冷启动追踪
+func (c *Client) ContainerStarted(ctx context.Context, key string) (*StartupTrace, error) {
+	var trace StartupTrace
+	if err := c.do(ctx, http.MethodPost, "/api/v2/startup/"+url.PathEscape(key), nil, nil, &trace); err != nil {
+		return nil, err
+	}
+	return &trace, nil
+}
+
+// Prefetches 列出进行中的预取任务
+func (c *Client) Prefetches(ctx context.Context) ([]PrefetchStatus, error) {
+	var statuses []PrefetchStatus
+	err := c.do(ctx, http.MethodGet, "/api/v2/prefetch", nil, nil, &statuses)
+	return statuses, err
+}
+
+// StartPrefetch 按节点 trace 目录中 ID 为 trace 的 trace 预取已注册到 fscache 的镜像
+func (c *Client) StartPrefetch(ctx context.Context, imageID, trace string) error {
+	return c.do(ctx, http.MethodPost, "/api/v2/prefetch", nil, PrefetchRequest{ImageID: imageID, Trace: trace}, nil)
+}
+
+// StartPrefetchWithFilter 按 trace 预取镜像,只预取通过 filter 的数据
+func (c *Client) StartPrefetchWithFilter(ctx context.Context, imageID, trace string, filter PrefetchFilter) error {
+	return c.do(ctx, http.MethodPost, "/api/v2/prefetch", nil, PrefetchRequest{ImageID: imageID, Trace: trace, Filter: &filter}, nil)
+}
+
+// MergeTraces 把镜像多次运行的 trace 合并为预取计划,traces 为节点 trace 目录中的 trace ID,
+// minFrequency 为 0 时保留所有 chunk
+func (c *Client) MergeTraces(ctx context.Context, imageID string, traces []string, minFrequency float64) (*PrefetchPlan, error) {
+	var plan PrefetchPlan
+	req := TraceMergeRequest{ImageID: imageID, Traces: traces, MinFrequency: minFrequency}
+	if err := c.do(ctx, http.MethodPost, "/api/v2/prefetch/merge", nil, req, &plan); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// TraceProfiles 列出节点上的 trace 配置
+func (c *Client) TraceProfiles(ctx context.Context) ([]TraceProfile, error) {
+	var profiles []TraceProfile
+	err := c.do(ctx, http.MethodGet, "/api/v2/prefetch/profiles", nil, nil, &profiles)
+	return profiles, err
+}
+
+// PutTraceProfile 保存 trace 配置中镜像的 trace,快照通过 dedup-trace-profile 标签选择配置
+func (c *Client) PutTraceProfile(ctx context.Context, profile, imageID string, chunks []string) error {
+	path := "/api/v2/prefetch/profiles/" + url.PathEscape(profile) + "/" + url.PathEscape(imageID)
+	return c.do(ctx, http.MethodPut, path, nil, TraceProfileRequest{Chunks: chunks}, nil)
+}
+
+// DeleteTraceProfile 删除 trace 配置中镜像的 trace,imageID 为空时删除整个配置
+func (c *Client) DeleteTraceProfile(ctx context.Context, profile, imageID string) error {
+	path := "/api/v2/prefetch/profiles/" + url.PathEscape(profile)
+	if imageID != "" {
+		path += "/" + url.PathEscape(imageID)
+	}
+	return c.do(ctx, http.MethodDelete, path, nil, nil, nil)
+}
+
+// CleanupVolumes 立即清理不再对应镜像或快照的 fscache 卷,返回删除的卷数
+func (c *Client) CleanupVolumes(ctx context.Context) (int, error) {
+	var result struct {
+		Removed int `json:"removed"`
+	}
+	err := c.do(ctx, http.MethodPost, "/api/v2/gc/volumes", nil, nil, &result)
+	return result.Removed, err
+}
+
+// SubmitVolumeGC 提交在后台清理孤儿 fscache 卷的任务,结果通过 Job 查询
+func (c *Client) SubmitVolumeGC(ctx context.Context) (*Job, error) {
+	var job Job
+	if err := c.do(ctx, http.MethodPost, "/api/v2/gc/volumes", url.Values{"async": {"true"}}, nil, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Jobs 列出长时间任务,按创建时间从新到旧排列,typ 和 state 为空时不过滤
+func (c *Client) Jobs(ctx context.Context, typ, state string) ([]Job, error) {
+	query := url.Values{}
+	if typ != "" {
+		query.Set("type", typ)
+	}
+	if state != "" {
+		query.Set("state", state)
+	}
+	var list []Job
+	err := c.do(ctx, http.MethodGet, "/api/v2/jobs", query, nil, &list)
+	return list, err
+}
+
+// Job 返回任务及其日志
+func (c *Client) Job(ctx context.Context, id string) (*Job, error) {
+	var job Job
+	if err := c.do(ctx, http.MethodGet, "/api/v2/jobs/"+url.PathEscape(id), nil, nil, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// CancelJob 取消排队中或运行中的任务,运行中的任务在处理结束后变为 canceled
+func (c *Client) CancelJob(ctx context.Context, id string) (*Job, error) {
+	var job Job
+	if err := c.do(ctx, http.MethodDelete, "/api/v2/jobs/"+url.PathEscape(id), nil, nil, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// RetentionReport 返回最近一次镜像保留规则检查的报告
+func (c *Client) RetentionReport(ctx context.Context) (*RetentionReport, error) {
+	var report RetentionReport
+	if err := c.do(ctx, http.MethodGet, "/api/v2/retention", nil, nil, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// ApplyRetention 立即按保留规则检查一次镜像,dryRun 为 true 时只报告将被删除的镜像
+func (c *Client) ApplyRetention(ctx context.Context, dryRun bool) (*RetentionReport, error) {
+	var report RetentionReport
+	if err := c.do(ctx, http.MethodPost, "/api/v2/retention", nil, RetentionRequest{DryRun: dryRun}, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// WarmupStatus 返回节点缓存预热的结果
+func (c *Client) WarmupStatus(ctx context.Context) (*WarmupStatus, error) {
+	var status WarmupStatus
+	if err := c.do(ctx, http.MethodGet, "/api/v2/warmup", nil, nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Warmup 按 warmup.feed 中的热度列表重新预热,为部署最多的镜像提交低优先级拉取任务
+func (c *Client) Warmup(ctx context.Context) (*WarmupStatus, error) {
+	var status WarmupStatus
+	if err := c.do(ctx, http.MethodPost, "/api/v2/warmup", nil, nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// MaintenanceStatus 返回维护窗口的当前状态
+func (c *Client) MaintenanceStatus(ctx context.Context) (*MaintenanceStatus, error) {
+	var status MaintenanceStatus
+	if err := c.do(ctx, http.MethodGet, "/api/v2/maintenance", nil, nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// OverrideMaintenance 立即开启(open 为 true)或关闭维护窗口 d 时间
+func (c *Client) OverrideMaintenance(ctx context.Context, open bool, d time.Duration) (*MaintenanceStatus, error) {
+	req := MaintenanceRequest{Action: "close", Duration: int(d / time.Second)}
+	if open {
+		req.Action = "open"
+	}
+	var status MaintenanceStatus
+	if err := c.do(ctx, http.MethodPost, "/api/v2/maintenance", nil, req, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// ClearMaintenanceOverride 取消维护窗口的手动覆盖,恢复按配置的窗口
+func (c *Client) ClearMaintenanceOverride(ctx context.Context) (*MaintenanceStatus, error) {
+	var status MaintenanceStatus
+	if err := c.do(ctx, http.MethodPost, "/api/v2/maintenance", nil, MaintenanceRequest{Action: "clear"}, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// DurabilityStatus 返回 chunk 持久化层的冗余方式和各后端是否可用
+func (c *Client) DurabilityStatus(ctx context.Context) (*DurabilityStatus, error) {
+	var status DurabilityStatus
+	if err := c.do(ctx, http.MethodGet, "/api/v2/durability", nil, nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// SubmitDurabilityRepair 提交补齐持久化层中缺失副本和分片的任务,结果通过 Job 查询
+func (c *Client) SubmitDurabilityRepair(ctx context.Context) (*Job, error) {
+	var job Job
+	if err := c.do(ctx, http.MethodPost, "/api/v2/durability", nil, nil, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Backends 返回各 chunk 存储后端的统计和健康状态
+func (c *Client) Backends(ctx context.Context) (*BackendHealth, error) {
+	var health BackendHealth
+	if err := c.do(ctx, http.MethodGet, "/api/v2/backends", nil, nil, &health); err != nil {
+		return nil, err
+	}
+	return &health, nil
+}
+
+// Usage 返回按镜像和命名空间统计的独占与共享空间,namespace 非空时只返回该命名空间的条目
+func (c *Client) Usage(ctx context.Context, namespace string) (*UsageReport, error) {
+	var query url.Values
+	if namespace != "" {
+		query = url.Values{"namespace": {namespace}}
+	}
+	var report UsageReport
+	if err := c.do(ctx, http.MethodGet, "/api/v2/usage", query, nil, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// Chargeback 返回按镜像和命名空间的流量分摊报告,previous 为 true 时返回上一个已关闭的窗口,
+// namespace 非空时只返回该命名空间的条目
+func (c *Client) Chargeback(ctx context.Context, namespace string, previous bool) (*ChargebackReport, error) {
+	query := url.Values{}
+	if namespace != "" {
+		query.Set("namespace", namespace)
+	}
+	if previous {
+		query.Set("window", "previous")
+	}
+	var report ChargebackReport
+	if err := c.do(ctx, http.MethodGet, "/api/v2/stats/chargeback", query, nil, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// ResetChargeback 关闭进行中的统计窗口并开始新窗口,返回关闭的窗口
+func (c *Client) ResetChargeback(ctx context.Context) (*ChargebackWindow, error) {
+	var window ChargebackWindow
+	if err := c.do(ctx, http.MethodPost, "/api/v2/stats/chargeback/reset", nil, nil, &window); err != nil {
+		return nil, err
+	}
+	return &window, nil
+}
+
+// Baselines 列出已记录的去重统计基线,按记录时间排序
+func (c *Client) Baselines(ctx context.Context) ([]DedupBaseline, error) {
+	var baselines []DedupBaseline
+	if err := c.do(ctx, http.MethodGet, "/api/v2/stats/baseline", nil, nil, &baselines); err != nil {
+		return nil, err
+	}
+	return baselines, nil
+}
+
+// RecordBaseline 采集当前去重统计并保存为基线,同名基线被覆盖
+func (c *Client) RecordBaseline(ctx context.Context, name string) (*DedupBaseline, error) {
+	var baseline DedupBaseline
+	if err := c.do(ctx, http.MethodPost, "/api/v2/stats/baseline", nil, BaselineRequest{Name: name}, &baseline); err != nil {
+		return nil, err
+	}
+	return &baseline, nil
+}
+
+// CompareBaseline 返回当前去重统计相对基线的变化
+func (c *Client) CompareBaseline(ctx context.Context, name string) (*BaselineReport, error) {
+	var report BaselineReport
+	if err := c.do(ctx, http.MethodGet, "/api/v2/stats/baseline/"+url.PathEscape(name), nil, nil, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// Mounts 列出各快照每层实际使用的挂载方式和回退原因
+func (c *Client) Mounts(ctx context.Context) ([]MountRecord, error) {
+	var records []MountRecord
+	if err := c.do(ctx, http.MethodGet, "/api/v2/mounts", nil, nil, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// Mount 返回快照最近一次挂载的记录
+func (c *Client) Mount(ctx context.Context, id string) (*MountRecord, error) {
+	var record MountRecord
+	if err := c.do(ctx, http.MethodGet, "/api/v2/mounts/"+url.PathEscape(id), nil, nil, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// LeakedMounts 列出不被任何快照引用的挂载和 loop 设备
+func (c *Client) LeakedMounts(ctx context.Context) ([]LeakedMount, error) {
+	var leaks []LeakedMount
+	if err := c.do(ctx, http.MethodGet, "/api/v2/mounts/leaked", nil, nil, &leaks); err != nil {
+		return nil, err
+	}
+	return leaks, nil
+}
+
+// CleanupLeakedMounts 强制卸载 paths 中仍然泄漏的挂载并解除 loop 设备,返回实际清理的条目
+func (c *Client) CleanupLeakedMounts(ctx context.Context, paths []string) ([]LeakedMount, error) {
+	var cleaned []LeakedMount
+	req := CleanupLeakedMountsRequest{Paths: paths, Confirm: true}
+	if err := c.do(ctx, http.MethodPost, "/api/v2/mounts/leaked", nil, req, &cleaned); err != nil {
+		return nil, err
+	}
+	return cleaned, nil
+}
+
+// FrozenSnapshots 列出已冻结的快照
+func (c *Client) FrozenSnapshots(ctx context.Context) ([]FrozenSnapshot, error) {
+	var frozen []FrozenSnapshot
+	if err := c.do(ctx, http.MethodGet, "/api/v2/snapshots/frozen", nil, nil, &frozen); err != nil {
+		return nil, err
+	}
+	return frozen, nil
+}
+
+// FreezeSnapshot 写回快照 upperdir 的脏数据并按需冻结其 overlay 挂载,到期前需调用 ThawSnapshot
+func (c *Client) FreezeSnapshot(ctx context.Context, req FreezeRequest) (*FrozenSnapshot, error) {
+	var frozen FrozenSnapshot
+	if err := c.do(ctx, http.MethodPost, "/api/v2/snapshots/freeze", nil, req, &frozen); err != nil {
+		return nil, err
+	}
+	return &frozen, nil
+}
+
+// ThawSnapshot 解冻快照
+func (c *Client) ThawSnapshot(ctx context.Context, key string) (*FrozenSnapshot, error) {
+	var thawed FrozenSnapshot
+	if err := c.do(ctx, http.MethodPost, "/api/v2/snapshots/thaw", nil, ThawRequest{Key: key}, &thawed); err != nil {
+		return nil, err
+	}
+	return &thawed, nil
+}
+
+// NegativeCache 返回镜像仓库负查找缓存的条目数和命中统计
+func (c *Client) NegativeCache(ctx context.Context) (*NegativeCacheStats, error) {
+	var stats NegativeCacheStats
+	if err := c.do(ctx, http.MethodGet, "/api/v2/cache/negative", nil, nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
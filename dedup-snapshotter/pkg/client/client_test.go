@@ -0,0 +1,100 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestClientDecodesEnvelope 验证客户端编码查询参数、带上 api.token、解开响应外层,并把失败响应转换为 *Error
+func TestClientDecodesEnvelope(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if got := r.Header.Get("Authorization"); got != "Bearer t0ken" {
+			t.Errorf("unexpected authorization %q", got)
+		}
+		switch r.URL.Path {
+		case "/api/v2/audit/logs":
+			if got := r.URL.Query().Get("q"); got != "timeout layer" {
+				t.Errorf("unexpected search %q", got)
+			}
+			if got := r.URL.Query().Get("group_by"); got != "" {
+				w.Write([]byte(`{"success":true,"data":{"group_by":"user","groups":[{"key":"api","count":3,"failures":1}]}}`))
+				return
+			}
+			w.Write([]byte(`{"success":true,"data":[{"id":1,"operation":"image_convert","result":"success"}]}`))
+		case "/api/v2/images/convert/missing":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"success":false,"error":{"code":"not_found","message":"conversion job not found","details":{"id":"missing"}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	c := NewWithHTTPClient(strings.TrimPrefix(ts.URL, "http://"), ts.Client())
+	c.SetToken("t0ken")
+	ctx := context.Background()
+
+	entries, err := c.AuditLogs(ctx, AuditQuery{Search: "timeout layer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Operation != "image_convert" {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+
+	groups, err := c.AuditGroups(ctx, AuditQuery{Search: "timeout layer"}, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0].Count != 3 || groups[0].Failures != 1 {
+		t.Errorf("unexpected groups %+v", groups)
+	}
+
+	_, err = c.ConversionJob(ctx, "missing")
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.Code != CodeNotFound || apiErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected not_found error, got %v", err)
+	}
+
+	if _, err := c.Health(ctx); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected error for non-JSON 404, got %v", err)
+	}
+	t.Logf("✓ 客户端解码成功响应,失败响应返回 %v", err)
+}
+
+// TestOpenAPIReferences 验证 OpenAPI 描述中的 $ref 都指向已生成的 schema
+func TestOpenAPIReferences(t *testing.T) {
+	spec := OpenAPI("/var/lib/dedup")
+	data, err := json.Marshal(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schemas := spec["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	for _, name := range []string{"Config", "ConversionJob", "Error", "StartupTrace"} {
+		if schemas[name] == nil {
+			t.Errorf("missing schema %s", name)
+		}
+	}
+
+	const prefix = `"$ref":"#/components/schemas/`
+	for rest := string(data); ; {
+		i := strings.Index(rest, prefix)
+		if i < 0 {
+			break
+		}
+		rest = rest[i+len(prefix):]
+		name := rest[:strings.Index(rest, `"`)]
+		if schemas[name] == nil {
+			t.Errorf("dangling reference to %s", name)
+		}
+	}
+
+	t.Logf("✓ OpenAPI 描述中的 %d 个 schema 引用均有效", len(schemas))
+}
@@ -0,0 +1,285 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+)
+
+// endpoint 描述一个 API 端点,OpenAPI 描述由端点表和客户端类型生成,与客户端方法保持一致
+type endpoint struct {
+	method   string
+	path     string
+	summary  string
+	query    []string
+	request  interface{}
+	response interface{}
+	status   int
+	// csv 为 true 时端点在 format=csv 时返回 text/csv
+	csv bool
+}
+
+var auditQuery = []string{"start_time", "end_time", "since", "operation", "target", "user", "result", "operation_id", "q", "limit", "offset", "format"}
+
+var endpoints = []endpoint{
+	{method: http.MethodGet, path: "/api/v2/audit/logs", summary: "查询审计日志,指定 group_by 时返回聚合结果", query: append(auditQuery, "group_by"), response: []AuditEntry{}, csv: true},
+	{method: http.MethodGet, path: "/api/v2/audit/stats", summary: "审计库汇总统计", response: map[string]interface{}{}},
+	{method: http.MethodGet, path: "/api/v2/stats/history", summary: "降采样后的指标序列", query: []string{"window", "step"}, response: StatsHistory{}},
+	{method: http.MethodGet, path: "/api/v2/stats/chargeback", summary: "按镜像和命名空间的流量分摊", query: []string{"window", "namespace"}, response: ChargebackReport{}},
+	{method: http.MethodPost, path: "/api/v2/stats/chargeback/reset", summary: "关闭统计窗口并开始新窗口", response: ChargebackWindow{}},
+	{method: http.MethodGet, path: "/api/v2/stats/baseline", summary: "列出去重统计基线", response: []DedupBaseline{}},
+	{method: http.MethodPost, path: "/api/v2/stats/baseline", summary: "记录去重统计基线", request: BaselineRequest{}, response: DedupBaseline{}, status: http.StatusCreated},
+	{method: http.MethodGet, path: "/api/v2/stats/baseline/{name}", summary: "当前去重统计相对基线的变化", response: BaselineReport{}},
+	{method: http.MethodGet, path: "/api/v2/config", summary: "当前配置", response: config.Config{}},
+	{method: http.MethodPut, path: "/api/v2/config", summary: "校验并保存配置", request: config.Config{}, response: configResult{}},
+	{method: http.MethodPost, path: "/api/v2/config/reload", summary: "从配置文件重新加载配置", response: configResult{}},
+	{method: http.MethodGet, path: "/api/v2/config/schema", summary: "配置的 JSON schema", response: map[string]interface{}{}},
+	{method: http.MethodGet, path: "/api/version", summary: "支持的 API 版本和兼容性约定", response: APIVersions{}},
+	{method: http.MethodGet, path: "/api/v2/health", summary: "健康状态", response: Health{}},
+	{method: http.MethodGet, path: "/api/v2/images/convert", summary: "列出转换任务", response: []ConversionJob{}},
+	{method: http.MethodPost, path: "/api/v2/images/convert", summary: "提交转换任务", request: ConvertRequest{}, response: ConversionJob{}, status: http.StatusAccepted},
+	{method: http.MethodGet, path: "/api/v2/images/convert/{id}", summary: "查询转换或重排任务", response: ConversionJob{}},
+	{method: http.MethodPost, path: "/api/v2/images/relayout", summary: "按访问顺序重建镜像", request: RelayoutRequest{}, response: ConversionJob{}, status: http.StatusAccepted},
+	{method: http.MethodPost, path: "/api/v2/push/plan", summary: "计算本地构建的层中已存在于镜像仓库或本地的数据", request: PushPlanRequest{}, response: PushPlan{}},
+	{method: http.MethodPost, path: "/api/v2/images/pull", summary: "拉取并物化镜像", request: PullRequest{}, response: PullResult{}},
+	{method: http.MethodPost, path: "/api/v2/images/upgrade", summary: "计算镜像升级的 chunk 差异并预取新增 chunk", request: UpgradeRequest{}, response: UpgradePlan{}},
+	{method: http.MethodPost, path: "/api/v2/images/import", summary: "从 OCI layout 或镜像 tar 包导入镜像", request: ImportRequest{}, response: ConversionJob{}, status: http.StatusAccepted},
+	{method: http.MethodGet, path: "/api/v2/pods", summary: "列出 pod 的镜像绑定挂载", response: []BindMount{}},
+	{method: http.MethodPost, path: "/api/v2/pods/{id}/binds", summary: "把镜像绑定挂载到 pod", request: BindRequest{}, response: BindMount{}, status: http.StatusCreated},
+	{method: http.MethodDelete, path: "/api/v2/pods/{id}", summary: "释放 pod 的绑定挂载", response: map[string]string{}},
+	{method: http.MethodGet, path: "/api/v2/startup", summary: "列出冷启动追踪", response: []StartupTrace{}},
+	{method: http.MethodPost, path: "/api/v2/startup/{id}", summary: "标记容器已启动", response: StartupTrace{}},
+	{method: http.MethodGet, path: "/api/v2/prefetch", summary: "列出进行中的预取任务", response: []PrefetchStatus{}},
+	{method: http.MethodPost, path: "/api/v2/prefetch", summary: "按 trace 启动预取", request: PrefetchRequest{}, response: map[string]string{}, status: http.StatusAccepted},
+	{method: http.MethodPost, path: "/api/v2/prefetch/merge", summary: "合并多次运行的 trace 为预取计划", request: TraceMergeRequest{}, response: PrefetchPlan{}},
+	{method: http.MethodGet, path: "/api/v2/prefetch/profiles", summary: "列出 trace 配置", response: []TraceProfile{}},
+	{method: http.MethodDelete, path: "/api/v2/prefetch/profiles/{profile}", summary: "删除 trace 配置", response: map[string]string{}},
+	{method: http.MethodPut, path: "/api/v2/prefetch/profiles/{profile}/{image}", summary: "保存 trace 配置中镜像的 trace", request: TraceProfileRequest{}, response: map[string]interface{}{}},
+	{method: http.MethodDelete, path: "/api/v2/prefetch/profiles/{profile}/{image}", summary: "删除 trace 配置中镜像的 trace", response: map[string]string{}},
+	{method: http.MethodPost, path: "/api/v2/gc/volumes", summary: "清理孤儿 fscache 卷,async=true 时提交后台任务并返回 Job", query: []string{"async"}, response: volumeGCResult{}},
+	{method: http.MethodGet, path: "/api/v2/cache/negative", summary: "负查找缓存统计", response: NegativeCacheStats{}},
+	{method: http.MethodGet, path: "/api/v2/backends", summary: "各 chunk 存储后端的统计和健康状态", response: BackendHealth{}},
+	{method: http.MethodGet, path: "/api/v2/usage", summary: "按镜像和命名空间统计独占与共享空间", query: []string{"namespace"}, response: UsageReport{}},
+	{method: http.MethodGet, path: "/api/v2/mounts", summary: "列出各快照每层的挂载方式和回退原因", response: []MountRecord{}},
+	{method: http.MethodGet, path: "/api/v2/mounts/{id}", summary: "查询快照的挂载记录", response: MountRecord{}},
+	{method: http.MethodGet, path: "/api/v2/mounts/leaked", summary: "列出不被任何快照引用的挂载和 loop 设备", response: []LeakedMount{}},
+	{method: http.MethodPost, path: "/api/v2/mounts/leaked", summary: "强制卸载经确认的泄漏挂载", request: CleanupLeakedMountsRequest{}, response: []LeakedMount{}},
+	{method: http.MethodGet, path: "/api/v2/snapshots/frozen", summary: "列出已冻结的快照", response: []FrozenSnapshot{}},
+	{method: http.MethodPost, path: "/api/v2/snapshots/freeze", summary: "为备份静默快照", request: FreezeRequest{}, response: FrozenSnapshot{}},
+	{method: http.MethodPost, path: "/api/v2/snapshots/thaw", summary: "解冻快照", request: ThawRequest{}, response: FrozenSnapshot{}},
+	{method: http.MethodGet, path: "/api/v2/jobs", summary: "列出持久化的长时间任务", query: []string{"type", "state", "limit"}, response: []Job{}},
+	{method: http.MethodGet, path: "/api/v2/jobs/{id}", summary: "查询任务及其日志", response: Job{}},
+	{method: http.MethodDelete, path: "/api/v2/jobs/{id}", summary: "取消排队中或运行中的任务", response: Job{}, status: http.StatusAccepted},
+	{method: http.MethodGet, path: "/api/v2/retention", summary: "查询最近一次镜像保留规则检查的报告", response: RetentionReport{}},
+	{method: http.MethodPost, path: "/api/v2/retention", summary: "立即按保留规则检查镜像,可只报告不删除", request: RetentionRequest{}, response: RetentionReport{}},
+	{method: http.MethodGet, path: "/api/v2/warmup", summary: "查询节点按集群镜像热度进行的缓存预热结果", response: WarmupStatus{}},
+	{method: http.MethodPost, path: "/api/v2/warmup", summary: "按热度列表重新预热,为部署最多的镜像提交低优先级拉取任务", response: WarmupStatus{}, status: http.StatusAccepted},
+	{method: http.MethodGet, path: "/api/v2/maintenance", summary: "查询维护窗口的状态", response: MaintenanceStatus{}},
+	{method: http.MethodPost, path: "/api/v2/maintenance", summary: "手动开启、关闭维护窗口或取消手动覆盖", request: MaintenanceRequest{}, response: MaintenanceStatus{}},
+	{method: http.MethodGet, path: "/api/v2/durability", summary: "查询 chunk 持久化层的冗余方式和后端状态", response: DurabilityStatus{}},
+	{method: http.MethodPost, path: "/api/v2/durability", summary: "提交补齐持久化层中缺失副本和分片的任务", response: Job{}, status: http.StatusAccepted},
+	{method: http.MethodPost, path: "/api/v2/webhooks/registry", summary: "接收 Harbor 或 distribution 的推送通知并预拉取镜像", request: map[string]interface{}{}, response: WebhookResult{}, status: http.StatusAccepted},
+}
+
+type volumeGCResult struct {
+	Removed int `json:"removed"`
+}
+
+// OpenAPI 生成管理 API 的 OpenAPI 3.0 描述,root 用于配置 schema 中路径字段的默认值
+func OpenAPI(root string) map[string]interface{} {
+	g := &specGenerator{root: root, components: map[string]interface{}{}}
+
+	paths := map[string]interface{}{}
+	for _, ep := range endpoints {
+		item, ok := paths[ep.path].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[ep.path] = item
+		}
+		item[strings.ToLower(ep.method)] = g.operation(ep)
+	}
+
+	g.components["Error"] = g.schema(reflect.TypeOf(Error{}))
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "dedup-snapshotter management API",
+			"version": "v2",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": g.components,
+		},
+	}
+}
+
+type specGenerator struct {
+	root       string
+	components map[string]interface{}
+}
+
+func (g *specGenerator) operation(ep endpoint) map[string]interface{} {
+	var params []interface{}
+	for _, seg := range strings.Split(ep.path, "/") {
+		if name, ok := strings.CutPrefix(seg, "{"); ok {
+			params = append(params, map[string]interface{}{
+				"name": strings.TrimSuffix(name, "}"), "in": "path", "required": true, "schema": map[string]string{"type": "string"},
+			})
+		}
+	}
+	for _, name := range ep.query {
+		params = append(params, map[string]interface{}{
+			"name": name, "in": "query", "schema": map[string]string{"type": "string"},
+		})
+	}
+
+	content := map[string]interface{}{
+		"application/json": map[string]interface{}{
+			"schema": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"success": map[string]string{"type": "boolean"},
+					"data":    g.schema(reflect.TypeOf(ep.response)),
+				},
+			},
+		},
+	}
+	if ep.csv {
+		content["text/csv"] = map[string]interface{}{
+			"schema": map[string]string{"type": "string"},
+		}
+	}
+
+	status := ep.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	op := map[string]interface{}{
+		"summary": ep.summary,
+		"responses": map[string]interface{}{
+			strconv.Itoa(status): map[string]interface{}{
+				"description": http.StatusText(status),
+				"content":     content,
+			},
+			"default": map[string]interface{}{
+				"description": "error",
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": map[string]interface{}{
+							"type": "object",
+							"properties": map[string]interface{}{
+								"success": map[string]string{"type": "boolean"},
+								"error":   map[string]string{"$ref": "#/components/schemas/Error"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+	if ep.request != nil {
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{
+					"schema": g.schema(reflect.TypeOf(ep.request)),
+				},
+			},
+		}
+	}
+	return op
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+	rawType      = reflect.TypeOf(json.RawMessage{})
+	configType   = reflect.TypeOf(config.Config{})
+)
+
+// schema 返回类型的 schema,具名结构体放入 components 并以 $ref 引用。
+// config.Config 使用 config.Schema,包含默认值和取值范围
+func (g *specGenerator) schema(t reflect.Type) map[string]interface{} {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t {
+	case rawType:
+		return map[string]interface{}{}
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case durationType:
+		return map[string]interface{}{"type": "integer", "format": "int64", "description": "nanoseconds"}
+	case configType:
+		if _, ok := g.components["Config"]; !ok {
+			s := config.Schema(g.root)
+			delete(s, "$schema")
+			g.components["Config"] = s
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/Config"}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		name := t.Name()
+		if name != "" {
+			name = strings.ToUpper(name[:1]) + name[1:]
+		}
+		if _, ok := g.components[name]; !ok && name != "" {
+			// 先占位,防止递归类型无限展开
+			g.components[name] = nil
+			g.components[name] = g.structSchema(t)
+		}
+		if name == "" {
+			return g.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Interface:
+		return map[string]interface{}{}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	default:
+		return map[string]interface{}{"type": "string"}
+	}
+}
+
+func (g *specGenerator) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Name
+		if tag := f.Tag.Get("json"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			if n := strings.Split(tag, ",")[0]; n != "" {
+				name = n
+			}
+		}
+		properties[name] = g.schema(f.Type)
+	}
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
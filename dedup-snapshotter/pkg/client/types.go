@@ -0,0 +1,616 @@
+package client
+
+import "time"
+
+// 以下类型与服务端响应的 JSON 结构一致。客户端不引用 storage、audit 等服务端包,
+// 避免把 containerd 和 sqlite 依赖带给调用方
+
+// APIVersionInfo 描述一个 API 版本,Status 为 "stable" 或 "deprecated",已弃用的版本在 Sunset 之后可能被移除
+type APIVersionInfo struct {
+	Version    string     `json:"version"`
+	Prefix     string     `json:"prefix"`
+	Status     string     `json:"status"`
+	Deprecated *time.Time `json:"deprecated,omitempty"`
+	Sunset     *time.Time `json:"sunset,omitempty"`
+	Successor  string     `json:"successor,omitempty"`
+}
+
+// APIVersions 是服务支持的 API 版本,Current 为推荐使用的版本
+type APIVersions struct {
+	Current  string           `json:"current"`
+	Versions []APIVersionInfo `json:"versions"`
+	Policy   string           `json:"policy"`
+}
+
+// AuditEntry 是一条审计日志
+type AuditEntry struct {
+	ID        int64     `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Operation string    `json:"operation"`
+	Target    string    `json:"target"`
+	User      string    `json:"user"`
+	PID       int       `json:"pid"`
+	Details   string    `json:"details"`
+	Result    string    `json:"result"`
+	Error     string    `json:"error,omitempty"`
+	Duration  int64     `json:"duration_ms"`
+	// OperationID 与同一快照 RPC 的日志中的 op_id 字段相同
+	OperationID string `json:"operation_id,omitempty"`
+}
+
+// AuditGroup 是按某一维度聚合的审计日志计数
+type AuditGroup struct {
+	Key      string    `json:"key"`
+	Count    int64     `json:"count"`
+	Failures int64     `json:"failures"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// StatsSample 是一个时间桶内的指标平均值
+type StatsSample struct {
+	Timestamp           time.Time `json:"timestamp"`
+	DedupRatio          float64   `json:"dedup_ratio"`
+	CacheHitRate        float64   `json:"cache_hit_rate"`
+	StorageUsedBytes    int64     `json:"storage_used_bytes"`
+	StorageUsagePercent float64   `json:"storage_usage_percent"`
+	Samples             int64     `json:"samples,omitempty"`
+}
+
+// StatsHistory 是降采样后的指标序列,Window 和 Step 为 Go duration 字符串
+type StatsHistory struct {
+	Window string        `json:"window"`
+	Step   string        `json:"step"`
+	Points []StatsSample `json:"points"`
+}
+
+// Alert 是正在触发的告警
+type Alert struct {
+	Name      string    `json:"name"`
+	State     string    `json:"state"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Message   string    `json:"message"`
+	Since     time.Time `json:"since"`
+}
+
+// Health 是服务健康状态,Status 为 healthy 或 degraded
+type Health struct {
+	Status    string    `json:"status"`
+	Timestamp time.Time `json:"timestamp"`
+	Version   string    `json:"version"`
+	Alerts    []Alert   `json:"alerts,omitempty"`
+}
+
+// ConvertRequest 指定源目录或按 digest 固定的镜像引用,二者选一。
+// Priority 为 low、normal 或 high,省略时使用镜像上的优先级注解
+type ConvertRequest struct {
+	Source   string `json:"source,omitempty"`
+	ImageID  string `json:"image_id,omitempty"`
+	ImageRef string `json:"image_ref,omitempty"`
+	Priority string `json:"priority,omitempty"`
+}
+
+// RelayoutRequest 按访问顺序重建镜像,Order 省略时使用已记录的顺序
+type RelayoutRequest struct {
+	ImageID string   `json:"image_id"`
+	Order   []string `json:"order,omitempty"`
+}
+
+// ImportRequest 指定节点上的 OCI layout 目录、OCI 归档或 docker save tar 包,Path 必须为绝对路径
+type ImportRequest struct {
+	Path string `json:"path"`
+}
+
+// PullRequest 指定要拉取并物化的镜像,Priority 同时提升同一仓库排队中的转换任务
+type PullRequest struct {
+	ImageRef string `json:"image_ref"`
+	Priority string `json:"priority,omitempty"`
+}
+
+// PrefetchRequest 按节点 trace 目录中的 trace 预取镜像,Filter 为空时使用节点预取策略文件中为镜像定义的过滤
+type PrefetchRequest struct {
+	ImageID string          `json:"image_id"`
+	Trace   string          `json:"trace"`
+	Filter  *PrefetchFilter `json:"filter,omitempty"`
+}
+
+// PrefetchFilter 限定预取的文件:Include/Exclude 为 glob(不含 "/" 时匹配文件名,以 "/**" 结尾时匹配
+// 目录下全部文件),MaxFileMB 大于 0 时每个文件只预取开头的 N MB
+type PrefetchFilter struct {
+	Include     []string `json:"include,omitempty"`
+	Exclude     []string `json:"exclude,omitempty"`
+	MaxFileMB   int64    `json:"max_file_mb,omitempty"`
+	SkipLocales bool     `json:"skip_locales,omitempty"`
+	SkipDocs    bool     `json:"skip_docs,omitempty"`
+}
+
+// TraceMergeRequest 合并节点 trace 目录中同一镜像多次运行的 trace
+type TraceMergeRequest struct {
+	ImageID      string   `json:"image_id"`
+	Traces       []string `json:"traces"`
+	MinFrequency float64  `json:"min_frequency,omitempty"`
+}
+
+// TraceProfile 是一个 trace 配置及其包含 trace 的镜像
+type TraceProfile struct {
+	Name   string   `json:"name"`
+	Images []string `json:"images"`
+}
+
+// TraceProfileRequest 是 trace 配置中一个镜像的 trace,按预取顺序排列的 chunk 哈希
+type TraceProfileRequest struct {
+	Chunks []string `json:"chunks"`
+}
+
+// PlanEntry 是预取计划中的一个 chunk,Frequency 为出现的运行占比,Position 为期望相对位置
+type PlanEntry struct {
+	ChunkHash string  `json:"chunk_hash"`
+	Frequency float64 `json:"frequency"`
+	Position  float64 `json:"position"`
+}
+
+// PrefetchPlan 是合并后的预取计划,Trace 为可用于 StartPrefetch 的 trace ID,Path 为其在节点上的路径
+type PrefetchPlan struct {
+	Runs    int         `json:"runs"`
+	Chunks  []PlanEntry `json:"chunks"`
+	Dropped int         `json:"dropped,omitempty"`
+	Path    string      `json:"path,omitempty"`
+	Trace   string      `json:"trace,omitempty"`
+}
+
+// ConversionJob 是转换、重排或预拉取任务,State 为 queued、running、completed 或 failed
+type ConversionJob struct {
+	ID         string    `json:"id"`
+	Source     string    `json:"source,omitempty"`
+	ImageRef   string    `json:"image_ref,omitempty"`
+	Relayout   bool      `json:"relayout,omitempty"`
+	Pull       bool      `json:"pull,omitempty"`
+	Import     bool      `json:"import,omitempty"`
+	ImageID    string    `json:"image_id,omitempty"`
+	Priority   int       `json:"priority,omitempty"`
+	State      string    `json:"state"`
+	Progress   float64   `json:"progress"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	StartedAt  time.Time `json:"started_at,omitempty"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+}
+
+// LayerPullStats 是拉取中单个层的下载和复用情况
+type LayerPullStats struct {
+	Digest       string `json:"digest"`
+	Size         int64  `json:"size"`
+	Cached       bool   `json:"cached"`
+	Chunks       int    `json:"chunks"`
+	ReusedChunks int    `json:"reused_chunks"`
+	FetchedBytes int64  `json:"fetched_bytes"`
+	ReusedBytes  int64  `json:"reused_bytes"`
+}
+
+// PullResult 汇总一次拉取的结果
+type PullResult struct {
+	Ref          string           `json:"ref"`
+	Digest       string           `json:"digest"`
+	Layers       []LayerPullStats `json:"layers"`
+	FetchedBytes int64            `json:"fetched_bytes"`
+	ReusedBytes  int64            `json:"reused_bytes"`
+}
+
+// PushPlanRequest 指定节点上本地构建好的层 blob(tar 或压缩 tar)
+type PushPlanRequest struct {
+	LayerPath string `json:"layer_path"`
+}
+
+// PushRange 是层中一段已存在于镜像仓库(Source 为 registry)或本地 chunk 存储中的数据
+type PushRange struct {
+	Offset      int64  `json:"offset"`
+	Size        int64  `json:"size"`
+	Source      string `json:"source"`
+	LayerDigest string `json:"layer_digest,omitempty"`
+	Chunks      int    `json:"chunks"`
+}
+
+// PushPlan 描述本地构建的层有多少数据已存在于镜像仓库或共享存储中
+type PushPlan struct {
+	Digest        string      `json:"digest"`
+	DiffID        string      `json:"diff_id"`
+	Size          int64       `json:"size"`
+	ChunkSize     int64       `json:"chunk_size"`
+	MountFrom     []string    `json:"mount_from,omitempty"`
+	Ranges        []PushRange `json:"ranges"`
+	RegistryBytes int64       `json:"registry_bytes"`
+	LocalBytes    int64       `json:"local_bytes"`
+	MissingBytes  int64       `json:"missing_bytes"`
+}
+
+// BindRequest 把镜像绑定挂载到 pod,Target 为空时挂载到 pod 目录下
+type BindRequest struct {
+	ImageID     string `json:"image_id"`
+	Target      string `json:"target,omitempty"`
+	Propagation string `json:"propagation,omitempty"`
+}
+
+// BindMount 是 pod 持有的镜像绑定挂载
+type BindMount struct {
+	PodID       string    `json:"pod_id"`
+	ImageID     string    `json:"image_id"`
+	Source      string    `json:"source"`
+	Target      string    `json:"target"`
+	Propagation string    `json:"propagation"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// UpgradeRequest 指定节点上运行的镜像和升级目标,DryRun 为 true 时只计算差异
+type UpgradeRequest struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	DryRun bool   `json:"dry_run"`
+}
+
+// UpgradeLayer 是升级目标的一个层相对源镜像的差异
+type UpgradeLayer struct {
+	Digest     string `json:"digest"`
+	Size       int64  `json:"size"`
+	Shared     bool   `json:"shared"`
+	Cached     bool   `json:"cached"`
+	Planned    bool   `json:"planned"`
+	Chunks     int    `json:"chunks"`
+	NewChunks  int    `json:"new_chunks"`
+	DeltaBytes int64  `json:"delta_bytes"`
+}
+
+// UpgradePlan 是两个镜像 chunk 清单的差异,DeltaBytes 为升级需要下载的字节数,Pull 为预取的实际下载情况
+type UpgradePlan struct {
+	From       string         `json:"from"`
+	To         string         `json:"to"`
+	FromDigest string         `json:"from_digest"`
+	ToDigest   string         `json:"to_digest"`
+	Layers     []UpgradeLayer `json:"layers"`
+	Chunks     int            `json:"chunks"`
+	NewChunks  int            `json:"new_chunks"`
+	TotalBytes int64          `json:"total_bytes"`
+	DeltaBytes int64          `json:"delta_bytes"`
+	Pull       *PullResult    `json:"pull,omitempty"`
+}
+
+// StartupTrace 是一次容器冷启动的追踪,时长字段为纳秒
+type StartupTrace struct {
+	Key              string        `json:"key"`
+	Images           []string      `json:"images"`
+	MountedAt        time.Time     `json:"mounted_at"`
+	PrefetchCoverage float64       `json:"prefetch_coverage"`
+	FirstRead        time.Duration `json:"first_read,omitempty"`
+	Faults           int64         `json:"faults"`
+	FaultBytes       int64         `json:"fault_bytes"`
+	BytesFetched     int64         `json:"bytes_fetched"`
+	ColdStart        time.Duration `json:"cold_start,omitempty"`
+	Started          bool          `json:"started"`
+	Done             bool          `json:"done"`
+}
+
+// PrefetchStatus 是进行中的预取任务,Elapsed 为纳秒
+type PrefetchStatus struct {
+	ImageID      string
+	TotalEntries int
+	Completed    int
+	Skipped      int
+	Filtered     int
+	Paused       bool
+	Progress     float64
+	StartTime    time.Time
+	Elapsed      time.Duration
+}
+
+// NegativeCacheStats 是镜像仓库负查找缓存的统计,TTL 为纳秒
+type NegativeCacheStats struct {
+	Entries       int           `json:"entries"`
+	TTL           time.Duration `json:"ttl"`
+	Hits          int64         `json:"hits"`
+	Misses        int64         `json:"misses"`
+	Invalidations int64         `json:"invalidations"`
+}
+
+// BackendStats 是单个 chunk 存储后端的统计,Kind 为 local、content-store、mirror 或 registry,
+// HitRate 和 ErrorRate 为百分比
+type BackendStats struct {
+	Backend           string    `json:"backend"`
+	Kind              string    `json:"kind"`
+	Reads             int64     `json:"reads"`
+	Writes            int64     `json:"writes"`
+	Errors            int64     `json:"errors"`
+	ErrorRate         float64   `json:"error_rate"`
+	Hits              int64     `json:"hits"`
+	Misses            int64     `json:"misses"`
+	HitRate           float64   `json:"hit_rate"`
+	BytesRead         int64     `json:"bytes_read"`
+	BytesWritten      int64     `json:"bytes_written"`
+	Healthy           bool      `json:"healthy"`
+	ConsecutiveErrors int64     `json:"consecutive_errors"`
+	LastError         string    `json:"last_error,omitempty"`
+	LastErrorTime     time.Time `json:"last_error_time,omitempty"`
+	LastSuccess       time.Time `json:"last_success,omitempty"`
+}
+
+// BackendHealth 是各 chunk 存储后端的健康状态,Status 为 healthy 或 degraded
+type BackendHealth struct {
+	Status   string         `json:"status"`
+	Backends []BackendStats `json:"backends"`
+}
+
+// UsageEntry 是一个镜像或命名空间的空间占用,ExclusiveBytes 为删除后实际可释放的字节数
+type UsageEntry struct {
+	Name           string `json:"name"`
+	Namespace      string `json:"namespace,omitempty"`
+	Layers         int    `json:"layers"`
+	Chunks         int64  `json:"chunks"`
+	TotalBytes     int64  `json:"total_bytes"`
+	ExclusiveBytes int64  `json:"exclusive_bytes"`
+	SharedBytes    int64  `json:"shared_bytes"`
+}
+
+// UsageReport 是去重感知的 du 结果,条目按可释放空间从大到小排列
+type UsageReport struct {
+	Images     []UsageEntry `json:"images"`
+	Namespaces []UsageEntry `json:"namespaces"`
+}
+
+// ChargebackEntry 是镜像或命名空间在统计窗口内的流量和当前独占的空间
+type ChargebackEntry struct {
+	Name             string `json:"name"`
+	Namespace        string `json:"namespace,omitempty"`
+	Layers           int    `json:"layers"`
+	DownloadedBytes  int64  `json:"downloaded_bytes"`
+	CacheServedBytes int64  `json:"cache_served_bytes"`
+	ExclusiveBytes   int64  `json:"exclusive_bytes"`
+}
+
+// ChargebackReport 是一个统计窗口的流量分摊报告,条目按下载量从大到小排列
+type ChargebackReport struct {
+	Start      time.Time         `json:"start"`
+	End        time.Time         `json:"end,omitempty"`
+	Images     []ChargebackEntry `json:"images"`
+	Namespaces []ChargebackEntry `json:"namespaces"`
+}
+
+// ChargebackWindow 是重置时关闭的统计窗口
+type ChargebackWindow struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Layers int       `json:"layers"`
+}
+
+// BaselineRequest 指定要记录的去重统计基线名
+type BaselineRequest struct {
+	Name string `json:"name"`
+}
+
+// ChunkTierStats 是一个 chunk 分层的去重统计
+type ChunkTierStats struct {
+	Tier        string  `json:"tier"`
+	Chunks      int64   `json:"chunks"`
+	StoredSize  int64   `json:"stored_bytes"`
+	LogicalSize int64   `json:"logical_bytes"`
+	DedupRatio  float64 `json:"dedup_ratio"`
+}
+
+// DedupBaseline 是某一时刻整个存储的去重统计,Settings 为采集时影响去重效果的配置
+type DedupBaseline struct {
+	Name         string            `json:"name,omitempty"`
+	Time         time.Time         `json:"time"`
+	Settings     map[string]string `json:"settings"`
+	Chunks       int64             `json:"chunks"`
+	LogicalBytes int64             `json:"logical_bytes"`
+	StoredBytes  int64             `json:"stored_bytes"`
+	ZeroBytes    int64             `json:"zero_bytes"`
+	ImageBytes   int64             `json:"image_bytes"`
+	DedupRatio   float64           `json:"dedup_ratio"`
+	Tiers        []ChunkTierStats  `json:"tiers"`
+}
+
+// TierDelta 是一个 chunk 分层相对基线的变化
+type TierDelta struct {
+	Tier             string  `json:"tier"`
+	LogicalBytes     int64   `json:"logical_bytes"`
+	StoredBytes      int64   `json:"stored_bytes"`
+	DedupRatioChange float64 `json:"dedup_ratio_change"`
+}
+
+// BaselineReport 是当前统计相对基线的变化。SavedBytes 是基线之后新增的数据比按基线去重率
+// 少占用的字节数,Changed 为与基线不同的配置及其当前值
+type BaselineReport struct {
+	Baseline         DedupBaseline     `json:"baseline"`
+	Current          DedupBaseline     `json:"current"`
+	LogicalBytes     int64             `json:"logical_bytes"`
+	StoredBytes      int64             `json:"stored_bytes"`
+	ZeroBytes        int64             `json:"zero_bytes"`
+	ImageBytes       int64             `json:"image_bytes"`
+	DedupRatioChange float64           `json:"dedup_ratio_change"`
+	SavedBytes       int64             `json:"saved_bytes"`
+	Changed          map[string]string `json:"changed"`
+	Tiers            []TierDelta       `json:"tiers"`
+}
+
+// FreezeRequest 静默活动快照,Key 为完整的快照 key 或 containerd 中的快照 key(通常为容器 ID)
+type FreezeRequest struct {
+	Key            string `json:"key"`
+	FSFreeze       bool   `json:"fsfreeze,omitempty"`
+	TimeoutSeconds int    `json:"timeout_seconds,omitempty"`
+}
+
+// ThawRequest 解冻快照
+type ThawRequest struct {
+	Key string `json:"key"`
+}
+
+// MountFallback 是层挂载放弃的一种方式,Reason 为 degraded、mount_failed 或 no_image
+type MountFallback struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+	Error  string `json:"error,omitempty"`
+}
+
+// LayerMount 是父层实际使用的挂载方式(fscache、loop 或 dir)和之前放弃的方式
+type LayerMount struct {
+	Layer     string          `json:"layer"`
+	Type      string          `json:"type"`
+	Path      string          `json:"path"`
+	Fallbacks []MountFallback `json:"fallbacks,omitempty"`
+}
+
+// MountRecord 是快照最近一次挂载的记录
+type MountRecord struct {
+	Snapshot string       `json:"snapshot"`
+	Strategy string       `json:"strategy"`
+	Type     string       `json:"type"`
+	Layers   []LayerMount `json:"layers"`
+	Time     time.Time    `json:"time"`
+}
+
+// LeakedMount 是不再被任何快照引用的挂载(Kind 为 mount)或 loop 设备(Kind 为 loop)
+type LeakedMount struct {
+	Kind     string `json:"kind"`
+	Path     string `json:"path"`
+	FSType   string `json:"fstype,omitempty"`
+	Source   string `json:"source,omitempty"`
+	Snapshot string `json:"snapshot,omitempty"`
+	Reason   string `json:"reason"`
+}
+
+// CleanupLeakedMountsRequest 强制卸载经确认的泄漏挂载,Confirm 必须为 true
+type CleanupLeakedMountsRequest struct {
+	Paths   []string `json:"paths"`
+	Confirm bool     `json:"confirm"`
+}
+
+// FrozenSnapshot 是为备份静默的快照,备份工具应在 ExpiresAt 前复制 UpperDir 并解冻
+type FrozenSnapshot struct {
+	Key        string    `json:"key"`
+	ID         string    `json:"id"`
+	UpperDir   string    `json:"upper_dir"`
+	MountPoint string    `json:"mount_point,omitempty"`
+	FSFrozen   bool      `json:"fs_frozen"`
+	FrozenAt   time.Time `json:"frozen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// WebhookResult 列出镜像仓库推送通知触发的预拉取任务
+type WebhookResult struct {
+	Jobs    []ConversionJob `json:"jobs"`
+	Skipped []string        `json:"skipped,omitempty"`
+}
+
+// Job 是持久化的长时间任务(转换、预取、卷 GC 等),State 为 queued、running、completed、failed 或 canceled,
+// 失败的任务在 MaxAttempts 内于 NextAttempt 重试。Logs 只在查询单个任务时返回
+type Job struct {
+	ID          string                 `json:"id"`
+	Type        string                 `json:"type"`
+	Target      string                 `json:"target,omitempty"`
+	State       string                 `json:"state"`
+	Progress    float64                `json:"progress"`
+	Params      map[string]interface{} `json:"params,omitempty"`
+	Error       string                 `json:"error,omitempty"`
+	Attempts    int                    `json:"attempts"`
+	MaxAttempts int                    `json:"max_attempts"`
+	CreatedAt   time.Time              `json:"created_at"`
+	StartedAt   time.Time              `json:"started_at,omitempty"`
+	FinishedAt  time.Time              `json:"finished_at,omitempty"`
+	NextAttempt time.Time              `json:"next_attempt,omitempty"`
+	Logs        []JobLogEntry          `json:"logs,omitempty"`
+}
+
+// JobLogEntry 是任务执行过程中记录的一条日志
+type JobLogEntry struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// RetentionPolicy 是镜像保留规则,0 表示对应规则不生效
+type RetentionPolicy struct {
+	KeepLastTags int      `json:"keep_last_tags"`
+	UnusedDays   int      `json:"unused_days"`
+	Pinned       []string `json:"pinned,omitempty"`
+}
+
+// RetentionDecision 是对指向同一 digest 的所有镜像名的处理结果,Action 为 keep 或 delete
+type RetentionDecision struct {
+	Digest   string    `json:"digest"`
+	Names    []string  `json:"names"`
+	Action   string    `json:"action"`
+	Reason   string    `json:"reason"`
+	LastUsed time.Time `json:"last_used"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// RetentionReport 是一次保留规则检查的结果,DryRun 为 true 时没有删除任何镜像
+type RetentionReport struct {
+	Time    time.Time           `json:"time"`
+	DryRun  bool                `json:"dry_run"`
+	Policy  RetentionPolicy     `json:"policy"`
+	Kept    int                 `json:"kept"`
+	Deleted int                 `json:"deleted"`
+	Failed  int                 `json:"failed"`
+	Images  []RetentionDecision `json:"images"`
+}
+
+// RetentionRequest 立即按保留规则检查一次镜像
+type RetentionRequest struct {
+	DryRun bool `json:"dry_run"`
+}
+
+// WarmupImage 是一个热门镜像的预热结果,成功提交时 JobID 为拉取任务 ID
+type WarmupImage struct {
+	Ref         string `json:"ref"`
+	Deployments int64  `json:"deployments"`
+	JobID       string `json:"job_id,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// WarmupStatus 记录一次按集群镜像热度列表进行的缓存预热
+type WarmupStatus struct {
+	Feed          string        `json:"feed"`
+	FeedGenerated time.Time     `json:"feed_generated_at"`
+	Time          time.Time     `json:"time"`
+	Images        []WarmupImage `json:"images"`
+}
+
+// MaintenanceWindow 是一个维护窗口,从 cron 表达式 Schedule 给出的时刻开始,持续 Duration 秒
+type MaintenanceWindow struct {
+	Schedule string `json:"schedule"`
+	Duration int    `json:"duration"`
+}
+
+// MaintenanceStatus 是维护窗口的当前状态,Active 为 true 表示卷 GC、校验、扁平化等重任务可以全速运行
+type MaintenanceStatus struct {
+	Enabled       bool                `json:"enabled"`
+	Active        bool                `json:"active"`
+	Until         time.Time           `json:"until,omitempty"`
+	Next          time.Time           `json:"next,omitempty"`
+	Override      string              `json:"override,omitempty"`
+	OverrideUntil time.Time           `json:"override_until,omitempty"`
+	OutsideDelay  int                 `json:"outside_delay_ms"`
+	Windows       []MaintenanceWindow `json:"windows"`
+}
+
+// MaintenanceRequest 临时调整维护窗口,Action 为 open、close 或 clear
+type MaintenanceRequest struct {
+	Action   string `json:"action"`
+	Duration int    `json:"duration,omitempty"`
+}
+
+// DurabilityBackend 是持久化层的一个后端,Available 为 false 时 Error 给出原因
+type DurabilityBackend struct {
+	Name      string `json:"name"`
+	Path      string `json:"path,omitempty"`
+	Available bool   `json:"available"`
+	Error     string `json:"error,omitempty"`
+}
+
+// DurabilityStatus 是 chunk 持久化层的状态,Mode 为 replicate 时保存 Copies 份副本,
+// 为 erasure 时切成 DataShards 个数据分片和 ParityShards 个校验分片
+type DurabilityStatus struct {
+	Mode         string              `json:"mode"`
+	Copies       int                 `json:"copies,omitempty"`
+	DataShards   int                 `json:"data_shards,omitempty"`
+	ParityShards int                 `json:"parity_shards,omitempty"`
+	Backends     []DurabilityBackend `json:"backends"`
+}
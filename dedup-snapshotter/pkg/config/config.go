@@ -3,8 +3,13 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 
 	"github.com/containerd/log"
 )
@@ -14,19 +19,68 @@ type Config struct {
 	EnableErofs   bool          `json:"enable_erofs"`
 	EnableFscache bool          `json:"enable_fscache"`
 	EnableMemDedup bool         `json:"enable_mem_dedup"`
+	// MountNamespace 为执行 erofs/overlay 挂载的挂载命名空间,如 /proc/1/ns/mnt。
+	// 本进程运行在私有或 slave 挂载命名空间(systemd PrivateMounts、MountFlags=slave)时,
+	// 需指向 containerd 所在的命名空间,否则 shim 看不到这些挂载
+	MountNamespace string       `json:"mount_namespace"`
 	Registry      string        `json:"registry"`
 	ChunkSize     int64         `json:"chunk_size"`
+	// EnableSmallChunks 让小于 chunk_size 的文件按 64KB 左右的内容定义块参与去重
+	EnableSmallChunks bool      `json:"enable_small_chunks"`
 	LogLevel      string        `json:"log_level"`
 	Prefetch      PrefetchConfig `json:"prefetch"`
 	KSM           KSMConfig     `json:"ksm"`
 	Dedupd        DedupdConfig  `json:"dedupd"`
+	Flatten       FlattenConfig `json:"flatten"`
+	Overlay       OverlayConfig `json:"overlay"`
+	Conversion    ConversionConfig `json:"conversion"`
+	MetricsPush   MetricsPushConfig `json:"metrics_push"`
+	MetricsLabels MetricsLabelsConfig `json:"metrics_labels"`
+	Socket        SocketConfig  `json:"socket"`
+	FaultInjection FaultInjectionConfig `json:"fault_injection"`
+	Alerts        AlertsConfig  `json:"alerts"`
+	IncrementalChunk IncrementalChunkConfig `json:"incremental_chunk"`
+	BindMounts    BindMountsConfig `json:"bind_mounts"`
+	StatsHistory  StatsHistoryConfig `json:"stats_history"`
+	Recovery      RecoveryConfig `json:"recovery"`
+	MemDedup      MemDedupConfig `json:"mem_dedup"`
+	Timeouts      TimeoutsConfig `json:"timeouts"`
+	Store         StoreConfig   `json:"store"`
+	Signing       SigningConfig `json:"signing"`
+	StartupTrace  StartupTraceConfig `json:"startup_trace"`
+	Background    BackgroundConfig `json:"background"`
+	Mirror        MirrorConfig  `json:"mirror"`
+	Scratch       ScratchConfig `json:"scratch"`
+	RegistryClient RegistryClientConfig `json:"registry_client"`
+	Webhook       WebhookConfig `json:"webhook"`
+	Diff          DiffConfig    `json:"diff"`
+	Audit         AuditConfig   `json:"audit"`
+	Debug         DebugConfig   `json:"debug"`
+	ChunkCache    ChunkCacheConfig `json:"chunk_cache"`
+	BufferPool    BufferPoolConfig `json:"buffer_pool"`
+	Encryption    EncryptionConfig `json:"encryption"`
+	Scan          ScanConfig    `json:"scan"`
+	Accounting    AccountingConfig `json:"accounting"`
+	Proxy         ProxyConfig   `json:"proxy"`
+	SlowLog       SlowLogConfig `json:"slow_log"`
+	SELinux       SELinuxConfig `json:"selinux"`
+	DiskWatch     DiskWatchConfig `json:"disk_watch"`
+	Retention     RetentionConfig `json:"retention"`
+	Warmup        WarmupConfig  `json:"warmup"`
+	Maintenance   MaintenanceConfig `json:"maintenance"`
+	Durability    DurabilityConfig `json:"durability"`
+	Admission     AdmissionConfig `json:"admission"`
+	API           APIConfig     `json:"api"`
 }
 
+// PrefetchConfig 中 PolicyFile 为按镜像定义预取过滤(只预取匹配的文件、大文件只取开头、跳过语言包和文档)
+// 的 JSON 文件,每次预取时读取,修改后对之后的预取生效;为空时不过滤
 type PrefetchConfig struct {
 	Enabled     bool   `json:"enabled"`
 	Workers     int    `json:"workers"`
 	QueueSize   int    `json:"queue_size"`
 	TraceDir    string `json:"trace_dir"`
+	PolicyFile  string `json:"policy_file"`
 }
 
 type KSMConfig struct {
@@ -36,11 +90,472 @@ type KSMConfig struct {
 	MergeAcrossNodes bool `json:"merge_across_nodes"`
 }
 
+// DedupdConfig 中 OnDemand* 为按需读取的限速(MB/s),0 表示不限速
 type DedupdConfig struct {
 	Enabled       bool   `json:"enabled"`
 	Workers       int    `json:"workers"`
 	Registry      string `json:"registry"`
 	FscacheDomain string `json:"fscache_domain"`
+	OnDemandRateMB      int `json:"ondemand_rate_mb"`
+	OnDemandImageRateMB int `json:"ondemand_image_rate_mb"`
+	OnDemandBurstMB     int `json:"ondemand_burst_mb"`
+	// Mirrors 为只读镜像服务(--mirror)地址,在镜像仓库之前依次尝试
+	Mirrors       []string `json:"mirrors"`
+	// VolumeGCInterval 为清理已删除镜像遗留的 fscache 卷的间隔(秒)
+	VolumeGCInterval int `json:"volume_gc_interval"`
+	// NegativeCacheTTL 为镜像仓库和镜像服务中不存在的 blob 不再探测的时间(秒)
+	NegativeCacheTTL int `json:"negative_cache_ttl"`
+	// FallbackFailures 为挂载中镜像按需读取连续失败多少次后改用本地 EROFS 镜像的 loop 挂载
+	FallbackFailures int `json:"fallback_failures"`
+	// FallbackCooldown 为改用 loop 挂载后多久(秒)再尝试 fscache
+	FallbackCooldown int `json:"fallback_cooldown"`
+}
+
+// FlattenConfig 控制深父链的后台扁平化:父层数超过 Threshold 时合并为单个 EROFS 镜像
+type FlattenConfig struct {
+	Enabled   bool `json:"enabled"`
+	Threshold int  `json:"threshold"`
+}
+
+// SELinuxConfig 控制主机启用 SELinux 时的挂载标签,未启用时忽略。MountContext 是快照没有
+// containerd.io/snapshot/dedup-selinux-context 标签时 overlay 的 context= 上下文,也写入新建的 upperdir 和 workdir;
+// LowerContext 非空时作为各 EROFS 层挂载的 context=,使没有 SELinux xattr 的层在强制模式下可读
+type SELinuxConfig struct {
+	MountContext string `json:"mount_context"`
+	LowerContext string `json:"lower_context"`
+}
+
+// DiskWatchConfig 控制磁盘空间看门狗:每 Interval 秒检查 root 和临时空间所在文件系统的可用空间百分比,
+// 依次低于 PausePercent、EvictPercent、GCPercent、RefusePercent 时逐级暂停预取、
+// 清空未挂载镜像的 fscache 卷、清理孤儿卷,最后拒绝新的转换,空间恢复后自动解除
+type DiskWatchConfig struct {
+	Disabled      bool    `json:"disabled"`
+	Interval      int     `json:"interval"`
+	PausePercent  float64 `json:"pause_percent"`
+	EvictPercent  float64 `json:"evict_percent"`
+	GCPercent     float64 `json:"gc_percent"`
+	RefusePercent float64 `json:"refuse_percent"`
+}
+
+// WarmupConfig 控制新节点的缓存预热:Feed 为集群范围的镜像热度列表(本地 JSON 文件或 http(s) URL,
+// 格式见 storage.PopularityFeed),节点首次启动时为部署最多的 TopN 个镜像以低优先级提交拉取任务
+type WarmupConfig struct {
+	Enabled bool   `json:"enabled"`
+	Feed    string `json:"feed"`
+	TopN    int    `json:"top_n"`
+}
+
+// MaintenanceConfig 定义维护窗口:Windows 中每个窗口从 cron 表达式 Schedule(分 时 日 月 周,本地时间)
+// 给出的时刻开始,持续 Duration 秒。启用后卷 GC、chunk 后台校验、父链扁平化和审计库压缩只在窗口内全速运行,
+// 窗口外逐项处理的任务每项之后等待 OutsideDelay 毫秒,为 0 时暂停到下一个窗口,整体执行的任务推迟到窗口内
+type MaintenanceConfig struct {
+	Enabled      bool                `json:"enabled"`
+	Windows      []MaintenanceWindow `json:"windows"`
+	OutsideDelay int                 `json:"outside_delay_ms"`
+}
+
+// MaintenanceWindow 是一个维护窗口,如 {"schedule": "0 2 * * *", "duration": 14400} 为每天 2 点到 6 点
+type MaintenanceWindow struct {
+	Schedule string `json:"schedule"`
+	Duration int    `json:"duration"`
+}
+
+// AdmissionConfig 控制快照 gRPC 服务的准入:Rules 中每条规则限制其 Methods(RPC 名,如 Usage、List、Prepare)
+// 合计的并发数,超过时最多排队 QueueTimeout 毫秒,仍无空位则返回 RESOURCE_EXHAUSTED。Sheddable 的规则
+// 在存储过载(转换队列已满,或不可丢弃的 RPC 正在排队)时不排队直接拒绝,把资源留给 Prepare 等关键操作
+type AdmissionConfig struct {
+	Enabled bool            `json:"enabled"`
+	Rules   []AdmissionRule `json:"rules"`
+}
+
+// AdmissionRule 是一组共用并发上限的 RPC
+type AdmissionRule struct {
+	Methods       []string `json:"methods"`
+	MaxConcurrent int      `json:"max_concurrent"`
+	QueueTimeout  int      `json:"queue_timeout_ms"`
+	Sheddable     bool     `json:"sheddable"`
+}
+
+// chunk 持久化层的冗余方式
+const (
+	DurabilityOff       = "off"
+	DurabilityReplicate = "replicate"
+	DurabilityErasure   = "erasure"
+)
+
+// DurabilityConfig 把新写入的 chunk 冗余保存到多个后端(通常是挂载的 NFS、CephFS 或 S3 网关目录),
+// 镜像被仓库回收后 chunk 仍能从这些后端修复。Mode 为 replicate 时每个 chunk 完整保存 Copies 份,
+// 为 erasure 时切成 DataShards 个数据分片和 ParityShards 个校验分片分别保存,任意 DataShards 个分片即可恢复
+type DurabilityConfig struct {
+	Mode         string              `json:"mode"`
+	Backends     []DurabilityBackend `json:"backends"`
+	Copies       int                 `json:"copies"`
+	DataShards   int                 `json:"data_shards"`
+	ParityShards int                 `json:"parity_shards"`
+}
+
+// DurabilityBackend 是一个以目录形式挂载的 chunk 后端,Name 用于放置计算和指标,修改会导致 chunk 重新放置
+type DurabilityBackend struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// DefaultRetentionNamespace 是 CRI 插件保存镜像的 containerd namespace
+const DefaultRetentionNamespace = "k8s.io"
+
+// RetentionConfig 控制镜像保留策略:每 Interval 秒检查 containerd Namespace 中的镜像,每个仓库只保留最近
+// KeepLastTags 个标签,删除超过 UnusedDays 天未被容器使用的镜像,0 表示对应规则不生效。Pinned 为按 path.Match
+// 匹配完整镜像名的模式,匹配的镜像、带 containerd.io/snapshot/dedup.retain=true 标签的镜像和 CRI 固定的镜像始终保留。
+// 镜像从 containerd 删除后其快照由 containerd 的垃圾回收释放;DryRun 为 true 时定期检查只报告不删除
+type RetentionConfig struct {
+	Enabled           bool     `json:"enabled"`
+	DryRun            bool     `json:"dry_run"`
+	Interval          int      `json:"interval"`
+	KeepLastTags      int      `json:"keep_last_tags"`
+	UnusedDays        int      `json:"unused_days"`
+	Pinned            []string `json:"pinned"`
+	Namespace         string   `json:"namespace"`
+	ContainerdAddress string   `json:"containerd_address"`
+}
+
+// OverlayConfig 覆盖内核 overlay 限制的探测值,0 表示自动探测。
+// IDMappedMounts 为带 uidmapping/gidmapping 标签的用户命名空间快照使用 idmapped lowerdir,
+// 仅在 containerd 通过 remap-ids 能力得知本快照器自行映射时开启,否则 containerd 已 chown 复制父层
+type OverlayConfig struct {
+	MaxLowerDirs   int  `json:"max_lower_dirs"`
+	MaxOptionBytes int  `json:"max_option_bytes"`
+	IDMappedMounts bool `json:"idmapped_mounts"`
+}
+
+// ConversionConfig 控制按需镜像转换队列的 worker 数和队列长度。
+// SourceDirs 列出允许通过 API 转换或导入的本地目录,为空时不接受本地来源
+type ConversionConfig struct {
+	Workers    int      `json:"workers"`
+	QueueSize  int      `json:"queue_size"`
+	SourceDirs []string `json:"source_dirs"`
+}
+
+// MetricsPushConfig 为无法被抓取的边缘节点配置指标推送,Mode 为 pushgateway 或 remote_write
+type MetricsPushConfig struct {
+	Enabled  bool              `json:"enabled"`
+	Mode     string            `json:"mode"`
+	URL      string            `json:"url"`
+	Interval int               `json:"interval"`
+	Job      string            `json:"job"`
+	Node     string            `json:"node"`
+	Cluster  string            `json:"cluster"`
+	Labels   map[string]string `json:"labels"`
+}
+
+// MetricsLabelsConfig 控制按镜像和 namespace 切分的指标(镜像缓存命中、层转换耗时、容器挂载)。
+// Enabled 为 false 时只保留全局和挂载方式维度;每个指标最多 MaxSeries 条序列,超过后新的镜像归入 other
+type MetricsLabelsConfig struct {
+	Enabled   bool `json:"enabled"`
+	MaxSeries int  `json:"max_series"`
+}
+
+// SocketConfig 控制快照服务 socket 的权限、属主和允许连接的对端 UID,
+// UID/GID 为 -1 时不修改属主
+type SocketConfig struct {
+	Mode        string `json:"mode"`
+	UID         int    `json:"uid"`
+	GID         int    `json:"gid"`
+	AllowedUIDs []int  `json:"allowed_uids"`
+}
+
+// FaultInjectionConfig 仅用于测试:启用后故障注入点生效,并开放 /api/v1/debug/faults
+type FaultInjectionConfig struct {
+	Enabled bool `json:"enabled"`
+}
+
+// AlertsConfig 配置告警阈值(百分比),为 0 的阈值不检查;触发时 health 接口报告 degraded
+type AlertsConfig struct {
+	Enabled         bool    `json:"enabled"`
+	MinDedupRatio   float64 `json:"min_dedup_ratio"`
+	MinCacheHitRate float64 `json:"min_cache_hit_rate"`
+	MaxDiskUsage    float64 `json:"max_disk_usage"`
+	WebhookURL      string  `json:"webhook_url"`
+	Interval        int     `json:"interval"`
+}
+
+// IncrementalChunkConfig 控制活动快照 upperdir 中大文件的后台预切分,
+// 文件停止写入 QuietPeriod 秒后才切分,适用于长时间运行的构建容器
+type IncrementalChunkConfig struct {
+	Enabled     bool `json:"enabled"`
+	QuietPeriod int  `json:"quiet_period"`
+}
+
+// BindMountsConfig 控制 EROFS 挂载点到 pod 目录的只读绑定,
+// KubeletPodsDir 下 pod 目录消失后每 ReapInterval 秒回收一次其绑定。
+// 绑定目标只能位于 root/pods 或 TargetDirs 列出的目录之下
+type BindMountsConfig struct {
+	Propagation    string   `json:"propagation"`
+	KubeletPodsDir string   `json:"kubelet_pods_dir"`
+	ReapInterval   int      `json:"reap_interval"`
+	TargetDirs     []string `json:"target_dirs"`
+}
+
+// StatsHistoryConfig 控制指标历史采样,每 Interval 秒记录一次,保留 RetentionDays 天
+type StatsHistoryConfig struct {
+	Enabled       bool `json:"enabled"`
+	Interval      int  `json:"interval"`
+	RetentionDays int  `json:"retention_days"`
+}
+
+// chunk 校验深度
+const (
+	VerifyModeNone  = "none"
+	VerifyModeQuick = "quick"
+	VerifyModeFull  = "full"
+)
+
+// RecoveryConfig 控制启动时快照恢复和 chunk 校验的并发度与校验深度,VerifyMode 为 full 时还会
+// 解析已有 EROFS 镜像的超级块校验和与元数据,删除未通过校验的镜像,
+// Background 为 true 时 chunk 校验在后台进行,不阻塞 gRPC 服务启动
+type RecoveryConfig struct {
+	Workers    int    `json:"workers"`
+	VerifyMode string `json:"verify_mode"`
+	Background bool   `json:"background"`
+}
+
+// 冷页回收方式
+const (
+	ReclaimOff     = "off"
+	ReclaimCold    = "cold"
+	ReclaimPageout = "pageout"
+)
+
+// MemDedupConfig 控制挂载后对镜像文件做内存去重扫描的并发和速率,FilesPerSecond 为 0 不限速。
+// Reclaim 为 cold 或 pageout 时每 ReclaimInterval 秒采样一次已挂载镜像的页访问,
+// 连续 ReclaimColdAfter 轮未被访问的页用 MADV_COLD 或 MADV_PAGEOUT 主动回收
+type MemDedupConfig struct {
+	Workers          int    `json:"workers"`
+	FilesPerSecond   int    `json:"files_per_second"`
+	Reclaim          string `json:"reclaim"`
+	ReclaimInterval  int    `json:"reclaim_interval"`
+	ReclaimColdAfter int    `json:"reclaim_cold_after"`
+}
+
+// TimeoutsConfig 是外部命令单次执行的超时(秒),超时的子进程会被杀死:
+// Mount 用于 mount/umount/losetup,Build 用于 mkfs.erofs。
+// Freeze 是快照为备份静默后保持冻结的最长时间,到期自动解冻,避免备份工具异常退出后容器一直阻塞
+type TimeoutsConfig struct {
+	Mount  int `json:"mount"`
+	Build  int `json:"build"`
+	Freeze int `json:"freeze"`
+	// Usage 为统计活动快照磁盘占用的遍历时限(秒),超时的 Usage 调用返回错误
+	Usage  int `json:"usage"`
+}
+
+// StoreConfig 控制与其他进程共享 root 时的附着方式:ReadOnly 为 true 时不获取存储所有权,
+// 只读打开索引,供统计和查询使用,所有写操作返回错误。
+// Immutable 用于预制存储的边缘设备:root 中的 chunk、镜像和索引只读,不做转换和拉取,
+// 快照元数据和容器的 upperdir 放在 WritableDir(如 tmpfs 或单独的磁盘)中
+type StoreConfig struct {
+	ReadOnly    bool   `json:"read_only"`
+	Immutable   bool   `json:"immutable"`
+	WritableDir string `json:"writable_dir"`
+}
+
+// SigningConfig 控制转换产物的签名:Key 为签名用的 PEM 私钥,为空时不签名;
+// TrustedKeys 为校验镜像仓库中已发布 chunk 清单的公钥,Require 为 true 时拒绝未签名的清单
+type SigningConfig struct {
+	Key         string   `json:"key"`
+	TrustedKeys []string `json:"trusted_keys"`
+	Require     bool     `json:"require"`
+}
+
+// StartupTraceConfig 控制容器冷启动追踪:从快照挂载到 ENTRYPOINT 启动期间的首次读取延迟、
+// 按需读取和下载字节数。Window 为等待启动信号的秒数,History 为保留的已结束追踪数
+type StartupTraceConfig struct {
+	Enabled bool `json:"enabled"`
+	Window  int  `json:"window"`
+	History int  `json:"history"`
+}
+
+// 只读镜像模式的默认监听地址和 containerd content store 路径
+const (
+	DefaultMirrorListen      = ":8090"
+	DefaultMirrorContentRoot = "/var/lib/containerd/io.containerd.content.v1.content"
+)
+
+// 后台任务的 IO 调度类
+const (
+	IOClassNone       = "none"
+	IOClassBestEffort = "best-effort"
+	IOClassIdle       = "idle"
+)
+
+// BackgroundConfig 控制镜像转换、chunk 校验和内存去重扫描等后台任务的优先级:
+// Nice 和 IOClass/IOLevel 作用于后台线程及其启动的外部命令;Cgroup 非空时外部命令放入该 cgroup v2
+// (相对路径以 /sys/fs/cgroup 为根),CPUWeight 和 IOMax 写入 cpu.weight 与 io.max(如 "8:0 wbps=10485760")
+type BackgroundConfig struct {
+	Nice      int      `json:"nice"`
+	IOClass   string   `json:"io_class"`
+	IOLevel   int      `json:"io_level"`
+	Cgroup    string   `json:"cgroup"`
+	CPUWeight int      `json:"cpu_weight"`
+	IOMax     []string `json:"io_max"`
+}
+
+// MirrorConfig 控制只读镜像模式(--mirror):在 Listen 上通过 HTTP 提供 root 中的 chunk、
+// EROFS 镜像以及 ContentRoot(containerd content store)中的层 blob,不运行快照服务
+type MirrorConfig struct {
+	Listen      string `json:"listen"`
+	ContentRoot string `json:"content_root"`
+}
+
+// DefaultScratchMinFreeMB 是临时空间所在文件系统默认保留的可用空间(MB)
+const DefaultScratchMinFreeMB = 1024
+
+// ScratchConfig 控制层解压和镜像构建使用的临时空间:Dir 为 temp、extract、staging 所在目录,
+// 为空时使用 root;MaxMB 为同时进行的解压预计占用的上限,超出时后续层等待,0 表示不限;
+// MinFreeMB 为 Dir 所在文件系统需保留的可用空间,不足时转换失败
+type ScratchConfig struct {
+	Dir       string `json:"dir"`
+	MaxMB     int    `json:"max_mb"`
+	MinFreeMB int    `json:"min_free_mb"`
+}
+
+// DefaultUserAgent 是访问镜像仓库和镜像服务时默认的 User-Agent
+const DefaultUserAgent = "dedup-snapshotter/1.0.0"
+
+// RegistryClientConfig 控制访问镜像仓库和镜像服务的 HTTP 请求:UserAgent 标识本节点,
+// TraceHeaders 为 true 时为每个请求附加 W3C traceparent 头
+type RegistryClientConfig struct {
+	UserAgent    string `json:"user_agent"`
+	TraceHeaders bool   `json:"trace_headers"`
+}
+
+// WebhookConfig 控制镜像仓库推送事件的接收(POST /api/v1/webhooks/registry)。开启的节点收到
+// Harbor 或 distribution 的推送通知后在后台预拉取并转换新镜像;要求 Authorization 头与 Secret 相同,
+// 只有设置 Insecure 时才允许不配置 Secret 接受未鉴权的通知;Repositories 为 host/repository 的 glob 模式,
+// 为空时接受所有仓库
+type WebhookConfig struct {
+	Enabled      bool     `json:"enabled"`
+	Secret       string   `json:"secret"`
+	Insecure     bool     `json:"insecure"`
+	Repositories []string `json:"repositories"`
+}
+
+// DefaultContainerdAddress 是 containerd 的默认 gRPC 地址
+const DefaultContainerdAddress = "/run/containerd/containerd.sock"
+
+// DiffConfig 控制在快照服务的 socket 上同时提供 containerd diff 服务(proxy_plugins 中 type = "diff"):
+// 层直接解包到快照 upperdir 并立即切分转换,不再由 containerd 解包后重新读取。
+// ContainerdAddress 用于访问 containerd 的 content 服务读取和写入层 blob
+type DiffConfig struct {
+	Enabled           bool   `json:"enabled"`
+	ContainerdAddress string `json:"containerd_address"`
+}
+
+// DefaultAuditRedactFields 是默认屏蔽的审计详情字段,覆盖配置和标签中常见的凭据
+var DefaultAuditRedactFields = []string{"*password*", "*secret*", "*token*", "authorization", "*credential*"}
+
+// AuditConfig 控制审计详情的记录。DisableDetails 为 true 时只记录操作、目标和结果,不记录详情;
+// RedactFields 为详情中需屏蔽的字段掩码(* 为通配符,匹配字段名或以 . 连接的路径,如 labels.*),
+// 写入和导出时替换为 [REDACTED]
+type AuditConfig struct {
+	DisableDetails bool     `json:"disable_details"`
+	RedactFields   []string `json:"redact_fields"`
+}
+
+// DebugConfig 控制 API 服务上 /api/v1/debug 下的 pprof、expvar、goroutine 和状态转储端点。
+// 开启时必须设置 Token,请求需带 Authorization: Bearer <token>;
+// MutexProfileFraction 和 BlockProfileRate 非 0 时开启锁竞争和阻塞采样,见 runtime.SetMutexProfileFraction
+type DebugConfig struct {
+	Enabled              bool   `json:"enabled"`
+	Token                string `json:"token"`
+	MutexProfileFraction int    `json:"mutex_profile_fraction"`
+	BlockProfileRate     int    `json:"block_profile_rate"`
+}
+
+// APIConfig 控制 API 服务上修改配置、删除数据、拉取镜像等管理操作的鉴权:设置 Token 时请求需带
+// Authorization: Bearer <token>,未设置时只接受来自本机回环地址的管理请求
+type APIConfig struct {
+	Token string `json:"token"`
+}
+
+// ChunkCacheConfig 控制本地 chunk 存储和 fscache 读取前的内存 chunk 缓存,
+// MaxMB 为缓存数据的上限,Disabled 为 true 时不缓存
+type ChunkCacheConfig struct {
+	Disabled bool `json:"disabled"`
+	MaxMB    int  `json:"max_mb"`
+	// IndexEntries 是 chunk 索引元数据(大小、引用计数)缓存的条目数,0 表示不缓存
+	IndexEntries int `json:"index_entries"`
+}
+
+// BufferPoolConfig 控制切分、哈希和重建使用的 chunk 缓冲池。HugePageBuffers 大于 0 时
+// 预先映射这么多个透明大页缓冲区(多 NUMA 节点时交错分布),常驻内存不归还;0 表示只用普通内存
+type BufferPoolConfig struct {
+	HugePageBuffers int `json:"huge_page_buffers"`
+}
+
+// EncryptionConfig 为 root 下的 chunks 和 snapshots 目录设置 fscrypt 策略,chunk 和容器 upperdir
+// 在本节点静态加密。密钥来源只能设置一个:KeyFile 为 64 字节原始密钥文件,KeyringKey 为 keyring 中
+// user 类型密钥的描述,KMSCommand 执行后在标准输出打印 hex 或 base64 编码的密钥。只能在新的 root 上开启
+type EncryptionConfig struct {
+	Enabled    bool     `json:"enabled"`
+	KeyFile    string   `json:"key_file"`
+	KeyringKey string   `json:"keyring_key"`
+	KMSCommand []string `json:"kms_command"`
+}
+
+// ScanConfig 控制转换后的层提供服务前的漏洞扫描。Scanner 为 trivy(以客户端模式连接 TrivyServer)
+// 或 exec(执行 Command,层目录为最后一个参数,输出 Trivy JSON 报告),为空时不扫描。
+// 报告含 BlockSeverities 级别的漏洞时阻止容器挂载该层,含 WarnSeverities 时只告警;
+// OnError 为扫描失败时的结论(allow、warn 或 block),Timeout 为单次扫描的超时(秒)
+type ScanConfig struct {
+	Scanner         string   `json:"scanner"`
+	Command         []string `json:"command"`
+	TrivyBinary     string   `json:"trivy_binary"`
+	TrivyServer     string   `json:"trivy_server"`
+	WarnSeverities  []string `json:"warn_severities"`
+	BlockSeverities []string `json:"block_severities"`
+	OnError         string   `json:"on_error"`
+	Timeout         int      `json:"timeout"`
+}
+
+const (
+	ScannerTrivy = "trivy"
+	ScannerExec  = "exec"
+)
+
+// DefaultScanTimeout 是单次漏洞扫描的默认超时(秒)
+const DefaultScanTimeout = 300
+
+// AccountingConfig 控制按镜像和命名空间的流量分摊统计。ResetInterval 为统计窗口的长度(小时),
+// 到期后自动开始新窗口;0 表示只通过 API 重置
+type AccountingConfig struct {
+	Disabled      bool `json:"disabled"`
+	ResetInterval int  `json:"reset_interval"`
+}
+
+// DefaultProxyListen 是拉取代理的默认监听地址
+const DefaultProxyListen = ":5050"
+
+// ProxyConfig 控制镜像仓库拉取代理:在 Listen 上把 /v2/ 请求转发到 Upstream(如 https://registry-1.docker.io),
+// 客户端照常拉取,经过代理的层 blob 在后台物化为 EROFS 镜像并记录 chunk 清单,其他节点随后通过
+// dedupd.mirrors 懒加载。Workers 为同时转换的层数;MaxLayerMB 大于 0 时更大的层只转发不转换
+type ProxyConfig struct {
+	Enabled    bool   `json:"enabled"`
+	Listen     string `json:"listen"`
+	Upstream   string `json:"upstream"`
+	Workers    int    `json:"workers"`
+	MaxLayerMB int64  `json:"max_layer_mb"`
+}
+
+// SlowLogConfig 控制慢操作日志:Prepare、挂载、转换和下载超过对应阈值(毫秒,0 表示不记录)时,
+// 在阈值处输出带 goroutine 栈和队列状态的详细日志,结束时再记录总耗时。
+// MaxPerMinute 是每种操作每分钟最多输出的日志条数,StackKB 是栈转储的上限
+type SlowLogConfig struct {
+	Enabled      bool  `json:"enabled"`
+	PrepareMs    int64 `json:"prepare_ms"`
+	MountMs      int64 `json:"mount_ms"`
+	ConversionMs int64 `json:"conversion_ms"`
+	DownloadMs   int64 `json:"download_ms"`
+	MaxPerMinute int   `json:"max_per_minute"`
+	StackKB      int   `json:"stack_kb"`
 }
 
 func DefaultConfig(root string) *Config {
@@ -51,6 +566,7 @@ func DefaultConfig(root string) *Config {
 		EnableMemDedup: true,
 		Registry:      "",
 		ChunkSize:     4 * 1024 * 1024,
+		EnableSmallChunks: true,
 		LogLevel:      "info",
 		Prefetch: PrefetchConfig{
 			Enabled:   true,
@@ -69,6 +585,153 @@ func DefaultConfig(root string) *Config {
 			Workers:       4,
 			Registry:      "https://registry-1.docker.io",
 			FscacheDomain: "dedup-snapshotter",
+			OnDemandRateMB:      256,
+			OnDemandImageRateMB: 64,
+			OnDemandBurstMB:     16,
+			VolumeGCInterval:    600,
+			NegativeCacheTTL:    600,
+			FallbackFailures:    5,
+			FallbackCooldown:    600,
+		},
+		Flatten: FlattenConfig{
+			Enabled:   true,
+			Threshold: 20,
+		},
+		Conversion: ConversionConfig{
+			Workers:   2,
+			QueueSize: 100,
+		},
+		MetricsPush: MetricsPushConfig{
+			Enabled:  false,
+			Mode:     "pushgateway",
+			Interval: 60,
+			Job:      "dedup-snapshotter",
+		},
+		MetricsLabels: MetricsLabelsConfig{
+			Enabled:   true,
+			MaxSeries: 200,
+		},
+		Alerts: AlertsConfig{
+			Enabled:      true,
+			MaxDiskUsage: 90,
+			Interval:     60,
+		},
+		IncrementalChunk: IncrementalChunkConfig{
+			Enabled:     false,
+			QuietPeriod: 5,
+		},
+		BindMounts: BindMountsConfig{
+			Propagation:    "rslave",
+			KubeletPodsDir: "/var/lib/kubelet/pods",
+			ReapInterval:   30,
+		},
+		StatsHistory: StatsHistoryConfig{
+			Enabled:       true,
+			Interval:      60,
+			RetentionDays: 30,
+		},
+		Recovery: RecoveryConfig{
+			Workers:    runtime.NumCPU(),
+			VerifyMode: VerifyModeQuick,
+			Background: true,
+		},
+		MemDedup: MemDedupConfig{
+			Workers:          2,
+			FilesPerSecond:   200,
+			Reclaim:          ReclaimOff,
+			ReclaimInterval:  60,
+			ReclaimColdAfter: 5,
+		},
+		Timeouts: TimeoutsConfig{
+			Mount:  30,
+			Build:  1800,
+			Freeze: 300,
+			Usage:  60,
+		},
+		StartupTrace: StartupTraceConfig{
+			Window:  300,
+			History: 256,
+		},
+		Background: BackgroundConfig{
+			Nice:      10,
+			IOClass:   IOClassIdle,
+			CPUWeight: 20,
+		},
+		Mirror: MirrorConfig{
+			Listen:      DefaultMirrorListen,
+			ContentRoot: DefaultMirrorContentRoot,
+		},
+		Scratch: ScratchConfig{
+			MinFreeMB: DefaultScratchMinFreeMB,
+		},
+		RegistryClient: RegistryClientConfig{
+			UserAgent: DefaultUserAgent,
+		},
+		Diff: DiffConfig{
+			ContainerdAddress: DefaultContainerdAddress,
+		},
+		Audit: AuditConfig{
+			RedactFields: append([]string(nil), DefaultAuditRedactFields...),
+		},
+		ChunkCache: ChunkCacheConfig{
+			MaxMB:        64,
+			IndexEntries: 65536,
+		},
+		DiskWatch: DiskWatchConfig{
+			Interval:      30,
+			PausePercent:  15,
+			EvictPercent:  10,
+			GCPercent:     7,
+			RefusePercent: 5,
+		},
+		Warmup: WarmupConfig{
+			TopN: 20,
+		},
+		Maintenance: MaintenanceConfig{
+			OutsideDelay: 1000,
+		},
+		Admission: AdmissionConfig{
+			Enabled: true,
+			Rules: []AdmissionRule{
+				{Methods: []string{"Usage", "List"}, MaxConcurrent: 4, QueueTimeout: 100, Sheddable: true},
+			},
+		},
+		Durability: DurabilityConfig{
+			Mode:         DurabilityOff,
+			Copies:       2,
+			DataShards:   4,
+			ParityShards: 2,
+		},
+		Retention: RetentionConfig{
+			Interval:          3600,
+			Namespace:         DefaultRetentionNamespace,
+			ContainerdAddress: DefaultContainerdAddress,
+		},
+		Scan: ScanConfig{
+			TrivyBinary:     "trivy",
+			WarnSeverities:  []string{"HIGH"},
+			BlockSeverities: []string{"CRITICAL"},
+			OnError:         "warn",
+			Timeout:         DefaultScanTimeout,
+		},
+		Socket: SocketConfig{
+			Mode:        "0600",
+			UID:         -1,
+			GID:         -1,
+			AllowedUIDs: []int{0},
+		},
+		Proxy: ProxyConfig{
+			Listen:  DefaultProxyListen,
+			Workers: 2,
+		},
+		SlowLog: SlowLogConfig{
+			Enabled:      true,
+			PrepareMs:    5000,
+			MountMs:      10000,
+			ConversionMs: 600000,
+			DownloadMs:   30000,
+			MaxPerMinute: 10,
+			StackKB:      64,
 		},
 	}
 }
@@ -87,6 +750,15 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, fmt.Errorf("failed to parse config: %w", err)
 	}
 
+	// 拼错的字段名会被 json.Unmarshal 静默忽略,这里显式拒绝
+	unknown, err := unknownFields(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("invalid config %s: %s", path, strings.Join(unknown, "; "))
+	}
+
 	if err := cfg.Validate(); err != nil {
 		return nil, err
 	}
@@ -111,6 +783,445 @@ func (c *Config) Validate() error {
 		c.Prefetch.QueueSize = 1000
 	}
 
+	if c.Prefetch.PolicyFile != "" && !filepath.IsAbs(c.Prefetch.PolicyFile) {
+		return fmt.Errorf("prefetch.policy_file must be an absolute path")
+	}
+
+	if c.Flatten.Threshold <= 0 {
+		c.Flatten.Threshold = 20
+	}
+
+	if c.Conversion.Workers <= 0 {
+		c.Conversion.Workers = 2
+	}
+
+	if c.Conversion.QueueSize <= 0 {
+		c.Conversion.QueueSize = 100
+	}
+
+	for _, dir := range c.Conversion.SourceDirs {
+		if !filepath.IsAbs(dir) {
+			return fmt.Errorf("conversion.source_dirs entry %q must be an absolute path", dir)
+		}
+	}
+
+	if c.MetricsPush.Interval <= 0 {
+		c.MetricsPush.Interval = 60
+	}
+
+	if c.MetricsPush.Enabled {
+		if c.MetricsPush.URL == "" {
+			return fmt.Errorf("metrics_push.url is required when push is enabled")
+		}
+		if c.MetricsPush.Mode != "pushgateway" && c.MetricsPush.Mode != "remote_write" {
+			return fmt.Errorf("metrics_push.mode must be pushgateway or remote_write")
+		}
+	}
+
+	switch c.BindMounts.Propagation {
+	case "":
+		c.BindMounts.Propagation = "rslave"
+	case "rprivate", "rslave", "rshared":
+	default:
+		return fmt.Errorf("bind_mounts.propagation must be rprivate, rslave or rshared")
+	}
+
+	if c.BindMounts.KubeletPodsDir == "" {
+		c.BindMounts.KubeletPodsDir = "/var/lib/kubelet/pods"
+	}
+
+	for _, dir := range c.BindMounts.TargetDirs {
+		if !filepath.IsAbs(dir) {
+			return fmt.Errorf("bind_mounts.target_dirs entry %q must be an absolute path", dir)
+		}
+	}
+
+	if c.BindMounts.ReapInterval <= 0 {
+		c.BindMounts.ReapInterval = 30
+	}
+
+	for name, label := range map[string]string{
+		"selinux.mount_context": c.SELinux.MountContext,
+		"selinux.lower_context": c.SELinux.LowerContext,
+	} {
+		if label != "" && (strings.Count(label, ":") < 3 || strings.ContainsAny(label, "\" \t\n")) {
+			return fmt.Errorf("%s must be an SELinux context of the form user:role:type:level", name)
+		}
+	}
+
+	if c.Recovery.Workers <= 0 {
+		c.Recovery.Workers = runtime.NumCPU()
+	}
+
+	switch c.Recovery.VerifyMode {
+	case "":
+		c.Recovery.VerifyMode = VerifyModeQuick
+	case VerifyModeNone, VerifyModeQuick, VerifyModeFull:
+	default:
+		return fmt.Errorf("recovery.verify_mode must be none, quick or full")
+	}
+
+	if c.MemDedup.Workers <= 0 {
+		c.MemDedup.Workers = 2
+	}
+
+	switch c.MemDedup.Reclaim {
+	case "":
+		c.MemDedup.Reclaim = ReclaimOff
+	case ReclaimOff, ReclaimCold, ReclaimPageout:
+	default:
+		return fmt.Errorf("mem_dedup.reclaim must be off, cold or pageout")
+	}
+
+	if c.MemDedup.ReclaimInterval <= 0 {
+		c.MemDedup.ReclaimInterval = 60
+	}
+
+	if c.MemDedup.ReclaimColdAfter <= 0 {
+		c.MemDedup.ReclaimColdAfter = 5
+	}
+
+	if c.Timeouts.Mount <= 0 {
+		c.Timeouts.Mount = 30
+	}
+
+	if c.Timeouts.Build <= 0 {
+		c.Timeouts.Build = 1800
+	}
+
+	if c.Timeouts.Freeze <= 0 {
+		c.Timeouts.Freeze = 300
+	}
+
+	if c.Timeouts.Usage <= 0 {
+		c.Timeouts.Usage = 60
+	}
+
+	if c.StartupTrace.Window <= 0 {
+		c.StartupTrace.Window = 300
+	}
+
+	if c.StartupTrace.History <= 0 {
+		c.StartupTrace.History = 256
+	}
+
+	switch c.Background.IOClass {
+	case "":
+		c.Background.IOClass = IOClassIdle
+	case IOClassNone, IOClassBestEffort, IOClassIdle:
+	default:
+		return fmt.Errorf("background.io_class must be none, best-effort or idle")
+	}
+
+	if c.Background.CPUWeight <= 0 {
+		c.Background.CPUWeight = 20
+	}
+
+	if c.Dedupd.VolumeGCInterval <= 0 {
+		c.Dedupd.VolumeGCInterval = 600
+	}
+
+	if c.Dedupd.NegativeCacheTTL <= 0 {
+		c.Dedupd.NegativeCacheTTL = 600
+	}
+
+	if c.Dedupd.FallbackFailures <= 0 {
+		c.Dedupd.FallbackFailures = 5
+	}
+
+	if c.Dedupd.FallbackCooldown <= 0 {
+		c.Dedupd.FallbackCooldown = 600
+	}
+
+	if c.Mirror.Listen == "" {
+		c.Mirror.Listen = DefaultMirrorListen
+	}
+
+	if c.Mirror.ContentRoot == "" {
+		c.Mirror.ContentRoot = DefaultMirrorContentRoot
+	}
+
+	if c.Diff.ContainerdAddress == "" {
+		c.Diff.ContainerdAddress = DefaultContainerdAddress
+	}
+
+	if c.Store.Immutable {
+		if c.Store.ReadOnly {
+			return fmt.Errorf("store.immutable and store.read_only are mutually exclusive")
+		}
+		if c.Store.WritableDir == "" {
+			return fmt.Errorf("store.writable_dir is required when store.immutable is set")
+		}
+		if !filepath.IsAbs(c.Store.WritableDir) {
+			return fmt.Errorf("store.writable_dir must be an absolute path")
+		}
+	}
+
+	if c.Encryption.Enabled {
+		sources := 0
+		for _, set := range []bool{c.Encryption.KeyFile != "", c.Encryption.KeyringKey != "", len(c.Encryption.KMSCommand) > 0} {
+			if set {
+				sources++
+			}
+		}
+		if sources != 1 {
+			return fmt.Errorf("encryption requires exactly one of key_file, keyring_key or kms_command")
+		}
+		if c.Store.Immutable {
+			return fmt.Errorf("encryption cannot be set up on an immutable store")
+		}
+	}
+
+	if c.Scan.TrivyBinary == "" {
+		c.Scan.TrivyBinary = "trivy"
+	}
+	if c.Scan.WarnSeverities == nil {
+		c.Scan.WarnSeverities = []string{"HIGH"}
+	}
+	if c.Scan.BlockSeverities == nil {
+		c.Scan.BlockSeverities = []string{"CRITICAL"}
+	}
+	if c.Scan.OnError == "" {
+		c.Scan.OnError = "warn"
+	}
+	if c.Scan.Timeout <= 0 {
+		c.Scan.Timeout = DefaultScanTimeout
+	}
+
+	switch c.Scan.OnError {
+	case "allow", "warn", "block":
+	default:
+		return fmt.Errorf("scan.on_error must be allow, warn or block")
+	}
+	switch c.Scan.Scanner {
+	case "":
+	case ScannerTrivy:
+		if c.Scan.TrivyServer == "" {
+			return fmt.Errorf("scan.trivy_server is required for the trivy scanner")
+		}
+	case ScannerExec:
+		if len(c.Scan.Command) == 0 {
+			return fmt.Errorf("scan.command is required for the exec scanner")
+		}
+	default:
+		return fmt.Errorf("scan.scanner must be %s or %s", ScannerTrivy, ScannerExec)
+	}
+
+	if c.Debug.Enabled && c.Debug.Token == "" {
+		return fmt.Errorf("debug.token is required when debug endpoints are enabled")
+	}
+
+	if c.Proxy.Listen == "" {
+		c.Proxy.Listen = DefaultProxyListen
+	}
+	if c.Proxy.Workers <= 0 {
+		c.Proxy.Workers = 2
+	}
+	if c.Proxy.Enabled {
+		u, err := url.Parse(c.Proxy.Upstream)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("proxy.upstream must be an http or https registry URL when the proxy is enabled")
+		}
+	}
+
+	if c.SlowLog.MaxPerMinute <= 0 {
+		c.SlowLog.MaxPerMinute = 10
+	}
+	if c.SlowLog.StackKB <= 0 {
+		c.SlowLog.StackKB = 64
+	}
+
+	if c.ChunkCache.MaxMB <= 0 {
+		c.ChunkCache.MaxMB = 64
+	}
+	if c.ChunkCache.IndexEntries < 0 {
+		c.ChunkCache.IndexEntries = 65536
+	}
+
+	if c.DiskWatch.Interval <= 0 {
+		c.DiskWatch.Interval = 30
+	}
+	if c.DiskWatch.PausePercent <= 0 {
+		c.DiskWatch.PausePercent = 15
+	}
+	if c.DiskWatch.EvictPercent <= 0 {
+		c.DiskWatch.EvictPercent = 10
+	}
+	if c.DiskWatch.GCPercent <= 0 {
+		c.DiskWatch.GCPercent = 7
+	}
+	if c.DiskWatch.RefusePercent <= 0 {
+		c.DiskWatch.RefusePercent = 5
+	}
+	dw := c.DiskWatch
+	if dw.PausePercent > 100 || dw.PausePercent < dw.EvictPercent || dw.EvictPercent < dw.GCPercent || dw.GCPercent < dw.RefusePercent {
+		return fmt.Errorf("disk_watch thresholds must satisfy 100 >= pause_percent >= evict_percent >= gc_percent >= refuse_percent")
+	}
+
+	if c.MetricsLabels.MaxSeries <= 0 {
+		c.MetricsLabels.MaxSeries = 200
+	}
+
+	if c.Warmup.TopN <= 0 {
+		c.Warmup.TopN = 20
+	}
+	if c.Warmup.Enabled && c.Warmup.Feed == "" {
+		return fmt.Errorf("warmup.feed is required when warmup is enabled")
+	}
+
+	if c.Maintenance.OutsideDelay < 0 {
+		c.Maintenance.OutsideDelay = 1000
+	}
+	for _, w := range c.Maintenance.Windows {
+		if len(strings.Fields(w.Schedule)) != 5 {
+			return fmt.Errorf("maintenance window schedule %q must have 5 fields (minute hour day-of-month month day-of-week)", w.Schedule)
+		}
+		if w.Duration <= 0 {
+			return fmt.Errorf("maintenance window %q must have a positive duration", w.Schedule)
+		}
+	}
+	if c.Maintenance.Enabled && len(c.Maintenance.Windows) == 0 {
+		return fmt.Errorf("maintenance.windows is required when maintenance is enabled")
+	}
+
+	seen := make(map[string]bool)
+	for _, r := range c.Admission.Rules {
+		if len(r.Methods) == 0 {
+			return fmt.Errorf("admission rules require at least one method")
+		}
+		if r.MaxConcurrent <= 0 {
+			return fmt.Errorf("admission rule for %v must have a positive max_concurrent", r.Methods)
+		}
+		if r.QueueTimeout < 0 {
+			return fmt.Errorf("admission rule for %v must have a non-negative queue_timeout_ms", r.Methods)
+		}
+		for _, m := range r.Methods {
+			if seen[m] {
+				return fmt.Errorf("method %s appears in more than one admission rule", m)
+			}
+			seen[m] = true
+		}
+	}
+
+	if c.Durability.Mode == "" {
+		c.Durability.Mode = DurabilityOff
+	}
+	if c.Durability.Copies <= 0 {
+		c.Durability.Copies = 2
+	}
+	if c.Durability.DataShards <= 0 {
+		c.Durability.DataShards = 4
+	}
+	if c.Durability.ParityShards <= 0 {
+		c.Durability.ParityShards = 2
+	}
+	names := make(map[string]bool)
+	for _, b := range c.Durability.Backends {
+		if b.Name == "" || b.Path == "" {
+			return fmt.Errorf("durability backends require a name and a path")
+		}
+		if names[b.Name] {
+			return fmt.Errorf("duplicate durability backend %q", b.Name)
+		}
+		names[b.Name] = true
+	}
+	switch c.Durability.Mode {
+	case DurabilityOff:
+	case DurabilityReplicate:
+		if len(c.Durability.Backends) < c.Durability.Copies {
+			return fmt.Errorf("durability.copies %d requires at least as many backends, got %d", c.Durability.Copies, len(c.Durability.Backends))
+		}
+	case DurabilityErasure:
+		if n := c.Durability.DataShards + c.Durability.ParityShards; len(c.Durability.Backends) < n {
+			return fmt.Errorf("durability erasure coding %d+%d requires at least %d backends, got %d",
+				c.Durability.DataShards, c.Durability.ParityShards, n, len(c.Durability.Backends))
+		}
+	default:
+		return fmt.Errorf("invalid durability.mode %q (must be off, replicate or erasure)", c.Durability.Mode)
+	}
+
+	if c.Retention.Interval <= 0 {
+		c.Retention.Interval = 3600
+	}
+	if c.Retention.Namespace == "" {
+		c.Retention.Namespace = DefaultRetentionNamespace
+	}
+	if c.Retention.ContainerdAddress == "" {
+		c.Retention.ContainerdAddress = DefaultContainerdAddress
+	}
+	for _, pattern := range c.Retention.Pinned {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid retention.pinned pattern %q: %w", pattern, err)
+		}
+	}
+
+	if c.Audit.RedactFields == nil {
+		c.Audit.RedactFields = append([]string(nil), DefaultAuditRedactFields...)
+	}
+
+	if c.Scratch.MinFreeMB <= 0 {
+		c.Scratch.MinFreeMB = DefaultScratchMinFreeMB
+	}
+
+	if c.RegistryClient.UserAgent == "" {
+		c.RegistryClient.UserAgent = DefaultUserAgent
+	}
+
+	if c.Webhook.Enabled && c.Webhook.Secret == "" && !c.Webhook.Insecure {
+		return fmt.Errorf("webhook.secret is required when webhook.enabled is set (set webhook.insecure to accept unauthenticated notifications)")
+	}
+	for _, pattern := range c.Webhook.Repositories {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid webhook.repositories pattern %q: %w", pattern, err)
+		}
+	}
+
+	if c.StatsHistory.Interval <= 0 {
+		c.StatsHistory.Interval = 60
+	}
+
+	if c.StatsHistory.RetentionDays <= 0 {
+		c.StatsHistory.RetentionDays = 30
+	}
+
+	if c.IncrementalChunk.QuietPeriod <= 0 {
+		c.IncrementalChunk.QuietPeriod = 5
+	}
+
+	if c.Alerts.Interval <= 0 {
+		c.Alerts.Interval = 60
+	}
+
+	for _, v := range []float64{c.Alerts.MinDedupRatio, c.Alerts.MinCacheHitRate, c.Alerts.MaxDiskUsage} {
+		if v < 0 || v > 100 {
+			return fmt.Errorf("alert thresholds must be between 0 and 100")
+		}
+	}
+
+	if c.Socket.Mode == "" {
+		c.Socket.Mode = "0600"
+	}
+
+	if _, err := strconv.ParseUint(c.Socket.Mode, 8, 32); err != nil {
+		return fmt.Errorf("socket.mode must be an octal permission: %w", err)
+	}
+
+	if len(c.Socket.AllowedUIDs) == 0 {
+		c.Socket.AllowedUIDs = []int{0}
+	}
+
+	if c.Signing.Require && len(c.Signing.TrustedKeys) == 0 {
+		return fmt.Errorf("signing.trusted_keys is required when signing.require is set")
+	}
+
+	if c.Overlay.MaxLowerDirs < 0 || c.Overlay.MaxOptionBytes < 0 {
+		return fmt.Errorf("overlay limits must not be negative")
+	}
+
+	if problems := c.checkRanges(); len(problems) > 0 {
+		return fmt.Errorf("invalid config: %s", strings.Join(problems, "; "))
+	}
+
 	return nil
 }
 
@@ -173,3 +1284,4 @@ func (c *Config) String() string {
 	data, _ := json.MarshalIndent(c, "", "  ")
 	return string(data)
 }
+
@@ -0,0 +1,339 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/containerd/log"
+)
+
+// fieldRange 是数值字段允许的闭区间
+type fieldRange struct {
+	Min float64
+	Max float64
+}
+
+// fieldRanges 以点分 JSON 路径列出需要范围检查的数值字段,Validate 和 Schema 共用
+var fieldRanges = map[string]fieldRange{
+	"chunk_size":                     {Min: 4096, Max: 64 << 20},
+	"prefetch.workers":               {Min: 1, Max: 256},
+	"prefetch.queue_size":            {Min: 1, Max: 1000000},
+	"ksm.scan_interval":              {Min: 0, Max: 60000},
+	"ksm.pages_to_scan":              {Min: 0, Max: 1000000},
+	"dedupd.workers":                 {Min: 0, Max: 256},
+	"dedupd.ondemand_rate_mb":        {Min: 0, Max: 100000},
+	"dedupd.ondemand_image_rate_mb":  {Min: 0, Max: 100000},
+	"dedupd.ondemand_burst_mb":       {Min: 0, Max: 100000},
+	"dedupd.volume_gc_interval":      {Min: 1, Max: 86400},
+	"dedupd.negative_cache_ttl":      {Min: 1, Max: 86400},
+	"dedupd.fallback_failures":       {Min: 1, Max: 1000},
+	"dedupd.fallback_cooldown":       {Min: 1, Max: 86400},
+	"flatten.threshold":              {Min: 2, Max: 500},
+	"conversion.workers":             {Min: 1, Max: 64},
+	"conversion.queue_size":          {Min: 1, Max: 100000},
+	"metrics_push.interval":          {Min: 1, Max: 86400},
+	"alerts.min_dedup_ratio":         {Min: 0, Max: 100},
+	"alerts.min_cache_hit_rate":      {Min: 0, Max: 100},
+	"alerts.max_disk_usage":          {Min: 0, Max: 100},
+	"alerts.interval":                {Min: 1, Max: 86400},
+	"incremental_chunk.quiet_period": {Min: 1, Max: 3600},
+	"bind_mounts.reap_interval":      {Min: 1, Max: 86400},
+	"stats_history.interval":         {Min: 1, Max: 86400},
+	"stats_history.retention_days":   {Min: 1, Max: 3650},
+	"recovery.workers":               {Min: 1, Max: 1024},
+	"timeouts.mount":                 {Min: 1, Max: 3600},
+	"timeouts.build":                 {Min: 1, Max: 86400},
+	"timeouts.freeze":                {Min: 1, Max: 3600},
+	"timeouts.usage":                 {Min: 1, Max: 3600},
+	"startup_trace.window":           {Min: 1, Max: 86400},
+	"startup_trace.history":          {Min: 1, Max: 100000},
+	"background.nice":                {Min: 0, Max: 19},
+	"background.io_level":            {Min: 0, Max: 7},
+	"background.cpu_weight":          {Min: 1, Max: 10000},
+	"scratch.max_mb":                 {Min: 0, Max: 1 << 30},
+	"scratch.min_free_mb":            {Min: 0, Max: 1 << 30},
+	"mem_dedup.reclaim_interval":     {Min: 1, Max: 86400},
+	"mem_dedup.reclaim_cold_after":   {Min: 1, Max: 255},
+	"debug.mutex_profile_fraction":   {Min: 0, Max: 1000000},
+	"debug.block_profile_rate":       {Min: 0, Max: 1000000000},
+	"chunk_cache.max_mb":             {Min: 1, Max: 1 << 20},
+	"chunk_cache.index_entries":      {Min: 0, Max: 1 << 24},
+	"disk_watch.interval":            {Min: 1, Max: 86400},
+	"disk_watch.pause_percent":       {Min: 0, Max: 100},
+	"disk_watch.evict_percent":       {Min: 0, Max: 100},
+	"disk_watch.gc_percent":          {Min: 0, Max: 100},
+	"disk_watch.refuse_percent":      {Min: 0, Max: 100},
+	"retention.interval":             {Min: 60, Max: 604800},
+	"retention.keep_last_tags":       {Min: 0, Max: 10000},
+	"retention.unused_days":          {Min: 0, Max: 3650},
+	"warmup.top_n":                   {Min: 1, Max: 1000},
+	"metrics_labels.max_series":      {Min: 1, Max: 100000},
+	"maintenance.outside_delay_ms":   {Min: 0, Max: 3600000},
+	"durability.copies":              {Min: 1, Max: 16},
+	"durability.data_shards":         {Min: 1, Max: 16},
+	"durability.parity_shards":       {Min: 1, Max: 8},
+	"buffer_pool.huge_page_buffers":  {Min: 0, Max: 4096},
+	"scan.timeout":                   {Min: 1, Max: 3600},
+	"accounting.reset_interval":      {Min: 0, Max: 8760},
+	"proxy.workers":                  {Min: 1, Max: 64},
+	"proxy.max_layer_mb":             {Min: 0, Max: 1 << 20},
+	"slow_log.prepare_ms":            {Min: 0, Max: 86400000},
+	"slow_log.mount_ms":              {Min: 0, Max: 86400000},
+	"slow_log.conversion_ms":         {Min: 0, Max: 86400000},
+	"slow_log.download_ms":           {Min: 0, Max: 86400000},
+	"slow_log.max_per_minute":        {Min: 1, Max: 10000},
+	"slow_log.stack_kb":              {Min: 1, Max: 16384},
+}
+
+// absolutePaths 列出必须为绝对路径的字段
+var absolutePaths = []string{"root", "prefetch.trace_dir", "bind_mounts.kubelet_pods_dir", "signing.key", "mount_namespace", "mirror.content_root", "scratch.dir"}
+
+// fieldEnums 列出取值受限的字符串字段
+var fieldEnums = map[string][]string{
+	"log_level":               {"debug", "info", "warn", "error"},
+	"metrics_push.mode":       {"pushgateway", "remote_write"},
+	"bind_mounts.propagation": {"rprivate", "rslave", "rshared"},
+	"recovery.verify_mode":    {VerifyModeNone, VerifyModeQuick, VerifyModeFull},
+	"background.io_class":     {IOClassNone, IOClassBestEffort, IOClassIdle},
+	"mem_dedup.reclaim":       {ReclaimOff, ReclaimCold, ReclaimPageout},
+	"durability.mode":         {DurabilityOff, DurabilityReplicate, DurabilityErasure},
+}
+
+// deprecatedFields 是旧版本写入过的字段,只告警不拒绝,值为替代字段,为空表示已移除且没有替代
+var deprecatedFields = map[string]string{
+	"enable_lazy":            "enable_fscache",
+	"mem_dedup.ksm_target":   "",
+	"mem_dedup.ksm_interval": "",
+}
+
+// Schema 返回描述 Config 的类 JSON Schema 文档,default 取自 DefaultConfig(root)
+func Schema(root string) map[string]interface{} {
+	schema := schemaFor(reflect.TypeOf(Config{}), reflect.ValueOf(*DefaultConfig(root)), "")
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "dedup-snapshotter config"
+	return schema
+}
+
+func schemaFor(t reflect.Type, def reflect.Value, path string) map[string]interface{} {
+	s := map[string]interface{}{}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		for i := 0; i < t.NumField(); i++ {
+			name := jsonName(t.Field(i))
+			if name == "" {
+				continue
+			}
+			// 切片元素等没有默认值的结构体,其字段也没有默认值
+			var fieldDef reflect.Value
+			if def.IsValid() {
+				fieldDef = def.Field(i)
+			}
+			properties[name] = schemaFor(t.Field(i).Type, fieldDef, joinPath(path, name))
+		}
+		s["type"] = "object"
+		s["properties"] = properties
+		s["additionalProperties"] = false
+		return s
+	case reflect.Bool:
+		s["type"] = "boolean"
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		s["type"] = "integer"
+	case reflect.Float32, reflect.Float64:
+		s["type"] = "number"
+	case reflect.String:
+		s["type"] = "string"
+	case reflect.Slice:
+		s["type"] = "array"
+		s["items"] = schemaFor(t.Elem(), reflect.Value{}, path)
+	case reflect.Map:
+		s["type"] = "object"
+		s["additionalProperties"] = schemaFor(t.Elem(), reflect.Value{}, path)
+	}
+
+	if r, ok := fieldRanges[path]; ok {
+		s["minimum"] = r.Min
+		s["maximum"] = r.Max
+	}
+	if values, ok := fieldEnums[path]; ok {
+		s["enum"] = values
+	}
+	if def.IsValid() && !(def.Kind() == reflect.Map && def.IsNil()) {
+		s["default"] = def.Interface()
+	}
+	return s
+}
+
+// unknownFields 对照 Config 结构查找原始 JSON 中无法识别的字段,并给出拼写相近的建议
+func unknownFields(data []byte) ([]string, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	walkUnknown(raw, reflect.TypeOf(Config{}), "", &problems)
+	sort.Strings(problems)
+	return problems, nil
+}
+
+func walkUnknown(raw map[string]interface{}, t reflect.Type, path string, problems *[]string) {
+	known := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		if name := jsonName(t.Field(i)); name != "" {
+			known[name] = t.Field(i).Type
+		}
+	}
+
+	for key, value := range raw {
+		fieldType, ok := known[key]
+		if replacement, deprecated := deprecatedFields[joinPath(path, key)]; !ok && deprecated {
+			if replacement == "" {
+				log.L.Warnf("config field %q has been removed and is ignored", joinPath(path, key))
+			} else {
+				log.L.Warnf("config field %q is deprecated and ignored, use %q instead", joinPath(path, key), replacement)
+			}
+			continue
+		}
+		if !ok {
+			problem := fmt.Sprintf("unknown field %q", joinPath(path, key))
+			if suggestion := closestName(key, known); suggestion != "" {
+				problem += fmt.Sprintf(" (did you mean %q?)", joinPath(path, suggestion))
+			}
+			*problems = append(*problems, problem)
+			continue
+		}
+		if nested, isObject := value.(map[string]interface{}); isObject && fieldType.Kind() == reflect.Struct {
+			walkUnknown(nested, fieldType, joinPath(path, key), problems)
+		}
+	}
+}
+
+// checkRanges 检查数值范围、绝对路径和枚举值,返回全部问题而不是第一个
+func (c *Config) checkRanges() []string {
+	var problems []string
+	values := flatten(reflect.ValueOf(*c), "")
+
+	for path, r := range fieldRanges {
+		v, ok := values[path]
+		if !ok {
+			continue
+		}
+		var f float64
+		switch v.Kind() {
+		case reflect.Int, reflect.Int32, reflect.Int64:
+			f = float64(v.Int())
+		case reflect.Float32, reflect.Float64:
+			f = v.Float()
+		default:
+			continue
+		}
+		if f < r.Min || f > r.Max {
+			problems = append(problems, fmt.Sprintf("%s must be between %g and %g, got %g", path, r.Min, r.Max, f))
+		}
+	}
+
+	for _, path := range absolutePaths {
+		if v, ok := values[path]; ok && v.String() != "" && !filepath.IsAbs(v.String()) {
+			problems = append(problems, fmt.Sprintf("%s must be an absolute path, got %q", path, v.String()))
+		}
+	}
+
+	for path, allowed := range fieldEnums {
+		v, ok := values[path]
+		if !ok || v.String() == "" {
+			continue
+		}
+		found := false
+		for _, a := range allowed {
+			if v.String() == a {
+				found = true
+				break
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("%s must be one of %s, got %q", path, strings.Join(allowed, ", "), v.String()))
+		}
+	}
+
+	sort.Strings(problems)
+	return problems
+}
+
+// flatten 把结构体展开为点分路径到字段值的映射
+func flatten(v reflect.Value, path string) map[string]reflect.Value {
+	values := map[string]reflect.Value{}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := jsonName(t.Field(i))
+		if name == "" {
+			continue
+		}
+		p := joinPath(path, name)
+		if t.Field(i).Type.Kind() == reflect.Struct {
+			for k, fv := range flatten(v.Field(i), p) {
+				values[k] = fv
+			}
+			continue
+		}
+		values[p] = v.Field(i)
+	}
+	return values
+}
+
+func jsonName(f reflect.StructField) string {
+	if !f.IsExported() {
+		return ""
+	}
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return ""
+	}
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name
+	}
+	return f.Name
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// closestName 返回编辑距离不超过 2 的最相近字段名
+func closestName(name string, known map[string]reflect.Type) string {
+	best, bestDist := "", 3
+	for candidate := range known {
+		if d := editDistance(name, candidate); d < bestDist || (d == bestDist && candidate < best) {
+			best, bestDist = candidate, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
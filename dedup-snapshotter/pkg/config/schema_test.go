@@ -0,0 +1,67 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestLoadConfigRejectsUnknownFields 验证拼错的字段被拒绝并给出建议,越界数值被报告
+func TestLoadConfigRejectsUnknownFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`{"root": "/var/lib/dedup", "chunk_size": 4194304, "enable_erfs": true, "prefetch": {"wrokers": 8}}`)
+	_, err := LoadConfig(path)
+	if err == nil {
+		t.Fatal("expected unknown fields to be rejected")
+	}
+	for _, want := range []string{`did you mean "enable_erofs"`, `did you mean "prefetch.workers"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in error, got %v", want, err)
+		}
+	}
+	t.Logf("✓ 未知字段被拒绝: %v", err)
+
+	write(`{"root": "/var/lib/dedup", "chunk_size": 4194304, "prefetch": {"workers": 1000}}`)
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "prefetch.workers must be between 1 and 256") {
+		t.Fatalf("expected range error, got %v", err)
+	}
+	t.Logf("✓ 越界数值被拒绝")
+
+	write(`{"root": "/var/lib/dedup", "chunk_size": 4194304}`)
+	if _, err := LoadConfig(path); err != nil {
+		t.Fatalf("expected minimal config to load: %v", err)
+	}
+
+	// 旧版本保存的已移除字段只告警
+	write(`{"root": "/var/lib/dedup", "chunk_size": 4194304, "mem_dedup": {"ksm_target": "files", "ksm_interval": 30}}`)
+	if _, err := LoadConfig(path); err != nil {
+		t.Fatalf("expected removed fields to be ignored: %v", err)
+	}
+}
+
+// TestSchemaDefaults 验证 schema 中携带默认值和范围
+func TestSchemaDefaults(t *testing.T) {
+	schema := Schema("/var/lib/dedup")
+	if schema["additionalProperties"] != false {
+		t.Fatal("expected top-level additionalProperties to be false")
+	}
+
+	properties := schema["properties"].(map[string]interface{})
+	prefetch := properties["prefetch"].(map[string]interface{})["properties"].(map[string]interface{})
+	workers := prefetch["workers"].(map[string]interface{})
+	if workers["default"] != 4 || workers["maximum"] != float64(256) {
+		t.Errorf("unexpected prefetch.workers schema: %v", workers)
+	}
+	if properties["enable_erofs"].(map[string]interface{})["default"] != true {
+		t.Errorf("expected enable_erofs default true")
+	}
+	t.Logf("✓ schema 包含默认值和范围")
+}
@@ -0,0 +1,31 @@
+package config
+
+// RedactedValue 是 API 返回配置时替换密钥字段的占位符
+const RedactedValue = "[REDACTED]"
+
+// secretFields 返回 c 中不能通过 API 读出的密钥字段
+func (c *Config) secretFields() []*string {
+	return []*string{&c.API.Token, &c.Debug.Token, &c.Webhook.Secret}
+}
+
+// Redacted 返回把非空密钥字段替换为 RedactedValue 的副本,原配置不变
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	for _, field := range redacted.secretFields() {
+		if *field != "" {
+			*field = RedactedValue
+		}
+	}
+	return &redacted
+}
+
+// RestoreSecrets 把值为 RedactedValue 的密钥字段恢复为 old 中的值,
+// 读取后原样写回的配置不会把占位符当作新的密钥
+func (c *Config) RestoreSecrets(old *Config) {
+	oldFields := old.secretFields()
+	for i, field := range c.secretFields() {
+		if *field == RedactedValue {
+			*field = *oldFields[i]
+		}
+	}
+}
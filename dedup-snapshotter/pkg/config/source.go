@@ -0,0 +1,53 @@
+package config
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/containerd/log"
+)
+
+// Source 是进程内共享的当前配置。读取无锁,更新时整体替换配置并按注册顺序通知订阅者;
+// 发布后的 Config 视为只读,修改时需复制后再 Update
+type Source struct {
+	current atomic.Pointer[Config]
+
+	// mu 串行化更新,订阅者按更新顺序收到通知
+	mu          sync.Mutex
+	subscribers []ConfigCallback
+}
+
+// NewSource 创建以 cfg 为初始配置的配置源
+func NewSource(cfg *Config) *Source {
+	s := &Source{}
+	s.current.Store(cfg)
+	return s
+}
+
+// Get 返回当前配置,nil 配置源返回 nil
+func (s *Source) Get() *Config {
+	if s == nil {
+		return nil
+	}
+	return s.current.Load()
+}
+
+// Subscribe 注册配置变更回调
+func (s *Source) Subscribe(callback ConfigCallback) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscribers = append(s.subscribers, callback)
+}
+
+// Update 替换当前配置并通知订阅者,回调失败只记录日志
+func (s *Source) Update(newConfig *Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	oldConfig := s.current.Swap(newConfig)
+	for _, callback := range s.subscribers {
+		if err := callback(oldConfig, newConfig); err != nil {
+			log.L.WithError(err).Error("config callback failed")
+		}
+	}
+}
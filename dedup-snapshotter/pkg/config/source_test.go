@@ -0,0 +1,68 @@
+package config
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// TestSourceSharedUpdates 验证经 watcher 保存的配置发布到共享配置源并通知回调,且并发读写安全
+func TestSourceSharedUpdates(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "config.json")
+	cfg := DefaultConfig(root)
+	if err := cfg.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	source := NewSource(cfg)
+	watcher, err := NewConfigWatcherWithSource(path, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Stop()
+
+	var notified []int
+	watcher.AddCallback(func(oldConfig, newConfig *Config) error {
+		if oldConfig.Prefetch.Workers == newConfig.Prefetch.Workers {
+			t.Errorf("callback got unchanged config")
+		}
+		notified = append(notified, newConfig.Prefetch.Workers)
+		return nil
+	})
+
+	updated := *cfg
+	updated.Prefetch.Workers = cfg.Prefetch.Workers + 1
+	if err := watcher.UpdateConfig(&updated); err != nil {
+		t.Fatal(err)
+	}
+	if source.Get() != &updated || watcher.GetConfig() != &updated {
+		t.Fatal("expected update to be visible through the shared source")
+	}
+	if len(notified) != 1 || notified[0] != updated.Prefetch.Workers {
+		t.Fatalf("expected one callback with the new config, got %v", notified)
+	}
+	t.Logf("✓ 保存的配置对所有持有者可见并通知回调")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if i%2 == 0 {
+					next := *source.Get()
+					next.Prefetch.Workers = i*100 + j
+					source.Update(&next)
+				} else if source.Get() == nil {
+					t.Error("unexpected nil config")
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if len(notified) != 401 {
+		t.Errorf("expected 401 notifications, got %d", len(notified))
+	}
+	t.Logf("✓ 并发读写配置源安全")
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"context"
 	"os"
+	"reflect"
 	"sync"
 	"time"
 
@@ -10,18 +11,25 @@ import (
 	"github.com/fsnotify/fsnotify"
 )
 
+// ConfigWatcher 监视配置文件,变更时更新共享的配置源。API 等其他更新方与 watcher 共用同一配置源,
+// 回调对所有来源的更新都生效
 type ConfigWatcher struct {
 	path        string
-	config      *Config
+	source      *Source
 	watcher     *fsnotify.Watcher
-	callbacks   []ConfigCallback
-	mu          sync.RWMutex
+	// mu 保护 lastModTime,API 保存配置后会更新它
+	mu          sync.Mutex
 	lastModTime time.Time
 }
 
 type ConfigCallback func(oldConfig, newConfig *Config) error
 
 func NewConfigWatcher(configPath string, initialConfig *Config) (*ConfigWatcher, error) {
+	return NewConfigWatcherWithSource(configPath, NewSource(initialConfig))
+}
+
+// NewConfigWatcherWithSource 创建更新 source 的 watcher
+func NewConfigWatcherWithSource(configPath string, source *Source) (*ConfigWatcher, error) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return nil, err
@@ -34,7 +42,7 @@ func NewConfigWatcher(configPath string, initialConfig *Config) (*ConfigWatcher,
 
 	cw := &ConfigWatcher{
 		path:        configPath,
-		config:      initialConfig,
+		source:      source,
 		watcher:     watcher,
 		lastModTime: stat.ModTime(),
 	}
@@ -48,9 +56,12 @@ func NewConfigWatcher(configPath string, initialConfig *Config) (*ConfigWatcher,
 }
 
 func (cw *ConfigWatcher) AddCallback(callback ConfigCallback) {
-	cw.mu.Lock()
-	defer cw.mu.Unlock()
-	cw.callbacks = append(cw.callbacks, callback)
+	cw.source.Subscribe(callback)
+}
+
+// Source 返回 watcher 更新的配置源
+func (cw *ConfigWatcher) Source() *Source {
+	return cw.source
 }
 
 func (cw *ConfigWatcher) Start(ctx context.Context) {
@@ -62,9 +73,7 @@ func (cw *ConfigWatcher) Stop() error {
 }
 
 func (cw *ConfigWatcher) GetConfig() *Config {
-	cw.mu.RLock()
-	defer cw.mu.RUnlock()
-	return cw.config
+	return cw.source.Get()
 }
 
 func (cw *ConfigWatcher) watchLoop(ctx context.Context) {
@@ -87,8 +96,13 @@ func (cw *ConfigWatcher) watchLoop(ctx context.Context) {
 					continue
 				}
 
-				if stat.ModTime().After(cw.lastModTime) {
+				cw.mu.Lock()
+				changed := stat.ModTime().After(cw.lastModTime)
+				if changed {
 					cw.lastModTime = stat.ModTime()
+				}
+				cw.mu.Unlock()
+				if changed {
 					debounceTimer.Reset(100 * time.Millisecond)
 				}
 			}
@@ -117,37 +131,29 @@ func (cw *ConfigWatcher) reloadConfig() {
 		return
 	}
 
-	cw.mu.Lock()
-	oldConfig := cw.config
-	cw.config = newConfig
-	callbacks := append([]ConfigCallback{}, cw.callbacks...)
-	cw.mu.Unlock()
-
-	for _, callback := range callbacks {
-		if err := callback(oldConfig, newConfig); err != nil {
-			log.L.WithError(err).Error("config callback failed")
-		}
+	// 经 UpdateConfig 保存的配置已经发布,文件变更不再重复通知
+	if reflect.DeepEqual(newConfig, cw.source.Get()) {
+		log.L.Debug("config file matches current config, skipping reload")
+		return
 	}
+	cw.source.Update(newConfig)
 
 	log.L.Info("config reloaded successfully")
 }
 
+// UpdateConfig 保存配置到文件并发布到配置源
 func (cw *ConfigWatcher) UpdateConfig(newConfig *Config) error {
-	cw.mu.Lock()
-	defer cw.mu.Unlock()
-
 	if err := newConfig.Save(cw.path); err != nil {
 		return err
 	}
-
-	oldConfig := cw.config
-	cw.config = newConfig
-
-	for _, callback := range cw.callbacks {
-		if err := callback(oldConfig, newConfig); err != nil {
-			log.L.WithError(err).Error("config callback failed")
+	if stat, err := os.Stat(cw.path); err == nil {
+		cw.mu.Lock()
+		if stat.ModTime().After(cw.lastModTime) {
+			cw.lastModTime = stat.ModTime()
 		}
+		cw.mu.Unlock()
 	}
 
+	cw.source.Update(newConfig)
 	return nil
 }
\ No newline at end of file
@@ -0,0 +1,301 @@
+package cri
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/containerd/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// 需要拦截的 CRI 方法,其余调用原样转发给上游
+var pullImageMethods = map[string]bool{
+	"/runtime.v1.ImageService/PullImage":       true,
+	"/runtime.v1alpha2.ImageService/PullImage": true,
+}
+
+var startContainerMethods = map[string]bool{
+	"/runtime.v1.RuntimeService/StartContainer":       true,
+	"/runtime.v1alpha2.RuntimeService/StartContainer": true,
+}
+
+// startReportTimeout 是报告容器启动的超时,报告在后台进行,不延迟 StartContainer 的响应
+const startReportTimeout = 10 * time.Second
+
+// PriorityAnnotation 是 Pod 上的镜像转换优先级提示(low、normal 或 high),
+// PullImage 请求中带有 Pod 配置时随拉取一起传给快照服务
+const PriorityAnnotation = "dedup.opencloudos.io/conversion-priority"
+
+// Puller 在 PullImage 转发给上游前物化镜像,使上游解包时直接复用已有层。
+// priority 为 Pod 上的 PriorityAnnotation,未设置时为空
+type Puller interface {
+	Pull(ctx context.Context, ref, priority string) error
+}
+
+// Proxy 是透明的 CRI 代理:所有 RPC 以原始字节转发给上游(containerd),
+// 只在 PullImage 前先通过 Puller 做去重感知的拉取。拉取失败时仍然转发,
+// 由上游按普通方式拉取
+type Proxy struct {
+	upstream *grpc.ClientConn
+	puller   Puller
+	notifier StartNotifier
+	server   *grpc.Server
+}
+
+// StartNotifier 在 StartContainer 成功后收到容器 ID,用于结束冷启动追踪
+type StartNotifier interface {
+	ContainerStarted(ctx context.Context, containerID string) error
+}
+
+func NewProxy(upstream *grpc.ClientConn, puller Puller) *Proxy {
+	p := &Proxy{
+		upstream: upstream,
+		puller:   puller,
+	}
+	p.server = grpc.NewServer(
+		grpc.ForceServerCodec(rawCodec{}),
+		grpc.UnknownServiceHandler(p.handle),
+	)
+	return p
+}
+
+// Dial 连接上游 CRI 服务的 unix socket
+func Dial(ctx context.Context, socketPath string) (*grpc.ClientConn, error) {
+	return grpc.DialContext(ctx, "unix://"+socketPath,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(rawCodec{})),
+	)
+}
+
+func (p *Proxy) SetStartNotifier(n StartNotifier) {
+	p.notifier = n
+}
+
+func (p *Proxy) Serve(l net.Listener) error {
+	return p.server.Serve(l)
+}
+
+func (p *Proxy) Stop() {
+	p.server.GracefulStop()
+}
+
+func (p *Proxy) handle(_ interface{}, stream grpc.ServerStream) error {
+	method, ok := grpc.MethodFromServerStream(stream)
+	if !ok {
+		return status.Error(codes.Internal, "unknown method")
+	}
+
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		ctx = metadata.NewOutgoingContext(ctx, md.Copy())
+	}
+
+	upstream, err := p.upstream.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}, method)
+	if err != nil {
+		return err
+	}
+
+	var started atomic.Value
+	c2s := make(chan error, 1)
+	s2c := make(chan error, 1)
+	go func() { c2s <- p.forwardRequests(ctx, method, stream, upstream, &started) }()
+	go func() { s2c <- forwardResponses(upstream, stream) }()
+
+	for {
+		select {
+		case err := <-c2s:
+			if err != nil {
+				return status.Errorf(codes.Internal, "failed to forward %s: %v", method, err)
+			}
+			// 请求方向结束后只等待上游响应
+			c2s = nil
+		case err := <-s2c:
+			stream.SetTrailer(upstream.Trailer())
+			if err == io.EOF {
+				if id, ok := started.Load().(string); ok {
+					go p.reportStarted(id)
+				}
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func (p *Proxy) forwardRequests(ctx context.Context, method string, src grpc.ServerStream, dst grpc.ClientStream, started *atomic.Value) error {
+	for {
+		f := &frame{}
+		if err := src.RecvMsg(f); err != nil {
+			if err == io.EOF {
+				return dst.CloseSend()
+			}
+			return err
+		}
+		if pullImageMethods[method] {
+			p.prePull(ctx, f.payload)
+		}
+		if startContainerMethods[method] && p.notifier != nil {
+			if id, err := protoBytesField(f.payload, 1); err == nil {
+				started.Store(string(id))
+			}
+		}
+		if err := dst.SendMsg(f); err != nil {
+			return err
+		}
+	}
+}
+
+func forwardResponses(src grpc.ClientStream, dst grpc.ServerStream) error {
+	header, err := src.Header()
+	if err != nil {
+		return err
+	}
+	if err := dst.SendHeader(header); err != nil {
+		return err
+	}
+
+	for {
+		f := &frame{}
+		if err := src.RecvMsg(f); err != nil {
+			return err
+		}
+		if err := dst.SendMsg(f); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *Proxy) prePull(ctx context.Context, request []byte) {
+	ref, err := pullImageRef(request)
+	if err != nil {
+		log.G(ctx).WithError(err).Warn("failed to decode PullImage request")
+		return
+	}
+	if err := p.puller.Pull(ctx, ref, podPriority(request)); err != nil {
+		log.G(ctx).WithError(err).Warnf("dedup pull of %s failed, falling back to upstream pull", ref)
+		return
+	}
+	log.G(ctx).Infof("materialized %s before upstream pull", ref)
+}
+
+// reportStarted 通知快照服务容器已启动,CRI 下容器的 rootfs 快照键就是容器 ID。
+// StartContainerRequest.container_id 字段编号为 1
+func (p *Proxy) reportStarted(containerID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), startReportTimeout)
+	defer cancel()
+	if err := p.notifier.ContainerStarted(ctx, containerID); err != nil {
+		log.L.WithError(err).Debugf("failed to report start of container %s", containerID)
+	}
+}
+
+// pullImageRef 从 PullImageRequest 中取出 image.image,
+// 字段编号见 CRI api.proto: PullImageRequest.image = 1, ImageSpec.image = 1
+func pullImageRef(request []byte) (string, error) {
+	spec, err := protoBytesField(request, 1)
+	if err != nil {
+		return "", fmt.Errorf("missing image spec: %w", err)
+	}
+	ref, err := protoBytesField(spec, 1)
+	if err != nil {
+		return "", fmt.Errorf("missing image reference: %w", err)
+	}
+	return string(ref), nil
+}
+
+// podPriority 从 PullImageRequest.sandbox_config 的 annotations 中取出 PriorityAnnotation,
+// 字段编号见 CRI api.proto: PullImageRequest.sandbox_config = 3, PodSandboxConfig.annotations = 7,
+// map 条目的 key = 1, value = 2。无效的取值忽略,不影响拉取
+func podPriority(request []byte) string {
+	config, err := protoBytesField(request, 3)
+	if err != nil {
+		return ""
+	}
+	for len(config) > 0 {
+		n, typ, l := protowire.ConsumeTag(config)
+		if l < 0 {
+			return ""
+		}
+		config = config[l:]
+		l = protowire.ConsumeFieldValue(n, typ, config)
+		if l < 0 {
+			return ""
+		}
+		if n == 7 && typ == protowire.BytesType {
+			entry, _ := protowire.ConsumeBytes(config[:l])
+			key, _ := protoBytesField(entry, 1)
+			if string(key) == PriorityAnnotation {
+				value, _ := protoBytesField(entry, 2)
+				switch v := string(value); v {
+				case "low", "normal", "high":
+					return v
+				}
+				return ""
+			}
+		}
+		config = config[l:]
+	}
+	return ""
+}
+
+func protoBytesField(b []byte, num protowire.Number) ([]byte, error) {
+	for len(b) > 0 {
+		n, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			return nil, protowire.ParseError(l)
+		}
+		b = b[l:]
+
+		if n == num && typ == protowire.BytesType {
+			v, l := protowire.ConsumeBytes(b)
+			if l < 0 {
+				return nil, protowire.ParseError(l)
+			}
+			return v, nil
+		}
+		l = protowire.ConsumeFieldValue(n, typ, b)
+		if l < 0 {
+			return nil, protowire.ParseError(l)
+		}
+		b = b[l:]
+	}
+	return nil, fmt.Errorf("field %d not found", num)
+}
+
+// frame 是未解码的 protobuf 消息
+type frame struct {
+	payload []byte
+}
+
+// rawCodec 不做编解码,直接传递消息字节
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	f, ok := v.(*frame)
+	if !ok {
+		return nil, fmt.Errorf("unexpected message type %T", v)
+	}
+	return f.payload, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	f, ok := v.(*frame)
+	if !ok {
+		return fmt.Errorf("unexpected message type %T", v)
+	}
+	f.payload = append(f.payload[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
@@ -0,0 +1,137 @@
+package cri
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type recordingPuller struct {
+	mu         sync.Mutex
+	refs       []string
+	priorities []string
+}
+
+func (r *recordingPuller) Pull(ctx context.Context, ref, priority string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.refs = append(r.refs, ref)
+	r.priorities = append(r.priorities, priority)
+	return nil
+}
+
+// withPodAnnotation 在 PullImageRequest 后追加带一个 annotation 的 sandbox_config
+func withPodAnnotation(req []byte, key, value string) []byte {
+	var entry []byte
+	entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+	entry = protowire.AppendString(entry, key)
+	entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+	entry = protowire.AppendString(entry, value)
+
+	var config []byte
+	// labels 字段在前,验证只读取 annotations
+	config = protowire.AppendTag(config, 6, protowire.BytesType)
+	config = protowire.AppendBytes(config, entry)
+	config = protowire.AppendTag(config, 7, protowire.BytesType)
+	config = protowire.AppendBytes(config, entry)
+
+	req = protowire.AppendTag(req, 3, protowire.BytesType)
+	return protowire.AppendBytes(req, config)
+}
+
+func pullImageRequest(ref string) []byte {
+	var spec []byte
+	spec = protowire.AppendTag(spec, 1, protowire.BytesType)
+	spec = protowire.AppendString(spec, ref)
+
+	var req []byte
+	// auth 字段排在前面,验证解析会跳过无关字段
+	req = protowire.AppendTag(req, 2, protowire.BytesType)
+	req = protowire.AppendBytes(req, []byte{0x0a, 0x01, 'u'})
+	req = protowire.AppendTag(req, 1, protowire.BytesType)
+	return protowire.AppendBytes(req, spec)
+}
+
+func serveUnix(t *testing.T, srv interface{ Serve(net.Listener) error }, path string) {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+}
+
+// TestProxyPrePullsAndForwards 验证 PullImage 先触发去重拉取再转发,其他调用只转发
+func TestProxyPrePullsAndForwards(t *testing.T) {
+	dir := t.TempDir()
+
+	// 上游回显请求字节,代理对消息内容透明
+	upstream := grpc.NewServer(
+		grpc.ForceServerCodec(rawCodec{}),
+		grpc.UnknownServiceHandler(func(_ interface{}, stream grpc.ServerStream) error {
+			f := &frame{}
+			if err := stream.RecvMsg(f); err != nil {
+				return err
+			}
+			return stream.SendMsg(f)
+		}),
+	)
+	defer upstream.Stop()
+	serveUnix(t, upstream, filepath.Join(dir, "upstream.sock"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	upstreamConn, err := Dial(ctx, filepath.Join(dir, "upstream.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstreamConn.Close()
+
+	puller := &recordingPuller{}
+	proxy := NewProxy(upstreamConn, puller)
+	defer proxy.Stop()
+	serveUnix(t, proxy, filepath.Join(dir, "proxy.sock"))
+
+	client, err := Dial(ctx, filepath.Join(dir, "proxy.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	req := &frame{payload: pullImageRequest("docker.io/library/busybox:latest")}
+	resp := &frame{}
+	if err := client.Invoke(ctx, "/runtime.v1.ImageService/PullImage", req, resp); err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.payload) != string(req.payload) {
+		t.Error("expected response to be forwarded unchanged")
+	}
+
+	status := &frame{payload: []byte{0x0a, 0x00}}
+	if err := client.Invoke(ctx, "/runtime.v1.ImageService/ImageStatus", status, &frame{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(puller.refs) != 1 || puller.refs[0] != "docker.io/library/busybox:latest" {
+		t.Fatalf("expected one pre-pull of busybox, got %v", puller.refs)
+	}
+	if puller.priorities[0] != "" {
+		t.Errorf("expected no priority without sandbox config, got %q", puller.priorities[0])
+	}
+	t.Logf("✓ PullImage 转发前物化 %s", puller.refs[0])
+
+	req = &frame{payload: withPodAnnotation(pullImageRequest("docker.io/library/nginx:latest"), PriorityAnnotation, "high")}
+	if err := client.Invoke(ctx, "/runtime.v1.ImageService/PullImage", req, &frame{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(puller.priorities) != 2 || puller.priorities[1] != "high" {
+		t.Fatalf("expected pod priority high to be passed to the puller, got %v", puller.priorities)
+	}
+	t.Logf("✓ Pod 上的转换优先级随拉取传递")
+}
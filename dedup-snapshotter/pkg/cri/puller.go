@@ -0,0 +1,36 @@
+package cri
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/client"
+)
+
+// APIPuller 调用 dedup-snapshotter 的 /api/v2/images/pull 物化镜像,
+// 并通过 /api/v2/startup/{id} 报告容器启动
+type APIPuller struct {
+	client *client.Client
+}
+
+func NewAPIPuller(apiAddress, token string, httpClient *http.Client) *APIPuller {
+	c := client.NewWithHTTPClient(apiAddress, httpClient)
+	c.SetToken(token)
+	return &APIPuller{client: c}
+}
+
+func (p *APIPuller) Pull(ctx context.Context, ref, priority string) error {
+	if _, err := p.client.PullWithPriority(ctx, ref, priority); err != nil {
+		return fmt.Errorf("pull failed: %w", err)
+	}
+	return nil
+}
+
+// ContainerStarted 结束容器的冷启动追踪;快照服务未开启追踪时返回错误,调用方可以忽略
+func (p *APIPuller) ContainerStarted(ctx context.Context, containerID string) error {
+	if _, err := p.client.ContainerStarted(ctx, containerID); err != nil {
+		return fmt.Errorf("startup report failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,396 @@
+// Package durability 把本地 chunk 冗余保存到多个后端:复制模式每个 chunk 保存多份完整副本,
+// 纠删模式切成数据分片和校验分片分散保存。chunk 按 rendezvous 哈希放置到后端,某个后端不可用时
+// 写入顺延到下一个后端,读取时从任意可用副本或足够多的分片恢复,因此丢失单个后端不会使镜像无法恢复
+package durability
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+)
+
+// ErrNotFound 表示后端中没有该对象,或冗余不足以恢复 chunk
+var ErrNotFound = errors.New("chunk not found in durability backends")
+
+// 后端操作
+const (
+	OpRead  = "read"
+	OpWrite = "write"
+)
+
+// shardHeaderSize 是分片文件头:8 字节原始 chunk 长度和 4 字节分片数据的 crc32
+const shardHeaderSize = 12
+
+// Backend 是保存 chunk 副本或分片的后端,对象不存在时 Get 返回 ErrNotFound
+type Backend interface {
+	Name() string
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	Has(key string) (bool, error)
+}
+
+// DirBackend 把对象保存在目录中,按键的前两个字符分子目录
+type DirBackend struct {
+	name string
+	root string
+}
+
+// NewDirBackend 创建以 root 目录为存储的后端。目录在第一次写入时创建,
+// 以便挂载点暂时不可用时进程仍能启动
+func NewDirBackend(name, root string) *DirBackend {
+	return &DirBackend{name: name, root: root}
+}
+
+func (b *DirBackend) Name() string {
+	return b.name
+}
+
+func (b *DirBackend) path(key string) string {
+	if len(key) < 2 {
+		return filepath.Join(b.root, key)
+	}
+	return filepath.Join(b.root, key[:2], key)
+}
+
+// Put 原子地写入对象,先写临时文件再重命名,避免读到写了一半的副本
+func (b *DirBackend) Put(key string, data []byte) error {
+	path := b.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := fmt.Sprintf("%s.tmp-%d", path, os.Getpid())
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (b *DirBackend) Get(key string) ([]byte, error) {
+	data, err := os.ReadFile(b.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (b *DirBackend) Has(key string) (bool, error) {
+	_, err := os.Stat(b.path(key))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Available 报告后端根目录当前是否可访问
+func (b *DirBackend) Available() error {
+	st, err := os.Stat(b.root)
+	if err != nil {
+		return err
+	}
+	if !st.IsDir() {
+		return fmt.Errorf("%s is not a directory", b.root)
+	}
+	return nil
+}
+
+// Op 是对一个后端的一次读写,用于按后端记录指标
+type Op struct {
+	Backend  string
+	Op       string
+	Bytes    int64
+	NotFound bool
+	Err      error
+	Duration time.Duration
+}
+
+// BackendStatus 是一个后端的可用状态
+type BackendStatus struct {
+	Name      string `json:"name"`
+	Path      string `json:"path,omitempty"`
+	Available bool   `json:"available"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Status 是持久化层的配置和各后端的可用状态
+type Status struct {
+	Mode         string          `json:"mode"`
+	Copies       int             `json:"copies,omitempty"`
+	DataShards   int             `json:"data_shards,omitempty"`
+	ParityShards int             `json:"parity_shards,omitempty"`
+	Backends     []BackendStatus `json:"backends"`
+}
+
+// Store 按配置的冗余方式把 chunk 写入多个后端并从中恢复
+type Store struct {
+	cfg      config.DurabilityConfig
+	backends []Backend
+	paths    map[string]string
+	coder    *coder
+	observe  func(Op)
+}
+
+// New 按 cfg 创建持久化层,每个配置的后端是一个目录。cfg.Mode 为 off 时返回错误
+func New(cfg config.DurabilityConfig) (*Store, error) {
+	backends := make([]Backend, 0, len(cfg.Backends))
+	paths := make(map[string]string)
+	for _, b := range cfg.Backends {
+		backends = append(backends, NewDirBackend(b.Name, b.Path))
+		paths[b.Name] = b.Path
+	}
+	s, err := newStore(cfg, backends)
+	if err != nil {
+		return nil, err
+	}
+	s.paths = paths
+	return s, nil
+}
+
+func newStore(cfg config.DurabilityConfig, backends []Backend) (*Store, error) {
+	s := &Store{cfg: cfg, backends: backends}
+	switch cfg.Mode {
+	case config.DurabilityReplicate:
+		if cfg.Copies <= 0 || len(backends) < cfg.Copies {
+			return nil, fmt.Errorf("%d copies require at least as many backends, got %d", cfg.Copies, len(backends))
+		}
+	case config.DurabilityErasure:
+		if len(backends) < cfg.DataShards+cfg.ParityShards {
+			return nil, fmt.Errorf("erasure coding %d+%d requires at least %d backends, got %d",
+				cfg.DataShards, cfg.ParityShards, cfg.DataShards+cfg.ParityShards, len(backends))
+		}
+		c, err := newCoder(cfg.DataShards, cfg.ParityShards)
+		if err != nil {
+			return nil, err
+		}
+		s.coder = c
+	default:
+		return nil, fmt.Errorf("unsupported durability mode %q", cfg.Mode)
+	}
+	return s, nil
+}
+
+// SetObserver 设置每次后端读写的回调
+func (s *Store) SetObserver(fn func(Op)) {
+	s.observe = fn
+}
+
+// Mode 返回冗余方式
+func (s *Store) Mode() string {
+	return s.cfg.Mode
+}
+
+// Status 返回配置和各后端的可用状态
+func (s *Store) Status() Status {
+	status := Status{Mode: s.cfg.Mode}
+	if s.coder != nil {
+		status.DataShards, status.ParityShards = s.cfg.DataShards, s.cfg.ParityShards
+	} else {
+		status.Copies = s.cfg.Copies
+	}
+	for _, b := range s.backends {
+		bs := BackendStatus{Name: b.Name(), Path: s.paths[b.Name()], Available: true}
+		if d, ok := b.(*DirBackend); ok {
+			if err := d.Available(); err != nil {
+				bs.Available, bs.Error = false, err.Error()
+			}
+		}
+		status.Backends = append(status.Backends, bs)
+	}
+	return status
+}
+
+// placement 返回 hash 的后端优先顺序:按后端名和 hash 的 rendezvous 哈希排序,
+// 增删后端只影响少量 chunk 的位置
+func (s *Store) placement(hash string) []Backend {
+	type scored struct {
+		b     Backend
+		score uint64
+	}
+	list := make([]scored, len(s.backends))
+	for i, b := range s.backends {
+		sum := sha256.Sum256([]byte(b.Name() + "/" + hash))
+		list[i] = scored{b, binary.BigEndian.Uint64(sum[:8])}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].score > list[j].score })
+	order := make([]Backend, len(list))
+	for i, e := range list {
+		order[i] = e.b
+	}
+	return order
+}
+
+// Put 按冗余方式写入 chunk。部分后端写入失败时顺延到其他后端,
+// 仍不足配置的副本或分片数时返回错误,已写入的部分保留,由 Ensure 之后补齐
+func (s *Store) Put(ctx context.Context, hash string, data []byte) error {
+	_, err := s.ensure(ctx, hash, data, false)
+	return err
+}
+
+// Ensure 检查 chunk 的副本或分片是否齐全,补写缺失的部分,返回补写的数量
+func (s *Store) Ensure(ctx context.Context, hash string, data []byte) (int, error) {
+	return s.ensure(ctx, hash, data, true)
+}
+
+func (s *Store) ensure(ctx context.Context, hash string, data []byte, check bool) (int, error) {
+	if s.coder == nil {
+		objects := [][]byte{data}
+		return s.place(ctx, hash, objects, s.cfg.Copies, check)
+	}
+	shards := s.coder.encode(data)
+	objects := make([][]byte, len(shards))
+	for i, shard := range shards {
+		obj := make([]byte, shardHeaderSize+len(shard))
+		binary.LittleEndian.PutUint64(obj[0:], uint64(len(data)))
+		binary.LittleEndian.PutUint32(obj[8:], crc32.ChecksumIEEE(shard))
+		copy(obj[shardHeaderSize:], shard)
+		objects[i] = obj
+	}
+	return s.place(ctx, hash, objects, 1, check)
+}
+
+// place 把 objects 中每个对象各写 copies 份到互不相同的后端。复制模式只有一个对象写 Copies 份,
+// 纠删模式每个分片写一份,分片 i 的键为 hash.i。check 为 true 时已存在的对象不重写
+func (s *Store) place(ctx context.Context, hash string, objects [][]byte, copies int, check bool) (int, error) {
+	order := s.placement(hash)
+	used := make([]bool, len(order))
+	written := 0
+	var errs []error
+
+	key := func(i int) string {
+		if s.coder == nil {
+			return hash
+		}
+		return hash + "." + strconv.Itoa(i)
+	}
+
+	// 先找出已经保存了对象的后端,只在 check 时需要
+	have := make([]int, len(objects))
+	if check {
+		for i := range objects {
+			for j, b := range order {
+				if used[j] || have[i] >= copies {
+					continue
+				}
+				if ok, err := b.Has(key(i)); err == nil && ok {
+					used[j] = true
+					have[i]++
+				}
+			}
+		}
+	}
+
+	for i, obj := range objects {
+		// 分片 i 优先放在第 i 个后端,复制模式从第一个后端开始
+		for n := 0; n < len(order) && have[i] < copies; n++ {
+			if err := ctx.Err(); err != nil {
+				return written, err
+			}
+			j := (i + n) % len(order)
+			if used[j] {
+				continue
+			}
+			used[j] = true
+			start := time.Now()
+			err := order[j].Put(key(i), obj)
+			s.observeOp(order[j].Name(), OpWrite, int64(len(obj)), err, start)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", order[j].Name(), err))
+				continue
+			}
+			have[i]++
+			written++
+		}
+		if have[i] < copies {
+			return written, fmt.Errorf("chunk %s: only %d of %d objects stored: %v", hash, have[i], copies, errs)
+		}
+	}
+	return written, nil
+}
+
+// Get 从后端读回 chunk 并校验哈希。复制模式按放置顺序读第一个完好的副本,
+// 纠删模式收集足够多的完好分片后重建
+func (s *Store) Get(ctx context.Context, hash string) ([]byte, error) {
+	order := s.placement(hash)
+	if s.coder == nil {
+		for _, b := range order {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			data, err := s.read(b, hash)
+			if err == nil && chunkHash(data) == hash {
+				return data, nil
+			}
+		}
+		return nil, fmt.Errorf("chunk %s: %w", hash, ErrNotFound)
+	}
+
+	n := s.cfg.DataShards + s.cfg.ParityShards
+	shards := make([][]byte, n)
+	size, found := -1, 0
+	for i := 0; i < n && found < s.cfg.DataShards; i++ {
+		// 分片 i 通常在第 i 个后端,写入顺延时可能在之后的后端
+		for k := 0; k < len(order); k++ {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			obj, err := s.read(order[(i+k)%len(order)], hash+"."+strconv.Itoa(i))
+			if err != nil || len(obj) < shardHeaderSize {
+				continue
+			}
+			shard := obj[shardHeaderSize:]
+			if crc32.ChecksumIEEE(shard) != binary.LittleEndian.Uint32(obj[8:]) {
+				continue
+			}
+			if size < 0 {
+				size = int(binary.LittleEndian.Uint64(obj[0:]))
+			}
+			shards[i] = shard
+			found++
+			break
+		}
+	}
+	if found < s.cfg.DataShards {
+		return nil, fmt.Errorf("chunk %s: %d of %d required shards available: %w", hash, found, s.cfg.DataShards, ErrNotFound)
+	}
+	data, err := s.coder.reconstruct(shards, size)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %s: %w", hash, err)
+	}
+	if chunkHash(data) != hash {
+		return nil, fmt.Errorf("chunk %s: reconstructed data hash mismatch", hash)
+	}
+	return data, nil
+}
+
+func (s *Store) read(b Backend, key string) ([]byte, error) {
+	start := time.Now()
+	data, err := b.Get(key)
+	s.observeOp(b.Name(), OpRead, int64(len(data)), err, start)
+	return data, err
+}
+
+func (s *Store) observeOp(backend, op string, bytes int64, err error, start time.Time) {
+	if s.observe != nil {
+		s.observe(Op{Backend: backend, Op: op, Bytes: bytes, NotFound: errors.Is(err, ErrNotFound), Err: err, Duration: time.Since(start)})
+	}
+}
+
+func chunkHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
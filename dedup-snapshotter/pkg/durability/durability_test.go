@@ -0,0 +1,143 @@
+package durability
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+)
+
+func testConfig(t *testing.T, mode string, backends int) config.DurabilityConfig {
+	dir := t.TempDir()
+	cfg := config.DurabilityConfig{Mode: mode, Copies: 2, DataShards: 4, ParityShards: 2}
+	for i := 0; i < backends; i++ {
+		cfg.Backends = append(cfg.Backends, config.DurabilityBackend{
+			Name: fmt.Sprintf("b%d", i),
+			Path: filepath.Join(dir, fmt.Sprintf("b%d", i)),
+		})
+	}
+	return cfg
+}
+
+// TestErasureCoding 验证丢失任意 parity 个分片后仍能恢复原始数据
+func TestErasureCoding(t *testing.T) {
+	c, err := newCoder(4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 10001)
+	rand.New(rand.NewSource(1)).Read(data)
+	shards := c.encode(data)
+
+	for a := 0; a < len(shards); a++ {
+		for b := a + 1; b < len(shards); b++ {
+			partial := append([][]byte{}, shards...)
+			partial[a], partial[b] = nil, nil
+			got, err := c.reconstruct(partial, len(data))
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("failed to reconstruct without shards %d and %d: %v", a, b, err)
+			}
+		}
+	}
+	partial := append([][]byte{}, shards...)
+	partial[0], partial[1], partial[5] = nil, nil, nil
+	if _, err := c.reconstruct(partial, len(data)); err == nil {
+		t.Fatal("expected reconstruction with too few shards to fail")
+	}
+	t.Logf("✓ 4+2 编码丢失任意两个分片都能恢复")
+}
+
+// TestStoreSurvivesBackendLoss 验证复制和纠删模式在一个后端丢失后仍能读回 chunk,Ensure 补齐冗余
+func TestStoreSurvivesBackendLoss(t *testing.T) {
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("durable chunk "), 1000)
+	hash := chunkHash(data)
+
+	for _, tc := range []struct {
+		mode     string
+		backends int
+		objects  int
+	}{
+		{config.DurabilityReplicate, 3, 2},
+		{config.DurabilityErasure, 7, 6},
+	} {
+		cfg := testConfig(t, tc.mode, tc.backends)
+		s, err := New(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writes := 0
+		s.SetObserver(func(op Op) {
+			if op.Op == OpWrite && op.Err == nil {
+				writes++
+			}
+		})
+		if err := s.Put(ctx, hash, data); err != nil {
+			t.Fatal(err)
+		}
+		if writes != tc.objects {
+			t.Fatalf("%s: expected %d objects written, got %d", tc.mode, tc.objects, writes)
+		}
+
+		// 删除保存了数据的第一个后端
+		lost := s.placement(hash)[0].Name()
+		for _, b := range cfg.Backends {
+			if b.Name == lost {
+				os.RemoveAll(b.Path)
+			}
+		}
+		got, err := s.Get(ctx, hash)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("%s: expected chunk to survive loss of %s, got %v", tc.mode, lost, err)
+		}
+
+		repaired, err := s.Ensure(ctx, hash, data)
+		if err != nil || repaired != 1 {
+			t.Fatalf("%s: expected one object to be repaired, got %d, %v", tc.mode, repaired, err)
+		}
+		if repaired, _ := s.Ensure(ctx, hash, data); repaired != 0 {
+			t.Fatalf("%s: expected nothing to repair, got %d", tc.mode, repaired)
+		}
+		t.Logf("✓ %s 模式丢失后端 %s 后读回 chunk 并补齐冗余", tc.mode, lost)
+	}
+
+	// 损坏的分片按校验失败处理,超过 parity 个分片丢失时返回 ErrNotFound
+	cfg := testConfig(t, config.DurabilityErasure, 6)
+	s, _ := New(cfg)
+	if err := s.Put(ctx, hash, data); err != nil {
+		t.Fatal(err)
+	}
+	order := s.placement(hash)
+	paths := map[string]string{}
+	for _, b := range cfg.Backends {
+		paths[b.Name] = b.Path
+	}
+	shard := filepath.Join(paths[order[0].Name()], hash[:2], hash+".0")
+	obj, _ := os.ReadFile(shard)
+	obj[len(obj)-1] ^= 0xff
+	os.WriteFile(shard, obj, 0644)
+	os.RemoveAll(paths[order[1].Name()])
+	if got, err := s.Get(ctx, hash); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("expected a corrupt and a lost shard to be tolerated, got %v", err)
+	}
+	os.RemoveAll(paths[order[2].Name()])
+	if _, err := s.Get(ctx, hash); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound with three shards gone, got %v", err)
+	}
+	unavailable := 0
+	for _, b := range s.Status().Backends {
+		if !b.Available {
+			unavailable++
+		}
+	}
+	if unavailable != 2 {
+		t.Fatalf("expected two backends to be reported unavailable, got %d", unavailable)
+	}
+	t.Logf("✓ 损坏的分片被忽略,分片不足时返回 ErrNotFound")
+}
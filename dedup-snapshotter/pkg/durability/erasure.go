@@ -0,0 +1,177 @@
+package durability
+
+import "fmt"
+
+// GF(2^8) 的对数表,本原多项式 x^8+x^4+x^3+x^2+1
+var (
+	gfExp [512]byte
+	gfLog [256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for i := 255; i < len(gfExp); i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+// mulAdd 计算 dst ^= c * src
+func mulAdd(dst, src []byte, c byte) {
+	if c == 0 {
+		return
+	}
+	var table [256]byte
+	for i := range table {
+		table[i] = gfMul(c, byte(i))
+	}
+	for i, b := range src {
+		dst[i] ^= table[b]
+	}
+}
+
+// coder 是系统 Reed-Solomon 编码:前 data 个分片是原始数据,后 parity 个分片由 Cauchy 矩阵生成,
+// 任意 data 个分片都能恢复原始数据
+type coder struct {
+	data, parity int
+	// matrix 的第 i 行给出第 i 个分片由各数据分片线性组合的系数
+	matrix [][]byte
+}
+
+func newCoder(data, parity int) (*coder, error) {
+	if data <= 0 || parity <= 0 || data+parity > 256 {
+		return nil, fmt.Errorf("invalid erasure coding %d+%d", data, parity)
+	}
+	c := &coder{data: data, parity: parity, matrix: make([][]byte, data+parity)}
+	for i := 0; i < data; i++ {
+		c.matrix[i] = make([]byte, data)
+		c.matrix[i][i] = 1
+	}
+	// Cauchy 矩阵 1/(x_j + y_i),x_j = data+j 与 y_i = i 互不相同
+	for j := 0; j < parity; j++ {
+		row := make([]byte, data)
+		for i := range row {
+			row[i] = gfInv(byte(data+j) ^ byte(i))
+		}
+		c.matrix[data+j] = row
+	}
+	return c, nil
+}
+
+// encode 把 data 切成 c.data 个等长分片(末尾补零)并计算校验分片
+func (c *coder) encode(data []byte) [][]byte {
+	size := (len(data) + c.data - 1) / c.data
+	if size == 0 {
+		size = 1
+	}
+	padded := make([]byte, size*(c.data+c.parity))
+	copy(padded, data)
+
+	shards := make([][]byte, c.data+c.parity)
+	for i := range shards {
+		shards[i] = padded[i*size : (i+1)*size]
+	}
+	for j := c.data; j < len(shards); j++ {
+		for i := 0; i < c.data; i++ {
+			mulAdd(shards[j], shards[i], c.matrix[j][i])
+		}
+	}
+	return shards
+}
+
+// reconstruct 从 shards 中任意 c.data 个非 nil 分片恢复长度为 size 的原始数据
+func (c *coder) reconstruct(shards [][]byte, size int) ([]byte, error) {
+	var rows []int
+	for i, s := range shards {
+		if s != nil && len(rows) < c.data {
+			rows = append(rows, i)
+		}
+	}
+	if len(rows) < c.data {
+		return nil, fmt.Errorf("need %d shards, only %d available", c.data, len(rows))
+	}
+	shardSize := len(shards[rows[0]])
+	for _, r := range rows {
+		if len(shards[r]) != shardSize {
+			return nil, fmt.Errorf("shard %d has size %d, expected %d", r, len(shards[r]), shardSize)
+		}
+	}
+	if size > shardSize*c.data {
+		return nil, fmt.Errorf("size %d exceeds %d shards of %d bytes", size, c.data, shardSize)
+	}
+
+	sub := make([][]byte, c.data)
+	for i, r := range rows {
+		sub[i] = c.matrix[r]
+	}
+	inv, err := invert(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, shardSize*c.data)
+	for i := 0; i < c.data; i++ {
+		dst := out[i*shardSize : (i+1)*shardSize]
+		for j, r := range rows {
+			mulAdd(dst, shards[r], inv[i][j])
+		}
+	}
+	return out[:size], nil
+}
+
+// invert 用高斯消元求 GF(2^8) 上方阵的逆
+func invert(m [][]byte) ([][]byte, error) {
+	n := len(m)
+	work := make([][]byte, n)
+	for i := range m {
+		work[i] = make([]byte, 2*n)
+		copy(work[i], m[i])
+		work[i][n+i] = 1
+	}
+	for col := 0; col < n; col++ {
+		pivot := -1
+		for r := col; r < n; r++ {
+			if work[r][col] != 0 {
+				pivot = r
+				break
+			}
+		}
+		if pivot < 0 {
+			return nil, fmt.Errorf("singular matrix")
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+		if inv := gfInv(work[col][col]); inv != 1 {
+			for k := range work[col] {
+				work[col][k] = gfMul(work[col][k], inv)
+			}
+		}
+		for r := 0; r < n; r++ {
+			if r != col && work[r][col] != 0 {
+				mulAdd(work[r], work[col], work[r][col])
+			}
+		}
+	}
+	inv := make([][]byte, n)
+	for i := range work {
+		inv[i] = work[i][n:]
+	}
+	return inv, nil
+}
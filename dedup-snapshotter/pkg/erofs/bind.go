@@ -0,0 +1,450 @@
+package erofs
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/log"
+	"golang.org/x/sys/unix"
+)
+
+// 绑定挂载的传播方式,与 kubelet mountPropagation 的语义对应:
+// rslave 对应 HostToContainer,rshared 对应 Bidirectional
+const (
+	PropagationPrivate = "rprivate"
+	PropagationSlave   = "rslave"
+	PropagationShared  = "rshared"
+)
+
+// bindRecordsFile 保存当前全部绑定,重启后据此卸载 target_dirs 中遗留的绑定
+const bindRecordsFile = "binds.json"
+
+var propagationFlags = map[string]uintptr{
+	PropagationPrivate: unix.MS_PRIVATE | unix.MS_REC,
+	PropagationSlave:   unix.MS_SLAVE | unix.MS_REC,
+	PropagationShared:  unix.MS_SHARED | unix.MS_REC,
+}
+
+type BindMount struct {
+	PodID       string    `json:"pod_id"`
+	ImageID     string    `json:"image_id"`
+	Source      string    `json:"source"`
+	Target      string    `json:"target"`
+	Propagation string    `json:"propagation"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// PodAliveFunc 判断 pod 是否仍在运行,返回 false 时其绑定挂载会被回收
+type PodAliveFunc func(podID string) bool
+
+// BindManager 把已挂载的 EROFS 镜像只读绑定到按 pod 划分的目录,
+// 供在自己挂载命名空间中查找挂载点的 kubelet/容器运行时使用。
+// 每个绑定持有镜像挂载的一个引用,pod 结束后由 Run 周期性回收。
+type BindManager struct {
+	mounts      *MountManager
+	podsDir     string
+	recordsPath string
+	propagation string
+	alive       PodAliveFunc
+	mu          sync.Mutex
+	binds       map[string]map[string]*BindMount
+	// targetDirs 是 podsDir 之外允许作为绑定目标的目录
+	targetDirs []string
+}
+
+func NewBindManager(mounts *MountManager, root string) (*BindManager, error) {
+	return NewBindManagerWithOptions(mounts, root, PropagationSlave, KubeletPodAlive("/var/lib/kubelet/pods"))
+}
+
+func NewBindManagerWithOptions(mounts *MountManager, root, propagation string, alive PodAliveFunc) (*BindManager, error) {
+	if _, ok := propagationFlags[propagation]; !ok {
+		return nil, fmt.Errorf("unsupported mount propagation %q", propagation)
+	}
+
+	podsDir := filepath.Join(root, "pods")
+	if err := os.MkdirAll(podsDir, 0755); err != nil {
+		return nil, err
+	}
+
+	b := &BindManager{
+		mounts:      mounts,
+		podsDir:     podsDir,
+		recordsPath: filepath.Join(root, bindRecordsFile),
+		propagation: propagation,
+		alive:       alive,
+		binds:       make(map[string]map[string]*BindMount),
+	}
+	b.cleanupStale()
+	return b, nil
+}
+
+// SetTargetDirs 设置 podsDir 之外允许作为绑定目标的目录
+func (b *BindManager) SetTargetDirs(dirs []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.targetDirs = append([]string(nil), dirs...)
+}
+
+// KubeletPodAlive 以 kubelet 的 pods/<uid> 目录是否存在判断 pod 是否存活
+func KubeletPodAlive(podsDir string) PodAliveFunc {
+	return func(podID string) bool {
+		_, err := os.Stat(filepath.Join(podsDir, podID))
+		return err == nil
+	}
+}
+
+// Bind 把镜像挂载点只读绑定到 target,target 为空时使用 root/pods/<pod>/<image>。
+// target 解析符号链接后必须位于 root/pods 或 SetTargetDirs 设置的目录之下。
+// propagation 为空时使用默认传播方式。
+func (b *BindManager) Bind(podID, imageID, target, propagation string) (*BindMount, error) {
+	if podID == "" || strings.ContainsAny(podID, "/\x00") || podID == "." || podID == ".." {
+		return nil, fmt.Errorf("invalid pod id %q", podID)
+	}
+	if err := ValidateImageID(imageID); err != nil {
+		return nil, err
+	}
+	if propagation == "" {
+		propagation = b.propagation
+	}
+	flags, ok := propagationFlags[propagation]
+	if !ok {
+		return nil, fmt.Errorf("unsupported mount propagation %q", propagation)
+	}
+	if target == "" {
+		target = filepath.Join(b.podsDir, podID, imageID)
+	} else if !filepath.IsAbs(target) {
+		return nil, fmt.Errorf("bind target must be an absolute path: %s", target)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	target, err := b.confineTarget(target)
+	if err != nil {
+		return nil, err
+	}
+
+	if existing, ok := b.binds[podID][target]; ok {
+		if existing.ImageID != imageID {
+			return nil, fmt.Errorf("target %s already bound to image %s", target, existing.ImageID)
+		}
+		copied := *existing
+		return &copied, nil
+	}
+
+	source, err := b.mounts.Acquire(imageID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := bindReadOnly(source, target, flags); err != nil {
+		b.mounts.Unmount(imageID)
+		return nil, fmt.Errorf("failed to bind %s to %s: %w", imageID, target, err)
+	}
+
+	bind := &BindMount{
+		PodID:       podID,
+		ImageID:     imageID,
+		Source:      source,
+		Target:      target,
+		Propagation: propagation,
+		CreatedAt:   time.Now(),
+	}
+	if b.binds[podID] == nil {
+		b.binds[podID] = make(map[string]*BindMount)
+	}
+	b.binds[podID][target] = bind
+	if err := b.saveRecords(); err != nil {
+		delete(b.binds[podID], target)
+		if len(b.binds[podID]) == 0 {
+			delete(b.binds, podID)
+		}
+		unix.Unmount(target, unix.MNT_DETACH)
+		b.mounts.Unmount(imageID)
+		return nil, err
+	}
+
+	log.L.Infof("bound erofs image %s to %s for pod %s (%s)", imageID, target, podID, propagation)
+	copied := *bind
+	return &copied, nil
+}
+
+// confineTarget 解析 target 中已存在部分的符号链接,确认结果位于 podsDir 或 targetDirs 之下
+// 且不是这些目录本身,返回解析后的路径。调用方持有 b.mu
+func (b *BindManager) confineTarget(target string) (string, error) {
+	resolved, err := resolveExisting(filepath.Clean(target))
+	if err != nil {
+		return "", fmt.Errorf("invalid bind target %s: %w", target, err)
+	}
+	for _, dir := range append([]string{b.podsDir}, b.targetDirs...) {
+		root, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(root, resolved)
+		if err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("bind target %s is outside %s and bind_mounts.target_dirs", target, b.podsDir)
+}
+
+// resolveExisting 对 path 最长的已存在前缀解析符号链接,再接上尚不存在的部分
+func resolveExisting(path string) (string, error) {
+	var rest []string
+	for {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, rest...)...), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return "", err
+		}
+		rest = append([]string{filepath.Base(path)}, rest...)
+		path = parent
+	}
+}
+
+// UnbindPod 卸载 pod 的全部绑定并释放对应的镜像挂载引用
+func (b *BindManager) UnbindPod(podID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var errs []error
+	for target, bind := range b.binds[podID] {
+		if err := unix.Unmount(target, unix.MNT_DETACH); err != nil && err != unix.EINVAL && err != unix.ENOENT {
+			errs = append(errs, fmt.Errorf("failed to unmount %s: %w", target, err))
+			continue
+		}
+		delete(b.binds[podID], target)
+		if strings.HasPrefix(target, b.podsDir+string(filepath.Separator)) {
+			os.Remove(target)
+		}
+		if err := b.mounts.Unmount(bind.ImageID); err != nil {
+			log.L.WithError(err).Warnf("failed to release erofs image %s", bind.ImageID)
+		}
+	}
+
+	if len(b.binds[podID]) == 0 {
+		delete(b.binds, podID)
+		os.Remove(filepath.Join(b.podsDir, podID))
+	}
+	if err := b.saveRecords(); err != nil {
+		log.L.WithError(err).Warn("failed to save bind records")
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("unbind errors: %v", errs)
+	}
+	log.L.Infof("released bind mounts of pod %s", podID)
+	return nil
+}
+
+// List 返回当前全部绑定,按 pod 和目标路径排序
+func (b *BindManager) List() []*BindMount {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var list []*BindMount
+	for _, binds := range b.binds {
+		for _, bind := range binds {
+			copied := *bind
+			list = append(list, &copied)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].PodID != list[j].PodID {
+			return list[i].PodID < list[j].PodID
+		}
+		return list[i].Target < list[j].Target
+	})
+	return list
+}
+
+// Reap 回收已结束 pod 的绑定,返回被回收的 pod
+func (b *BindManager) Reap() []string {
+	if b.alive == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	var dead []string
+	for podID := range b.binds {
+		if !b.alive(podID) {
+			dead = append(dead, podID)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, podID := range dead {
+		if err := b.UnbindPod(podID); err != nil {
+			log.L.WithError(err).Warnf("failed to release bind mounts of terminated pod %s", podID)
+		}
+	}
+	return dead
+}
+
+func (b *BindManager) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.Reap()
+		}
+	}
+}
+
+// saveRecords 把当前全部绑定写入 recordsPath。调用方持有 b.mu
+func (b *BindManager) saveRecords() error {
+	records := []*BindMount{}
+	for _, binds := range b.binds {
+		for _, bind := range binds {
+			records = append(records, bind)
+		}
+	}
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	tmpPath := b.recordsPath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write bind records: %w", err)
+	}
+	return os.Rename(tmpPath, b.recordsPath)
+}
+
+// cleanupStale 卸载上次运行遗留的绑定:pods 目录下的挂载点,以及记录中仍挂载着的
+// target_dirs 中的目标。重启后镜像挂载的引用计数已丢失,这些绑定不再有效
+func (b *BindManager) cleanupStale() {
+	mounted, err := mountPoints()
+	if err != nil {
+		log.L.WithError(err).Debug("failed to read mountinfo")
+		return
+	}
+
+	prefix := b.podsDir + string(filepath.Separator)
+	stale := make(map[string]bool)
+	for _, target := range mounted {
+		if strings.HasPrefix(target, prefix) {
+			stale[target] = true
+		}
+	}
+	if data, err := os.ReadFile(b.recordsPath); err == nil {
+		var records []*BindMount
+		if err := json.Unmarshal(data, &records); err != nil {
+			log.L.WithError(err).Warnf("ignoring corrupt bind records %s", b.recordsPath)
+		}
+		isMounted := make(map[string]bool, len(mounted))
+		for _, target := range mounted {
+			isMounted[target] = true
+		}
+		for _, record := range records {
+			if isMounted[record.Target] {
+				stale[record.Target] = true
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		log.L.WithError(err).Warnf("failed to read bind records %s", b.recordsPath)
+	}
+
+	targets := make([]string, 0, len(stale))
+	for target := range stale {
+		targets = append(targets, target)
+	}
+	// 先卸载最深的路径
+	sort.Sort(sort.Reverse(sort.StringSlice(targets)))
+	for _, target := range targets {
+		if err := unix.Unmount(target, unix.MNT_DETACH); err != nil {
+			log.L.WithError(err).Warnf("failed to unmount stale bind %s", target)
+			continue
+		}
+		if strings.HasPrefix(target, prefix) {
+			os.Remove(target)
+		}
+		log.L.Infof("removed stale bind mount %s", target)
+	}
+
+	if err := b.saveRecords(); err != nil {
+		log.L.WithError(err).Warn("failed to reset bind records")
+	}
+}
+
+func bindReadOnly(source, target string, propagation uintptr) error {
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+	if err := unix.Mount(source, target, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return err
+	}
+
+	// 只读必须通过 remount 设置,首次 bind 时 MS_RDONLY 会被内核忽略
+	if err := unix.Mount("", target, "", unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY, ""); err != nil {
+		unix.Unmount(target, unix.MNT_DETACH)
+		return fmt.Errorf("remount read-only: %w", err)
+	}
+	if err := unix.Mount("", target, "", propagation, ""); err != nil {
+		unix.Unmount(target, unix.MNT_DETACH)
+		return fmt.Errorf("set propagation: %w", err)
+	}
+	return nil
+}
+
+// mountPoints 从 mountinfo 中读出全部挂载点
+func mountPoints() ([]string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var targets []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		targets = append(targets, unescapeMountinfo(fields[4]))
+	}
+	return targets, scanner.Err()
+}
+
+// unescapeMountinfo 还原 mountinfo 中以 \ooo 转义的空白字符
+func unescapeMountinfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && isOctal(s[i+1]) && isOctal(s[i+2]) && isOctal(s[i+3]) {
+			sb.WriteByte((s[i+1]-'0')<<6 | (s[i+2]-'0')<<3 | (s[i+3] - '0'))
+			i += 3
+			continue
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+func isOctal(c byte) bool {
+	return c >= '0' && c <= '7'
+}
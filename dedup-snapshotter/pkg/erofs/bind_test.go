@@ -0,0 +1,124 @@
+package erofs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestBindManager 验证绑定只读、持有镜像挂载引用,并在 pod 结束后被回收
+func TestBindManager(t *testing.T) {
+	tmpDir := t.TempDir()
+	mounts, err := NewMountManager(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 用普通目录代替已挂载的 EROFS 镜像
+	source := filepath.Join(tmpDir, "image")
+	writeTestFile(t, filepath.Join(source, "a.txt"), "data")
+	mounts.activeMounts["img"] = &MountPoint{ID: "img", MountPath: source, RefCount: 1}
+
+	alive := map[string]bool{"pod-1": true}
+	binds, err := NewBindManagerWithOptions(mounts, tmpDir, PropagationPrivate, func(podID string) bool {
+		return alive[podID]
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := binds.Bind("pod-1", "missing", "", ""); err == nil {
+		t.Error("expected error binding an image that is not mounted")
+	}
+	if _, err := binds.Bind("../x", "img", "", ""); err == nil {
+		t.Error("expected error for invalid pod id")
+	}
+	if _, err := binds.Bind("pod-1", "../../etc", "", ""); err == nil {
+		t.Error("expected error for invalid image id")
+	}
+	escape := filepath.Join(tmpDir, "pods", "escape")
+	if err := os.Symlink("/etc", escape); err != nil {
+		t.Fatal(err)
+	}
+	for _, target := range []string{"/etc", filepath.Join(tmpDir, "pods"), filepath.Join(tmpDir, "pods", "..", "image"), filepath.Join(escape, "x")} {
+		if _, err := binds.Bind("pod-1", "img", target, ""); err == nil {
+			t.Errorf("expected bind target %s to be rejected", target)
+		}
+	}
+	allowed := t.TempDir()
+	binds.SetTargetDirs([]string{allowed})
+	if _, err := binds.confineTarget(filepath.Join(allowed, "pod-1", "img")); err != nil {
+		t.Errorf("expected target under target_dirs to be allowed: %v", err)
+	}
+	os.Remove(escape)
+	t.Logf("✓ 绑定目标限制在 pods 目录和 target_dirs 之下")
+
+	bind, err := binds.Bind("pod-1", "img", "", "")
+	if err != nil {
+		t.Skipf("bind mount not permitted: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(bind.Target, "a.txt")); err != nil || string(data) != "data" {
+		t.Fatalf("bind target does not expose source: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(bind.Target, "b.txt"), []byte("x"), 0644); err == nil {
+		t.Error("bind target must be read-only")
+	}
+	if mounts.activeMounts["img"].RefCount != 2 {
+		t.Errorf("expected bind to hold a mount reference, refcount=%d", mounts.activeMounts["img"].RefCount)
+	}
+	t.Logf("✓ 绑定挂载只读并持有镜像引用")
+
+	if reaped := binds.Reap(); len(reaped) != 0 {
+		t.Errorf("live pod must not be reaped: %v", reaped)
+	}
+	delete(alive, "pod-1")
+	if reaped := binds.Reap(); len(reaped) != 1 {
+		t.Fatalf("expected terminated pod to be reaped, got %v", reaped)
+	}
+	if len(binds.List()) != 0 || mounts.activeMounts["img"].RefCount != 1 {
+		t.Errorf("reap must release the bind and its mount reference")
+	}
+	if _, err := os.Stat(bind.Target); !os.IsNotExist(err) {
+		t.Errorf("bind target should be removed after reap: %v", err)
+	}
+	t.Logf("✓ pod 结束后绑定被回收")
+}
+
+// TestBindManagerCleansRecordedTargets 验证重启后按记录卸载 target_dirs 中遗留的绑定
+func TestBindManagerCleansRecordedTargets(t *testing.T) {
+	tmpDir := t.TempDir()
+	mounts, err := NewMountManager(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := filepath.Join(tmpDir, "image")
+	writeTestFile(t, filepath.Join(source, "a.txt"), "data")
+	mounts.activeMounts["img"] = &MountPoint{ID: "img", MountPath: source, RefCount: 1}
+
+	binds, err := NewBindManagerWithOptions(mounts, tmpDir, PropagationPrivate, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed := t.TempDir()
+	binds.SetTargetDirs([]string{allowed})
+	bind, err := binds.Bind("pod-1", "img", filepath.Join(allowed, "pod-1", "img"), "")
+	if err != nil {
+		t.Skipf("bind mount not permitted: %v", err)
+	}
+	defer unix.Unmount(bind.Target, unix.MNT_DETACH)
+
+	// 模拟重启:新的 BindManager 不持有任何绑定
+	restarted, err := NewBindManagerWithOptions(mounts, tmpDir, PropagationPrivate, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(bind.Target, "a.txt")); !os.IsNotExist(err) {
+		t.Errorf("recorded bind %s should be unmounted after restart: %v", bind.Target, err)
+	}
+	if len(restarted.List()) != 0 {
+		t.Errorf("restarted manager should hold no binds")
+	}
+	t.Logf("✓ 重启后卸载 target_dirs 中遗留的绑定")
+}
@@ -2,27 +2,54 @@ package erofs
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/bufpool"
+	"github.com/opencloudos/dedup-snapshotter/pkg/chunkcache"
 )
 
 const (
 	BlockSize     = 4096
 	ChunkSize     = 4 * 1024 * 1024
 	ErofsImageExt = ".erofs"
+
+	// ChunkHash 是 chunk 内容寻址使用的哈希算法
+	ChunkHash = "sha256"
+	// Compression 是 mkfs.erofs 构建镜像使用的压缩算法
+	Compression = "lz4hc"
 )
 
 type Builder struct {
 	root      string
 	chunksDir string
 	indexer   *ChunkIndexer
+	// prechunked 缓存增量切分器提前处理过的文件,键为源文件路径
+	prechunked sync.Map
+	healMu     sync.RWMutex
+	fetchChunk ChunkFetchFunc
+	onHeal     func(HealEvent)
+	onChunkOp  func(ChunkStoreOp)
+	// replicate 在新 chunk 写入本地后同步调用,把 chunk 保存到持久化层
+	replicate func(hash string, data []byte)
+	// fetchDurable 从持久化层取回 chunk,修复时先于 fetchChunk 尝试
+	fetchDurable ChunkFetchFunc
+	// smallChunks 为 true 时小于 ChunkSize 的文件也参与去重,见 cdc.go
+	smallChunks bool
+	// buildTimeout 是 mkfs.erofs 单次执行的超时
+	buildTimeout time.Duration
+	// scratchDir 是暂存目录 staging 所在的目录,默认为 root
+	scratchDir string
+	// cache 缓存已校验的热点 chunk,为空时每次读取磁盘
+	cache *chunkcache.Cache
+	// verifyImages 为 true 时启动恢复用 VerifyImage 完整校验已有镜像,否则只检查超级块
+	verifyImages bool
 }
 
 type ChunkInfo struct {
@@ -50,37 +77,85 @@ func NewBuilder(root string) (*Builder, error) {
 	}
 
 	return &Builder{
-		root:      root,
-		chunksDir: chunksDir,
-		indexer:   indexer,
+		root:         root,
+		chunksDir:    chunksDir,
+		indexer:      indexer,
+		buildTimeout: DefaultBuildTimeout,
+		scratchDir:   root,
 	}, nil
 }
 
+// SetBuildTimeout 设置 mkfs.erofs 单次执行的超时,超时的进程会被杀死,0 表示只受 ctx 约束
+func (b *Builder) SetBuildTimeout(timeout time.Duration) {
+	b.buildTimeout = timeout
+}
+
+// SetImageVerification 设置 RecoverBuilds 是否解析校验和并遍历元数据来校验已有镜像
+func (b *Builder) SetImageVerification(full bool) {
+	b.verifyImages = full
+}
+
+// SetScratchDir 设置构建暂存目录所在的目录,使解压和暂存数据不占用 chunk 所在的文件系统
+func (b *Builder) SetScratchDir(dir string) {
+	b.scratchDir = dir
+}
+
+// SetChunkCache 设置已校验 chunk 的内存缓存
+func (b *Builder) SetChunkCache(c *chunkcache.Cache) {
+	b.cache = c
+}
+
+// SetIndexCache 设置 chunk 索引的元数据缓存
+func (b *Builder) SetIndexCache(c *chunkcache.MetaCache) {
+	b.indexer.SetMetaCache(c)
+}
+
+// IndexCacheStats 返回 chunk 索引元数据缓存的统计
+func (b *Builder) IndexCacheStats() chunkcache.MetaStats {
+	return b.indexer.meta.Stats()
+}
+
+// stagingPath 返回镜像构建的暂存目录
+func (b *Builder) stagingPath(imageID string) string {
+	return filepath.Join(b.scratchDir, "staging", imageID)
+}
+
+// ProgressFunc 在构建过程中报告已处理的源文件字节数
+type ProgressFunc func(processed int64)
+
 func (b *Builder) BuildImage(ctx context.Context, sourceDir, imageID string) (string, error) {
-	imagePath := filepath.Join(b.root, "images", imageID+ErofsImageExt)
-	if err := os.MkdirAll(filepath.Dir(imagePath), 0755); err != nil {
+	return b.BuildImageWithProgress(ctx, sourceDir, imageID, nil)
+}
+
+func (b *Builder) BuildImageWithProgress(ctx context.Context, sourceDir, imageID string, progress ProgressFunc) (string, error) {
+	stagingDir := b.stagingPath(imageID)
+	txn, err := b.beginBuild(BuildKindImage, imageID, stagingDir)
+	if err != nil {
 		return "", err
 	}
+	defer txn.finish()
 
-	stagingDir := filepath.Join(b.root, "staging", imageID)
 	if err := os.MkdirAll(stagingDir, 0755); err != nil {
 		return "", err
 	}
-	defer os.RemoveAll(stagingDir)
 
-	if err := b.processDirectory(ctx, sourceDir, stagingDir, imageID); err != nil {
+	if err := b.processDirectory(ctx, sourceDir, stagingDir, imageID, progress); err != nil {
 		return "", err
 	}
 
-	if err := b.buildErofsImage(stagingDir, imagePath); err != nil {
+	if err := b.buildErofsImage(ctx, stagingDir, txn.record.Temp); err != nil {
+		return "", err
+	}
+	if err := txn.commit(); err != nil {
 		return "", err
 	}
 
-	log.G(ctx).Infof("built erofs image: %s", imagePath)
-	return imagePath, nil
+	log.G(ctx).Infof("built erofs image: %s", txn.imagePath)
+	return txn.imagePath, nil
 }
 
-func (b *Builder) processDirectory(ctx context.Context, sourceDir, targetDir, imageID string) error {
+func (b *Builder) processDirectory(ctx context.Context, sourceDir, targetDir, imageID string, progress ProgressFunc) error {
+	var processed int64
 	return filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -98,7 +173,14 @@ func (b *Builder) processDirectory(ctx context.Context, sourceDir, targetDir, im
 		}
 
 		if info.Mode().IsRegular() {
-			return b.processFile(ctx, path, targetPath, imageID, info)
+			if err := b.processFile(ctx, path, targetPath, imageID, info); err != nil {
+				return err
+			}
+			if progress != nil {
+				processed += info.Size()
+				progress(processed)
+			}
+			return nil
 		}
 
 		if info.Mode()&os.ModeSymlink != 0 {
@@ -115,6 +197,9 @@ func (b *Builder) processDirectory(ctx context.Context, sourceDir, targetDir, im
 
 func (b *Builder) processFile(ctx context.Context, sourcePath, targetPath, imageID string, info os.FileInfo) error {
 	if info.Size() < ChunkSize {
+		if b.smallChunks && info.Size() >= SmallChunkMin {
+			return b.deduplicateSmallFile(ctx, sourcePath, targetPath, imageID)
+		}
 		return b.copySmallFile(sourcePath, targetPath)
 	}
 
@@ -139,55 +224,68 @@ func (b *Builder) copySmallFile(source, target string) error {
 }
 
 func (b *Builder) deduplicateFile(ctx context.Context, sourcePath, targetPath, imageID string, info os.FileInfo) error {
-	file, err := os.Open(sourcePath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
+	chunks, ok := b.prechunkedChunks(sourcePath, info)
+	if ok {
+		log.G(ctx).Debugf("reusing %d prechunked chunks for %s", len(chunks), sourcePath)
+	} else {
+		file, err := os.Open(sourcePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
 
-	chunks, err := b.chunkFile(file)
-	if err != nil {
-		return err
+		chunks, err = b.chunkFile(file)
+		if err != nil {
+			return err
+		}
 	}
 
-	_ = &FileMetadata{
+	meta := &FileMetadata{
 		Path:   targetPath,
 		Mode:   info.Mode(),
 		Size:   info.Size(),
 		Chunks: chunks,
 	}
+	// 没有 chunk 覆盖的区间在重建时保持为空洞
+	if holes := fileHoles(chunks, info.Size()); len(holes) > 0 {
+		log.G(ctx).Debugf("%s is sparse, skipping %d holes", sourcePath, len(holes))
+	}
 
-	for _, chunk := range chunks {
+	for _, chunk := range meta.Chunks {
 		if err := b.indexer.RecordChunk(imageID, chunk.Hash, chunk.Size); err != nil {
 			return err
 		}
 	}
 
-	return b.reconstructFile(targetPath, chunks)
+	return b.reconstructFile(ctx, sourcePath, meta.Path, meta.Chunks, meta.Size)
 }
 
+// chunkFile 按 ChunkSize 切分文件,chunk 边界对齐到文件偏移,与非稀疏文件的切分一致。
+// 稀疏文件中完全落在空洞内的 chunk 不计算哈希也不存储,与数据相交的 chunk 连同其中的空洞一起切分
 func (b *Builder) chunkFile(file *os.File) ([]ChunkInfo, error) {
-	var chunks []ChunkInfo
-	buffer := make([]byte, ChunkSize)
-	offset := int64(0)
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
 
-	for {
-		n, err := io.ReadFull(file, buffer)
-		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+	var chunks []ChunkInfo
+	buf := bufpool.Default().Get()
+	defer bufpool.Default().Put(buf)
+	buffer := buf.B[:ChunkSize]
+
+	for _, offset := range ChunkOffsets(dataExtents(file, info.Size()), ChunkSize) {
+		size := min(ChunkSize, info.Size()-offset)
+		n, err := file.ReadAt(buffer[:size], offset)
+		if err != nil && err != io.EOF {
 			return nil, err
 		}
 		if n == 0 {
 			break
 		}
 
-		hash := sha256.Sum256(buffer[:n])
-		hashStr := hex.EncodeToString(hash[:])
-
-		chunkPath := filepath.Join(b.chunksDir, hashStr)
-		if _, statErr := os.Stat(chunkPath); os.IsNotExist(statErr) {
-			if err := os.WriteFile(chunkPath, buffer[:n], 0644); err != nil {
-				return nil, err
-			}
+		hashStr, writeErr := b.writeChunk(buffer[:n])
+		if writeErr != nil {
+			return nil, writeErr
 		}
 
 		chunks = append(chunks, ChunkInfo{
@@ -195,18 +293,14 @@ func (b *Builder) chunkFile(file *os.File) ([]ChunkInfo, error) {
 			Offset: offset,
 			Size:   int64(n),
 		})
-
-		offset += int64(n)
-
-		if err == io.EOF || err == io.ErrUnexpectedEOF {
-			break
-		}
 	}
 
 	return chunks, nil
 }
 
-func (b *Builder) reconstructFile(targetPath string, chunks []ChunkInfo) error {
+// reconstructFile 把 chunk 写回各自的偏移,每个 chunk 都校验哈希,损坏时先尝试修复。
+// chunk 之间和末尾未覆盖的区间以及全零 chunk 保留为空洞,最终文件大小为 size
+func (b *Builder) reconstructFile(ctx context.Context, sourcePath, targetPath string, chunks []ChunkInfo, size int64) error {
 	output, err := os.Create(targetPath)
 	if err != nil {
 		return err
@@ -214,29 +308,30 @@ func (b *Builder) reconstructFile(targetPath string, chunks []ChunkInfo) error {
 	defer output.Close()
 
 	for _, chunk := range chunks {
-		chunkPath := filepath.Join(b.chunksDir, chunk.Hash)
-		data, err := os.ReadFile(chunkPath)
+		if chunk.Hash == ZeroChunkHash {
+			// 全零 chunk 留作空洞
+			continue
+		}
+		data, err := b.readVerifiedChunk(ctx, sourcePath, chunk)
 		if err != nil {
 			return err
 		}
-		if _, err := output.Write(data); err != nil {
+		if err := writeSparse(output, data, chunk.Offset); err != nil {
 			return err
 		}
 	}
 
-	return nil
+	return output.Truncate(size)
 }
 
-func (b *Builder) buildErofsImage(sourceDir, imagePath string) error {
-	cmd := exec.Command("mkfs.erofs",
-		"-zlz4hc",
+func (b *Builder) buildErofsImage(ctx context.Context, sourceDir, imagePath string) error {
+	output, err := runCommand(ctx, b.buildTimeout, "mkfs.erofs",
+		"-z"+Compression,
 		"-T", "0",
 		"--all-root",
 		imagePath,
 		sourceDir,
 	)
-
-	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("mkfs.erofs failed: %w, output: %s", err, string(output))
 	}
@@ -248,6 +343,57 @@ func (b *Builder) GetChunkStats(imageID string) (*ChunkStats, error) {
 	return b.indexer.GetImageStats(imageID)
 }
 
+// HasChunk 判断本地 chunk 存储中是否已有该 chunk,全零 chunk 总是存在
+func (b *Builder) HasChunk(hash string) bool {
+	if hash == ZeroChunkHash {
+		return true
+	}
+	_, err := os.Stat(filepath.Join(b.chunksDir, hash))
+	return err == nil
+}
+
+// ListChunks 返回本地 chunk 存储中所有 chunk 的哈希
+func (b *Builder) ListChunks() ([]string, error) {
+	entries, err := os.ReadDir(b.chunksDir)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() && !strings.HasSuffix(entry.Name(), ".tmp") {
+			hashes = append(hashes, entry.Name())
+		}
+	}
+	return hashes, nil
+}
+
+// ReadChunk 读取本地 chunk 并校验哈希,不做修复
+func (b *Builder) ReadChunk(hash string) ([]byte, error) {
+	if data, ok := b.cache.Get(hash); ok {
+		return data, nil
+	}
+	start := time.Now()
+	data, err := os.ReadFile(filepath.Join(b.chunksDir, hash))
+	if err == nil && chunkHash(data) != hash {
+		err = fmt.Errorf("chunk %s hash mismatch", hash)
+	}
+	b.observeChunkOp("read", int64(len(data)), err, start)
+	if err != nil {
+		return nil, err
+	}
+	b.cache.Add(hash, data)
+	return data, nil
+}
+
+// RemoveImage 删除镜像文件及其 chunk 引用记录
+func (b *Builder) RemoveImage(imageID string) error {
+	imagePath := b.imagePath(imageID)
+	if err := os.Remove(imagePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return b.indexer.RemoveImage(imageID)
+}
+
 func (b *Builder) Close() error {
 	return b.indexer.Close()
 }
@@ -0,0 +1,138 @@
+package erofs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// chunk 分层:大文件按 ChunkSize 定长切分,小于 ChunkSize 的文件按内容定义切分(CDC),
+// 使不同文件之间相同的片段也能去重
+const (
+	ChunkTierLarge = "large"
+	ChunkTierSmall = "small"
+
+	SmallChunkMin = 16 * 1024
+	SmallChunkAvg = 64 * 1024
+	SmallChunkMax = 256 * 1024
+)
+
+// CDCMask 的有效位数决定平均块大小,log2(SmallChunkAvg) = 16
+const CDCMask = uint64(SmallChunkAvg-1) << 48
+
+// GearSeed 是 gearTable 的固定种子,保证不同节点、不同版本切出相同的边界
+const GearSeed = uint64(0x9e3779b97f4a7c15)
+
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	seed := GearSeed
+	for i := range table {
+		// splitmix64
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// SetSmallChunkTier 开启或关闭小文件的 CDC 分层
+func (b *Builder) SetSmallChunkTier(enabled bool) {
+	b.smallChunks = enabled
+}
+
+// cdcBoundary 返回 data 中第一个块的长度,使用 gear 滚动哈希
+func cdcBoundary(data []byte) int {
+	if len(data) <= SmallChunkMin {
+		return len(data)
+	}
+	limit := len(data)
+	if limit > SmallChunkMax {
+		limit = SmallChunkMax
+	}
+
+	var hash uint64
+	for i := SmallChunkMin; i < limit; i++ {
+		hash = (hash << 1) + gearTable[data[i]]
+		if hash&CDCMask == 0 {
+			return i + 1
+		}
+	}
+	return limit
+}
+
+// chunkSmallFile 把整个文件读入内存后按 CDC 切分,调用方保证文件小于 ChunkSize
+func (b *Builder) chunkSmallFile(file *os.File) ([]ChunkInfo, error) {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var chunks []ChunkInfo
+	for offset := 0; offset < len(data); {
+		n := cdcBoundary(data[offset:])
+		hash, err := b.writeChunk(data[offset : offset+n])
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, ChunkInfo{Hash: hash, Offset: int64(offset), Size: int64(n)})
+		offset += n
+	}
+	return chunks, nil
+}
+
+// writeChunk 按内容哈希保存 chunk,已存在时跳过,全零 chunk 不保存
+func (b *Builder) writeChunk(data []byte) (string, error) {
+	if isZeroChunk(data) {
+		return ZeroChunkHash, nil
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	chunkPath := filepath.Join(b.chunksDir, hash)
+	if _, err := os.Stat(chunkPath); os.IsNotExist(err) {
+		start := time.Now()
+		err := os.WriteFile(chunkPath, data, 0644)
+		b.observeChunkOp("write", int64(len(data)), err, start)
+		if err != nil {
+			return "", err
+		}
+		b.replicateChunk(hash, data)
+	}
+	return hash, nil
+}
+
+func (b *Builder) deduplicateSmallFile(ctx context.Context, sourcePath, targetPath, imageID string) error {
+	file, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	chunks, err := b.chunkSmallFile(file)
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, chunk := range chunks {
+		if err := b.indexer.RecordChunkTier(imageID, chunk.Hash, chunk.Size, ChunkTierSmall); err != nil {
+			return err
+		}
+	}
+
+	return b.reconstructFile(ctx, sourcePath, targetPath, chunks, info.Size())
+}
+
+// TierStats 返回每个 chunk 分层的去重收益
+func (b *Builder) TierStats() ([]TierStats, error) {
+	return b.indexer.GetTierStats()
+}
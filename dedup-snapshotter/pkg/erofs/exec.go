@@ -0,0 +1,81 @@
+package erofs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/background"
+)
+
+const (
+	// DefaultMountTimeout 是 mount/umount/losetup 单次执行的超时
+	DefaultMountTimeout = 30 * time.Second
+	// DefaultBuildTimeout 是 mkfs.erofs 单次执行的超时
+	DefaultBuildTimeout = 30 * time.Minute
+
+	// killGrace 是发送 SIGKILL 后等待子进程退出的时间,
+	// 处于 D 状态(例如卡在失联的存储上)的进程无法被杀死,超过后直接放弃等待
+	killGrace = 5 * time.Second
+)
+
+// ErrCommandTimeout 表示外部命令超时并已被杀死
+var ErrCommandTimeout = errors.New("command timed out")
+
+// runCommand 执行外部命令并返回合并的 stdout/stderr。
+// 子进程放在独立的进程组中,超时或 ctx 取消时杀死整个进程组(mount 可能派生 mount.<type> 助手),
+// timeout 为 0 时只受 ctx 约束。ctx 携带后台控制器时命令以后台优先级运行
+func runCommand(ctx context.Context, timeout time.Duration, name string, args ...string) ([]byte, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%s not started: %w", name, err)
+	}
+
+	var output bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	bg := background.FromContext(ctx)
+	bg.PrepareCommand(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	bg.ApplyProcess(cmd.Process.Pid)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return output.Bytes(), err
+	case <-ctx.Done():
+	}
+
+	pid := cmd.Process.Pid
+	syscall.Kill(-pid, syscall.SIGKILL)
+
+	select {
+	case <-done:
+	case <-time.After(killGrace):
+		// 输出缓冲区仍可能被写入,不再读取
+		log.G(ctx).Warnf("%s (pid %d) did not exit after SIGKILL, abandoning it", name, pid)
+	}
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("%s killed after %v: %w", name, timeout, ErrCommandTimeout)
+	}
+	return nil, fmt.Errorf("%s cancelled: %w", name, ctx.Err())
+}
@@ -0,0 +1,137 @@
+package erofs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestRunCommandKillsProcessGroup 验证超时后整个进程组被杀死,不会等待后台子进程
+func TestRunCommandKillsProcessGroup(t *testing.T) {
+	start := time.Now()
+	_, err := runCommand(context.Background(), 200*time.Millisecond, "sh", "-c", "sleep 30 & sleep 30")
+	if !errors.Is(err, ErrCommandTimeout) {
+		t.Fatalf("expected ErrCommandTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > killGrace {
+		t.Fatalf("command took %v to be killed", elapsed)
+	}
+
+	output, err := runCommand(context.Background(), time.Second, "echo", "ok")
+	if err != nil || string(output) != "ok\n" {
+		t.Fatalf("unexpected result %q, %v", output, err)
+	}
+	t.Logf("✓ 超时命令在 %v 内被杀死", time.Since(start))
+}
+
+// TestReserveWaitIsCancellable 验证等待同一镜像进行中的挂载可以被 ctx 中断
+func TestReserveWaitIsCancellable(t *testing.T) {
+	mm, err := NewMountManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, reserved, err := mm.reserve(context.Background(), "img"); !reserved || err != nil {
+		t.Fatalf("expected first caller to reserve, got %v %v", reserved, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, reserved, err := mm.reserve(ctx, "img"); reserved || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected waiter to give up, got %v %v", reserved, err)
+	}
+
+	mm.release("img", &MountPoint{ID: "img", MountPath: "/mnt/img", RefCount: 1})
+	path, reserved, err := mm.reserve(context.Background(), "img")
+	if reserved || err != nil || path != "/mnt/img" {
+		t.Fatalf("expected reuse of released mount, got %q %v %v", path, reserved, err)
+	}
+	t.Logf("✓ 等待方在 ctx 超时后放弃,释放后复用挂载")
+}
+
+// TestSetMountNamespace 验证指向自身命名空间时直接挂载,不存在的命名空间被拒绝
+func TestSetMountNamespace(t *testing.T) {
+	mm, err := NewMountManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mm.SetMountNamespace("/proc/self/ns/mnt"); err != nil {
+		t.Fatal(err)
+	}
+	if mm.namespace != "" {
+		t.Errorf("expected own namespace to mount directly, got %q", mm.namespace)
+	}
+
+	if err := mm.SetMountNamespace("/proc/0/ns/mnt"); err == nil {
+		t.Error("expected error for missing namespace")
+	}
+	t.Log("✓ 自身命名空间不经 nsenter,无效命名空间被拒绝")
+}
+
+// TestFailedLosetupDetachesLoop 验证 losetup 出错或超时时,内核已关联的 loop 设备被解除,
+// 之前已关联同一文件的设备保持不动
+func TestFailedLosetupDetachesLoop(t *testing.T) {
+	dir := t.TempDir()
+	sysDir := filepath.Join(dir, "block")
+	binDir := filepath.Join(dir, "bin")
+	imagePath := filepath.Join(dir, "image.erofs")
+	if err := os.WriteFile(imagePath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{filepath.Join(sysDir, "loop3", "loop"), binDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(sysDir, "loop3", "loop", "backing_file"), []byte(imagePath+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟 losetup:关联设备后出错退出,或在 LOSETUP_HANG 时关联后挂起
+	script := `#!/bin/sh
+sys=` + sysDir + `
+case "$1" in
+-f)
+	mkdir -p $sys/loop7/loop && echo "$3" > $sys/loop7/loop/backing_file
+	[ -n "$LOSETUP_HANG" ] && sleep 30
+	echo "losetup: failed to set up loop device" >&2
+	exit 1 ;;
+-d)
+	rm -rf $sys/$(basename $2)
+	echo $2 >> $sys/detached ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(binDir, "losetup"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", binDir+":"+os.Getenv("PATH"))
+	oldGlob := loopBackingGlob
+	loopBackingGlob = filepath.Join(sysDir, "loop*", "loop", "backing_file")
+	defer func() { loopBackingGlob = oldGlob }()
+
+	mm, err := NewMountManager(filepath.Join(dir, "root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mm.SetCommandTimeout(500 * time.Millisecond)
+
+	for _, hang := range []string{"", "1"} {
+		t.Setenv("LOSETUP_HANG", hang)
+		os.Remove(filepath.Join(sysDir, "detached"))
+		if _, err := mm.setupLoopDevice(context.Background(), imagePath); err == nil {
+			t.Fatal("expected losetup to fail")
+		}
+		detached, _ := os.ReadFile(filepath.Join(sysDir, "detached"))
+		if string(detached) != "/dev/loop7\n" {
+			t.Fatalf("expected only /dev/loop7 to be detached (hang=%q), got %q", hang, detached)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(sysDir, "loop3")); err != nil {
+		t.Errorf("pre-existing loop device must be kept: %v", err)
+	}
+	t.Logf("✓ losetup 出错和超时后解除已关联的 loop 设备")
+}
@@ -0,0 +1,271 @@
+package erofs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/containerd/log"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// FlattenedSuffix 扁平化镜像以父链最上层的 ID 加此后缀命名
+	FlattenedSuffix = ".flat"
+
+	overlayOpaqueXattr = "trusted.overlay.opaque"
+)
+
+// FlattenedImageID 返回以 topParent 为顶层的父链对应的扁平化镜像 ID
+func FlattenedImageID(topParent string) string {
+	return topParent + FlattenedSuffix
+}
+
+// BuildFlattenedImage 将按 overlay 顺序(第一个为最上层)排列的 lowerDirs 合并为单个 EROFS 镜像,
+// 合并时处理 overlay whiteout 和 opaque 目录。镜像先写入临时文件,完成后原子替换。
+func (b *Builder) BuildFlattenedImage(ctx context.Context, lowerDirs []string, imageID string) (string, error) {
+	stagingDir := b.stagingPath(imageID)
+	if err := os.RemoveAll(stagingDir); err != nil {
+		return "", err
+	}
+	txn, err := b.beginBuild(BuildKindFlatten, imageID, stagingDir)
+	if err != nil {
+		return "", err
+	}
+	defer txn.finish()
+
+	if err := os.MkdirAll(stagingDir, 0755); err != nil {
+		return "", err
+	}
+
+	for i := len(lowerDirs) - 1; i >= 0; i-- {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		if err := applyOverlayLayer(lowerDirs[i], stagingDir); err != nil {
+			return "", fmt.Errorf("failed to merge layer %s: %w", lowerDirs[i], err)
+		}
+	}
+
+	if err := b.buildErofsImage(ctx, stagingDir, txn.record.Temp); err != nil {
+		return "", err
+	}
+	if err := txn.commit(); err != nil {
+		return "", err
+	}
+
+	log.G(ctx).Infof("built flattened erofs image %s from %d layers", txn.imagePath, len(lowerDirs))
+	return txn.imagePath, nil
+}
+
+// EnsureFlattenedImage 返回 imageID 对应的扁平化镜像,不存在或不完整时用 lowerDirs 构建
+func (b *Builder) EnsureFlattenedImage(ctx context.Context, lowerDirs []string, imageID string) (string, error) {
+	if path := b.imagePath(imageID); CheckImage(path) == nil {
+		return path, nil
+	}
+	return b.BuildFlattenedImage(ctx, lowerDirs, imageID)
+}
+
+// applyOverlayLayer 把一层内容叠加到 target 上:
+// whiteout(0/0 字符设备)删除下层同名文件,opaque 目录先清空下层内容。
+// 保留属主、完整的权限位(含 setuid/setgid/sticky)、扩展属性和时间戳,层内的硬链接仍为硬链接,
+// 设备、FIFO 和 socket 节点用 mknod 重建
+func applyOverlayLayer(layerDir, target string) error {
+	links := make(map[inodeKey]string)
+	var dirs []string
+	var dirInfos []os.FileInfo
+
+	err := filepath.Walk(layerDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(layerDir, path)
+		if err != nil {
+			return err
+		}
+		if relPath == "." {
+			return nil
+		}
+		targetPath := filepath.Join(target, relPath)
+
+		if isWhiteout(info) {
+			return os.RemoveAll(targetPath)
+		}
+
+		existing, statErr := os.Lstat(targetPath)
+		if statErr == nil && (!info.IsDir() || !existing.IsDir()) {
+			if err := os.RemoveAll(targetPath); err != nil {
+				return err
+			}
+		}
+
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return fmt.Errorf("unsupported file info for %s", path)
+		}
+
+		switch {
+		case info.IsDir():
+			if isOpaqueDir(path) {
+				if err := os.RemoveAll(targetPath); err != nil {
+					return err
+				}
+			}
+			if err := os.MkdirAll(targetPath, 0700); err != nil {
+				return err
+			}
+			// 目录的时间戳在其内容写入后再设置
+			dirs = append(dirs, path)
+			dirInfos = append(dirInfos, info)
+			return copyMetadata(path, targetPath, info, stat, false)
+
+		case info.Mode().IsRegular():
+			key := inodeKey{dev: uint64(stat.Dev), ino: stat.Ino}
+			if stat.Nlink > 1 {
+				if first, ok := links[key]; ok {
+					return os.Link(first, targetPath)
+				}
+				links[key] = targetPath
+			}
+			if err := copyFileContents(path, targetPath); err != nil {
+				return err
+			}
+			return copyMetadata(path, targetPath, info, stat, true)
+
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(link, targetPath); err != nil {
+				return err
+			}
+			return copyMetadata(path, targetPath, info, stat, true)
+
+		default:
+			// 字符设备、块设备、FIFO 和 socket
+			if err := unix.Mknod(targetPath, stat.Mode, int(stat.Rdev)); err != nil {
+				return fmt.Errorf("failed to create special file %s: %w", relPath, err)
+			}
+			return copyMetadata(path, targetPath, info, stat, true)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		relPath, _ := filepath.Rel(layerDir, dirs[i])
+		if err := setTimes(filepath.Join(target, relPath), dirInfos[i].Sys().(*syscall.Stat_t)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inodeKey 标识层内的一个 inode,用于识别硬链接
+type inodeKey struct {
+	dev, ino uint64
+}
+
+// copyMetadata 把 source 的属主、权限位和扩展属性复制到 target,withTimes 为 true 时同时复制时间戳。
+// 先 chown 再 chmod,chown 会清除 setuid/setgid 位;符号链接没有独立的权限位
+func copyMetadata(source, target string, info os.FileInfo, stat *syscall.Stat_t, withTimes bool) error {
+	if err := os.Lchown(target, int(stat.Uid), int(stat.Gid)); err != nil {
+		return fmt.Errorf("failed to chown %s: %w", target, err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		if err := unix.Chmod(target, stat.Mode&07777); err != nil {
+			return fmt.Errorf("failed to chmod %s: %w", target, err)
+		}
+	}
+	if err := copyXattrs(source, target); err != nil {
+		return err
+	}
+	if withTimes {
+		return setTimes(target, stat)
+	}
+	return nil
+}
+
+// copyXattrs 复制 source 的扩展属性(包括 security.capability 和 SELinux 标签),
+// overlay 自身的 trusted.overlay.* 不属于镜像内容
+func copyXattrs(source, target string) error {
+	size, err := unix.Llistxattr(source, nil)
+	if err != nil {
+		if errors.Is(err, unix.ENOTSUP) {
+			return nil
+		}
+		return fmt.Errorf("failed to list xattrs of %s: %w", source, err)
+	}
+	if size == 0 {
+		return nil
+	}
+	buf := make([]byte, size)
+	size, err = unix.Llistxattr(source, buf)
+	if err != nil {
+		return fmt.Errorf("failed to list xattrs of %s: %w", source, err)
+	}
+
+	for _, name := range strings.Split(strings.TrimSuffix(string(buf[:size]), "\x00"), "\x00") {
+		if name == "" || strings.HasPrefix(name, "trusted.overlay.") {
+			continue
+		}
+		n, err := unix.Lgetxattr(source, name, nil)
+		if err != nil {
+			return fmt.Errorf("failed to read xattr %s of %s: %w", name, source, err)
+		}
+		value := make([]byte, n)
+		if n, err = unix.Lgetxattr(source, name, value); err != nil {
+			return fmt.Errorf("failed to read xattr %s of %s: %w", name, source, err)
+		}
+		if err := unix.Lsetxattr(target, name, value[:n], 0); err != nil {
+			return fmt.Errorf("failed to set xattr %s on %s: %w", name, target, err)
+		}
+	}
+	return nil
+}
+
+// setTimes 设置 path 本身(不跟随符号链接)的访问和修改时间
+func setTimes(path string, stat *syscall.Stat_t) error {
+	times := []unix.Timespec{unix.NsecToTimespec(stat.Atim.Nano()), unix.NsecToTimespec(stat.Mtim.Nano())}
+	return unix.UtimesNanoAt(unix.AT_FDCWD, path, times, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+func isWhiteout(info os.FileInfo) bool {
+	if info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && stat.Rdev == 0
+}
+
+func isOpaqueDir(path string) bool {
+	buf := make([]byte, 1)
+	n, err := unix.Lgetxattr(path, overlayOpaqueXattr, buf)
+	return err == nil && n == 1 && buf[0] == 'y'
+}
+
+func copyFileContents(source, target string) error {
+	input, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	output, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(output, input); err != nil {
+		output.Close()
+		return err
+	}
+	return output.Close()
+}
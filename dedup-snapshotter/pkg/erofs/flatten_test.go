@@ -0,0 +1,137 @@
+package erofs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestApplyOverlayLayers 验证扁平化合并按 overlay 语义处理覆盖、whiteout 和 opaque 目录
+func TestApplyOverlayLayers(t *testing.T) {
+	tmpDir := t.TempDir()
+	lower := filepath.Join(tmpDir, "lower")
+	upper := filepath.Join(tmpDir, "upper")
+	target := filepath.Join(tmpDir, "merged")
+
+	// 下层: a.txt, removed.txt, opaque/old.txt
+	writeTestFile(t, filepath.Join(lower, "a.txt"), "lower")
+	writeTestFile(t, filepath.Join(lower, "removed.txt"), "lower")
+	writeTestFile(t, filepath.Join(lower, "opaque", "old.txt"), "lower")
+
+	// 上层: 覆盖 a.txt, whiteout removed.txt, opaque 目录只保留 new.txt
+	writeTestFile(t, filepath.Join(upper, "a.txt"), "upper")
+	writeTestFile(t, filepath.Join(upper, "opaque", "new.txt"), "upper")
+	if err := unix.Mknod(filepath.Join(upper, "removed.txt"), unix.S_IFCHR, 0); err != nil {
+		t.Skipf("mknod whiteout not permitted: %v", err)
+	}
+	if err := unix.Setxattr(filepath.Join(upper, "opaque"), overlayOpaqueXattr, []byte("y"), 0); err != nil {
+		t.Skipf("trusted xattr not permitted: %v", err)
+	}
+
+	if err := os.MkdirAll(target, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// overlay 顺序: 上层在前,合并时从最下层开始应用
+	for _, layer := range []string{lower, upper} {
+		if err := applyOverlayLayer(layer, target); err != nil {
+			t.Fatalf("failed to apply layer %s: %v", layer, err)
+		}
+	}
+
+	if data, err := os.ReadFile(filepath.Join(target, "a.txt")); err != nil || string(data) != "upper" {
+		t.Errorf("expected a.txt from upper layer, got %q (err=%v)", data, err)
+	}
+	if _, err := os.Lstat(filepath.Join(target, "removed.txt")); !os.IsNotExist(err) {
+		t.Errorf("whiteout should remove removed.txt, stat err=%v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(target, "opaque", "old.txt")); !os.IsNotExist(err) {
+		t.Errorf("opaque dir should hide lower opaque/old.txt, stat err=%v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(target, "opaque", "new.txt")); err != nil {
+		t.Errorf("expected opaque/new.txt from upper layer: %v", err)
+	}
+}
+
+// TestApplyOverlayLayerMetadata 验证扁平化保留属主、setuid 位、扩展属性、硬链接和设备节点
+func TestApplyOverlayLayerMetadata(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("requires root to chown and mknod")
+	}
+
+	tmpDir := t.TempDir()
+	layer := filepath.Join(tmpDir, "layer")
+	target := filepath.Join(tmpDir, "merged")
+
+	suid := filepath.Join(layer, "bin", "su")
+	writeTestFile(t, suid, "setuid")
+	if err := os.Lchown(suid, 1000, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(suid, 0755|os.ModeSetuid); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(suid, filepath.Join(layer, "bin", "su-link")); err != nil {
+		t.Fatal(err)
+	}
+	hasXattr := unix.Lsetxattr(suid, "user.dedup-test", []byte("v"), 0) == nil
+	if err := unix.Mknod(filepath.Join(layer, "null"), unix.S_IFCHR|0666, int(unix.Mkdev(1, 3))); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(target, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyOverlayLayer(layer, target); err != nil {
+		t.Fatalf("failed to apply layer: %v", err)
+	}
+
+	var st unix.Stat_t
+	if err := unix.Lstat(filepath.Join(target, "bin", "su"), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Uid != 1000 || st.Gid != 1000 {
+		t.Errorf("expected owner 1000:1000, got %d:%d", st.Uid, st.Gid)
+	}
+	if st.Mode&07777 != unix.S_ISUID|0755 {
+		t.Errorf("expected mode 4755, got %o", st.Mode&07777)
+	}
+	if st.Nlink != 2 {
+		t.Errorf("expected hardlink to be kept, nlink=%d", st.Nlink)
+	}
+
+	var link unix.Stat_t
+	if err := unix.Lstat(filepath.Join(target, "bin", "su-link"), &link); err != nil {
+		t.Fatal(err)
+	}
+	if link.Ino != st.Ino {
+		t.Errorf("su-link should share inode %d, got %d", st.Ino, link.Ino)
+	}
+
+	if hasXattr {
+		value := make([]byte, 16)
+		n, err := unix.Lgetxattr(filepath.Join(target, "bin", "su"), "user.dedup-test", value)
+		if err != nil || string(value[:n]) != "v" {
+			t.Errorf("expected xattr to be copied, got %q (err=%v)", value[:n], err)
+		}
+	}
+
+	var dev unix.Stat_t
+	if err := unix.Lstat(filepath.Join(target, "null"), &dev); err != nil {
+		t.Fatal(err)
+	}
+	if dev.Mode&unix.S_IFMT != unix.S_IFCHR || dev.Rdev != unix.Mkdev(1, 3) {
+		t.Errorf("expected char device 1:3, got mode %o rdev %d", dev.Mode, dev.Rdev)
+	}
+	t.Logf("✓ 扁平化保留属主、setuid、硬链接和设备节点")
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,89 @@
+package erofs
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// FIFREEZE 和 FITHAW 是 linux/fs.h 中的 _IOWR('X', 119/120, int)
+const (
+	ioctlFIFREEZE = 0xc0045877
+	ioctlFITHAW   = 0xc0045878
+)
+
+// ErrFreezeUnsupported 表示文件系统不支持 fsfreeze(如内核未为 overlayfs 实现冻结)
+var ErrFreezeUnsupported = errors.New("filesystem does not support freezing")
+
+// SyncDir 把 dir 所在文件系统的脏数据写回磁盘
+func SyncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := unix.Syncfs(int(f.Fd())); err != nil {
+		return fmt.Errorf("syncfs %s: %w", dir, err)
+	}
+	return nil
+}
+
+// FindOverlayMount 从 mountinfo 中找出以 upperDir 为 upperdir 的 overlay 挂载点,未挂载时返回空字符串
+func FindOverlayMount(upperDir string) (string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	want := "upperdir=" + filepath.Clean(upperDir)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 可选字段之后以 "-" 分隔,其后为文件系统类型、挂载源和超级块选项
+		pre, post, ok := strings.Cut(scanner.Text(), " - ")
+		if !ok {
+			continue
+		}
+		fields, super := strings.Fields(pre), strings.Fields(post)
+		if len(fields) < 5 || len(super) < 3 || super[0] != "overlay" {
+			continue
+		}
+		for _, opt := range strings.Split(super[2], ",") {
+			if unescapeMountinfo(opt) == want {
+				return unescapeMountinfo(fields[4]), nil
+			}
+		}
+	}
+	return "", scanner.Err()
+}
+
+// FreezeFS 冻结 mountPoint 上的文件系统,写入阻塞直到 ThawFS
+func FreezeFS(mountPoint string) error {
+	return freezeIoctl(mountPoint, ioctlFIFREEZE)
+}
+
+// ThawFS 解冻 mountPoint 上的文件系统
+func ThawFS(mountPoint string) error {
+	return freezeIoctl(mountPoint, ioctlFITHAW)
+}
+
+func freezeIoctl(mountPoint string, req uint) error {
+	f, err := os.Open(mountPoint)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := unix.IoctlSetInt(int(f.Fd()), req, 0); err != nil {
+		if errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.ENOTTY) {
+			return fmt.Errorf("%s: %w", mountPoint, ErrFreezeUnsupported)
+		}
+		return fmt.Errorf("ioctl on %s: %w", mountPoint, err)
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package erofs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/containerd/log"
+)
+
+// 修复损坏 chunk 时使用的数据来源
+const (
+	HealSourceFile     = "source_file"
+	HealSourceDurable  = "durable"
+	HealSourceRegistry = "registry"
+)
+
+// ChunkFetchFunc 按哈希从远端(通常是镜像仓库)取回 chunk 数据
+type ChunkFetchFunc func(ctx context.Context, hash string) ([]byte, error)
+
+// ChunkStoreOp 是对本地 chunk 存储的一次读写,NotFound 表示读取的 chunk 不存在
+type ChunkStoreOp struct {
+	Op       string
+	Bytes    int64
+	NotFound bool
+	Err      error
+	Duration time.Duration
+}
+
+// HealEvent 记录一次损坏 chunk 的修复尝试,Error 非空表示修复失败
+type HealEvent struct {
+	Hash   string    `json:"hash"`
+	Source string    `json:"source,omitempty"`
+	Error  string    `json:"error,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// SetChunkFetcher 设置源文件不可用时修复 chunk 的远端来源
+func (b *Builder) SetChunkFetcher(fn ChunkFetchFunc) {
+	b.healMu.Lock()
+	defer b.healMu.Unlock()
+	b.fetchChunk = fn
+}
+
+// SetDurableFetcher 设置持久化层作为修复来源,先于镜像仓库尝试,镜像被仓库回收后仍可修复
+func (b *Builder) SetDurableFetcher(fn ChunkFetchFunc) {
+	b.healMu.Lock()
+	defer b.healMu.Unlock()
+	b.fetchDurable = fn
+}
+
+// SetChunkReplicator 设置新 chunk 写入本地后的回调。回调同步执行,data 在返回后会被复用,不能保留
+func (b *Builder) SetChunkReplicator(fn func(hash string, data []byte)) {
+	b.healMu.Lock()
+	defer b.healMu.Unlock()
+	b.replicate = fn
+}
+
+func (b *Builder) replicateChunk(hash string, data []byte) {
+	b.healMu.RLock()
+	fn := b.replicate
+	b.healMu.RUnlock()
+
+	if fn != nil {
+		fn(hash, data)
+	}
+}
+
+// SetHealHandler 设置修复事件的回调,用于记录指标和日志
+func (b *Builder) SetHealHandler(fn func(HealEvent)) {
+	b.healMu.Lock()
+	defer b.healMu.Unlock()
+	b.onHeal = fn
+}
+
+// readVerifiedChunk 读取 chunk 并校验哈希。损坏或丢失时依次从源文件对应区间
+// 和远端取回数据,校验通过后覆盖本地 chunk 文件。
+func (b *Builder) readVerifiedChunk(ctx context.Context, sourcePath string, chunk ChunkInfo) ([]byte, error) {
+	if chunk.Hash == ZeroChunkHash {
+		return make([]byte, chunk.Size), nil
+	}
+	if data, ok := b.cache.Get(chunk.Hash); ok {
+		return data, nil
+	}
+	chunkPath := filepath.Join(b.chunksDir, chunk.Hash)
+	start := time.Now()
+	data, err := os.ReadFile(chunkPath)
+	if err == nil && chunkHash(data) == chunk.Hash {
+		b.observeChunkOp("read", int64(len(data)), nil, start)
+		b.cache.Add(chunk.Hash, data)
+		return data, nil
+	}
+
+	if err != nil {
+		b.observeChunkOp("read", 0, err, start)
+		log.G(ctx).WithError(err).Warnf("chunk %s unreadable, healing", chunk.Hash)
+	} else {
+		b.observeChunkOp("read", 0, fmt.Errorf("chunk %s hash mismatch", chunk.Hash), start)
+		log.G(ctx).Warnf("chunk %s hash mismatch, healing", chunk.Hash)
+	}
+
+	data, source, healErr := b.healChunk(ctx, sourcePath, chunk)
+	event := HealEvent{Hash: chunk.Hash, Source: source, Time: time.Now()}
+	if healErr == nil {
+		healErr = writeFileAtomic(chunkPath, data)
+	}
+	if healErr != nil {
+		event.Error = healErr.Error()
+	}
+	b.notifyHeal(event)
+
+	if healErr != nil {
+		return nil, fmt.Errorf("chunk %s is corrupt and could not be healed: %w", chunk.Hash, healErr)
+	}
+	log.G(ctx).Infof("healed chunk %s from %s", chunk.Hash, source)
+	b.cache.Add(chunk.Hash, data)
+	return data, nil
+}
+
+func (b *Builder) healChunk(ctx context.Context, sourcePath string, chunk ChunkInfo) ([]byte, string, error) {
+	var errs []error
+
+	if sourcePath != "" {
+		data, err := readRange(sourcePath, chunk.Offset, chunk.Size)
+		if err == nil && chunkHash(data) == chunk.Hash {
+			return data, HealSourceFile, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("source file changed")
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", HealSourceFile, err))
+	}
+
+	b.healMu.RLock()
+	fetchDurable, fetch := b.fetchDurable, b.fetchChunk
+	b.healMu.RUnlock()
+
+	if fetchDurable != nil {
+		data, err := fetchDurable(ctx, chunk.Hash)
+		if err == nil && chunkHash(data) == chunk.Hash {
+			return data, HealSourceDurable, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("fetched data hash mismatch")
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", HealSourceDurable, err))
+	}
+
+	if fetch != nil {
+		data, err := fetch(ctx, chunk.Hash)
+		if err == nil && chunkHash(data) == chunk.Hash {
+			return data, HealSourceRegistry, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("fetched data hash mismatch")
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", HealSourceRegistry, err))
+	}
+
+	if len(errs) == 0 {
+		return nil, "", fmt.Errorf("no healing source available")
+	}
+	return nil, "", fmt.Errorf("%v", errs)
+}
+
+// SetChunkStoreObserver 设置本地 chunk 存储读写的回调,用于按后端记录指标
+func (b *Builder) SetChunkStoreObserver(fn func(ChunkStoreOp)) {
+	b.healMu.Lock()
+	defer b.healMu.Unlock()
+	b.onChunkOp = fn
+}
+
+func (b *Builder) observeChunkOp(op string, bytes int64, err error, start time.Time) {
+	b.healMu.RLock()
+	fn := b.onChunkOp
+	b.healMu.RUnlock()
+
+	if fn != nil {
+		fn(ChunkStoreOp{Op: op, Bytes: bytes, NotFound: os.IsNotExist(err), Err: err, Duration: time.Since(start)})
+	}
+}
+
+func (b *Builder) notifyHeal(event HealEvent) {
+	b.healMu.RLock()
+	onHeal := b.onHeal
+	b.healMu.RUnlock()
+
+	if onHeal != nil {
+		onHeal(event)
+	}
+}
+
+func chunkHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func readRange(path string, offset, size int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data := make([]byte, size)
+	if _, err := f.ReadAt(data, offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data, nil
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,83 @@
+package erofs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestReconstructHealsCorruptChunk 验证损坏的 chunk 依次从源文件和远端修复,都不可用时构建失败
+func TestReconstructHealsCorruptChunk(t *testing.T) {
+	tmpDir := t.TempDir()
+	builder, err := NewBuilder(filepath.Join(tmpDir, "root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer builder.Close()
+
+	var events []HealEvent
+	builder.SetHealHandler(func(e HealEvent) { events = append(events, e) })
+
+	content := append(bytes.Repeat([]byte("a"), ChunkSize), []byte("tail")...)
+	source := filepath.Join(tmpDir, "source")
+	if err := os.WriteFile(source, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := builder.chunkFile(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	corrupt := func() {
+		if err := os.WriteFile(filepath.Join(builder.chunksDir, chunks[1].Hash), []byte("bad!"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	target := filepath.Join(tmpDir, "target")
+	ctx := context.Background()
+
+	corrupt()
+	if err := builder.reconstructFile(ctx, source, target, chunks, int64(len(content))); err != nil {
+		t.Fatalf("expected healing from source file: %v", err)
+	}
+	if data, _ := os.ReadFile(target); !bytes.Equal(data, content) {
+		t.Fatal("reconstructed file does not match source")
+	}
+	if len(events) != 1 || events[0].Source != HealSourceFile || events[0].Error != "" {
+		t.Fatalf("unexpected heal events: %+v", events)
+	}
+	t.Logf("✓ 损坏 chunk 从源文件修复")
+
+	corrupt()
+	os.Remove(source)
+	builder.SetChunkFetcher(func(ctx context.Context, hash string) ([]byte, error) {
+		return []byte("tail"), nil
+	})
+	if err := builder.reconstructFile(ctx, source, target, chunks, int64(len(content))); err != nil {
+		t.Fatalf("expected healing from registry: %v", err)
+	}
+	if len(events) != 2 || events[1].Source != HealSourceRegistry {
+		t.Fatalf("unexpected heal events: %+v", events)
+	}
+	t.Logf("✓ 源文件不可用时从远端修复")
+
+	corrupt()
+	builder.SetChunkFetcher(func(ctx context.Context, hash string) ([]byte, error) {
+		return nil, errors.New("unreachable")
+	})
+	if err := builder.reconstructFile(ctx, source, target, chunks, int64(len(content))); err == nil {
+		t.Fatal("expected reconstruction to fail when chunk cannot be healed")
+	}
+	if len(events) != 3 || events[2].Error == "" {
+		t.Fatalf("expected failed heal event, got %+v", events)
+	}
+	t.Logf("✓ 无法修复时构建失败并记录事件")
+}
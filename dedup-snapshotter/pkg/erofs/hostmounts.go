@@ -0,0 +1,137 @@
+package erofs
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// HostMount 是挂载命名空间 mountinfo 中的一个挂载,LowerDirs 和 UpperDir 仅对 overlay 有值
+type HostMount struct {
+	Path      string
+	FSType    string
+	Source    string
+	LowerDirs []string
+	UpperDir  string
+}
+
+// HostMounts 返回 mount/umount 所在挂载命名空间(见 SetMountNamespace)中的全部挂载
+func (m *MountManager) HostMounts() ([]HostMount, error) {
+	m.mountsMu.RLock()
+	namespace := m.namespace
+	m.mountsMu.RUnlock()
+
+	// /proc/<pid>/ns/mnt 所在命名空间的挂载见 /proc/<pid>/mountinfo
+	path := "/proc/self/mountinfo"
+	if namespace != "" && strings.HasSuffix(namespace, "/ns/mnt") {
+		path = filepath.Join(filepath.Dir(filepath.Dir(namespace)), "mountinfo")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseMountinfo(f)
+}
+
+func parseMountinfo(r io.Reader) ([]HostMount, error) {
+	var mounts []HostMount
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		pre, post, ok := strings.Cut(scanner.Text(), " - ")
+		if !ok {
+			continue
+		}
+		fields, super := strings.Fields(pre), strings.Fields(post)
+		if len(fields) < 5 || len(super) < 3 {
+			continue
+		}
+		hm := HostMount{
+			Path:   unescapeMountinfo(fields[4]),
+			FSType: super[0],
+			Source: unescapeMountinfo(super[1]),
+		}
+		if hm.FSType == "overlay" {
+			for _, opt := range strings.Split(super[2], ",") {
+				key, value, _ := strings.Cut(unescapeMountinfo(opt), "=")
+				switch key {
+				case "lowerdir":
+					hm.LowerDirs = strings.Split(value, ":")
+				case "upperdir":
+					hm.UpperDir = value
+				}
+			}
+		}
+		mounts = append(mounts, hm)
+	}
+	return mounts, scanner.Err()
+}
+
+// loopBackingGlob 匹配 sysfs 中各 loop 设备的后端文件
+var loopBackingGlob = "/sys/block/loop*/loop/backing_file"
+
+// LoopDevices 返回已关联文件的 loop 设备及其后端文件路径
+func LoopDevices() (map[string]string, error) {
+	paths, err := filepath.Glob(loopBackingGlob)
+	if err != nil {
+		return nil, err
+	}
+	devices := make(map[string]string, len(paths))
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		name := filepath.Base(filepath.Dir(filepath.Dir(p)))
+		devices["/dev/"+name] = strings.TrimSpace(string(data))
+	}
+	return devices, nil
+}
+
+// MountsDir 返回 EROFS 层和底部合并层的挂载目录
+func (m *MountManager) MountsDir() string {
+	return m.mountsDir
+}
+
+// DerivedMount 报告 MountsDir 下名为 name 的挂载是否为底部合并层或 idmapped 挂载,而不是某个快照的层挂载
+func DerivedMount(name string) bool {
+	return strings.HasPrefix(name, mergedMountPrefix) || strings.HasPrefix(name, idmapMountPrefix)
+}
+
+// Tracked 报告 path 是否为本进程登记的活动挂载的挂载点或 loop 设备
+func (m *MountManager) Tracked(path string) bool {
+	m.mountsMu.RLock()
+	defer m.mountsMu.RUnlock()
+	for _, mp := range m.activeMounts {
+		if mp.MountPath == path || mp.LoopDevice == path {
+			return true
+		}
+	}
+	return false
+}
+
+// ForceUnmount 以 lazy 方式卸载不再被登记的挂载,即使仍有进程打开其中的文件;
+// 挂载点在 MountsDir 之下时一并删除目录
+func (m *MountManager) ForceUnmount(ctx context.Context, mountPath string) error {
+	if m.Tracked(mountPath) {
+		return fmt.Errorf("%s is an active mount, refusing to force unmount", mountPath)
+	}
+	output, err := m.runMount(ctx, "umount", "-l", mountPath)
+	if err != nil {
+		return fmt.Errorf("umount -l %s failed: %w, output: %s", mountPath, err, string(output))
+	}
+	if filepath.Dir(mountPath) == m.mountsDir {
+		os.Remove(mountPath)
+	}
+	return nil
+}
+
+// DetachLoop 解除 loop 设备与后端文件的关联
+func (m *MountManager) DetachLoop(ctx context.Context, loopDev string) error {
+	return m.detachLoopDevice(ctx, loopDev)
+}
@@ -0,0 +1,269 @@
+package erofs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/log"
+	"golang.org/x/sys/unix"
+)
+
+const idmapMountPrefix = "idmap-"
+
+// IDRange 是一段连续的 ID 映射:容器内从 ContainerID 起的 Size 个 ID 对应主机上从 HostID 起的 ID
+type IDRange struct {
+	ContainerID uint32
+	HostID      uint32
+	Size        uint32
+}
+
+// IDMap 是用户命名空间容器的 UID/GID 映射,来自 containerd 的
+// containerd.io/snapshot/uidmapping 和 gidmapping 快照标签
+type IDMap struct {
+	UIDs []IDRange
+	GIDs []IDRange
+}
+
+// ParseIDMap 解析快照标签中的 ID 映射,格式为 "容器ID:主机ID:长度",多段以逗号分隔。
+// 两个标签都不存在时返回 nil
+func ParseIDMap(labels map[string]string) (*IDMap, error) {
+	uid, hasUID := labels[snapshots.LabelSnapshotUIDMapping]
+	gid, hasGID := labels[snapshots.LabelSnapshotGIDMapping]
+	if !hasUID && !hasGID {
+		return nil, nil
+	}
+	if !hasUID || !hasGID {
+		return nil, fmt.Errorf("%s and %s must be set together", snapshots.LabelSnapshotUIDMapping, snapshots.LabelSnapshotGIDMapping)
+	}
+
+	uids, err := parseIDRanges(uid)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %w", snapshots.LabelSnapshotUIDMapping, uid, err)
+	}
+	gids, err := parseIDRanges(gid)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %w", snapshots.LabelSnapshotGIDMapping, gid, err)
+	}
+	return &IDMap{UIDs: uids, GIDs: gids}, nil
+}
+
+func parseIDRanges(s string) ([]IDRange, error) {
+	var ranges []IDRange
+	for _, part := range strings.Split(s, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ":")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("expected container:host:size")
+		}
+		var values [3]uint32
+		for i, f := range fields {
+			v, err := strconv.ParseUint(f, 10, 32)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = uint32(v)
+		}
+		r := IDRange{ContainerID: values[0], HostID: values[1], Size: values[2]}
+		if r.Size == 0 || uint64(r.ContainerID)+uint64(r.Size) > 1<<32 || uint64(r.HostID)+uint64(r.Size) > 1<<32 {
+			return nil, fmt.Errorf("range %s is empty or overflows", part)
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+func hostID(ranges []IDRange, id uint32) (int, bool) {
+	for _, r := range ranges {
+		if id >= r.ContainerID && id-r.ContainerID < r.Size {
+			return int(r.HostID + id - r.ContainerID), true
+		}
+	}
+	return 0, false
+}
+
+// RootPair 返回容器内 root 对应的主机 UID/GID
+func (m *IDMap) RootPair() (int, int, error) {
+	uid, ok := hostID(m.UIDs, 0)
+	if !ok {
+		return 0, 0, fmt.Errorf("container root uid is not mapped")
+	}
+	gid, ok := hostID(m.GIDs, 0)
+	if !ok {
+		return 0, 0, fmt.Errorf("container root gid is not mapped")
+	}
+	return uid, gid, nil
+}
+
+// Key 返回映射的规范表示,相同映射的挂载可以共享
+func (m *IDMap) Key() string {
+	format := func(ranges []IDRange) string {
+		parts := make([]string, len(ranges))
+		for i, r := range ranges {
+			parts[i] = fmt.Sprintf("%d:%d:%d", r.ContainerID, r.HostID, r.Size)
+		}
+		return strings.Join(parts, ",")
+	}
+	return "u" + format(m.UIDs) + "/g" + format(m.GIDs)
+}
+
+func sysProcIDMap(ranges []IDRange) []syscall.SysProcIDMap {
+	out := make([]syscall.SysProcIDMap, len(ranges))
+	for i, r := range ranges {
+		out[i] = syscall.SysProcIDMap{ContainerID: int(r.ContainerID), HostID: int(r.HostID), Size: int(r.Size)}
+	}
+	return out
+}
+
+// usernsFD 创建带有该映射的用户命名空间并返回其文件描述符。
+// 子进程以 ptrace 方式启动,在 exec 处停住,拿到命名空间后即被杀死
+func usernsFD(m *IDMap) (*os.File, error) {
+	// ptrace 的跟踪者是线程,Start 和 Wait 必须在同一线程上
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	cmd := exec.Command("/proc/self/exe")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:  unix.CLONE_NEWUSER,
+		UidMappings: sysProcIDMap(m.UIDs),
+		GidMappings: sysProcIDMap(m.GIDs),
+		Ptrace:      true,
+		Pdeathsig:   syscall.SIGKILL,
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to create user namespace: %w", err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	return os.Open(fmt.Sprintf("/proc/%d/ns/user", cmd.Process.Pid))
+}
+
+// mountIDMapped 把 source 克隆为分离的挂载,设置 idmap 后挂到 target
+func mountIDMapped(source, target string, userns *os.File) error {
+	fd, err := unix.OpenTree(unix.AT_FDCWD, source, unix.OPEN_TREE_CLONE|unix.OPEN_TREE_CLOEXEC)
+	if err != nil {
+		return fmt.Errorf("open_tree %s: %w", source, err)
+	}
+	defer unix.Close(fd)
+
+	attr := unix.MountAttr{Attr_set: unix.MOUNT_ATTR_IDMAP, Userns_fd: uint64(userns.Fd())}
+	if err := unix.MountSetattr(fd, "", unix.AT_EMPTY_PATH, &attr); err != nil {
+		return fmt.Errorf("mount_setattr %s: %w", source, err)
+	}
+	if err := unix.MoveMount(fd, "", unix.AT_FDCWD, target, unix.MOVE_MOUNT_F_EMPTY_PATH); err != nil {
+		return fmt.Errorf("move_mount %s: %w", target, err)
+	}
+	return nil
+}
+
+func idmapMountID(source string, m *IDMap) string {
+	sum := sha256.Sum256([]byte(source + "|" + m.Key()))
+	return idmapMountPrefix + hex.EncodeToString(sum[:])[:16]
+}
+
+// IDMapSupported 探测能否使用 idmapped 挂载:需要 mount_setattr(5.12+)、
+// 存储所在文件系统支持 idmap,且挂载在本进程的命名空间中进行。结果只探测一次
+func (m *MountManager) IDMapSupported() bool {
+	m.idmapOnce.Do(func() {
+		m.mountsMu.RLock()
+		namespace := m.namespace
+		m.mountsMu.RUnlock()
+		if namespace != "" {
+			log.L.Infof("idmapped mounts disabled: mounts are performed in namespace %s", namespace)
+			return
+		}
+		if err := m.probeIDMap(); err != nil {
+			log.L.WithError(err).Infof("idmapped mounts not supported")
+			return
+		}
+		m.idmapSupported = true
+	})
+	return m.idmapSupported
+}
+
+func (m *MountManager) probeIDMap() error {
+	dir, err := os.MkdirTemp(m.mountsDir, ".idmap-probe-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "target")
+	if err := os.Mkdir(target, 0755); err != nil {
+		return err
+	}
+
+	userns, err := usernsFD(&IDMap{UIDs: []IDRange{{HostID: 65534, Size: 1}}, GIDs: []IDRange{{HostID: 65534, Size: 1}}})
+	if err != nil {
+		return err
+	}
+	defer userns.Close()
+
+	if err := mountIDMapped(dir, target, userns); err != nil {
+		return err
+	}
+	return unix.Unmount(target, unix.MNT_DETACH)
+}
+
+// MountIDMapped 为快照的每个 source 目录创建按 idmap 映射属主的克隆挂载,返回对应的挂载路径。
+// 相同目录和映射的挂载在快照间共享,任一失败时已建立的挂载被释放;
+// 成功的挂载记在快照名下,由 ReleaseSnapshot 释放
+func (m *MountManager) MountIDMapped(ctx context.Context, snapshotID string, sources []string, idmap *IDMap) ([]string, error) {
+	userns, err := usernsFD(idmap)
+	if err != nil {
+		return nil, err
+	}
+	defer userns.Close()
+
+	var paths, ids []string
+	for _, source := range sources {
+		id := idmapMountID(source, idmap)
+		mountPath, err := m.mountIDMappedSource(ctx, id, source, userns)
+		if err != nil {
+			m.releaseMerged(ids)
+			return nil, err
+		}
+		paths = append(paths, mountPath)
+		ids = append(ids, id)
+	}
+
+	m.mountsMu.Lock()
+	m.snapshotIDMapped[snapshotID] = append(m.snapshotIDMapped[snapshotID], ids...)
+	m.mountsMu.Unlock()
+	return paths, nil
+}
+
+func (m *MountManager) mountIDMappedSource(ctx context.Context, id, source string, userns *os.File) (string, error) {
+	if existing, reserved, err := m.reserve(ctx, id); !reserved {
+		return existing, err
+	}
+	var mp *MountPoint
+	defer func() { m.release(id, mp) }()
+
+	mountPath := filepath.Join(m.mountsDir, id)
+	if err := os.MkdirAll(mountPath, 0755); err != nil {
+		return "", err
+	}
+	if err := mountIDMapped(source, mountPath, userns); err != nil {
+		os.Remove(mountPath)
+		return "", err
+	}
+
+	mp = &MountPoint{
+		ID:        id,
+		ImagePath: "idmap://" + source,
+		MountPath: mountPath,
+		RefCount:  1,
+	}
+	return mountPath, nil
+}
@@ -0,0 +1,83 @@
+package erofs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/containerd/containerd/snapshots"
+)
+
+// TestIDMappedMounts 验证 containerd 的映射标签解析,以及在支持的内核上
+// idmapped 克隆挂载按映射显示属主、随快照释放
+func TestIDMappedMounts(t *testing.T) {
+	labels := map[string]string{
+		snapshots.LabelSnapshotUIDMapping: "0:100000:65536",
+		snapshots.LabelSnapshotGIDMapping: "0:200000:65536",
+	}
+	idmap, err := ParseIDMap(labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid, gid, err := idmap.RootPair(); err != nil || uid != 100000 || gid != 200000 {
+		t.Fatalf("unexpected root pair %d:%d, %v", uid, gid, err)
+	}
+	if idmap, err := ParseIDMap(map[string]string{}); idmap != nil || err != nil {
+		t.Errorf("expected no mapping without labels, got %+v %v", idmap, err)
+	}
+	for _, bad := range []map[string]string{
+		{snapshots.LabelSnapshotUIDMapping: "0:100000:65536"},
+		{snapshots.LabelSnapshotUIDMapping: "0:100000", snapshots.LabelSnapshotGIDMapping: "0:100000:65536"},
+		{snapshots.LabelSnapshotUIDMapping: "0:4294967295:2", snapshots.LabelSnapshotGIDMapping: "0:100000:65536"},
+	} {
+		if _, err := ParseIDMap(bad); err == nil {
+			t.Errorf("expected %v to be rejected", bad)
+		}
+	}
+	t.Logf("✓ 解析映射标签,拒绝不完整或越界的映射")
+
+	if os.Geteuid() != 0 {
+		t.Skip("idmapped mounts require root")
+	}
+	tmpDir := t.TempDir()
+	mm, err := NewMountManager(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mm.UnmountAll()
+	if !mm.IDMapSupported() {
+		t.Skip("idmapped mounts not supported by this kernel or filesystem")
+	}
+
+	layer := filepath.Join(tmpDir, "layer")
+	writeTestFile(t, filepath.Join(layer, "etc", "passwd"), "root:x:0:0::/root:/bin/sh\n")
+	paths, err := mm.MountIDMapped(context.Background(), "snap-1", []string{layer}, idmap)
+	if err != nil {
+		t.Fatalf("failed to create idmapped mount: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(paths[0], "etc", "passwd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := info.Sys().(*syscall.Stat_t); st.Uid != 100000 || st.Gid != 200000 {
+		t.Fatalf("expected file owned by 100000:200000 through idmapped mount, got %d:%d", st.Uid, st.Gid)
+	}
+	t.Logf("✓ idmapped 挂载中 root 文件显示为 %d:%d,原目录未 chown", 100000, 200000)
+
+	// 相同目录和映射的挂载被共享
+	again, err := mm.MountIDMapped(context.Background(), "snap-2", []string{layer}, idmap)
+	if err != nil || again[0] != paths[0] {
+		t.Fatalf("expected shared idmapped mount, got %v %v", again, err)
+	}
+	mm.ReleaseSnapshot("snap-1")
+	if _, err := os.Stat(filepath.Join(paths[0], "etc")); err != nil {
+		t.Errorf("mount released while still used by snap-2: %v", err)
+	}
+	mm.ReleaseSnapshot("snap-2")
+	if stats := mm.GetStats(); len(stats) != 0 {
+		t.Errorf("expected idmapped mounts released, %d remain", len(stats))
+	}
+	t.Logf("✓ 共享的 idmapped 挂载在最后一个快照释放后卸载")
+}
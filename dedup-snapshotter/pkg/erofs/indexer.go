@@ -2,14 +2,19 @@ package erofs
 
 import (
 	"database/sql"
+	"fmt"
 	"sync"
 
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/opencloudos/dedup-snapshotter/pkg/chunkcache"
+	"github.com/opencloudos/dedup-snapshotter/pkg/refcount"
 )
 
 type ChunkIndexer struct {
 	db *sql.DB
 	mu sync.RWMutex
+	// meta 缓存 chunk 的大小、分层和引用计数,写事务提交后在 mu 内同步更新
+	meta *chunkcache.MetaCache
 }
 
 type ChunkStats struct {
@@ -17,7 +22,11 @@ type ChunkStats struct {
 	UniqueChunks int64
 	TotalSize    int64
 	DedupeSize   int64
-	DedupRatio   float64
+	// ExclusiveSize 是只被该镜像引用的 chunk 总大小,即删除镜像后可释放的空间
+	ExclusiveSize int64
+	// ZeroSize 是省略存储的全零 chunk 总大小
+	ZeroSize   int64
+	DedupRatio float64
 }
 
 func NewChunkIndexer(dbPath string) (*ChunkIndexer, error) {
@@ -35,13 +44,26 @@ func NewChunkIndexer(dbPath string) (*ChunkIndexer, error) {
 	return indexer, nil
 }
 
+// SetMetaCache 设置 chunk 元数据缓存,nil 表示每次查找都查询数据库
+func (c *ChunkIndexer) SetMetaCache(meta *chunkcache.MetaCache) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.meta = meta
+}
+
+// statsSchemaVersion 是统计计数器的版本,低于该版本的索引在打开时回填一次计数器。
+// 版本 2 起 ref_count 为 chunk 在所有镜像中出现的次数,旧版删除镜像时每个 chunk 只减一,回填时重新计数
+const statsSchemaVersion = 2
+
 func (c *ChunkIndexer) init() error {
 	schema := `
 	CREATE TABLE IF NOT EXISTS chunks (
 		hash TEXT PRIMARY KEY,
 		size INTEGER NOT NULL,
 		ref_count INTEGER DEFAULT 0,
-		first_seen INTEGER DEFAULT (strftime('%s', 'now'))
+		first_seen INTEGER DEFAULT (strftime('%s', 'now')),
+		tier TEXT NOT NULL DEFAULT 'large',
+		image_refs INTEGER NOT NULL DEFAULT 0
 	);
 
 	CREATE TABLE IF NOT EXISTS image_chunks (
@@ -56,20 +78,128 @@ func (c *ChunkIndexer) init() error {
 		image_id TEXT PRIMARY KEY,
 		created_at INTEGER DEFAULT (strftime('%s', 'now')),
 		total_size INTEGER DEFAULT 0,
-		chunk_count INTEGER DEFAULT 0
+		chunk_count INTEGER DEFAULT 0,
+		unique_chunks INTEGER NOT NULL DEFAULT 0,
+		dedupe_size INTEGER NOT NULL DEFAULT 0,
+		exclusive_size INTEGER NOT NULL DEFAULT 0,
+		zero_size INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS tier_stats (
+		tier TEXT PRIMARY KEY,
+		chunks INTEGER NOT NULL DEFAULT 0,
+		stored_size INTEGER NOT NULL DEFAULT 0,
+		logical_size INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS counters (
+		name TEXT PRIMARY KEY,
+		value INTEGER NOT NULL DEFAULT 0
 	);
 
 	CREATE INDEX IF NOT EXISTS idx_chunks_hash ON chunks(hash);
 	CREATE INDEX IF NOT EXISTS idx_chunks_refcount ON chunks(ref_count);
 	CREATE INDEX IF NOT EXISTS idx_image_chunks_image ON image_chunks(image_id);
+	CREATE INDEX IF NOT EXISTS idx_image_chunks_hash ON image_chunks(chunk_hash);
 	CREATE INDEX IF NOT EXISTS idx_images_created ON images(created_at);
 	`
 
-	_, err := c.db.Exec(schema)
-	return err
+	if _, err := c.db.Exec(schema); err != nil {
+		return err
+	}
+
+	// 分层之前创建的索引没有 tier 列,已有 chunk 都属于大块层;
+	// 计数器之前创建的索引缺少计数列,由 backfillStats 回填
+	columns := []struct{ table, name, def string }{
+		{"chunks", "tier", "TEXT NOT NULL DEFAULT 'large'"},
+		{"chunks", "image_refs", "INTEGER NOT NULL DEFAULT 0"},
+		{"images", "unique_chunks", "INTEGER NOT NULL DEFAULT 0"},
+		{"images", "dedupe_size", "INTEGER NOT NULL DEFAULT 0"},
+		{"images", "exclusive_size", "INTEGER NOT NULL DEFAULT 0"},
+		{"images", "zero_size", "INTEGER NOT NULL DEFAULT 0"},
+	}
+	for _, col := range columns {
+		var exists int
+		if err := c.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, col.table, col.name).Scan(&exists); err != nil {
+			return err
+		}
+		if exists == 0 {
+			if _, err := c.db.Exec(`ALTER TABLE ` + col.table + ` ADD COLUMN ` + col.name + ` ` + col.def); err != nil {
+				return err
+			}
+		}
+	}
+
+	var version int
+	if err := c.db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	if version < statsSchemaVersion {
+		return c.backfillStats()
+	}
+	return nil
+}
+
+// backfillStats 用全表聚合一次性重建计数器,只在升级旧索引时执行
+func (c *ChunkIndexer) backfillStats() error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		`UPDATE chunks SET ref_count = (
+			SELECT COUNT(*) FROM image_chunks WHERE chunk_hash = chunks.hash
+		)`,
+		`DELETE FROM chunks WHERE ref_count = 0`,
+		`UPDATE chunks SET image_refs = (
+			SELECT COUNT(DISTINCT image_id) FROM image_chunks WHERE chunk_hash = chunks.hash
+		)`,
+		`UPDATE images SET
+			chunk_count = (SELECT COUNT(*) FROM image_chunks WHERE image_id = images.image_id),
+			unique_chunks = (
+				SELECT COUNT(DISTINCT ic.chunk_hash) FROM image_chunks ic
+				JOIN chunks c ON ic.chunk_hash = c.hash
+				WHERE ic.image_id = images.image_id
+			),
+			dedupe_size = COALESCE((
+				SELECT SUM(c.size) FROM (SELECT DISTINCT chunk_hash FROM image_chunks WHERE image_id = images.image_id) ic
+				JOIN chunks c ON ic.chunk_hash = c.hash
+			), 0),
+			exclusive_size = COALESCE((
+				SELECT SUM(c.size) FROM (SELECT DISTINCT chunk_hash FROM image_chunks WHERE image_id = images.image_id) ic
+				JOIN chunks c ON ic.chunk_hash = c.hash
+				WHERE c.image_refs = 1
+			), 0)`,
+		`DELETE FROM tier_stats`,
+		`INSERT INTO tier_stats (tier, chunks, stored_size, logical_size)
+			SELECT tier, COUNT(*), COALESCE(SUM(size), 0), COALESCE(SUM(size * ref_count), 0)
+			FROM chunks GROUP BY tier`,
+		`INSERT INTO tier_stats (tier, chunks, stored_size, logical_size)
+			SELECT 'zero', 0, 0, COALESCE(SUM(zero_size), 0) FROM images`,
+		`INSERT OR REPLACE INTO counters (name, value) SELECT 'images', COUNT(*) FROM images`,
+		fmt.Sprintf(`PRAGMA user_version = %d`, statsSchemaVersion),
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to backfill chunk stats: %w", err)
+		}
+	}
+	return tx.Commit()
 }
 
 func (c *ChunkIndexer) RecordChunk(imageID, chunkHash string, size int64) error {
+	return c.RecordChunkTier(imageID, chunkHash, size, ChunkTierLarge)
+}
+
+// RecordChunkTier 记录镜像引用的 chunk 及其所属分层,并在同一事务中更新统计计数器,
+// 每次记录只做按主键或索引的查找,代价与镜像和 chunk 总数无关
+func (c *ChunkIndexer) RecordChunkTier(imageID, chunkHash string, size int64, tier string) error {
+	if chunkHash == ZeroChunkHash {
+		return c.recordZeroChunk(imageID, size)
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -79,25 +209,50 @@ func (c *ChunkIndexer) RecordChunk(imageID, chunkHash string, size int64) error
 	}
 	defer tx.Rollback()
 
-	_, err = tx.Exec(`
-		INSERT INTO chunks (hash, size, ref_count)
-		VALUES (?, ?, 1)
-		ON CONFLICT(hash) DO UPDATE SET ref_count = ref_count + 1
-	`, chunkHash, size)
+	// 已有 chunk 的大小和分层以首次记录为准,缓存命中时不再查询
+	meta, cached := c.meta.Get(chunkHash)
+	newChunk := false
+	if !cached {
+		meta = chunkcache.ChunkMeta{Size: size, Tier: tier}
+		err = tx.QueryRow(`SELECT size, tier, ref_count FROM chunks WHERE hash = ?`, chunkHash).Scan(&meta.Size, &meta.Tier, &meta.RefCount)
+		newChunk = err == sql.ErrNoRows
+		if err != nil && !newChunk {
+			return err
+		}
+	}
+	chunkSize, chunkTier := meta.Size, meta.Tier
+
+	_, err = tx.Exec(`INSERT OR IGNORE INTO chunks (hash, size, ref_count, tier) VALUES (?, ?, 0, ?)`, chunkHash, size, tier)
 	if err != nil {
 		return err
 	}
-
-	var order int
-	err = tx.QueryRow(`
-		SELECT COALESCE(MAX(chunk_order), -1) + 1
-		FROM image_chunks
-		WHERE image_id = ?
-	`, imageID).Scan(&order)
+	counts, err := refcount.Adjust(tx, map[string]int64{chunkHash: 1})
 	if err != nil {
 		return err
 	}
 
+	var stored, chunks int64
+	if newChunk {
+		stored, chunks = chunkSize, 1
+	}
+	if err := addTierStats(tx, chunkTier, chunks, stored, chunkSize); err != nil {
+		return err
+	}
+
+	// chunk_count 即已记录的次数,也就是下一个 chunk_order
+	var order int64
+	err = tx.QueryRow(`SELECT chunk_count FROM images WHERE image_id = ?`, imageID).Scan(&order)
+	newImage := err == sql.ErrNoRows
+	if err != nil && !newImage {
+		return err
+	}
+
+	var inImage int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM (SELECT 1 FROM image_chunks WHERE image_id = ? AND chunk_hash = ? LIMIT 1)`,
+		imageID, chunkHash).Scan(&inImage); err != nil {
+		return err
+	}
+
 	_, err = tx.Exec(`
 		INSERT OR IGNORE INTO image_chunks (image_id, chunk_hash, chunk_order)
 		VALUES (?, ?, ?)
@@ -106,50 +261,150 @@ func (c *ChunkIndexer) RecordChunk(imageID, chunkHash string, size int64) error
 		return err
 	}
 
+	var unique, dedupe, exclusive int64
+	if inImage == 0 {
+		unique, dedupe = 1, chunkSize
+		var imageRefs int64
+		if err := tx.QueryRow(`UPDATE chunks SET image_refs = image_refs + 1 WHERE hash = ? RETURNING image_refs`, chunkHash).Scan(&imageRefs); err != nil {
+			return err
+		}
+		switch imageRefs {
+		case 1:
+			exclusive = chunkSize
+		case 2:
+			// chunk 开始被共享,原来独占它的镜像失去这部分独占大小
+			if err := shiftExclusive(tx, chunkHash, imageID, -chunkSize); err != nil {
+				return err
+			}
+		}
+	}
+
 	_, err = tx.Exec(`
-		INSERT INTO images (image_id, total_size, chunk_count)
-		VALUES (?, ?, 1)
+		INSERT INTO images (image_id, total_size, chunk_count, unique_chunks, dedupe_size, exclusive_size)
+		VALUES (?, ?, 1, ?, ?, ?)
 		ON CONFLICT(image_id) DO UPDATE SET
-			total_size = total_size + ?,
-			chunk_count = chunk_count + 1
+			total_size = total_size + excluded.total_size,
+			chunk_count = chunk_count + 1,
+			unique_chunks = unique_chunks + excluded.unique_chunks,
+			dedupe_size = dedupe_size + excluded.dedupe_size,
+			exclusive_size = exclusive_size + excluded.exclusive_size
+	`, imageID, size, unique, dedupe, exclusive)
+	if err != nil {
+		return err
+	}
+
+	if newImage {
+		if err := addCounter(tx, "images", 1); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	meta.RefCount = counts[chunkHash]
+	c.meta.Put(chunkHash, meta)
+	return nil
+}
+
+// recordZeroChunk 记录镜像中的全零 chunk。chunk 表中没有 ZeroChunkHash,
+// 只在 image_chunks 中记录其顺序,并更新镜像和 ChunkTierZero 分层的计数器
+func (c *ChunkIndexer) recordZeroChunk(imageID string, size int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// chunk_count 即下一个 chunk_order,全零 chunk 同样记入 image_chunks 以保留镜像完整的 chunk 顺序
+	var order int64
+	err = tx.QueryRow(`SELECT chunk_count FROM images WHERE image_id = ?`, imageID).Scan(&order)
+	newImage := err == sql.ErrNoRows
+	if err != nil && !newImage {
+		return err
+	}
+	_, err = tx.Exec(`INSERT OR IGNORE INTO image_chunks (image_id, chunk_hash, chunk_order) VALUES (?, ?, ?)`,
+		imageID, ZeroChunkHash, order)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO images (image_id, total_size, chunk_count, zero_size)
+		VALUES (?, ?, 1, ?)
+		ON CONFLICT(image_id) DO UPDATE SET
+			total_size = total_size + excluded.total_size,
+			chunk_count = chunk_count + 1,
+			zero_size = zero_size + excluded.zero_size
 	`, imageID, size, size)
 	if err != nil {
 		return err
 	}
 
+	if err := addTierStats(tx, ChunkTierZero, 0, 0, size); err != nil {
+		return err
+	}
+	if newImage {
+		if err := addCounter(tx, "images", 1); err != nil {
+			return err
+		}
+	}
+
 	return tx.Commit()
 }
 
+// shiftExclusive 调整除 excludeImage 外唯一引用该 chunk 的镜像的独占大小
+func shiftExclusive(tx *sql.Tx, chunkHash, excludeImage string, delta int64) error {
+	var owner string
+	err := tx.QueryRow(`SELECT image_id FROM image_chunks WHERE chunk_hash = ? AND image_id != ? LIMIT 1`,
+		chunkHash, excludeImage).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE images SET exclusive_size = exclusive_size + ? WHERE image_id = ?`, delta, owner)
+	return err
+}
+
+func addTierStats(tx *sql.Tx, tier string, chunks, stored, logical int64) error {
+	_, err := tx.Exec(`
+		INSERT INTO tier_stats (tier, chunks, stored_size, logical_size)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(tier) DO UPDATE SET
+			chunks = chunks + excluded.chunks,
+			stored_size = stored_size + excluded.stored_size,
+			logical_size = logical_size + excluded.logical_size
+	`, tier, chunks, stored, logical)
+	return err
+}
+
+func addCounter(tx *sql.Tx, name string, delta int64) error {
+	_, err := tx.Exec(`
+		INSERT INTO counters (name, value) VALUES (?, ?)
+		ON CONFLICT(name) DO UPDATE SET value = value + excluded.value
+	`, name, delta)
+	return err
+}
+
+// GetImageStats 直接读取镜像的计数器,未记录过的镜像返回空统计
 func (c *ChunkIndexer) GetImageStats(imageID string) (*ChunkStats, error) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	var stats ChunkStats
-
 	err := c.db.QueryRow(`
-		SELECT
-			COUNT(*) as total_chunks,
-			COUNT(DISTINCT chunk_hash) as unique_chunks,
-			SUM(c.size) as total_size
-		FROM image_chunks ic
-		JOIN chunks c ON ic.chunk_hash = c.hash
-		WHERE ic.image_id = ?
-	`, imageID).Scan(&stats.TotalChunks, &stats.UniqueChunks, &stats.TotalSize)
-
-	if err != nil {
-		return nil, err
+		SELECT chunk_count, unique_chunks, total_size, dedupe_size, exclusive_size, zero_size
+		FROM images
+		WHERE image_id = ?
+	`, imageID).Scan(&stats.TotalChunks, &stats.UniqueChunks, &stats.TotalSize, &stats.DedupeSize, &stats.ExclusiveSize, &stats.ZeroSize)
+	if err == sql.ErrNoRows {
+		return &stats, nil
 	}
-
-	err = c.db.QueryRow(`
-		SELECT SUM(c.size)
-		FROM (
-			SELECT DISTINCT chunk_hash
-			FROM image_chunks
-			WHERE image_id = ?
-		) ic
-		JOIN chunks c ON ic.chunk_hash = c.hash
-	`, imageID).Scan(&stats.DedupeSize)
-
 	if err != nil {
 		return nil, err
 	}
@@ -165,21 +420,56 @@ func (c *ChunkIndexer) GetChunk(chunkHash string) (*ChunkInfo, error) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
+	if meta, ok := c.meta.Get(chunkHash); ok {
+		return &ChunkInfo{Hash: chunkHash, Size: meta.Size}, nil
+	}
+
 	var chunk ChunkInfo
-	var refCount int64
+	var meta chunkcache.ChunkMeta
 	err := c.db.QueryRow(`
-		SELECT hash, size, ref_count
+		SELECT hash, size, tier, ref_count
 		FROM chunks
 		WHERE hash = ?
-	`, chunkHash).Scan(&chunk.Hash, &chunk.Size, &refCount)
+	`, chunkHash).Scan(&chunk.Hash, &meta.Size, &meta.Tier, &meta.RefCount)
 
 	if err != nil {
 		return nil, err
 	}
 
+	chunk.Size = meta.Size
+	c.meta.Put(chunkHash, meta)
 	return &chunk, nil
 }
 
+// GetRefCounts 批量返回 chunk 在所有镜像中出现的次数,缓存未命中的 chunk 合并查询,未索引的 chunk 不在结果中
+func (c *ChunkIndexer) GetRefCounts(hashes []string) (map[string]int64, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	counts := make(map[string]int64, len(hashes))
+	var misses []string
+	for _, hash := range hashes {
+		if meta, ok := c.meta.Get(hash); ok {
+			counts[hash] = meta.RefCount
+		} else {
+			misses = append(misses, hash)
+		}
+	}
+	if len(misses) == 0 {
+		return counts, nil
+	}
+
+	found, err := refcount.Get(c.db, misses)
+	if err != nil {
+		return nil, err
+	}
+	for hash, count := range found {
+		counts[hash] = count
+	}
+	return counts, nil
+}
+
+// GetImageChunks 按构建顺序返回镜像引用的 chunk 哈希,全零 chunk 为 ZeroChunkHash
 func (c *ChunkIndexer) GetImageChunks(imageID string) ([]string, error) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -207,6 +497,45 @@ func (c *ChunkIndexer) GetImageChunks(imageID string) ([]string, error) {
 	return chunks, rows.Err()
 }
 
+// ImageChunk 是镜像 chunk 映射中的一项,Order 为 chunk 在镜像构建时的顺序
+type ImageChunk struct {
+	Order    int64  `json:"order"`
+	Hash     string `json:"hash"`
+	Size     int64  `json:"size"`
+	Tier     string `json:"tier"`
+	RefCount int64  `json:"ref_count"`
+}
+
+// ImageChunkMap 按构建顺序返回镜像引用的 chunk 及其大小、分层和引用计数,全零 chunk 不在其中
+func (c *ChunkIndexer) ImageChunkMap(imageID string) ([]ImageChunk, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	rows, err := c.db.Query(`
+		SELECT ic.chunk_order, ic.chunk_hash, c.size, c.tier, c.ref_count
+		FROM image_chunks ic
+		JOIN chunks c ON c.hash = ic.chunk_hash
+		WHERE ic.image_id = ?
+		ORDER BY ic.chunk_order
+	`, imageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []ImageChunk
+	for rows.Next() {
+		var chunk ImageChunk
+		if err := rows.Scan(&chunk.Order, &chunk.Hash, &chunk.Size, &chunk.Tier, &chunk.RefCount); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks, rows.Err()
+}
+
+// RemoveImage 删除镜像的 chunk 引用,代价与该镜像的 chunk 数成正比,计数器在同一事务中更新
 func (c *ChunkIndexer) RemoveImage(imageID string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -217,35 +546,103 @@ func (c *ChunkIndexer) RemoveImage(imageID string) error {
 	}
 	defer tx.Rollback()
 
-	_, err = tx.Exec(`
-		UPDATE chunks
-		SET ref_count = ref_count - 1
-		WHERE hash IN (
-			SELECT chunk_hash FROM image_chunks WHERE image_id = ?
-		)
+	rows, err := tx.Query(`
+		SELECT c.hash, c.size, c.tier, c.image_refs, ic.occurrences
+		FROM (SELECT chunk_hash, COUNT(*) AS occurrences FROM image_chunks WHERE image_id = ? GROUP BY chunk_hash) ic
+		JOIN chunks c ON ic.chunk_hash = c.hash
 	`, imageID)
 	if err != nil {
 		return err
 	}
+	type chunkRef struct {
+		hash, tier                   string
+		size, imageRefs, occurrences int64
+	}
+	var refs []chunkRef
+	deltas := make(map[string]int64)
+	for rows.Next() {
+		var r chunkRef
+		if err := rows.Scan(&r.hash, &r.size, &r.tier, &r.imageRefs, &r.occurrences); err != nil {
+			rows.Close()
+			return err
+		}
+		refs = append(refs, r)
+		deltas[r.hash] = -r.occurrences
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
 
-	_, err = tx.Exec(`DELETE FROM chunks WHERE ref_count <= 0`)
-	if err != nil {
+	if _, err := tx.Exec(`DELETE FROM image_chunks WHERE image_id = ?`, imageID); err != nil {
 		return err
 	}
 
-	_, err = tx.Exec(`DELETE FROM image_chunks WHERE image_id = ?`, imageID)
+	// 释放镜像中每次出现的引用,归零的 chunk 被删除
+	counts, err := refcount.Adjust(tx, deltas)
 	if err != nil {
 		return err
 	}
+	for _, r := range refs {
+		if counts[r.hash] == 0 {
+			if _, err := tx.Exec(`DELETE FROM chunks WHERE hash = ?`, r.hash); err != nil {
+				return err
+			}
+			if err := addTierStats(tx, r.tier, -1, -r.size, -r.size*r.occurrences); err != nil {
+				return err
+			}
+			continue
+		}
 
-	_, err = tx.Exec(`DELETE FROM images WHERE image_id = ?`, imageID)
+		if _, err := tx.Exec(`UPDATE chunks SET image_refs = image_refs - 1 WHERE hash = ?`, r.hash); err != nil {
+			return err
+		}
+		if err := addTierStats(tx, r.tier, 0, 0, -r.size*r.occurrences); err != nil {
+			return err
+		}
+		if r.imageRefs == 2 {
+			// 只剩一个镜像引用,该 chunk 变为其独占
+			if err := shiftExclusive(tx, r.hash, imageID, r.size); err != nil {
+				return err
+			}
+		}
+	}
+
+	var zeroSize int64
+	err = tx.QueryRow(`SELECT zero_size FROM images WHERE image_id = ?`, imageID).Scan(&zeroSize)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if zeroSize > 0 {
+		if err := addTierStats(tx, ChunkTierZero, 0, 0, -zeroSize); err != nil {
+			return err
+		}
+	}
+
+	result, err := tx.Exec(`DELETE FROM images WHERE image_id = ?`, imageID)
 	if err != nil {
 		return err
 	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		if err := addCounter(tx, "images", -n); err != nil {
+			return err
+		}
+	}
 
-	return tx.Commit()
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, r := range refs {
+		if counts[r.hash] == 0 {
+			c.meta.Remove(r.hash)
+		} else {
+			c.meta.Put(r.hash, chunkcache.ChunkMeta{Size: r.size, Tier: r.tier, RefCount: counts[r.hash]})
+		}
+	}
+	return nil
 }
 
+// GetGlobalStats 汇总各分层的计数器,不扫描 chunk 表
 func (c *ChunkIndexer) GetGlobalStats() (*GlobalStats, error) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -253,10 +650,10 @@ func (c *ChunkIndexer) GetGlobalStats() (*GlobalStats, error) {
 	var stats GlobalStats
 	err := c.db.QueryRow(`
 		SELECT
-			COUNT(*) as total_chunks,
-			SUM(size) as total_size,
-			SUM(size * ref_count) as logical_size
-		FROM chunks
+			COALESCE(SUM(chunks), 0),
+			COALESCE(SUM(stored_size), 0),
+			COALESCE(SUM(logical_size), 0)
+		FROM tier_stats
 	`).Scan(&stats.TotalChunks, &stats.TotalSize, &stats.LogicalSize)
 
 	if err != nil {
@@ -267,7 +664,12 @@ func (c *ChunkIndexer) GetGlobalStats() (*GlobalStats, error) {
 		stats.DedupRatio = float64(stats.LogicalSize-stats.TotalSize) / float64(stats.LogicalSize) * 100
 	}
 
-	err = c.db.QueryRow(`SELECT COUNT(*) FROM images`).Scan(&stats.ImageCount)
+	err = c.db.QueryRow(`SELECT COALESCE(MAX(value), 0) FROM counters WHERE name = 'images'`).Scan(&stats.ImageCount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.db.QueryRow(`SELECT COALESCE(MAX(logical_size), 0) FROM tier_stats WHERE tier = ?`, ChunkTierZero).Scan(&stats.ZeroSize)
 	if err != nil {
 		return nil, err
 	}
@@ -275,6 +677,44 @@ func (c *ChunkIndexer) GetGlobalStats() (*GlobalStats, error) {
 	return &stats, nil
 }
 
+// TierStats 描述一个 chunk 分层的去重效果,LogicalSize 为去重前的引用总量
+type TierStats struct {
+	Tier        string  `json:"tier"`
+	Chunks      int64   `json:"chunks"`
+	StoredSize  int64   `json:"stored_bytes"`
+	LogicalSize int64   `json:"logical_bytes"`
+	DedupRatio  float64 `json:"dedup_ratio"`
+}
+
+func (c *ChunkIndexer) GetTierStats() ([]TierStats, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	rows, err := c.db.Query(`
+		SELECT tier, chunks, stored_size, logical_size
+		FROM tier_stats
+		WHERE chunks > 0 OR logical_size > 0
+		ORDER BY tier
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []TierStats
+	for rows.Next() {
+		var s TierStats
+		if err := rows.Scan(&s.Tier, &s.Chunks, &s.StoredSize, &s.LogicalSize); err != nil {
+			return nil, err
+		}
+		if s.LogicalSize > 0 {
+			s.DedupRatio = float64(s.LogicalSize-s.StoredSize) / float64(s.LogicalSize) * 100
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
 func (c *ChunkIndexer) Close() error {
 	return c.db.Close()
 }
@@ -283,6 +723,8 @@ type GlobalStats struct {
 	TotalChunks int64
 	TotalSize   int64
 	LogicalSize int64
-	DedupRatio  float64
-	ImageCount  int64
+	// ZeroSize 是省略存储的全零 chunk 总大小,已计入 LogicalSize
+	ZeroSize   int64
+	DedupRatio float64
+	ImageCount int64
 }
@@ -0,0 +1,338 @@
+package erofs
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/chunkcache"
+)
+
+// TestImageStatsExclusiveSize 验证独占大小只统计未被其他镜像共享的 chunk
+func TestImageStatsExclusiveSize(t *testing.T) {
+	indexer, err := NewChunkIndexer(filepath.Join(t.TempDir(), "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer indexer.Close()
+
+	records := []struct {
+		image, hash string
+		size        int64
+	}{
+		{"a", "shared", 100},
+		{"a", "only-a", 30},
+		{"a", "only-a", 30},
+		{"b", "shared", 100},
+		{"b", "only-b", 7},
+	}
+	for _, r := range records {
+		if err := indexer.RecordChunk(r.image, r.hash, r.size); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := indexer.GetImageStats("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.TotalChunks != 3 || stats.ExclusiveSize != 30 {
+		t.Errorf("unexpected stats for a: %+v", stats)
+	}
+	t.Logf("✓ 镜像 a 共 %d 个 chunk,独占 %d 字节", stats.TotalChunks, stats.ExclusiveSize)
+
+	stats, err = indexer.GetImageStats("missing")
+	if err != nil {
+		t.Fatalf("expected empty stats for unknown image: %v", err)
+	}
+	if stats.TotalChunks != 0 || stats.ExclusiveSize != 0 {
+		t.Errorf("unexpected stats for unknown image: %+v", stats)
+	}
+	t.Logf("✓ 无 chunk 的镜像返回空统计")
+}
+
+// TestStatsCountersAfterRemove 验证删除镜像后计数器与回填结果一致,独占大小随共享关系变化
+func TestStatsCountersAfterRemove(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.db")
+	indexer, err := NewChunkIndexer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := []struct {
+		image, hash, tier string
+		size              int64
+	}{
+		{"a", "shared", ChunkTierLarge, 100},
+		{"a", "only-a", ChunkTierSmall, 30},
+		{"b", "shared", ChunkTierLarge, 100},
+		{"b", "only-b", ChunkTierLarge, 7},
+		{"c", "shared", ChunkTierLarge, 100},
+	}
+	for _, r := range records {
+		if err := indexer.RecordChunkTier(r.image, r.hash, r.size, r.tier); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := indexer.RemoveImage("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := indexer.RemoveImage("c"); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := indexer.GetImageStats("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.ExclusiveSize != 130 || stats.UniqueChunks != 2 {
+		t.Errorf("expected a to own all its chunks after b and c are removed: %+v", stats)
+	}
+	global, err := indexer.GetGlobalStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if global.TotalChunks != 2 || global.TotalSize != 130 || global.LogicalSize != 130 || global.ImageCount != 1 {
+		t.Errorf("unexpected global stats: %+v", global)
+	}
+
+	// 清空版本号后重新打开会用全表聚合回填,结果应与增量计数一致
+	if _, err := indexer.db.Exec(`PRAGMA user_version = 0`); err != nil {
+		t.Fatal(err)
+	}
+	indexer.Close()
+	indexer, err = NewChunkIndexer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer indexer.Close()
+
+	rebuilt, err := indexer.GetGlobalStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *rebuilt != *global {
+		t.Errorf("backfilled stats %+v differ from incremental %+v", rebuilt, global)
+	}
+	t.Logf("✓ 增量计数与回填结果一致: %+v", rebuilt)
+}
+
+// TestGroupUsage 验证共享基础层的镜像只把组内独有的 chunk 计为可释放空间
+func TestGroupUsage(t *testing.T) {
+	indexer, err := NewChunkIndexer(filepath.Join(t.TempDir(), "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer indexer.Close()
+
+	records := []struct {
+		image, hash string
+		size        int64
+	}{
+		{"base", "os", 100},
+		{"app1", "lib", 40},
+		{"app1", "bin1", 10},
+		{"app2", "lib", 40},
+		{"app2", "bin2", 5},
+		{"other", "bin2", 5},
+	}
+	for _, r := range records {
+		if err := indexer.RecordChunk(r.image, r.hash, r.size); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	usage, err := indexer.GroupUsage([][]string{
+		{"base", "app1"},
+		{"base", "app2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// app1: base 被两组共享,lib 也被 app2 引用,只有 bin1 可释放
+	if u := usage[0]; u.Chunks != 3 || u.TotalSize != 150 || u.ExclusiveSize != 10 || u.SharedSize != 140 {
+		t.Errorf("unexpected usage for app1: %+v", u)
+	}
+	// app2: bin2 还被组外的 other 引用
+	if u := usage[1]; u.TotalSize != 145 || u.ExclusiveSize != 0 {
+		t.Errorf("unexpected usage for app2: %+v", u)
+	}
+
+	// 整组删除时 base 和 lib 一并释放,bin2 仍被 other 引用
+	union, err := indexer.GroupUsage([][]string{{"base", "app1", "app2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := union[0]; u.Chunks != 4 || u.ExclusiveSize != 150 || u.SharedSize != 5 {
+		t.Errorf("unexpected usage for union: %+v", u)
+	}
+	t.Logf("✓ 分组空间 %+v, 整组 %+v", usage, union)
+}
+
+// TestChunkMetaCache 验证元数据缓存命中时跳过查询,且引用计数在记录和删除镜像后与数据库一致
+func TestChunkMetaCache(t *testing.T) {
+	indexer, err := NewChunkIndexer(filepath.Join(t.TempDir(), "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer indexer.Close()
+	meta := chunkcache.NewMetaCache(2)
+	indexer.SetMetaCache(meta)
+
+	records := []struct {
+		image, hash, tier string
+		size              int64
+	}{
+		{"a", "x", ChunkTierLarge, 100},
+		{"a", "y", ChunkTierSmall, 10},
+		{"b", "x", ChunkTierSmall, 999},
+		{"b", "z", ChunkTierLarge, 50},
+		{"c", "x", ChunkTierLarge, 100},
+	}
+	for _, r := range records {
+		if err := indexer.RecordChunkTier(r.image, r.hash, r.size, r.tier); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 缓存命中时 chunk 的大小和分层仍以首次记录为准
+	chunk, err := indexer.GetChunk("x")
+	if err != nil || chunk.Size != 100 {
+		t.Fatalf("unexpected chunk x: %+v %v", chunk, err)
+	}
+	if stats := meta.Stats(); stats.Hits < 2 || stats.Entries != 2 || stats.Evictions != 1 {
+		t.Errorf("unexpected cache stats: %+v", stats)
+	}
+	t.Logf("✓ 重复的 chunk 从缓存读取元数据: %+v", meta.Stats())
+
+	checkRefs := func(hashes ...string) {
+		t.Helper()
+		for _, hash := range hashes {
+			var want int64
+			err := indexer.db.QueryRow(`SELECT ref_count FROM chunks WHERE hash = ?`, hash).Scan(&want)
+			got, ok := meta.Get(hash)
+			if err != nil && ok {
+				t.Errorf("chunk %s removed from the index is still cached", hash)
+			}
+			if err == nil && ok && got.RefCount != want {
+				t.Errorf("cached ref count of %s is %d, database has %d", hash, got.RefCount, want)
+			}
+		}
+	}
+	checkRefs("x", "y", "z")
+	if err := indexer.RemoveImage("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := indexer.RemoveImage("b"); err != nil {
+		t.Fatal(err)
+	}
+	checkRefs("x", "y", "z")
+	if _, ok := meta.Get("z"); ok {
+		t.Error("expected chunk z to be dropped from the cache when its last reference is removed")
+	}
+	global, err := indexer.GetGlobalStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if global.TotalChunks != 1 || global.TotalSize != 100 {
+		t.Errorf("unexpected global stats: %+v", global)
+	}
+	t.Logf("✓ 删除镜像后缓存的引用计数与数据库一致")
+}
+
+// BenchmarkChunkLookup 对比有无元数据缓存时反复查找同一批 chunk 的耗时
+func BenchmarkChunkLookup(b *testing.B) {
+	for _, entries := range []int{0, 4096} {
+		b.Run(fmt.Sprintf("cache=%d", entries), func(b *testing.B) {
+			indexer, err := NewChunkIndexer(filepath.Join(b.TempDir(), "index.db"))
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer indexer.Close()
+			indexer.SetMetaCache(chunkcache.NewMetaCache(entries))
+			for i := 0; i < 256; i++ {
+				if err := indexer.RecordChunk("image", fmt.Sprintf("chunk-%d", i), 4096); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := indexer.GetChunk(fmt.Sprintf("chunk-%d", i%256)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// TestRemoveImageRefCounts 验证删除镜像时释放 chunk 在该镜像中的每次出现,计数与重新回填的结果一致
+func TestRemoveImageRefCounts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.db")
+	indexer, err := NewChunkIndexer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range []struct{ image, hash string }{
+		{"a", "x"}, {"a", "x"}, {"a", "x"}, {"a", "y"},
+		{"b", "x"}, {"b", "z"}, {"b", "z"},
+	} {
+		if err := indexer.RecordChunk(r.image, r.hash, 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	counts, err := indexer.GetRefCounts([]string{"x", "y", "z", "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 3 || counts["x"] != 4 || counts["y"] != 1 || counts["z"] != 2 {
+		t.Fatalf("unexpected ref counts %v", counts)
+	}
+
+	if err := indexer.RemoveImage("a"); err != nil {
+		t.Fatal(err)
+	}
+	counts, err = indexer.GetRefCounts([]string{"x", "y", "z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 2 || counts["x"] != 1 || counts["z"] != 2 {
+		t.Fatalf("expected every occurrence in a to be released, got %v", counts)
+	}
+	global, err := indexer.GetGlobalStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if global.TotalChunks != 2 || global.LogicalSize != 30 {
+		t.Errorf("unexpected global stats: %+v", global)
+	}
+	t.Logf("✓ 删除镜像释放每次出现的引用: %v", counts)
+
+	// 旧版只减一留下的偏大计数在回填时修正,不再被引用的 chunk 被删除
+	if _, err := indexer.db.Exec(`UPDATE chunks SET ref_count = ref_count + 2; PRAGMA user_version = 1`); err != nil {
+		t.Fatal(err)
+	}
+	indexer.Close()
+	indexer, err = NewChunkIndexer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer indexer.Close()
+	counts, err = indexer.GetRefCounts([]string{"x", "z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts["x"] != 1 || counts["z"] != 2 {
+		t.Fatalf("expected backfill to recount references, got %v", counts)
+	}
+	rebuilt, err := indexer.GetGlobalStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *rebuilt != *global {
+		t.Errorf("backfilled stats %+v differ from incremental %+v", rebuilt, global)
+	}
+	t.Logf("✓ 升级时重新计数")
+}
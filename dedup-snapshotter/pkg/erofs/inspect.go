@@ -0,0 +1,211 @@
+package erofs
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"strings"
+	"time"
+)
+
+// 超级块特性位,见内核 fs/erofs/erofs_fs.h
+const (
+	erofsFeatureCompatSbChksum = 0x1
+
+	erofsFeatureIncompatZeroPadding = 0x1
+	erofsFeatureIncompatComprCfgs   = 0x2
+	// erofsFeatureIncompatKnown 是本解析器认识的不兼容特性,内核遇到其他位会拒绝挂载
+	erofsFeatureIncompatKnown = 0xFF
+)
+
+type erofsFeature struct {
+	bit  uint32
+	name string
+}
+
+var erofsCompatFeatures = []erofsFeature{
+	{0x1, "sb_csum"},
+	{0x2, "mtime"},
+	{0x4, "xattr_filter"},
+}
+
+var erofsIncompatFeatures = []erofsFeature{
+	{0x1, "zero_padding"},
+	{0x2, "compr_cfgs"},
+	{0x4, "chunked_file"},
+	{0x8, "device_table"},
+	{0x10, "ztailpacking"},
+	{0x20, "fragments"},
+	{0x40, "xattr_prefixes"},
+	{0x80, "48bit"},
+}
+
+// erofsComprAlgorithms 按 available_compr_algs 的位序排列
+var erofsComprAlgorithms = []string{"lz4", "lzma", "deflate", "zstd"}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ImageInfo 是不挂载镜像、直接解析超级块得到的元数据
+type ImageInfo struct {
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"`
+	BlockSize  int64     `json:"block_size"`
+	Blocks     int64     `json:"blocks"`
+	Inodes     uint64    `json:"inodes"`
+	RootNid    uint64    `json:"root_nid"`
+	BuildTime  time.Time `json:"build_time"`
+	UUID       string    `json:"uuid"`
+	VolumeName string    `json:"volume_name,omitempty"`
+	Checksum   uint32    `json:"checksum"`
+	// Features 是超级块中置位的兼容和不兼容特性,不认识的位以十六进制列出
+	Features []string `json:"features"`
+	// Compression 是镜像可能使用的压缩算法,为空表示未压缩
+	Compression []string `json:"compression"`
+	// MetadataBytes 是超级块、inode 和目录数据所占的字节数,仅 VerifyImage 填写
+	MetadataBytes int64 `json:"metadata_bytes,omitempty"`
+
+	compat   uint32
+	incompat uint32
+}
+
+// InspectImage 读取镜像的超级块并解析元数据,只在超级块不可读或魔数、块大小非法时返回错误
+func InspectImage(path string) (*ImageInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	sb := make([]byte, erofsSuperSize)
+	if _, err := f.ReadAt(sb, erofsSuperOffset); err != nil {
+		return nil, fmt.Errorf("failed to read erofs superblock: %w", err)
+	}
+	if magic := binary.LittleEndian.Uint32(sb[0:]); magic != erofsSuperMagic {
+		return nil, fmt.Errorf("invalid erofs magic %#x", magic)
+	}
+	blkszbits := sb[12]
+	if blkszbits < 9 || blkszbits > 16 {
+		return nil, fmt.Errorf("invalid erofs block size bits %d", blkszbits)
+	}
+
+	info := &ImageInfo{
+		Path:       path,
+		Size:       st.Size(),
+		BlockSize:  int64(1) << blkszbits,
+		Blocks:     int64(binary.LittleEndian.Uint32(sb[36:])),
+		Inodes:     binary.LittleEndian.Uint64(sb[16:]),
+		RootNid:    uint64(binary.LittleEndian.Uint16(sb[14:])),
+		BuildTime:  time.Unix(int64(binary.LittleEndian.Uint64(sb[24:])), int64(binary.LittleEndian.Uint32(sb[32:]))).UTC(),
+		UUID:       formatUUID(sb[48:64]),
+		VolumeName: strings.TrimRight(string(sb[64:80]), "\x00"),
+		Checksum:   binary.LittleEndian.Uint32(sb[4:]),
+		compat:     binary.LittleEndian.Uint32(sb[8:]),
+		incompat:   binary.LittleEndian.Uint32(sb[80:]),
+	}
+	info.Features = featureNames(info.compat, erofsCompatFeatures, "compat")
+	info.Features = append(info.Features, featureNames(info.incompat, erofsIncompatFeatures, "incompat")...)
+
+	switch {
+	case info.incompat&erofsFeatureIncompatComprCfgs != 0:
+		algs := binary.LittleEndian.Uint16(sb[84:])
+		for i, name := range erofsComprAlgorithms {
+			if algs&(1<<i) != 0 {
+				algs &^= 1 << i
+				info.Compression = append(info.Compression, name)
+			}
+		}
+		if algs != 0 {
+			info.Compression = append(info.Compression, fmt.Sprintf("unknown(%#x)", algs))
+		}
+	case info.incompat&erofsFeatureIncompatZeroPadding != 0:
+		// 没有压缩配置时只可能是 lz4
+		info.Compression = []string{"lz4"}
+	}
+	return info, nil
+}
+
+// VerifyImage 在不挂载的情况下校验镜像:超级块校验和、不认识的不兼容特性、文件是否被截断,
+// 并从根目录遍历 inode 和目录块。返回的 info 在校验失败时也尽量填写
+func VerifyImage(path string) (*ImageInfo, error) {
+	info, err := InspectImage(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if unknown := info.incompat &^ erofsFeatureIncompatKnown; unknown != 0 {
+		return info, fmt.Errorf("unsupported erofs incompat features %#x", unknown)
+	}
+	if want := info.Blocks * info.BlockSize; info.Size < want {
+		return info, fmt.Errorf("image truncated: %d of %d bytes", info.Size, want)
+	}
+	if info.compat&erofsFeatureCompatSbChksum != 0 {
+		if err := verifySuperblockChecksum(path, info); err != nil {
+			return info, err
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return info, err
+	}
+	defer f.Close()
+	extents, err := readMetadataExtents(f)
+	// 超过遍历上限的大镜像不算损坏,只是不再继续检查
+	if err != nil && !errors.Is(err, errTooManyInodes) {
+		return info, fmt.Errorf("invalid erofs metadata: %w", err)
+	}
+	for _, ext := range extents {
+		info.MetadataBytes += ext.Length
+	}
+	return info, nil
+}
+
+// verifySuperblockChecksum 按内核的方式计算校验和:超级块所在块从超级块起始到块尾,校验和字段置零后求 crc32c
+func verifySuperblockChecksum(path string, info *ImageInfo) error {
+	if info.BlockSize <= erofsSuperOffset {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	buf := make([]byte, info.BlockSize-erofsSuperOffset)
+	if _, err := f.ReadAt(buf, erofsSuperOffset); err != nil {
+		return fmt.Errorf("failed to read erofs superblock: %w", err)
+	}
+	binary.LittleEndian.PutUint32(buf[4:], 0)
+	// 内核的 crc32c 以 ~0 为初值且不做最终取反
+	if sum := ^crc32.Checksum(buf, castagnoli); sum != info.Checksum {
+		return fmt.Errorf("superblock checksum mismatch: expected %#08x, got %#08x", info.Checksum, sum)
+	}
+	return nil
+}
+
+func featureNames(bits uint32, known []erofsFeature, kind string) []string {
+	var names []string
+	for _, f := range known {
+		if bits&f.bit != 0 {
+			bits &^= f.bit
+			names = append(names, f.name)
+		}
+	}
+	if bits != 0 {
+		names = append(names, fmt.Sprintf("%s(%#x)", kind, bits))
+	}
+	return names
+}
+
+func formatUUID(b []byte) string {
+	s := hex.EncodeToString(b)
+	return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:32]
+}
@@ -0,0 +1,81 @@
+package erofs
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeChecksummedImage 写入只有根目录的镜像,超级块带 sb_csum 特性和校验和
+func writeChecksummedImage(t *testing.T, path string, incompat uint32, algs uint16) []byte {
+	img := make([]byte, 3*BlockSize)
+	sb := img[erofsSuperOffset:]
+	binary.LittleEndian.PutUint32(sb[0:], erofsSuperMagic)
+	binary.LittleEndian.PutUint32(sb[8:], erofsFeatureCompatSbChksum)
+	sb[12] = 12
+	binary.LittleEndian.PutUint64(sb[16:], 1)
+	binary.LittleEndian.PutUint32(sb[36:], 3)
+	binary.LittleEndian.PutUint32(sb[40:], 1)
+	copy(sb[64:], "rootfs")
+	binary.LittleEndian.PutUint32(sb[80:], incompat)
+	binary.LittleEndian.PutUint16(sb[84:], algs)
+
+	rootSize := putTestDirents(img[2*BlockSize:], []testDirent{{0, "."}, {0, ".."}})
+	putTestInode(img, 0, 0o40755, erofsLayoutFlatPlain, uint32(rootSize), 2)
+
+	sum := ^crc32.Checksum(img[erofsSuperOffset:BlockSize], crc32.MakeTable(crc32.Castagnoli))
+	binary.LittleEndian.PutUint32(sb[4:], sum)
+	if err := os.WriteFile(path, img, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+// TestVerifyImage 验证不挂载时解析出的元数据和压缩方式,以及校验和不符、未知特性和截断的镜像被拒绝
+func TestVerifyImage(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "good"+ErofsImageExt)
+	img := writeChecksummedImage(t, path, erofsFeatureIncompatZeroPadding|erofsFeatureIncompatComprCfgs, 0x9)
+
+	info, err := VerifyImage(path)
+	if err != nil {
+		t.Fatalf("expected a valid image, got %v", err)
+	}
+	if info.BlockSize != BlockSize || info.Blocks != 3 || info.Inodes != 1 || info.VolumeName != "rootfs" || info.MetadataBytes == 0 {
+		t.Fatalf("unexpected image info %+v", info)
+	}
+	if !reflect.DeepEqual(info.Compression, []string{"lz4", "zstd"}) {
+		t.Fatalf("expected lz4 and zstd compression, got %v", info.Compression)
+	}
+	if !reflect.DeepEqual(info.Features, []string{"sb_csum", "zero_padding", "compr_cfgs"}) {
+		t.Fatalf("unexpected features %v", info.Features)
+	}
+	t.Logf("✓ 解析出 %d 块的镜像,压缩 %v,特性 %v", info.Blocks, info.Compression, info.Features)
+
+	// 超级块所在块中的任何改动都会使校验和不符
+	img[BlockSize-1] = 1
+	corrupt := filepath.Join(dir, "corrupt"+ErofsImageExt)
+	os.WriteFile(corrupt, img, 0644)
+	if _, err := VerifyImage(corrupt); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+
+	unknown := filepath.Join(dir, "unknown"+ErofsImageExt)
+	writeChecksummedImage(t, unknown, 0x100, 0)
+	if _, err := VerifyImage(unknown); err == nil || !strings.Contains(err.Error(), "unsupported") {
+		t.Fatalf("expected unknown incompat features to be rejected, got %v", err)
+	}
+
+	truncated := filepath.Join(dir, "truncated"+ErofsImageExt)
+	writeChecksummedImage(t, truncated, erofsFeatureIncompatZeroPadding, 0)
+	os.Truncate(truncated, 2*BlockSize)
+	info, err = VerifyImage(truncated)
+	if err == nil || info == nil || !reflect.DeepEqual(info.Compression, []string{"lz4"}) {
+		t.Fatalf("expected a truncated lz4 image to fail verification, got %+v, %v", info, err)
+	}
+	t.Logf("✓ 校验和不符、未知特性和截断的镜像未通过校验")
+}
@@ -0,0 +1,274 @@
+package erofs
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/containerd/log"
+)
+
+// 构建日志:每个进行中的构建在 root/build-journal 下有一条记录,列出它的暂存目录和临时镜像文件。
+// 镜像先写入临时文件,fsync 后原子重命名为正式文件名,因此正式文件存在即表示构建完整;
+// 进程在构建中途退出时,启动时由 RecoverBuilds 按记录清理残留并撤销已写入的 chunk 引用
+const (
+	journalDirName = "build-journal"
+	partialSuffix  = ".partial"
+)
+
+// 构建类型
+const (
+	BuildKindImage    = "build"
+	BuildKindFlatten  = "flatten"
+	BuildKindRelayout = "relayout"
+)
+
+// legacySuffixes 是引入构建日志之前遗留的临时文件后缀
+var legacySuffixes = []string{".tmp", ".relayout", ".tar"}
+
+// BuildRecord 是构建日志中的一条记录
+type BuildRecord struct {
+	ImageID string    `json:"image_id"`
+	Kind    string    `json:"kind"`
+	Staging string    `json:"staging,omitempty"`
+	Temp    string    `json:"temp"`
+	Started time.Time `json:"started"`
+	PID     int       `json:"pid"`
+}
+
+// BuildRecovery 汇总启动时的清理结果
+type BuildRecovery struct {
+	// Interrupted 是上次运行中未完成的构建
+	Interrupted []BuildRecord
+	// Invalid 是超级块缺失、被截断或未通过完整校验的镜像,已删除
+	Invalid []string
+}
+
+// buildTxn 是一次进行中的构建,finish 在未提交时清理临时文件,并删除日志记录
+type buildTxn struct {
+	record    BuildRecord
+	path      string
+	imagePath string
+	committed bool
+}
+
+func (b *Builder) journalDir() string {
+	return filepath.Join(b.root, journalDirName)
+}
+
+func (b *Builder) imagePath(imageID string) string {
+	return filepath.Join(b.root, "images", imageID+ErofsImageExt)
+}
+
+// validImageID 限定镜像 ID 的字符:镜像 ID 会拼入镜像、暂存目录和构建日志的路径,
+// 不能含路径分隔符,也不能是 . 或 ..
+var validImageID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:-]*$`)
+
+// ValidateImageID 检查镜像 ID 能否安全地用作 root 下的文件名
+func ValidateImageID(imageID string) error {
+	if !validImageID.MatchString(imageID) {
+		return fmt.Errorf("invalid image id %q", imageID)
+	}
+	return nil
+}
+
+// beginBuild 在开始写入前记录构建,staging 为空表示不使用暂存目录
+func (b *Builder) beginBuild(kind, imageID, staging string) (*buildTxn, error) {
+	if err := ValidateImageID(imageID); err != nil {
+		return nil, err
+	}
+	imagePath := b.imagePath(imageID)
+	if err := os.MkdirAll(filepath.Dir(imagePath), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(b.journalDir(), 0755); err != nil {
+		return nil, err
+	}
+
+	txn := &buildTxn{
+		record: BuildRecord{
+			ImageID: imageID,
+			Kind:    kind,
+			Staging: staging,
+			Temp:    imagePath + "." + kind + partialSuffix,
+			Started: time.Now(),
+			PID:     os.Getpid(),
+		},
+		path:      filepath.Join(b.journalDir(), imageID+"."+kind+".json"),
+		imagePath: imagePath,
+	}
+	data, err := json.Marshal(txn.record)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(txn.path, data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write build journal: %w", err)
+	}
+	os.Remove(txn.record.Temp)
+	return txn, nil
+}
+
+// commit 把临时镜像落盘后原子替换正式文件
+func (t *buildTxn) commit() error {
+	f, err := os.Open(t.record.Temp)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to sync %s: %w", t.record.Temp, err)
+	}
+	if err := os.Rename(t.record.Temp, t.imagePath); err != nil {
+		return err
+	}
+	if dir, err := os.Open(filepath.Dir(t.imagePath)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	t.committed = true
+	return nil
+}
+
+func (t *buildTxn) finish() {
+	if !t.committed {
+		cleanupBuild(t.record)
+	} else if t.record.Staging != "" {
+		os.RemoveAll(t.record.Staging)
+	}
+	os.Remove(t.path)
+}
+
+func cleanupBuild(r BuildRecord) {
+	os.Remove(r.Temp)
+	os.Remove(r.Temp + ".tar")
+	if r.Staging != "" {
+		os.RemoveAll(r.Staging)
+	}
+}
+
+// RecoverBuilds 清理上次运行中断的构建:删除其临时文件和暂存目录,撤销未完成镜像的 chunk 引用,
+// 删除无日志记录的遗留临时文件以及不完整的镜像。需在开始任何构建之前调用
+func (b *Builder) RecoverBuilds() (*BuildRecovery, error) {
+	report := &BuildRecovery{}
+
+	entries, err := os.ReadDir(b.journalDir())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read build journal: %w", err)
+	}
+	for _, e := range entries {
+		path := filepath.Join(b.journalDir(), e.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read build journal: %w", err)
+		}
+		var r BuildRecord
+		if err := json.Unmarshal(data, &r); err != nil {
+			// 记录本身写到一半,对应的临时文件由下面的扫描清理
+			log.L.WithError(err).Warnf("discarding corrupt build journal entry %s", e.Name())
+			os.Remove(path)
+			continue
+		}
+
+		cleanupBuild(r)
+		if r.Kind != BuildKindRelayout {
+			if _, err := os.Stat(b.imagePath(r.ImageID)); os.IsNotExist(err) {
+				if err := b.indexer.RemoveImage(r.ImageID); err != nil {
+					return nil, fmt.Errorf("failed to drop chunk references of %s: %w", r.ImageID, err)
+				}
+			}
+		}
+		os.Remove(path)
+		report.Interrupted = append(report.Interrupted, r)
+		log.L.Warnf("discarded interrupted %s of erofs image %s (started %s by pid %d)", r.Kind, r.ImageID, r.Started.Format(time.RFC3339), r.PID)
+	}
+
+	// 没有构建在运行,暂存目录中的内容都是残留
+	if err := os.RemoveAll(filepath.Join(b.scratchDir, "staging")); err != nil {
+		return nil, err
+	}
+
+	imagesDir := filepath.Join(b.root, "images")
+	images, err := os.ReadDir(imagesDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, e := range images {
+		name := e.Name()
+		path := filepath.Join(imagesDir, name)
+		if isPartialImage(name) {
+			os.Remove(path)
+			continue
+		}
+		if e.IsDir() || !strings.HasSuffix(name, ErofsImageExt) {
+			continue
+		}
+		check := CheckImage
+		if b.verifyImages {
+			check = func(path string) error {
+				_, err := VerifyImage(path)
+				return err
+			}
+		}
+		if err := check(path); err != nil {
+			imageID := strings.TrimSuffix(name, ErofsImageExt)
+			log.L.WithError(err).Warnf("removing incomplete erofs image %s", imageID)
+			if err := os.Remove(path); err != nil {
+				return nil, err
+			}
+			if err := b.indexer.RemoveImage(imageID); err != nil {
+				return nil, fmt.Errorf("failed to drop chunk references of %s: %w", imageID, err)
+			}
+			report.Invalid = append(report.Invalid, imageID)
+		}
+	}
+	return report, nil
+}
+
+func isPartialImage(name string) bool {
+	if strings.HasSuffix(name, partialSuffix) {
+		return true
+	}
+	for _, suffix := range legacySuffixes {
+		if strings.HasSuffix(name, ErofsImageExt+suffix) || strings.HasSuffix(name, partialSuffix+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckImage 检查镜像的超级块,并确认文件没有短于超级块记录的块数
+func CheckImage(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sb := make([]byte, erofsSuperSize)
+	if _, err := f.ReadAt(sb, erofsSuperOffset); err != nil {
+		return fmt.Errorf("failed to read erofs superblock: %w", err)
+	}
+	if magic := binary.LittleEndian.Uint32(sb[0:]); magic != erofsSuperMagic {
+		return fmt.Errorf("invalid erofs magic %#x", magic)
+	}
+	blkszbits := sb[12]
+	if blkszbits < 9 || blkszbits > 16 {
+		return fmt.Errorf("invalid erofs block size bits %d", blkszbits)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	blocks := int64(binary.LittleEndian.Uint32(sb[36:]))
+	if want := blocks << blkszbits; info.Size() < want {
+		return fmt.Errorf("image truncated: %d of %d bytes", info.Size(), want)
+	}
+	return nil
+}
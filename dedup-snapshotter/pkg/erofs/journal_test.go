@@ -0,0 +1,78 @@
+package erofs
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestImage(t *testing.T, path string, blocks uint32, size int) {
+	img := make([]byte, size)
+	binary.LittleEndian.PutUint32(img[erofsSuperOffset:], erofsSuperMagic)
+	img[erofsSuperOffset+12] = 12
+	binary.LittleEndian.PutUint32(img[erofsSuperOffset+36:], blocks)
+	if err := os.WriteFile(path, img, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestRecoverBuilds 模拟构建中途进程退出,验证重启后残留文件被清理、chunk 引用被撤销、完整镜像保留
+func TestRecoverBuilds(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "root")
+	builder, err := NewBuilder(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 中断的构建:日志记录、暂存文件、写了一半的临时镜像和已记录的 chunk 引用
+	txn, err := builder.beginBuild(BuildKindImage, "crashed", builder.stagingPath("crashed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(txn.record.Staging, 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(txn.record.Staging, "file"), []byte("data"), 0644)
+	os.WriteFile(txn.record.Temp, []byte("half written"), 0644)
+	if err := builder.indexer.RecordChunk("crashed", "chunk-a", 4); err != nil {
+		t.Fatal(err)
+	}
+	builder.Close()
+
+	imagesDir := filepath.Join(root, "images")
+	writeTestImage(t, filepath.Join(imagesDir, "good"+ErofsImageExt), 2, 2*BlockSize)
+	writeTestImage(t, filepath.Join(imagesDir, "truncated"+ErofsImageExt), 4, 2*BlockSize)
+	os.WriteFile(filepath.Join(imagesDir, "old"+ErofsImageExt+".tmp"), []byte("x"), 0644)
+
+	// 重启
+	builder, err = NewBuilder(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer builder.Close()
+	report, err := builder.RecoverBuilds()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Interrupted) != 1 || report.Interrupted[0].ImageID != "crashed" {
+		t.Errorf("unexpected interrupted builds %+v", report.Interrupted)
+	}
+	if len(report.Invalid) != 1 || report.Invalid[0] != "truncated" {
+		t.Errorf("unexpected invalid images %v", report.Invalid)
+	}
+	entries, _ := os.ReadDir(imagesDir)
+	if len(entries) != 1 || entries[0].Name() != "good"+ErofsImageExt {
+		t.Errorf("expected only the complete image to remain, got %v", entries)
+	}
+	for _, path := range []string{txn.record.Staging, txn.record.Temp, txn.path} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s survived recovery", path)
+		}
+	}
+	if chunks, _ := builder.indexer.GetImageChunks("crashed"); len(chunks) != 0 {
+		t.Errorf("chunk references of interrupted build kept: %v", chunks)
+	}
+	t.Logf("✓ 清理 %d 个中断的构建和 %d 个不完整镜像", len(report.Interrupted), len(report.Invalid))
+}
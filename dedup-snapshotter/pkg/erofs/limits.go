@@ -0,0 +1,196 @@
+package erofs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/containerd/log"
+)
+
+const (
+	// DefaultMaxLowerDirs 对应内核 OVL_MAX_STACK,层数无法探测时使用
+	DefaultMaxLowerDirs = 500
+
+	mergedMountPrefix = "merged-"
+
+	// probeLongName 和 probeShortName 是探测时 lowerdir 目录名的长度,
+	// 长目录名使参数长度先于层数达到上限,短目录名用于探测层数
+	probeLongName  = 200
+	probeShortName = 5
+	// probeMaxLayers 是探测的层数上限
+	probeMaxLayers = 1024
+)
+
+// OverlayLimits 描述单个 overlay 挂载能接受的 lowerdir 层数和挂载参数长度
+type OverlayLimits struct {
+	MaxLowerDirs   int
+	MaxOptionBytes int
+}
+
+// defaultOverlayLimits 是无法试挂载时的保守值:旧的 mount(2) 接口把挂载参数限制在一页以内,超出会返回 E2BIG/EINVAL
+func defaultOverlayLimits() OverlayLimits {
+	return OverlayLimits{
+		MaxLowerDirs:   DefaultMaxLowerDirs,
+		MaxOptionBytes: os.Getpagesize() - 1,
+	}
+}
+
+var (
+	probeOnce    sync.Once
+	probedLimits OverlayLimits
+	probeErr     error
+)
+
+// DetectOverlayLimits 试挂载只读 overlay 探测内核和 mount 命令实际接受的 lowerdir 层数和挂载参数长度,
+// 设为之后挂载的限制并返回,maxLowerDirs/maxOptionBytes 非零时覆盖探测值。
+// 探测结果在进程内缓存,无法试挂载(如没有权限)时使用默认值
+func (m *MountManager) DetectOverlayLimits(ctx context.Context, maxLowerDirs, maxOptionBytes int) OverlayLimits {
+	probeOnce.Do(func() {
+		probedLimits, probeErr = m.probeOverlayLimits(ctx)
+		if probeErr == nil {
+			log.G(ctx).Infof("overlay accepts %d lowerdirs and %d option bytes", probedLimits.MaxLowerDirs, probedLimits.MaxOptionBytes)
+		}
+	})
+
+	limits := probedLimits
+	if probeErr != nil {
+		log.G(ctx).WithError(probeErr).Warn("failed to probe overlay limits, assuming defaults")
+		limits = defaultOverlayLimits()
+	}
+	if maxLowerDirs > 0 {
+		limits.MaxLowerDirs = maxLowerDirs
+	}
+	if maxOptionBytes > 0 {
+		limits.MaxOptionBytes = maxOptionBytes
+	}
+
+	m.SetOverlayLimits(limits)
+	return limits
+}
+
+// OverlayLimits 返回当前使用的 overlay 限制
+func (m *MountManager) OverlayLimits() OverlayLimits {
+	m.mountsMu.RLock()
+	defer m.mountsMu.RUnlock()
+	return m.limits
+}
+
+// probeOverlayLimits 在 root 下创建空目录作为 lowerdir,二分查找能挂载成功的最长参数和最多层数。
+// 试挂载与实际挂载使用同一个 mount 命令和挂载命名空间。参数长度放不下更多层时层数无法观测,保留默认值
+func (m *MountManager) probeOverlayLimits(ctx context.Context) (OverlayLimits, error) {
+	dir, err := os.MkdirTemp(m.root, "overlay-probe-")
+	if err != nil {
+		return OverlayLimits{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	target := filepath.Join(dir, "mnt")
+	if err := os.Mkdir(target, 0755); err != nil {
+		return OverlayLimits{}, err
+	}
+	long, err := probeDirs(dir, "l", probeLongName, probeMaxLayers)
+	if err != nil {
+		return OverlayLimits{}, err
+	}
+	short, err := probeDirs(dir, "s", probeShortName, probeMaxLayers)
+	if err != nil {
+		return OverlayLimits{}, err
+	}
+
+	var mountErr error
+	tryMount := func(lowerDirs []string) bool {
+		output, err := m.runMount(ctx, "mount", "-t", "overlay", "-o", "ro,lowerdir="+strings.Join(lowerDirs, ":"), "overlay", target)
+		if err != nil {
+			mountErr = fmt.Errorf("%w, output: %s", err, output)
+			return false
+		}
+		if err := m.unmountPath(ctx, target); err != nil {
+			mountErr = err
+			return false
+		}
+		return true
+	}
+	if !tryMount(short[:2]) {
+		return OverlayLimits{}, fmt.Errorf("test overlay mount failed: %w", mountErr)
+	}
+
+	limits := OverlayLimits{MaxLowerDirs: DefaultMaxLowerDirs}
+	n := probeSearch(2, len(long), func(n int) bool { return tryMount(long[:n]) })
+	limits.MaxOptionBytes = len("ro,lowerdir=") + len(strings.Join(long[:n], ":"))
+	if n == len(long) || !tryMount(short[:n+1]) {
+		// 长目录名受层数而不是参数长度限制,参数长度至少为已成功的长度
+		limits.MaxLowerDirs = n
+		return limits, nil
+	}
+
+	// 短目录名下参数长度允许的最多层数
+	fit := (limits.MaxOptionBytes - len("ro,lowerdir=") + 1) / (len(short[0]) + 1)
+	hi := min(fit, len(short))
+	if tryMount(short[:hi]) {
+		if hi == len(short) || hi > DefaultMaxLowerDirs {
+			limits.MaxLowerDirs = hi
+		}
+		return limits, nil
+	}
+	limits.MaxLowerDirs = probeSearch(2, hi-1, func(n int) bool { return tryMount(short[:n]) })
+	return limits, nil
+}
+
+// probeDirs 在 dir 下创建 n 个名称长度为 nameLen 的空目录
+func probeDirs(dir, prefix string, nameLen, n int) ([]string, error) {
+	dirs := make([]string, n)
+	for i := range dirs {
+		dirs[i] = filepath.Join(dir, fmt.Sprintf("%s%0*d", prefix, nameLen-len(prefix), i))
+		if err := os.Mkdir(dirs[i], 0755); err != nil {
+			return nil, err
+		}
+	}
+	return dirs, nil
+}
+
+// probeSearch 返回 [lo, hi] 中使 ok 成立的最大值,要求 ok(lo) 成立且 ok 单调
+func probeSearch(lo, hi int, ok func(int) bool) int {
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if ok(mid) {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return lo
+}
+
+// fits 判断 lowerDirs 加上已占用的 reserved 字节后能否放入一次 overlay 挂载
+func (l OverlayLimits) fits(lowerDirs []string, reserved int) bool {
+	if len(lowerDirs) > l.MaxLowerDirs {
+		return false
+	}
+	return reserved+len("lowerdir=")+len(strings.Join(lowerDirs, ":")) <= l.MaxOptionBytes
+}
+
+// foldPoint 返回能保留为独立 lowerdir 的上层父层个数,其余底部父层合并为一个路径长度为 foldedLen 的 lowerdir。
+// 至少合并一层,连合并后的单个 lowerdir 都放不下时返回 -1
+func (l OverlayLimits) foldPoint(lowerDirs []string, reserved, foldedLen int) int {
+	size := reserved + len("lowerdir=") + foldedLen
+	if size > l.MaxOptionBytes {
+		return -1
+	}
+	keep := 0
+	for keep < len(lowerDirs)-1 && keep+1 < l.MaxLowerDirs && size+len(lowerDirs[keep])+1 <= l.MaxOptionBytes {
+		size += len(lowerDirs[keep]) + 1
+		keep++
+	}
+	return keep
+}
+
+func mergedMountID(lowerDirs []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(lowerDirs, ":")))
+	return mergedMountPrefix + hex.EncodeToString(sum[:])[:16]
+}
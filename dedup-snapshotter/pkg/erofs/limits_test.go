@@ -0,0 +1,177 @@
+package erofs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func syntheticLowerDirs(t *testing.T, base string, n int) []string {
+	dirs := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		dir := filepath.Join(base, fmt.Sprintf("layer-%04d", i))
+		writeTestFile(t, filepath.Join(dir, fmt.Sprintf("file-%04d", i)), fmt.Sprintf("%d", i))
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// TestFoldPoint 验证超出限制的父链只合并放不下的底部父层,上层尽量保留为独立的 lowerdir
+func TestFoldPoint(t *testing.T) {
+	limits := OverlayLimits{MaxLowerDirs: DefaultMaxLowerDirs, MaxOptionBytes: 4095}
+	folded := "/var/lib/containerd/io.containerd.snapshotter.v1.dedup/mounts/" + FlattenedImageID(mergedMountID(nil))
+
+	for _, n := range []int{500, 1000} {
+		dirs := make([]string, n)
+		for i := range dirs {
+			dirs[i] = fmt.Sprintf("/var/lib/containerd/io.containerd.snapshotter.v1.dedup/mounts/%d", i)
+		}
+
+		keep := limits.foldPoint(dirs, len("ro,"), len(folded))
+		if keep <= 0 || keep >= n {
+			t.Fatalf("%d layers: unexpected fold point %d", n, keep)
+		}
+		if !limits.fits(append(dirs[:keep:keep], folded), len("ro,")) {
+			t.Errorf("%d layers: %d kept layers plus the folded lower exceed limits", n, keep)
+		}
+		if limits.fits(append(dirs[:keep+1:keep+1], folded), len("ro,")) {
+			t.Errorf("%d layers: fold point %d is not maximal", n, keep)
+		}
+		t.Logf("✓ %d 层父链保留上面 %d 层,合并底部 %d 层", n, keep, n-keep)
+	}
+
+	if keep := limits.foldPoint([]string{"/a", "/b"}, 4090, len(folded)); keep != -1 {
+		t.Errorf("expected -1 when even the folded lower does not fit, got %d", keep)
+	}
+
+	// 没有扁平化实现时直接失败,不再退回到会丢失 whiteout 的中间 overlay
+	mm, err := NewMountManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mm.SetOverlayLimits(limits)
+	dirs := make([]string, 1000)
+	for i := range dirs {
+		dirs[i] = fmt.Sprintf("/var/lib/containerd/io.containerd.snapshotter.v1.dedup/mounts/%d", i)
+	}
+	snap := t.TempDir()
+	if _, err := mm.CreateOverlayMounts(context.Background(), "deep", dirs, filepath.Join(snap, "fs"), filepath.Join(snap, "work"), ""); err == nil {
+		t.Fatal("expected deep chain without flattener to fail")
+	}
+}
+
+// TestProbeOverlayLimits 试挂载探测 overlay 限制,探测到的参数长度内的挂载必须成功
+func TestProbeOverlayLimits(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("overlay mounts require root")
+	}
+	mm, err := NewMountManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits, err := mm.probeOverlayLimits(context.Background())
+	if err != nil {
+		t.Skipf("overlay not available: %v", err)
+	}
+	if limits.MaxLowerDirs < 2 || limits.MaxOptionBytes < 1024 {
+		t.Fatalf("implausible overlay limits %+v", limits)
+	}
+
+	dir := t.TempDir()
+	var lowerDirs []string
+	for i := 0; ; i++ {
+		next := filepath.Join(dir, fmt.Sprintf("%0100d", i))
+		if !limits.fits(append(lowerDirs, next), len("ro,")) {
+			break
+		}
+		if err := os.Mkdir(next, 0755); err != nil {
+			t.Fatal(err)
+		}
+		lowerDirs = append(lowerDirs, next)
+	}
+	target := filepath.Join(dir, "mnt")
+	os.Mkdir(target, 0755)
+	if out, err := exec.Command("mount", "-t", "overlay", "-o", "ro,lowerdir="+strings.Join(lowerDirs, ":"), "overlay", target).CombinedOutput(); err != nil {
+		t.Fatalf("mount of %d lowerdirs within probed limits %+v failed: %v %s", len(lowerDirs), limits, err, out)
+	}
+	exec.Command("umount", target).Run()
+	t.Logf("✓ 探测到 overlay 限制 %+v", limits)
+}
+
+// TestCreateOverlayMounts500Layers 挂载 500 层的合成父链,验证超限时把底部父层合并为扁平化镜像而不是 E2BIG,
+// 且上层的 whiteout 仍然隐藏被合并的底层文件
+func TestCreateOverlayMounts500Layers(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("overlay mounts require root")
+	}
+	if _, err := exec.LookPath("mkfs.erofs"); err != nil {
+		t.Skip("mkfs.erofs not available")
+	}
+
+	tmpDir := t.TempDir()
+	mm, err := NewMountManager(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to create mount manager: %v", err)
+	}
+	defer mm.UnmountAll()
+
+	builder, err := NewBuilder(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mm.SetLowerFlattener(builder)
+
+	lowerDirs := syntheticLowerDirs(t, filepath.Join(tmpDir, "layers"), 500)
+	// 最上层删除最底层的文件
+	if err := unix.Mknod(filepath.Join(lowerDirs[0], "file-0499"), unix.S_IFCHR, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	probe := filepath.Join(tmpDir, "probe")
+	os.MkdirAll(probe, 0755)
+	if out, err := exec.Command("mount", "-t", "overlay", "-o", "ro,lowerdir="+lowerDirs[0]+":"+lowerDirs[1], "overlay", probe).CombinedOutput(); err != nil {
+		t.Skipf("overlay not available: %v %s", err, out)
+	}
+	exec.Command("umount", probe).Run()
+
+	upperDir := filepath.Join(tmpDir, "snap", "fs")
+	workDir := filepath.Join(tmpDir, "snap", "work")
+	mounts, err := mm.CreateOverlayMounts(context.Background(), "snap-500", lowerDirs, upperDir, workDir, "")
+	if err != nil {
+		t.Fatalf("failed to create overlay mounts: %v", err)
+	}
+
+	target := filepath.Join(tmpDir, "rootfs")
+	os.MkdirAll(target, 0755)
+	opts := strings.Join(mounts[0].Options, ",")
+	if len(opts) > mm.limits.MaxOptionBytes {
+		t.Fatalf("mount options still %d bytes, limit %d", len(opts), mm.limits.MaxOptionBytes)
+	}
+	if out, err := exec.Command("mount", "-t", "overlay", "-o", opts, "overlay", target).CombinedOutput(); err != nil {
+		t.Fatalf("failed to mount 500-layer overlay: %v %s", err, out)
+	}
+	defer exec.Command("umount", target).Run()
+
+	for _, i := range []int{0, 250, 498} {
+		path := filepath.Join(target, fmt.Sprintf("file-%04d", i))
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("expected %s visible in merged rootfs: %v", path, err)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(target, "file-0499")); !os.IsNotExist(err) {
+		t.Errorf("file deleted by the top layer reappeared in merged rootfs: %v", err)
+	}
+
+	exec.Command("umount", target).Run()
+	mm.ReleaseSnapshot("snap-500")
+	if stats := mm.GetStats(); len(stats) != 0 {
+		t.Errorf("expected flattened lower released, %d remain", len(stats))
+	}
+	t.Logf("✓ 500 层父链合并底部父层后挂载成功,上层 whiteout 生效")
+}
@@ -0,0 +1,299 @@
+package erofs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// EROFS 磁盘格式常量,见内核 fs/erofs/erofs_fs.h
+const (
+	erofsSuperMagic    = 0xE0F5E1E2
+	erofsSuperOffset   = 1024
+	erofsSuperSize     = 128
+	erofsSlotSize      = 32
+	erofsDirentSize    = 12
+	erofsInodeCompact  = 32
+	erofsInodeExtended = 64
+
+	erofsLayoutFlatPlain  = 0
+	erofsLayoutFlatInline = 2
+
+	// maxMetadataInodes 防止损坏的镜像导致无限遍历
+	maxMetadataInodes = 1 << 20
+)
+
+// errTooManyInodes 表示镜像的 inode 数超过了遍历上限
+var errTooManyInodes = errors.New("too many inodes in erofs image")
+
+// Extent 是文件中的一段字节区间
+type Extent struct {
+	Offset int64
+	Length int64
+}
+
+type erofsInode struct {
+	nid      uint64
+	offset   int64
+	isDir    bool
+	layout   int
+	size     int64
+	rawBlk   uint32
+	metaSize int64
+}
+
+type metadataReader struct {
+	r           io.ReaderAt
+	blockSize   int64
+	metaBlkAddr int64
+	extents     []Extent
+}
+
+// MetadataExtents 解析 EROFS 镜像布局,返回超级块、所有可达 inode 所在块以及目录数据块的区间,
+// 按偏移排序并合并相邻区间。容器启动时的 ls/stat 只会访问这些区间。
+func MetadataExtents(imagePath string) ([]Extent, error) {
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return readMetadataExtents(f)
+}
+
+func readMetadataExtents(r io.ReaderAt) ([]Extent, error) {
+	sb := make([]byte, erofsSuperSize)
+	if _, err := r.ReadAt(sb, erofsSuperOffset); err != nil {
+		return nil, fmt.Errorf("failed to read erofs superblock: %w", err)
+	}
+	if magic := binary.LittleEndian.Uint32(sb[0:]); magic != erofsSuperMagic {
+		return nil, fmt.Errorf("invalid erofs magic %#x", magic)
+	}
+
+	blkszbits := sb[12]
+	if blkszbits < 9 || blkszbits > 16 {
+		return nil, fmt.Errorf("invalid erofs block size bits %d", blkszbits)
+	}
+
+	m := &metadataReader{
+		r:           r,
+		blockSize:   int64(1) << blkszbits,
+		metaBlkAddr: int64(binary.LittleEndian.Uint32(sb[40:])),
+	}
+	rootNid := uint64(binary.LittleEndian.Uint16(sb[14:]))
+
+	// 超级块所在的第一个块
+	m.addExtent(0, m.blockSize)
+
+	visited := map[uint64]bool{rootNid: true}
+	queue := []uint64{rootNid}
+	for len(queue) > 0 {
+		nid := queue[0]
+		queue = queue[1:]
+
+		inode, err := m.readInode(nid)
+		if err != nil {
+			return nil, err
+		}
+		m.addExtent(inode.offset, inode.metaSize)
+		if !inode.isDir {
+			continue
+		}
+
+		children, err := m.readDir(inode)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read directory nid %d: %w", nid, err)
+		}
+		for _, child := range children {
+			if visited[child] {
+				continue
+			}
+			if len(visited) >= maxMetadataInodes {
+				return nil, errTooManyInodes
+			}
+			visited[child] = true
+			queue = append(queue, child)
+		}
+	}
+
+	return mergeExtents(m.extents), nil
+}
+
+func (m *metadataReader) addExtent(offset, length int64) {
+	if length <= 0 {
+		return
+	}
+	// 按块对齐,预取以块为单位
+	start := offset / m.blockSize * m.blockSize
+	end := (offset + length + m.blockSize - 1) / m.blockSize * m.blockSize
+	m.extents = append(m.extents, Extent{Offset: start, Length: end - start})
+}
+
+func (m *metadataReader) readInode(nid uint64) (*erofsInode, error) {
+	offset := m.metaBlkAddr*m.blockSize + int64(nid)*erofsSlotSize
+
+	buf := make([]byte, erofsInodeExtended)
+	n, err := m.r.ReadAt(buf, offset)
+	if n < erofsInodeCompact {
+		return nil, fmt.Errorf("failed to read inode %d: %w", nid, err)
+	}
+
+	format := binary.LittleEndian.Uint16(buf[0:])
+	xattrCount := int64(binary.LittleEndian.Uint16(buf[2:]))
+	mode := binary.LittleEndian.Uint16(buf[4:])
+
+	inode := &erofsInode{
+		nid:    nid,
+		offset: offset,
+		isDir:  mode&0xF000 == 0x4000,
+		layout: int(format>>1) & 0x7,
+		rawBlk: binary.LittleEndian.Uint32(buf[16:]),
+	}
+
+	inodeSize := int64(erofsInodeCompact)
+	if format&1 == 1 {
+		if n < erofsInodeExtended {
+			return nil, fmt.Errorf("truncated extended inode %d", nid)
+		}
+		inodeSize = erofsInodeExtended
+		inode.size = int64(binary.LittleEndian.Uint64(buf[8:]))
+	} else {
+		inode.size = int64(binary.LittleEndian.Uint32(buf[8:]))
+	}
+
+	xattrSize := int64(0)
+	if xattrCount > 0 {
+		xattrSize = 12 + (xattrCount-1)*4
+	}
+	inode.metaSize = inodeSize + xattrSize
+
+	return inode, nil
+}
+
+// readDir 返回目录项的 nid,同时把目录数据块(含内联尾部)记录为元数据区间
+func (m *metadataReader) readDir(inode *erofsInode) ([]uint64, error) {
+	var blocks []Extent
+
+	switch inode.layout {
+	case erofsLayoutFlatPlain:
+		if inode.size > 0 {
+			blocks = append(blocks, Extent{Offset: int64(inode.rawBlk) * m.blockSize, Length: inode.size})
+		}
+	case erofsLayoutFlatInline:
+		full := inode.size / m.blockSize * m.blockSize
+		if full > 0 {
+			blocks = append(blocks, Extent{Offset: int64(inode.rawBlk) * m.blockSize, Length: full})
+		}
+		if tail := inode.size - full; tail > 0 {
+			inlineOffset := inode.offset + inode.metaSize
+			blocks = append(blocks, Extent{Offset: inlineOffset, Length: tail})
+		}
+	default:
+		// 压缩或 chunk-based 目录不解析内容,只预取 inode
+		return nil, nil
+	}
+
+	var children []uint64
+	for _, ext := range blocks {
+		m.addExtent(ext.Offset, ext.Length)
+
+		for pos := int64(0); pos < ext.Length; pos += m.blockSize {
+			size := ext.Length - pos
+			if size > m.blockSize {
+				size = m.blockSize
+			}
+			block := make([]byte, size)
+			if _, err := m.r.ReadAt(block, ext.Offset+pos); err != nil {
+				return nil, err
+			}
+			nids, err := parseDirBlock(block)
+			if err != nil {
+				return nil, err
+			}
+			children = append(children, nids...)
+		}
+	}
+
+	return children, nil
+}
+
+// parseDirBlock 解析一个目录块,第一个目录项的 nameoff 决定该块中目录项的个数
+func parseDirBlock(block []byte) ([]uint64, error) {
+	if len(block) < erofsDirentSize {
+		return nil, nil
+	}
+
+	nameoff := int(binary.LittleEndian.Uint16(block[8:]))
+	if nameoff < erofsDirentSize || nameoff > len(block) || nameoff%erofsDirentSize != 0 {
+		return nil, fmt.Errorf("invalid dirent nameoff %d", nameoff)
+	}
+
+	count := nameoff / erofsDirentSize
+	nids := make([]uint64, 0, count)
+	for i := 0; i < count; i++ {
+		de := block[i*erofsDirentSize:]
+		start := int(binary.LittleEndian.Uint16(de[8:]))
+		end := len(block)
+		if i+1 < count {
+			end = int(binary.LittleEndian.Uint16(block[(i+1)*erofsDirentSize+8:]))
+		}
+		if start > end || end > len(block) {
+			return nil, fmt.Errorf("invalid dirent name range %d-%d", start, end)
+		}
+
+		name := block[start:end]
+		for j, c := range name {
+			if c == 0 {
+				name = name[:j]
+				break
+			}
+		}
+		if string(name) == "." || string(name) == ".." {
+			continue
+		}
+		nids = append(nids, binary.LittleEndian.Uint64(de[0:]))
+	}
+
+	return nids, nil
+}
+
+func mergeExtents(extents []Extent) []Extent {
+	if len(extents) == 0 {
+		return nil
+	}
+
+	sort.Slice(extents, func(i, j int) bool {
+		return extents[i].Offset < extents[j].Offset
+	})
+
+	merged := []Extent{extents[0]}
+	for _, ext := range extents[1:] {
+		last := &merged[len(merged)-1]
+		if ext.Offset <= last.Offset+last.Length {
+			if end := ext.Offset + ext.Length; end > last.Offset+last.Length {
+				last.Length = end - last.Offset
+			}
+			continue
+		}
+		merged = append(merged, ext)
+	}
+	return merged
+}
+
+// ChunkOffsets 把区间映射到 chunkSize 对齐的 chunk 起始偏移,用于按 chunk 预取
+func ChunkOffsets(extents []Extent, chunkSize int64) []int64 {
+	var offsets []int64
+	seen := make(map[int64]bool)
+	for _, ext := range extents {
+		for off := ext.Offset / chunkSize * chunkSize; off < ext.Offset+ext.Length; off += chunkSize {
+			if !seen[off] {
+				seen[off] = true
+				offsets = append(offsets, off)
+			}
+		}
+	}
+	return offsets
+}
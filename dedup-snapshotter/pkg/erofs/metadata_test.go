@@ -0,0 +1,76 @@
+package erofs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+type testDirent struct {
+	nid  uint64
+	name string
+}
+
+func putTestInode(img []byte, nid uint64, mode uint16, layout int, size uint32, rawBlk uint32) int {
+	off := BlockSize + int(nid)*erofsSlotSize
+	binary.LittleEndian.PutUint16(img[off:], uint16(layout<<1))
+	binary.LittleEndian.PutUint16(img[off+4:], mode)
+	binary.LittleEndian.PutUint32(img[off+8:], size)
+	binary.LittleEndian.PutUint32(img[off+16:], rawBlk)
+	return off + erofsInodeCompact
+}
+
+func putTestDirents(buf []byte, entries []testDirent) int {
+	nameoff := len(entries) * erofsDirentSize
+	for i, e := range entries {
+		binary.LittleEndian.PutUint64(buf[i*erofsDirentSize:], e.nid)
+		binary.LittleEndian.PutUint16(buf[i*erofsDirentSize+8:], uint16(nameoff))
+		nameoff += copy(buf[nameoff:], e.name)
+	}
+	return nameoff
+}
+
+// TestMetadataExtents 用手工构造的 EROFS 镜像验证超级块、inode 块、普通目录块和内联目录都被识别
+func TestMetadataExtents(t *testing.T) {
+	// 块 0: 超级块; 块 1-2: inode 区(meta_blkaddr=1); 块 3: 普通数据; 块 4: 根目录块
+	img := make([]byte, 6*BlockSize)
+	sb := img[erofsSuperOffset:]
+	binary.LittleEndian.PutUint32(sb[0:], erofsSuperMagic)
+	sb[12] = 12
+	binary.LittleEndian.PutUint16(sb[14:], 0)
+	binary.LittleEndian.PutUint32(sb[40:], 1)
+
+	rootEntries := []testDirent{{0, "."}, {0, ".."}, {1, "file"}, {2, "sub"}}
+	rootSize := putTestDirents(img[4*BlockSize:], rootEntries)
+	putTestInode(img, 0, 0o40755, erofsLayoutFlatPlain, uint32(rootSize), 4)
+	putTestInode(img, 1, 0o100644, erofsLayoutFlatPlain, BlockSize, 3)
+
+	// sub 是内联目录,目录项紧跟在 inode 之后;其子项 nid 200 位于块 2
+	var inline [64]byte
+	subSize := putTestDirents(inline[:], []testDirent{{2, "."}, {0, ".."}, {200, "deep"}})
+	inlineOff := putTestInode(img, 2, 0o40755, erofsLayoutFlatInline, uint32(subSize), 0)
+	copy(img[inlineOff:], inline[:subSize])
+	putTestInode(img, 200, 0o100644, erofsLayoutFlatPlain, 0, 0)
+
+	extents, err := readMetadataExtents(bytes.NewReader(img))
+	if err != nil {
+		t.Fatalf("failed to read metadata extents: %v", err)
+	}
+
+	want := []Extent{{Offset: 0, Length: 3 * BlockSize}, {Offset: 4 * BlockSize, Length: BlockSize}}
+	if !reflect.DeepEqual(extents, want) {
+		t.Fatalf("unexpected extents %v, want %v", extents, want)
+	}
+
+	offsets := ChunkOffsets(extents, BlockSize)
+	if !reflect.DeepEqual(offsets, []int64{0, BlockSize, 2 * BlockSize, 4 * BlockSize}) {
+		t.Errorf("unexpected chunk offsets %v", offsets)
+	}
+	t.Logf("✓ 元数据区间 %v, 普通数据块未被预取", extents)
+
+	binary.LittleEndian.PutUint32(img[erofsSuperOffset:], 0)
+	if _, err := readMetadataExtents(bytes.NewReader(img)); err == nil {
+		t.Error("expected error for invalid magic")
+	}
+}
@@ -1,15 +1,20 @@
 package erofs
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/containerd/containerd/mount"
 	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/internal/faultinject"
+	"github.com/opencloudos/dedup-snapshotter/pkg/opid"
+	"github.com/opencloudos/dedup-snapshotter/pkg/slowlog"
 )
 
 type MountManager struct {
@@ -17,6 +22,24 @@ type MountManager struct {
 	mountsDir   string
 	mountsMu    sync.RWMutex
 	activeMounts map[string]*MountPoint
+	limits      OverlayLimits
+	// snapshotMerged 记录每个快照因超出 overlay 限制而挂载的底部合并层
+	snapshotMerged map[string][]string
+	// flattener 构建底部合并层的扁平化镜像,foldMu 串行化合并层的构建、挂载和清理
+	flattener   LowerFlattener
+	foldMu      sync.Mutex
+	// snapshotIDMapped 记录每个用户命名空间快照使用的 idmapped 挂载
+	snapshotIDMapped map[string][]string
+	idmapOnce        sync.Once
+	idmapSupported   bool
+	// pending 记录正在挂载或卸载的镜像,外部命令执行期间不持有 mountsMu,
+	// 同一镜像的其他调用者等待 channel 关闭或自身 ctx 取消
+	pending     map[string]chan struct{}
+	timeout     time.Duration
+	// namespace 非空时 mount/umount 经 nsenter 在该挂载命名空间中执行
+	namespace   string
+	// lowerLabel 非空时作为 EROFS 层挂载的 SELinux context= 选项
+	lowerLabel  string
 }
 
 type MountPoint struct {
@@ -34,20 +57,151 @@ func NewMountManager(root string) (*MountManager, error) {
 	}
 
 	return &MountManager{
-		root:         root,
-		mountsDir:    mountsDir,
-		activeMounts: make(map[string]*MountPoint),
+		root:           root,
+		mountsDir:      mountsDir,
+		activeMounts:   make(map[string]*MountPoint),
+		limits:         defaultOverlayLimits(),
+		snapshotMerged: make(map[string][]string),
+		snapshotIDMapped: make(map[string][]string),
+		pending:        make(map[string]chan struct{}),
+		timeout:        DefaultMountTimeout,
 	}, nil
 }
 
-func (m *MountManager) MountErofs(imageID, imagePath string) (string, error) {
+// LowerFlattener 把按 overlay 顺序(第一个为最上层)排列的 lowerDirs 合并为单个 EROFS 镜像,
+// 合并时处理 whiteout 和 opaque 目录,镜像已存在时直接返回。由 Builder 实现
+type LowerFlattener interface {
+	EnsureFlattenedImage(ctx context.Context, lowerDirs []string, imageID string) (string, error)
+}
+
+// SetLowerFlattener 设置父链超出 overlay 限制时合并底部父层的实现,未设置时这类挂载直接失败
+func (m *MountManager) SetLowerFlattener(f LowerFlattener) {
 	m.mountsMu.Lock()
 	defer m.mountsMu.Unlock()
+	m.flattener = f
+}
 
-	if mp, ok := m.activeMounts[imageID]; ok {
-		mp.RefCount++
-		log.L.Debugf("reusing existing mount for %s, refcount=%d", imageID, mp.RefCount)
-		return mp.MountPath, nil
+func (m *MountManager) SetOverlayLimits(limits OverlayLimits) {
+	m.mountsMu.Lock()
+	defer m.mountsMu.Unlock()
+	m.limits = limits
+}
+
+// SetCommandTimeout 设置 mount/umount/losetup 单次执行的超时,超时的命令会被杀死
+func (m *MountManager) SetCommandTimeout(timeout time.Duration) {
+	m.mountsMu.Lock()
+	defer m.mountsMu.Unlock()
+	m.timeout = timeout
+}
+
+func (m *MountManager) commandTimeout() time.Duration {
+	m.mountsMu.RLock()
+	defer m.mountsMu.RUnlock()
+	return m.timeout
+}
+
+// SetMountNamespace 让 mount/umount 在 path 指向的挂载命名空间(如 /proc/1/ns/mnt)中执行。
+// systemd 以 PrivateMounts 或 MountFlags=slave 启动本进程时,本进程的挂载不会传播到 containerd,
+// 返回给 shim 的 overlay lowerdir 将无法解析。path 与当前进程是同一命名空间时不切换
+func (m *MountManager) SetMountNamespace(path string) error {
+	if path == "" {
+		m.mountsMu.Lock()
+		m.namespace = ""
+		m.mountsMu.Unlock()
+		return nil
+	}
+
+	target, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat mount namespace %s: %w", path, err)
+	}
+	self, err := os.Stat("/proc/self/ns/mnt")
+	if err != nil {
+		return fmt.Errorf("failed to stat own mount namespace: %w", err)
+	}
+	if os.SameFile(target, self) {
+		log.L.Debugf("mount namespace %s is our own, mounting directly", path)
+		path = ""
+	} else if _, err := exec.LookPath("nsenter"); err != nil {
+		return fmt.Errorf("nsenter required to mount in namespace %s: %w", path, err)
+	}
+
+	m.mountsMu.Lock()
+	m.namespace = path
+	m.mountsMu.Unlock()
+	if path != "" {
+		log.L.Infof("mounting erofs and overlay filesystems in mount namespace %s", path)
+	}
+	return nil
+}
+
+// runMount 执行 mount/umount,配置了挂载命名空间时经 nsenter 进入该命名空间
+func (m *MountManager) runMount(ctx context.Context, name string, args ...string) ([]byte, error) {
+	m.mountsMu.RLock()
+	namespace := m.namespace
+	timeout := m.timeout
+	m.mountsMu.RUnlock()
+
+	if namespace != "" {
+		args = append([]string{"--mount=" + namespace, "--", name}, args...)
+		name = "nsenter"
+	}
+	return runCommand(ctx, timeout, name, args...)
+}
+
+// reserve 在镜像已挂载时增加引用并返回挂载路径;否则为调用方占位(reserved 为 true),
+// 调用方完成后必须调用 release。已有进行中的挂载或卸载时等待其结束,ctx 取消则放弃等待
+func (m *MountManager) reserve(ctx context.Context, imageID string) (mountPath string, reserved bool, err error) {
+	for {
+		m.mountsMu.Lock()
+		if mp, ok := m.activeMounts[imageID]; ok {
+			mp.RefCount++
+			log.G(ctx).Debugf("reusing existing mount for %s, refcount=%d", imageID, mp.RefCount)
+			m.mountsMu.Unlock()
+			return mp.MountPath, false, nil
+		}
+		wait, busy := m.pending[imageID]
+		if !busy {
+			m.pending[imageID] = make(chan struct{})
+			m.mountsMu.Unlock()
+			return "", true, nil
+		}
+		m.mountsMu.Unlock()
+
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return "", false, fmt.Errorf("gave up waiting for pending mount of %s: %w", imageID, ctx.Err())
+		}
+	}
+}
+
+// release 结束 reserve 的占位,mp 不为 nil 时登记为活动挂载
+func (m *MountManager) release(imageID string, mp *MountPoint) {
+	m.mountsMu.Lock()
+	defer m.mountsMu.Unlock()
+
+	if mp != nil {
+		m.activeMounts[imageID] = mp
+	}
+	if wait, ok := m.pending[imageID]; ok {
+		close(wait)
+		delete(m.pending, imageID)
+	}
+}
+
+func (m *MountManager) MountErofs(ctx context.Context, imageID, imagePath string) (_ string, err error) {
+	if existing, reserved, err := m.reserve(ctx, imageID); !reserved {
+		return existing, err
+	}
+	var mp *MountPoint
+	defer func() { m.release(imageID, mp) }()
+
+	op := slowlog.Start(slowlog.OpMount, log.Fields{"image": imageID, "path": imagePath, opid.Field: opid.FromContext(ctx)})
+	defer func() { op.Done(err) }()
+
+	if err := faultinject.Inject(faultinject.MountFailure); err != nil {
+		return "", fmt.Errorf("failed to mount erofs: %w", err)
 	}
 
 	mountPath := filepath.Join(m.mountsDir, imageID)
@@ -55,17 +209,17 @@ func (m *MountManager) MountErofs(imageID, imagePath string) (string, error) {
 		return "", err
 	}
 
-	loopDev, err := m.setupLoopDevice(imagePath)
+	loopDev, err := m.setupLoopDevice(ctx, imagePath)
 	if err != nil {
 		return "", fmt.Errorf("failed to setup loop device: %w", err)
 	}
 
-	if err := m.mountErofsImage(loopDev, mountPath); err != nil {
-		m.detachLoopDevice(loopDev)
+	if err := m.mountErofsImage(ctx, loopDev, mountPath); err != nil {
+		m.detachLoopDevice(context.Background(), loopDev)
 		return "", err
 	}
 
-	m.activeMounts[imageID] = &MountPoint{
+	mp = &MountPoint{
 		ID:         imageID,
 		ImagePath:  imagePath,
 		MountPath:  mountPath,
@@ -73,14 +227,18 @@ func (m *MountManager) MountErofs(imageID, imagePath string) (string, error) {
 		RefCount:   1,
 	}
 
-	log.L.Infof("mounted erofs image %s at %s (loop=%s)", imageID, mountPath, loopDev)
+	log.G(ctx).Infof("mounted erofs image %s at %s (loop=%s)", imageID, mountPath, loopDev)
 	return mountPath, nil
 }
 
-func (m *MountManager) setupLoopDevice(imagePath string) (string, error) {
-	cmd := exec.Command("losetup", "-f", "--show", imagePath)
-	output, err := cmd.CombinedOutput()
+func (m *MountManager) setupLoopDevice(ctx context.Context, imagePath string) (string, error) {
+	before, listErr := LoopDevices()
+	output, err := runCommand(ctx, m.commandTimeout(), "losetup", "-f", "--show", imagePath)
 	if err != nil {
+		// losetup 超时被杀死或出错时内核可能已关联了设备,此时拿不到设备名,按后端文件找出并解除
+		if listErr == nil {
+			m.detachNewLoops(imagePath, before)
+		}
 		return "", fmt.Errorf("losetup failed: %w, output: %s", err, string(output))
 	}
 
@@ -88,9 +246,31 @@ func (m *MountManager) setupLoopDevice(imagePath string) (string, error) {
 	return loopDev, nil
 }
 
-func (m *MountManager) mountErofsImage(loopDev, mountPath string) error {
-	cmd := exec.Command("mount", "-t", "erofs", "-o", "ro", loopDev, mountPath)
-	output, err := cmd.CombinedOutput()
+// detachNewLoops 解除 before 之后新关联到 imagePath 且未被登记的 loop 设备
+func (m *MountManager) detachNewLoops(imagePath string, before map[string]string) {
+	after, err := LoopDevices()
+	if err != nil {
+		log.L.WithError(err).Warnf("failed to list loop devices after losetup of %s", imagePath)
+		return
+	}
+	backing := imagePath
+	if resolved, err := filepath.EvalSymlinks(imagePath); err == nil {
+		backing = resolved
+	}
+	for dev, file := range after {
+		if file != backing || before[dev] == file || m.Tracked(dev) {
+			continue
+		}
+		if err := m.detachLoopDevice(context.Background(), dev); err != nil {
+			log.L.WithError(err).Warnf("failed to detach loop device %s left by failed losetup", dev)
+			continue
+		}
+		log.L.Infof("detached loop device %s left by failed losetup of %s", dev, imagePath)
+	}
+}
+
+func (m *MountManager) mountErofsImage(ctx context.Context, loopDev, mountPath string) error {
+	output, err := m.runMount(ctx, "mount", "-t", "erofs", "-o", m.erofsMountOptions("ro"), loopDev, mountPath)
 	if err != nil {
 		return fmt.Errorf("mount failed: %w, output: %s", err, string(output))
 	}
@@ -98,14 +278,18 @@ func (m *MountManager) mountErofsImage(loopDev, mountPath string) error {
 	return nil
 }
 
-func (m *MountManager) MountErofsWithFscache(imageID, fsid, domain string) (string, error) {
-	m.mountsMu.Lock()
-	defer m.mountsMu.Unlock()
+func (m *MountManager) MountErofsWithFscache(ctx context.Context, imageID, fsid, domain string) (_ string, err error) {
+	if existing, reserved, err := m.reserve(ctx, imageID); !reserved {
+		return existing, err
+	}
+	var mp *MountPoint
+	defer func() { m.release(imageID, mp) }()
 
-	if mp, ok := m.activeMounts[imageID]; ok {
-		mp.RefCount++
-		log.L.Debugf("reusing existing fscache mount for %s, refcount=%d", imageID, mp.RefCount)
-		return mp.MountPath, nil
+	op := slowlog.Start(slowlog.OpMount, log.Fields{"image": imageID, "fsid": fsid, "domain": domain, opid.Field: opid.FromContext(ctx)})
+	defer func() { op.Done(err) }()
+
+	if err := faultinject.Inject(faultinject.MountFailure); err != nil {
+		return "", fmt.Errorf("failed to mount erofs with fscache: %w", err)
 	}
 
 	mountPath := filepath.Join(m.mountsDir, imageID)
@@ -113,14 +297,13 @@ func (m *MountManager) MountErofsWithFscache(imageID, fsid, domain string) (stri
 		return "", err
 	}
 
-	mountOpts := fmt.Sprintf("ro,fsid=%s,domain=%s", fsid, domain)
-	cmd := exec.Command("mount", "-t", "erofs", "-o", mountOpts, "none", mountPath)
-	output, err := cmd.CombinedOutput()
+	mountOpts := m.erofsMountOptions(fmt.Sprintf("ro,fsid=%s,domain=%s", fsid, domain))
+	output, err := m.runMount(ctx, "mount", "-t", "erofs", "-o", mountOpts, "none", mountPath)
 	if err != nil {
 		return "", fmt.Errorf("fscache mount failed: %w, output: %s", err, string(output))
 	}
 
-	m.activeMounts[imageID] = &MountPoint{
+	mp = &MountPoint{
 		ID:         imageID,
 		ImagePath:  fmt.Sprintf("fscache://%s/%s", domain, fsid),
 		MountPath:  mountPath,
@@ -128,58 +311,127 @@ func (m *MountManager) MountErofsWithFscache(imageID, fsid, domain string) (stri
 		RefCount:   1,
 	}
 
-	log.L.Infof("mounted erofs with fscache: %s at %s (fsid=%s, domain=%s)", imageID, mountPath, fsid, domain)
+	log.G(ctx).Infof("mounted erofs with fscache: %s at %s (fsid=%s, domain=%s)", imageID, mountPath, fsid, domain)
 	return mountPath, nil
 }
 
-func (m *MountManager) Unmount(imageID string) error {
+// RemountLoop 把 fscache 挂载的镜像原地换成本地 EROFS 镜像的 loop 挂载,保留引用计数。
+// 旧挂载被惰性卸载,之后创建的 overlay 读取本地镜像,已在使用旧挂载的 overlay 在其卸载后释放
+func (m *MountManager) RemountLoop(ctx context.Context, imageID, imagePath string) error {
 	m.mountsMu.Lock()
-	defer m.mountsMu.Unlock()
+	mp, ok := m.activeMounts[imageID]
+	if !ok {
+		m.mountsMu.Unlock()
+		return fmt.Errorf("mount point not found for %s", imageID)
+	}
+	if mp.LoopDevice != "" {
+		m.mountsMu.Unlock()
+		return nil
+	}
+	delete(m.activeMounts, imageID)
+	m.pending[imageID] = make(chan struct{})
+	m.mountsMu.Unlock()
+
+	restore := mp
+	defer func() { m.release(imageID, restore) }()
 
+	loopDev, err := m.setupLoopDevice(ctx, imagePath)
+	if err != nil {
+		return fmt.Errorf("failed to setup loop device: %w", err)
+	}
+	if output, err := m.runMount(ctx, "umount", "-l", mp.MountPath); err != nil {
+		m.detachLoopDevice(context.Background(), loopDev)
+		return fmt.Errorf("lazy umount failed: %w, output: %s", err, string(output))
+	}
+	if err := m.mountErofsImage(ctx, loopDev, mp.MountPath); err != nil {
+		m.detachLoopDevice(context.Background(), loopDev)
+		// 旧挂载已分离,挂载点不再可用
+		restore = nil
+		return err
+	}
+
+	restore = &MountPoint{
+		ID:         imageID,
+		ImagePath:  imagePath,
+		MountPath:  mp.MountPath,
+		LoopDevice: loopDev,
+		RefCount:   mp.RefCount,
+	}
+	log.G(ctx).Infof("remounted erofs image %s from %s to loop device %s", imageID, mp.ImagePath, loopDev)
+	return nil
+}
+
+// Unmount 释放一个引用,最后一个引用释放时卸载。卸载是清理路径,不受调用方取消影响,
+// 只受命令超时约束;卸载失败时挂载点保留在活动列表中
+func (m *MountManager) Unmount(imageID string) error {
+	m.mountsMu.Lock()
 	mp, ok := m.activeMounts[imageID]
 	if !ok {
+		m.mountsMu.Unlock()
 		return fmt.Errorf("mount point not found for %s", imageID)
 	}
 
 	mp.RefCount--
 	if mp.RefCount > 0 {
+		m.mountsMu.Unlock()
 		log.L.Debugf("decremented refcount for %s, refcount=%d", imageID, mp.RefCount)
 		return nil
 	}
 
-	if err := m.unmountPath(mp.MountPath); err != nil {
+	delete(m.activeMounts, imageID)
+	m.pending[imageID] = make(chan struct{})
+	m.mountsMu.Unlock()
+
+	var restore *MountPoint
+	defer func() { m.release(imageID, restore) }()
+
+	ctx := context.Background()
+	if err := m.unmountPath(ctx, mp.MountPath); err != nil {
+		restore = mp
 		return err
 	}
 
-	if err := m.detachLoopDevice(mp.LoopDevice); err != nil {
-		log.L.Warnf("failed to detach loop device %s: %v", mp.LoopDevice, err)
+	if mp.LoopDevice != "" {
+		if err := m.detachLoopDevice(ctx, mp.LoopDevice); err != nil {
+			log.L.Warnf("failed to detach loop device %s: %v", mp.LoopDevice, err)
+		}
 	}
 
-	delete(m.activeMounts, imageID)
 	os.RemoveAll(mp.MountPath)
 
 	log.L.Infof("unmounted erofs image %s", imageID)
 	return nil
 }
 
-func (m *MountManager) unmountPath(mountPath string) error {
-	cmd := exec.Command("umount", mountPath)
-	output, err := cmd.CombinedOutput()
+func (m *MountManager) unmountPath(ctx context.Context, mountPath string) error {
+	output, err := m.runMount(ctx, "umount", mountPath)
 	if err != nil {
 		return fmt.Errorf("umount failed: %w, output: %s", err, string(output))
 	}
 	return nil
 }
 
-func (m *MountManager) detachLoopDevice(loopDev string) error {
-	cmd := exec.Command("losetup", "-d", loopDev)
-	output, err := cmd.CombinedOutput()
+func (m *MountManager) detachLoopDevice(ctx context.Context, loopDev string) error {
+	output, err := runCommand(ctx, m.commandTimeout(), "losetup", "-d", loopDev)
 	if err != nil {
 		return fmt.Errorf("losetup detach failed: %w, output: %s", err, string(output))
 	}
 	return nil
 }
 
+// Acquire 为已挂载的镜像增加一个引用并返回挂载路径,调用方用 Unmount 释放
+func (m *MountManager) Acquire(imageID string) (string, error) {
+	m.mountsMu.Lock()
+	defer m.mountsMu.Unlock()
+
+	mp, ok := m.activeMounts[imageID]
+	if !ok {
+		return "", fmt.Errorf("erofs image %s is not mounted", imageID)
+	}
+	mp.RefCount++
+	return mp.MountPath, nil
+}
+
 func (m *MountManager) GetMountPath(imageID string) (string, bool) {
 	m.mountsMu.RLock()
 	defer m.mountsMu.RUnlock()
@@ -190,7 +442,9 @@ func (m *MountManager) GetMountPath(imageID string) (string, bool) {
 	return "", false
 }
 
-func (m *MountManager) CreateOverlayMounts(snapshotID string, lowerDirs []string, upperDir, workDir string) ([]mount.Mount, error) {
+// CreateOverlayMounts 返回快照的 overlay 挂载。mountLabel 非空时 upperdir 和 workdir 打上该 SELinux 标签,
+// 并以 context= 选项挂载,容器内所有文件都显示为该标签
+func (m *MountManager) CreateOverlayMounts(ctx context.Context, snapshotID string, lowerDirs []string, upperDir, workDir, mountLabel string) ([]mount.Mount, error) {
 	if err := os.MkdirAll(upperDir, 0755); err != nil {
 		return nil, err
 	}
@@ -202,8 +456,29 @@ func (m *MountManager) CreateOverlayMounts(snapshotID string, lowerDirs []string
 		fmt.Sprintf("upperdir=%s", upperDir),
 		fmt.Sprintf("workdir=%s", workDir),
 	}
+	if mountLabel != "" {
+		for _, dir := range []string{upperDir, workDir} {
+			if err := SetFileLabel(dir, mountLabel); err != nil {
+				return nil, err
+			}
+		}
+		options = append(options, FormatMountLabel(mountLabel))
+	}
 
 	if len(lowerDirs) > 0 {
+		reserved := len(strings.Join(options, ",")) + 1
+		m.mountsMu.RLock()
+		limits := m.limits
+		m.mountsMu.RUnlock()
+
+		if !limits.fits(lowerDirs, reserved) {
+			merged, err := m.foldLowerDirs(ctx, snapshotID, lowerDirs, limits, reserved)
+			if err != nil {
+				return nil, err
+			}
+			lowerDirs = merged
+		}
+
 		lowerDir := strings.Join(lowerDirs, ":")
 		options = append(options, fmt.Sprintf("lowerdir=%s", lowerDir))
 	}
@@ -217,26 +492,115 @@ func (m *MountManager) CreateOverlayMounts(snapshotID string, lowerDirs []string
 	}, nil
 }
 
+// foldLowerDirs 在 lowerdir 超出内核限制时,把放不下的底部父层合并为一个扁平化 EROFS 镜像并挂载为最底层,
+// 上面的父层保持为独立的 lowerdir。底部各层之间的 whiteout 和 opaque 目录在构建镜像时已应用,
+// 上层的 whiteout 仍由最终的 overlay 作用于它;把中间层挂载为 overlay 则会吃掉组内的 whiteout,
+// 使上层删除的文件在更下层重新出现
+func (m *MountManager) foldLowerDirs(ctx context.Context, snapshotID string, lowerDirs []string, limits OverlayLimits, reserved int) ([]string, error) {
+	m.mountsMu.Lock()
+	flattener := m.flattener
+	stale := m.snapshotMerged[snapshotID]
+	delete(m.snapshotMerged, snapshotID)
+	m.mountsMu.Unlock()
+	m.releaseMerged(stale)
+
+	if flattener == nil {
+		return nil, fmt.Errorf("parent chain of %d layers exceeds overlay limits (max %d lowerdirs, %d option bytes) and no flattener is configured",
+			len(lowerDirs), limits.MaxLowerDirs, limits.MaxOptionBytes)
+	}
+
+	foldedLen := len(filepath.Join(m.mountsDir, FlattenedImageID(mergedMountID(nil))))
+	keep := limits.foldPoint(lowerDirs, reserved, foldedLen)
+	if keep < 0 {
+		return nil, fmt.Errorf("mount options exceed overlay limit of %d bytes even with a single lowerdir", limits.MaxOptionBytes)
+	}
+	bottom := lowerDirs[keep:]
+	id := FlattenedImageID(mergedMountID(bottom))
+
+	m.foldMu.Lock()
+	defer m.foldMu.Unlock()
+	imagePath, err := flattener.EnsureFlattenedImage(ctx, bottom, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to flatten %d bottom layers: %w", len(bottom), err)
+	}
+	mountPath, err := m.MountErofs(ctx, id, imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mount flattened lower %s: %w", id, err)
+	}
+
+	m.mountsMu.Lock()
+	m.snapshotMerged[snapshotID] = []string{id}
+	m.mountsMu.Unlock()
+
+	log.G(ctx).Infof("snapshot %s: folded the bottom %d of %d lowerdirs into flattened image %s", snapshotID, len(bottom), len(lowerDirs), id)
+	return append(lowerDirs[:keep:keep], mountPath), nil
+}
+
+// PruneFoldedLowers 对 imagesDir 中没有挂载的底部合并层镜像调用 remove。这些镜像以父层挂载路径的哈希命名,
+// 父层删除后不会再被使用,仍需要时下次挂载会重新构建
+func (m *MountManager) PruneFoldedLowers(imagesDir string, remove func(imageID string)) {
+	m.foldMu.Lock()
+	defer m.foldMu.Unlock()
+
+	entries, err := os.ReadDir(imagesDir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ErofsImageExt)
+		if !ok || !strings.HasPrefix(id, mergedMountPrefix) {
+			continue
+		}
+		if _, mounted := m.GetMountPath(id); !mounted {
+			remove(id)
+		}
+	}
+}
+
+// ReleaseSnapshot 释放快照创建时挂载的底部合并层和 idmapped 挂载
+func (m *MountManager) ReleaseSnapshot(snapshotID string) {
+	m.mountsMu.Lock()
+	ids := append(m.snapshotMerged[snapshotID], m.snapshotIDMapped[snapshotID]...)
+	delete(m.snapshotMerged, snapshotID)
+	delete(m.snapshotIDMapped, snapshotID)
+	m.mountsMu.Unlock()
+
+	m.releaseMerged(ids)
+}
+
+func (m *MountManager) releaseMerged(ids []string) {
+	for _, id := range ids {
+		if err := m.Unmount(id); err != nil {
+			log.L.WithError(err).Warnf("failed to release folded lower %s", id)
+		}
+	}
+}
+
 func (m *MountManager) UnmountAll() error {
 	m.mountsMu.Lock()
-	defer m.mountsMu.Unlock()
+	active := m.activeMounts
+	m.activeMounts = make(map[string]*MountPoint)
+	m.snapshotMerged = make(map[string][]string)
+	m.snapshotIDMapped = make(map[string][]string)
+	m.mountsMu.Unlock()
 
+	ctx := context.Background()
 	var errs []error
-	for id, mp := range m.activeMounts {
-		if err := m.unmountPath(mp.MountPath); err != nil {
+	for id, mp := range active {
+		if err := m.unmountPath(ctx, mp.MountPath); err != nil {
 			errs = append(errs, fmt.Errorf("failed to unmount %s: %w", id, err))
 			continue
 		}
 
-		if err := m.detachLoopDevice(mp.LoopDevice); err != nil {
-			errs = append(errs, fmt.Errorf("failed to detach loop %s: %w", mp.LoopDevice, err))
+		if mp.LoopDevice != "" {
+			if err := m.detachLoopDevice(ctx, mp.LoopDevice); err != nil {
+				errs = append(errs, fmt.Errorf("failed to detach loop %s: %w", mp.LoopDevice, err))
+			}
 		}
 
 		os.RemoveAll(mp.MountPath)
 	}
 
-	m.activeMounts = make(map[string]*MountPoint)
-
 	if len(errs) > 0 {
 		return fmt.Errorf("unmount errors: %v", errs)
 	}
@@ -0,0 +1,257 @@
+package erofs
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/containerd/log"
+)
+
+// AccessOrderExt 是访问顺序文件的扩展名,文件放在预取 trace 目录中,以镜像 ID 命名
+const AccessOrderExt = ".order"
+
+// LoadAccessOrder 读取访问顺序文件:每行一个相对镜像根目录的路径,按首次访问排序,# 开头为注释
+func LoadAccessOrder(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var order []string
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p := cleanOrderPath(line)
+		if p == "" || seen[p] {
+			continue
+		}
+		seen[p] = true
+		order = append(order, p)
+	}
+	return order, scanner.Err()
+}
+
+// cleanOrderPath 把路径规范为不带前导 / 的相对路径,指向根目录之外时返回空串
+func cleanOrderPath(p string) string {
+	p = strings.TrimPrefix(filepath.Clean("/"+p), "/")
+	if p == "" || p == "." {
+		return ""
+	}
+	return p
+}
+
+// BuildImageOrdered 与 BuildImageWithProgress 相同,但镜像中的文件数据按 order 排列,
+// 启动时的顺序读取能被预读和预取覆盖。order 为空时等同于普通构建
+func (b *Builder) BuildImageOrdered(ctx context.Context, sourceDir, imageID string, order []string, progress ProgressFunc) (string, error) {
+	if len(order) == 0 {
+		return b.BuildImageWithProgress(ctx, sourceDir, imageID, progress)
+	}
+
+	stagingDir := b.stagingPath(imageID)
+	txn, err := b.beginBuild(BuildKindImage, imageID, stagingDir)
+	if err != nil {
+		return "", err
+	}
+	defer txn.finish()
+
+	if err := os.MkdirAll(stagingDir, 0755); err != nil {
+		return "", err
+	}
+
+	if err := b.processDirectory(ctx, sourceDir, stagingDir, imageID, progress); err != nil {
+		return "", err
+	}
+
+	if err := b.buildOrderedImage(ctx, stagingDir, txn.record.Temp, order); err != nil {
+		if !errors.Is(err, ErrOrderedBuildUnsupported) {
+			return "", err
+		}
+		log.G(ctx).WithError(err).Warnf("building %s without access order", txn.imagePath)
+		os.Remove(txn.record.Temp)
+		if err := b.buildErofsImage(ctx, stagingDir, txn.record.Temp); err != nil {
+			return "", err
+		}
+		if err := txn.commit(); err != nil {
+			return "", err
+		}
+		return txn.imagePath, nil
+	}
+	if err := txn.commit(); err != nil {
+		return "", err
+	}
+
+	log.G(ctx).Infof("built erofs image %s with %d files in access order", txn.imagePath, len(order))
+	return txn.imagePath, nil
+}
+
+// Relayout 按新的访问顺序重建已有镜像。sourceDir 为镜像内容(快照目录或镜像的挂载点),
+// 不重新切分 chunk,也不修改 chunk 索引。新镜像写入临时文件后替换原文件,
+// 已挂载的旧镜像在卸载前继续使用原布局
+func (b *Builder) Relayout(ctx context.Context, sourceDir, imageID string, order []string) (string, error) {
+	imagePath := b.imagePath(imageID)
+	if _, err := os.Stat(imagePath); err != nil {
+		return "", fmt.Errorf("image %s not found: %w", imageID, err)
+	}
+
+	txn, err := b.beginBuild(BuildKindRelayout, imageID, "")
+	if err != nil {
+		return "", err
+	}
+	defer txn.finish()
+
+	if err := b.buildOrderedImage(ctx, sourceDir, txn.record.Temp, order); err != nil {
+		return "", err
+	}
+	if err := txn.commit(); err != nil {
+		return "", err
+	}
+
+	log.G(ctx).Infof("relayout of erofs image %s with %d files in access order", imagePath, len(order))
+	return imagePath, nil
+}
+
+// ErrOrderedBuildUnsupported 表示 mkfs.erofs 不支持按 tar 顺序排列数据(erofs-utils 1.8 之前)
+var ErrOrderedBuildUnsupported = errors.New("mkfs.erofs does not support ordered tar input")
+
+// buildOrderedImage 把 sourceDir 按访问顺序写成 tar,再用 mkfs.erofs --sort=none 保持 tar 中的数据顺序
+func (b *Builder) buildOrderedImage(ctx context.Context, sourceDir, imagePath string, order []string) error {
+	tarPath := imagePath + ".tar"
+	defer os.Remove(tarPath)
+
+	if err := writeOrderedTar(sourceDir, tarPath, order); err != nil {
+		return fmt.Errorf("failed to write ordered tar: %w", err)
+	}
+
+	output, err := runCommand(ctx, b.buildTimeout, "mkfs.erofs",
+		"-z"+Compression,
+		"-T", "0",
+		"--all-root",
+		"--tar=f",
+		"--sort=none",
+		imagePath,
+		tarPath,
+	)
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil || errors.Is(err, ErrCommandTimeout) {
+		return fmt.Errorf("mkfs.erofs failed: %w, output: %s", err, string(output))
+	}
+	return fmt.Errorf("%w: %v, output: %s", ErrOrderedBuildUnsupported, err, strings.TrimSpace(string(output)))
+}
+
+// writeOrderedTar 依次写入所有目录、order 中的文件、其余条目(按路径)。
+// 目录必须先于其中的文件出现;order 中不存在或不是普通文件的路径被忽略
+func writeOrderedTar(sourceDir, tarPath string, order []string) error {
+	var dirs, others []string
+	regular := make(map[string]bool)
+	err := filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(sourceDir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		switch {
+		case info.IsDir():
+			dirs = append(dirs, rel)
+		case info.Mode().IsRegular():
+			regular[rel] = true
+		case info.Mode()&os.ModeSocket != 0:
+			// tar 不支持 socket
+		default:
+			others = append(others, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	entries := dirs
+	for _, p := range order {
+		if regular[p] {
+			entries = append(entries, p)
+			delete(regular, p)
+		}
+	}
+	var rest []string
+	for p := range regular {
+		rest = append(rest, p)
+	}
+	rest = append(rest, others...)
+	sort.Strings(rest)
+	entries = append(entries, rest...)
+
+	f, err := os.Create(tarPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	bw := bufio.NewWriter(f)
+	tw := tar.NewWriter(bw)
+	for _, rel := range entries {
+		if err := addTarEntry(tw, sourceDir, rel); err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func addTarEntry(tw *tar.Writer, sourceDir, rel string) error {
+	path := filepath.Join(sourceDir, rel)
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	var link string
+	if info.Mode()&os.ModeSymlink != 0 {
+		if link, err = os.Readlink(path); err != nil {
+			return err
+		}
+	}
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = rel
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	// 镜像以 --all-root 构建,不保留属主名
+	hdr.Uname, hdr.Gname = "", ""
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	_, err = io.Copy(tw, src)
+	return err
+}
@@ -0,0 +1,69 @@
+package erofs
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestWriteOrderedTar 验证目录在前、trace 中的文件按访问顺序、其余文件按路径排列
+func TestWriteOrderedTar(t *testing.T) {
+	src := t.TempDir()
+	for _, name := range []string{"bin/app", "etc/config", "lib/a.so", "lib/b.so"} {
+		path := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("a.so", filepath.Join(src, "lib/current.so")); err != nil {
+		t.Fatal(err)
+	}
+
+	orderFile := filepath.Join(t.TempDir(), "img"+AccessOrderExt)
+	trace := "# startup\n/lib/b.so\nbin/app\n../../etc/passwd\nlib/b.so\nmissing\n"
+	if err := os.WriteFile(orderFile, []byte(trace), 0644); err != nil {
+		t.Fatal(err)
+	}
+	order, err := LoadAccessOrder(orderFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"lib/b.so", "bin/app", "etc/passwd", "missing"}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("unexpected order %v", order)
+	}
+
+	tarPath := filepath.Join(t.TempDir(), "img.tar")
+	if err := writeOrderedTar(src, tarPath, order); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var names []string
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+
+	want := []string{"bin/", "etc/", "lib/", "lib/b.so", "bin/app", "etc/config", "lib/a.so", "lib/current.so"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("unexpected tar order:\n got %v\nwant %v", names, want)
+	}
+	t.Logf("✓ tar 条目按访问顺序排列: %v", names)
+}
@@ -0,0 +1,91 @@
+package erofs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// prechunkedFile 是活动快照中已提前切分的大文件,大小或修改时间变化后失效
+type prechunkedFile struct {
+	size    int64
+	modTime time.Time
+	chunks  []ChunkInfo
+}
+
+// PrechunkFile 在后台提前切分并存储大文件的 chunk,之后构建镜像时
+// deduplicateFile 可直接复用结果而不必重新计算哈希。小文件直接忽略。
+func (b *Builder) PrechunkFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() || info.Size() < ChunkSize {
+		return nil
+	}
+	if cached, ok := b.prechunked.Load(path); ok {
+		entry := cached.(*prechunkedFile)
+		if entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) {
+			return nil
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	chunks, err := b.chunkFile(file)
+	if err != nil {
+		return err
+	}
+
+	// 切分期间文件仍在写入时结果不可用,等下一次写入事件重新切分
+	after, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if after.Size() != info.Size() || !after.ModTime().Equal(info.ModTime()) {
+		return nil
+	}
+
+	b.prechunked.Store(path, &prechunkedFile{
+		size:    info.Size(),
+		modTime: info.ModTime(),
+		chunks:  chunks,
+	})
+	return nil
+}
+
+// ForgetPrechunked 丢弃 dir 下所有文件的预切分结果
+func (b *Builder) ForgetPrechunked(dir string) {
+	prefix := filepath.Clean(dir) + string(filepath.Separator)
+	b.prechunked.Range(func(key, _ interface{}) bool {
+		if strings.HasPrefix(key.(string), prefix) {
+			b.prechunked.Delete(key)
+		}
+		return true
+	})
+}
+
+func (b *Builder) prechunkedChunks(path string, info os.FileInfo) ([]ChunkInfo, bool) {
+	cached, ok := b.prechunked.Load(path)
+	if !ok {
+		return nil, false
+	}
+	entry := cached.(*prechunkedFile)
+	if entry.size != info.Size() || !entry.modTime.Equal(info.ModTime()) {
+		b.prechunked.Delete(path)
+		return nil, false
+	}
+
+	for _, chunk := range entry.chunks {
+		if !b.HasChunk(chunk.Hash) {
+			b.prechunked.Delete(path)
+			return nil, false
+		}
+	}
+	return entry.chunks, true
+}
@@ -0,0 +1,63 @@
+package erofs
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestPrechunkFile 验证预切分结果在文件未变化时被复用,文件修改后失效
+func TestPrechunkFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	builder, err := NewBuilder(filepath.Join(tmpDir, "root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer builder.Close()
+
+	upper := filepath.Join(tmpDir, "upper")
+	if err := os.MkdirAll(upper, 0755); err != nil {
+		t.Fatal(err)
+	}
+	small := filepath.Join(upper, "small")
+	large := filepath.Join(upper, "large")
+	writeTestFile(t, small, "small")
+	if err := os.WriteFile(large, bytes.Repeat([]byte("x"), ChunkSize+100), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{small, large} {
+		if err := builder.PrechunkFile(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	info, _ := os.Stat(small)
+	if _, ok := builder.prechunkedChunks(small, info); ok {
+		t.Error("small files must not be prechunked")
+	}
+	info, _ = os.Stat(large)
+	chunks, ok := builder.prechunkedChunks(large, info)
+	if !ok || len(chunks) != 2 || chunks[1].Offset != ChunkSize || chunks[1].Size != 100 {
+		t.Fatalf("unexpected prechunked result: ok=%v chunks=%+v", ok, chunks)
+	}
+	t.Logf("✓ 大文件预切分为 %d 个 chunk", len(chunks))
+
+	later := info.ModTime().Add(time.Second)
+	if err := os.Chtimes(large, later, later); err != nil {
+		t.Fatal(err)
+	}
+	info, _ = os.Stat(large)
+	if _, ok := builder.prechunkedChunks(large, info); ok {
+		t.Error("prechunked result must be invalidated after modification")
+	}
+
+	builder.PrechunkFile(large)
+	builder.ForgetPrechunked(upper)
+	if _, ok := builder.prechunkedChunks(large, info); ok {
+		t.Error("prechunked result must be dropped by ForgetPrechunked")
+	}
+	t.Logf("✓ 文件修改或快照删除后预切分结果失效")
+}
@@ -0,0 +1,51 @@
+package erofs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Rechunk 按当前的切分参数重新切分镜像内容并重建镜像。sourceDir 为镜像内容(通常是原镜像的挂载点),
+// 原有的 chunk 引用在构建前释放;构建中断时镜像本身不受影响,再次执行即可恢复引用
+func (b *Builder) Rechunk(ctx context.Context, sourceDir, imageID string, order []string) (string, error) {
+	if _, err := os.Stat(b.imagePath(imageID)); err != nil {
+		return "", fmt.Errorf("image %s not found: %w", imageID, err)
+	}
+	if err := b.indexer.RemoveImage(imageID); err != nil {
+		return "", fmt.Errorf("failed to drop chunk references of %s: %w", imageID, err)
+	}
+	return b.BuildImageOrdered(ctx, sourceDir, imageID, order, nil)
+}
+
+// PruneChunks 删除 chunk 索引中没有镜像引用的 chunk 文件,返回删除的个数和字节数
+func (b *Builder) PruneChunks() (int, int64, error) {
+	hashes, err := b.ListChunks()
+	if err != nil {
+		return 0, 0, err
+	}
+	counts, err := b.indexer.GetRefCounts(hashes)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var removed int
+	var freed int64
+	for _, hash := range hashes {
+		if counts[hash] > 0 {
+			continue
+		}
+		path := filepath.Join(b.chunksDir, hash)
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return removed, freed, err
+		}
+		removed++
+		freed += info.Size()
+	}
+	return removed, freed, nil
+}
@@ -0,0 +1,91 @@
+package erofs
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// dataExtents 用 SEEK_DATA/SEEK_HOLE 找出文件中的数据区间,空洞不出现在结果中。
+// 文件系统不支持时把整个文件视为一个数据区间
+func dataExtents(file *os.File, size int64) []Extent {
+	whole := []Extent{{Offset: 0, Length: size}}
+	if size == 0 {
+		return nil
+	}
+
+	fd := int(file.Fd())
+	var extents []Extent
+	for off := int64(0); off < size; {
+		data, err := unix.Seek(fd, off, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			// 之后都是空洞
+			break
+		}
+		if err != nil {
+			return whole
+		}
+		if data >= size {
+			break
+		}
+		hole, err := unix.Seek(fd, data, unix.SEEK_HOLE)
+		if err != nil {
+			return whole
+		}
+		if hole > size {
+			hole = size
+		}
+		if hole <= data {
+			return whole
+		}
+		extents = append(extents, Extent{Offset: data, Length: hole - data})
+		off = hole
+	}
+	return extents
+}
+
+// fileHoles 根据按偏移排序的 chunk 推导出大小为 size 的文件中未被 chunk 覆盖的空洞
+func fileHoles(chunks []ChunkInfo, size int64) []Extent {
+	var holes []Extent
+	off := int64(0)
+	for _, chunk := range chunks {
+		if chunk.Offset > off {
+			holes = append(holes, Extent{Offset: off, Length: chunk.Offset - off})
+		}
+		if end := chunk.Offset + chunk.Size; end > off {
+			off = end
+		}
+	}
+	if size > off {
+		holes = append(holes, Extent{Offset: off, Length: size - off})
+	}
+	return holes
+}
+
+// writeSparse 把 data 写到 f 的 offset 处,跳过全零的块,使 chunk 内的空洞在重建后仍是空洞。
+// 跳过的区间由调用方最终截断文件时补为零
+func writeSparse(f *os.File, data []byte, offset int64) error {
+	// 相邻的非零块合并为一次写入,start 为当前非零区间的起点,-1 表示不在区间内
+	start := -1
+	flush := func(end int) error {
+		if start < 0 {
+			return nil
+		}
+		_, err := f.WriteAt(data[start:end], offset+int64(start))
+		start = -1
+		return err
+	}
+	for i := 0; i < len(data); i += BlockSize {
+		if !isZeroChunk(data[i:min(i+BlockSize, len(data))]) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if err := flush(i); err != nil {
+			return err
+		}
+	}
+	return flush(len(data))
+}
@@ -0,0 +1,106 @@
+package erofs
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// TestSparseFileRoundTrip 验证稀疏文件中完全落在空洞内的 chunk 不被切分存储,chunk 边界与非稀疏文件一样
+// 对齐到 ChunkSize,重建后内容一致且空洞仍是空洞
+func TestSparseFileRoundTrip(t *testing.T) {
+	tmpDir := t.TempDir()
+	builder, err := NewBuilder(filepath.Join(tmpDir, "root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer builder.Close()
+
+	// 布局:空洞 | 1 MiB 数据(从第二个 chunk 中间开始) | 空洞 | 4 KiB 数据 | 尾部空洞
+	head := bytes.Repeat([]byte("d"), 1<<20)
+	tail := bytes.Repeat([]byte("t"), BlockSize)
+	size := int64(5 * ChunkSize)
+	source := filepath.Join(tmpDir, "source")
+	f, err := os.Create(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt(head, ChunkSize+BlockSize); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt(tail, 3*ChunkSize); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	var st syscall.Stat_t
+	if err := syscall.Stat(source, &st); err != nil || st.Blocks*512 >= size {
+		t.Skip("filesystem does not support sparse files")
+	}
+
+	f, err = os.Open(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := builder.chunkFile(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stored int64
+	for _, c := range chunks {
+		if c.Offset%ChunkSize != 0 {
+			t.Fatalf("chunk at %d is not aligned to the chunk size", c.Offset)
+		}
+		stored += c.Size
+	}
+	if len(chunks) != 2 || chunks[0].Offset != ChunkSize || chunks[1].Offset != 3*ChunkSize {
+		t.Fatalf("expected only the two chunks holding data, got %+v", chunks)
+	}
+	holes := fileHoles(chunks, size)
+	if len(holes) != 3 || holes[0].Offset != 0 || holes[len(holes)-1].Offset+holes[len(holes)-1].Length != size {
+		t.Fatalf("unexpected holes %+v", holes)
+	}
+
+	// 同样内容的非稀疏文件切分出相同哈希的 chunk
+	want, _ := os.ReadFile(source)
+	dense := filepath.Join(tmpDir, "dense")
+	if err := os.WriteFile(dense, want, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err = os.Open(dense)
+	if err != nil {
+		t.Fatal(err)
+	}
+	denseChunks, err := builder.chunkFile(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range chunks {
+		if dc := denseChunks[c.Offset/ChunkSize]; dc.Offset != c.Offset || dc.Hash != c.Hash {
+			t.Errorf("chunk at %d differs from the dense file: %+v vs %+v", c.Offset, c, dc)
+		}
+	}
+	t.Logf("✓ 切分 %d 字节数据,跳过 %d 个空洞", stored, len(holes))
+
+	target := filepath.Join(tmpDir, "target")
+	if err := builder.reconstructFile(context.Background(), source, target, chunks, size); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(target)
+	if !bytes.Equal(got, want) {
+		t.Fatal("reconstructed sparse file does not match source")
+	}
+	if err := syscall.Stat(target, &st); err != nil || st.Blocks*512 >= size/2 {
+		t.Errorf("expected reconstructed file to stay sparse, %d blocks allocated", st.Blocks)
+	}
+	t.Logf("✓ 重建后内容一致,空洞未分配磁盘块")
+}
@@ -0,0 +1,116 @@
+package erofs
+
+// GroupUsage 是一组镜像引用的 chunk 空间。ExclusiveSize 为删除整组后可释放的字节数,
+// 即只被组内独有的镜像引用的 chunk;其余为 SharedSize
+type GroupUsage struct {
+	Chunks        int64 `json:"chunks"`
+	TotalSize     int64 `json:"total_bytes"`
+	ExclusiveSize int64 `json:"exclusive_bytes"`
+	SharedSize    int64 `json:"shared_bytes"`
+}
+
+// GroupUsage 按 chunk 引用计算每组镜像的独占和共享空间,结果与 groups 一一对应。
+// 同一镜像可以出现在多个组中(如共享的基础层),删除其中一组后它仍被保留,其 chunk 计为共享;
+// 不属于任何组的镜像同样使其引用的 chunk 成为共享
+func (c *ChunkIndexer) GroupUsage(groups [][]string) ([]GroupUsage, error) {
+	owners := make(map[string]int)
+	for _, group := range groups {
+		for _, id := range uniqueStrings(group) {
+			owners[id]++
+		}
+	}
+
+	type chunkRefs struct {
+		size   int64
+		images []string
+	}
+	chunks := make(map[string]*chunkRefs)
+	imageChunks := make(map[string][]string)
+
+	c.mu.RLock()
+	rows, err := c.db.Query(`
+		SELECT DISTINCT ic.image_id, ic.chunk_hash, c.size
+		FROM image_chunks ic JOIN chunks c ON c.hash = ic.chunk_hash
+	`)
+	if err != nil {
+		c.mu.RUnlock()
+		return nil, err
+	}
+	for rows.Next() {
+		var imageID, hash string
+		var size int64
+		if err := rows.Scan(&imageID, &hash, &size); err != nil {
+			rows.Close()
+			c.mu.RUnlock()
+			return nil, err
+		}
+		ref, ok := chunks[hash]
+		if !ok {
+			ref = &chunkRefs{size: size}
+			chunks[hash] = ref
+		}
+		ref.images = append(ref.images, imageID)
+		if owners[imageID] > 0 {
+			imageChunks[imageID] = append(imageChunks[imageID], hash)
+		}
+	}
+	err = rows.Err()
+	rows.Close()
+	c.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	usage := make([]GroupUsage, len(groups))
+	for i, group := range groups {
+		removable := make(map[string]bool)
+		for _, id := range group {
+			if owners[id] == 1 {
+				removable[id] = true
+			}
+		}
+
+		seen := make(map[string]bool)
+		for _, id := range uniqueStrings(group) {
+			for _, hash := range imageChunks[id] {
+				if seen[hash] {
+					continue
+				}
+				seen[hash] = true
+
+				ref := chunks[hash]
+				usage[i].Chunks++
+				usage[i].TotalSize += ref.size
+				exclusive := true
+				for _, img := range ref.images {
+					if !removable[img] {
+						exclusive = false
+						break
+					}
+				}
+				if exclusive {
+					usage[i].ExclusiveSize += ref.size
+				}
+			}
+		}
+		usage[i].SharedSize = usage[i].TotalSize - usage[i].ExclusiveSize
+	}
+	return usage, nil
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	unique := values[:0:0]
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
+
+// GroupUsage 返回每组镜像的独占和共享空间,见 ChunkIndexer.GroupUsage
+func (b *Builder) GroupUsage(groups [][]string) ([]GroupUsage, error) {
+	return b.indexer.GroupUsage(groups)
+}
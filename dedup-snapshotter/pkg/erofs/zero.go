@@ -0,0 +1,37 @@
+package erofs
+
+import "bytes"
+
+// 全零 chunk 不落盘也不进入 chunk 表,统一用 ZeroChunkHash 表示,读取时按 chunk 大小合成零字节。
+// 镜像中预分配的文件常含大量全零块,这部分字节计入 ChunkTierZero 分层的逻辑大小。
+// ZeroChunkHash 是 sha256("dedup-snapshotter zero chunk"),与 chunk 哈希格式相同,但不会等于任何 chunk 内容的哈希
+const (
+	ZeroChunkHash = "5283d670a3c0489846e76c5e24c65c0d80e676a3e026e1134b86721e62e493dd"
+	ChunkTierZero = "zero"
+)
+
+var zeroBlock = make([]byte, 64*1024)
+
+// isZeroChunk 判断数据是否全为零
+func isZeroChunk(data []byte) bool {
+	for len(data) > 0 {
+		n := len(data)
+		if n > len(zeroBlock) {
+			n = len(zeroBlock)
+		}
+		if !bytes.Equal(data[:n], zeroBlock[:n]) {
+			return false
+		}
+		data = data[n:]
+	}
+	return true
+}
+
+// ZeroBytes 返回所有镜像中被省略存储的全零 chunk 字节数
+func (b *Builder) ZeroBytes() (int64, error) {
+	stats, err := b.indexer.GetGlobalStats()
+	if err != nil {
+		return 0, err
+	}
+	return stats.ZeroSize, nil
+}
@@ -0,0 +1,89 @@
+package erofs
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestZeroChunkElision 验证全零 chunk 不落盘、重建时合成零字节,并计入统计
+func TestZeroChunkElision(t *testing.T) {
+	tmpDir := t.TempDir()
+	builder, err := NewBuilder(filepath.Join(tmpDir, "root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer builder.Close()
+
+	content := append(make([]byte, ChunkSize), []byte("tail")...)
+	source := filepath.Join(tmpDir, "source")
+	if err := os.WriteFile(source, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := filepath.Join(tmpDir, "target")
+	if err := builder.deduplicateFile(context.Background(), source, target, "img", info); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(target); !bytes.Equal(data, content) {
+		t.Fatal("reconstructed file does not match source")
+	}
+	if _, err := os.Stat(filepath.Join(builder.chunksDir, ZeroChunkHash)); !os.IsNotExist(err) {
+		t.Errorf("zero chunk must not be stored, stat err %v", err)
+	}
+	if !builder.HasChunk(ZeroChunkHash) {
+		t.Error("zero chunk should always be available")
+	}
+	if ZeroChunkHash == chunkHash(make([]byte, ChunkSize)) {
+		t.Error("zero chunk sentinel must differ from the hash of zero data")
+	}
+	t.Logf("✓ 全零 chunk 未落盘,重建内容一致")
+
+	order, err := builder.indexer.GetImageChunks("img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(order) != 2 || order[0] != ZeroChunkHash || order[1] != chunkHash([]byte("tail")) {
+		t.Errorf("expected the zero chunk to be recorded in order, got %v", order)
+	}
+	t.Logf("✓ 全零 chunk 按顺序记入镜像 chunk 列表")
+
+	stats, err := builder.GetChunkStats("img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.ZeroSize != ChunkSize || stats.TotalSize != int64(len(content)) || stats.TotalChunks != 2 {
+		t.Errorf("unexpected image stats %+v", stats)
+	}
+	tiers, err := builder.TierStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var zeroTier *TierStats
+	for i := range tiers {
+		if tiers[i].Tier == ChunkTierZero {
+			zeroTier = &tiers[i]
+		}
+	}
+	if zeroTier == nil || zeroTier.StoredSize != 0 || zeroTier.LogicalSize != ChunkSize {
+		t.Errorf("unexpected zero tier stats %+v", tiers)
+	}
+	t.Logf("✓ 省略 %d 字节全零数据计入统计", stats.ZeroSize)
+
+	if err := builder.RemoveImage("img"); err != nil {
+		t.Fatal(err)
+	}
+	if zero, err := builder.ZeroBytes(); err != nil || zero != 0 {
+		t.Errorf("expected zero bytes to be released with the image, got %d (%v)", zero, err)
+	}
+	if order, _ := builder.indexer.GetImageChunks("img"); len(order) != 0 {
+		t.Errorf("expected image chunks to be removed, got %v", order)
+	}
+	t.Logf("✓ 删除镜像后全零统计归零")
+}
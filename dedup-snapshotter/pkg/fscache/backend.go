@@ -3,12 +3,16 @@ package fscache
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"syscall"
 
 	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/internal/lazy"
 )
 
 const (
@@ -24,6 +28,12 @@ type Backend struct {
 	fd        int
 	mu        sync.RWMutex
 	volumes   map[string]*Volume
+	objectIDs map[uint32]objectRef
+	onEvict   func(EvictionEvent)
+	onRead    func(ReadEvent)
+	// cachefiles 按需读取的请求数和请求字节数
+	ondemandReads     atomic.Int64
+	ondemandReadBytes atomic.Int64
 }
 
 type Volume struct {
@@ -32,6 +42,8 @@ type Volume struct {
 	CookieFd  int
 	Objects   map[string]*CacheObject
 	mu        sync.RWMutex
+	// removed 为 true 时卷已被 RemoveVolume 删除,不再创建新对象
+	removed   bool
 }
 
 type CacheObject struct {
@@ -40,6 +52,13 @@ type CacheObject struct {
 	Fd        int
 	Complete  bool
 	mu        sync.Mutex
+	// ranges 是未完整的对象中已写入数据的区间,按偏移排序且互不相邻
+	ranges    []objectRange
+}
+
+type objectRange struct {
+	offset int64
+	end    int64
 }
 
 func NewBackend(root string) (*Backend, error) {
@@ -68,6 +87,7 @@ func NewBackend(root string) (*Backend, error) {
 		volumeDir: volumeDir,
 		fd:        fd,
 		volumes:   make(map[string]*Volume),
+		objectIDs: make(map[uint32]objectRef),
 	}
 
 	if err := backend.bindCache(); err != nil {
@@ -153,7 +173,7 @@ func (b *Backend) CreateVolume(ctx context.Context, volumeName string) (*Volume,
 	}
 
 	b.volumes[volumeName] = volume
-	log.L.Infof("created fscache volume: %s", volumeName)
+	log.G(ctx).Infof("created fscache volume: %s", volumeName)
 
 	return volume, nil
 }
@@ -173,6 +193,9 @@ func (v *Volume) CreateObject(ctx context.Context, key string, size int64) (*Cac
 	v.mu.Lock()
 	defer v.mu.Unlock()
 
+	if v.removed {
+		return nil, fmt.Errorf("volume %s has been removed", v.Name)
+	}
 	if obj, exists := v.Objects[key]; exists {
 		return obj, nil
 	}
@@ -197,7 +220,7 @@ func (v *Volume) CreateObject(ctx context.Context, key string, size int64) (*Cac
 	}
 
 	v.Objects[key] = obj
-	log.L.Debugf("created cache object: %s (size=%d)", key, size)
+	log.G(ctx).Debugf("created cache object: %s (size=%d)", key, size)
 
 	return obj, nil
 }
@@ -222,6 +245,73 @@ func (o *CacheObject) Write(offset int64, data []byte) (int, error) {
 	return n, nil
 }
 
+// WriteFrom 把 r 中的数据从 offset 开始流式写入缓存对象,返回写入的字节数。
+// 本地文件来源由内核直接复制,其他来源经固定大小的缓冲写入,不在内存中缓冲整个 chunk
+func (o *CacheObject) WriteFrom(offset int64, r io.Reader) (int64, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	// 复制一份 fd 交给 os.File,关闭时不影响对象自身的 fd
+	fd, err := syscall.Dup(o.Fd)
+	if err != nil {
+		return 0, fmt.Errorf("failed to dup cache object fd: %w", err)
+	}
+	f := os.NewFile(uintptr(fd), o.Key)
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to seek cache object: %w", err)
+	}
+	if s, ok := r.(*lazy.FileSection); ok {
+		r = &s.LimitedReader
+	}
+
+	n, err := f.ReadFrom(r)
+	if err != nil {
+		return n, fmt.Errorf("failed to write to cache object: %w", err)
+	}
+	return n, nil
+}
+
+// AddRange 记录对象中 [offset, offset+length) 的数据已写入
+func (o *CacheObject) AddRange(offset, length int64) {
+	if length <= 0 {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	merged := objectRange{offset: offset, end: offset + length}
+	ranges := make([]objectRange, 0, len(o.ranges)+1)
+	for _, r := range o.ranges {
+		if r.end < merged.offset || r.offset > merged.end {
+			ranges = append(ranges, r)
+			continue
+		}
+		merged.offset = min(merged.offset, r.offset)
+		merged.end = max(merged.end, r.end)
+	}
+	ranges = append(ranges, merged)
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].offset < ranges[j].offset })
+	o.ranges = ranges
+}
+
+// HasRange 判断 [offset, offset+length) 的数据是否已全部写入,完整的对象总是返回 true
+func (o *CacheObject) HasRange(offset, length int64) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.Complete {
+		return true
+	}
+	for _, r := range o.ranges {
+		if r.offset <= offset && offset+length <= r.end {
+			return true
+		}
+	}
+	return false
+}
+
 func (o *CacheObject) MarkComplete() error {
 	o.mu.Lock()
 	defer o.mu.Unlock()
@@ -265,6 +355,60 @@ func (v *Volume) Close() error {
 	return nil
 }
 
+// RemoveVolume 关闭卷的 cookie 和所有对象,删除卷目录。卷不在内存中时(如上次运行遗留)只删除目录
+func (b *Backend) RemoveVolume(volumeName string) error {
+	b.mu.Lock()
+	vol, exists := b.volumes[volumeName]
+	delete(b.volumes, volumeName)
+	for id, ref := range b.objectIDs {
+		if ref.volume == volumeName {
+			delete(b.objectIDs, id)
+		}
+	}
+	b.mu.Unlock()
+
+	if exists {
+		vol.mu.Lock()
+		vol.removed = true
+		vol.mu.Unlock()
+		if err := vol.Close(); err != nil {
+			log.L.WithError(err).Warnf("failed to close fscache volume %s", volumeName)
+		}
+	}
+
+	if err := os.RemoveAll(filepath.Join(b.volumeDir, volumeName)); err != nil {
+		return fmt.Errorf("failed to remove volume dir: %w", err)
+	}
+	log.L.Infof("removed fscache volume: %s", volumeName)
+	return nil
+}
+
+// VolumeNames 返回内存中和卷目录下的所有卷名,包括上次运行遗留、尚未重新注册的卷
+func (b *Backend) VolumeNames() ([]string, error) {
+	b.mu.RLock()
+	seen := make(map[string]bool, len(b.volumes))
+	for name := range b.volumes {
+		seen[name] = true
+	}
+	b.mu.RUnlock()
+
+	entries, err := os.ReadDir(b.volumeDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			seen[e.Name()] = true
+		}
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
 func (b *Backend) Close() error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -284,7 +428,9 @@ func (b *Backend) GetStats() *BackendStats {
 	defer b.mu.RUnlock()
 
 	stats := &BackendStats{
-		Volumes: len(b.volumes),
+		Volumes:           len(b.volumes),
+		OnDemandReads:     b.ondemandReads.Load(),
+		OnDemandReadBytes: b.ondemandReadBytes.Load(),
 	}
 
 	for _, vol := range b.volumes {
@@ -307,4 +453,6 @@ type BackendStats struct {
 	Objects         int
 	CompleteObjects int
 	TotalSize       int64
+	OnDemandReads     int64
+	OnDemandReadBytes int64
 }
@@ -1,33 +1,75 @@
 package fscache
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/internal/faultinject"
+	"github.com/opencloudos/dedup-snapshotter/internal/lazy"
+	"github.com/opencloudos/dedup-snapshotter/pkg/accounting"
+	"github.com/opencloudos/dedup-snapshotter/pkg/chunkcache"
+	"github.com/opencloudos/dedup-snapshotter/pkg/metrics"
+	"github.com/opencloudos/dedup-snapshotter/pkg/storelock"
 )
 
 type DedupDaemon struct {
 	backend       *Backend
 	root          string
 	registry      string
-	client        *http.Client
+	fetcher       Fetcher
+	// mirrors 是只读镜像服务,在镜像仓库之前尝试,也用于按哈希修复 chunk
+	mirrors       []*MirrorFetcher
 	prefetcher    *Prefetcher
 	downloadQueue chan *DownloadTask
+	// priorityQueue 中的任务(如按需读取)总是先于普通下载处理
+	priorityQueue chan *DownloadTask
+	// onDemand 对按需读取限速并在镜像间轮询,再送入 priorityQueue
+	onDemand      *OnDemandScheduler
 	workers       int
 	wg            sync.WaitGroup
 	ctx           context.Context
 	cancel        context.CancelFunc
 	mu            sync.RWMutex
 	images        map[string]*ImageInfo
+	// mounted 记录挂载中的镜像,其被淘汰的 chunk 需要重新下载
+	mounted       map[string]bool
+	evictHooks    []func(EvictionEvent)
+	// cacheLock 保证同一 root 的缓存目录只由一个守护进程绑定
+	cacheLock     *storelock.Lock
+	// tracer 为空时不记录冷启动追踪
+	tracer        *StartupTracer
+	// cachedChunks 是全局的 chunk 缓存索引:哈希 -> 完整缓存了该 chunk 的卷数,
+	// 预取时据此跳过兄弟镜像已下载的 chunk,不必逐卷检查对象
+	cachedChunks  map[string]int
+	// localChunk 判断本地 chunk 存储中是否已有该 chunk,为空时只查缓存索引
+	localChunk    func(hash string) bool
+	// httpClient 由镜像仓库和镜像服务的 fetcher 共用
+	httpClient    *http.Client
+	// negative 记录数据源确认不存在的 blob,为空时不缓存
+	negative      *NegativeCache
+	// metrics 为空时不记录各数据源的指标
+	metrics       atomic.Pointer[metrics.Metrics]
+	// chunkCache 在内存中缓存热点 chunk,兄弟镜像的卷可直接从中填充,为空时不缓存
+	chunkCache    *chunkcache.Cache
+	// ledger 按镜像记录下载和从缓存提供的字节数,为空时不记录
+	ledger        *accounting.Ledger
+	// readFailures 记录挂载中镜像的连续下载失败次数,见 fallback.go
+	readFailures  map[string]int
+	failureThreshold int
+	failureHooks  []func(ReadFailureEvent)
 }
 
 type ImageInfo struct {
@@ -40,6 +82,22 @@ type ImageInfo struct {
 type ImageManifest struct {
 	Layers    []*LayerInfo
 	TotalSize int64
+	// Chunks 按 chunk 哈希索引其在层 blob 中的位置
+	Chunks map[string]*ChunkLocation
+}
+
+type ChunkLocation struct {
+	LayerDigest string
+	Offset      int64
+	Size        int64
+	// Files 是引用该 chunk 的文件区间,预取过滤据此判断 chunk 属于哪些文件
+	Files []ChunkFileRef
+}
+
+// ChunkFileRef 是引用 chunk 的文件及 chunk 在文件中的偏移
+type ChunkFileRef struct {
+	Path       string
+	FileOffset int64
 }
 
 type LayerInfo struct {
@@ -60,31 +118,49 @@ type DownloadTask struct {
 }
 
 func NewDedupDaemon(root, registry string, workers int) (*DedupDaemon, error) {
+	cacheLock, err := storelock.Acquire(root, storelock.Fscache, filepath.Base(os.Args[0]))
+	if err != nil {
+		return nil, fmt.Errorf("fscache cache of %s is served elsewhere: %w", root, err)
+	}
+
 	backend, err := NewBackend(root)
 	if err != nil {
+		cacheLock.Release()
 		return nil, fmt.Errorf("failed to create fscache backend: %w", err)
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
+	httpClient := &http.Client{Timeout: 30 * time.Second}
 
 	daemon := &DedupDaemon{
 		backend:       backend,
 		root:          root,
 		registry:      registry,
-		client:        &http.Client{Timeout: 30 * time.Second},
+		httpClient:    httpClient,
 		downloadQueue: make(chan *DownloadTask, 10000),
+		priorityQueue: make(chan *DownloadTask, 1000),
 		workers:       workers,
 		ctx:           ctx,
 		cancel:        cancel,
 		images:        make(map[string]*ImageInfo),
+		mounted:       make(map[string]bool),
+		cacheLock:     cacheLock,
+		cachedChunks:  make(map[string]int),
 	}
+	daemon.fetcher = daemon.observe(BackendRegistry, registry, NewRegistryFetcher(registry, httpClient))
+	backend.SetEvictionHandler(daemon.handleEviction)
+	backend.SetReadHandler(daemon.handleRead)
 
 	prefetcher, err := NewPrefetcher(daemon)
 	if err != nil {
 		backend.Close()
+		cacheLock.Release()
 		return nil, err
 	}
 	daemon.prefetcher = prefetcher
+	daemon.onDemand = NewOnDemandScheduler(daemon.priorityQueue, 0, 0, 0)
+	go daemon.onDemand.Run(ctx)
+	go backend.RunEvents(ctx)
 
 	daemon.startWorkers()
 
@@ -105,22 +181,30 @@ func (d *DedupDaemon) downloadWorker(id int) {
 	log.L.Infof("download worker %d started", id)
 
 	for {
+		var task *DownloadTask
 		select {
-		case <-d.ctx.Done():
-			log.L.Infof("download worker %d stopped", id)
-			return
-
-		case task := <-d.downloadQueue:
-			if task == nil {
+		case task = <-d.priorityQueue:
+		default:
+			select {
+			case <-d.ctx.Done():
+				log.L.Infof("download worker %d stopped", id)
 				return
+			case task = <-d.priorityQueue:
+			case task = <-d.downloadQueue:
 			}
+		}
 
-			if err := d.processDownloadTask(task); err != nil {
-				log.L.WithError(err).Warnf("worker %d failed to process task: %s", id, task.ChunkHash)
-			} else {
-				log.L.Debugf("worker %d completed task: %s", id, task.ChunkHash)
-			}
+		if task == nil {
+			return
 		}
+
+		err := d.processDownloadTask(task)
+		if err != nil {
+			log.L.WithError(err).Warnf("worker %d failed to process task: %s", id, task.ChunkHash)
+		} else {
+			log.L.Debugf("worker %d completed task: %s", id, task.ChunkHash)
+		}
+		d.recordReadResult(task.ImageID, err)
 	}
 }
 
@@ -128,6 +212,7 @@ func (d *DedupDaemon) processDownloadTask(task *DownloadTask) error {
 	obj, exists := task.Volume.GetObject(task.ChunkHash)
 	if exists && obj.Complete {
 		log.L.Debugf("chunk already cached: %s", task.ChunkHash)
+		d.observeImageRead(task.ImageID, true)
 		return nil
 	}
 
@@ -139,56 +224,262 @@ func (d *DedupDaemon) processDownloadTask(task *DownloadTask) error {
 		}
 	}
 
-	data, err := d.fetchChunkData(task.ImageID, task.LayerDigest, task.Offset, task.Size)
-	if err != nil {
-		return fmt.Errorf("failed to fetch chunk: %w", err)
-	}
+	d.mu.RLock()
+	cache := d.chunkCache
+	ledger := d.ledger
+	d.mu.RUnlock()
 
-	if _, err := obj.Write(0, data); err != nil {
+	var body io.ReadCloser
+	var captured *bytes.Buffer
+	var data []byte
+	var hit bool
+	data, hit = cache.Get(task.ChunkHash)
+	if hit {
+		body = io.NopCloser(bytes.NewReader(data))
+	} else {
+		var err error
+		body, err = d.fetchChunkStream(task.ImageID, task.LayerDigest, task.Offset, task.Size)
+		if err != nil {
+			return fmt.Errorf("failed to fetch chunk: %w", err)
+		}
+		if cache != nil {
+			captured = bytes.NewBuffer(make([]byte, 0, task.Size))
+			body = &teeReadCloser{Reader: io.TeeReader(body, captured), Closer: body}
+		}
+	}
+	written, err := obj.WriteFrom(0, body)
+	body.Close()
+	if err != nil {
 		return fmt.Errorf("failed to write to cache: %w", err)
 	}
+	if written == 0 {
+		return fmt.Errorf("empty response for chunk %s", task.ChunkHash)
+	}
 
 	if err := obj.MarkComplete(); err != nil {
 		return fmt.Errorf("failed to mark complete: %w", err)
 	}
+	d.mu.Lock()
+	d.cachedChunks[task.ChunkHash]++
+	d.mu.Unlock()
+	if captured != nil && int64(captured.Len()) == written {
+		cache.Add(task.ChunkHash, captured.Bytes())
+	}
+
+	if hit {
+		ledger.AddCacheServed(task.ImageID, written)
+	} else {
+		ledger.AddDownloaded(task.ImageID, written)
+	}
+	d.observeImageRead(task.ImageID, hit)
+
+	if tracer := d.startupTracer(); tracer != nil && !hit {
+		tracer.RecordFetch(task.ImageID, written)
+	}
 
-	log.L.Debugf("downloaded and cached chunk: %s (size=%d)", task.ChunkHash, len(data))
+	log.L.Debugf("downloaded and cached chunk: %s (size=%d)", task.ChunkHash, written)
 	return nil
 }
 
-func (d *DedupDaemon) fetchChunkData(imageID, layerDigest string, offset, size int64) ([]byte, error) {
-	url := fmt.Sprintf("%s/v2/%s/blobs/%s", d.registry, imageID, layerDigest)
+type teeReadCloser struct {
+	io.Reader
+	io.Closer
+}
 
-	req, err := http.NewRequestWithContext(d.ctx, "GET", url, nil)
-	if err != nil {
+// SetChunkCache 设置 chunk 的内存缓存,下载的共享 chunk 写入缓存,其他卷需要同一 chunk 时直接从内存填充
+func (d *DedupDaemon) SetChunkCache(c *chunkcache.Cache) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.chunkCache = c
+}
+
+// SetLedger 设置流量分摊账本,按镜像记录下载和从内存缓存填充的字节数
+func (d *DedupDaemon) SetLedger(l *accounting.Ledger) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.ledger = l
+}
+
+// fetchChunkStream 打开块数据的流,数据源支持流式读取时不在内存中缓冲整个 chunk
+func (d *DedupDaemon) fetchChunkStream(imageID, layerDigest string, offset, size int64) (io.ReadCloser, error) {
+	d.mu.RLock()
+	fetcher := d.fetcher
+	d.mu.RUnlock()
+
+	if err := faultinject.Inject(faultinject.SlowChunk); err != nil {
 		return nil, err
 	}
 
-	rangeHeader := fmt.Sprintf("bytes=%d-%d", offset, offset+size-1)
-	req.Header.Set("Range", rangeHeader)
+	return fetchStream(d.ctx, fetcher, imageID, layerDigest, offset, size)
+}
 
-	resp, err := d.client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("http request failed: %w", err)
+// FetchChunk 在已注册镜像的清单中查找 chunk 所在的层并重新下载,用于修复损坏的本地 chunk
+func (d *DedupDaemon) FetchChunk(ctx context.Context, chunkHash string) ([]byte, error) {
+	d.mu.RLock()
+	var imageID string
+	var loc *ChunkLocation
+	for id, info := range d.images {
+		if info.Manifest == nil {
+			continue
+		}
+		if l, ok := info.Manifest.Chunks[chunkHash]; ok {
+			imageID, loc = id, l
+			break
+		}
+	}
+	fetcher := d.fetcher
+	d.mu.RUnlock()
+
+	if loc == nil {
+		if data, ok := d.fetchFromMirrors(ctx, chunkHash); ok {
+			return data, nil
+		}
+		return nil, fmt.Errorf("chunk %s not found in any registered image", chunkHash)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	if err := faultinject.Inject(faultinject.SlowChunk); err != nil {
+		return nil, err
+	}
+	return fetcher.Fetch(ctx, imageID, loc.LayerDigest, loc.Offset, loc.Size)
+}
+
+// LocateChunk 返回已注册镜像中包含该 chunk 的层位置
+func (d *DedupDaemon) LocateChunk(chunkHash string) (*ChunkLocation, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	for _, info := range d.images {
+		if info.Manifest == nil {
+			continue
+		}
+		if loc, ok := info.Manifest.Chunks[chunkHash]; ok {
+			return loc, true
+		}
 	}
+	return nil, false
+}
 
-	data, err := io.ReadAll(resp.Body)
+// ImagesWithLayer 返回包含指定层 blob 的已注册镜像
+func (d *DedupDaemon) ImagesWithLayer(layerDigest string) []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	var ids []string
+	for id, info := range d.images {
+		if info.Manifest == nil {
+			continue
+		}
+		for _, layer := range info.Manifest.Layers {
+			if layer.Digest == layerDigest {
+				ids = append(ids, id)
+				break
+			}
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// SetTransport 替换镜像仓库和镜像服务请求使用的 RoundTripper,需在开始下载前调用
+func (d *DedupDaemon) SetTransport(rt http.RoundTripper) {
+	d.httpClient.Transport = rt
+}
+
+// SetNegativeCache 让镜像仓库和镜像服务在 TTL 内跳过已确认不存在的 blob 和 chunk
+func (d *DedupDaemon) SetNegativeCache(c *NegativeCache) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.negative = c
+	setNegativeCache(d.fetcher, c)
+}
+
+func setNegativeCache(f Fetcher, c *NegativeCache) {
+	switch f := f.(type) {
+	case *RegistryFetcher:
+		f.SetNegativeCache(c)
+	case *MirrorFetcher:
+		f.SetNegativeCache(c)
+	case ChainFetcher:
+		for _, sub := range f {
+			setNegativeCache(sub, c)
+		}
+	case *observedFetcher:
+		setNegativeCache(f.Fetcher, c)
+	}
+}
+
+// UseMirrors 在镜像仓库之前依次尝试只读镜像服务。需在 UseContentStore 之前调用,
+// 以保持本地 content store、镜像服务、镜像仓库的顺序
+func (d *DedupDaemon) UseMirrors(urls []string) {
+	if len(urls) == 0 {
+		return
+	}
+	d.mu.Lock()
+	chain := make(ChainFetcher, 0, len(urls)+1)
+	for _, url := range urls {
+		m := NewMirrorFetcher(url, d.httpClient)
+		m.SetNegativeCache(d.negative)
+		d.mirrors = append(d.mirrors, m)
+		chain = append(chain, d.observe(BackendMirror, m.url, m))
+	}
+	d.fetcher = append(chain, d.fetcher)
+	d.mu.Unlock()
+
+	log.L.Infof("dedupd fetching from mirrors %v before the registry", urls)
+}
+
+// fetchFromMirrors 按哈希从镜像服务读取清单中没有记录的 chunk,并校验内容
+func (d *DedupDaemon) fetchFromMirrors(ctx context.Context, chunkHash string) ([]byte, bool) {
+	d.mu.RLock()
+	mirrors := d.mirrors
+	d.mu.RUnlock()
+
+	for _, m := range mirrors {
+		start := time.Now()
+		data, err := m.FetchChunk(ctx, chunkHash)
+		d.observeBackend(BackendMirror, m.url, int64(len(data)), err, start)
+		if err != nil {
+			if !errors.Is(err, ErrBlobNotFound) {
+				log.G(ctx).WithError(err).Warnf("failed to fetch chunk %s from mirror %s", chunkHash, m.url)
+			}
+			continue
+		}
+		if d.ComputeChunkHash(data) != chunkHash {
+			log.G(ctx).Warnf("mirror %s returned corrupt chunk %s", m.url, chunkHash)
+			continue
+		}
+		return data, true
+	}
+	return nil, false
+}
+
+// UseContentStore 优先从 containerd content store 中已有的层 blob 读取块数据,
+// 本地不存在时再回退到镜像仓库
+func (d *DedupDaemon) UseContentStore(root string) error {
+	csFetcher, err := lazy.NewContentStoreFetcher(root)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return err
 	}
 
-	return data, nil
+	d.mu.Lock()
+	d.fetcher = ChainFetcher{d.observe(BackendContentStore, BackendContentStore, csFetcher), d.fetcher}
+	d.mu.Unlock()
+
+	log.L.Infof("dedupd read-through enabled for content store %s", root)
+	return nil
 }
 
 func (d *DedupDaemon) RegisterImage(ctx context.Context, imageID string, manifestPath string) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
+	// 重新注册的镜像可能已推送到仓库,之前的不存在结果不再可信
+	if d.negative != nil {
+		if n := d.negative.InvalidateImage(imageID); n > 0 {
+			log.G(ctx).Debugf("dropped %d negative lookups of %s", n, imageID)
+		}
+	}
+
 	if _, exists := d.images[imageID]; exists {
 		return nil
 	}
@@ -211,37 +502,197 @@ func (d *DedupDaemon) RegisterImage(ctx context.Context, imageID string, manifes
 
 	d.images[imageID] = imageInfo
 
-	log.L.Infof("registered image %s with %d layers", imageID, len(manifest.Layers))
+	log.G(ctx).Infof("registered image %s with %d layers", imageID, len(manifest.Layers))
 	return nil
 }
 
-func (d *DedupDaemon) loadManifest(manifestPath string) (*ImageManifest, error) {
-	data, err := os.ReadFile(manifestPath)
+// UnregisterImage 注销镜像:停止预取,释放其缓存对象计数,关闭卷的 cookie 并删除卷目录。
+// 未注册的镜像只删除遗留的卷目录
+func (d *DedupDaemon) UnregisterImage(ctx context.Context, imageID string) error {
+	d.mu.Lock()
+	info, exists := d.images[imageID]
+	delete(d.images, imageID)
+	delete(d.mounted, imageID)
+	d.mu.Unlock()
+
+	if exists {
+		if d.prefetcher != nil {
+			d.prefetcher.StopPrefetch(imageID)
+		}
+
+		var complete []string
+		info.Volume.mu.RLock()
+		for key, obj := range info.Volume.Objects {
+			if obj.Complete && key != ImageObjectKey(imageID) {
+				complete = append(complete, key)
+			}
+		}
+		info.Volume.mu.RUnlock()
+
+		d.mu.Lock()
+		for _, key := range complete {
+			if n := d.cachedChunks[key]; n > 1 {
+				d.cachedChunks[key] = n - 1
+			} else {
+				delete(d.cachedChunks, key)
+			}
+		}
+		d.mu.Unlock()
+	}
+
+	if err := d.backend.RemoveVolume(imageID); err != nil {
+		return fmt.Errorf("failed to remove volume of %s: %w", imageID, err)
+	}
+	if exists {
+		log.G(ctx).Infof("unregistered image %s", imageID)
+	}
+	return nil
+}
+
+// CleanupVolumes 删除 inUse 返回 false 且未挂载的卷,包括已注册的镜像和上次运行遗留的卷目录,返回删除的卷数
+func (d *DedupDaemon) CleanupVolumes(ctx context.Context, inUse func(imageID string) bool) (int, error) {
+	return d.CleanupVolumesWithThrottle(ctx, inUse, nil)
+}
+
+// CleanupVolumesWithThrottle 同 CleanupVolumes,每删除一个卷后调用 throttle,throttle 返回错误时停止
+func (d *DedupDaemon) CleanupVolumesWithThrottle(ctx context.Context, inUse func(imageID string) bool, throttle func(context.Context) error) (int, error) {
+	names, err := d.backend.VolumeNames()
 	if err != nil {
-		return nil, err
+		return 0, fmt.Errorf("failed to list volumes: %w", err)
 	}
 
-	manifest := &ImageManifest{
-		Layers: make([]*LayerInfo, 0),
+	removed := 0
+	for _, name := range names {
+		if d.IsMounted(name) || inUse(name) {
+			continue
+		}
+		if err := d.UnregisterImage(ctx, name); err != nil {
+			log.G(ctx).WithError(err).Warnf("failed to clean up orphan volume %s", name)
+			continue
+		}
+		removed++
+		if throttle != nil {
+			if err := throttle(ctx); err != nil {
+				return removed, err
+			}
+		}
 	}
+	return removed, nil
+}
 
-	var currentOffset int64
-	lines := string(data)
-	for _, line := range splitLines(lines) {
-		if line == "" {
+// EvictColdVolumes 丢弃未挂载镜像下载到卷中的数据以释放磁盘空间:镜像保持注册,卷重建为空,
+// 之后挂载时重新按需下载。已经为空的卷跳过,返回清空的卷数
+func (d *DedupDaemon) EvictColdVolumes(ctx context.Context) (int, error) {
+	d.mu.RLock()
+	var cold []*ImageInfo
+	for id, info := range d.images {
+		if !d.mounted[id] {
+			cold = append(cold, info)
+		}
+	}
+	d.mu.RUnlock()
+
+	evicted := 0
+	for _, info := range cold {
+		if volumeEmpty(info.Volume) {
 			continue
 		}
+		if err := d.UnregisterImage(ctx, info.ImageID); err != nil {
+			log.G(ctx).WithError(err).Warnf("failed to evict volume of %s", info.ImageID)
+			continue
+		}
+		volume, err := d.backend.CreateVolume(ctx, info.ImageID)
+		if err != nil {
+			return evicted, fmt.Errorf("failed to recreate volume of %s: %w", info.ImageID, err)
+		}
+		d.mu.Lock()
+		if _, exists := d.images[info.ImageID]; !exists {
+			d.images[info.ImageID] = &ImageInfo{ImageID: info.ImageID, Volume: volume, Manifest: info.Manifest}
+		}
+		d.mu.Unlock()
+		evicted++
+	}
+	return evicted, nil
+}
+
+// volumeEmpty 报告卷中既没有本次运行创建的对象,卷目录下也没有上次运行留下的数据
+func volumeEmpty(v *Volume) bool {
+	v.mu.RLock()
+	n := len(v.Objects)
+	v.mu.RUnlock()
+	if n > 0 {
+		return false
+	}
+	entries, err := os.ReadDir(v.Path)
+	return err != nil || len(entries) == 0
+}
+
+// PausePrefetch 暂停(paused 为 true)或恢复所有预取任务
+func (d *DedupDaemon) PausePrefetch(paused bool) {
+	if d.prefetcher == nil {
+		return
+	}
+	if paused {
+		d.prefetcher.Pause()
+	} else {
+		d.prefetcher.Resume()
+	}
+}
+
+// RunVolumeGC 每 interval 清理一次孤儿卷,直到守护进程关闭,throttle 见 CleanupVolumesWithThrottle
+func (d *DedupDaemon) RunVolumeGC(interval time.Duration, inUse func(imageID string) bool, throttle func(context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := d.CleanupVolumesWithThrottle(d.ctx, inUse, throttle)
+			if err != nil {
+				log.L.WithError(err).Warn("fscache volume gc failed")
+			} else if n > 0 {
+				log.L.Infof("removed %d orphan fscache volumes", n)
+			}
+		}
+	}
+}
+
+// loadManifest 读取 LayerProcessor 生成的层清单,建立 chunk 哈希到层 blob 偏移的索引
+func (d *DedupDaemon) loadManifest(manifestPath string) (*ImageManifest, error) {
+	layerManifest, err := LoadLayerManifest(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+
+	layer := &LayerInfo{
+		Digest: layerManifest.Digest,
+	}
+	manifest := &ImageManifest{
+		Layers: []*LayerInfo{layer},
+		Chunks: make(map[string]*ChunkLocation),
+	}
 
-		layer := &LayerInfo{
-			Digest: line,
-			Offset: currentOffset,
-			Size:   4 * 1024 * 1024,
+	for _, file := range layerManifest.Files {
+		for _, chunk := range file.Chunks {
+			ref := ChunkFileRef{Path: file.Path, FileOffset: chunk.FileOffset}
+			if loc, exists := manifest.Chunks[chunk.Hash]; exists {
+				loc.Files = append(loc.Files, ref)
+				continue
+			}
+			manifest.Chunks[chunk.Hash] = &ChunkLocation{
+				LayerDigest: layerManifest.Digest,
+				Offset:      chunk.BlobOffset,
+				Size:        chunk.Size,
+				Files:       []ChunkFileRef{ref},
+			}
+			layer.ChunkHashes = append(layer.ChunkHashes, chunk.Hash)
+			layer.Size += chunk.Size
 		}
-		manifest.Layers = append(manifest.Layers, layer)
-		currentOffset += layer.Size
 	}
 
-	manifest.TotalSize = currentOffset
+	manifest.TotalSize = layer.Size
 	return manifest, nil
 }
 
@@ -261,6 +712,35 @@ func splitLines(s string) []string {
 }
 
 func (d *DedupDaemon) StartPrefetch(ctx context.Context, imageID string, traceFile string) error {
+	return d.StartPrefetchWithFilter(ctx, imageID, traceFile, nil)
+}
+
+// StartPrefetchWithFilter 按 trace 预取镜像,filter 不为 nil 时只预取通过过滤的 chunk
+func (d *DedupDaemon) StartPrefetchWithFilter(ctx context.Context, imageID string, traceFile string, filter *PrefetchFilter) error {
+	d.mu.RLock()
+	imageInfo, exists := d.images[imageID]
+	d.mu.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("image not registered: %s", imageID)
+	}
+
+	return d.prefetcher.StartPrefetchWithFilter(ctx, imageInfo, traceFile, filter)
+}
+
+// PrefetchStatuses 返回进行中的预取任务状态
+func (d *DedupDaemon) PrefetchStatuses() []*PrefetchStatus {
+	return d.prefetcher.GetAllJobStatuses()
+}
+
+// SetOnDemandLimits 设置按需读取的全局和单镜像限速(字节/秒),0 表示不限速
+func (d *DedupDaemon) SetOnDemandLimits(globalRate, imageRate, burst int64) {
+	d.onDemand.SetLimits(globalRate, imageRate, burst)
+	log.L.Infof("on-demand read limits: global=%d B/s, per-image=%d B/s, burst=%d B", globalRate, imageRate, burst)
+}
+
+// RequestChunk 处理容器读取到未缓存 chunk 时的按需请求,经限速调度后由下载 worker 拉取
+func (d *DedupDaemon) RequestChunk(imageID, chunkHash string) error {
 	d.mu.RLock()
 	imageInfo, exists := d.images[imageID]
 	d.mu.RUnlock()
@@ -269,7 +749,23 @@ func (d *DedupDaemon) StartPrefetch(ctx context.Context, imageID string, traceFi
 		return fmt.Errorf("image not registered: %s", imageID)
 	}
 
-	return d.prefetcher.StartPrefetch(ctx, imageInfo, traceFile)
+	loc, ok := imageInfo.Manifest.Chunks[chunkHash]
+	if !ok {
+		return fmt.Errorf("chunk %s not found in manifest of %s", chunkHash, imageID)
+	}
+	if obj, ok := imageInfo.Volume.GetObject(chunkHash); ok && obj.Complete {
+		return nil
+	}
+
+	return d.onDemand.Submit(&DownloadTask{
+		ImageID:     imageID,
+		LayerDigest: loc.LayerDigest,
+		ChunkHash:   chunkHash,
+		Offset:      loc.Offset,
+		Size:        loc.Size,
+		Priority:    500,
+		Volume:      imageInfo.Volume,
+	})
 }
 
 func (d *DedupDaemon) EnqueueDownload(task *DownloadTask) {
@@ -282,6 +778,185 @@ func (d *DedupDaemon) EnqueueDownload(task *DownloadTask) {
 	}
 }
 
+// ImageObjectKey 返回内核读取镜像 blob 时打开的缓存对象的键。EROFS 以 fsid 作为镜像 blob 的 cookie 名,
+// 快照服务挂载时 fsid 即镜像 ID
+func ImageObjectKey(imageID string) string {
+	return imageID
+}
+
+// WarmImageRanges 把本地镜像文件中 offsets 处长度为 chunkSize 的区间写入镜像 blob 缓存对象的相同偏移,
+// 返回写入的字节数。内核按需读取这些区间时直接完成,不再等待下载。
+// 用于在挂载时写入超级块、inode 表和目录块,不依赖 trace 预取是否完成
+func (d *DedupDaemon) WarmImageRanges(imageID, imagePath string, offsets []int64, chunkSize int64) (int64, error) {
+	d.mu.RLock()
+	imageInfo, exists := d.images[imageID]
+	d.mu.RUnlock()
+
+	if !exists {
+		return 0, fmt.Errorf("image not registered: %s", imageID)
+	}
+
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	key := ImageObjectKey(imageID)
+	obj, ok := imageInfo.Volume.GetObject(key)
+	if !ok {
+		if obj, err = imageInfo.Volume.CreateObject(d.ctx, key, st.Size()); err != nil {
+			return 0, fmt.Errorf("failed to create cache object: %w", err)
+		}
+	}
+
+	var warmed int64
+	for _, offset := range offsets {
+		length := min(chunkSize, st.Size()-offset)
+		if length <= 0 || obj.HasRange(offset, length) {
+			continue
+		}
+		n, err := obj.WriteFrom(offset, io.NewSectionReader(f, offset, length))
+		if err != nil {
+			return warmed, err
+		}
+		obj.AddRange(offset, n)
+		warmed += n
+	}
+	return warmed, nil
+}
+
+// SetMounted 标记镜像是否处于挂载状态
+func (d *DedupDaemon) SetMounted(imageID string, mounted bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if mounted {
+		d.mounted[imageID] = true
+	} else {
+		delete(d.mounted, imageID)
+		delete(d.readFailures, imageID)
+	}
+}
+
+// IsMounted 返回镜像是否处于挂载状态
+func (d *DedupDaemon) IsMounted(imageID string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.mounted[imageID]
+}
+
+// OnEviction 注册缓存对象失效后的回调,在 daemon 自身处理之后调用
+func (d *DedupDaemon) OnEviction(fn func(EvictionEvent)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.evictHooks = append(d.evictHooks, fn)
+}
+
+// handleEviction 在挂载中镜像的数据 chunk 被淘汰后重新按需下载;
+// 镜像 blob 的缓存对象不在清单中,由回调方重新预热
+func (d *DedupDaemon) handleEviction(e EvictionEvent) {
+	d.mu.Lock()
+	if n := d.cachedChunks[e.Key]; n > 1 {
+		d.cachedChunks[e.Key] = n - 1
+	} else {
+		delete(d.cachedChunks, e.Key)
+	}
+	mounted := d.mounted[e.Volume]
+	hooks := d.evictHooks
+	d.mu.Unlock()
+
+	if mounted && e.Key != ImageObjectKey(e.Volume) {
+		if err := d.RequestChunk(e.Volume, e.Key); err != nil {
+			log.L.WithError(err).Debugf("failed to re-enqueue evicted chunk %s of %s", e.Key, e.Volume)
+		}
+	}
+
+	for _, fn := range hooks {
+		fn(e)
+	}
+}
+
+// SetChunkLookup 设置本地 chunk 存储的查询,存储中已有的 chunk 不再预取
+func (d *DedupDaemon) SetChunkLookup(fn func(hash string) bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.localChunk = fn
+}
+
+// ChunkPresent 判断 chunk 是否已被任一已注册镜像完整缓存或已在本地 chunk 存储中
+func (d *DedupDaemon) ChunkPresent(hash string) bool {
+	d.mu.RLock()
+	cached := d.cachedChunks[hash] > 0
+	local := d.localChunk
+	d.mu.RUnlock()
+
+	return cached || (local != nil && local(hash))
+}
+
+// SetTracer 设置冷启动追踪器,按需读取和下载会记到引用该镜像的活动追踪上
+func (d *DedupDaemon) SetTracer(t *StartupTracer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.tracer = t
+}
+
+func (d *DedupDaemon) startupTracer() *StartupTracer {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.tracer
+}
+
+// handleRead 在读取的区间已写入缓存对象(如预热的元数据)时直接完成读取,否则记为一次按需读取
+func (d *DedupDaemon) handleRead(e ReadEvent) {
+	if vol, err := d.backend.GetVolume(e.Volume); err == nil {
+		if obj, ok := vol.GetObject(e.Key); ok && obj.HasRange(e.Offset, e.Length) {
+			if err := d.backend.CompleteRead(e); err != nil {
+				log.L.WithError(err).Debug("failed to complete cached read")
+			}
+			d.mu.RLock()
+			ledger := d.ledger
+			d.mu.RUnlock()
+			ledger.AddCacheServed(e.Volume, e.Length)
+			return
+		}
+	}
+
+	if tracer := d.startupTracer(); tracer != nil {
+		tracer.RecordFault(e.Volume, e.Length)
+	}
+}
+
+// CachedFraction 返回镜像清单中已完整缓存的字节比例(0-1),未注册的镜像按未缓存计
+func (d *DedupDaemon) CachedFraction(imageIDs []string) float64 {
+	d.mu.RLock()
+	infos := make([]*ImageInfo, 0, len(imageIDs))
+	for _, id := range imageIDs {
+		if info, ok := d.images[id]; ok && info.Manifest != nil {
+			infos = append(infos, info)
+		}
+	}
+	d.mu.RUnlock()
+
+	var cached, total int64
+	for _, info := range infos {
+		for hash, loc := range info.Manifest.Chunks {
+			total += loc.Size
+			if obj, ok := info.Volume.GetObject(hash); ok && obj.Complete {
+				cached += loc.Size
+			}
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(cached) / float64(total)
+}
+
 func (d *DedupDaemon) GetImageVolume(imageID string) (*Volume, error) {
 	d.mu.RLock()
 	defer d.mu.RUnlock()
@@ -318,12 +993,17 @@ func (d *DedupDaemon) GetStats() *DaemonStats {
 		QueueDepth:   len(d.downloadQueue),
 		BackendStats: d.backend.GetStats(),
 	}
+	if d.negative != nil {
+		negative := d.negative.Stats()
+		stats.NegativeCache = &negative
+	}
+	stats.Kernel = d.KernelCacheStats()
 
 	return stats
 }
 
 func (d *DedupDaemon) Shutdown(ctx context.Context) error {
-	log.L.Info("shutting down dedupd daemon")
+	log.G(ctx).Info("shutting down dedupd daemon")
 
 	d.cancel()
 
@@ -334,6 +1014,7 @@ func (d *DedupDaemon) Shutdown(ctx context.Context) error {
 	if d.prefetcher != nil {
 		d.prefetcher.Stop()
 	}
+	defer d.cacheLock.Release()
 
 	if d.backend != nil {
 		return d.backend.Close()
@@ -343,7 +1024,9 @@ func (d *DedupDaemon) Shutdown(ctx context.Context) error {
 }
 
 type DaemonStats struct {
-	Images       int
-	QueueDepth   int
-	BackendStats *BackendStats
+	Images        int
+	QueueDepth    int
+	BackendStats  *BackendStats
+	NegativeCache *NegativeCacheStats
+	Kernel        *metrics.KernelCacheStats
 }
@@ -0,0 +1,178 @@
+package fscache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/accounting"
+)
+
+// TestCleanupVolumes 验证注销镜像会删除卷目录和缓存计数,挂载中和仍在使用的卷被保留
+func TestCleanupVolumes(t *testing.T) {
+	volumeDir := t.TempDir()
+	for _, name := range []string{"removed", "mounted", "live", "leftover"} {
+		if err := os.MkdirAll(filepath.Join(volumeDir, name), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed := &Volume{Name: "removed", Objects: map[string]*CacheObject{
+		"chunk-a": {Key: "chunk-a", Fd: -1, Complete: true},
+	}}
+	b := &Backend{
+		fd:        -1,
+		volumeDir: volumeDir,
+		volumes:   map[string]*Volume{"removed": removed},
+		objectIDs: map[uint32]objectRef{7: {volume: "removed", key: "chunk-a"}},
+	}
+	d := &DedupDaemon{
+		backend:      b,
+		images:       map[string]*ImageInfo{"removed": {ImageID: "removed", Volume: removed}},
+		mounted:      map[string]bool{"mounted": true},
+		cachedChunks: map[string]int{"chunk-a": 1},
+	}
+
+	n, err := d.CleanupVolumes(context.Background(), func(name string) bool { return name == "live" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 volumes removed, got %d", n)
+	}
+
+	names, err := b.VolumeNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	if len(names) != 2 || names[0] != "live" || names[1] != "mounted" {
+		t.Errorf("unexpected remaining volumes %v", names)
+	}
+	if len(d.images) != 0 || len(d.cachedChunks) != 0 || len(b.objectIDs) != 0 {
+		t.Errorf("state not released: images=%v cached=%v objects=%v", d.images, d.cachedChunks, b.objectIDs)
+	}
+	if _, err := removed.CreateObject(context.Background(), "chunk-b", 1); err == nil {
+		t.Error("expected removed volume to reject new objects")
+	}
+	t.Logf("✓ 清理了 %d 个孤儿卷,保留 %v", n, names)
+}
+
+// TestReadFailureThreshold 验证挂载中镜像连续下载失败达到阈值时只通知一次,
+// 成功或卸载后重新计数,未挂载镜像的失败不计入
+func TestReadFailureThreshold(t *testing.T) {
+	d := &DedupDaemon{mounted: map[string]bool{"app": true}}
+	d.SetReadFailureThreshold(3)
+	var events []ReadFailureEvent
+	d.OnReadFailure(func(e ReadFailureEvent) { events = append(events, e) })
+
+	boom := errors.New("backend unavailable")
+	d.recordReadResult("app", boom)
+	d.recordReadResult("app", boom)
+	d.recordReadResult("app", nil)
+	d.recordReadResult("app", boom)
+	d.recordReadResult("app", boom)
+	if len(events) != 0 {
+		t.Fatalf("success must reset the failure count, got %+v", events)
+	}
+	for i := 0; i < 3; i++ {
+		d.recordReadResult("app", boom)
+	}
+	if len(events) != 1 || events[0].Volume != "app" || events[0].Failures != 3 || events[0].Err != boom {
+		t.Fatalf("expected a single failure event, got %+v", events)
+	}
+	t.Logf("✓ 连续失败 %d 次时通知一次", events[0].Failures)
+
+	d.SetMounted("app", false)
+	for i := 0; i < 5; i++ {
+		d.recordReadResult("app", boom)
+		d.recordReadResult("prefetch-only", boom)
+	}
+	if len(events) != 1 {
+		t.Errorf("failures of unmounted images must not be reported, got %+v", events)
+	}
+	t.Logf("✓ 未挂载镜像的下载失败不计入")
+}
+
+type countingFetcher struct {
+	calls atomic.Int32
+}
+
+func (f *countingFetcher) Fetch(ctx context.Context, imageID, layerDigest string, offset, size int64) ([]byte, error) {
+	f.calls.Add(1)
+	return nil, errors.New("unexpected fetch")
+}
+
+// TestWarmedRangeServedWithoutFetch 验证预热写入的是内核读取的镜像对象,
+// 读取已预热的范围直接由缓存完成,不计为缺页也不触发下载
+func TestWarmedRangeServedWithoutFetch(t *testing.T) {
+	dir := t.TempDir()
+	image := make([]byte, 3*4096)
+	for i := range image {
+		image[i] = byte(i % 251)
+	}
+	imagePath := filepath.Join(dir, "image.erofs")
+	if err := os.WriteFile(imagePath, image, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := os.OpenFile(filepath.Join(dir, "object"), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	vol := &Volume{Name: "app", Objects: map[string]*CacheObject{
+		ImageObjectKey("app"): {Key: ImageObjectKey("app"), Fd: int(cache.Fd())},
+	}}
+	fetcher := &countingFetcher{}
+	tracer := NewStartupTracerWithOptions(time.Minute, 10)
+	ledger := accounting.Open(filepath.Join(dir, "ledger.json"))
+	d := &DedupDaemon{
+		ctx:     context.Background(),
+		backend: &Backend{fd: -1, volumes: map[string]*Volume{"app": vol}, objectIDs: make(map[uint32]objectRef)},
+		images:  map[string]*ImageInfo{"app": {ImageID: "app", Volume: vol, Manifest: &ImageManifest{}}},
+		fetcher: fetcher,
+		tracer:  tracer,
+		ledger:  ledger,
+	}
+
+	warmed, err := d.WarmImageRanges("app", imagePath, []int64{0}, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if warmed != 4096 {
+		t.Fatalf("expected 4096 warmed bytes, got %d", warmed)
+	}
+	got := make([]byte, 4096)
+	if _, err := cache.ReadAt(got, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, image[:4096]) {
+		t.Fatal("warmed object does not match the image")
+	}
+	t.Logf("✓ 预热把镜像前 %d 字节写入内核读取的对象", warmed)
+
+	tracer.Begin("ctr", []string{"app"}, 0)
+	d.handleRead(ReadEvent{ID: 1, Volume: "app", Key: ImageObjectKey("app"), Offset: 1024, Length: 512})
+	d.handleRead(ReadEvent{ID: 2, Volume: "app", Key: ImageObjectKey("app"), Offset: 8192, Length: 512})
+	trace, ok := tracer.MarkStarted("ctr")
+	if !ok {
+		t.Fatal("expected a startup trace")
+	}
+	if trace.Faults != 1 {
+		t.Errorf("only the cold read should fault, got %d faults", trace.Faults)
+	}
+	if n := fetcher.calls.Load(); n != 0 {
+		t.Errorf("warmed reads must not fetch, got %d fetches", n)
+	}
+	if served := ledger.Current().Layers["app"].CacheServedBytes; served != 512 {
+		t.Errorf("expected 512 cache-served bytes, got %d", served)
+	}
+	t.Logf("✓ 已预热范围由缓存完成,未预热范围记为缺页")
+}
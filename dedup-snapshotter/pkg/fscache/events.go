@@ -0,0 +1,279 @@
+package fscache
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+
+	"github.com/containerd/log"
+	"golang.org/x/sys/unix"
+)
+
+// cachefiles on-demand 消息,布局见 include/uapi/linux/cachefiles.h
+const (
+	cachefilesOpOpen  = 0
+	cachefilesOpClose = 1
+	cachefilesOpRead  = 2
+
+	cachefilesMsgHeaderSize  = 16
+	cachefilesOpenHeaderSize = 16
+	cachefilesReadSize       = 16
+	cachefilesMsgMaxSize     = 4096
+
+	// cachefilesIocReadComplete 是 CACHEFILES_IOC_READ_COMPLETE,即 _IOW(0x98, 1, int),参数为 READ 消息的 ID
+	cachefilesIocReadComplete = 0x40049801
+)
+
+// 缓存对象失效的原因
+const (
+	EvictReasonClose = "close"
+	EvictReasonCull  = "cull"
+)
+
+// EvictionEvent 表示内核关闭或淘汰了一个缓存对象,对象中的数据需要重新下载
+type EvictionEvent struct {
+	Volume string
+	Key    string
+	Reason string
+}
+
+// ReadEvent 表示内核读取到缓存对象中尚未就绪的数据,即一次按需读取。
+// 数据写入对象后以 ID 调用 Backend.CompleteRead 唤醒读取
+type ReadEvent struct {
+	ID     uint32
+	Volume string
+	Key    string
+	Offset int64
+	Length int64
+}
+
+type cachefilesMsg struct {
+	ID       uint32
+	Opcode   uint32
+	ObjectID uint32
+	Data     []byte
+}
+
+type objectRef struct {
+	volume string
+	key    string
+}
+
+func parseCachefilesMsg(buf []byte) (*cachefilesMsg, error) {
+	if len(buf) < cachefilesMsgHeaderSize {
+		return nil, fmt.Errorf("short cachefiles message: %d bytes", len(buf))
+	}
+	length := binary.LittleEndian.Uint32(buf[8:12])
+	if int(length) > len(buf) || length < cachefilesMsgHeaderSize {
+		return nil, fmt.Errorf("invalid cachefiles message length %d", length)
+	}
+	return &cachefilesMsg{
+		ID:       binary.LittleEndian.Uint32(buf[0:4]),
+		Opcode:   binary.LittleEndian.Uint32(buf[4:8]),
+		ObjectID: binary.LittleEndian.Uint32(buf[12:16]),
+		Data:     buf[cachefilesMsgHeaderSize:length],
+	}, nil
+}
+
+// SetEvictionHandler 设置对象失效时的回调,回调在事件循环中同步执行
+func (b *Backend) SetEvictionHandler(fn func(EvictionEvent)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onEvict = fn
+}
+
+// SetReadHandler 设置按需读取的回调,回调在事件循环中同步执行
+func (b *Backend) SetReadHandler(fn func(ReadEvent)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onRead = fn
+}
+
+// RunEvents 读取 cachefiles 设备上的 on-demand 消息,直到 ctx 取消或设备不支持 on-demand 模式
+func (b *Backend) RunEvents(ctx context.Context) {
+	buf := make([]byte, cachefilesMsgMaxSize)
+	fds := []unix.PollFd{{Fd: int32(b.fd), Events: unix.POLLIN}}
+
+	for ctx.Err() == nil {
+		n, err := unix.Poll(fds, 1000)
+		if err != nil {
+			if errors.Is(err, unix.EINTR) {
+				continue
+			}
+			log.G(ctx).WithError(err).Warn("cachefiles event loop stopped")
+			return
+		}
+		if n == 0 {
+			continue
+		}
+
+		n, err = syscall.Read(b.fd, buf)
+		if err != nil {
+			if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) {
+				continue
+			}
+			log.G(ctx).WithError(err).Debug("cachefiles on-demand events not available")
+			return
+		}
+
+		// 非 on-demand 模式下读到的是缓存状态文本(如 "ready cull=1 ..."),只表示状态变化
+		if bytes.HasPrefix(buf[:n], []byte("ready")) {
+			log.G(ctx).Debugf("cachefiles state changed: %s", bytes.TrimSpace(buf[:n]))
+			continue
+		}
+		msg, err := parseCachefilesMsg(buf[:n])
+		if err != nil {
+			log.G(ctx).WithError(err).Warn("dropping malformed cachefiles message")
+			continue
+		}
+		b.handleMessage(msg)
+	}
+}
+
+func (b *Backend) handleMessage(msg *cachefilesMsg) {
+	switch msg.Opcode {
+	case cachefilesOpOpen:
+		if err := b.handleOpen(msg); err != nil {
+			log.L.WithError(err).Warnf("failed to handle cachefiles open %d", msg.ID)
+		}
+	case cachefilesOpClose:
+		b.mu.Lock()
+		ref, ok := b.objectIDs[msg.ObjectID]
+		delete(b.objectIDs, msg.ObjectID)
+		b.mu.Unlock()
+		if ok {
+			b.Evict(ref.volume, ref.key, EvictReasonClose)
+		}
+	case cachefilesOpRead:
+		// 数据由 DedupDaemon.RequestChunk 下载,这里只通知回调
+		if len(msg.Data) < cachefilesReadSize {
+			log.L.Warnf("dropping short cachefiles read %d", msg.ID)
+			return
+		}
+		length := int64(binary.LittleEndian.Uint64(msg.Data[8:16]))
+		b.ondemandReads.Add(1)
+		b.ondemandReadBytes.Add(length)
+
+		b.mu.RLock()
+		ref, ok := b.objectIDs[msg.ObjectID]
+		fn := b.onRead
+		b.mu.RUnlock()
+		if ok && fn != nil {
+			fn(ReadEvent{
+				ID:     msg.ID,
+				Volume: ref.volume,
+				Key:    ref.key,
+				Offset: int64(binary.LittleEndian.Uint64(msg.Data[0:8])),
+				Length: length,
+			})
+		}
+	}
+}
+
+// handleOpen 记录内核分配的对象 ID,把匿名 fd 关联到对应的缓存对象并回复 copen
+func (b *Backend) handleOpen(msg *cachefilesMsg) error {
+	if len(msg.Data) < cachefilesOpenHeaderSize {
+		return fmt.Errorf("short open message")
+	}
+	volumeKeySize := binary.LittleEndian.Uint32(msg.Data[0:4])
+	cookieKeySize := binary.LittleEndian.Uint32(msg.Data[4:8])
+	fd := int(binary.LittleEndian.Uint32(msg.Data[8:12]))
+	keys := msg.Data[cachefilesOpenHeaderSize:]
+	if uint64(volumeKeySize)+uint64(cookieKeySize) > uint64(len(keys)) {
+		syscall.Close(fd)
+		return fmt.Errorf("open message keys exceed payload")
+	}
+
+	// erofs 的卷键形如 "erofs,<fsid>",这里的卷名即 fsid
+	volumeName := string(bytes.TrimRight(keys[:volumeKeySize], "\x00"))
+	volumeName = strings.TrimPrefix(volumeName, "erofs,")
+	key := string(bytes.TrimRight(keys[volumeKeySize:volumeKeySize+cookieKeySize], "\x00"))
+
+	vol, err := b.GetVolume(volumeName)
+	if err != nil {
+		syscall.Close(fd)
+		return b.reply(fmt.Sprintf("copen %d,%d", msg.ID, -int(syscall.ENOENT)))
+	}
+
+	vol.mu.Lock()
+	obj, exists := vol.Objects[key]
+	if !exists {
+		obj = &CacheObject{Key: key, Fd: -1}
+		vol.Objects[key] = obj
+	}
+	vol.mu.Unlock()
+
+	obj.mu.Lock()
+	if obj.Fd > 0 {
+		syscall.Close(obj.Fd)
+		// 之前写入的数据在旧的 fd 中,内核的缓存文件里没有
+		obj.ranges = nil
+	}
+	obj.Fd = fd
+	size := obj.Size
+	obj.mu.Unlock()
+
+	b.mu.Lock()
+	b.objectIDs[msg.ObjectID] = objectRef{volume: volumeName, key: key}
+	b.mu.Unlock()
+
+	return b.reply(fmt.Sprintf("copen %d,%d", msg.ID, size))
+}
+
+// CompleteRead 通知内核按需读取 e 的数据已写入缓存对象
+func (b *Backend) CompleteRead(e ReadEvent) error {
+	vol, err := b.GetVolume(e.Volume)
+	if err != nil {
+		return err
+	}
+	obj, ok := vol.GetObject(e.Key)
+	if !ok {
+		return fmt.Errorf("cache object %s/%s not found", e.Volume, e.Key)
+	}
+	obj.mu.Lock()
+	defer obj.mu.Unlock()
+	if err := unix.IoctlSetInt(obj.Fd, cachefilesIocReadComplete, int(e.ID)); err != nil {
+		return fmt.Errorf("failed to complete read %d of %s/%s: %w", e.ID, e.Volume, e.Key, err)
+	}
+	return nil
+}
+
+func (b *Backend) reply(cmd string) error {
+	_, err := syscall.Write(b.fd, []byte(cmd))
+	return err
+}
+
+// Evict 把缓存对象标记为失效并从卷中移除,然后通知回调。
+// 内核关闭对象时由事件循环调用,也可以在主动淘汰(cull)后调用
+func (b *Backend) Evict(volumeName, key, reason string) {
+	vol, err := b.GetVolume(volumeName)
+	if err != nil {
+		return
+	}
+
+	vol.mu.Lock()
+	obj, ok := vol.Objects[key]
+	delete(vol.Objects, key)
+	vol.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	obj.mu.Lock()
+	obj.Complete = false
+	obj.mu.Unlock()
+	obj.Close()
+
+	b.mu.RLock()
+	fn := b.onEvict
+	b.mu.RUnlock()
+
+	log.L.Debugf("cache object %s/%s evicted (%s)", volumeName, key, reason)
+	if fn != nil {
+		fn(EvictionEvent{Volume: volumeName, Key: key, Reason: reason})
+	}
+}
@@ -0,0 +1,73 @@
+package fscache
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func encodeCachefilesMsg(id, opcode, objectID uint32, data []byte) []byte {
+	buf := make([]byte, cachefilesMsgHeaderSize+len(data))
+	binary.LittleEndian.PutUint32(buf[0:4], id)
+	binary.LittleEndian.PutUint32(buf[4:8], opcode)
+	binary.LittleEndian.PutUint32(buf[8:12], uint32(len(buf)))
+	binary.LittleEndian.PutUint32(buf[12:16], objectID)
+	copy(buf[cachefilesMsgHeaderSize:], data)
+	return buf
+}
+
+// TestCloseEvictsObject 验证内核 OPEN 后 CLOSE 会移除缓存对象并通知回调
+func TestCloseEvictsObject(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "anon"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	anonFd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vol := &Volume{Name: "img", Objects: map[string]*CacheObject{
+		"chunk-a": {Key: "chunk-a", Size: 4096, Fd: -1, Complete: true},
+	}}
+	b := &Backend{
+		fd:        -1,
+		volumes:   map[string]*Volume{"img": vol},
+		objectIDs: make(map[uint32]objectRef),
+	}
+
+	var events []EvictionEvent
+	b.SetEvictionHandler(func(e EvictionEvent) { events = append(events, e) })
+
+	volumeKey := []byte("erofs,img\x00")
+	cookieKey := []byte("chunk-a")
+	open := make([]byte, cachefilesOpenHeaderSize, cachefilesOpenHeaderSize+len(volumeKey)+len(cookieKey))
+	binary.LittleEndian.PutUint32(open[0:4], uint32(len(volumeKey)))
+	binary.LittleEndian.PutUint32(open[4:8], uint32(len(cookieKey)))
+	binary.LittleEndian.PutUint32(open[8:12], uint32(anonFd))
+	open = append(append(open, volumeKey...), cookieKey...)
+
+	msg, err := parseCachefilesMsg(encodeCachefilesMsg(1, cachefilesOpOpen, 7, open))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 测试中没有 cachefiles 设备,copen 回复失败不影响对象关联
+	b.handleMessage(msg)
+	if obj, _ := vol.GetObject("chunk-a"); obj.Fd != anonFd {
+		t.Fatalf("expected object to use anon fd %d, got %d", anonFd, obj.Fd)
+	}
+
+	msg, _ = parseCachefilesMsg(encodeCachefilesMsg(2, cachefilesOpClose, 7, nil))
+	b.handleMessage(msg)
+
+	if _, ok := vol.GetObject("chunk-a"); ok {
+		t.Error("expected evicted object to be removed from volume")
+	}
+	if len(events) != 1 || events[0] != (EvictionEvent{Volume: "img", Key: "chunk-a", Reason: EvictReasonClose}) {
+		t.Fatalf("unexpected eviction events: %+v", events)
+	}
+	t.Logf("✓ CLOSE 事件淘汰对象 %s/%s", events[0].Volume, events[0].Key)
+}
@@ -0,0 +1,63 @@
+package fscache
+
+import (
+	"github.com/containerd/log"
+)
+
+// DefaultReadFailureThreshold 是判定按需读取持续失败的连续失败次数
+const DefaultReadFailureThreshold = 5
+
+// ReadFailureEvent 表示挂载中镜像的按需读取连续失败达到阈值,容器继续读取会得到 EIO
+type ReadFailureEvent struct {
+	Volume   string
+	Failures int
+	Err      error
+}
+
+// SetReadFailureThreshold 设置判定持续失败的连续失败次数,n <= 0 时使用默认值
+func (d *DedupDaemon) SetReadFailureThreshold(n int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.failureThreshold = n
+}
+
+// OnReadFailure 注册按需读取持续失败时的回调,每个镜像在失败计数清零前只通知一次
+func (d *DedupDaemon) OnReadFailure(fn func(ReadFailureEvent)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.failureHooks = append(d.failureHooks, fn)
+}
+
+// recordReadResult 记录挂载中镜像的一次下载结果,成功时清零连续失败计数。
+// 未挂载镜像的预取失败不影响容器读取,不计入
+func (d *DedupDaemon) recordReadResult(imageID string, err error) {
+	d.mu.Lock()
+	if err == nil {
+		delete(d.readFailures, imageID)
+		d.mu.Unlock()
+		return
+	}
+	if !d.mounted[imageID] {
+		d.mu.Unlock()
+		return
+	}
+	if d.readFailures == nil {
+		d.readFailures = make(map[string]int)
+	}
+	d.readFailures[imageID]++
+	failures := d.readFailures[imageID]
+	threshold := d.failureThreshold
+	if threshold <= 0 {
+		threshold = DefaultReadFailureThreshold
+	}
+	hooks := d.failureHooks
+	d.mu.Unlock()
+
+	if failures != threshold {
+		return
+	}
+	log.L.WithError(err).Warnf("on-demand reads of %s failed %d times in a row", imageID, failures)
+	for _, fn := range hooks {
+		fn(ReadFailureEvent{Volume: imageID, Failures: failures, Err: err})
+	}
+}
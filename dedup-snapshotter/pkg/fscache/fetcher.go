@@ -0,0 +1,223 @@
+package fscache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/internal/faultinject"
+	"github.com/opencloudos/dedup-snapshotter/internal/lazy"
+	"github.com/opencloudos/dedup-snapshotter/pkg/slowlog"
+)
+
+// ErrBlobNotFound 表示当前数据源中不存在请求的 blob,调用方应尝试下一个数据源
+var ErrBlobNotFound = lazy.ErrBlobNotFound
+
+// Fetcher 按层 digest 和偏移量读取块数据
+type Fetcher interface {
+	Fetch(ctx context.Context, imageID, layerDigest string, offset, size int64) ([]byte, error)
+}
+
+// StreamFetcher 以流的形式返回块数据,下载任务直接把数据写入缓存对象,不在内存中缓冲整个 chunk
+type StreamFetcher interface {
+	FetchStream(ctx context.Context, imageID, layerDigest string, offset, size int64) (io.ReadCloser, error)
+}
+
+// fetchStream 优先使用 StreamFetcher,否则把 Fetch 的结果包装为流
+func fetchStream(ctx context.Context, f Fetcher, imageID, layerDigest string, offset, size int64) (io.ReadCloser, error) {
+	if sf, ok := f.(StreamFetcher); ok {
+		return sf.FetchStream(ctx, imageID, layerDigest, offset, size)
+	}
+	data, err := f.Fetch(ctx, imageID, layerDigest, offset, size)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// RegistryFetcher 通过 HTTP Range 请求从镜像仓库读取块数据
+type RegistryFetcher struct {
+	registry string
+	client   *http.Client
+	// negative 为空时每次都向数据源探测
+	negative *NegativeCache
+}
+
+func NewRegistryFetcher(registry string, client *http.Client) *RegistryFetcher {
+	return &RegistryFetcher{
+		registry: registry,
+		client:   client,
+	}
+}
+
+// SetNegativeCache 让该数据源在 TTL 内跳过已确认不存在的 blob
+func (r *RegistryFetcher) SetNegativeCache(c *NegativeCache) {
+	r.negative = c
+}
+
+func (r *RegistryFetcher) Fetch(ctx context.Context, imageID, layerDigest string, offset, size int64) (_ []byte, err error) {
+	op := slowlog.Start(slowlog.OpDownload, log.Fields{"image": imageID, "layer": layerDigest, "offset": offset, "size": size})
+	defer func() { op.Done(err) }()
+
+	body, err := r.FetchStream(ctx, imageID, layerDigest, offset, size)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	// 响应体已限制为 size 字节,按区间大小一次分配,避免 io.ReadAll 反复扩容
+	data := make([]byte, max(size, 0))
+	n, err := io.ReadFull(body, data)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	return data[:n], nil
+}
+
+// FetchStream 返回 Range 请求的响应体,调用方负责关闭
+func (r *RegistryFetcher) FetchStream(ctx context.Context, imageID, layerDigest string, offset, size int64) (io.ReadCloser, error) {
+	if err := faultinject.Inject(faultinject.RegistryError); err != nil {
+		return nil, fmt.Errorf("http request failed: %w", err)
+	}
+	if r.negative != nil && r.negative.Lookup(r.registry, imageID, layerDigest) {
+		return nil, fmt.Errorf("%s: %w", layerDigest, ErrBlobNotFound)
+	}
+
+	url := fmt.Sprintf("%s/v2/%s/blobs/%s", r.registry, imageID, layerDigest)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	rangeHeader := fmt.Sprintf("bytes=%d-%d", offset, offset+size-1)
+	req.Header.Set("Range", rangeHeader)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http request failed: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		if r.negative != nil {
+			r.negative.Add(r.registry, imageID, layerDigest)
+		}
+		return nil, fmt.Errorf("%s: %w", layerDigest, ErrBlobNotFound)
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	// 不支持 Range 的服务返回整个 blob,跳过偏移之前的数据
+	if resp.StatusCode == http.StatusOK && offset > 0 {
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to read response: %w", err)
+		}
+	}
+	return limitedBody{Reader: io.LimitReader(resp.Body, size), Closer: resp.Body}, nil
+}
+
+type limitedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// ChainFetcher 依次尝试多个数据源,前一个失败时回退到下一个
+type ChainFetcher []Fetcher
+
+func (c ChainFetcher) Fetch(ctx context.Context, imageID, layerDigest string, offset, size int64) ([]byte, error) {
+	lastErr := fmt.Errorf("%s: %w", layerDigest, ErrBlobNotFound)
+
+	for _, f := range c {
+		data, err := f.Fetch(ctx, imageID, layerDigest, offset, size)
+		if err == nil {
+			return data, nil
+		}
+
+		if errors.Is(err, ErrBlobNotFound) {
+			log.G(ctx).Debugf("blob %s not found in %T, trying next source", layerDigest, f)
+		} else {
+			log.G(ctx).WithError(err).Warnf("failed to fetch blob %s from %T, trying next source", layerDigest, f)
+		}
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+// FetchStream 与 Fetch 相同,依次尝试各数据源,返回第一个成功打开的流
+func (c ChainFetcher) FetchStream(ctx context.Context, imageID, layerDigest string, offset, size int64) (io.ReadCloser, error) {
+	lastErr := fmt.Errorf("%s: %w", layerDigest, ErrBlobNotFound)
+
+	for _, f := range c {
+		rc, err := fetchStream(ctx, f, imageID, layerDigest, offset, size)
+		if err == nil {
+			return rc, nil
+		}
+
+		if errors.Is(err, ErrBlobNotFound) {
+			log.G(ctx).Debugf("blob %s not found in %T, trying next source", layerDigest, f)
+		} else {
+			log.G(ctx).WithError(err).Warnf("failed to fetch blob %s from %T, trying next source", layerDigest, f)
+		}
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+// MirrorFetcher 从只读镜像服务(dedup-snapshotter --mirror)读取数据。
+// 层 blob 走与镜像仓库相同的 /v2 路径,另外支持按哈希读取 chunk
+type MirrorFetcher struct {
+	*RegistryFetcher
+	url string
+}
+
+func NewMirrorFetcher(url string, client *http.Client) *MirrorFetcher {
+	url = strings.TrimSuffix(url, "/")
+	return &MirrorFetcher{
+		RegistryFetcher: NewRegistryFetcher(url, client),
+		url:             url,
+	}
+}
+
+// FetchChunk 按哈希读取镜像服务上的 chunk,不存在时返回 ErrBlobNotFound
+func (m *MirrorFetcher) FetchChunk(ctx context.Context, chunkHash string) ([]byte, error) {
+	// 按哈希读取的 chunk 不属于某个镜像,只能等待 TTL 过期
+	if m.negative != nil && m.negative.Lookup(m.url, "", "chunk:"+chunkHash) {
+		return nil, fmt.Errorf("chunk %s: %w", chunkHash, ErrBlobNotFound)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.url+"/chunks/"+chunkHash, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		if m.negative != nil {
+			m.negative.Add(m.url, "", "chunk:"+chunkHash)
+		}
+		return nil, fmt.Errorf("chunk %s: %w", chunkHash, ErrBlobNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return io.ReadAll(resp.Body)
+}
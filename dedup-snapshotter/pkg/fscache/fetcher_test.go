@@ -0,0 +1,77 @@
+package fscache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/opencloudos/dedup-snapshotter/internal/lazy"
+	"github.com/opencontainers/go-digest"
+)
+
+// TestStreamIntoCacheObject 验证 content store 和不支持 Range 的服务返回的流都按偏移写入缓存对象
+func TestStreamIntoCacheObject(t *testing.T) {
+	blob := []byte("0123456789abcdef")
+	dgst := digest.FromBytes(blob)
+
+	root := t.TempDir()
+	blobPath := filepath.Join(root, "blobs", "sha256", dgst.Encoded())
+	if err := os.MkdirAll(filepath.Dir(blobPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(blobPath, blob, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 忽略 Range,返回整个 blob
+		w.Write(blob)
+	}))
+	defer ts.Close()
+
+	cs, err := lazy.NewContentStoreFetcher(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for name, f := range map[string]Fetcher{
+		"content store": cs,
+		"registry":      NewRegistryFetcher(ts.URL, ts.Client()),
+	} {
+		target := filepath.Join(t.TempDir(), "object")
+		fd, err := syscall.Open(target, syscall.O_RDWR|syscall.O_CREAT, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj := &CacheObject{Key: "chunk", Fd: fd}
+
+		body, err := fetchStream(ctx, f, "app", dgst.String(), 4, 6)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		n, err := obj.WriteFrom(2, body)
+		body.Close()
+		obj.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		data, err := os.ReadFile(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 6 || string(data[2:]) != "456789" {
+			t.Errorf("%s: wrote %d bytes, object %q", name, n, data)
+		}
+	}
+
+	if _, err := cs.FetchStream(ctx, "app", digest.FromString("x").String(), 0, 1); err == nil {
+		t.Error("expected missing blob to fail")
+	}
+	t.Logf("✓ 流式写入缓存对象,不支持 Range 的服务按偏移截取")
+}
@@ -0,0 +1,84 @@
+package fscache
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/metrics"
+)
+
+// 5.17 之前内核的 fscache 统计
+const oldFscacheStats = `FS-Cache statistics
+Cookies: idx=3 dat=120 spc=0
+Objects: alc=118 nal=0 avl=118 ded=10
+Retrvls: n=500 ok=420 wt=3 nod=80 nbf=0 int=0 oom=0
+Retrvls: ops=500 owt=2 abt=0
+CacheEv: nsp=1 grv=0 nbf=0 cul=7
+`
+
+// 6.x 内核的 netfs 统计,其中包含 fscache 的计数
+const netfsStats = `Netfs  : DR=0 RA=12 RF=0 WB=0 WBZ=0
+Netfs  : DL=35 ds=35 df=0 di=0
+Netfs  : RD=300 rs=300 rf=0
+-- FS-Cache statistics --
+Cookies: n=42 v=1 vcol=0 voom=0
+NoSpace: nwr=0 ncr=0 cull=4
+IO     : rd=310 wr=35
+`
+
+// TestReadKernelStats 验证新旧两种内核统计格式都能推导出读取、未命中和清理计数
+func TestReadKernelStats(t *testing.T) {
+	saved := kernelStatsFiles
+	defer func() { kernelStatsFiles = saved }()
+
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	kernelStatsFiles = []struct{ name, path string }{
+		{"fscache", write("old", oldFscacheStats)},
+		{"netfs", filepath.Join(dir, "missing")},
+	}
+	stats, err := ReadKernelStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := metrics.KernelCacheStats{CacheReads: 420, CacheMisses: 80, Culled: 7}
+	if stats.CacheReads != want.CacheReads || stats.CacheMisses != want.CacheMisses || stats.Culled != want.Culled {
+		t.Errorf("old format: got %+v, want %+v", stats, want)
+	}
+	if stats.Counters["fscache.Objects.avl"] != 118 {
+		t.Errorf("expected raw counter fscache.Objects.avl=118, got %v", stats.Counters)
+	}
+	t.Logf("✓ 解析旧格式统计")
+
+	netfs := write("netfs", netfsStats)
+	kernelStatsFiles = []struct{ name, path string }{
+		{"fscache", netfs},
+		{"netfs", netfs},
+	}
+	if stats, err = ReadKernelStats(); err != nil {
+		t.Fatal(err)
+	}
+	want = metrics.KernelCacheStats{CacheReads: 310, CacheMisses: 35, Culled: 4}
+	if stats.CacheReads != want.CacheReads || stats.CacheMisses != want.CacheMisses || stats.Culled != want.Culled {
+		t.Errorf("new format: got %+v, want %+v", stats, want)
+	}
+	if stats.Counters["netfs.Netfs.RA"] != 12 || stats.Counters["netfs.Cookies.n"] != 42 {
+		t.Errorf("expected counters from all sections, got %v", stats.Counters)
+	}
+	t.Logf("✓ 解析 netfs 格式统计")
+
+	kernelStatsFiles = []struct{ name, path string }{{"fscache", filepath.Join(dir, "missing")}}
+	if _, err := ReadKernelStats(); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected ErrNotExist without stats files, got %v", err)
+	}
+	t.Logf("✓ 统计不可用时返回 ErrNotExist")
+}
@@ -0,0 +1,168 @@
+package fscache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	digest "github.com/opencontainers/go-digest"
+)
+
+const LayerManifestVersion = 1
+
+// LayerManifest 描述一个镜像层中文件区间到 chunk、chunk 到层 blob 偏移的映射,
+// 由 LayerProcessor 生成,DedupDaemon 和 Prefetcher 据此按 chunk 下载数据。
+// BlobOffset 是 chunk 在未压缩 tar 流(DiffID)中的偏移;Compressed 为 false 时
+// 该流与 Digest 对应的 blob 相同,可直接按范围读取。
+type LayerManifest struct {
+	Version    int          `json:"version"`
+	LayerID    string       `json:"layer_id"`
+	Digest     string       `json:"digest"`
+	DiffID     string       `json:"diff_id"`
+	Compressed bool         `json:"compressed"`
+	ChunkSize  int64        `json:"chunk_size"`
+	Files      []*FileEntry `json:"files"`
+}
+
+type FileEntry struct {
+	Path   string      `json:"path"`
+	Size   int64       `json:"size"`
+	Chunks []*ChunkRef `json:"chunks"`
+}
+
+// ChunkRef 是文件中 [FileOffset, FileOffset+Size) 区间对应的 chunk
+type ChunkRef struct {
+	Hash       string `json:"hash"`
+	FileOffset int64  `json:"file_offset"`
+	BlobOffset int64  `json:"blob_offset"`
+	Size       int64  `json:"size"`
+}
+
+func WriteLayerManifest(path string, manifest *LayerManifest) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func LoadLayerManifest(path string) (*LayerManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest LayerManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid layer manifest %s: %w", path, err)
+	}
+	if manifest.Version != LayerManifestVersion {
+		return nil, fmt.Errorf("unsupported layer manifest version %d in %s", manifest.Version, path)
+	}
+
+	return &manifest, nil
+}
+
+// 构建系统以 OCI referrer 形式随镜像发布的 chunk 清单:artifact 的每个 blob
+// 是一个层的 LayerManifest JSON,按 Digest 对应到镜像中的层
+const (
+	ArtifactTypeChunkManifest = "application/vnd.opencloudos.dedup.chunk-manifest.v1"
+	MediaTypeLayerManifest    = "application/vnd.opencloudos.dedup.layer-manifest.v1+json"
+
+	maxManifestChunkSize = 64 << 20
+)
+
+// ParseLayerManifest 解析并校验外部发布的层清单
+func ParseLayerManifest(data []byte) (*LayerManifest, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	var manifest LayerManifest
+	if err := dec.Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid layer manifest: %w", err)
+	}
+	if err := manifest.Validate(); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// Validate 检查发布的清单:层 digest 和 DiffID 必须存在,每个文件的 chunk
+// 从 0 开始连续覆盖整个文件,且所有 chunk 在 blob 中的区间互不重叠
+func (m *LayerManifest) Validate() error {
+	if m.Version != LayerManifestVersion {
+		return fmt.Errorf("unsupported layer manifest version %d", m.Version)
+	}
+	if _, err := digest.Parse(m.Digest); err != nil {
+		return fmt.Errorf("invalid layer digest %q: %w", m.Digest, err)
+	}
+	if _, err := digest.Parse(m.DiffID); err != nil {
+		return fmt.Errorf("invalid diff id %q: %w", m.DiffID, err)
+	}
+	if m.ChunkSize <= 0 || m.ChunkSize > maxManifestChunkSize {
+		return fmt.Errorf("chunk size %d out of range (0, %d]", m.ChunkSize, maxManifestChunkSize)
+	}
+
+	paths := make(map[string]bool, len(m.Files))
+	var chunks []*ChunkRef
+	for _, file := range m.Files {
+		if file.Path != filepath.Clean("/"+file.Path) {
+			return fmt.Errorf("file path %q is not absolute and clean", file.Path)
+		}
+		if paths[file.Path] {
+			return fmt.Errorf("duplicate file %s", file.Path)
+		}
+		paths[file.Path] = true
+
+		var offset int64
+		for _, chunk := range file.Chunks {
+			if !isChunkHash(chunk.Hash) {
+				return fmt.Errorf("invalid chunk hash %q in %s", chunk.Hash, file.Path)
+			}
+			if chunk.Size <= 0 || chunk.Size > m.ChunkSize {
+				return fmt.Errorf("chunk %s in %s has invalid size %d", chunk.Hash, file.Path, chunk.Size)
+			}
+			if chunk.FileOffset != offset || chunk.BlobOffset < 0 {
+				return fmt.Errorf("chunk %s in %s is not contiguous at offset %d", chunk.Hash, file.Path, chunk.FileOffset)
+			}
+			offset += chunk.Size
+			chunks = append(chunks, chunk)
+		}
+		if offset != file.Size {
+			return fmt.Errorf("chunks of %s cover %d of %d bytes", file.Path, offset, file.Size)
+		}
+	}
+
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].BlobOffset < chunks[j].BlobOffset })
+	for i := 1; i < len(chunks); i++ {
+		if prev := chunks[i-1]; prev.BlobOffset+prev.Size > chunks[i].BlobOffset {
+			return fmt.Errorf("chunks %s and %s overlap at blob offset %d", prev.Hash, chunks[i].Hash, chunks[i].BlobOffset)
+		}
+	}
+	return nil
+}
+
+// isChunkHash 判断是否为小写十六进制的 sha256
+func isChunkHash(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,65 @@
+package fscache
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func validLayerManifest() *LayerManifest {
+	hash := strings.Repeat("ab", 32)
+	return &LayerManifest{
+		Version:   LayerManifestVersion,
+		Digest:    "sha256:" + strings.Repeat("1", 64),
+		DiffID:    "sha256:" + strings.Repeat("2", 64),
+		ChunkSize: 4096,
+		Files: []*FileEntry{
+			{Path: "/bin/a", Size: 6000, Chunks: []*ChunkRef{
+				{Hash: hash, FileOffset: 0, BlobOffset: 512, Size: 4096},
+				{Hash: hash, FileOffset: 4096, BlobOffset: 4608, Size: 1904},
+			}},
+			{Path: "/empty", Size: 0},
+		},
+	}
+}
+
+// TestParseLayerManifestValidation 验证发布清单的校验规则
+func TestParseLayerManifestValidation(t *testing.T) {
+	cases := []struct {
+		name   string
+		mutate func(m *LayerManifest)
+		errMsg string
+	}{
+		{"valid", func(m *LayerManifest) {}, ""},
+		{"version", func(m *LayerManifest) { m.Version = 2 }, "unsupported layer manifest version"},
+		{"digest", func(m *LayerManifest) { m.Digest = "" }, "invalid layer digest"},
+		{"chunk size", func(m *LayerManifest) { m.ChunkSize = 0 }, "chunk size"},
+		{"relative path", func(m *LayerManifest) { m.Files[0].Path = "bin/a" }, "not absolute"},
+		{"bad hash", func(m *LayerManifest) { m.Files[0].Chunks[0].Hash = "XYZ" }, "invalid chunk hash"},
+		{"gap", func(m *LayerManifest) { m.Files[0].Chunks[1].FileOffset = 5000 }, "not contiguous"},
+		{"short", func(m *LayerManifest) { m.Files[0].Size = 7000 }, "cover 6000 of 7000"},
+		{"overlap", func(m *LayerManifest) { m.Files[0].Chunks[1].BlobOffset = 1024 }, "overlap"},
+	}
+
+	for _, tc := range cases {
+		m := validLayerManifest()
+		tc.mutate(m)
+		data, _ := json.Marshal(m)
+
+		_, err := ParseLayerManifest(data)
+		if tc.errMsg == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tc.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.errMsg) {
+			t.Errorf("%s: expected error containing %q, got %v", tc.name, tc.errMsg, err)
+		}
+	}
+
+	if _, err := ParseLayerManifest([]byte(`{"version":1,"extra":true}`)); err == nil {
+		t.Error("expected unknown fields to be rejected")
+	}
+	t.Logf("✓ %d 条清单校验规则", len(cases)-1)
+}
@@ -0,0 +1,163 @@
+package fscache
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/containerd/log"
+)
+
+// NegativeCacheFile 是负查找缓存在 root 下的文件名
+const NegativeCacheFile = "negative-lookups.json"
+
+// DefaultNegativeTTL 是负查找结果默认的有效期
+const DefaultNegativeTTL = 10 * time.Minute
+
+// NegativeCache 记录数据源确认不存在的 blob、chunk 和 referrer,在 TTL 内不再重复探测。
+// 条目按 数据源/镜像/键 索引,重新注册镜像时清除该镜像的条目;缓存保存在磁盘上,重启后仍然有效
+type NegativeCache struct {
+	path string
+	ttl  time.Duration
+
+	mu            sync.Mutex
+	entries       map[string]negativeEntry
+	hits          int64
+	misses        int64
+	invalidations int64
+}
+
+type negativeEntry struct {
+	Image   string    `json:"image"`
+	Expires time.Time `json:"expires"`
+}
+
+// NegativeCacheStats 是负查找缓存的命中统计,Invalidations 为因镜像重新注册而清除的条目数
+type NegativeCacheStats struct {
+	Entries       int           `json:"entries"`
+	TTL           time.Duration `json:"ttl"`
+	Hits          int64         `json:"hits"`
+	Misses        int64         `json:"misses"`
+	Invalidations int64         `json:"invalidations"`
+}
+
+// NewNegativeCache 加载 path 中未过期的条目,文件不存在时从空缓存开始
+func NewNegativeCache(path string, ttl time.Duration) (*NegativeCache, error) {
+	if ttl <= 0 {
+		ttl = DefaultNegativeTTL
+	}
+	c := &NegativeCache{
+		path:    path,
+		ttl:     ttl,
+		entries: make(map[string]negativeEntry),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read negative cache: %w", err)
+	}
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		// 缓存只影响效率,损坏时丢弃
+		log.L.WithError(err).Warnf("discarding corrupt negative cache %s", path)
+		c.entries = make(map[string]negativeEntry)
+	}
+	c.expire(time.Now())
+	return c, nil
+}
+
+func negativeKey(source, image, key string) string {
+	return source + "|" + image + "|" + key
+}
+
+// Lookup 报告 source 上镜像 image 的 key 是否在有效期内被确认不存在
+func (c *NegativeCache) Lookup(source, image, key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[negativeKey(source, image, key)]
+	if ok && time.Now().Before(e.Expires) {
+		c.hits++
+		return true
+	}
+	c.misses++
+	return false
+}
+
+// Add 记录 source 上镜像 image 的 key 不存在
+func (c *NegativeCache) Add(source, image, key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.expire(now)
+	c.entries[negativeKey(source, image, key)] = negativeEntry{Image: image, Expires: now.Add(c.ttl)}
+	c.save()
+}
+
+// InvalidateImage 清除镜像的所有条目,返回清除的条目数
+func (c *NegativeCache) InvalidateImage(image string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for k, e := range c.entries {
+		if e.Image == image {
+			delete(c.entries, k)
+			removed++
+		}
+	}
+	if removed > 0 {
+		c.invalidations += int64(removed)
+		c.save()
+	}
+	return removed
+}
+
+func (c *NegativeCache) Stats() NegativeCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expire(time.Now())
+	return NegativeCacheStats{
+		Entries:       len(c.entries),
+		TTL:           c.ttl,
+		Hits:          c.hits,
+		Misses:        c.misses,
+		Invalidations: c.invalidations,
+	}
+}
+
+func (c *NegativeCache) expire(now time.Time) {
+	for k, e := range c.entries {
+		if !now.Before(e.Expires) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// save 原子地替换缓存文件,调用方需持有 mu。写入失败只记录日志
+func (c *NegativeCache) save() {
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		log.L.WithError(err).Warn("failed to save negative cache")
+		return
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		log.L.WithError(err).Warn("failed to save negative cache")
+	}
+}
+
+// OpenNegativeCache 打开 root 下的负查找缓存
+func OpenNegativeCache(root string, ttl time.Duration) (*NegativeCache, error) {
+	return NewNegativeCache(filepath.Join(root, NegativeCacheFile), ttl)
+}
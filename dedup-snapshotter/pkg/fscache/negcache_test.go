@@ -0,0 +1,59 @@
+package fscache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestNegativeCache 验证仓库返回 404 后在 TTL 内不再探测,缓存跨重启保留,重新注册镜像时失效
+func TestNegativeCache(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	root := t.TempDir()
+	cache, err := OpenNegativeCache(root, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := NewRegistryFetcher(ts.URL, ts.Client())
+	f.SetNegativeCache(cache)
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if _, err := f.Fetch(ctx, "app", "sha256:missing", 0, 10); !errors.Is(err, ErrBlobNotFound) {
+			t.Fatalf("expected ErrBlobNotFound, got %v", err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("expected a single probe, got %d", requests)
+	}
+
+	// 重启后从磁盘加载
+	reloaded, err := OpenNegativeCache(root, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded.Lookup(ts.URL, "app", "sha256:missing") {
+		t.Error("negative lookup lost across reload")
+	}
+	if n := reloaded.InvalidateImage("app"); n != 1 {
+		t.Errorf("expected 1 invalidated entry, got %d", n)
+	}
+	if reloaded.Lookup(ts.URL, "app", "sha256:missing") {
+		t.Error("entry survived invalidation")
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 2 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	t.Logf("✓ 负查找缓存 %+v", stats)
+}
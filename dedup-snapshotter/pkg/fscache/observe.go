@@ -0,0 +1,108 @@
+package fscache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/metrics"
+)
+
+// 数据源后端类型,用于按后端区分指标
+const (
+	BackendContentStore = "content-store"
+	BackendMirror       = "mirror"
+	BackendRegistry     = "registry"
+)
+
+// SetMetrics 按数据源记录读取的延迟、错误、命中率和字节数,并在指标快照中附上内核 fscache 统计
+func (d *DedupDaemon) SetMetrics(m *metrics.Metrics) {
+	d.metrics.Store(m)
+	if m != nil {
+		m.SetKernelCacheCollector(d.KernelCacheStats)
+	}
+}
+
+// observe 包装数据源,使其每次读取都计入 name 对应后端的指标
+func (d *DedupDaemon) observe(kind, name string, f Fetcher) Fetcher {
+	return &observedFetcher{Fetcher: f, daemon: d, kind: kind, name: name}
+}
+
+func (d *DedupDaemon) observeBackend(kind, name string, bytes int64, err error, start time.Time) {
+	m := d.metrics.Load()
+	if m == nil {
+		return
+	}
+	m.ObserveBackendOp(metrics.BackendOp{
+		Backend:  name,
+		Kind:     kind,
+		Op:       metrics.BackendOpRead,
+		Bytes:    bytes,
+		NotFound: errors.Is(err, ErrBlobNotFound),
+		Err:      err,
+		Duration: time.Since(start),
+	})
+}
+
+// observeImageRead 按镜像记录一次 chunk 读取是否由本地缓存满足
+func (d *DedupDaemon) observeImageRead(imageID string, hit bool) {
+	if m := d.metrics.Load(); m != nil {
+		m.ObserveImageCache(imageID, hit)
+	}
+}
+
+type observedFetcher struct {
+	Fetcher
+	daemon     *DedupDaemon
+	kind, name string
+}
+
+func (o *observedFetcher) Fetch(ctx context.Context, imageID, layerDigest string, offset, size int64) ([]byte, error) {
+	start := time.Now()
+	data, err := o.Fetcher.Fetch(ctx, imageID, layerDigest, offset, size)
+	o.daemon.observeBackend(o.kind, o.name, int64(len(data)), err, start)
+	return data, err
+}
+
+// FetchStream 在打开流时记录延迟和结果,字节数在流读完或关闭时计入
+func (o *observedFetcher) FetchStream(ctx context.Context, imageID, layerDigest string, offset, size int64) (io.ReadCloser, error) {
+	start := time.Now()
+	rc, err := fetchStream(ctx, o.Fetcher, imageID, layerDigest, offset, size)
+	o.daemon.observeBackend(o.kind, o.name, 0, err, start)
+	if err != nil {
+		return nil, err
+	}
+	return &countedStream{ReadCloser: rc, fetcher: o}, nil
+}
+
+type countedStream struct {
+	io.ReadCloser
+	fetcher *observedFetcher
+	n       int64
+	done    bool
+}
+
+func (c *countedStream) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	if err == io.EOF {
+		c.report()
+	}
+	return n, err
+}
+
+func (c *countedStream) Close() error {
+	c.report()
+	return c.ReadCloser.Close()
+}
+
+func (c *countedStream) report() {
+	if c.done {
+		return
+	}
+	c.done = true
+	if m := c.fetcher.daemon.metrics.Load(); m != nil {
+		m.AddBackendBytes(c.fetcher.name, c.fetcher.kind, metrics.BackendOpRead, c.n)
+	}
+}
@@ -0,0 +1,63 @@
+package fscache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/metrics"
+)
+
+// TestBackendMetrics 验证按数据源记录命中、未命中、错误和字节数,连续失败后标记为不健康
+func TestBackendMetrics(t *testing.T) {
+	failing := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case failing:
+			w.WriteHeader(http.StatusBadGateway)
+		case strings.Contains(r.URL.Path, "missing"):
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Write([]byte("chunk-data"))
+		}
+	}))
+	defer ts.Close()
+
+	m := metrics.NewMetrics()
+	d := &DedupDaemon{}
+	d.SetMetrics(m)
+	f := d.observe(BackendRegistry, ts.URL, NewRegistryFetcher(ts.URL, ts.Client()))
+
+	ctx := context.Background()
+	if _, err := f.Fetch(ctx, "app", "sha256:present", 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := fetchStream(ctx, f, "app", "sha256:present", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, rc)
+	rc.Close()
+	f.Fetch(ctx, "app", "sha256:missing", 0, 10)
+
+	backends := m.Backends()
+	if len(backends) != 1 {
+		t.Fatalf("expected one backend, got %+v", backends)
+	}
+	b := backends[0]
+	if b.Kind != BackendRegistry || b.Reads != 3 || b.Hits != 2 || b.Misses != 1 || b.Errors != 0 || b.BytesRead != 20 || !b.Healthy {
+		t.Errorf("unexpected stats %+v", b)
+	}
+
+	failing = true
+	for i := 0; i < metrics.BackendUnhealthyAfter; i++ {
+		f.Fetch(ctx, "app", "sha256:present", 0, 10)
+	}
+	if b := m.Backends()[0]; b.Healthy || b.Errors != int64(metrics.BackendUnhealthyAfter) || b.LastError == "" {
+		t.Errorf("expected failing backend to be unhealthy: %+v", b)
+	}
+	t.Logf("✓ 后端统计 %+v", m.Backends()[0])
+}
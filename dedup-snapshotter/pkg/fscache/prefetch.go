@@ -17,6 +17,8 @@ type Prefetcher struct {
 	mu             sync.RWMutex
 	maxConcurrent  int
 	predictorCache *PredictorCache
+	// resume 在预取暂停期间非空,Resume 时关闭以唤醒等待的任务
+	resume chan struct{}
 }
 
 type PrefetchJob struct {
@@ -24,10 +26,14 @@ type PrefetchJob struct {
 	ImageInfo    *ImageInfo
 	TraceEntries []*TraceEntry
 	Index        int
-	StartTime    time.Time
-	mu           sync.Mutex
-	ctx          context.Context
-	cancel       context.CancelFunc
+	// Skipped 是因兄弟镜像或本地存储已有而跳过的 chunk 数
+	Skipped int
+	// Filtered 是被预取过滤排除的 chunk 数
+	Filtered  int
+	StartTime time.Time
+	mu        sync.Mutex
+	ctx       context.Context
+	cancel    context.CancelFunc
 }
 
 type TraceEntry struct {
@@ -60,6 +66,11 @@ func NewPrefetcher(daemon *DedupDaemon) (*Prefetcher, error) {
 }
 
 func (p *Prefetcher) StartPrefetch(ctx context.Context, imageInfo *ImageInfo, traceFile string) error {
+	return p.StartPrefetchWithFilter(ctx, imageInfo, traceFile, nil)
+}
+
+// StartPrefetchWithFilter 按 trace 预取镜像,filter 不为 nil 时只预取通过过滤的 chunk
+func (p *Prefetcher) StartPrefetchWithFilter(ctx context.Context, imageInfo *ImageInfo, traceFile string, filter *PrefetchFilter) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
@@ -71,6 +82,9 @@ func (p *Prefetcher) StartPrefetch(ctx context.Context, imageInfo *ImageInfo, tr
 	if err != nil {
 		return fmt.Errorf("failed to load trace file: %w", err)
 	}
+	traces = resolveTraces(imageInfo.Manifest, traces)
+	resolved := len(traces)
+	traces = filter.Apply(imageInfo.Manifest, traces)
 
 	jobCtx, cancel := context.WithCancel(ctx)
 	job := &PrefetchJob{
@@ -78,6 +92,7 @@ func (p *Prefetcher) StartPrefetch(ctx context.Context, imageInfo *ImageInfo, tr
 		ImageInfo:    imageInfo,
 		TraceEntries: traces,
 		Index:        0,
+		Filtered:     resolved - len(traces),
 		StartTime:    time.Now(),
 		ctx:          jobCtx,
 		cancel:       cancel,
@@ -87,7 +102,11 @@ func (p *Prefetcher) StartPrefetch(ctx context.Context, imageInfo *ImageInfo, tr
 
 	go p.runPrefetchJob(job)
 
-	log.L.Infof("started prefetch for image %s with %d trace entries", imageInfo.ImageID, len(traces))
+	if filter != nil {
+		log.G(ctx).Infof("started prefetch for image %s with %d trace entries, %d filtered out", imageInfo.ImageID, len(traces), resolved-len(traces))
+	} else {
+		log.G(ctx).Infof("started prefetch for image %s with %d trace entries", imageInfo.ImageID, len(traces))
+	}
 	return nil
 }
 
@@ -97,31 +116,41 @@ func (p *Prefetcher) loadTraceFile(traceFile string) ([]*TraceEntry, error) {
 		return nil, err
 	}
 
+	// 每行一个 chunk 哈希,按访问顺序排列;偏移和大小由 resolveTraces 从清单中获取
 	var traces []*TraceEntry
-	lines := splitLines(string(data))
-
-	var offset int64
-	const defaultChunkSize = 4 * 1024 * 1024
-
-	for _, line := range lines {
+	for _, line := range splitLines(string(data)) {
 		if line == "" {
 			continue
 		}
 
-		entry := &TraceEntry{
-			Offset:    offset,
-			Size:      defaultChunkSize,
+		traces = append(traces, &TraceEntry{
 			ChunkHash: line,
 			Timestamp: time.Now().UnixNano(),
-		}
-
-		traces = append(traces, entry)
-		offset += defaultChunkSize
+		})
 	}
 
 	return traces, nil
 }
 
+// resolveTraces 用清单中的 chunk 位置填充 trace 的偏移和大小,丢弃清单中不存在的 chunk
+func resolveTraces(manifest *ImageManifest, traces []*TraceEntry) []*TraceEntry {
+	resolved := traces[:0]
+	for _, trace := range traces {
+		loc, ok := manifest.Chunks[trace.ChunkHash]
+		if !ok {
+			continue
+		}
+		trace.Offset = loc.Offset
+		trace.Size = loc.Size
+		resolved = append(resolved, trace)
+	}
+
+	if dropped := len(traces) - len(resolved); dropped > 0 {
+		log.L.Warnf("%d trace entries reference chunks missing from the manifest", dropped)
+	}
+	return resolved
+}
+
 func (p *Prefetcher) runPrefetchJob(job *PrefetchJob) {
 	defer func() {
 		p.mu.Lock()
@@ -134,6 +163,11 @@ func (p *Prefetcher) runPrefetchJob(job *PrefetchJob) {
 	var wg sync.WaitGroup
 
 	for i, entry := range job.TraceEntries {
+		if !p.waitResumed(job.ctx) {
+			log.L.Infof("prefetch job cancelled for image %s", job.ImageID)
+			wg.Wait()
+			return
+		}
 		select {
 		case <-job.ctx.Done():
 			log.L.Infof("prefetch job cancelled for image %s", job.ImageID)
@@ -171,14 +205,23 @@ func (p *Prefetcher) prefetchChunk(job *PrefetchJob, trace *TraceEntry) error {
 		return nil
 	}
 
-	layerDigest := ""
-	if len(job.ImageInfo.Manifest.Layers) > 0 {
-		layerDigest = job.ImageInfo.Manifest.Layers[0].Digest
+	loc, ok := job.ImageInfo.Manifest.Chunks[trace.ChunkHash]
+	if !ok {
+		return fmt.Errorf("chunk %s not in manifest", trace.ChunkHash)
+	}
+
+	// 基础层与其他镜像相同时,chunk 已由兄弟镜像下载过
+	if p.daemon.ChunkPresent(trace.ChunkHash) {
+		job.mu.Lock()
+		job.Skipped++
+		job.mu.Unlock()
+		log.L.Debugf("chunk %s of %s already present, skipping prefetch", trace.ChunkHash, job.ImageID)
+		return nil
 	}
 
 	task := &DownloadTask{
 		ImageID:     job.ImageID,
-		LayerDigest: layerDigest,
+		LayerDigest: loc.LayerDigest,
 		ChunkHash:   trace.ChunkHash,
 		Offset:      trace.Offset,
 		Size:        trace.Size,
@@ -259,6 +302,9 @@ func (p *Prefetcher) GetJobStatus(imageID string) *PrefetchStatus {
 		ImageID:      job.ImageID,
 		TotalEntries: totalEntries,
 		Completed:    job.Index,
+		Skipped:      job.Skipped,
+		Filtered:     job.Filtered,
+		Paused:       p.resume != nil,
 		Progress:     progress,
 		StartTime:    job.StartTime,
 		Elapsed:      time.Since(job.StartTime),
@@ -283,6 +329,50 @@ func (p *Prefetcher) GetAllJobStatuses() []*PrefetchStatus {
 	return statuses
 }
 
+// Pause 暂停所有预取任务:正在下载的 chunk 完成后不再发起新的下载,新启动的任务也等待,Resume 后从暂停处继续
+func (p *Prefetcher) Pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resume == nil {
+		p.resume = make(chan struct{})
+		log.L.Info("prefetch paused")
+	}
+}
+
+// Resume 恢复暂停的预取任务
+func (p *Prefetcher) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resume != nil {
+		close(p.resume)
+		p.resume = nil
+		log.L.Info("prefetch resumed")
+	}
+}
+
+// Paused 报告预取是否处于暂停状态
+func (p *Prefetcher) Paused() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.resume != nil
+}
+
+// waitResumed 在预取暂停时阻塞到恢复,任务被取消时返回 false
+func (p *Prefetcher) waitResumed(ctx context.Context) bool {
+	p.mu.RLock()
+	resume := p.resume
+	p.mu.RUnlock()
+	if resume == nil {
+		return true
+	}
+	select {
+	case <-resume:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
 func (p *Prefetcher) Stop() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -299,6 +389,9 @@ type PrefetchStatus struct {
 	ImageID      string
 	TotalEntries int
 	Completed    int
+	Skipped      int
+	Filtered     int
+	Paused       bool
 	Progress     float64
 	StartTime    time.Time
 	Elapsed      time.Duration
@@ -0,0 +1,46 @@
+package fscache
+
+import (
+	"context"
+	"testing"
+)
+
+// TestPrefetchSkipsSiblingChunks 验证兄弟镜像已缓存或本地存储已有的 chunk 不再入队下载
+func TestPrefetchSkipsSiblingChunks(t *testing.T) {
+	daemon := &DedupDaemon{
+		ctx:           context.Background(),
+		downloadQueue: make(chan *DownloadTask, 10),
+		cachedChunks:  map[string]int{"base": 1},
+		localChunk:    func(hash string) bool { return hash == "local" },
+	}
+	prefetcher, _ := NewPrefetcher(daemon)
+
+	manifest := &ImageManifest{Chunks: map[string]*ChunkLocation{
+		"base":  {LayerDigest: "sha256:l1", Size: 10},
+		"local": {LayerDigest: "sha256:l1", Offset: 10, Size: 10},
+		"app":   {LayerDigest: "sha256:l2", Size: 10},
+	}}
+	job := &PrefetchJob{
+		ImageID:   "image-b",
+		ImageInfo: &ImageInfo{ImageID: "image-b", Volume: &Volume{Objects: map[string]*CacheObject{}}, Manifest: manifest},
+	}
+
+	for _, hash := range []string{"base", "local", "app"} {
+		if err := prefetcher.prefetchChunk(job, &TraceEntry{ChunkHash: hash, Size: 10}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(daemon.downloadQueue) != 1 || job.Skipped != 2 {
+		t.Fatalf("expected only app to be queued, got %d queued and %d skipped", len(daemon.downloadQueue), job.Skipped)
+	}
+	if task := <-daemon.downloadQueue; task.ChunkHash != "app" {
+		t.Fatalf("unexpected queued chunk %s", task.ChunkHash)
+	}
+
+	daemon.handleEviction(EvictionEvent{Volume: "image-a", Key: "base"})
+	if daemon.ChunkPresent("base") {
+		t.Error("expected evicted chunk to leave the global index")
+	}
+	t.Logf("✓ 跳过 %d 个已存在的 chunk", job.Skipped)
+}
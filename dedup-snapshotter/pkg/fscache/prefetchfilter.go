@@ -0,0 +1,170 @@
+package fscache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// 跳过语言包和文档时排除的目录
+var (
+	localeDirs = []string{"/usr/share/locale", "/usr/lib/locale", "/usr/share/i18n"}
+	docDirs    = []string{"/usr/share/doc", "/usr/share/man", "/usr/share/info", "/usr/share/gtk-doc"}
+)
+
+// PrefetchFilter 限定预取的数据,各条件依次作用于 trace 中的每个 chunk,引用 chunk 的文件中
+// 有一个通过时保留该 chunk。
+//
+// Include 和 Exclude 为 glob:不含 "/" 时匹配文件名,否则匹配绝对路径,以 "/**" 结尾时匹配目录下的
+// 全部文件。Include 非空时只预取匹配的文件;MaxFileMB 大于 0 时每个文件只预取开头的 N MB
+type PrefetchFilter struct {
+	Include     []string `json:"include,omitempty"`
+	Exclude     []string `json:"exclude,omitempty"`
+	MaxFileMB   int64    `json:"max_file_mb,omitempty"`
+	SkipLocales bool     `json:"skip_locales,omitempty"`
+	SkipDocs    bool     `json:"skip_docs,omitempty"`
+}
+
+// Validate 检查 glob 语法和数值
+func (f *PrefetchFilter) Validate() error {
+	for _, pattern := range append(append([]string(nil), f.Include...), f.Exclude...) {
+		if _, err := path.Match(strings.TrimSuffix(pattern, "/**"), ""); err != nil {
+			return fmt.Errorf("invalid glob %q: %w", pattern, err)
+		}
+	}
+	if f.MaxFileMB < 0 {
+		return fmt.Errorf("max_file_mb must not be negative")
+	}
+	return nil
+}
+
+// Apply 返回通过过滤的 trace 条目,保持原有顺序
+func (f *PrefetchFilter) Apply(manifest *ImageManifest, traces []*TraceEntry) []*TraceEntry {
+	if f == nil {
+		return traces
+	}
+	kept := make([]*TraceEntry, 0, len(traces))
+	for _, trace := range traces {
+		loc, ok := manifest.Chunks[trace.ChunkHash]
+		// 没有文件信息的 chunk(如元数据)无法按文件判断,保留
+		if !ok || len(loc.Files) == 0 {
+			kept = append(kept, trace)
+			continue
+		}
+		for _, ref := range loc.Files {
+			if f.keep(ref) {
+				kept = append(kept, trace)
+				break
+			}
+		}
+	}
+	return kept
+}
+
+func (f *PrefetchFilter) keep(ref ChunkFileRef) bool {
+	if f.MaxFileMB > 0 && ref.FileOffset >= f.MaxFileMB<<20 {
+		return false
+	}
+	if f.SkipLocales && underAny(ref.Path, localeDirs) {
+		return false
+	}
+	if f.SkipDocs && underAny(ref.Path, docDirs) {
+		return false
+	}
+	if matchAny(ref.Path, f.Exclude) {
+		return false
+	}
+	return len(f.Include) == 0 || matchAny(ref.Path, f.Include)
+}
+
+func underAny(p string, dirs []string) bool {
+	for _, dir := range dirs {
+		if strings.HasPrefix(p, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(p string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if dir, ok := strings.CutSuffix(pattern, "/**"); ok {
+			for d := path.Dir(p); d != "/" && d != "."; d = path.Dir(d) {
+				if matched, _ := path.Match(dir, d); matched {
+					return true
+				}
+			}
+			continue
+		}
+		target := p
+		if !strings.Contains(pattern, "/") {
+			target = path.Base(p)
+		}
+		if matched, _ := path.Match(pattern, target); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// PrefetchPolicy 是按镜像定义预取过滤的策略文件。Images 的键为镜像 ID 或匹配镜像 ID 的 glob,
+// 精确匹配优先,其次按键的字典序取第一个匹配的 glob,都不匹配时使用 Default
+type PrefetchPolicy struct {
+	Default *PrefetchFilter            `json:"default,omitempty"`
+	Images  map[string]*PrefetchFilter `json:"images,omitempty"`
+}
+
+// LoadPrefetchPolicy 读取并校验策略文件
+func LoadPrefetchPolicy(file string) (*PrefetchPolicy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var policy PrefetchPolicy
+	if err := dec.Decode(&policy); err != nil {
+		return nil, fmt.Errorf("invalid prefetch policy %s: %w", file, err)
+	}
+
+	if policy.Default != nil {
+		if err := policy.Default.Validate(); err != nil {
+			return nil, fmt.Errorf("prefetch policy %s default: %w", file, err)
+		}
+	}
+	for key, filter := range policy.Images {
+		if _, err := path.Match(key, ""); err != nil {
+			return nil, fmt.Errorf("prefetch policy %s: invalid image pattern %q: %w", file, key, err)
+		}
+		if filter == nil {
+			return nil, fmt.Errorf("prefetch policy %s: image %s has no filter", file, key)
+		}
+		if err := filter.Validate(); err != nil {
+			return nil, fmt.Errorf("prefetch policy %s image %s: %w", file, key, err)
+		}
+	}
+	return &policy, nil
+}
+
+// For 返回镜像使用的过滤,没有适用的过滤时返回 nil
+func (p *PrefetchPolicy) For(imageID string) *PrefetchFilter {
+	if filter, ok := p.Images[imageID]; ok {
+		return filter
+	}
+	patterns := make([]string, 0, len(p.Images))
+	for key := range p.Images {
+		patterns = append(patterns, key)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, imageID); matched {
+			return p.Images[pattern]
+		}
+	}
+	return p.Default
+}
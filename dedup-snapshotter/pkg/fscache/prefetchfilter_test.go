@@ -0,0 +1,96 @@
+package fscache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestPrefetchFilter 验证按 glob、文件开头大小以及语言包和文档目录过滤 trace,共享 chunk 只要一个文件通过即保留
+func TestPrefetchFilter(t *testing.T) {
+	manifest := &ImageManifest{Chunks: map[string]*ChunkLocation{
+		"bin":    {Files: []ChunkFileRef{{Path: "/usr/bin/app"}}},
+		"bin2":   {Files: []ChunkFileRef{{Path: "/usr/bin/app", FileOffset: 8 << 20}}},
+		"locale": {Files: []ChunkFileRef{{Path: "/usr/share/locale/de/LC_MESSAGES/app.mo"}}},
+		"doc":    {Files: []ChunkFileRef{{Path: "/usr/share/doc/app/README"}}},
+		"shared": {Files: []ChunkFileRef{{Path: "/usr/share/doc/app/LICENSE"}, {Path: "/etc/app/LICENSE"}}},
+		"lib":    {Files: []ChunkFileRef{{Path: "/usr/lib/python3/site.py"}}},
+		"meta":   {},
+	}}
+	traces := func() []*TraceEntry {
+		var entries []*TraceEntry
+		for _, hash := range []string{"meta", "bin", "bin2", "locale", "doc", "shared", "lib"} {
+			entries = append(entries, &TraceEntry{ChunkHash: hash})
+		}
+		return entries
+	}
+	hashes := func(entries []*TraceEntry) []string {
+		var out []string
+		for _, e := range entries {
+			out = append(out, e.ChunkHash)
+		}
+		return out
+	}
+
+	cases := []struct {
+		name   string
+		filter *PrefetchFilter
+		want   []string
+	}{
+		{"nil", nil, []string{"meta", "bin", "bin2", "locale", "doc", "shared", "lib"}},
+		{"skip", &PrefetchFilter{SkipLocales: true, SkipDocs: true, MaxFileMB: 4}, []string{"meta", "bin", "shared", "lib"}},
+		{"include", &PrefetchFilter{Include: []string{"/usr/bin/**", "*.py"}}, []string{"meta", "bin", "bin2", "lib"}},
+		{"exclude", &PrefetchFilter{Exclude: []string{"LICENSE", "/usr/share/*/app/**"}}, []string{"meta", "bin", "bin2", "locale", "lib"}},
+	}
+	for _, c := range cases {
+		got := hashes(c.filter.Apply(manifest, traces()))
+		if len(got) != len(c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+				break
+			}
+		}
+	}
+	t.Logf("✓ 过滤条件按文件作用于 chunk")
+}
+
+// TestPrefetchPolicy 验证策略文件按镜像 ID 精确匹配、glob 匹配和默认值选择过滤,并拒绝无效的 glob
+func TestPrefetchPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	os.WriteFile(path, []byte(`{
+		"default": {"skip_docs": true},
+		"images": {
+			"web-*": {"max_file_mb": 16},
+			"web-api": {"include": ["/app/**"]}
+		}
+	}`), 0644)
+
+	policy, err := LoadPrefetchPolicy(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := policy.For("web-api"); f == nil || len(f.Include) != 1 {
+		t.Errorf("expected exact match for web-api, got %+v", f)
+	}
+	if f := policy.For("web-frontend"); f == nil || f.MaxFileMB != 16 {
+		t.Errorf("expected glob match for web-frontend, got %+v", f)
+	}
+	if f := policy.For("db"); f == nil || !f.SkipDocs {
+		t.Errorf("expected default for db, got %+v", f)
+	}
+	t.Logf("✓ 按镜像选择过滤")
+
+	os.WriteFile(path, []byte(`{"images": {"x": {"include": ["[bad"]}}}`), 0644)
+	if _, err := LoadPrefetchPolicy(path); err == nil {
+		t.Error("expected invalid glob to be rejected")
+	}
+	os.WriteFile(path, []byte(`{"default": {"skip_doc": true}}`), 0644)
+	if _, err := LoadPrefetchPolicy(path); err == nil {
+		t.Error("expected unknown field to be rejected")
+	}
+	t.Logf("✓ 无效策略被拒绝")
+}
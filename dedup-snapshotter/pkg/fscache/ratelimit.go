@@ -0,0 +1,238 @@
+package fscache
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// TokenBucket 按 rate 字节/秒补充令牌,最多积累 burst 个,burst 为 0 时取 rate
+type TokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(rate, burst float64) *TokenBucket {
+	if burst <= 0 {
+		burst = rate
+	}
+	return &TokenBucket{rate: rate, burst: burst, tokens: burst}
+}
+
+func (b *TokenBucket) refill(now time.Time) {
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+}
+
+// wait 返回获得 n 个令牌还需等待的时间;超过 burst 的请求按 burst 计算,避免永远无法满足
+func (b *TokenBucket) wait(now time.Time, n float64) time.Duration {
+	b.refill(now)
+	n = math.Min(n, b.burst)
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *TokenBucket) take(n float64) {
+	b.tokens -= math.Min(n, b.burst)
+}
+
+func (b *TokenBucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// OnDemandScheduler 在全局和每个镜像的令牌桶限制下,按镜像轮询分发按需读取任务,
+// 大量容器同时冷启动时单个镜像的突发读取不会饿死其他镜像。
+// rate 为 0 表示不限速。
+type OnDemandScheduler struct {
+	mu         sync.Mutex
+	global     *TokenBucket
+	imageRate  float64
+	imageBurst float64
+	buckets    map[string]*TokenBucket
+	queues     map[string][]*DownloadTask
+	order      []string
+	next       int
+	maxQueue   int
+	notify     chan struct{}
+	out        chan<- *DownloadTask
+}
+
+func NewOnDemandScheduler(out chan<- *DownloadTask, globalRate, imageRate, burst int64) *OnDemandScheduler {
+	s := &OnDemandScheduler{
+		buckets:  make(map[string]*TokenBucket),
+		queues:   make(map[string][]*DownloadTask),
+		maxQueue: 1000,
+		notify:   make(chan struct{}, 1),
+		out:      out,
+	}
+	s.SetLimits(globalRate, imageRate, burst)
+	return s
+}
+
+// SetLimits 调整限速,已有的令牌桶按新参数重建
+func (s *OnDemandScheduler) SetLimits(globalRate, imageRate, burst int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.global = nil
+	if globalRate > 0 {
+		s.global = NewTokenBucket(float64(globalRate), float64(burst))
+	}
+	s.imageRate = float64(imageRate)
+	s.imageBurst = float64(burst)
+	s.buckets = make(map[string]*TokenBucket)
+}
+
+func (s *OnDemandScheduler) Submit(task *DownloadTask) error {
+	s.mu.Lock()
+	queue, ok := s.queues[task.ImageID]
+	if len(queue) >= s.maxQueue {
+		s.mu.Unlock()
+		return fmt.Errorf("on-demand queue full for image %s", task.ImageID)
+	}
+	if !ok {
+		s.order = append(s.order, task.ImageID)
+	}
+	s.queues[task.ImageID] = append(queue, task)
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (s *OnDemandScheduler) Run(ctx context.Context) {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		task, wait := s.dispatch(time.Now())
+		if task != nil {
+			select {
+			case s.out <- task:
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+
+		var timeout <-chan time.Time
+		if wait > 0 {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(wait)
+			timeout = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.notify:
+		case <-timeout:
+		}
+	}
+}
+
+// dispatch 从上次停下的镜像开始轮询,返回第一个令牌充足的任务;
+// 没有可分发任务时返回最早可分发的等待时间,队列全空时返回 0
+func (s *OnDemandScheduler) dispatch(now time.Time) (*DownloadTask, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var minWait time.Duration
+	for i := 0; i < len(s.order); {
+		if s.next >= len(s.order) {
+			s.next = 0
+		}
+		imageID := s.order[s.next]
+		queue := s.queues[imageID]
+		if len(queue) == 0 {
+			s.removeImage(s.next, now)
+			continue
+		}
+
+		task := queue[0]
+		cost := float64(task.Size)
+		wait := time.Duration(0)
+
+		bucket := s.bucket(imageID)
+		if bucket != nil {
+			wait = bucket.wait(now, cost)
+		}
+		if s.global != nil {
+			if w := s.global.wait(now, cost); w > wait {
+				wait = w
+			}
+		}
+
+		if wait == 0 {
+			if bucket != nil {
+				bucket.take(cost)
+			}
+			if s.global != nil {
+				s.global.take(cost)
+			}
+			s.queues[imageID] = queue[1:]
+			s.next++
+			return task, 0
+		}
+
+		if minWait == 0 || wait < minWait {
+			minWait = wait
+		}
+		s.next++
+		i++
+	}
+	return nil, minWait
+}
+
+func (s *OnDemandScheduler) bucket(imageID string) *TokenBucket {
+	if s.imageRate <= 0 {
+		return nil
+	}
+	b, ok := s.buckets[imageID]
+	if !ok {
+		b = NewTokenBucket(s.imageRate, s.imageBurst)
+		s.buckets[imageID] = b
+	}
+	return b
+}
+
+// removeImage 把队列已空的镜像移出轮询,令牌桶已满时一并释放
+func (s *OnDemandScheduler) removeImage(idx int, now time.Time) {
+	imageID := s.order[idx]
+	delete(s.queues, imageID)
+	if b, ok := s.buckets[imageID]; ok && b.full(now) {
+		delete(s.buckets, imageID)
+	}
+	s.order = append(s.order[:idx], s.order[idx+1:]...)
+}
+
+// Pending 返回每个镜像排队中的按需读取数
+func (s *OnDemandScheduler) Pending() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pending := make(map[string]int, len(s.queues))
+	for id, queue := range s.queues {
+		if len(queue) > 0 {
+			pending[id] = len(queue)
+		}
+	}
+	return pending
+}
@@ -0,0 +1,68 @@
+package fscache
+
+import (
+	"testing"
+	"time"
+)
+
+// TestOnDemandSchedulerFairness 验证镜像间轮询分发,以及单镜像令牌耗尽时不阻塞其他镜像
+func TestOnDemandSchedulerFairness(t *testing.T) {
+	s := NewOnDemandScheduler(nil, 0, 100, 200)
+	for i := 0; i < 5; i++ {
+		s.Submit(&DownloadTask{ImageID: "noisy", Size: 100})
+	}
+	s.Submit(&DownloadTask{ImageID: "quiet", Size: 100})
+
+	now := time.Now()
+	var order []string
+	for {
+		task, _ := s.dispatch(now)
+		if task == nil {
+			break
+		}
+		order = append(order, task.ImageID)
+	}
+
+	// noisy 的突发额度为 2 个任务,quiet 在第二个位置得到服务
+	expected := []string{"noisy", "quiet", "noisy"}
+	if len(order) != len(expected) {
+		t.Fatalf("expected dispatch order %v, got %v", expected, order)
+	}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("expected dispatch order %v, got %v", expected, order)
+		}
+	}
+	t.Logf("✓ 轮询分发顺序 %v", order)
+
+	task, wait := s.dispatch(now)
+	if task != nil || wait != time.Second {
+		t.Fatalf("expected noisy image to wait 1s for tokens, got task=%v wait=%v", task, wait)
+	}
+	if task, _ := s.dispatch(now.Add(time.Second)); task == nil || task.ImageID != "noisy" {
+		t.Fatalf("expected noisy task after refill, got %v", task)
+	}
+	if pending := s.Pending(); pending["noisy"] != 2 {
+		t.Errorf("expected 2 pending noisy tasks, got %v", pending)
+	}
+	t.Logf("✓ 单镜像令牌耗尽后按速率补充")
+}
+
+// TestOnDemandSchedulerGlobalLimit 验证全局令牌桶限制所有镜像的总速率
+func TestOnDemandSchedulerGlobalLimit(t *testing.T) {
+	s := NewOnDemandScheduler(nil, 100, 0, 100)
+	s.Submit(&DownloadTask{ImageID: "a", Size: 100})
+	s.Submit(&DownloadTask{ImageID: "b", Size: 100})
+
+	now := time.Now()
+	if task, _ := s.dispatch(now); task == nil || task.ImageID != "a" {
+		t.Fatalf("expected first task from a, got %v", task)
+	}
+	if task, wait := s.dispatch(now); task != nil || wait != time.Second {
+		t.Fatalf("expected global limit to delay b by 1s, got task=%v wait=%v", task, wait)
+	}
+	if task, _ := s.dispatch(now.Add(time.Second)); task == nil || task.ImageID != "b" {
+		t.Fatalf("expected task from b after refill, got %v", task)
+	}
+	t.Logf("✓ 全局限速生效")
+}
@@ -0,0 +1,124 @@
+package fscache
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// PlanEntry 是合并后预取计划中的一个 chunk。Frequency 为出现该 chunk 的运行占比,
+// Position 为它在各次运行中的期望相对位置(0 为最先访问),未出现的运行按末尾计
+type PlanEntry struct {
+	ChunkHash string  `json:"chunk_hash"`
+	Frequency float64 `json:"frequency"`
+	Position  float64 `json:"position"`
+}
+
+// PrefetchPlan 是由多次运行的 trace 合并得到的预取计划,Dropped 为低于最小出现频率而舍弃的 chunk 数,
+// Trace 为计划在 trace 目录中的 ID
+type PrefetchPlan struct {
+	Runs    int         `json:"runs"`
+	Chunks  []PlanEntry `json:"chunks"`
+	Dropped int         `json:"dropped,omitempty"`
+	Path    string      `json:"path,omitempty"`
+	Trace   string      `json:"trace,omitempty"`
+}
+
+// LoadTraces 读取 trace 文件,每个文件为一次运行,格式同 StartPrefetch 使用的 trace
+func LoadTraces(paths []string) ([][]string, error) {
+	runs := make([][]string, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read trace %s: %w", path, err)
+		}
+		var run []string
+		for _, line := range splitLines(string(data)) {
+			if line = strings.TrimSpace(line); line != "" {
+				run = append(run, line)
+			}
+		}
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+// MergeTraces 合并同一镜像多次运行的访问序列:每次运行内按首次访问去重,各运行取并集,
+// 按期望位置排序。只在少数运行中出现的 chunk 期望位置靠后,因此稳定访问的 chunk 优先预取。
+// minFrequency 大于 0 时舍弃出现频率低于它的 chunk
+func MergeTraces(runs [][]string, minFrequency float64) *PrefetchPlan {
+	type stat struct {
+		seen     int
+		position float64
+		first    int
+	}
+
+	stats := make(map[string]*stat)
+	var order []string
+	for _, run := range runs {
+		seen := make(map[string]bool, len(run))
+		var unique []string
+		for _, hash := range run {
+			if !seen[hash] {
+				seen[hash] = true
+				unique = append(unique, hash)
+			}
+		}
+		for i, hash := range unique {
+			s, ok := stats[hash]
+			if !ok {
+				s = &stat{first: len(order)}
+				stats[hash] = s
+				order = append(order, hash)
+			}
+			s.seen++
+			s.position += float64(i) / float64(len(unique))
+		}
+	}
+
+	plan := &PrefetchPlan{Runs: len(runs), Chunks: []PlanEntry{}}
+	for _, hash := range order {
+		s := stats[hash]
+		frequency := float64(s.seen) / float64(len(runs))
+		if frequency < minFrequency {
+			plan.Dropped++
+			continue
+		}
+		missing := float64(len(runs) - s.seen)
+		plan.Chunks = append(plan.Chunks, PlanEntry{
+			ChunkHash: hash,
+			Frequency: frequency,
+			Position:  (s.position + missing) / float64(len(runs)),
+		})
+	}
+
+	sort.SliceStable(plan.Chunks, func(i, j int) bool {
+		a, b := plan.Chunks[i], plan.Chunks[j]
+		if a.Position != b.Position {
+			return a.Position < b.Position
+		}
+		return a.Frequency > b.Frequency
+	})
+	return plan
+}
+
+// WriteTrace 把计划按顺序写成 trace 文件,可直接用于 StartPrefetch
+func (p *PrefetchPlan) WriteTrace(path string) error {
+	var b strings.Builder
+	for _, entry := range p.Chunks {
+		b.WriteString(entry.ChunkHash)
+		b.WriteByte('\n')
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	p.Path = path
+	return nil
+}
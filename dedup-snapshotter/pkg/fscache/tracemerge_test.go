@@ -0,0 +1,52 @@
+package fscache
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestMergeTraces 验证多次运行的 trace 去重合并、按频率加权排序,并能作为预取 trace 读回
+func TestMergeTraces(t *testing.T) {
+	runs := [][]string{
+		{"a", "b", "c", "a", "d"},
+		{"a", "c", "b", "e"},
+		{"b", "a", "c"},
+	}
+
+	plan := MergeTraces(runs, 0)
+	var order []string
+	for _, entry := range plan.Chunks {
+		order = append(order, entry.ChunkHash)
+	}
+	// d 和 e 各只出现一次,排在稳定访问的 chunk 之后
+	if want := []string{"a", "b", "c", "d", "e"}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("expected %v, got %v", want, order)
+	}
+	if plan.Chunks[0].Frequency != 1 || plan.Chunks[4].Frequency != 1.0/3 {
+		t.Errorf("unexpected frequencies %+v", plan.Chunks)
+	}
+
+	filtered := MergeTraces(runs, 0.5)
+	if len(filtered.Chunks) != 3 || filtered.Dropped != 2 {
+		t.Errorf("expected 3 chunks and 2 dropped, got %+v", filtered)
+	}
+
+	path := filepath.Join(t.TempDir(), "app.plan")
+	if err := filtered.WriteTrace(path); err != nil {
+		t.Fatal(err)
+	}
+	p := &Prefetcher{}
+	traces, err := p.loadTraceFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(traces) != 3 || traces[0].ChunkHash != "a" {
+		t.Errorf("plan not readable as trace: %+v", traces)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Error("temporary plan file left behind")
+	}
+	t.Logf("✓ 合并 %d 次运行得到 %d 个 chunk 的预取计划", plan.Runs, len(plan.Chunks))
+}
@@ -0,0 +1,208 @@
+package fscache
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTraceWindow 是等待容器启动信号的最长时间,超过后追踪以未启动结束
+	DefaultTraceWindow = 5 * time.Minute
+	// DefaultTraceHistory 是保留的已结束追踪数
+	DefaultTraceHistory = 256
+)
+
+// StartupTrace 记录一次容器冷启动:从快照挂载到 ENTRYPOINT 启动之间的首次读取延迟、
+// 按需读取次数和下载字节数,以及挂载时这些镜像已在本地缓存的比例(预取覆盖率)
+type StartupTrace struct {
+	Key              string        `json:"key"`
+	Images           []string      `json:"images"`
+	MountedAt        time.Time     `json:"mounted_at"`
+	PrefetchCoverage float64       `json:"prefetch_coverage"`
+	FirstRead        time.Duration `json:"first_read,omitempty"`
+	Faults           int64         `json:"faults"`
+	FaultBytes       int64         `json:"fault_bytes"`
+	BytesFetched     int64         `json:"bytes_fetched"`
+	ColdStart        time.Duration `json:"cold_start,omitempty"`
+	// Started 为 false 表示在追踪窗口内没有收到启动信号
+	Started bool `json:"started"`
+	Done    bool `json:"done"`
+}
+
+// StartupTracer 跟踪挂载中的快照,按镜像把按需读取和下载归到所有引用该镜像的活动追踪上。
+// 启动信号由 CRI 代理在 StartContainer 成功后经 API 送达,快照键即容器 ID
+type StartupTracer struct {
+	mu       sync.Mutex
+	window   time.Duration
+	limit    int
+	active   map[string]*StartupTrace
+	history  []StartupTrace
+	onFinish []func(StartupTrace)
+	now      func() time.Time
+}
+
+func NewStartupTracer() *StartupTracer {
+	return NewStartupTracerWithOptions(DefaultTraceWindow, DefaultTraceHistory)
+}
+
+func NewStartupTracerWithOptions(window time.Duration, history int) *StartupTracer {
+	if window <= 0 {
+		window = DefaultTraceWindow
+	}
+	if history <= 0 {
+		history = DefaultTraceHistory
+	}
+	return &StartupTracer{
+		window: window,
+		limit:  history,
+		active: make(map[string]*StartupTrace),
+		now:    time.Now,
+	}
+}
+
+// OnFinish 注册追踪结束时的回调,回调在锁外调用
+func (t *StartupTracer) OnFinish(fn func(StartupTrace)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onFinish = append(t.onFinish, fn)
+}
+
+// Begin 在快照挂载后开始追踪,同一个键重复挂载时保留原来的追踪
+func (t *StartupTracer) Begin(key string, images []string, coverage float64) {
+	finished := t.expire()
+
+	t.mu.Lock()
+	if _, ok := t.active[key]; !ok {
+		t.active[key] = &StartupTrace{
+			Key:              key,
+			Images:           append([]string(nil), images...),
+			MountedAt:        t.now(),
+			PrefetchCoverage: coverage,
+		}
+	}
+	t.mu.Unlock()
+
+	t.notify(finished)
+}
+
+// RecordFault 记录镜像上一次缓存未命中的按需读取
+func (t *StartupTracer) RecordFault(imageID string, size int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	for _, trace := range t.active {
+		if !containsImage(trace.Images, imageID) {
+			continue
+		}
+		if trace.Faults == 0 {
+			trace.FirstRead = now.Sub(trace.MountedAt)
+		}
+		trace.Faults++
+		trace.FaultBytes += size
+	}
+}
+
+// RecordFetch 记录为镜像下载的字节数,包括按需读取、元数据预热和预取
+func (t *StartupTracer) RecordFetch(imageID string, size int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, trace := range t.active {
+		if containsImage(trace.Images, imageID) {
+			trace.BytesFetched += size
+		}
+	}
+}
+
+// MarkStarted 在容器 ENTRYPOINT 启动后结束追踪,键没有活动追踪时返回 false
+func (t *StartupTracer) MarkStarted(key string) (StartupTrace, bool) {
+	t.mu.Lock()
+	trace, ok := t.active[key]
+	if !ok {
+		t.mu.Unlock()
+		return StartupTrace{}, false
+	}
+	trace.Started = true
+	trace.ColdStart = t.now().Sub(trace.MountedAt)
+	done := t.finishLocked(trace)
+	t.mu.Unlock()
+
+	t.notify([]StartupTrace{done})
+	return done, true
+}
+
+// Forget 丢弃快照的活动追踪,用于快照在启动前被删除
+func (t *StartupTracer) Forget(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.active, key)
+}
+
+// Traces 返回活动追踪和已结束的追踪,按挂载时间排序
+func (t *StartupTracer) Traces() []StartupTrace {
+	finished := t.expire()
+	t.notify(finished)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	traces := append([]StartupTrace(nil), t.history...)
+	for _, trace := range t.active {
+		traces = append(traces, *trace)
+	}
+	sort.Slice(traces, func(i, j int) bool {
+		return traces[i].MountedAt.Before(traces[j].MountedAt)
+	})
+	return traces
+}
+
+// expire 结束超过追踪窗口仍未收到启动信号的追踪
+func (t *StartupTracer) expire() []StartupTrace {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var finished []StartupTrace
+	now := t.now()
+	for _, trace := range t.active {
+		if now.Sub(trace.MountedAt) > t.window {
+			finished = append(finished, t.finishLocked(trace))
+		}
+	}
+	return finished
+}
+
+func (t *StartupTracer) finishLocked(trace *StartupTrace) StartupTrace {
+	delete(t.active, trace.Key)
+	trace.Done = true
+	t.history = append(t.history, *trace)
+	if len(t.history) > t.limit {
+		t.history = t.history[len(t.history)-t.limit:]
+	}
+	return *trace
+}
+
+func (t *StartupTracer) notify(traces []StartupTrace) {
+	if len(traces) == 0 {
+		return
+	}
+	t.mu.Lock()
+	hooks := t.onFinish
+	t.mu.Unlock()
+
+	for _, trace := range traces {
+		for _, fn := range hooks {
+			fn(trace)
+		}
+	}
+}
+
+func containsImage(images []string, imageID string) bool {
+	for _, id := range images {
+		if id == imageID {
+			return true
+		}
+	}
+	return false
+}
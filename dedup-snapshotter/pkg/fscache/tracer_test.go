@@ -0,0 +1,52 @@
+package fscache
+
+import (
+	"testing"
+	"time"
+)
+
+// TestStartupTracer 验证按需读取和下载按镜像归到活动追踪,启动信号和超时都会结束追踪
+func TestStartupTracer(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tracer := NewStartupTracerWithOptions(time.Minute, 10)
+	tracer.now = func() time.Time { return now }
+
+	var finished []StartupTrace
+	tracer.OnFinish(func(trace StartupTrace) { finished = append(finished, trace) })
+
+	tracer.Begin("ctr-a", []string{"base", "app"}, 0.5)
+	tracer.Begin("ctr-b", []string{"base"}, 1)
+
+	now = now.Add(200 * time.Millisecond)
+	tracer.RecordFault("app", 4096)
+	now = now.Add(100 * time.Millisecond)
+	tracer.RecordFault("base", 8192)
+	tracer.RecordFetch("base", 1<<20)
+	tracer.RecordFetch("other", 1<<20)
+
+	now = now.Add(700 * time.Millisecond)
+	trace, ok := tracer.MarkStarted("ctr-a")
+	if !ok {
+		t.Fatal("expected active trace for ctr-a")
+	}
+	if trace.FirstRead != 200*time.Millisecond || trace.ColdStart != time.Second {
+		t.Errorf("unexpected latencies: first read %v, cold start %v", trace.FirstRead, trace.ColdStart)
+	}
+	if trace.Faults != 2 || trace.FaultBytes != 12288 || trace.BytesFetched != 1<<20 {
+		t.Errorf("unexpected counters: %+v", trace)
+	}
+	if _, ok := tracer.MarkStarted("ctr-a"); ok {
+		t.Error("expected finished trace not to be marked again")
+	}
+
+	now = now.Add(2 * time.Minute)
+	traces := tracer.Traces()
+	if len(traces) != 2 || len(finished) != 2 {
+		t.Fatalf("expected both traces finished, got %d traces and %d callbacks", len(traces), len(finished))
+	}
+	expired := finished[1]
+	if expired.Key != "ctr-b" || expired.Started || expired.FirstRead != 300*time.Millisecond {
+		t.Errorf("unexpected expired trace: %+v", expired)
+	}
+	t.Logf("✓ 冷启动 %v,首次读取 %v,按需读取 %d 次", trace.ColdStart, trace.FirstRead, trace.Faults)
+}
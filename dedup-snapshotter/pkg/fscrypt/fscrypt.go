@@ -0,0 +1,243 @@
+// Package fscrypt 为存储目录设置内核 fscrypt v2 加密策略,使 chunk 和容器 upperdir
+// 在本节点静态加密,不依赖整盘加密。
+//
+// 主密钥由 FS_IOC_ADD_ENCRYPTION_KEY 加入文件系统级 keyring,内核据此派生标识符;
+// 目录策略只能设置在空目录上,之后其中新建的文件和子目录自动继承
+package fscrypt
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// KeySize 是主密钥的长度,与内核 FSCRYPT_MAX_KEY_SIZE 一致
+	KeySize = unix.FSCRYPT_MAX_KEY_SIZE
+	// kmsTimeout 是执行 KMS 命令获取密钥的超时
+	kmsTimeout = 30 * time.Second
+)
+
+var (
+	// ErrUnsupported 表示内核或文件系统不支持 fscrypt
+	ErrUnsupported = errors.New("fscrypt not supported")
+	// ErrPolicyMismatch 表示目录已用其他密钥加密
+	ErrPolicyMismatch = errors.New("directory is encrypted with a different key")
+)
+
+// Identifier 是内核根据主密钥派生的密钥标识符
+type Identifier [unix.FSCRYPT_KEY_IDENTIFIER_SIZE]byte
+
+func (id Identifier) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// KeySource 指定主密钥的来源,三者只能设置一个:File 为原始密钥文件;Keyring 为
+// 进程可见 keyring 中 user 类型密钥的描述;Command 执行后在标准输出打印 hex 或 base64 编码的密钥
+type KeySource struct {
+	File    string
+	Keyring string
+	Command []string
+}
+
+// LoadKey 按来源读取 KeySize 字节的主密钥
+func LoadKey(ctx context.Context, src KeySource) ([]byte, error) {
+	var key []byte
+	switch {
+	case src.File != "":
+		data, err := os.ReadFile(src.File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file: %w", err)
+		}
+		key = data
+	case src.Keyring != "":
+		data, err := readKeyring(src.Keyring)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key %q from keyring: %w", src.Keyring, err)
+		}
+		key = data
+	case len(src.Command) > 0:
+		data, err := runKMS(ctx, src.Command)
+		if err != nil {
+			return nil, err
+		}
+		key = data
+	default:
+		return nil, fmt.Errorf("no key source configured")
+	}
+
+	if len(key) != KeySize {
+		Wipe(key)
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KeySize, len(key))
+	}
+	return key, nil
+}
+
+// Wipe 清零密钥,用完后调用
+func Wipe(key []byte) {
+	for i := range key {
+		key[i] = 0
+	}
+}
+
+// readKeyring 在进程的 keyring(线程、进程、会话、用户)中查找 user 类型密钥并读出内容
+func readKeyring(desc string) ([]byte, error) {
+	id, err := unix.RequestKey("user", desc, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, KeySize+1)
+	n, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, buf, 0)
+	if err != nil {
+		return nil, err
+	}
+	if n > len(buf) {
+		n = len(buf)
+	}
+	return buf[:n], nil
+}
+
+// runKMS 执行 KMS 命令,解码其输出的密钥
+func runKMS(ctx context.Context, command []string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, kmsTimeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("kms command failed: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	defer Wipe(out)
+
+	encoded := string(bytes.TrimSpace(out))
+	if key, err := hex.DecodeString(encoded); err == nil {
+		return key, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("kms command output is neither hex nor base64")
+	}
+	return key, nil
+}
+
+// CheckSupport 检查 dir 所在的文件系统是否支持 fscrypt v2 策略。不支持时返回 ErrUnsupported,
+// 并说明可能的原因(如 ext4 未开启 encrypt 特性)
+func CheckSupport(dir string) error {
+	_, err := GetPolicy(dir)
+	return err
+}
+
+// GetPolicy 返回目录的 v2 策略使用的密钥标识符,目录未加密时返回 nil
+func GetPolicy(dir string) (*Identifier, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	arg := unix.FscryptGetPolicyExArg{Size: uint64(unsafe.Sizeof(unix.FscryptPolicyV2{}))}
+	err = ioctl(f, unix.FS_IOC_GET_ENCRYPTION_POLICY_EX, unsafe.Pointer(&arg))
+	switch {
+	case errors.Is(err, unix.ENODATA):
+		return nil, nil
+	case errors.Is(err, unix.ENOTTY), errors.Is(err, unix.EOPNOTSUPP):
+		return nil, fmt.Errorf("%s: %w: kernel or filesystem lacks encryption support (ext4 needs the encrypt feature, f2fs needs encrypt)", dir, ErrUnsupported)
+	case err != nil:
+		return nil, fmt.Errorf("failed to get encryption policy of %s: %w", dir, err)
+	}
+
+	policy := (*unix.FscryptPolicyV2)(unsafe.Pointer(&arg.Policy[0]))
+	if policy.Version != unix.FSCRYPT_POLICY_V2 {
+		return nil, fmt.Errorf("%s: %w: uses a v1 encryption policy", dir, ErrPolicyMismatch)
+	}
+	id := Identifier(policy.Master_key_identifier)
+	return &id, nil
+}
+
+// AddKey 把主密钥加入 dir 所在文件系统的 keyring,返回内核派生的标识符。重复添加同一密钥无副作用
+func AddKey(dir string, key []byte) (Identifier, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return Identifier{}, err
+	}
+	defer f.Close()
+
+	// 参数结构体后紧跟密钥内容
+	size := int(unsafe.Sizeof(unix.FscryptAddKeyArg{}))
+	buf := make([]byte, size+len(key))
+	defer Wipe(buf)
+	arg := (*unix.FscryptAddKeyArg)(unsafe.Pointer(&buf[0]))
+	arg.Key_spec.Type = unix.FSCRYPT_KEY_SPEC_TYPE_IDENTIFIER
+	arg.Raw_size = uint32(len(key))
+	copy(buf[size:], key)
+
+	if err := ioctl(f, unix.FS_IOC_ADD_ENCRYPTION_KEY, unsafe.Pointer(&buf[0])); err != nil {
+		if errors.Is(err, unix.ENOTTY) || errors.Is(err, unix.EOPNOTSUPP) {
+			return Identifier{}, fmt.Errorf("%s: %w: %v", dir, ErrUnsupported, err)
+		}
+		return Identifier{}, fmt.Errorf("failed to add encryption key: %w", err)
+	}
+	var id Identifier
+	copy(id[:], arg.Key_spec.U[:len(id)])
+	return id, nil
+}
+
+// SetPolicy 为空目录设置使用 id 密钥的 v2 策略(内容 AES-256-XTS,文件名 AES-256-CTS)。
+// 目录已用同一密钥加密时不做处理,已用其他密钥加密时返回 ErrPolicyMismatch
+func SetPolicy(dir string, id Identifier) error {
+	current, err := GetPolicy(dir)
+	if err != nil {
+		return err
+	}
+	if current != nil {
+		if *current != id {
+			return fmt.Errorf("%s: %w (key %s)", dir, ErrPolicyMismatch, current)
+		}
+		return nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("%s is not empty: encryption can only be enabled before any data is written", dir)
+	}
+
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	policy := unix.FscryptPolicyV2{
+		Version:                   unix.FSCRYPT_POLICY_V2,
+		Contents_encryption_mode:  unix.FSCRYPT_MODE_AES_256_XTS,
+		Filenames_encryption_mode: unix.FSCRYPT_MODE_AES_256_CTS,
+		Flags:                     unix.FSCRYPT_POLICY_FLAGS_PAD_32,
+		Master_key_identifier:     id,
+	}
+	if err := ioctl(f, unix.FS_IOC_SET_ENCRYPTION_POLICY, unsafe.Pointer(&policy)); err != nil {
+		return fmt.Errorf("failed to set encryption policy on %s: %w", dir, err)
+	}
+	return nil
+}
+
+func ioctl(f *os.File, req uint, arg unsafe.Pointer) error {
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), uintptr(req), uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package fscrypt
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestLoadKey 验证从密钥文件和 KMS 命令读取密钥,长度不对时拒绝
+func TestLoadKey(t *testing.T) {
+	want := bytes.Repeat([]byte{0xab}, KeySize)
+	dir := t.TempDir()
+
+	keyFile := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyFile, want, 0600); err != nil {
+		t.Fatal(err)
+	}
+	key, err := LoadKey(context.Background(), KeySource{File: keyFile})
+	if err != nil || !bytes.Equal(key, want) {
+		t.Fatalf("LoadKey(file) = %x, %v", key, err)
+	}
+
+	key, err = LoadKey(context.Background(), KeySource{Command: []string{"echo", hex.EncodeToString(want)}})
+	if err != nil || !bytes.Equal(key, want) {
+		t.Fatalf("LoadKey(command) = %x, %v", key, err)
+	}
+
+	if _, err := LoadKey(context.Background(), KeySource{Command: []string{"echo", "abcd"}}); err == nil {
+		t.Error("expected short key to be rejected")
+	}
+	if _, err := LoadKey(context.Background(), KeySource{Command: []string{"false"}}); err == nil {
+		t.Error("expected failing kms command to be rejected")
+	}
+	if _, err := LoadKey(context.Background(), KeySource{}); err == nil {
+		t.Error("expected missing key source to be rejected")
+	}
+	t.Logf("✓ 从文件和 KMS 命令读取 %d 字节密钥", KeySize)
+}
+
+// TestCheckSupport 验证能力检测在不支持的文件系统上返回 ErrUnsupported
+func TestCheckSupport(t *testing.T) {
+	err := CheckSupport(t.TempDir())
+	if err != nil && !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Logf("✓ 临时目录加密支持: %v", err == nil)
+}
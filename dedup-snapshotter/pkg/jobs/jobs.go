@@ -0,0 +1,595 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/containerd/log"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// 任务状态
+const (
+	StateQueued    = "queued"
+	StateRunning   = "running"
+	StateCompleted = "completed"
+	StateFailed    = "failed"
+	StateCanceled  = "canceled"
+)
+
+// DefaultMaxAttempts 是任务失败后最多执行的次数,包括第一次
+const DefaultMaxAttempts = 3
+
+const (
+	// retention 是已结束任务的保留时间,超过后连同日志一起删除
+	retention = 7 * 24 * time.Hour
+	// maxLogEntries 是每个任务保留的日志条数,超出时删除最早的
+	maxLogEntries = 200
+	// dispatchInterval 是检查到期重试和新任务的间隔,Submit 会立即唤醒调度
+	dispatchInterval = time.Second
+)
+
+// ErrNotFound 表示任务不存在或已超过保留时间被删除
+var ErrNotFound = errors.New("job not found")
+
+// Job 是一个持久化的长时间操作。Params 为提交时的参数,重试和进程重启后按它重新执行
+type Job struct {
+	ID          string          `json:"id"`
+	Type        string          `json:"type"`
+	Target      string          `json:"target,omitempty"`
+	State       string          `json:"state"`
+	Progress    float64         `json:"progress"`
+	Params      json.RawMessage `json:"params,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	CreatedAt   time.Time       `json:"created_at"`
+	StartedAt   time.Time       `json:"started_at,omitempty"`
+	FinishedAt  time.Time       `json:"finished_at,omitempty"`
+	NextAttempt time.Time       `json:"next_attempt,omitempty"`
+	Logs        []LogEntry      `json:"logs,omitempty"`
+}
+
+// Finished 报告任务是否已结束,不会再执行
+func (j *Job) Finished() bool {
+	return j.State == StateCompleted || j.State == StateFailed || j.State == StateCanceled
+}
+
+// LogEntry 是任务执行过程中记录的一条日志
+type LogEntry struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// Handler 执行一个任务,返回错误时在 MaxAttempts 内重试。ctx 在任务被取消或进程退出时结束
+type Handler func(ctx context.Context, job *Job, r *Reporter) error
+
+// Options 是一种任务的执行方式,零值使用一个 worker、DefaultMaxAttempts 次、30 秒起的重试间隔
+type Options struct {
+	Workers     int
+	MaxAttempts int
+	// RetryDelay 是第一次重试前的等待,之后每次重试按执行次数倍增
+	RetryDelay time.Duration
+}
+
+type jobType struct {
+	handler Handler
+	opts    Options
+	active  int
+}
+
+// Filter 限定 List 返回的任务,空字段不限定
+type Filter struct {
+	Type  string
+	State string
+	Limit int
+}
+
+// Manager 把任务持久化到 SQLite,执行已注册处理函数的任务,失败时重试。
+// 未注册处理函数的类型由其所有者自行调度,只通过 Save 和 Logf 记录状态
+type Manager struct {
+	db *sql.DB
+	// mu 串行化写入,并保护 types 和 running
+	mu      sync.Mutex
+	types   map[string]*jobType
+	running map[string]context.CancelFunc
+
+	wake   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// Open 打开任务数据库。上次进程退出时仍在运行的任务重新排队,已达到最大执行次数的记为失败
+func Open(path string) (*Manager, error) {
+	db, err := sql.Open("sqlite3", path+"?_journal_mode=WAL&_synchronous=FULL")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open job database: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &Manager{
+		db:      db,
+		types:   make(map[string]*jobType),
+		running: make(map[string]context.CancelFunc),
+		wake:    make(chan struct{}, 1),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	if err := m.init(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize job database: %w", err)
+	}
+	if err := m.recover(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to recover interrupted jobs: %w", err)
+	}
+	return m, nil
+}
+
+func (m *Manager) init() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS jobs (
+		id TEXT PRIMARY KEY,
+		type TEXT NOT NULL,
+		target TEXT NOT NULL,
+		state TEXT NOT NULL,
+		progress REAL NOT NULL,
+		params TEXT,
+		error TEXT,
+		attempts INTEGER NOT NULL,
+		max_attempts INTEGER NOT NULL,
+		created_at DATETIME NOT NULL,
+		started_at DATETIME,
+		finished_at DATETIME,
+		next_attempt DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS job_logs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		job_id TEXT NOT NULL,
+		time DATETIME NOT NULL,
+		message TEXT NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_jobs_state ON jobs(state);
+	CREATE INDEX IF NOT EXISTS idx_jobs_type ON jobs(type);
+	CREATE INDEX IF NOT EXISTS idx_job_logs_job ON job_logs(job_id);
+	`
+	_, err := m.db.Exec(schema)
+	return err
+}
+
+func (m *Manager) recover() error {
+	now := time.Now()
+	jobs, err := m.List(Filter{State: StateRunning})
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		msg := "interrupted by restart, requeued"
+		if job.Attempts >= job.MaxAttempts {
+			job.State = StateFailed
+			job.Error = "interrupted by restart"
+			job.FinishedAt = now
+			msg = "interrupted by restart after last attempt"
+		} else {
+			job.State = StateQueued
+			job.NextAttempt = now
+		}
+		if err := m.Save(job); err != nil {
+			return err
+		}
+		m.Logf(job.ID, "%s", msg)
+		log.L.Warnf("%s job %s (%s) was %s", job.Type, job.ID, job.Target, msg)
+	}
+	return nil
+}
+
+// Register 设置 typ 类型任务的处理函数,须在 Start 之前调用
+func (m *Manager) Register(typ string, handler Handler, opts Options) {
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultMaxAttempts
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = 30 * time.Second
+	}
+	m.mu.Lock()
+	m.types[typ] = &jobType{handler: handler, opts: opts}
+	m.mu.Unlock()
+}
+
+// Start 启动调度,执行排队中和到期重试的任务,并定期删除超过保留时间的任务
+func (m *Manager) Start() {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(dispatchInterval)
+		defer ticker.Stop()
+		lastPrune := time.Time{}
+		for {
+			if time.Since(lastPrune) > time.Hour {
+				if n, err := m.Prune(time.Now().Add(-retention)); err != nil {
+					log.L.WithError(err).Warn("failed to prune finished jobs")
+				} else if n > 0 {
+					log.L.Infof("pruned %d finished jobs", n)
+				}
+				lastPrune = time.Now()
+			}
+			m.dispatch()
+			select {
+			case <-m.ctx.Done():
+				return
+			case <-m.wake:
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Close 停止调度并取消运行中的任务,被取消的任务下次启动时重新排队
+func (m *Manager) Close() error {
+	m.cancel()
+	m.wg.Wait()
+	return m.db.Close()
+}
+
+func newID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// Submit 创建一个排队中的任务,params 编码为 JSON 保存,处理函数从 Job.Params 解码
+func (m *Manager) Submit(typ, target string, params interface{}) (*Job, error) {
+	m.mu.Lock()
+	t, ok := m.types[typ]
+	m.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown job type %q", typ)
+	}
+
+	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode job params: %w", err)
+	}
+	now := time.Now()
+	job := &Job{
+		ID:          newID(),
+		Type:        typ,
+		Target:      target,
+		State:       StateQueued,
+		Params:      data,
+		MaxAttempts: t.opts.MaxAttempts,
+		CreatedAt:   now,
+		NextAttempt: now,
+	}
+	if err := m.Save(job); err != nil {
+		return nil, err
+	}
+	log.L.Infof("queued %s job %s for %s", typ, job.ID, target)
+
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// Save 写入任务的当前状态,不存在时创建
+func (m *Manager) Save(job *Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := m.db.Exec(`
+		INSERT INTO jobs (id, type, target, state, progress, params, error, attempts, max_attempts, created_at, started_at, finished_at, next_attempt)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			state = excluded.state, progress = excluded.progress, params = excluded.params, error = excluded.error,
+			attempts = excluded.attempts, max_attempts = excluded.max_attempts, started_at = excluded.started_at,
+			finished_at = excluded.finished_at, next_attempt = excluded.next_attempt
+	`, job.ID, job.Type, job.Target, job.State, job.Progress, string(job.Params), job.Error, job.Attempts, job.MaxAttempts,
+		job.CreatedAt, job.StartedAt, job.FinishedAt, job.NextAttempt)
+	if err != nil {
+		return fmt.Errorf("failed to save job %s: %w", job.ID, err)
+	}
+	return nil
+}
+
+// Logf 追加一条任务日志,写入失败只记录到进程日志
+func (m *Manager) Logf(id, format string, args ...interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	msg := fmt.Sprintf(format, args...)
+	if _, err := m.db.Exec(`INSERT INTO job_logs (job_id, time, message) VALUES (?, ?, ?)`, id, time.Now(), msg); err != nil {
+		log.L.WithError(err).Warnf("failed to write log of job %s", id)
+		return
+	}
+	m.db.Exec(`
+		DELETE FROM job_logs WHERE job_id = ? AND id NOT IN (
+			SELECT id FROM job_logs WHERE job_id = ? ORDER BY id DESC LIMIT ?
+		)
+	`, id, id, maxLogEntries)
+}
+
+const jobColumns = `id, type, target, state, progress, params, error, attempts, max_attempts, created_at, started_at, finished_at, next_attempt`
+
+func scanJob(row interface{ Scan(...interface{}) error }) (*Job, error) {
+	var job Job
+	var params, errStr sql.NullString
+	var started, finished, next sql.NullTime
+	if err := row.Scan(&job.ID, &job.Type, &job.Target, &job.State, &job.Progress, &params, &errStr,
+		&job.Attempts, &job.MaxAttempts, &job.CreatedAt, &started, &finished, &next); err != nil {
+		return nil, err
+	}
+	if params.String != "" {
+		job.Params = json.RawMessage(params.String)
+	}
+	job.Error = errStr.String
+	job.StartedAt = started.Time
+	job.FinishedAt = finished.Time
+	job.NextAttempt = next.Time
+	return &job, nil
+}
+
+// Get 返回任务及其日志
+func (m *Manager) Get(id string) (*Job, error) {
+	job, err := scanJob(m.db.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read job %s: %w", id, err)
+	}
+
+	rows, err := m.db.Query(`SELECT time, message FROM job_logs WHERE job_id = ? ORDER BY id`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read logs of job %s: %w", id, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var entry LogEntry
+		if err := rows.Scan(&entry.Time, &entry.Message); err != nil {
+			return nil, err
+		}
+		job.Logs = append(job.Logs, entry)
+	}
+	return job, rows.Err()
+}
+
+// List 按创建时间从新到旧返回任务,不含日志
+func (m *Manager) List(filter Filter) ([]*Job, error) {
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE 1=1`
+	var args []interface{}
+	if filter.Type != "" {
+		query += ` AND type = ?`
+		args = append(args, filter.Type)
+	}
+	if filter.State != "" {
+		query += ` AND state = ?`
+		args = append(args, filter.State)
+	}
+	query += ` ORDER BY created_at DESC`
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(` LIMIT %d`, filter.Limit)
+	}
+
+	rows, err := m.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	defer rows.Close()
+	jobs := []*Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// Cancel 取消排队中或运行中的任务。由所有者自行调度的任务类型不能通过 Manager 取消
+func (m *Manager) Cancel(id string) (*Job, error) {
+	job, err := m.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	_, managed := m.types[job.Type]
+	cancel := m.running[id]
+	m.mu.Unlock()
+	if !managed {
+		return nil, fmt.Errorf("%s jobs cannot be canceled through the job manager", job.Type)
+	}
+	if job.Finished() {
+		return job, nil
+	}
+
+	if cancel != nil {
+		// 运行中的任务由 run 在处理函数返回后记为取消
+		cancel()
+		m.Logf(id, "cancel requested")
+		return job, nil
+	}
+	job.State = StateCanceled
+	job.FinishedAt = time.Now()
+	if err := m.Save(job); err != nil {
+		return nil, err
+	}
+	m.Logf(id, "canceled before start")
+	return job, nil
+}
+
+// Prune 删除在 before 之前结束的任务及其日志,返回删除的任务数
+func (m *Manager) Prune(before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res, err := m.db.Exec(`DELETE FROM jobs WHERE state IN (?, ?, ?) AND finished_at < ?`,
+		StateCompleted, StateFailed, StateCanceled, before)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := m.db.Exec(`DELETE FROM job_logs WHERE job_id NOT IN (SELECT id FROM jobs)`); err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+// dispatch 按创建顺序启动到期的排队任务,每种类型不超过其 worker 数
+func (m *Manager) dispatch() {
+	queued, err := m.List(Filter{State: StateQueued})
+	if err != nil {
+		log.L.WithError(err).Warn("failed to list queued jobs")
+		return
+	}
+	now := time.Now()
+	for i := len(queued) - 1; i >= 0; i-- {
+		job := queued[i]
+		if job.NextAttempt.After(now) {
+			continue
+		}
+		m.mu.Lock()
+		t, ok := m.types[job.Type]
+		full := !ok || t.active >= t.opts.Workers || m.ctx.Err() != nil
+		m.mu.Unlock()
+		if full {
+			continue
+		}
+
+		job.State = StateRunning
+		job.Attempts++
+		job.StartedAt = now
+		job.Error = ""
+		if claimed, err := m.claim(job); err != nil || !claimed {
+			if err != nil {
+				log.L.WithError(err).Warnf("failed to start job %s", job.ID)
+			}
+			continue
+		}
+		m.Logf(job.ID, "attempt %d of %d started", job.Attempts, job.MaxAttempts)
+
+		ctx, cancel := context.WithCancel(m.ctx)
+		m.mu.Lock()
+		t.active++
+		m.running[job.ID] = cancel
+		m.mu.Unlock()
+
+		m.wg.Add(1)
+		go m.run(ctx, cancel, t, job)
+	}
+}
+
+// claim 把仍在排队的任务记为运行中,任务已被取消时返回 false
+func (m *Manager) claim(job *Job) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res, err := m.db.Exec(`UPDATE jobs SET state = ?, attempts = ?, started_at = ?, error = '' WHERE id = ? AND state = ?`,
+		job.State, job.Attempts, job.StartedAt, job.ID, StateQueued)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (m *Manager) run(ctx context.Context, cancel context.CancelFunc, t *jobType, job *Job) {
+	defer m.wg.Done()
+	defer func() {
+		cancel()
+		m.mu.Lock()
+		t.active--
+		delete(m.running, job.ID)
+		m.mu.Unlock()
+		select {
+		case m.wake <- struct{}{}:
+		default:
+		}
+	}()
+
+	err := m.call(ctx, t.handler, job)
+	now := time.Now()
+	switch {
+	case m.ctx.Err() != nil:
+		// 进程退出,下次启动时重新执行,不计入执行次数
+		job.State = StateQueued
+		job.Attempts--
+		job.NextAttempt = now
+		m.Logf(job.ID, "interrupted by shutdown, requeued")
+	case ctx.Err() != nil:
+		job.State = StateCanceled
+		job.FinishedAt = now
+		m.Logf(job.ID, "canceled")
+	case err == nil:
+		job.State = StateCompleted
+		job.Progress = 100
+		job.FinishedAt = now
+		m.Logf(job.ID, "completed")
+	case job.Attempts < job.MaxAttempts:
+		delay := t.opts.RetryDelay * time.Duration(job.Attempts)
+		job.State = StateQueued
+		job.Error = err.Error()
+		job.NextAttempt = now.Add(delay)
+		m.Logf(job.ID, "attempt %d failed: %v, retrying in %s", job.Attempts, err, delay)
+		log.L.WithError(err).Warnf("%s job %s failed, retrying in %s", job.Type, job.ID, delay)
+	default:
+		job.State = StateFailed
+		job.Error = err.Error()
+		job.FinishedAt = now
+		m.Logf(job.ID, "attempt %d failed: %v, giving up", job.Attempts, err)
+		log.L.WithError(err).Warnf("%s job %s failed", job.Type, job.ID)
+	}
+	if err := m.Save(job); err != nil {
+		log.L.WithError(err).Warnf("failed to record result of job %s", job.ID)
+	}
+}
+
+// call 执行处理函数,处理函数 panic 时作为失败处理
+func (m *Manager) call(ctx context.Context, handler Handler, job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(ctx, job, &Reporter{m: m, job: job})
+}
+
+// Reporter 供处理函数报告进度和写入任务日志
+type Reporter struct {
+	m   *Manager
+	job *Job
+	// saved 是上次写入进度的时间,进度最多每秒写入一次
+	saved time.Time
+}
+
+// Progress 更新任务进度(0-100)
+func (r *Reporter) Progress(percent float64) {
+	r.job.Progress = percent
+	if time.Since(r.saved) < time.Second {
+		return
+	}
+	r.saved = time.Now()
+	if err := r.m.Save(r.job); err != nil {
+		log.L.WithError(err).Debugf("failed to record progress of job %s", r.job.ID)
+	}
+}
+
+// Logf 追加一条任务日志
+func (r *Reporter) Logf(format string, args ...interface{}) {
+	r.m.Logf(r.job.ID, format, args...)
+}
@@ -0,0 +1,142 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitState 等待任务进入 state
+func waitState(t *testing.T, m *Manager, id, state string) *Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := m.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.State == state {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	job, _ := m.Get(id)
+	t.Fatalf("job %s did not reach %s, last state %+v", id, state, job)
+	return nil
+}
+
+// TestManagerRetriesAndRecovers 验证任务执行、失败重试、取消,以及进程重启后被中断的任务重新排队
+func TestManagerRetriesAndRecovers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.db")
+	m, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls int32
+	m.Register("flaky", func(ctx context.Context, job *Job, r *Reporter) error {
+		var params struct {
+			FailTimes int32 `json:"fail_times"`
+		}
+		if err := json.Unmarshal(job.Params, &params); err != nil {
+			return err
+		}
+		r.Progress(50)
+		if atomic.AddInt32(&calls, 1) <= params.FailTimes {
+			return errors.New("transient failure")
+		}
+		r.Logf("done after %d attempts", job.Attempts)
+		return nil
+	}, Options{MaxAttempts: 3, RetryDelay: time.Millisecond})
+	block := make(chan struct{})
+	m.Register("slow", func(ctx context.Context, job *Job, r *Reporter) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-block:
+			return nil
+		}
+	}, Options{})
+	m.Start()
+
+	job, err := m.Submit("flaky", "img", map[string]int{"fail_times": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := waitState(t, m, job.ID, StateCompleted)
+	if done.Attempts != 3 || done.Progress != 100 || len(done.Logs) == 0 {
+		t.Fatalf("expected completion on the third attempt with logs, got %+v", done)
+	}
+	t.Logf("✓ 失败两次后第三次成功,记录 %d 条日志", len(done.Logs))
+
+	atomic.StoreInt32(&calls, 0)
+	job, err = m.Submit("flaky", "img", map[string]int{"fail_times": 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := waitState(t, m, job.ID, StateFailed)
+	if failed.Attempts != 3 || failed.Error != "transient failure" {
+		t.Fatalf("expected failure after 3 attempts, got %+v", failed)
+	}
+	t.Logf("✓ 达到最大执行次数后记为失败: %s", failed.Error)
+
+	running, err := m.Submit("slow", "a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitState(t, m, running.ID, StateRunning)
+	if _, err := m.Cancel(running.ID); err != nil {
+		t.Fatal(err)
+	}
+	waitState(t, m, running.ID, StateCanceled)
+	t.Logf("✓ 运行中的任务被取消")
+
+	if _, err := m.Submit("unknown", "x", nil); err == nil {
+		t.Error("expected unknown job type to be rejected")
+	}
+
+	// 模拟崩溃:运行中的记录留在库中
+	interrupted, err := m.Submit("slow", "b", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitState(t, m, interrupted.ID, StateRunning)
+	crashed, _ := m.Get(interrupted.ID)
+	m.Close()
+	crashed.Logs = nil
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Close 时被中断的任务已重新排队,改回运行中以模拟进程被杀死
+	if err := reopened.Save(crashed); err != nil {
+		t.Fatal(err)
+	}
+	reopened.Close()
+
+	m, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	recovered, err := m.Get(interrupted.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recovered.State != StateQueued {
+		t.Fatalf("expected interrupted job to be requeued, got %+v", recovered)
+	}
+	m.Register("slow", func(ctx context.Context, job *Job, r *Reporter) error { return nil }, Options{})
+	m.Start()
+	waitState(t, m, interrupted.ID, StateCompleted)
+	t.Logf("✓ 重启后被中断的任务重新排队并完成")
+
+	list, err := m.List(Filter{State: StateFailed})
+	if err != nil || len(list) != 1 || list[0].ID != failed.ID {
+		t.Fatalf("expected the failed job to survive restart, got %v, %v", list, err)
+	}
+}
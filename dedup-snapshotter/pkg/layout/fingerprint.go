@@ -0,0 +1,121 @@
+package layout
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FingerprintFile 是 root 下记录 chunk 切分参数的文件
+const FingerprintFile = "FINGERPRINT"
+
+// ErrFingerprintMismatch 表示 root 中的 chunk 由不同的切分参数生成,需先执行 --rechunk
+var ErrFingerprintMismatch = errors.New("chunk store was written with different chunking parameters")
+
+// CDCParams 是小文件 CDC 切分的参数,任一项变化都会改变切分边界
+type CDCParams struct {
+	Min      int    `json:"min"`
+	Avg      int    `json:"avg"`
+	Max      int    `json:"max"`
+	Mask     string `json:"mask"`
+	GearSeed string `json:"gear_seed"`
+}
+
+// Fingerprint 描述 chunk 存储的切分参数。参数不同的程序切出的 chunk 无法与已有 chunk 去重,
+// 哈希算法不同时连已有 chunk 的校验都会失败
+type Fingerprint struct {
+	ChunkSize   int64  `json:"chunk_size"`
+	Hash        string `json:"hash"`
+	Compression string `json:"compression"`
+	// SmallChunks 表示存储中有 CDC 切分的 chunk,开启过一次即保持为 true
+	SmallChunks bool      `json:"small_chunks"`
+	CDC         CDCParams `json:"cdc"`
+	WrittenBy   string    `json:"written_by,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at,omitempty"`
+}
+
+// Mismatches 返回本程序的参数 f 与磁盘上的指纹 disk 不兼容的项。
+// CDC 参数只在双方都启用小块分层时比较,开关小块分层本身不影响已有 chunk
+func (f *Fingerprint) Mismatches(disk *Fingerprint) []string {
+	var problems []string
+	if f.ChunkSize != disk.ChunkSize {
+		problems = append(problems, fmt.Sprintf("chunk size %d, store has %d", f.ChunkSize, disk.ChunkSize))
+	}
+	if f.Hash != disk.Hash {
+		problems = append(problems, fmt.Sprintf("hash %s, store has %s", f.Hash, disk.Hash))
+	}
+	if f.Compression != disk.Compression {
+		problems = append(problems, fmt.Sprintf("compression %s, store has %s", f.Compression, disk.Compression))
+	}
+	if f.SmallChunks && disk.SmallChunks && f.CDC != disk.CDC {
+		problems = append(problems, fmt.Sprintf("CDC parameters %+v, store has %+v", f.CDC, disk.CDC))
+	}
+	return problems
+}
+
+// ReadFingerprint 读取 root 的指纹,没有 FINGERPRINT 文件时返回 nil
+func ReadFingerprint(root string) (*Fingerprint, error) {
+	data, err := os.ReadFile(filepath.Join(root, FingerprintFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f Fingerprint
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", FingerprintFile, err)
+	}
+	return &f, nil
+}
+
+// WriteFingerprint 原子地写入 root 的指纹
+func WriteFingerprint(root string, f *Fingerprint) error {
+	f.WrittenBy = filepath.Base(os.Args[0])
+	f.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(root, FingerprintFile)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// CheckFingerprint 检查 root 的 chunk 能否由参数为 want 的程序使用,不修改任何文件。
+// 没有指纹的 root 由引入指纹之前的程序写入,那些程序的切分参数与当前相同,视为兼容
+func CheckFingerprint(root string, want *Fingerprint) (*Fingerprint, error) {
+	disk, err := ReadFingerprint(root)
+	if err != nil || disk == nil {
+		return nil, err
+	}
+	if problems := want.Mismatches(disk); len(problems) > 0 {
+		return disk, fmt.Errorf("%w: %s (run --rechunk to migrate the store)", ErrFingerprintMismatch, strings.Join(problems, "; "))
+	}
+	return disk, nil
+}
+
+// EnsureFingerprint 在存储所有者启动时调用:参数不兼容时返回错误,否则记录本程序的参数。
+// 记录时保留已有的 CDC chunk,之后再开启小块分层仍按已有的 CDC 参数比较
+func EnsureFingerprint(root string, want *Fingerprint) error {
+	disk, err := CheckFingerprint(root, want)
+	if err != nil {
+		return err
+	}
+	f := *want
+	if disk != nil && disk.SmallChunks && !want.SmallChunks {
+		f.SmallChunks = true
+		f.CDC = disk.CDC
+	}
+	if disk != nil && disk.Mismatches(&f) == nil && disk.SmallChunks == f.SmallChunks && disk.CDC == f.CDC {
+		return nil
+	}
+	return WriteFingerprint(root, &f)
+}
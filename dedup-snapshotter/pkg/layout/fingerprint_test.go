@@ -0,0 +1,69 @@
+package layout
+
+import (
+	"errors"
+	"testing"
+)
+
+func testFingerprint(smallChunks bool) *Fingerprint {
+	return &Fingerprint{
+		ChunkSize:   4 << 20,
+		Hash:        "sha256",
+		Compression: "lz4hc",
+		SmallChunks: smallChunks,
+		CDC:         CDCParams{Min: 16 << 10, Avg: 64 << 10, Max: 256 << 10, Mask: "0xffff000000000000", GearSeed: "0x9e3779b97f4a7c15"},
+	}
+}
+
+// TestFingerprint 验证没有指纹的 root 被记录为当前参数,切分参数变化时拒绝使用,
+// 开关小块分层兼容且不丢失已有 CDC chunk 的参数
+func TestFingerprint(t *testing.T) {
+	root := t.TempDir()
+
+	if err := EnsureFingerprint(root, testFingerprint(false)); err != nil {
+		t.Fatal(err)
+	}
+	disk, err := ReadFingerprint(root)
+	if err != nil || disk == nil || disk.ChunkSize != 4<<20 || disk.WrittenBy == "" {
+		t.Fatalf("expected fingerprint to be recorded, got %+v, %v", disk, err)
+	}
+	t.Logf("✓ 没有指纹的 root 记录当前参数")
+
+	changed := testFingerprint(false)
+	changed.ChunkSize = 1 << 20
+	changed.Hash = "blake3"
+	if _, err := CheckFingerprint(root, changed); !errors.Is(err, ErrFingerprintMismatch) {
+		t.Fatalf("expected ErrFingerprintMismatch, got %v", err)
+	}
+	if problems := changed.Mismatches(disk); len(problems) != 2 {
+		t.Fatalf("expected chunk size and hash mismatches, got %v", problems)
+	}
+	if err := EnsureFingerprint(root, changed); !errors.Is(err, ErrFingerprintMismatch) {
+		t.Fatalf("expected ErrFingerprintMismatch, got %v", err)
+	}
+	if disk, _ := ReadFingerprint(root); disk.ChunkSize != 4<<20 {
+		t.Fatal("incompatible binary must not rewrite the fingerprint")
+	}
+	t.Logf("✓ 切分参数变化时拒绝使用且不改写指纹")
+
+	// 开启小块分层后再关闭,指纹仍记录已有的 CDC 参数
+	if err := EnsureFingerprint(root, testFingerprint(true)); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnsureFingerprint(root, testFingerprint(false)); err != nil {
+		t.Fatal(err)
+	}
+	if disk, _ := ReadFingerprint(root); !disk.SmallChunks {
+		t.Fatal("expected small_chunks to stay recorded once CDC chunks exist")
+	}
+	cdc := testFingerprint(true)
+	cdc.CDC.Avg = 32 << 10
+	if _, err := CheckFingerprint(root, cdc); !errors.Is(err, ErrFingerprintMismatch) {
+		t.Fatalf("expected CDC parameter change to be rejected, got %v", err)
+	}
+	cdc.SmallChunks = false
+	if _, err := CheckFingerprint(root, cdc); err != nil {
+		t.Fatalf("CDC parameters must not matter with small chunks disabled: %v", err)
+	}
+	t.Logf("✓ 开关小块分层兼容,CDC 参数只在启用时比较")
+}
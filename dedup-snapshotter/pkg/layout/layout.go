@@ -0,0 +1,411 @@
+// Package layout 为 root 下的持久状态定义格式版本,并提供升级、降级和兼容性检查。
+//
+// 每个组件(chunk 目录、EROFS 镜像、索引库、chunk 索引库、挂载目录)有独立的格式版本,
+// 记录在 root/FORMAT 中。Compat 是读取该组件所需的最低格式版本:
+// 本程序支持的版本低于 Compat 时拒绝使用,防止旧程序破坏新格式。
+// 一组组件版本构成一个格式代次(generation),降级按代次进行。
+//
+// 引入版本之前的程序不读取 FORMAT,回退到这些程序前需先执行 --downgrade-to=1
+package layout
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// FormatFile 是 root 下记录格式版本的文件
+const FormatFile = "FORMAT"
+
+// 持久状态的组件
+const (
+	Chunks     = "chunks"
+	Images     = "images"
+	Index      = "index"
+	ChunkIndex = "chunk_index"
+	Mounts     = "mounts"
+)
+
+// ErrIncompatible 表示 root 的格式无法被本程序安全使用
+var ErrIncompatible = errors.New("on-disk format is incompatible")
+
+// generations 按代次列出各组件的格式版本,下标 0 为第 1 代(引入版本之前的布局)
+var generations = []map[string]int{
+	{Chunks: 1, Images: 1, Index: 1, ChunkIndex: 0, Mounts: 1},
+	// 第 2 代:chunk 索引增加增量统计计数器
+	{Chunks: 1, Images: 1, Index: 1, ChunkIndex: 1, Mounts: 1},
+	// 第 3 代:两个索引的引用计数统一为出现次数,打开时按文件和镜像重新计数一次
+	{Chunks: 1, Images: 1, Index: 2, ChunkIndex: 2, Mounts: 1},
+}
+
+// CurrentGeneration 是本程序写入的格式代次
+var CurrentGeneration = len(generations)
+
+// step 把一个组件从 From 迁移到 To。Offline 为 true 的迁移不能在有挂载时执行
+type step struct {
+	Component string
+	From, To  int
+	Offline   bool
+	// Compat 是迁移后读取该组件所需的最低版本
+	Compat int
+	Run    func(root string) error
+}
+
+// steps 列出所有升级和降级步骤。索引和 chunk 索引的升级由 IndexDB 和 ChunkIndexer 打开时完成;
+// 引用计数重新计数后旧程序仍可读取,Compat 不变,降级只需让再次升级时重新计数
+var steps = []step{
+	{Component: ChunkIndex, From: 0, To: 1, Compat: 1},
+	{Component: ChunkIndex, From: 1, To: 0, Compat: 0, Run: resetChunkIndexStats},
+	{Component: ChunkIndex, From: 1, To: 2, Compat: 1},
+	{Component: ChunkIndex, From: 2, To: 1, Compat: 1, Run: resetChunkIndexRefCounts},
+	{Component: Index, From: 1, To: 2, Compat: 1},
+	{Component: Index, From: 2, To: 1, Compat: 1, Run: resetIndexRefCounts},
+}
+
+// ComponentVersion 是组件在磁盘上的格式版本
+type ComponentVersion struct {
+	Version int `json:"version"`
+	Compat  int `json:"compat"`
+}
+
+// Format 是 FORMAT 文件的内容
+type Format struct {
+	Generation int                         `json:"generation"`
+	Components map[string]ComponentVersion `json:"components"`
+	WrittenBy  string                      `json:"written_by"`
+	UpdatedAt  time.Time                   `json:"updated_at"`
+}
+
+// 组件相对本程序的状态
+const (
+	StatusCurrent   = "current"
+	StatusUpgrade   = "upgrade"
+	StatusDowngrade = "downgrade"
+	// StatusNewer 表示组件由更新的程序写入,但仍声明与本程序兼容
+	StatusNewer        = "newer"
+	StatusIncompatible = "incompatible"
+)
+
+type ComponentReport struct {
+	Component string `json:"component"`
+	OnDisk    int    `json:"on_disk"`
+	Compat    int    `json:"compat"`
+	Supported int    `json:"supported"`
+	Status    string `json:"status"`
+	// Offline 表示需要的迁移不能在有挂载时执行
+	Offline bool `json:"offline,omitempty"`
+}
+
+// Report 是 root 与本程序的兼容性检查结果
+type Report struct {
+	Root         string            `json:"root"`
+	Generation   int               `json:"generation"`
+	Supported    int               `json:"supported"`
+	Versioned    bool              `json:"versioned"`
+	Components   []ComponentReport `json:"components"`
+	ActiveMounts []string          `json:"active_mounts,omitempty"`
+	Problems     []string          `json:"problems,omitempty"`
+}
+
+// Compatible 返回本程序能否在该 root 上启动(必要时自动升级)
+func (r *Report) Compatible() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "root %s: format generation %d, this binary writes %d\n", r.Root, r.Generation, r.Supported)
+	for _, c := range r.Components {
+		fmt.Fprintf(&b, "  %-12s on-disk v%d (compat v%d), supported v%d: %s\n", c.Component, c.OnDisk, c.Compat, c.Supported, c.Status)
+	}
+	if len(r.ActiveMounts) > 0 {
+		fmt.Fprintf(&b, "  %d active mounts under root\n", len(r.ActiveMounts))
+	}
+	for _, p := range r.Problems {
+		fmt.Fprintf(&b, "  problem: %s\n", p)
+	}
+	if r.Compatible() {
+		b.WriteString("compatible")
+	} else {
+		b.WriteString("incompatible")
+	}
+	return b.String()
+}
+
+// ReadFormat 读取 root 的格式,没有 FORMAT 文件时视为第 1 代,versioned 为 false
+func ReadFormat(root string) (format *Format, versioned bool, err error) {
+	data, err := os.ReadFile(filepath.Join(root, FormatFile))
+	if os.IsNotExist(err) {
+		return generationFormat(1), false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var f Format
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, false, fmt.Errorf("invalid %s: %w", FormatFile, err)
+	}
+	if f.Components == nil {
+		f.Components = make(map[string]ComponentVersion)
+	}
+	return &f, true, nil
+}
+
+// Check 比较 root 的格式与本程序支持的格式,不修改任何文件
+func Check(root string) (*Report, error) {
+	return check(root, CurrentGeneration)
+}
+
+func check(root string, target int) (*Report, error) {
+	format, versioned, err := ReadFormat(root)
+	if err != nil {
+		return nil, err
+	}
+	mounts, err := ActiveMounts(root)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		Root:         root,
+		Generation:   format.Generation,
+		Supported:    CurrentGeneration,
+		Versioned:    versioned,
+		ActiveMounts: mounts,
+	}
+
+	want := generations[target-1]
+	for _, component := range componentNames() {
+		disk := format.Components[component]
+		c := ComponentReport{
+			Component: component,
+			OnDisk:    disk.Version,
+			Compat:    disk.Compat,
+			Supported: generations[CurrentGeneration-1][component],
+		}
+
+		switch {
+		case disk.Compat > c.Supported:
+			c.Status = StatusIncompatible
+			report.Problems = append(report.Problems, fmt.Sprintf("%s format v%d requires a binary supporting v%d", component, disk.Version, disk.Compat))
+		case disk.Version == want[component]:
+			c.Status = StatusCurrent
+		case disk.Version > c.Supported:
+			c.Status = StatusNewer
+		default:
+			path, err := migrationPath(component, disk.Version, want[component])
+			if err != nil {
+				c.Status = StatusIncompatible
+				report.Problems = append(report.Problems, err.Error())
+				break
+			}
+			c.Status = StatusUpgrade
+			if disk.Version > want[component] {
+				c.Status = StatusDowngrade
+			}
+			for _, s := range path {
+				c.Offline = c.Offline || s.Offline
+			}
+			if c.Offline && len(mounts) > 0 {
+				report.Problems = append(report.Problems, fmt.Sprintf("%s migration v%d -> v%d requires unmounting %d active mounts", component, disk.Version, want[component], len(mounts)))
+			}
+		}
+		report.Components = append(report.Components, c)
+	}
+
+	// 更新的程序增加的组件,声明了兼容版本就说明本程序不能忽略它
+	for component, disk := range format.Components {
+		if _, known := want[component]; !known && disk.Compat > 0 {
+			report.Problems = append(report.Problems, fmt.Sprintf("unknown component %s requires v%d", component, disk.Compat))
+		}
+	}
+	sort.Strings(report.Problems)
+	return report, nil
+}
+
+// Upgrade 把 root 迁移到本程序的格式代次并写入 FORMAT。
+// 调用方必须持有存储所有权,不兼容时返回 ErrIncompatible 且不做任何修改
+func Upgrade(root string) (*Report, error) {
+	return migrate(root, CurrentGeneration)
+}
+
+// Downgrade 把 root 迁移到较旧的格式代次,供回退到旧版本程序前执行
+func Downgrade(root string, generation int) (*Report, error) {
+	if generation < 1 || generation > CurrentGeneration {
+		return nil, fmt.Errorf("format generation must be between 1 and %d", CurrentGeneration)
+	}
+	return migrate(root, generation)
+}
+
+func migrate(root string, target int) (*Report, error) {
+	report, err := check(root, target)
+	if err != nil {
+		return nil, err
+	}
+	if !report.Compatible() {
+		return report, fmt.Errorf("%w: %s", ErrIncompatible, strings.Join(report.Problems, "; "))
+	}
+
+	format, _, err := ReadFormat(root)
+	if err != nil {
+		return nil, err
+	}
+	want := generations[target-1]
+	for _, c := range report.Components {
+		if c.Status != StatusUpgrade && c.Status != StatusDowngrade {
+			continue
+		}
+		path, err := migrationPath(c.Component, c.OnDisk, want[c.Component])
+		if err != nil {
+			return report, err
+		}
+		component := c.Component
+		for _, s := range path {
+			if s.Run != nil {
+				if err := s.Run(root); err != nil {
+					return report, fmt.Errorf("failed to migrate %s from v%d to v%d: %w", component, s.From, s.To, err)
+				}
+			}
+			// 每一步之后立即记录,中途失败时下次从已完成的版本继续
+			format.Components[component] = ComponentVersion{Version: s.To, Compat: s.Compat}
+			if err := writeFormat(root, format); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	// 有更新程序写入的组件时保留原代次,否则降级后的旧程序会误以为格式已回到目标代次
+	newer := false
+	for _, c := range report.Components {
+		newer = newer || c.Status == StatusNewer
+	}
+	if !newer {
+		format.Generation = target
+	}
+	if err := writeFormat(root, format); err != nil {
+		return report, err
+	}
+	return check(root, target)
+}
+
+// migrationPath 返回把组件从 from 迁移到 to 的步骤序列
+func migrationPath(component string, from, to int) ([]step, error) {
+	var path []step
+	for from != to {
+		found := false
+		for _, s := range steps {
+			if s.Component == component && s.From == from && (s.To-s.From)*(to-from) > 0 {
+				path = append(path, s)
+				from = s.To
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no migration for %s from v%d to v%d", component, from, to)
+		}
+	}
+	return path, nil
+}
+
+func generationFormat(generation int) *Format {
+	f := &Format{Generation: generation, Components: make(map[string]ComponentVersion)}
+	for component, version := range generations[generation-1] {
+		f.Components[component] = ComponentVersion{Version: version, Compat: version}
+	}
+	return f
+}
+
+func componentNames() []string {
+	names := make([]string, 0, len(generations[0]))
+	for component := range generations[0] {
+		names = append(names, component)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func writeFormat(root string, f *Format) error {
+	f.WrittenBy = filepath.Base(os.Args[0])
+	f.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return err
+	}
+	path := filepath.Join(root, FormatFile)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// ActiveMounts 返回挂载在 root 下的挂载点,升级时据此判断是否有运行中的容器
+func ActiveMounts(root string) ([]string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	prefix := filepath.Clean(root) + "/"
+	var mounts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		if strings.HasPrefix(fields[4], prefix) {
+			mounts = append(mounts, fields[4])
+		}
+	}
+	return mounts, scanner.Err()
+}
+
+// resetChunkIndexStats 让 chunk 索引回到没有计数器的版本:旧程序不维护计数器,
+// 清零 user_version 后再次升级时会用全表聚合重新回填
+func resetChunkIndexStats(root string) error {
+	return setUserVersion(filepath.Join(root, "chunk-index.db"), 0)
+}
+
+// resetChunkIndexRefCounts 让 chunk 索引回到第 2 代:旧程序删除镜像时每个 chunk 只减一次引用,
+// user_version 回到 1 后再次升级时重新计数
+func resetChunkIndexRefCounts(root string) error {
+	return setUserVersion(filepath.Join(root, "chunk-index.db"), 1)
+}
+
+// resetIndexRefCounts 让索引回到第 2 代:旧程序索引新 chunk 时多计一次引用,
+// 清零 user_version 后再次升级时按 files 表重新计数
+func resetIndexRefCounts(root string) error {
+	return setUserVersion(filepath.Join(root, "index.db"), 0)
+}
+
+func setUserVersion(path string, version int) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version))
+	return err
+}
@@ -0,0 +1,110 @@
+package layout
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func chunkIndexVersion(t *testing.T, root string) int {
+	db, err := sql.Open("sqlite3", filepath.Join(root, "chunk-index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+// TestUpgradeAndDowngrade 验证未记录版本的 root 被升级到当前代次,降级会重置 chunk 索引计数器版本
+func TestUpgradeAndDowngrade(t *testing.T) {
+	root := t.TempDir()
+
+	db, err := sql.Open("sqlite3", filepath.Join(root, "chunk-index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`PRAGMA user_version = 1`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	report, err := Check(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Versioned || report.Generation != 1 || !report.Compatible() {
+		t.Fatalf("unexpected report for unversioned root: %+v", report)
+	}
+
+	report, err = Upgrade(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Generation != CurrentGeneration || !report.Versioned {
+		t.Fatalf("expected generation %d after upgrade, got %+v", CurrentGeneration, report)
+	}
+
+	if _, err := Downgrade(root, 1); err != nil {
+		t.Fatal(err)
+	}
+	format, _, err := ReadFormat(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format.Generation != 1 || format.Components[ChunkIndex].Version != 0 {
+		t.Fatalf("unexpected format after downgrade: %+v", format)
+	}
+	if v := chunkIndexVersion(t, root); v != 0 {
+		t.Fatalf("expected chunk index stats to be reset, user_version=%d", v)
+	}
+	t.Logf("✓ 升级到第 %d 代后可降级回第 1 代", CurrentGeneration)
+}
+
+// TestNewerFormat 验证更新程序写入的格式:声明兼容时保留其代次,否则拒绝升级
+func TestNewerFormat(t *testing.T) {
+	root := t.TempDir()
+	if _, err := Upgrade(root); err != nil {
+		t.Fatal(err)
+	}
+
+	format, _, err := ReadFormat(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	format.Generation = CurrentGeneration + 1
+	format.Components[Images] = ComponentVersion{Version: 2, Compat: 1}
+	writeTestFormat(t, root, format)
+
+	report, err := Upgrade(root)
+	if err != nil {
+		t.Fatalf("expected compatible newer format: %v", err)
+	}
+	if report.Generation != CurrentGeneration+1 {
+		t.Errorf("expected newer generation to be kept, got %d", report.Generation)
+	}
+
+	format.Components[Images] = ComponentVersion{Version: 3, Compat: 3}
+	writeTestFormat(t, root, format)
+	report, err = Upgrade(root)
+	if !errors.Is(err, ErrIncompatible) || report.Compatible() {
+		t.Fatalf("expected ErrIncompatible, got %v", err)
+	}
+	t.Logf("✓ %s", report.Problems[0])
+}
+
+func writeTestFormat(t *testing.T, root string, format *Format) {
+	data, err := json.Marshal(format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, FormatFile), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
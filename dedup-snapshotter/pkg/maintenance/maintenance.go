@@ -0,0 +1,257 @@
+// Package maintenance 实现维护窗口:运维用 cron 表达式定义窗口,卷 GC、chunk 后台校验、父链扁平化
+// 和审计库压缩等重任务只在窗口内全速运行,窗口外逐项处理的任务每项之后等待一段时间或暂停到下一个窗口,
+// 整体执行的任务推迟到窗口内。窗口可随配置热更新,也可经 API 临时开启或关闭
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+)
+
+// 手动覆盖
+const (
+	OverrideOpen  = "open"
+	OverrideClose = "close"
+)
+
+// recheckInterval 是没有已知边界时重新计算窗口状态的间隔
+const recheckInterval = time.Minute
+
+type window struct {
+	config.MaintenanceWindow
+	sched *schedule
+}
+
+// Status 是维护窗口的当前状态。Active 为 true 表示重任务可以全速运行,未启用维护窗口时始终为 true
+type Status struct {
+	Enabled bool `json:"enabled"`
+	Active  bool `json:"active"`
+	// Until 是当前窗口的结束时间,Next 是下一个窗口的开始时间,零值表示未知
+	Until time.Time `json:"until,omitempty"`
+	Next  time.Time `json:"next,omitempty"`
+	// Override 为 open 或 close 时表示窗口被手动开启或关闭,直到 OverrideUntil
+	Override      string                     `json:"override,omitempty"`
+	OverrideUntil time.Time                  `json:"override_until,omitempty"`
+	OutsideDelay  int                        `json:"outside_delay_ms"`
+	Windows       []config.MaintenanceWindow `json:"windows"`
+}
+
+// Scheduler 判断当前是否处于维护窗口,并按配置节流窗口外的重任务。nil 的 Scheduler 不做任何限制
+type Scheduler struct {
+	mu            sync.Mutex
+	cfg           config.MaintenanceConfig
+	windows       []window
+	override      string
+	overrideUntil time.Time
+	// changed 在配置或手动覆盖变化时关闭,唤醒等待中的任务
+	changed chan struct{}
+	now     func() time.Time
+
+	// 缓存的窗口状态,在 validUntil 之前有效
+	active     bool
+	until      time.Time
+	next       time.Time
+	validUntil time.Time
+}
+
+// New 按 cfg 创建调度器,cron 表达式无效时返回错误
+func New(cfg config.MaintenanceConfig) (*Scheduler, error) {
+	s := &Scheduler{changed: make(chan struct{}), now: time.Now}
+	if err := s.SetConfig(cfg); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SetConfig 替换窗口配置,任一 cron 表达式无效时保留原配置并返回错误
+func (s *Scheduler) SetConfig(cfg config.MaintenanceConfig) error {
+	windows := make([]window, 0, len(cfg.Windows))
+	for _, w := range cfg.Windows {
+		sched, err := parseSchedule(w.Schedule)
+		if err != nil {
+			return err
+		}
+		if w.Duration <= 0 {
+			return fmt.Errorf("maintenance window %q must have a positive duration", w.Schedule)
+		}
+		windows = append(windows, window{MaintenanceWindow: w, sched: sched})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cfg = cfg
+	s.windows = windows
+	s.notifyLocked()
+	return nil
+}
+
+// Override 手动开启(open 为 true)或关闭维护窗口 d 时间,之后恢复按配置的窗口
+func (s *Scheduler) Override(open bool, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.override = OverrideClose
+	if open {
+		s.override = OverrideOpen
+	}
+	s.overrideUntil = s.now().Add(d)
+	s.notifyLocked()
+}
+
+// ClearOverride 取消手动覆盖
+func (s *Scheduler) ClearOverride() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.override = ""
+	s.overrideUntil = time.Time{}
+	s.notifyLocked()
+}
+
+func (s *Scheduler) notifyLocked() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+	s.validUntil = time.Time{}
+}
+
+// Active 报告重任务当前是否可以全速运行
+func (s *Scheduler) Active() bool {
+	if s == nil {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	active, _, _ := s.stateLocked()
+	return active
+}
+
+// Status 返回维护窗口的当前状态
+func (s *Scheduler) Status() Status {
+	if s == nil {
+		return Status{Active: true}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	active, until, next := s.stateLocked()
+	status := Status{
+		Enabled:      s.cfg.Enabled,
+		Active:       active,
+		Until:        until,
+		Next:         next,
+		OutsideDelay: s.cfg.OutsideDelay,
+		Windows:      append([]config.MaintenanceWindow{}, s.cfg.Windows...),
+	}
+	if s.override != "" && s.now().Before(s.overrideUntil) {
+		status.Override = s.override
+		status.OverrideUntil = s.overrideUntil
+	}
+	return status
+}
+
+// stateLocked 返回是否处于窗口、当前窗口的结束时间和下一个窗口的开始时间,结果缓存到下一个边界
+func (s *Scheduler) stateLocked() (bool, time.Time, time.Time) {
+	now := s.now()
+	if !s.cfg.Enabled {
+		return true, time.Time{}, time.Time{}
+	}
+	if now.Before(s.validUntil) {
+		return s.active, s.until, s.next
+	}
+
+	s.active, s.until, s.next = false, time.Time{}, time.Time{}
+	switch {
+	case s.override != "" && now.Before(s.overrideUntil):
+		if s.override == OverrideOpen {
+			s.active, s.until = true, s.overrideUntil
+		} else {
+			s.next = s.overrideUntil
+		}
+	default:
+		for _, w := range s.windows {
+			duration := time.Duration(w.Duration) * time.Second
+			if start, ok := w.sched.lastStart(now, duration); ok {
+				if end := start.Add(duration); end.After(s.until) {
+					s.active, s.until = true, end
+				}
+			}
+		}
+		if !s.active {
+			for _, w := range s.windows {
+				if next := w.sched.next(now); !next.IsZero() && (s.next.IsZero() || next.Before(s.next)) {
+					s.next = next
+				}
+			}
+		}
+	}
+
+	// 状态在窗口结束或下一个窗口开始前不变,但不超过 recheckInterval,以便重叠的窗口被及时发现
+	s.validUntil = now.Add(recheckInterval)
+	if boundary := s.until; s.active && boundary.Before(s.validUntil) {
+		s.validUntil = boundary
+	}
+	if boundary := s.next; !s.active && !boundary.IsZero() && boundary.Before(s.validUntil) {
+		s.validUntil = boundary
+	}
+	return s.active, s.until, s.next
+}
+
+// Throttle 在逐项处理的重任务每处理一项后调用:窗口内立即返回;窗口外等待 outside_delay_ms,
+// 为 0 时等到下一个窗口开始。ctx 取消时返回其错误
+func (s *Scheduler) Throttle(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	active, _, _ := s.stateLocked()
+	delay := time.Duration(s.cfg.OutsideDelay) * time.Millisecond
+	changed := s.changed
+	s.mu.Unlock()
+	if active {
+		return nil
+	}
+	if delay <= 0 {
+		return s.Wait(ctx)
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+	case <-changed:
+	}
+	return nil
+}
+
+// Wait 等到处于维护窗口内,用于只能整体执行的重任务。ctx 取消时返回其错误
+func (s *Scheduler) Wait(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	for {
+		s.mu.Lock()
+		active, _, _ := s.stateLocked()
+		wait := s.validUntil.Sub(s.now())
+		changed := s.changed
+		s.mu.Unlock()
+		if active {
+			return nil
+		}
+		if wait <= 0 {
+			wait = time.Second
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		case <-changed:
+			timer.Stop()
+		}
+	}
+}
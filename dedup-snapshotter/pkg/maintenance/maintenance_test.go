@@ -0,0 +1,121 @@
+package maintenance
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+)
+
+// TestSchedule 验证 cron 表达式的解析、窗口是否生效以及下一个窗口的计算
+func TestSchedule(t *testing.T) {
+	for _, spec := range []string{"* * *", "60 * * * *", "0 24 * * *", "0 2 0 * *", "*/0 * * * *", "5-1 * * * *"} {
+		if _, err := parseSchedule(spec); err == nil {
+			t.Errorf("expected %q to be rejected", spec)
+		}
+	}
+
+	// 工作日 22:30 开始
+	sched, err := parseSchedule("30 22 * * 1-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2026-10-16 是周五
+	fri := time.Date(2026, 10, 16, 23, 0, 0, 0, time.Local)
+	if start, ok := sched.lastStart(fri, 2*time.Hour); !ok || start.Hour() != 22 || start.Minute() != 30 {
+		t.Fatalf("expected the window started at 22:30 to be active, got %v %v", start, ok)
+	}
+	if _, ok := sched.lastStart(fri, 20*time.Minute); ok {
+		t.Fatal("expected a 20 minute window to have ended")
+	}
+	next := sched.next(fri)
+	if want := time.Date(2026, 10, 19, 22, 30, 0, 0, time.Local); !next.Equal(want) {
+		t.Fatalf("expected the next window on monday %v, got %v", want, next)
+	}
+
+	// 周日写作 7,日和周都受限时任一匹配即可
+	sched, _ = parseSchedule("0 3 1 * 7")
+	if next := sched.next(fri); next.Day() != 18 || next.Weekday() != time.Sunday {
+		t.Fatalf("expected sunday 18th, got %v", next)
+	}
+	if next := sched.next(time.Date(2026, 10, 25, 4, 0, 0, 0, time.Local)); next.Day() != 1 || next.Month() != time.November {
+		t.Fatalf("expected november 1st, got %v", next)
+	}
+	t.Logf("✓ 工作日窗口下一次开始于 %s", next.Format(time.RFC3339))
+}
+
+// TestScheduler 验证窗口外节流和暂停、窗口内不限制,以及手动开启窗口唤醒等待中的任务
+func TestScheduler(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.Local)
+	cfg := config.MaintenanceConfig{
+		Enabled:      true,
+		Windows:      []config.MaintenanceWindow{{Schedule: "0 2 * * *", Duration: 4 * 3600}},
+		OutsideDelay: 20,
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return now }
+
+	status := s.Status()
+	if status.Active || !status.Next.Equal(time.Date(2026, 10, 17, 2, 0, 0, 0, time.Local)) {
+		t.Fatalf("expected to be outside the window until 02:00, got %+v", status)
+	}
+	start := time.Now()
+	if err := s.Throttle(context.Background()); err != nil || time.Since(start) < 20*time.Millisecond {
+		t.Fatalf("expected work outside the window to be delayed, got %v after %v", err, time.Since(start))
+	}
+	t.Logf("✓ 窗口外每项等待 %v", time.Since(start).Round(time.Millisecond))
+
+	// outside_delay_ms 为 0 时暂停到窗口开启,手动开启窗口唤醒等待中的任务
+	cfg.OutsideDelay = 0
+	if err := s.SetConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Throttle(context.Background()) }()
+	select {
+	case err := <-done:
+		t.Fatalf("expected work to pause outside the window, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	s.Override(true, time.Hour)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected opening the window to resume paused work")
+	}
+	if status := s.Status(); !status.Active || status.Override != OverrideOpen {
+		t.Fatalf("expected a manually opened window, got %+v", status)
+	}
+	s.ClearOverride()
+	t.Logf("✓ 暂停的任务在手动开启窗口后继续")
+
+	// 进入配置的窗口后全速运行
+	now = time.Date(2026, 10, 17, 3, 0, 0, 0, time.Local)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Throttle(ctx); err != nil || !s.Active() {
+		t.Fatalf("expected work to run at full speed inside the window, got %v", err)
+	}
+	if status := s.Status(); !status.Until.Equal(time.Date(2026, 10, 17, 6, 0, 0, 0, time.Local)) {
+		t.Fatalf("expected the window to end at 06:00, got %+v", status)
+	}
+
+	if err := s.SetConfig(config.MaintenanceConfig{Enabled: true, Windows: []config.MaintenanceWindow{{Schedule: "bad", Duration: 60}}}); err == nil {
+		t.Fatal("expected an invalid schedule to be rejected")
+	}
+	if !s.Active() {
+		t.Fatal("expected the previous windows to be kept after a rejected update")
+	}
+	var disabled *Scheduler
+	if !disabled.Active() || disabled.Throttle(ctx) != nil {
+		t.Fatal("expected a nil scheduler not to throttle")
+	}
+	t.Logf("✓ 窗口内全速运行,无效配置不替换当前窗口")
+}
@@ -20,6 +20,16 @@ type MemoryDeduplicator struct {
 	mergedPages  int64
 	savedMemory  int64
 	ksm          *KSMController
+	// advised 记录已处理过的文件(按 inode 和 mtime),内容未变时不重复 mmap
+	advisedMu    sync.Mutex
+	advised      map[fileKey]struct{}
+}
+
+type fileKey struct {
+	dev   uint64
+	ino   uint64
+	mtime int64
+	size  int64
 }
 
 type PageInfo struct {
@@ -48,6 +58,7 @@ func NewMemoryDeduplicator(root string) (*MemoryDeduplicator, error) {
 		root:     root,
 		pageSize: pageSize,
 		pageMap:  make(map[string]*PageInfo),
+		advised:  make(map[fileKey]struct{}),
 		ksm:      ksm,
 	}, nil
 }
@@ -156,13 +167,45 @@ func (m *MemoryDeduplicator) DeduplicateFile(filePath string) error {
 		return nil
 	}
 
+	key, hasKey := statKey(stat)
+	if hasKey {
+		m.advisedMu.Lock()
+		_, seen := m.advised[key]
+		m.advisedMu.Unlock()
+		if seen {
+			return nil
+		}
+	}
+
 	data, err := syscall.Mmap(int(file.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_PRIVATE)
 	if err != nil {
 		return fmt.Errorf("mmap failed: %w", err)
 	}
 	defer syscall.Munmap(data)
 
-	return m.processPages(data, filePath)
+	if err := m.processPages(data, filePath); err != nil {
+		return err
+	}
+
+	if hasKey {
+		m.advisedMu.Lock()
+		m.advised[key] = struct{}{}
+		m.advisedMu.Unlock()
+	}
+	return nil
+}
+
+func statKey(info os.FileInfo) (fileKey, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileKey{}, false
+	}
+	return fileKey{
+		dev:   uint64(st.Dev),
+		ino:   st.Ino,
+		mtime: info.ModTime().UnixNano(),
+		size:  info.Size(),
+	}, true
 }
 
 func (m *MemoryDeduplicator) processPages(data []byte, filePath string) error {
@@ -137,7 +137,7 @@ func TestMemoryDedupWithKSM(t *testing.T) {
 
 	fileCount := 5
 	for i := 0; i < fileCount; i++ {
-		testFile := filepath.Join(tmpDir, filepath.Join("test", string('A'+i)+".dat"))
+		testFile := filepath.Join(tmpDir, filepath.Join("test", string(rune('A'+i))+".dat"))
 		os.MkdirAll(filepath.Dir(testFile), 0755)
 
 		if err := os.WriteFile(testFile, sharedContent, 0644); err != nil {
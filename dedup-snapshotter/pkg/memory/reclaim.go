@@ -0,0 +1,493 @@
+package memory
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/metrics"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	pageIdleBitmap = "/sys/kernel/mm/page_idle/bitmap"
+	selfPagemap    = "/proc/self/pagemap"
+
+	pagemapPresent = 1 << 63
+	pagemapPFNMask = 1<<55 - 1
+)
+
+// pageSampler 返回文件每页自上次采样以来是否被访问过,跨轮状态保存在 trackedFile 中
+type pageSampler interface {
+	Sample(path string, f *trackedFile) ([]bool, error)
+}
+
+// trackedMount 是一个被采样的挂载,files 在后台遍历完成后填充
+type trackedMount struct {
+	files map[string]*trackedFile
+}
+
+// trackedFile 是一个被采样的镜像文件,idle 为每页连续未被访问的轮数
+type trackedFile struct {
+	size int64
+	idle []uint8
+	pfns []uint64
+}
+
+// ReclaimStats 汇总冷页回收,ColdBytes 为已 madvise 的驻留页,ReclaimedBytes 为随即从页缓存释放的字节数
+type ReclaimStats struct {
+	Mode           string `json:"mode"`
+	Files          int    `json:"files"`
+	Passes         int64  `json:"passes"`
+	ColdBytes      int64  `json:"cold_bytes"`
+	ReclaimedBytes int64  `json:"reclaimed_bytes"`
+}
+
+// Reclaimer 周期性采样已挂载镜像中文件页的访问情况,对连续多轮未被访问的页范围执行
+// MADV_COLD 或 MADV_PAGEOUT。与 KSM 互补:KSM 合并重复页,Reclaimer 主动释放冷页,
+// 适用于内存紧张的边缘节点。访问采样基于内核的 page_idle 位图,需要 CAP_SYS_ADMIN
+type Reclaimer struct {
+	mode      string
+	advice    int
+	interval  time.Duration
+	coldAfter uint8
+	pageSize  int
+	sampler   pageSampler
+	metrics   *metrics.Metrics
+
+	mu     sync.Mutex
+	mounts map[string]*trackedMount
+	stats  ReclaimStats
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewReclaimer 创建回收器,mode 为 cold 或 pageout;内核不支持 page_idle 时返回错误
+func NewReclaimer(mode string, interval time.Duration, coldAfter int) (*Reclaimer, error) {
+	sampler, err := newIdleSampler()
+	if err != nil {
+		return nil, err
+	}
+	return NewReclaimerWithOptions(mode, interval, coldAfter, sampler)
+}
+
+// NewReclaimerWithOptions 使用给定的采样器创建回收器,调用 Start 后开始采样
+func NewReclaimerWithOptions(mode string, interval time.Duration, coldAfter int, sampler pageSampler) (*Reclaimer, error) {
+	var advice int
+	switch mode {
+	case "cold":
+		advice = unix.MADV_COLD
+	case "pageout":
+		advice = unix.MADV_PAGEOUT
+	default:
+		return nil, fmt.Errorf("unsupported reclaim mode %q", mode)
+	}
+	if coldAfter <= 0 || coldAfter > 255 {
+		return nil, fmt.Errorf("cold threshold must be between 1 and 255 samples")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Reclaimer{
+		mode:      mode,
+		advice:    advice,
+		interval:  interval,
+		coldAfter: uint8(coldAfter),
+		pageSize:  os.Getpagesize(),
+		sampler:   sampler,
+		mounts:    make(map[string]*trackedMount),
+		stats:     ReclaimStats{Mode: mode},
+		ctx:       ctx,
+		cancel:    cancel,
+	}, nil
+}
+
+func (r *Reclaimer) SetMetrics(m *metrics.Metrics) {
+	r.metrics = m
+}
+
+// Start 启动周期采样
+func (r *Reclaimer) Start() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.ctx.Done():
+				return
+			case <-ticker.C:
+				r.Pass()
+			}
+		}
+	}()
+}
+
+// Track 在后台遍历挂载点 root,之后每轮采样其中的普通文件;同一 id 已在采样时直接返回
+func (r *Reclaimer) Track(id, root string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.mounts[id]; exists {
+		return
+	}
+	m := &trackedMount{}
+	r.mounts[id] = m
+
+	go func() {
+		files := make(map[string]*trackedFile)
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil || !info.Mode().IsRegular() || info.Size() == 0 {
+				return nil
+			}
+			files[path] = &trackedFile{size: info.Size()}
+			return nil
+		})
+
+		// 遍历期间被 Untrack 的挂载不再加入
+		r.mu.Lock()
+		if r.mounts[id] == m {
+			m.files = files
+		}
+		r.mu.Unlock()
+	}()
+}
+
+// Untrack 停止采样挂载 id,须在卸载之前调用
+func (r *Reclaimer) Untrack(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.mounts, id)
+}
+
+// Pass 对所有被采样的文件做一轮采样,并回收新变冷的页
+func (r *Reclaimer) Pass() {
+	r.mu.Lock()
+	var ids []string
+	for id := range r.mounts {
+		ids = append(ids, id)
+	}
+	r.mu.Unlock()
+
+	var cold, reclaimed int64
+	for _, id := range ids {
+		r.mu.Lock()
+		var files map[string]*trackedFile
+		if m, ok := r.mounts[id]; ok {
+			files = m.files
+		}
+		r.mu.Unlock()
+
+		for path, f := range files {
+			if r.ctx.Err() != nil {
+				return
+			}
+			// 采样期间挂载可能被移除,未跟踪的文件不再映射,以免阻止卸载
+			r.mu.Lock()
+			_, tracked := r.mounts[id]
+			r.mu.Unlock()
+			if !tracked {
+				break
+			}
+
+			c, n, err := r.sampleFile(path, f)
+			if err != nil {
+				log.L.WithError(err).Debugf("cold page sampling of %s failed", path)
+				continue
+			}
+			cold += c
+			reclaimed += n
+		}
+	}
+
+	r.mu.Lock()
+	r.stats.Passes++
+	r.stats.ColdBytes += cold
+	r.stats.ReclaimedBytes += reclaimed
+	r.mu.Unlock()
+	if r.metrics != nil && reclaimed > 0 {
+		r.metrics.AddMemoryReclaimed(reclaimed)
+	}
+	if cold > 0 {
+		log.L.Debugf("advised %d bytes of cold image pages, %d bytes reclaimed", cold, reclaimed)
+	}
+}
+
+func (r *Reclaimer) sampleFile(path string, f *trackedFile) (int64, int64, error) {
+	pages := int((f.size + int64(r.pageSize) - 1) / int64(r.pageSize))
+	if len(f.idle) != pages {
+		f.idle = make([]uint8, pages)
+		f.pfns = nil
+	}
+
+	accessed, err := r.sampler.Sample(path, f)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var ranges []pageRange
+	for i := range f.idle {
+		if accessed[i] {
+			f.idle[i] = 0
+			continue
+		}
+		if f.idle[i] < r.coldAfter {
+			f.idle[i]++
+			// 只在刚达到阈值时回收一次,之后被重新读入的页会先被采样为已访问
+			if f.idle[i] == r.coldAfter {
+				ranges = appendPage(ranges, i)
+			}
+		}
+	}
+	if len(ranges) == 0 {
+		return 0, 0, nil
+	}
+	return r.advise(path, f.size, ranges)
+}
+
+// pageRange 是连续的页区间 [start, end)
+type pageRange struct {
+	start, end int
+}
+
+func appendPage(ranges []pageRange, page int) []pageRange {
+	if n := len(ranges); n > 0 && ranges[n-1].end == page {
+		ranges[n-1].end++
+		return ranges
+	}
+	return append(ranges, pageRange{start: page, end: page + 1})
+}
+
+// advise 对冷页区间执行 madvise,返回区间内原本驻留的字节数和随即被释放的字节数
+func (r *Reclaimer) advise(path string, size int64, ranges []pageRange) (int64, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	data, err := unix.Mmap(int(file.Fd()), 0, int(size), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return 0, 0, fmt.Errorf("mmap failed: %w", err)
+	}
+	defer unix.Munmap(data)
+
+	var cold, reclaimed int64
+	for _, pr := range ranges {
+		region := data[pr.start*r.pageSize : min(pr.end*r.pageSize, len(data))]
+		before := r.resident(region)
+		if before == 0 {
+			continue
+		}
+		// madvise 只作用于本进程页表中已映射的页,先映射驻留页
+		vec := make([]byte, pr.end-pr.start)
+		mincore(region, vec)
+		touchResident(region, vec, r.pageSize)
+		if err := unix.Madvise(region, r.advice); err != nil {
+			return cold, reclaimed, fmt.Errorf("madvise failed: %w", err)
+		}
+		cold += before
+		reclaimed += before - r.resident(region)
+	}
+	return cold, reclaimed, nil
+}
+
+// resident 返回区间内驻留在页缓存中的字节数
+func (r *Reclaimer) resident(region []byte) int64 {
+	vec := make([]byte, (len(region)+r.pageSize-1)/r.pageSize)
+	if err := mincore(region, vec); err != nil {
+		return 0
+	}
+	var n int64
+	for _, v := range vec {
+		if v&1 != 0 {
+			n += int64(r.pageSize)
+		}
+	}
+	return n
+}
+
+func (r *Reclaimer) Stats() ReclaimStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := r.stats
+	for _, m := range r.mounts {
+		stats.Files += len(m.files)
+	}
+	return stats
+}
+
+// Close 停止采样
+func (r *Reclaimer) Close() {
+	r.cancel()
+	r.wg.Wait()
+}
+
+// idleSampler 用 page_idle 位图采样:每轮先读取上一轮标记为空闲的页帧是否仍空闲,再重新取得
+// 各驻留页的页帧号并标记为空闲。页帧号变化说明页被换出后重新读入,同样视为已访问
+type idleSampler struct {
+	pageSize int
+}
+
+func newIdleSampler() (*idleSampler, error) {
+	f, err := os.OpenFile(pageIdleBitmap, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("page idle tracking not available: %w", err)
+	}
+	f.Close()
+	return &idleSampler{pageSize: os.Getpagesize()}, nil
+}
+
+func (s *idleSampler) Sample(path string, f *trackedFile) ([]bool, error) {
+	bitmap, err := os.OpenFile(pageIdleBitmap, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer bitmap.Close()
+
+	pages := len(f.idle)
+	accessed := make([]bool, pages)
+	// 在本进程映射这些页之前读取空闲位,避免采样本身被计为访问
+	idle := make([]bool, pages)
+	for i, pfn := range f.pfns {
+		if pfn != 0 {
+			idle[i], err = readIdle(bitmap, pfn)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	pfns, err := s.residentPFNs(path, f.size, pages)
+	if err != nil {
+		return nil, err
+	}
+	for i, pfn := range pfns {
+		if pfn == 0 {
+			// 未驻留的页没有可回收的内容
+			continue
+		}
+		accessed[i] = i >= len(f.pfns) || f.pfns[i] != pfn || !idle[i]
+	}
+	if err := markIdle(bitmap, pfns); err != nil {
+		return nil, err
+	}
+	f.pfns = pfns
+	return accessed, nil
+}
+
+// residentPFNs 返回文件各驻留页的页帧号,未驻留的页为 0
+func (s *idleSampler) residentPFNs(path string, size int64, pages int) ([]uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data, err := unix.Mmap(int(file.Fd()), 0, int(size), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("mmap failed: %w", err)
+	}
+	defer unix.Munmap(data)
+
+	vec := make([]byte, pages)
+	if err := mincore(data, vec); err != nil {
+		return nil, fmt.Errorf("mincore failed: %w", err)
+	}
+	// 只映射已驻留的页,不把未缓存的内容读进来
+	touchResident(data, vec, s.pageSize)
+
+	pagemap, err := os.Open(selfPagemap)
+	if err != nil {
+		return nil, err
+	}
+	defer pagemap.Close()
+
+	entries := make([]byte, pages*8)
+	offset := int64(uintptr(unsafe.Pointer(&data[0]))/uintptr(s.pageSize)) * 8
+	if _, err := pagemap.ReadAt(entries, offset); err != nil {
+		return nil, fmt.Errorf("failed to read pagemap: %w", err)
+	}
+
+	pfns := make([]uint64, pages)
+	found := false
+	for i := range pfns {
+		entry := binary.LittleEndian.Uint64(entries[i*8:])
+		if vec[i]&1 != 0 && entry&pagemapPresent != 0 {
+			pfns[i] = entry & pagemapPFNMask
+			found = found || pfns[i] != 0
+		}
+	}
+	if !found && hasResident(vec) {
+		return nil, fmt.Errorf("page frame numbers hidden by the kernel, CAP_SYS_ADMIN required")
+	}
+	return pfns, nil
+}
+
+// mincore 填充每页是否驻留在页缓存中,vec 每页一字节
+func mincore(data, vec []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	_, _, errno := unix.Syscall(unix.SYS_MINCORE, uintptr(unsafe.Pointer(&data[0])), uintptr(len(data)), uintptr(unsafe.Pointer(&vec[0])))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// touched 接收读取的字节,防止编译器省略触碰页面的读操作
+var touched byte
+
+// touchResident 读取每个驻留页的首字节,让它们映射到本进程的页表
+func touchResident(data, vec []byte, pageSize int) {
+	for i, v := range vec {
+		if v&1 != 0 {
+			touched += data[i*pageSize]
+		}
+	}
+}
+
+func hasResident(vec []byte) bool {
+	for _, v := range vec {
+		if v&1 != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func readIdle(bitmap *os.File, pfn uint64) (bool, error) {
+	word := make([]byte, 8)
+	if _, err := bitmap.ReadAt(word, int64(pfn/64*8)); err != nil {
+		return false, fmt.Errorf("failed to read page idle bitmap: %w", err)
+	}
+	return binary.LittleEndian.Uint64(word)&(1<<(pfn%64)) != 0, nil
+}
+
+// markIdle 把页帧标记为空闲;位图写入以 8 字节为单位,写入 0 的位不受影响
+func markIdle(bitmap *os.File, pfns []uint64) error {
+	words := make(map[uint64]uint64)
+	for _, pfn := range pfns {
+		if pfn != 0 {
+			words[pfn/64] |= 1 << (pfn % 64)
+		}
+	}
+	word := make([]byte, 8)
+	for index, bits := range words {
+		binary.LittleEndian.PutUint64(word, bits)
+		if _, err := bitmap.WriteAt(word, int64(index*8)); err != nil {
+			return fmt.Errorf("failed to write page idle bitmap: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package memory
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// hotPages 模拟访问采样:前 hot 页每轮都被访问,其余页从不访问
+type hotPages struct {
+	hot     int
+	samples int
+}
+
+func (s *hotPages) Sample(path string, f *trackedFile) ([]bool, error) {
+	s.samples++
+	accessed := make([]bool, len(f.idle))
+	for i := 0; i < s.hot && i < len(accessed); i++ {
+		accessed[i] = true
+	}
+	return accessed, nil
+}
+
+// TestReclaimerAdvisesColdPages 验证连续多轮未被访问的页只被回收一次,热页不受影响,取消跟踪后不再采样
+func TestReclaimerAdvisesColdPages(t *testing.T) {
+	pageSize := os.Getpagesize()
+	mountDir := t.TempDir()
+	path := filepath.Join(mountDir, "lib.so")
+	if err := os.WriteFile(path, bytes.Repeat([]byte("C"), 8*pageSize), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sampler := &hotPages{hot: 2}
+	r, err := NewReclaimerWithOptions("cold", time.Hour, 2, sampler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	r.Track("img", mountDir)
+	deadline := time.Now().Add(5 * time.Second)
+	for r.Stats().Files == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("mount walk did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	os.ReadFile(path)
+	r.Pass()
+	if stats := r.Stats(); stats.ColdBytes != 0 {
+		t.Fatalf("pages advised before reaching the cold threshold: %+v", stats)
+	}
+	r.Pass()
+	cold := r.Stats().ColdBytes
+	if cold > int64(6*pageSize) {
+		t.Errorf("hot pages advised: %d bytes", cold)
+	}
+	r.Pass()
+	if stats := r.Stats(); stats.ColdBytes != cold || stats.Passes != 3 {
+		t.Errorf("cold pages advised again: %+v", stats)
+	}
+
+	r.Untrack("img")
+	r.Pass()
+	if sampler.samples != 3 {
+		t.Errorf("untracked mount sampled, %d samples", sampler.samples)
+	}
+
+	var ranges []pageRange
+	for _, page := range []int{2, 3, 4, 7} {
+		ranges = appendPage(ranges, page)
+	}
+	if len(ranges) != 2 || ranges[0] != (pageRange{2, 5}) || ranges[1] != (pageRange{7, 8}) {
+		t.Errorf("unexpected ranges %v", ranges)
+	}
+	t.Logf("✓ 冷页回收 %+v", r.Stats())
+}
@@ -0,0 +1,199 @@
+package memory
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/background"
+)
+
+// FileScanner 用固定数量的 worker 扫描挂载点中的文件做内存去重,
+// 按 filesPerSecond 限速,每个挂载的扫描可以单独取消
+type FileScanner struct {
+	dedup   *MemoryDeduplicator
+	files   chan scanFile
+	limiter *time.Ticker
+	mu      sync.Mutex
+	scans   map[string]*scan
+	wg      sync.WaitGroup
+	ctx     context.Context
+	cancel  context.CancelFunc
+	bg      *background.Controller
+}
+
+// scan 是一次挂载扫描,遍历结束且排队的文件处理完后自动结束
+type scan struct {
+	id      string
+	ctx     context.Context
+	cancel  context.CancelFunc
+	walking bool
+	pending int
+}
+
+type scanFile struct {
+	scan *scan
+	path string
+}
+
+// NewFileScanner 创建扫描器,filesPerSecond 为 0 表示不限速
+func NewFileScanner(dedup *MemoryDeduplicator, workers, filesPerSecond int) *FileScanner {
+	return NewFileScannerWithOptions(dedup, workers, filesPerSecond, nil)
+}
+
+// NewFileScannerWithOptions 创建扫描器,bg 非 nil 时遍历和 worker 以后台优先级运行
+func NewFileScannerWithOptions(dedup *MemoryDeduplicator, workers, filesPerSecond int, bg *background.Controller) *FileScanner {
+	if workers <= 0 {
+		workers = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &FileScanner{
+		dedup:  dedup,
+		files:  make(chan scanFile, workers*4),
+		scans:  make(map[string]*scan),
+		ctx:    ctx,
+		cancel: cancel,
+		bg:     bg,
+	}
+	if filesPerSecond > 0 {
+		s.limiter = time.NewTicker(time.Second / time.Duration(filesPerSecond))
+	}
+
+	for i := 0; i < workers; i++ {
+		s.wg.Add(1)
+		bg.Go(s.worker)
+	}
+	return s
+}
+
+// Scan 在后台遍历 root 并提交其中的普通文件;同一 id 已在扫描时直接返回
+func (s *FileScanner) Scan(id, root string) {
+	s.mu.Lock()
+	if _, running := s.scans[id]; running || s.ctx.Err() != nil {
+		s.mu.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	sc := &scan{id: id, ctx: ctx, cancel: cancel, walking: true}
+	s.scans[id] = sc
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	s.bg.Go(func() {
+		defer s.wg.Done()
+
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if !info.Mode().IsRegular() || info.Size() == 0 {
+				return nil
+			}
+
+			s.mu.Lock()
+			sc.pending++
+			s.mu.Unlock()
+			select {
+			case s.files <- scanFile{scan: sc, path: path}:
+				return nil
+			case <-ctx.Done():
+				s.done(sc)
+				return ctx.Err()
+			}
+		})
+		if err != nil && ctx.Err() == nil {
+			log.L.WithError(err).Debugf("memory dedup scan of %s stopped", root)
+		}
+
+		s.mu.Lock()
+		sc.walking = false
+		s.mu.Unlock()
+		s.finishIfIdle(sc)
+	})
+}
+
+// Cancel 停止挂载 id 的扫描,已排队的文件也会被跳过
+func (s *FileScanner) Cancel(id string) {
+	s.mu.Lock()
+	sc, ok := s.scans[id]
+	delete(s.scans, id)
+	s.mu.Unlock()
+
+	if ok {
+		sc.cancel()
+	}
+}
+
+// done 在一个排队文件处理完或被跳过后调用
+func (s *FileScanner) done(sc *scan) {
+	s.mu.Lock()
+	sc.pending--
+	s.mu.Unlock()
+	s.finishIfIdle(sc)
+}
+
+func (s *FileScanner) finishIfIdle(sc *scan) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sc.walking || sc.pending > 0 {
+		return
+	}
+	sc.cancel()
+	if s.scans[sc.id] == sc {
+		delete(s.scans, sc.id)
+	}
+}
+
+func (s *FileScanner) worker() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case f := <-s.files:
+			s.process(f)
+			s.done(f.scan)
+		}
+	}
+}
+
+func (s *FileScanner) process(f scanFile) {
+	if f.scan.ctx.Err() != nil {
+		return
+	}
+	if s.limiter != nil {
+		select {
+		case <-s.limiter.C:
+		case <-f.scan.ctx.Done():
+			return
+		}
+	}
+	if err := s.dedup.DeduplicateFile(f.path); err != nil {
+		log.L.WithError(err).Debugf("memory dedup of %s failed", f.path)
+	}
+}
+
+// Scanning 返回仍在扫描中的挂载数
+func (s *FileScanner) Scanning() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.scans)
+}
+
+// Close 取消所有扫描并等待 worker 退出
+func (s *FileScanner) Close() {
+	s.cancel()
+	s.wg.Wait()
+	if s.limiter != nil {
+		s.limiter.Stop()
+	}
+}
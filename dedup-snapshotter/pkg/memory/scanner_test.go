@@ -0,0 +1,88 @@
+package memory
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestFileScannerSkipsAdvisedFiles 验证扫描完成后自动结束,重复扫描不会再次处理未变化的文件
+func TestFileScannerSkipsAdvisedFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	dedup, err := NewMemoryDeduplicator(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dedup.Close()
+
+	mountDir := filepath.Join(tmpDir, "mnt")
+	os.MkdirAll(mountDir, 0755)
+	content := bytes.Repeat([]byte("SCANNER"), 4096)
+	for i := 0; i < 4; i++ {
+		if err := os.WriteFile(filepath.Join(mountDir, fmt.Sprintf("f%d", i)), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scanner := NewFileScanner(dedup, 2, 0)
+	defer scanner.Close()
+
+	waitIdle := func() {
+		deadline := time.Now().Add(5 * time.Second)
+		for scanner.Scanning() > 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("scan did not finish")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	scanner.Scan("layer", mountDir)
+	waitIdle()
+	first, _ := dedup.GetStats()
+	if first.MergedPages == 0 {
+		t.Fatalf("expected pages to be merged, got %+v", first)
+	}
+
+	scanner.Scan("layer", mountDir)
+	waitIdle()
+	second, _ := dedup.GetStats()
+	if second.MergedPages != first.MergedPages {
+		t.Errorf("expected unchanged files to be skipped, merged pages %d -> %d", first.MergedPages, second.MergedPages)
+	}
+	t.Logf("✓ 重复扫描跳过已处理文件 (合并页面 %d)", second.MergedPages)
+}
+
+// TestFileScannerCancel 验证取消后排队的文件不再处理
+func TestFileScannerCancel(t *testing.T) {
+	tmpDir := t.TempDir()
+	dedup, err := NewMemoryDeduplicator(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dedup.Close()
+
+	for i := 0; i < 50; i++ {
+		os.WriteFile(filepath.Join(tmpDir, fmt.Sprintf("f%d", i)), []byte("data"), 0644)
+	}
+
+	// 每秒 1 个文件,取消时绝大部分文件仍在排队
+	scanner := NewFileScanner(dedup, 1, 1)
+	defer scanner.Close()
+
+	scanner.Scan("layer", tmpDir)
+	scanner.Cancel("layer")
+	if n := scanner.Scanning(); n != 0 {
+		t.Fatalf("expected no running scans after cancel, got %d", n)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	stats, _ := dedup.GetStats()
+	if stats.UniquePages > 1 {
+		t.Errorf("expected cancelled scan to stop, got %d unique pages", stats.UniquePages)
+	}
+	t.Logf("✓ 取消扫描后停止处理")
+}
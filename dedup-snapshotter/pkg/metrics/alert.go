@@ -0,0 +1,226 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/containerd/log"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	AlertDedupRatioLow   = "dedup_ratio_low"
+	AlertCacheHitRateLow = "cache_hit_rate_low"
+	AlertDiskUsageHigh   = "disk_usage_high"
+
+	AlertStateFiring   = "firing"
+	AlertStateResolved = "resolved"
+)
+
+// AlertRules 为 0 的阈值不检查
+type AlertRules struct {
+	MinDedupRatio   float64
+	MinCacheHitRate float64
+	MaxDiskUsage    float64
+	// DiskPath 是计算磁盘使用率的挂载点,通常为 snapshotter root
+	DiskPath   string
+	WebhookURL string
+	Interval   time.Duration
+}
+
+type Alert struct {
+	Name      string    `json:"name"`
+	State     string    `json:"state"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Message   string    `json:"message"`
+	Since     time.Time `json:"since"`
+}
+
+// Alerter 周期性检查阈值,状态变化时记录事件并调用 webhook
+type Alerter struct {
+	metrics *Metrics
+	rules   AlertRules
+	client  *http.Client
+	mu      sync.RWMutex
+	firing  map[string]*Alert
+}
+
+func NewAlerter(m *Metrics, rules AlertRules) *Alerter {
+	if rules.Interval <= 0 {
+		rules.Interval = time.Minute
+	}
+
+	return &Alerter{
+		metrics: m,
+		rules:   rules,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		firing:  make(map[string]*Alert),
+	}
+}
+
+func (a *Alerter) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.rules.Interval)
+	defer ticker.Stop()
+
+	for {
+		a.Evaluate(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Evaluate 检查一次所有阈值,返回本次状态发生变化的告警
+func (a *Alerter) Evaluate(ctx context.Context) []*Alert {
+	snapshot := a.metrics.GetSnapshot()
+	now := time.Now()
+
+	type check struct {
+		name      string
+		active    bool
+		breached  bool
+		value     float64
+		threshold float64
+		message   string
+	}
+
+	checks := []check{
+		{
+			name:      AlertDedupRatioLow,
+			active:    a.rules.MinDedupRatio > 0 && snapshot.TotalChunks > 0,
+			breached:  snapshot.DedupRatio < a.rules.MinDedupRatio,
+			value:     snapshot.DedupRatio,
+			threshold: a.rules.MinDedupRatio,
+			message:   "dedup ratio below threshold, check chunking configuration",
+		},
+		{
+			name:      AlertCacheHitRateLow,
+			active:    a.rules.MinCacheHitRate > 0 && snapshot.LazyLoadHits+snapshot.LazyLoadMisses > 0,
+			breached:  snapshot.CacheHitRate < a.rules.MinCacheHitRate,
+			value:     snapshot.CacheHitRate,
+			threshold: a.rules.MinCacheHitRate,
+			message:   "cache hit rate below threshold, check prefetch and fscache",
+		},
+	}
+
+	if a.rules.MaxDiskUsage > 0 && a.rules.DiskPath != "" {
+		if usage, err := diskUsagePercent(a.rules.DiskPath); err != nil {
+			log.G(ctx).WithError(err).Debugf("failed to stat %s", a.rules.DiskPath)
+		} else {
+			checks = append(checks, check{
+				name:      AlertDiskUsageHigh,
+				active:    true,
+				breached:  usage > a.rules.MaxDiskUsage,
+				value:     usage,
+				threshold: a.rules.MaxDiskUsage,
+				message:   "disk usage above threshold on " + a.rules.DiskPath,
+			})
+		}
+	}
+
+	var changed []*Alert
+	a.mu.Lock()
+	for _, c := range checks {
+		current, firing := a.firing[c.name]
+		switch {
+		case c.active && c.breached && !firing:
+			alert := &Alert{Name: c.name, State: AlertStateFiring, Value: c.value, Threshold: c.threshold, Message: c.message, Since: now}
+			a.firing[c.name] = alert
+			copied := *alert
+			changed = append(changed, &copied)
+		case c.active && c.breached:
+			current.Value = c.value
+		case firing && (!c.active || !c.breached):
+			delete(a.firing, c.name)
+			resolved := *current
+			resolved.State = AlertStateResolved
+			resolved.Value = c.value
+			resolved.Since = now
+			changed = append(changed, &resolved)
+		}
+	}
+	a.mu.Unlock()
+
+	for _, alert := range changed {
+		if alert.State == AlertStateFiring {
+			log.G(ctx).Warnf("alert %s firing: %s (value=%.2f threshold=%.2f)", alert.Name, alert.Message, alert.Value, alert.Threshold)
+		} else {
+			log.G(ctx).Infof("alert %s resolved (value=%.2f)", alert.Name, alert.Value)
+		}
+		if a.rules.WebhookURL != "" {
+			if err := a.notify(ctx, alert); err != nil {
+				log.G(ctx).WithError(err).Warnf("failed to send alert %s to webhook", alert.Name)
+			}
+		}
+	}
+
+	return changed
+}
+
+// Firing 返回当前触发中的告警,健康检查据此报告 degraded
+func (a *Alerter) Firing() []*Alert {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	alerts := make([]*Alert, 0, len(a.firing))
+	for _, alert := range a.firing {
+		copied := *alert
+		alerts = append(alerts, &copied)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].Name < alerts[j].Name
+	})
+	return alerts
+}
+
+func (a *Alerter) notify(ctx context.Context, alert *Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.rules.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+func diskUsagePercent(path string) (float64, error) {
+	_, percent, err := DiskUsage(path)
+	return percent, err
+}
+
+// DiskUsage 返回 path 所在文件系统已用的字节数和百分比
+func DiskUsage(path string) (int64, float64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	if st.Blocks == 0 {
+		return 0, 0, nil
+	}
+	used := st.Blocks - st.Bfree
+	return int64(used) * st.Bsize, float64(used) / float64(st.Blocks) * 100, nil
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestAlerterTransitions 验证告警在阈值越界时触发、恢复时解除,并各发送一次 webhook
+func TestAlerterTransitions(t *testing.T) {
+	var events []Alert
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert Alert
+		json.NewDecoder(r.Body).Decode(&alert)
+		events = append(events, alert)
+	}))
+	defer server.Close()
+
+	m := NewMetrics()
+	alerter := NewAlerter(m, AlertRules{MinDedupRatio: 20, WebhookURL: server.URL})
+	ctx := context.Background()
+
+	if changed := alerter.Evaluate(ctx); len(changed) != 0 {
+		t.Errorf("no alert expected without chunk stats, got %d", len(changed))
+	}
+
+	m.UpdateChunkStats(100, 95)
+	alerter.Evaluate(ctx)
+	alerter.Evaluate(ctx)
+	if firing := alerter.Firing(); len(firing) != 1 || firing[0].Name != AlertDedupRatioLow {
+		t.Fatalf("expected dedup_ratio_low firing, got %+v", firing)
+	}
+
+	m.UpdateChunkStats(100, 50)
+	alerter.Evaluate(ctx)
+	if firing := alerter.Firing(); len(firing) != 0 {
+		t.Errorf("expected alert resolved, got %+v", firing)
+	}
+
+	if len(events) != 2 || events[0].State != AlertStateFiring || events[1].State != AlertStateResolved {
+		t.Errorf("expected firing and resolved webhook events, got %+v", events)
+	}
+	t.Logf("✓ 去重率告警触发和恢复各通知一次")
+}
@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"sort"
+	"time"
+)
+
+// chunk 存储后端操作
+const (
+	BackendOpRead  = "read"
+	BackendOpWrite = "write"
+)
+
+// BackendUnhealthyAfter 是后端连续失败多少次后被视为不健康
+const BackendUnhealthyAfter = 3
+
+// BackendOp 描述一次 chunk 存储后端操作。NotFound 表示查找未命中,计入未命中而不是错误
+type BackendOp struct {
+	Backend  string
+	Kind     string
+	Op       string
+	Bytes    int64
+	NotFound bool
+	Err      error
+	Duration time.Duration
+}
+
+// BackendStats 是单个 chunk 存储后端(本地 chunk 存储、content store、镜像服务、镜像仓库)的统计。
+// HitRate 和 ErrorRate 为百分比,Healthy 在连续失败达到 BackendUnhealthyAfter 次后为 false
+type BackendStats struct {
+	Backend           string    `json:"backend"`
+	Kind              string    `json:"kind"`
+	Reads             int64     `json:"reads"`
+	Writes            int64     `json:"writes"`
+	Errors            int64     `json:"errors"`
+	ErrorRate         float64   `json:"error_rate"`
+	Hits              int64     `json:"hits"`
+	Misses            int64     `json:"misses"`
+	HitRate           float64   `json:"hit_rate"`
+	BytesRead         int64     `json:"bytes_read"`
+	BytesWritten      int64     `json:"bytes_written"`
+	Healthy           bool      `json:"healthy"`
+	ConsecutiveErrors int64     `json:"consecutive_errors"`
+	LastError         string    `json:"last_error,omitempty"`
+	LastErrorTime     time.Time `json:"last_error_time,omitempty"`
+	LastSuccess       time.Time `json:"last_success,omitempty"`
+}
+
+// ObserveBackendOp 记录一次后端操作的结果、字节数和耗时
+func (m *Metrics) ObserveBackendOp(op BackendOp) {
+	m.ObserveHistogram("backend_op_latency", Labels{"backend": op.Backend, "op": op.Op}, op.Duration)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := m.backendStats(op.Backend, op.Kind)
+	if op.Op == BackendOpWrite {
+		stats.Writes++
+	} else {
+		stats.Reads++
+	}
+
+	switch {
+	case op.Err != nil && !op.NotFound:
+		stats.Errors++
+		stats.ConsecutiveErrors++
+		stats.LastError = op.Err.Error()
+		stats.LastErrorTime = time.Now()
+		return
+	case op.NotFound:
+		stats.Misses++
+	case op.Op == BackendOpRead:
+		stats.Hits++
+	}
+	stats.ConsecutiveErrors = 0
+	stats.LastSuccess = time.Now()
+	stats.addBytes(op.Op, op.Bytes)
+}
+
+// AddBackendBytes 累计流式读写在操作完成后才确定的字节数
+func (m *Metrics) AddBackendBytes(backend, kind, op string, n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.backendStats(backend, kind).addBytes(op, n)
+}
+
+func (s *BackendStats) addBytes(op string, n int64) {
+	if op == BackendOpWrite {
+		s.BytesWritten += n
+	} else {
+		s.BytesRead += n
+	}
+}
+
+func (m *Metrics) backendStats(backend, kind string) *BackendStats {
+	stats, ok := m.backends[backend]
+	if !ok {
+		stats = &BackendStats{Backend: backend, Kind: kind}
+		m.backends[backend] = stats
+	}
+	return stats
+}
+
+// Backends 返回各后端的统计和健康状态,按名称排序
+func (m *Metrics) Backends() []BackendStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.backendSnapshots()
+}
+
+func (m *Metrics) backendSnapshots() []BackendStats {
+	stats := make([]BackendStats, 0, len(m.backends))
+	for _, s := range m.backends {
+		b := *s
+		if ops := b.Reads + b.Writes; ops > 0 {
+			b.ErrorRate = float64(b.Errors) / float64(ops) * 100
+		}
+		if lookups := b.Hits + b.Misses; lookups > 0 {
+			b.HitRate = float64(b.Hits) / float64(lookups) * 100
+		}
+		b.Healthy = b.ConsecutiveErrors < BackendUnhealthyAfter
+		stats = append(stats, b)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Backend < stats[j].Backend })
+	return stats
+}
@@ -0,0 +1,32 @@
+package metrics
+
+// DiskWatchStats 是磁盘空间看门狗的状态:Level 为当前水位等级(0 表示空间充足),
+// FreePercent 和 FreeBytes 是受监控文件系统中可用空间最少的一个,其余为启动以来的累计次数
+type DiskWatchStats struct {
+	Level              int     `json:"level"`
+	LevelName          string  `json:"level_name"`
+	Path               string  `json:"path"`
+	FreePercent        float64 `json:"free_percent"`
+	FreeBytes          int64   `json:"free_bytes"`
+	PrefetchPaused     bool    `json:"prefetch_paused"`
+	VolumesEvicted     int64   `json:"volumes_evicted"`
+	GCRuns             int64   `json:"gc_runs"`
+	ConversionsRefused int64   `json:"conversions_refused"`
+}
+
+// SetDiskWatchCollector 设置每次生成快照时读取看门狗状态的函数,fn 返回 nil 表示看门狗未启用
+func (m *Metrics) SetDiskWatchCollector(fn func() *DiskWatchStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.diskWatch = fn
+}
+
+func (m *Metrics) collectDiskWatch() *DiskWatchStats {
+	m.mu.RLock()
+	fn := m.diskWatch
+	m.mu.RUnlock()
+	if fn == nil {
+		return nil
+	}
+	return fn()
+}
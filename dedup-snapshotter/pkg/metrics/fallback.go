@@ -0,0 +1,41 @@
+package metrics
+
+import "sort"
+
+// MountFallbackStats 是从挂载方式 From 因 Reason 回退到 To 的次数
+type MountFallbackStats struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Reason string `json:"reason"`
+	Count  int64  `json:"count"`
+}
+
+// IncMountFallback 记录一次挂载回退,reason 应为固定的原因代码而不是错误信息
+func (m *Metrics) IncMountFallback(from, to, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := from + "/" + to + "/" + reason
+	stats, ok := m.fallbacks[key]
+	if !ok {
+		stats = &MountFallbackStats{From: from, To: to, Reason: reason}
+		m.fallbacks[key] = stats
+	}
+	stats.Count++
+}
+
+func (m *Metrics) fallbackSnapshots() []MountFallbackStats {
+	stats := make([]MountFallbackStats, 0, len(m.fallbacks))
+	for _, s := range m.fallbacks {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].From != stats[j].From {
+			return stats[i].From < stats[j].From
+		}
+		if stats[i].To != stats[j].To {
+			return stats[i].To < stats[j].To
+		}
+		return stats[i].Reason < stats[j].Reason
+	})
+	return stats
+}
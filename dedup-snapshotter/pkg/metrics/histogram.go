@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+var DefaultLatencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+type Labels map[string]string
+
+// String 按键排序输出 {k=v,...},同一组标签总是得到相同的序列键
+func (l Labels) String() string {
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%s", k, l[k]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+type Histogram struct {
+	buckets []time.Duration
+	counts  []int64
+	count   int64
+	sum     time.Duration
+}
+
+func NewHistogram(buckets []time.Duration) *Histogram {
+	return &Histogram{
+		buckets: buckets,
+		counts:  make([]int64, len(buckets)+1),
+	}
+}
+
+func (h *Histogram) Observe(d time.Duration) {
+	idx := sort.Search(len(h.buckets), func(i int) bool {
+		return d <= h.buckets[i]
+	})
+	h.counts[idx]++
+	h.count++
+	h.sum += d
+}
+
+// quantile 按桶上界估算分位数,落在最后一个桶之外的返回最大桶上界
+func (h *Histogram) quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+
+	rank := int64(q * float64(h.count))
+	var seen int64
+	for i, c := range h.counts {
+		seen += c
+		if seen > rank {
+			if i < len(h.buckets) {
+				return h.buckets[i]
+			}
+			break
+		}
+	}
+	return h.buckets[len(h.buckets)-1]
+}
+
+func (h *Histogram) snapshot(name string, labels Labels) *HistogramSnapshot {
+	buckets := make([]BucketCount, 0, len(h.counts))
+	var cumulative int64
+	for i, c := range h.counts {
+		cumulative += c
+		le := "+Inf"
+		if i < len(h.buckets) {
+			le = h.buckets[i].String()
+		}
+		buckets = append(buckets, BucketCount{UpperBound: le, Count: cumulative})
+	}
+
+	var avg time.Duration
+	if h.count > 0 {
+		avg = h.sum / time.Duration(h.count)
+	}
+
+	return &HistogramSnapshot{
+		Name:    name,
+		Labels:  labels,
+		Count:   h.count,
+		Sum:     h.sum,
+		Avg:     avg,
+		P50:     h.quantile(0.5),
+		P99:     h.quantile(0.99),
+		Buckets: buckets,
+	}
+}
+
+type BucketCount struct {
+	UpperBound string `json:"le"`
+	Count      int64  `json:"count"`
+}
+
+type HistogramSnapshot struct {
+	Name    string        `json:"name"`
+	Labels  Labels        `json:"labels"`
+	Count   int64         `json:"count"`
+	Sum     time.Duration `json:"sum"`
+	Avg     time.Duration `json:"avg"`
+	P50     time.Duration `json:"p50"`
+	P99     time.Duration `json:"p99"`
+	Buckets []BucketCount `json:"buckets"`
+}
+
+func (h *HistogramSnapshot) String() string {
+	return fmt.Sprintf("%s%s: count=%d avg=%v p50<=%v p99<=%v",
+		h.Name, h.Labels, h.Count, h.Avg, h.P50, h.P99)
+}
+
+type labeledHistogram struct {
+	name      string
+	labels    Labels
+	histogram *Histogram
+}
+
+// DepthBucket 把父链深度归到固定区间,避免每个深度都产生一条序列
+func DepthBucket(depth int) string {
+	switch {
+	case depth <= 0:
+		return "0"
+	case depth == 1:
+		return "1"
+	case depth < 5:
+		return "2-4"
+	case depth < 10:
+		return "5-9"
+	case depth < 20:
+		return "10-19"
+	case depth < 30:
+		return "20-29"
+	default:
+		return "30+"
+	}
+}
@@ -0,0 +1,31 @@
+package metrics
+
+// KernelCacheStats 是内核 fscache/cachefiles 的统计,附带用户态处理的 cachefiles 按需读取计数,
+// 用于对照内核与守护进程看到的命中和未命中。Counters 保存内核的全部原始计数,键为 "<文件>.<段>.<计数>",
+// 如 "fscache.IO.rd"
+type KernelCacheStats struct {
+	CacheReads        int64            `json:"cache_reads"`
+	CacheMisses       int64            `json:"cache_misses"`
+	Culled            int64            `json:"culled"`
+	OnDemandReads     int64            `json:"ondemand_reads"`
+	OnDemandReadBytes int64            `json:"ondemand_read_bytes"`
+	Counters          map[string]int64 `json:"counters,omitempty"`
+}
+
+// SetKernelCacheCollector 设置每次生成快照时读取内核 fscache 统计的函数,fn 返回 nil 表示统计不可用
+func (m *Metrics) SetKernelCacheCollector(fn func() *KernelCacheStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.kernelCache = fn
+}
+
+// collectKernelCache 在不持有锁的情况下读取内核统计
+func (m *Metrics) collectKernelCache() *KernelCacheStats {
+	m.mu.RLock()
+	fn := m.kernelCache
+	m.mu.RUnlock()
+	if fn == nil {
+		return nil
+	}
+	return fn()
+}
@@ -0,0 +1,204 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+)
+
+// 按镜像、namespace 和挂载方式切分指标的标签
+const (
+	LabelImage     = "image"
+	LabelNamespace = "namespace"
+	LabelMountType = "mount_type"
+)
+
+// OverflowLabelValue 是指标序列数达到上限后新出现的镜像和 namespace 标签值
+const OverflowLabelValue = "other"
+
+// DefaultMaxSeries 是每个指标默认允许的序列数
+const DefaultMaxSeries = 200
+
+// maxImageLabels 是记录的镜像标签数上限,超过后新的层不再带镜像标签
+const maxImageLabels = 16384
+
+// boundedLabels 是取值不受控的标签,受 SetLabelPolicy 的开关和序列数上限约束
+var boundedLabels = []string{LabelImage, LabelNamespace}
+
+// counterHelp 是带标签计数器的说明,未列出的使用指标名
+var counterHelp = map[string]string{
+	"image_cache_hits":   "Chunk reads served from the local cache, per image.",
+	"image_cache_misses": "Chunk reads fetched from a remote source, per image.",
+	"container_mounts":   "Container root filesystems mounted, per image and mount type.",
+	"rpc_rejected":       "Snapshot RPCs rejected by admission control, per method and reason.",
+}
+
+type labeledCounter struct {
+	name   string
+	labels Labels
+	value  int64
+}
+
+// CounterSnapshot 是一条带标签计数器序列的当前值
+type CounterSnapshot struct {
+	Name   string `json:"name"`
+	Labels Labels `json:"labels"`
+	Value  int64  `json:"value"`
+}
+
+// LabelOverflowStats 是指标 Metric 因序列数达到上限而归入 other 的观测次数
+type LabelOverflowStats struct {
+	Metric string `json:"metric"`
+	Count  int64  `json:"count"`
+}
+
+// SetLabelPolicy 设置镜像和 namespace 标签:enabled 为 false 时去掉这两个标签,只保留全局和挂载方式维度;
+// 每个指标最多 maxSeries 条序列,超过后新的镜像和 namespace 归入 other,已有序列不受影响
+func (m *Metrics) SetLabelPolicy(enabled bool, maxSeries int) {
+	if maxSeries <= 0 {
+		maxSeries = DefaultMaxSeries
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.imageDimensions = enabled
+	m.maxSeries = maxSeries
+}
+
+// SetImageLabels 记录层 id 所属的镜像和 namespace,之后按 id 观测的指标带上这两个标签。
+// 同一层被多个镜像共用时保留第一次记录的镜像
+func (m *Metrics) SetImageLabels(id, image, namespace string) {
+	if id == "" || image == "" {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.imageLabels[id]; ok || len(m.imageLabels) >= maxImageLabels {
+		return
+	}
+	labels := Labels{LabelImage: ImageLabel(image)}
+	if namespace != "" {
+		labels[LabelNamespace] = namespace
+	}
+	m.imageLabels[id] = labels
+}
+
+// ForgetImage 在层删除后丢弃其镜像标签
+func (m *Metrics) ForgetImage(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.imageLabels, id)
+}
+
+// ImageLabels 返回层 id 的镜像和 namespace 标签,未记录时为空
+func (m *Metrics) ImageLabels(id string) Labels {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return mergeLabels(nil, m.imageLabels[id])
+}
+
+// ObserveImageCache 记录层 id 的一次 chunk 读取是否由本地缓存满足
+func (m *Metrics) ObserveImageCache(id string, hit bool) {
+	name := "image_cache_misses"
+	if hit {
+		name = "image_cache_hits"
+	}
+	m.AddCounter(name, m.ImageLabels(id), 1)
+}
+
+// AddCounter 把带标签计数器 name 增加 delta
+func (m *Metrics) AddCounter(name string, labels Labels, delta int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	labels = m.boundLabelsLocked(name, labels, func(key string) bool {
+		_, ok := m.counters[key]
+		return ok
+	})
+	key := name + labels.String()
+	c, ok := m.counters[key]
+	if !ok {
+		c = &labeledCounter{name: name, labels: labels}
+		m.counters[key] = c
+	}
+	c.value += delta
+}
+
+// boundLabelsLocked 返回实际记录的标签:未启用镜像维度时去掉镜像和 namespace 标签;
+// 指标 name 的序列数达到上限后,新序列的镜像和 namespace 归入 OverflowLabelValue。exists 报告序列是否已存在
+func (m *Metrics) boundLabelsLocked(name string, labels Labels, exists func(key string) bool) Labels {
+	bounded := false
+	for _, k := range boundedLabels {
+		if _, ok := labels[k]; ok {
+			bounded = true
+		}
+	}
+	if !bounded {
+		return labels
+	}
+	if !m.imageDimensions {
+		stripped := mergeLabels(nil, labels)
+		for _, k := range boundedLabels {
+			delete(stripped, k)
+		}
+		return stripped
+	}
+	if exists(name + labels.String()) {
+		return labels
+	}
+
+	if m.series[name] >= m.maxSeries {
+		m.overflows[name]++
+		labels = mergeLabels(nil, labels)
+		for _, k := range boundedLabels {
+			if _, ok := labels[k]; ok {
+				labels[k] = OverflowLabelValue
+			}
+		}
+		if exists(name + labels.String()) {
+			return labels
+		}
+	}
+	m.series[name]++
+	return labels
+}
+
+func (m *Metrics) counterSnapshots() []CounterSnapshot {
+	keys := make([]string, 0, len(m.counters))
+	for k := range m.counters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	counters := make([]CounterSnapshot, 0, len(keys))
+	for _, k := range keys {
+		c := m.counters[k]
+		counters = append(counters, CounterSnapshot{Name: c.name, Labels: c.labels, Value: c.value})
+	}
+	return counters
+}
+
+func (m *Metrics) overflowSnapshots() []LabelOverflowStats {
+	stats := make([]LabelOverflowStats, 0, len(m.overflows))
+	for name, n := range m.overflows {
+		stats = append(stats, LabelOverflowStats{Metric: name, Count: n})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Metric < stats[j].Metric })
+	return stats
+}
+
+// ImageLabel 把镜像引用规整为标签值:去掉 docker.io/library/ 前缀,带标签时去掉 digest,
+// 只有 digest 时保留前 12 位,同一镜像的不同写法落到同一条序列
+func ImageLabel(ref string) string {
+	ref = strings.TrimPrefix(ref, "docker.io/library/")
+	ref = strings.TrimPrefix(ref, "docker.io/")
+	name, dgst, hasDigest := strings.Cut(ref, "@")
+	if !hasDigest {
+		return ref
+	}
+	if i := strings.LastIndexByte(name, ':'); i > strings.LastIndexByte(name, '/') {
+		return name
+	}
+	if _, hex, ok := strings.Cut(dgst, ":"); ok && len(hex) > 12 {
+		dgst = dgst[:len(dgst)-len(hex)+12]
+	}
+	return name + "@" + dgst
+}
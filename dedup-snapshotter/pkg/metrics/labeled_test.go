@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLabeledCounters 验证按镜像和 namespace 切分的计数器、序列数上限和关闭镜像维度
+func TestLabeledCounters(t *testing.T) {
+	m := NewMetrics()
+	m.SetLabelPolicy(true, 3)
+	m.SetImageLabels("layer-1", "docker.io/library/nginx:1.25@sha256:0123456789abcdef0123", "k8s.io")
+	m.SetImageLabels("layer-1", "redis:7", "default")
+	m.ObserveImageCache("layer-1", true)
+	m.ObserveImageCache("layer-1", true)
+	m.ObserveImageCache("layer-1", false)
+
+	out := m.GetSnapshot().Text(Labels{"node": "n1"})
+	for _, want := range []string{
+		`dedup_snapshotter_image_cache_hits_total{image="nginx:1.25",namespace="k8s.io",node="n1"} 2`,
+		`dedup_snapshotter_image_cache_misses_total{image="nginx:1.25",namespace="k8s.io",node="n1"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("missing %s in:\n%s", want, out)
+		}
+	}
+	t.Logf("✓ 命中和未命中按镜像 nginx:1.25 计数")
+
+	// 超过上限的新镜像归入 other,已有序列继续累加
+	for i := 0; i < 5; i++ {
+		m.AddCounter("container_mounts", Labels{LabelImage: fmt.Sprintf("app-%d", i), LabelMountType: "fscache"}, 1)
+	}
+	m.AddCounter("container_mounts", Labels{LabelImage: "app-0", LabelMountType: "fscache"}, 1)
+	m.ObserveHistogram("layer_conversion_latency", Labels{LabelImage: "app-9"}, time.Second)
+
+	series := map[string]int64{}
+	snapshot := m.GetSnapshot()
+	for _, c := range snapshot.Counters {
+		if c.Name == "container_mounts" {
+			series[c.Labels[LabelImage]] = c.Value
+		}
+	}
+	if len(series) != 4 || series["app-0"] != 2 || series[OverflowLabelValue] != 2 {
+		t.Fatalf("expected three images and an overflow series, got %v", series)
+	}
+	if len(snapshot.LabelOverflows) != 1 || snapshot.LabelOverflows[0].Count != 2 {
+		t.Fatalf("expected two overflowed observations, got %+v", snapshot.LabelOverflows)
+	}
+	if !strings.Contains(snapshot.Text(nil), `dedup_snapshotter_label_overflows_total{metric="container_mounts"} 2`) {
+		t.Fatal("expected the overflow counter to be exported")
+	}
+	t.Logf("✓ 序列数达到上限后新镜像归入 other")
+
+	// 关闭镜像维度后只保留挂载类型
+	m.SetLabelPolicy(false, 3)
+	m.AddCounter("container_mounts", Labels{LabelImage: "app-7", LabelNamespace: "k8s.io", LabelMountType: "loop"}, 1)
+	found := false
+	for _, c := range m.GetSnapshot().Counters {
+		if c.Name == "container_mounts" && c.Labels.String() == "{mount_type=loop}" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("expected image and namespace labels to be dropped")
+	}
+	t.Logf("✓ 关闭镜像维度后只按挂载类型计数")
+}
+
+// TestImageLabel 验证镜像引用规整为稳定的标签值
+func TestImageLabel(t *testing.T) {
+	for ref, want := range map[string]string{
+		"docker.io/library/nginx:1.25":                  "nginx:1.25",
+		"docker.io/bitnami/redis:7":                     "bitnami/redis:7",
+		"registry:5000/app:v1@sha256:0123456789abcdef0": "registry:5000/app:v1",
+		"registry:5000/app@sha256:0123456789abcdef0":    "registry:5000/app@sha256:0123456789ab",
+		"quay.io/app": "quay.io/app",
+	} {
+		if got := ImageLabel(ref); got != want {
+			t.Errorf("ImageLabel(%q) = %q, want %q", ref, got, want)
+		}
+	}
+}
@@ -3,6 +3,8 @@ package metrics
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
@@ -16,17 +18,66 @@ type Metrics struct {
 	uniqueChunks    int64
 	dedupRatio      float64
 	memoryDeduped   int64
+	memoryReclaimed int64
 	lazyLoadHits    int64
 	lazyLoadMisses  int64
 	mountCount      int64
 	unmountCount    int64
+	chunksHealed    int64
+	chunkHealFailures int64
+	chunkCacheHits    int64
+	chunkCacheMisses  int64
+	chunkCacheEvictions int64
+	chunkCacheBytes   int64
+	chunkCacheEntries int
+	conversionsCollapsed int64
 	buildTime       time.Duration
 	mountTime       time.Duration
+	histograms      map[string]*labeledHistogram
+	// counters 是带标签的计数器,镜像和 namespace 标签受 series、maxSeries 约束,见 labeled.go
+	counters        map[string]*labeledCounter
+	series          map[string]int
+	overflows       map[string]int64
+	maxSeries       int
+	imageDimensions bool
+	imageLabels     map[string]Labels
+	chunkTiers      []ChunkTierStats
+	registries      map[string]*RegistryStats
+	backends        map[string]*BackendStats
+	fallbacks       map[string]*MountFallbackStats
+	kernelCache     func() *KernelCacheStats
+	diskWatch       func() *DiskWatchStats
+}
+
+// ChunkTierStats 是单个 chunk 分层的去重收益
+type ChunkTierStats struct {
+	Tier         string  `json:"tier"`
+	StoredBytes  int64   `json:"stored_bytes"`
+	LogicalBytes int64   `json:"logical_bytes"`
+	DedupRatio   float64 `json:"dedup_ratio"`
+}
+
+// RegistryStats 是对单个镜像仓库或镜像服务的请求统计,Errors 为传输失败、429 和 5xx 响应
+type RegistryStats struct {
+	Registry string `json:"registry"`
+	Requests int64  `json:"requests"`
+	Errors   int64  `json:"errors"`
+	Bytes    int64  `json:"bytes"`
 }
 
 func NewMetrics() *Metrics {
 	return &Metrics{
-		startTime: time.Now(),
+		startTime:  time.Now(),
+		histograms: make(map[string]*labeledHistogram),
+		counters:   make(map[string]*labeledCounter),
+		series:     make(map[string]int),
+		overflows:  make(map[string]int64),
+		maxSeries:  DefaultMaxSeries,
+		imageDimensions: true,
+		imageLabels: make(map[string]Labels),
+		registries: make(map[string]*RegistryStats),
+		backends:   make(map[string]*BackendStats),
+		fallbacks:  make(map[string]*MountFallbackStats),
 	}
 }
 
@@ -52,12 +103,25 @@ func (m *Metrics) UpdateChunkStats(total, unique int64) {
 	}
 }
 
+func (m *Metrics) UpdateChunkTiers(tiers []ChunkTierStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.chunkTiers = append([]ChunkTierStats(nil), tiers...)
+}
+
 func (m *Metrics) UpdateMemoryDeduped(bytes int64) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.memoryDeduped = bytes
 }
 
+// AddMemoryReclaimed 累计冷页主动回收释放的字节数
+func (m *Metrics) AddMemoryReclaimed(bytes int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.memoryReclaimed += bytes
+}
+
 func (m *Metrics) IncLazyLoadHit() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -82,6 +146,45 @@ func (m *Metrics) IncUnmountCount() {
 	m.unmountCount++
 }
 
+func (m *Metrics) IncChunkHealed() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.chunksHealed++
+}
+
+func (m *Metrics) IncChunkHealFailure() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.chunkHealFailures++
+}
+
+// IncConversionCollapsed 记录一次合并到进行中转换的并发层转换
+func (m *Metrics) IncConversionCollapsed() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.conversionsCollapsed++
+}
+
+// ObserveChunkCache 记录一次内存 chunk 缓存查找
+func (m *Metrics) ObserveChunkCache(hit bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if hit {
+		m.chunkCacheHits++
+	} else {
+		m.chunkCacheMisses++
+	}
+}
+
+// UpdateChunkCache 更新内存 chunk 缓存的占用,并累计淘汰的条目数
+func (m *Metrics) UpdateChunkCache(bytes int64, entries int, evicted int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.chunkCacheBytes = bytes
+	m.chunkCacheEntries = entries
+	m.chunkCacheEvictions += evicted
+}
+
 func (m *Metrics) AddBuildTime(duration time.Duration) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -94,7 +197,78 @@ func (m *Metrics) AddMountTime(duration time.Duration) {
 	m.mountTime += duration
 }
 
+func (m *Metrics) ObserveHistogram(name string, labels Labels, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	labels = m.boundLabelsLocked(name, labels, func(key string) bool {
+		_, ok := m.histograms[key]
+		return ok
+	})
+	key := name + labels.String()
+	lh, ok := m.histograms[key]
+	if !ok {
+		lh = &labeledHistogram{
+			name:      name,
+			labels:    labels,
+			histogram: NewHistogram(DefaultLatencyBuckets),
+		}
+		m.histograms[key] = lh
+	}
+	lh.histogram.Observe(duration)
+}
+
+// ObserveOperation 记录快照操作耗时,按父链深度和挂载类型打标签
+func (m *Metrics) ObserveOperation(operation string, depth int, mountType string, duration time.Duration) {
+	m.ObserveHistogram("snapshot_operation_latency", Labels{
+		"operation":  operation,
+		"depth":      DepthBucket(depth),
+		"mount_type": mountType,
+	}, duration)
+}
+
+// ObserveRegistryRequest 记录一次仓库请求的结果和收到响应头的耗时
+func (m *Metrics) ObserveRegistryRequest(registry string, failed bool, duration time.Duration) {
+	m.ObserveHistogram("registry_request_latency", Labels{"registry": registry}, duration)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := m.registryStats(registry)
+	stats.Requests++
+	if failed {
+		stats.Errors++
+	}
+}
+
+// AddRegistryBytes 累计从仓库读取的响应体字节数
+func (m *Metrics) AddRegistryBytes(registry string, n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.registryStats(registry).Bytes += n
+}
+
+func (m *Metrics) registryStats(registry string) *RegistryStats {
+	stats, ok := m.registries[registry]
+	if !ok {
+		stats = &RegistryStats{Registry: registry}
+		m.registries[registry] = stats
+	}
+	return stats
+}
+
+func (m *Metrics) registrySnapshots() []RegistryStats {
+	stats := make([]RegistryStats, 0, len(m.registries))
+	for _, s := range m.registries {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Registry < stats[j].Registry })
+	return stats
+}
+
 func (m *Metrics) GetSnapshot() *MetricsSnapshot {
+	kernelCache := m.collectKernelCache()
+	diskWatch := m.collectDiskWatch()
+
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
@@ -103,6 +277,10 @@ func (m *Metrics) GetSnapshot() *MetricsSnapshot {
 	if m.lazyLoadHits+m.lazyLoadMisses > 0 {
 		cacheHitRate = float64(m.lazyLoadHits) / float64(m.lazyLoadHits+m.lazyLoadMisses) * 100
 	}
+	chunkCacheHitRate := 0.0
+	if m.chunkCacheHits+m.chunkCacheMisses > 0 {
+		chunkCacheHitRate = float64(m.chunkCacheHits) / float64(m.chunkCacheHits+m.chunkCacheMisses) * 100
+	}
 
 	return &MetricsSnapshot{
 		Uptime:         uptime,
@@ -112,14 +290,48 @@ func (m *Metrics) GetSnapshot() *MetricsSnapshot {
 		UniqueChunks:   m.uniqueChunks,
 		DedupRatio:     m.dedupRatio,
 		MemoryDeduped:  m.memoryDeduped,
+		MemoryReclaimed: m.memoryReclaimed,
 		LazyLoadHits:   m.lazyLoadHits,
 		LazyLoadMisses: m.lazyLoadMisses,
 		CacheHitRate:   cacheHitRate,
 		MountCount:     m.mountCount,
 		UnmountCount:   m.unmountCount,
+		ChunksHealed:   m.chunksHealed,
+		ChunkHealFailures: m.chunkHealFailures,
+		ChunkCacheHits:    m.chunkCacheHits,
+		ChunkCacheMisses:  m.chunkCacheMisses,
+		ChunkCacheHitRate: chunkCacheHitRate,
+		ChunkCacheEvictions: m.chunkCacheEvictions,
+		ChunkCacheBytes:   m.chunkCacheBytes,
+		ChunkCacheEntries: m.chunkCacheEntries,
+		ConversionsCollapsed: m.conversionsCollapsed,
 		AvgBuildTime:   m.avgBuildTime(),
 		AvgMountTime:   m.avgMountTime(),
+		Histograms:     m.histogramSnapshots(),
+		Counters:       m.counterSnapshots(),
+		LabelOverflows: m.overflowSnapshots(),
+		ChunkTiers:     append([]ChunkTierStats(nil), m.chunkTiers...),
+		Registries:     m.registrySnapshots(),
+		Backends:       m.backendSnapshots(),
+		MountFallbacks: m.fallbackSnapshots(),
+		KernelCache:    kernelCache,
+		DiskWatch:      diskWatch,
+	}
+}
+
+func (m *Metrics) histogramSnapshots() []*HistogramSnapshot {
+	keys := make([]string, 0, len(m.histograms))
+	for k := range m.histograms {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	snapshots := make([]*HistogramSnapshot, 0, len(keys))
+	for _, k := range keys {
+		lh := m.histograms[k]
+		snapshots = append(snapshots, lh.histogram.snapshot(lh.name, lh.labels))
 	}
+	return snapshots
 }
 
 func (m *Metrics) avgBuildTime() time.Duration {
@@ -147,12 +359,27 @@ func (m *Metrics) Reset() {
 	m.uniqueChunks = 0
 	m.dedupRatio = 0
 	m.memoryDeduped = 0
+	m.memoryReclaimed = 0
 	m.lazyLoadHits = 0
 	m.lazyLoadMisses = 0
 	m.mountCount = 0
 	m.unmountCount = 0
+	m.chunksHealed = 0
+	m.chunkHealFailures = 0
+	m.chunkCacheHits = 0
+	m.chunkCacheMisses = 0
+	m.chunkCacheEvictions = 0
+	m.conversionsCollapsed = 0
 	m.buildTime = 0
 	m.mountTime = 0
+	m.histograms = make(map[string]*labeledHistogram)
+	m.counters = make(map[string]*labeledCounter)
+	m.series = make(map[string]int)
+	m.overflows = make(map[string]int64)
+	m.chunkTiers = nil
+	m.registries = make(map[string]*RegistryStats)
+	m.backends = make(map[string]*BackendStats)
+	m.fallbacks = make(map[string]*MountFallbackStats)
 }
 
 type MetricsSnapshot struct {
@@ -163,17 +390,36 @@ type MetricsSnapshot struct {
 	UniqueChunks   int64         `json:"unique_chunks"`
 	DedupRatio     float64       `json:"dedup_ratio"`
 	MemoryDeduped  int64         `json:"memory_deduped_bytes"`
+	MemoryReclaimed int64        `json:"memory_reclaimed_bytes"`
 	LazyLoadHits   int64         `json:"lazy_load_hits"`
 	LazyLoadMisses int64         `json:"lazy_load_misses"`
 	CacheHitRate   float64       `json:"cache_hit_rate"`
 	MountCount     int64         `json:"mount_count"`
 	UnmountCount   int64         `json:"unmount_count"`
+	ChunksHealed   int64         `json:"chunks_healed"`
+	ChunkHealFailures int64      `json:"chunk_heal_failures"`
+	ChunkCacheHits    int64      `json:"chunk_cache_hits"`
+	ChunkCacheMisses  int64      `json:"chunk_cache_misses"`
+	ChunkCacheHitRate float64    `json:"chunk_cache_hit_rate"`
+	ChunkCacheEvictions int64    `json:"chunk_cache_evictions"`
+	ChunkCacheBytes   int64      `json:"chunk_cache_bytes"`
+	ChunkCacheEntries int        `json:"chunk_cache_entries"`
+	ConversionsCollapsed int64   `json:"conversions_collapsed"`
 	AvgBuildTime   time.Duration `json:"avg_build_time"`
 	AvgMountTime   time.Duration `json:"avg_mount_time"`
+	Histograms     []*HistogramSnapshot `json:"histograms,omitempty"`
+	Counters       []CounterSnapshot    `json:"counters,omitempty"`
+	LabelOverflows []LabelOverflowStats `json:"label_overflows,omitempty"`
+	ChunkTiers     []ChunkTierStats     `json:"chunk_tiers,omitempty"`
+	Registries     []RegistryStats      `json:"registries,omitempty"`
+	Backends       []BackendStats       `json:"backends,omitempty"`
+	MountFallbacks []MountFallbackStats `json:"mount_fallbacks,omitempty"`
+	KernelCache    *KernelCacheStats    `json:"kernel_cache,omitempty"`
+	DiskWatch      *DiskWatchStats      `json:"disk_watch,omitempty"`
 }
 
 func (s *MetricsSnapshot) String() string {
-	return fmt.Sprintf(`Metrics:
+	out := fmt.Sprintf(`Metrics:
   Uptime: %v
   Snapshots: %d
   Images: %d
@@ -181,11 +427,16 @@ func (s *MetricsSnapshot) String() string {
   Unique Chunks: %d
   Dedup Ratio: %.2f%%
   Memory Deduped: %s
+  Memory Reclaimed: %s
   Lazy Load Hits: %d
   Lazy Load Misses: %d
   Cache Hit Rate: %.2f%%
   Mounts: %d
   Unmounts: %d
+  Chunks Healed: %d
+  Chunk Heal Failures: %d
+  Chunk Cache: %s in %d entries, %.2f%% hit rate
+  Conversions Collapsed: %d
   Avg Build Time: %v
   Avg Mount Time: %v`,
 		s.Uptime,
@@ -195,14 +446,50 @@ func (s *MetricsSnapshot) String() string {
 		s.UniqueChunks,
 		s.DedupRatio,
 		formatBytes(s.MemoryDeduped),
+		formatBytes(s.MemoryReclaimed),
 		s.LazyLoadHits,
 		s.LazyLoadMisses,
 		s.CacheHitRate,
 		s.MountCount,
 		s.UnmountCount,
+		s.ChunksHealed,
+		s.ChunkHealFailures,
+		formatBytes(s.ChunkCacheBytes),
+		s.ChunkCacheEntries,
+		s.ChunkCacheHitRate,
+		s.ConversionsCollapsed,
 		s.AvgBuildTime,
 		s.AvgMountTime,
 	)
+
+	if len(s.Histograms) > 0 {
+		lines := make([]string, 0, len(s.Histograms))
+		for _, h := range s.Histograms {
+			lines = append(lines, "    "+h.String())
+		}
+		out += "\n  Latency Histograms:\n" + strings.Join(lines, "\n")
+	}
+
+	if len(s.ChunkTiers) > 0 {
+		lines := make([]string, 0, len(s.ChunkTiers))
+		for _, t := range s.ChunkTiers {
+			lines = append(lines, fmt.Sprintf("    %s: stored %s of %s (%.2f%% saved)",
+				t.Tier, formatBytes(t.StoredBytes), formatBytes(t.LogicalBytes), t.DedupRatio))
+		}
+		out += "\n  Chunk Tiers:\n" + strings.Join(lines, "\n")
+	}
+
+	if k := s.KernelCache; k != nil {
+		out += fmt.Sprintf("\n  Kernel Cache: %d reads, %d misses, %d culled, %d on-demand reads (%s)",
+			k.CacheReads, k.CacheMisses, k.Culled, k.OnDemandReads, formatBytes(k.OnDemandReadBytes))
+	}
+
+	if w := s.DiskWatch; w != nil {
+		out += fmt.Sprintf("\n  Disk Watch: %s, %.1f%% free on %s (%s)",
+			w.LevelName, w.FreePercent, w.Path, formatBytes(w.FreeBytes))
+	}
+
+	return out
 }
 
 func (s *MetricsSnapshot) JSON() (string, error) {
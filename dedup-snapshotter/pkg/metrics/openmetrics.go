@@ -0,0 +1,305 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	// TextContentType 是 pushgateway 接受的 Prometheus 文本格式
+	TextContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+	metricPrefix = "dedup_snapshotter_"
+)
+
+type sample struct {
+	name   string
+	labels Labels
+	value  float64
+}
+
+type family struct {
+	name    string
+	typ     string
+	help    string
+	samples []sample
+}
+
+// families 把快照展开为指标族,extra 标签(node/cluster 等)附加到每个样本上
+func (s *MetricsSnapshot) families(extra Labels) []family {
+	gauge := func(name, help string, value float64) family {
+		return family{
+			name:    metricPrefix + name,
+			typ:     "gauge",
+			help:    help,
+			samples: []sample{{name: metricPrefix + name, labels: extra, value: value}},
+		}
+	}
+	counter := func(name, help string, value int64) family {
+		return family{
+			name:    metricPrefix + name,
+			typ:     "counter",
+			help:    help,
+			samples: []sample{{name: metricPrefix + name + "_total", labels: extra, value: float64(value)}},
+		}
+	}
+
+	families := []family{
+		gauge("uptime_seconds", "Time since the snapshotter started.", s.Uptime.Seconds()),
+		counter("snapshots", "Snapshots created.", s.SnapshotCount),
+		counter("images", "EROFS images built.", s.ImageCount),
+		gauge("chunks", "Chunks referenced by the dedup store.", float64(s.TotalChunks)),
+		gauge("unique_chunks", "Unique chunks stored after deduplication.", float64(s.UniqueChunks)),
+		gauge("dedup_ratio", "Percentage of chunks removed by deduplication.", s.DedupRatio),
+		gauge("memory_deduped_bytes", "Memory saved by KSM page merging.", float64(s.MemoryDeduped)),
+		counter("memory_reclaimed_bytes", "Page cache of cold image pages reclaimed by madvise.", s.MemoryReclaimed),
+		counter("lazy_load_hits", "Lazy loads served from the local cache.", s.LazyLoadHits),
+		counter("lazy_load_misses", "Lazy loads fetched from a remote source.", s.LazyLoadMisses),
+		counter("mounts", "Mounts performed.", s.MountCount),
+		counter("unmounts", "Unmounts performed.", s.UnmountCount),
+		counter("chunks_healed", "Corrupt chunks repaired during reconstruction.", s.ChunksHealed),
+		counter("chunk_heal_failures", "Corrupt chunks that could not be repaired.", s.ChunkHealFailures),
+		counter("chunk_cache_hits", "Chunk reads served from the in-memory chunk cache.", s.ChunkCacheHits),
+		counter("chunk_cache_misses", "Chunk reads not found in the in-memory chunk cache.", s.ChunkCacheMisses),
+		counter("chunk_cache_evictions", "Chunks evicted from the in-memory chunk cache.", s.ChunkCacheEvictions),
+		gauge("chunk_cache_bytes", "Bytes held by the in-memory chunk cache.", float64(s.ChunkCacheBytes)),
+		gauge("chunk_cache_entries", "Chunks held by the in-memory chunk cache.", float64(s.ChunkCacheEntries)),
+		counter("conversions_collapsed", "Concurrent layer conversions that waited on one already in progress.", s.ConversionsCollapsed),
+		gauge("avg_build_seconds", "Average EROFS image build time.", s.AvgBuildTime.Seconds()),
+		gauge("avg_mount_seconds", "Average mount time.", s.AvgMountTime.Seconds()),
+	}
+
+	if len(s.ChunkTiers) > 0 {
+		stored := family{name: metricPrefix + "chunk_tier_stored_bytes", typ: "gauge", help: "Bytes stored per chunk tier after deduplication."}
+		logical := family{name: metricPrefix + "chunk_tier_logical_bytes", typ: "gauge", help: "Bytes referenced per chunk tier before deduplication."}
+		for _, t := range s.ChunkTiers {
+			labels := mergeLabels(extra, Labels{"tier": t.Tier})
+			stored.samples = append(stored.samples, sample{name: stored.name, labels: labels, value: float64(t.StoredBytes)})
+			logical.samples = append(logical.samples, sample{name: logical.name, labels: labels, value: float64(t.LogicalBytes)})
+		}
+		families = append(families, stored, logical)
+	}
+
+	if len(s.Registries) > 0 {
+		requests := family{name: metricPrefix + "registry_requests", typ: "counter", help: "Requests sent to each registry or mirror."}
+		errors := family{name: metricPrefix + "registry_request_errors", typ: "counter", help: "Registry requests that failed or returned 429/5xx."}
+		received := family{name: metricPrefix + "registry_received_bytes", typ: "counter", help: "Response bytes read from each registry or mirror."}
+		for _, r := range s.Registries {
+			labels := mergeLabels(extra, Labels{"registry": r.Registry})
+			requests.samples = append(requests.samples, sample{name: requests.name + "_total", labels: labels, value: float64(r.Requests)})
+			errors.samples = append(errors.samples, sample{name: errors.name + "_total", labels: labels, value: float64(r.Errors)})
+			received.samples = append(received.samples, sample{name: received.name + "_total", labels: labels, value: float64(r.Bytes)})
+		}
+		families = append(families, requests, errors, received)
+	}
+
+	if len(s.Backends) > 0 {
+		ops := family{name: metricPrefix + "backend_ops", typ: "counter", help: "Chunk store operations per backend."}
+		errors := family{name: metricPrefix + "backend_errors", typ: "counter", help: "Failed chunk store operations per backend."}
+		hits := family{name: metricPrefix + "backend_hits", typ: "counter", help: "Chunk lookups found in each backend."}
+		misses := family{name: metricPrefix + "backend_misses", typ: "counter", help: "Chunk lookups not found in each backend."}
+		bytes := family{name: metricPrefix + "backend_bytes", typ: "counter", help: "Bytes read from or written to each backend."}
+		healthy := family{name: metricPrefix + "backend_healthy", typ: "gauge", help: "Whether each backend is healthy (1) or failing (0)."}
+		for _, b := range s.Backends {
+			labels := mergeLabels(extra, Labels{"backend": b.Backend, "kind": b.Kind})
+			ops.samples = append(ops.samples,
+				sample{name: ops.name + "_total", labels: mergeLabels(labels, Labels{"op": "read"}), value: float64(b.Reads)},
+				sample{name: ops.name + "_total", labels: mergeLabels(labels, Labels{"op": "write"}), value: float64(b.Writes)})
+			errors.samples = append(errors.samples, sample{name: errors.name + "_total", labels: labels, value: float64(b.Errors)})
+			hits.samples = append(hits.samples, sample{name: hits.name + "_total", labels: labels, value: float64(b.Hits)})
+			misses.samples = append(misses.samples, sample{name: misses.name + "_total", labels: labels, value: float64(b.Misses)})
+			bytes.samples = append(bytes.samples,
+				sample{name: bytes.name + "_total", labels: mergeLabels(labels, Labels{"op": "read"}), value: float64(b.BytesRead)},
+				sample{name: bytes.name + "_total", labels: mergeLabels(labels, Labels{"op": "write"}), value: float64(b.BytesWritten)})
+			up := 0.0
+			if b.Healthy {
+				up = 1
+			}
+			healthy.samples = append(healthy.samples, sample{name: healthy.name, labels: labels, value: up})
+		}
+		families = append(families, ops, errors, hits, misses, bytes, healthy)
+	}
+
+	if len(s.MountFallbacks) > 0 {
+		fallbacks := family{name: metricPrefix + "mount_fallbacks", typ: "counter", help: "Layer mounts that fell back to another mount type."}
+		for _, f := range s.MountFallbacks {
+			labels := mergeLabels(extra, Labels{"from": f.From, "to": f.To, "reason": f.Reason})
+			fallbacks.samples = append(fallbacks.samples, sample{name: fallbacks.name + "_total", labels: labels, value: float64(f.Count)})
+		}
+		families = append(families, fallbacks)
+	}
+
+	if w := s.DiskWatch; w != nil {
+		families = append(families,
+			gauge("disk_watch_level", "Disk space watchdog level, 0 when free space is above all thresholds.", float64(w.Level)),
+			gauge("disk_free_percent", "Free space on the fullest store filesystem.", w.FreePercent),
+			gauge("disk_free_bytes", "Free bytes on the fullest store filesystem.", float64(w.FreeBytes)),
+			counter("disk_watch_volumes_evicted", "Cold fscache volumes emptied to free disk space.", w.VolumesEvicted),
+			counter("disk_watch_gc_runs", "Volume GC runs triggered by low disk space.", w.GCRuns),
+			counter("disk_watch_conversions_refused", "Conversions refused because the store filesystem was nearly full.", w.ConversionsRefused))
+	}
+
+	if k := s.KernelCache; k != nil {
+		families = append(families,
+			counter("kernel_cache_reads", "Reads served from the cache by kernel fscache.", k.CacheReads),
+			counter("kernel_cache_misses", "Reads kernel fscache could not serve from the cache.", k.CacheMisses),
+			counter("kernel_culled", "Cache objects culled by cachefiles to free space.", k.Culled),
+			counter("ondemand_reads", "Cachefiles on-demand read requests handled by the daemon.", k.OnDemandReads),
+			counter("ondemand_read_bytes", "Bytes requested by cachefiles on-demand reads.", k.OnDemandReadBytes))
+		if len(k.Counters) > 0 {
+			names := make([]string, 0, len(k.Counters))
+			for name := range k.Counters {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			raw := family{name: metricPrefix + "kernel_fscache_stat", typ: "gauge", help: "Raw kernel fscache and netfs statistics counters."}
+			for _, name := range names {
+				raw.samples = append(raw.samples, sample{name: raw.name, labels: mergeLabels(extra, Labels{"stat": name}), value: float64(k.Counters[name])})
+			}
+			families = append(families, raw)
+		}
+	}
+
+	counters := make(map[string]*family)
+	var counterOrder []string
+	for _, c := range s.Counters {
+		name := metricPrefix + c.Name
+		f, ok := counters[name]
+		if !ok {
+			help := counterHelp[c.Name]
+			if help == "" {
+				help = c.Name + "."
+			}
+			f = &family{name: name, typ: "counter", help: help}
+			counters[name] = f
+			counterOrder = append(counterOrder, name)
+		}
+		f.samples = append(f.samples, sample{name: name + "_total", labels: mergeLabels(extra, c.Labels), value: float64(c.Value)})
+	}
+	for _, name := range counterOrder {
+		families = append(families, *counters[name])
+	}
+	if len(s.LabelOverflows) > 0 {
+		overflows := family{name: metricPrefix + "label_overflows", typ: "counter", help: "Observations whose image and namespace labels were folded into \"other\" by the series limit."}
+		for _, o := range s.LabelOverflows {
+			overflows.samples = append(overflows.samples, sample{name: overflows.name + "_total", labels: mergeLabels(extra, Labels{"metric": o.Metric}), value: float64(o.Count)})
+		}
+		families = append(families, overflows)
+	}
+
+	histograms := make(map[string]*family)
+	var order []string
+	for _, h := range s.Histograms {
+		name := metricPrefix + h.Name + "_seconds"
+		f, ok := histograms[name]
+		if !ok {
+			f = &family{name: name, typ: "histogram", help: "Latency of " + h.Name + "."}
+			histograms[name] = f
+			order = append(order, name)
+		}
+		f.samples = append(f.samples, histogramSamples(name, h, extra)...)
+	}
+	for _, name := range order {
+		families = append(families, *histograms[name])
+	}
+
+	return families
+}
+
+func histogramSamples(name string, h *HistogramSnapshot, extra Labels) []sample {
+	labels := mergeLabels(extra, h.Labels)
+
+	samples := make([]sample, 0, len(h.Buckets)+2)
+	for _, b := range h.Buckets {
+		le := b.UpperBound
+		if le != "+Inf" {
+			if d, err := time.ParseDuration(le); err == nil {
+				le = formatFloat(d.Seconds())
+			}
+		}
+		samples = append(samples, sample{
+			name:   name + "_bucket",
+			labels: mergeLabels(labels, Labels{"le": le}),
+			value:  float64(b.Count),
+		})
+	}
+	samples = append(samples,
+		sample{name: name + "_count", labels: labels, value: float64(h.Count)},
+		sample{name: name + "_sum", labels: labels, value: h.Sum.Seconds()},
+	)
+	return samples
+}
+
+// OpenMetrics 以 OpenMetrics 文本格式输出快照
+func (s *MetricsSnapshot) OpenMetrics(extra Labels) string {
+	return s.exposition(extra, true)
+}
+
+// Text 以 Prometheus 0.0.4 文本格式输出快照,counter 族名带 _total 后缀
+func (s *MetricsSnapshot) Text(extra Labels) string {
+	return s.exposition(extra, false)
+}
+
+func (s *MetricsSnapshot) exposition(extra Labels, openMetrics bool) string {
+	var b strings.Builder
+	for _, f := range s.families(extra) {
+		name := f.name
+		if !openMetrics && f.typ == "counter" {
+			name += "_total"
+		}
+		fmt.Fprintf(&b, "# TYPE %s %s\n", name, f.typ)
+		fmt.Fprintf(&b, "# HELP %s %s\n", name, f.help)
+		for _, smp := range f.samples {
+			b.WriteString(smp.name)
+			b.WriteString(formatLabels(smp.labels))
+			b.WriteByte(' ')
+			b.WriteString(formatFloat(smp.value))
+			b.WriteByte('\n')
+		}
+	}
+	if openMetrics {
+		b.WriteString("# EOF\n")
+	}
+	return b.String()
+}
+
+func mergeLabels(base, extra Labels) Labels {
+	merged := make(Labels, len(base)+len(extra))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range extra {
+		merged[k] = v
+	}
+	return merged
+}
+
+func formatLabels(labels Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%q", k, labels[k]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,184 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/klauspost/compress/s2"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	PushModePushgateway = "pushgateway"
+	PushModeRemoteWrite = "remote_write"
+)
+
+// PushConfig 描述推送目标;Labels 中的 node/cluster 等在 pushgateway 模式下作为分组键
+type PushConfig struct {
+	Mode     string
+	URL      string
+	Job      string
+	Interval time.Duration
+	Labels   Labels
+}
+
+// Pusher 为无法被抓取的边缘节点周期性推送指标快照
+type Pusher struct {
+	metrics *Metrics
+	config  PushConfig
+	client  *http.Client
+}
+
+func NewPusher(m *Metrics, cfg PushConfig) (*Pusher, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("push url is required")
+	}
+	if cfg.Mode != PushModePushgateway && cfg.Mode != PushModeRemoteWrite {
+		return nil, fmt.Errorf("unknown push mode %q", cfg.Mode)
+	}
+	if cfg.Job == "" {
+		cfg.Job = "dedup-snapshotter"
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Minute
+	}
+
+	return &Pusher{
+		metrics: m,
+		config:  cfg,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Run 按间隔推送直到 ctx 取消,推送失败只记录日志,下一轮重试
+func (p *Pusher) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := p.Push(ctx); err != nil {
+				log.G(ctx).WithError(err).Warnf("failed to push metrics to %s", p.config.URL)
+			}
+		}
+	}
+}
+
+func (p *Pusher) Push(ctx context.Context) error {
+	snapshot := p.metrics.GetSnapshot()
+
+	var req *http.Request
+	var err error
+	switch p.config.Mode {
+	case PushModePushgateway:
+		req, err = p.pushgatewayRequest(ctx, snapshot)
+	default:
+		req, err = p.remoteWriteRequest(ctx, snapshot)
+	}
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("push rejected: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// pushgatewayRequest 以 PUT /metrics/job/<job>/<label>/<value>... 替换整个分组
+func (p *Pusher) pushgatewayRequest(ctx context.Context, snapshot *MetricsSnapshot) (*http.Request, error) {
+	path := "/metrics/job/" + url.PathEscape(p.config.Job)
+
+	keys := make([]string, 0, len(p.config.Labels))
+	for k := range p.config.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		path += "/" + url.PathEscape(k) + "/" + url.PathEscape(p.config.Labels[k])
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut,
+		strings.TrimSuffix(p.config.URL, "/")+path, strings.NewReader(snapshot.Text(nil)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", TextContentType)
+	return req, nil
+}
+
+func (p *Pusher) remoteWriteRequest(ctx context.Context, snapshot *MetricsSnapshot) (*http.Request, error) {
+	labels := mergeLabels(p.config.Labels, Labels{"job": p.config.Job})
+	body := s2.EncodeSnappy(nil, encodeWriteRequest(snapshot.families(labels), time.Now()))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	return req, nil
+}
+
+// encodeWriteRequest 手工编码 prometheus.WriteRequest:
+// WriteRequest{timeseries=1}, TimeSeries{labels=1, samples=2}, Label{name=1, value=2}, Sample{value=1, timestamp=2}
+func encodeWriteRequest(families []family, now time.Time) []byte {
+	timestamp := now.UnixMilli()
+
+	var buf []byte
+	for _, f := range families {
+		for _, smp := range f.samples {
+			labels := mergeLabels(smp.labels, Labels{"__name__": smp.name})
+			keys := make([]string, 0, len(labels))
+			for k := range labels {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+
+			var series []byte
+			for _, k := range keys {
+				var label []byte
+				label = protowire.AppendTag(label, 1, protowire.BytesType)
+				label = protowire.AppendString(label, k)
+				label = protowire.AppendTag(label, 2, protowire.BytesType)
+				label = protowire.AppendString(label, labels[k])
+
+				series = protowire.AppendTag(series, 1, protowire.BytesType)
+				series = protowire.AppendBytes(series, label)
+			}
+
+			var s []byte
+			s = protowire.AppendTag(s, 1, protowire.Fixed64Type)
+			s = protowire.AppendFixed64(s, math.Float64bits(smp.value))
+			s = protowire.AppendTag(s, 2, protowire.VarintType)
+			s = protowire.AppendVarint(s, uint64(timestamp))
+
+			series = protowire.AppendTag(series, 2, protowire.BytesType)
+			series = protowire.AppendBytes(series, s)
+
+			buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+			buf = protowire.AppendBytes(buf, series)
+		}
+	}
+	return buf
+}
@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+)
+
+// TestPushModes 验证 pushgateway 分组路径和 remote-write 编码
+func TestPushModes(t *testing.T) {
+	m := NewMetrics()
+	m.IncSnapshotCount()
+	m.ObserveOperation("mount", 3, "fscache", 20*time.Millisecond)
+
+	var gotPath, gotMethod, gotEncoding string
+	var gotBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotMethod, gotEncoding = r.URL.Path, r.Method, r.Header.Get("Content-Encoding")
+		gotBody, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	labels := Labels{"node": "edge-1", "cluster": "prod"}
+
+	pusher, err := NewPusher(m, PushConfig{Mode: PushModePushgateway, URL: server.URL, Labels: labels})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pusher.Push(context.Background()); err != nil {
+		t.Fatalf("pushgateway push failed: %v", err)
+	}
+	if gotMethod != http.MethodPut || gotPath != "/metrics/job/dedup-snapshotter/cluster/prod/node/edge-1" {
+		t.Errorf("unexpected pushgateway request %s %s", gotMethod, gotPath)
+	}
+	if !strings.Contains(string(gotBody), "dedup_snapshotter_snapshots_total 1") {
+		t.Errorf("pushgateway body missing snapshot counter:\n%s", gotBody)
+	}
+	if !strings.Contains(string(gotBody), `dedup_snapshotter_snapshot_operation_latency_seconds_bucket{depth="2-4",le="0.025",mount_type="fscache",operation="mount"} 1`) {
+		t.Errorf("pushgateway body missing histogram bucket:\n%s", gotBody)
+	}
+	t.Logf("✓ pushgateway 推送到分组 %s", gotPath)
+
+	pusher, err = NewPusher(m, PushConfig{Mode: PushModeRemoteWrite, URL: server.URL + "/api/v1/write", Labels: labels})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pusher.Push(context.Background()); err != nil {
+		t.Fatalf("remote write push failed: %v", err)
+	}
+	if gotEncoding != "snappy" {
+		t.Errorf("expected snappy encoding, got %q", gotEncoding)
+	}
+	decoded, err := s2.Decode(nil, gotBody)
+	if err != nil {
+		t.Fatalf("failed to decode snappy body: %v", err)
+	}
+	for _, want := range []string{"__name__", "dedup_snapshotter_snapshots_total", "edge-1", "prod"} {
+		if !bytes.Contains(decoded, []byte(want)) {
+			t.Errorf("remote write body missing %q", want)
+		}
+	}
+	t.Logf("✓ remote-write 推送 %d 字节", len(decoded))
+}
@@ -0,0 +1,187 @@
+// Package mirror 以只读 HTTP 服务提供本机 root 中的 chunk、EROFS 镜像和 containerd 已下载的层 blob,
+// 让 CI 缓存主机这类大容量节点充当集群的第一级 chunk 来源,而不必运行快照服务。
+//
+// 接口:
+//   - /v2/<name>/blobs/<digest>:containerd content store 中的层 blob,支持 Range,
+//     路径与镜像仓库相同,节点上的 RegistryFetcher 可以直接指向镜像服务
+//   - /chunks/<hash>:EROFS chunk(erofs-chunks)或存储 chunk(chunks)
+//   - /images/<key>.erofs 及其 .sig 签名
+//   - /manifests/<layer>.manifest
+//
+// 服务只读取文件,不打开索引数据库,也不持有存储锁,可以与同一 root 上的快照服务共存
+package mirror
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/erofs"
+	"github.com/opencloudos/dedup-snapshotter/pkg/signing"
+	"github.com/opencontainers/go-digest"
+)
+
+// chunkDirs 是按哈希查找 chunk 的目录,依次尝试
+var chunkDirs = []string{"erofs-chunks", "chunks"}
+
+// validName 限制文件名中的字符,拒绝路径穿越
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:-]*$`)
+
+type Server struct {
+	root        string
+	contentRoot string
+	server      *http.Server
+
+	requests  atomic.Int64
+	bytesSent atomic.Int64
+}
+
+// Stats 是镜像服务自启动以来的请求统计
+type Stats struct {
+	Requests  int64 `json:"requests"`
+	BytesSent int64 `json:"bytes_sent"`
+}
+
+func NewServer(root, contentRoot string) (*Server, error) {
+	if _, err := os.Stat(root); err != nil {
+		return nil, err
+	}
+	return &Server{root: root, contentRoot: contentRoot}, nil
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/", s.handleBlob)
+	mux.HandleFunc("/chunks/", s.handleChunk)
+	mux.HandleFunc("/images/", s.handleImage)
+	mux.HandleFunc("/manifests/", s.handleManifest)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return mux
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	s.server = &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	log.L.Infof("serving read-only mirror of %s on %s", s.root, addr)
+	return s.server.ListenAndServe()
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.server == nil {
+		return nil
+	}
+	return s.server.Shutdown(ctx)
+}
+
+func (s *Server) Stats() Stats {
+	return Stats{Requests: s.requests.Load(), BytesSent: s.bytesSent.Load()}
+}
+
+// handleBlob 处理 /v2/<name>/blobs/<digest>,name 不参与查找,content store 按 digest 寻址
+func (s *Server) handleBlob(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/v2/" {
+		// 镜像仓库 API 的版本探测
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	i := strings.LastIndex(r.URL.Path, "/blobs/")
+	if i < 0 || s.contentRoot == "" {
+		http.NotFound(w, r)
+		return
+	}
+	dgst, err := digest.Parse(r.URL.Path[i+len("/blobs/"):])
+	if err != nil {
+		http.Error(w, "invalid digest", http.StatusBadRequest)
+		return
+	}
+	s.serveFile(w, r, filepath.Join(s.contentRoot, "blobs", dgst.Algorithm().String(), dgst.Encoded()))
+}
+
+func (s *Server) handleChunk(w http.ResponseWriter, r *http.Request) {
+	hash := strings.TrimPrefix(r.URL.Path, "/chunks/")
+	if !validName.MatchString(hash) {
+		http.Error(w, "invalid chunk hash", http.StatusBadRequest)
+		return
+	}
+	for _, dir := range chunkDirs {
+		path := filepath.Join(s.root, dir, hash)
+		if _, err := os.Stat(path); err == nil {
+			s.serveFile(w, r, path)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+func (s *Server) handleImage(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/images/")
+	if !validName.MatchString(name) ||
+		!(strings.HasSuffix(name, erofs.ErofsImageExt) || strings.HasSuffix(name, erofs.ErofsImageExt+signing.SignatureExt)) {
+		http.NotFound(w, r)
+		return
+	}
+	s.serveFile(w, r, filepath.Join(s.root, "images", name))
+}
+
+func (s *Server) handleManifest(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/manifests/")
+	if !validName.MatchString(name) {
+		http.NotFound(w, r)
+		return
+	}
+	s.serveFile(w, r, filepath.Join(s.root, "manifests", name))
+}
+
+// serveFile 只读地返回文件,http.ServeContent 处理 Range、HEAD 和 If-Modified-Since
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, path string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+		log.L.WithError(err).Warnf("mirror failed to open %s", path)
+		http.Error(w, "failed to open file", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.requests.Add(1)
+	cw := &countingWriter{ResponseWriter: w}
+	http.ServeContent(cw, r, filepath.Base(path), info.ModTime(), f)
+	s.bytesSent.Add(cw.n)
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,85 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/fscache"
+	"github.com/opencontainers/go-digest"
+)
+
+func writeFile(t *testing.T, path string, data []byte) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestMirrorServesFetchers 验证节点侧的 MirrorFetcher 可以按层偏移和按哈希从镜像服务读取数据
+func TestMirrorServesFetchers(t *testing.T) {
+	root := t.TempDir()
+	contentRoot := t.TempDir()
+
+	layer := []byte("0123456789abcdef")
+	dgst := digest.FromBytes(layer)
+	writeFile(t, filepath.Join(contentRoot, "blobs", "sha256", dgst.Encoded()), layer)
+	writeFile(t, filepath.Join(root, "erofs-chunks", "abc123"), []byte("chunk"))
+	writeFile(t, filepath.Join(root, "images", "img.erofs"), []byte("erofs"))
+	writeFile(t, filepath.Join(root, "index.db"), []byte("private"))
+
+	server, err := NewServer(root, contentRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	ctx := context.Background()
+	fetcher := fscache.NewMirrorFetcher(ts.URL+"/", ts.Client())
+
+	data, err := fetcher.Fetch(ctx, "library/app", dgst.String(), 4, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "456789" {
+		t.Errorf("unexpected range data %q", data)
+	}
+
+	if data, err := fetcher.FetchChunk(ctx, "abc123"); err != nil || string(data) != "chunk" {
+		t.Errorf("unexpected chunk %q, %v", data, err)
+	}
+	if _, err := fetcher.FetchChunk(ctx, "missing"); !errors.Is(err, fscache.ErrBlobNotFound) {
+		t.Errorf("expected ErrBlobNotFound, got %v", err)
+	}
+
+	// ServeMux 会把 .. 清理并重定向,处理函数另外拒绝非法名称和非镜像文件
+	for path, want := range map[string]int{
+		"/images/img.erofs":        http.StatusOK,
+		"/images/index.db":         http.StatusNotFound,
+		"/chunks/.hidden":          http.StatusBadRequest,
+		"/manifests/../index.db":   http.StatusMovedPermanently,
+		"/v2/app/blobs/not-digest": http.StatusBadRequest,
+	} {
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+		resp, err := client.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("%s: expected %d, got %d", path, want, resp.StatusCode)
+		}
+	}
+
+	if stats := server.Stats(); stats.Requests != 3 {
+		t.Errorf("expected 3 served files, got %+v", stats)
+	}
+	t.Logf("✓ 镜像服务提供了 %d 次读取,路径穿越被拒绝", server.Stats().Requests)
+}
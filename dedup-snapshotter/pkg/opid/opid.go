@@ -0,0 +1,41 @@
+// Package opid 为每个快照 RPC 生成操作 ID,放入 context 并作为 context 日志的 op_id 字段,
+// 存储、erofs 和 fscache 中经 log.G(ctx) 输出的日志以及审计记录都带有同一 ID,
+// 并发操作时可据此找出单个 Prepare 在各子系统中的全部日志
+package opid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/containerd/log"
+)
+
+// Field 是日志中操作 ID 的字段名
+const Field = "op_id"
+
+type key struct{}
+
+// New 生成一个新的操作 ID
+func New() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// Start 为 rpc 开始一个操作:ctx 中已有操作 ID 时沿用(如 View 内部调用 Prepare 的路径),
+// 否则生成新 ID,返回的 context 的日志带有 op_id 和 rpc 字段
+func Start(ctx context.Context, rpc string) context.Context {
+	if FromContext(ctx) != "" {
+		return ctx
+	}
+	id := New()
+	ctx = context.WithValue(ctx, key{}, id)
+	return log.WithLogger(ctx, log.G(ctx).WithFields(log.Fields{Field: id, "rpc": rpc}))
+}
+
+// FromContext 返回 ctx 中的操作 ID,没有时为空
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(key{}).(string)
+	return id
+}
@@ -0,0 +1,32 @@
+package opid
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containerd/log"
+)
+
+// TestStart 验证操作 ID 写入 context 和日志字段,嵌套调用沿用外层的 ID
+func TestStart(t *testing.T) {
+	ctx := Start(context.Background(), "prepare")
+	id := FromContext(ctx)
+	if len(id) != 16 {
+		t.Fatalf("expected a 16 character operation id, got %q", id)
+	}
+	if got := log.G(ctx).Data[Field]; got != id {
+		t.Fatalf("expected log field %s=%s, got %v", Field, id, got)
+	}
+	if log.G(ctx).Data["rpc"] != "prepare" {
+		t.Fatalf("expected rpc field, got %v", log.G(ctx).Data)
+	}
+
+	nested := Start(ctx, "mounts")
+	if FromContext(nested) != id || log.G(nested).Data["rpc"] != "prepare" {
+		t.Fatalf("expected nested operation to keep %s, got %s", id, FromContext(nested))
+	}
+	if other := FromContext(Start(context.Background(), "remove")); other == id || other == "" {
+		t.Fatalf("expected a new operation id, got %q", other)
+	}
+	t.Logf("✓ 操作 ID %s 写入日志字段并在嵌套调用中沿用", id)
+}
@@ -0,0 +1,378 @@
+// Package proxy 实现镜像仓库的拉取代理:客户端(如 containerd 的 registry mirror)照常拉取,
+// 代理把 /v2/ 请求转发到上游仓库,并把经过的层 blob 交给存储在后台物化为 EROFS 镜像、
+// 切分并记录 chunk 清单,集群中的其他节点随后可以通过镜像服务(--mirror)懒加载这些层。
+//
+// 代理不处理认证:Authorization 头原样转发,上游的 401 质询原样返回,客户端直接向上游的令牌服务
+// 换取令牌。层 blob 由经过代理的镜像清单识别,只有在清单中见过的层才会被转换
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/log"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// maxManifestSize 是代理解析的清单大小上限,更大的响应只转发
+	maxManifestSize = 4 << 20
+	// maxKnownLayers 是记录的层描述符上限,超出后清空重新记录
+	maxKnownLayers = 10000
+)
+
+// 转发给上游和返回给客户端的头
+var (
+	requestHeaders  = []string{"Authorization", "Accept", "Range", "If-None-Match", "If-Modified-Since"}
+	responseHeaders = []string{"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges", "Docker-Content-Digest",
+		"Docker-Distribution-Api-Version", "Etag", "Last-Modified", "Www-Authenticate", "Location", "Link"}
+)
+
+// Converter 物化层 blob 并记录 chunk 清单,由 storage.DedupStore 实现
+type Converter interface {
+	HasLayer(layerID string) bool
+	ConvertProxiedLayer(ctx context.Context, layer ocispec.Descriptor, blobPath string) error
+}
+
+// Options 配置拉取代理。Upstream 为上游仓库地址;TempDir 存放转换前的 blob;
+// MaxLayerSize 大于 0 时更大的层只转发不转换;Client 为空时使用 http.DefaultClient
+type Options struct {
+	Upstream     string
+	TempDir      string
+	Workers      int
+	MaxLayerSize int64
+	Client       *http.Client
+}
+
+// Stats 是代理自启动以来的统计
+type Stats struct {
+	Requests           int64 `json:"requests"`
+	BytesProxied       int64 `json:"bytes_proxied"`
+	LayersQueued       int64 `json:"layers_queued"`
+	LayersConverted    int64 `json:"layers_converted"`
+	ConversionFailures int64 `json:"conversion_failures"`
+	Pending            int   `json:"pending"`
+}
+
+type job struct {
+	layer ocispec.Descriptor
+	path  string
+}
+
+type Server struct {
+	upstream     *url.URL
+	client       *http.Client
+	converter    Converter
+	tempDir      string
+	maxLayerSize int64
+	server       *http.Server
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	queue  chan job
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	layers   map[digest.Digest]ocispec.Descriptor
+	inflight map[digest.Digest]bool
+
+	requests  atomic.Int64
+	bytes     atomic.Int64
+	queued    atomic.Int64
+	converted atomic.Int64
+	failures  atomic.Int64
+}
+
+// NewServer 创建拉取代理并启动转换 worker
+func NewServer(converter Converter, opts Options) (*Server, error) {
+	upstream, err := url.Parse(opts.Upstream)
+	if err != nil || (upstream.Scheme != "http" && upstream.Scheme != "https") || upstream.Host == "" {
+		return nil, fmt.Errorf("invalid upstream registry %q", opts.Upstream)
+	}
+	if err := os.MkdirAll(opts.TempDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create proxy temp dir: %w", err)
+	}
+	// 上次退出时未转换的 blob
+	stale, _ := filepath.Glob(filepath.Join(opts.TempDir, "blob-*"))
+	for _, path := range stale {
+		os.Remove(path)
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		upstream:     upstream,
+		client:       opts.Client,
+		converter:    converter,
+		tempDir:      opts.TempDir,
+		maxLayerSize: opts.MaxLayerSize,
+		ctx:          ctx,
+		cancel:       cancel,
+		queue:        make(chan job, opts.Workers*4),
+		layers:       make(map[digest.Digest]ocispec.Descriptor),
+		inflight:     make(map[digest.Digest]bool),
+	}
+	for i := 0; i < opts.Workers; i++ {
+		s.wg.Add(1)
+		go s.worker()
+	}
+	return s, nil
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/", s.handleRegistry)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return mux
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	s.server = &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	log.L.Infof("serving pull-through proxy for %s on %s", s.upstream, addr)
+	return s.server.ListenAndServe()
+}
+
+// Shutdown 停止接受请求,取消进行中的转换并等待 worker 退出
+func (s *Server) Shutdown(ctx context.Context) error {
+	var err error
+	if s.server != nil {
+		err = s.server.Shutdown(ctx)
+	}
+	s.cancel()
+	s.wg.Wait()
+
+	for {
+		select {
+		case j := <-s.queue:
+			os.Remove(j.path)
+			s.release(j.layer.Digest)
+		default:
+			return err
+		}
+	}
+}
+
+func (s *Server) Stats() Stats {
+	s.mu.Lock()
+	pending := len(s.inflight)
+	s.mu.Unlock()
+	return Stats{
+		Requests:           s.requests.Load(),
+		BytesProxied:       s.bytes.Load(),
+		LayersQueued:       s.queued.Load(),
+		LayersConverted:    s.converted.Load(),
+		ConversionFailures: s.failures.Load(),
+		Pending:            pending,
+	}
+}
+
+// handleRegistry 转发 /v2/ 下的拉取请求。清单响应用于记录层描述符,
+// 完整下载的层 blob 同时写入临时文件,校验后排队转换
+func (s *Server) handleRegistry(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "pull-through proxy is read-only", http.StatusMethodNotAllowed)
+		return
+	}
+	s.requests.Add(1)
+
+	target := *s.upstream
+	target.Path = strings.TrimSuffix(s.upstream.Path, "/") + r.URL.Path
+	target.RawQuery = r.URL.RawQuery
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, target.String(), nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, h := range requestHeaders {
+		if v := r.Header.Values(h); len(v) > 0 {
+			req.Header[h] = v
+		}
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		log.G(r.Context()).WithError(err).Warnf("proxy request to %s failed", target.Redacted())
+		http.Error(w, "upstream registry unavailable", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, h := range responseHeaders {
+		if v := resp.Header.Values(h); len(v) > 0 {
+			w.Header()[h] = v
+		}
+	}
+
+	kind, ref := parsePath(r.URL.Path)
+	ok := r.Method == http.MethodGet && resp.StatusCode == http.StatusOK
+	switch {
+	case ok && kind == "manifests":
+		s.proxyManifest(w, resp)
+	case ok && kind == "blobs":
+		s.proxyBlob(w, resp, ref)
+	default:
+		w.WriteHeader(resp.StatusCode)
+		n, _ := io.Copy(w, resp.Body)
+		s.bytes.Add(n)
+	}
+}
+
+// proxyManifest 返回清单并记录其中的层描述符
+func (s *Server) proxyManifest(w http.ResponseWriter, resp *http.Response) {
+	w.WriteHeader(resp.StatusCode)
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+	n, _ := w.Write(data)
+	s.bytes.Add(int64(n))
+	if err != nil || len(data) > maxManifestSize {
+		// 超大或读取失败的清单只转发
+		n, _ := io.Copy(w, resp.Body)
+		s.bytes.Add(n)
+		return
+	}
+
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil || len(manifest.Layers) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.layers)+len(manifest.Layers) > maxKnownLayers {
+		s.layers = make(map[digest.Digest]ocispec.Descriptor)
+	}
+	for _, layer := range manifest.Layers {
+		if images.IsLayerType(layer.MediaType) && !images.IsNonDistributable(layer.MediaType) {
+			s.layers[layer.Digest] = layer
+		}
+	}
+}
+
+// proxyBlob 返回 blob,需要转换的层同时写入临时文件
+func (s *Server) proxyBlob(w http.ResponseWriter, resp *http.Response, ref string) {
+	w.WriteHeader(resp.StatusCode)
+
+	layer, ok := s.claim(ref)
+	if !ok {
+		n, _ := io.Copy(w, resp.Body)
+		s.bytes.Add(n)
+		return
+	}
+
+	tmp, err := os.CreateTemp(s.tempDir, "blob-")
+	if err != nil {
+		log.L.WithError(err).Warnf("failed to create temp file for layer %s", layer.Digest)
+		s.release(layer.Digest)
+		n, _ := io.Copy(w, resp.Body)
+		s.bytes.Add(n)
+		return
+	}
+
+	verifier := layer.Digest.Verifier()
+	n, err := io.Copy(io.MultiWriter(w, tmp, verifier), resp.Body)
+	s.bytes.Add(n)
+	closeErr := tmp.Close()
+	switch {
+	case err != nil || closeErr != nil:
+		// 客户端中断或上游连接断开,下次拉取时再转换
+		log.L.Debugf("not converting partially proxied layer %s", layer.Digest)
+	case n != layer.Size || !verifier.Verified():
+		log.L.Warnf("proxied blob %s does not match its descriptor, not converting", layer.Digest)
+	default:
+		select {
+		case s.queue <- job{layer: layer, path: tmp.Name()}:
+			s.queued.Add(1)
+			return
+		default:
+			log.L.Warnf("proxy conversion queue is full, not converting layer %s", layer.Digest)
+		}
+	}
+	os.Remove(tmp.Name())
+	s.release(layer.Digest)
+}
+
+// claim 判断 blob 是否为需要转换的层,是则标记为转换中
+func (s *Server) claim(ref string) (ocispec.Descriptor, bool) {
+	dgst, err := digest.Parse(ref)
+	if err != nil {
+		return ocispec.Descriptor{}, false
+	}
+	s.mu.Lock()
+	layer, known := s.layers[dgst]
+	if !known || s.inflight[dgst] || (s.maxLayerSize > 0 && layer.Size > s.maxLayerSize) {
+		s.mu.Unlock()
+		return ocispec.Descriptor{}, false
+	}
+	s.inflight[dgst] = true
+	s.mu.Unlock()
+
+	if s.converter.HasLayer(dgst.Encoded()) {
+		s.release(dgst)
+		return ocispec.Descriptor{}, false
+	}
+	return layer, true
+}
+
+func (s *Server) release(dgst digest.Digest) {
+	s.mu.Lock()
+	delete(s.inflight, dgst)
+	s.mu.Unlock()
+}
+
+func (s *Server) worker() {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case j := <-s.queue:
+			s.convert(j)
+		}
+	}
+}
+
+func (s *Server) convert(j job) {
+	defer s.release(j.layer.Digest)
+	defer os.Remove(j.path)
+
+	start := time.Now()
+	if err := s.converter.ConvertProxiedLayer(s.ctx, j.layer, j.path); err != nil {
+		s.failures.Add(1)
+		log.L.WithError(err).Warnf("failed to convert proxied layer %s", j.layer.Digest)
+		return
+	}
+	s.converted.Add(1)
+	log.L.Infof("converted proxied layer %s (%d bytes) in %s", j.layer.Digest, j.layer.Size, time.Since(start))
+}
+
+// parsePath 从 /v2/<name>/{manifests,blobs}/<reference> 中取出类型和引用,其他路径返回空
+func parsePath(path string) (string, string) {
+	for _, kind := range []string{"manifests", "blobs"} {
+		if i := strings.LastIndex(path, "/"+kind+"/"); i > len("/v2") {
+			return kind, path[i+len(kind)+2:]
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type fakeConverter struct {
+	mu        sync.Mutex
+	converted map[string][]byte
+}
+
+func (f *fakeConverter) HasLayer(layerID string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.converted[layerID]
+	return ok
+}
+
+func (f *fakeConverter) ConvertProxiedLayer(ctx context.Context, layer ocispec.Descriptor, blobPath string) error {
+	data, err := os.ReadFile(blobPath)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.converted[layer.Digest.Encoded()] = data
+	return nil
+}
+
+func (f *fakeConverter) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.converted)
+}
+
+// TestPullThroughProxy 验证代理转发清单和 blob、把清单中的层交给转换、已转换的层不再转换,
+// 以及认证质询原样返回
+func TestPullThroughProxy(t *testing.T) {
+	layer := []byte("layer tar data")
+	config := []byte("{}")
+	layerDesc := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: digest.FromBytes(layer), Size: int64(len(layer))}
+	configDesc := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageConfig, Digest: digest.FromBytes(config), Size: int64(len(config))}
+	manifest, _ := json.Marshal(ocispec.Manifest{MediaType: ocispec.MediaTypeImageManifest, Config: configDesc, Layers: []ocispec.Descriptor{layerDesc}})
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.Header().Set("Www-Authenticate", `Bearer realm="https://auth.example.com/token"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/library/app/manifests/latest":
+			w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+			w.Write(manifest)
+		case "/v2/library/app/blobs/" + layerDesc.Digest.String():
+			w.Write(layer)
+		case "/v2/library/app/blobs/" + configDesc.Digest.String():
+			w.Write(config)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+
+	converter := &fakeConverter{converted: map[string][]byte{}}
+	s, err := NewServer(converter, Options{Upstream: upstream.URL, TempDir: t.TempDir(), Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown(context.Background())
+	proxy := httptest.NewServer(s.Handler())
+	defer proxy.Close()
+
+	get := func(path, token string) (*http.Response, []byte) {
+		req, _ := http.NewRequest(http.MethodGet, proxy.URL+path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, body
+	}
+
+	if resp, _ := get("/v2/library/app/manifests/latest", ""); resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("Www-Authenticate") == "" {
+		t.Fatalf("expected auth challenge to be relayed, got %d", resp.StatusCode)
+	}
+	t.Logf("✓ 认证质询原样返回")
+
+	// 清单之前拉取的 blob 无法识别为层,不转换
+	get("/v2/library/app/blobs/"+layerDesc.Digest.String(), "token")
+	if resp, body := get("/v2/library/app/manifests/latest", "token"); resp.StatusCode != http.StatusOK || string(body) != string(manifest) {
+		t.Fatalf("unexpected manifest response %d", resp.StatusCode)
+	}
+	get("/v2/library/app/blobs/"+configDesc.Digest.String(), "token")
+	if resp, body := get("/v2/library/app/blobs/"+layerDesc.Digest.String(), "token"); resp.StatusCode != http.StatusOK || string(body) != string(layer) {
+		t.Fatalf("unexpected blob response %d", resp.StatusCode)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for converter.count() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if string(converter.converted[layerDesc.Digest.Encoded()]) != string(layer) || converter.count() != 1 {
+		t.Fatalf("expected only the layer to be converted, got %d conversions", converter.count())
+	}
+	t.Logf("✓ 清单中的层在转发后被转换")
+
+	get("/v2/library/app/blobs/"+layerDesc.Digest.String(), "token")
+	time.Sleep(50 * time.Millisecond)
+	if stats := s.Stats(); stats.LayersQueued != 1 || stats.LayersConverted != 1 || stats.Pending != 0 {
+		t.Errorf("expected converted layer not to be queued again, got %+v", stats)
+	}
+	t.Logf("✓ 已转换的层不再转换")
+
+	req, _ := http.NewRequest(http.MethodPut, proxy.URL+"/v2/library/app/manifests/latest", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected push to be rejected, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,85 @@
+// Package refcount 提供 chunk 引用计数的读写,供存储索引和 EROFS chunk 索引共用。
+// 两者的 chunks 表都以 hash 为主键、ref_count 为引用次数:新 chunk 以 0 插入,
+// 所有增减都通过 Adjust 在调用方的事务中完成,不在插入时隐式计入一次引用
+package refcount
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrUnknownChunk 表示调整引用计数的 chunk 不在索引中
+var ErrUnknownChunk = errors.New("chunk not indexed")
+
+// ErrUnderflow 表示调整后引用计数将小于 0,通常说明同一引用被释放了两次
+var ErrUnderflow = errors.New("chunk reference count would drop below zero")
+
+// batchSize 是单条查询绑定的 hash 数,低于 SQLite 默认的 999 个参数上限
+const batchSize = 500
+
+// Querier 是 *sql.DB 和 *sql.Tx 共有的查询方法
+type Querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Adjust 在事务 tx 中按 deltas 调整各 chunk 的引用计数,返回调整后的值。
+// 任一 chunk 不存在或计数将为负时返回错误,调用方应回滚事务
+func Adjust(tx *sql.Tx, deltas map[string]int64) (map[string]int64, error) {
+	hashes := make([]string, 0, len(deltas))
+	for hash := range deltas {
+		hashes = append(hashes, hash)
+	}
+	// 固定顺序,出错时报告的 chunk 可复现
+	sort.Strings(hashes)
+
+	counts := make(map[string]int64, len(hashes))
+	for _, hash := range hashes {
+		var count int64
+		err := tx.QueryRow(`UPDATE chunks SET ref_count = ref_count + ? WHERE hash = ? RETURNING ref_count`, deltas[hash], hash).Scan(&count)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("chunk %s: %w", hash, ErrUnknownChunk)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to adjust ref count of chunk %s: %w", hash, err)
+		}
+		if count < 0 {
+			return nil, fmt.Errorf("chunk %s: %w", hash, ErrUnderflow)
+		}
+		counts[hash] = count
+	}
+	return counts, nil
+}
+
+// Get 批量查询 chunk 的引用计数,不在索引中的 chunk 不出现在结果中
+func Get(q Querier, hashes []string) (map[string]int64, error) {
+	counts := make(map[string]int64, len(hashes))
+	for start := 0; start < len(hashes); start += batchSize {
+		batch := hashes[start:min(start+batchSize, len(hashes))]
+		args := make([]interface{}, len(batch))
+		for i, hash := range batch {
+			args[i] = hash
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(batch)), ",")
+		rows, err := q.Query(`SELECT hash, ref_count FROM chunks WHERE hash IN (`+placeholders+`)`, args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query ref counts: %w", err)
+		}
+		for rows.Next() {
+			var hash string
+			var count int64
+			if err := rows.Scan(&hash, &count); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			counts[hash] = count
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return counts, nil
+}
@@ -0,0 +1,91 @@
+package retention
+
+import (
+	"context"
+	"encoding/json"
+
+	containersapi "github.com/containerd/containerd/api/services/containers/v1"
+	imagesapi "github.com/containerd/containerd/api/services/images/v1"
+	"github.com/containerd/containerd/namespaces"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// criContainerMetadataExtension 是 CRI 插件保存容器元数据的扩展名
+const criContainerMetadataExtension = "io.cri-containerd.container.metadata"
+
+// containerdStore 通过 containerd 的镜像和容器服务访问 namespace 中的镜像
+type containerdStore struct {
+	images     imagesapi.ImagesClient
+	containers containersapi.ContainersClient
+	namespace  string
+}
+
+// NewContainerdStore 返回访问 containerd namespace(CRI 为 k8s.io)中镜像的 ImageStore。
+// 删除不同步等待垃圾回收,快照随后由 containerd 经快照服务释放
+func NewContainerdStore(conn grpc.ClientConnInterface, namespace string) ImageStore {
+	return &containerdStore{
+		images:     imagesapi.NewImagesClient(conn),
+		containers: containersapi.NewContainersClient(conn),
+		namespace:  namespace,
+	}
+}
+
+func (s *containerdStore) Images(ctx context.Context) ([]Image, error) {
+	resp, err := s.images.List(namespaces.WithNamespace(ctx, s.namespace), &imagesapi.ListImagesRequest{})
+	if err != nil {
+		return nil, err
+	}
+	images := make([]Image, 0, len(resp.Images))
+	for _, img := range resp.Images {
+		if img.Target == nil {
+			continue
+		}
+		image := Image{Name: img.Name, Digest: img.Target.Digest, Labels: img.Labels}
+		if img.CreatedAt != nil {
+			image.CreatedAt = img.CreatedAt.AsTime()
+		}
+		if img.UpdatedAt != nil {
+			image.UpdatedAt = img.UpdatedAt.AsTime()
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+// criContainerMetadata 是 CRI 容器扩展中记录的元数据,ImageRef 为创建容器时解析出的镜像 ID
+type criContainerMetadata struct {
+	Metadata struct {
+		ImageRef string
+	}
+}
+
+// InUse 返回容器记录的镜像名,以及 CRI 创建的容器的镜像 ID,镜像名被移到新镜像后仍能找到原镜像
+func (s *containerdStore) InUse(ctx context.Context) ([]string, error) {
+	resp, err := s.containers.List(namespaces.WithNamespace(ctx, s.namespace), &containersapi.ListContainersRequest{})
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]string, 0, len(resp.Containers))
+	for _, c := range resp.Containers {
+		if c.Image != "" {
+			refs = append(refs, c.Image)
+		}
+		if ext := c.Extensions[criContainerMetadataExtension]; ext != nil {
+			var md criContainerMetadata
+			if err := json.Unmarshal(ext.GetValue(), &md); err == nil && md.Metadata.ImageRef != "" {
+				refs = append(refs, md.Metadata.ImageRef)
+			}
+		}
+	}
+	return refs, nil
+}
+
+func (s *containerdStore) Delete(ctx context.Context, name string) error {
+	_, err := s.images.Delete(namespaces.WithNamespace(ctx, s.namespace), &imagesapi.DeleteImageRequest{Name: name})
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,377 @@
+// Package retention 按保留规则清理 containerd 中的镜像:每个仓库只保留最近 N 个标签,
+// 超过 X 天未被容器使用的镜像被删除,固定的镜像和正在使用的镜像始终保留。镜像只从 containerd 的
+// 镜像服务中删除,不再被引用的快照由 containerd 的垃圾回收经快照服务的 Remove 正常释放
+package retention
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	refdocker "github.com/containerd/containerd/reference/docker"
+	"github.com/containerd/log"
+)
+
+const (
+	// LabelRetain 为 true 的镜像始终保留
+	LabelRetain = "containerd.io/snapshot/dedup.retain"
+	// LabelCRIPinned 是 CRI 插件为 sandbox 等固定镜像设置的标签,值为 pinned
+	LabelCRIPinned = "io.cri-containerd.pinned"
+)
+
+// 镜像的处理结果
+const (
+	ActionKeep   = "keep"
+	ActionDelete = "delete"
+)
+
+// Policy 是保留规则,KeepLastTags 和 UnusedDays 为 0 时对应规则不生效。
+// Pinned 为按 path.Match 匹配完整镜像名的模式,如 docker.io/library/pause:*
+type Policy struct {
+	KeepLastTags int      `json:"keep_last_tags"`
+	UnusedDays   int      `json:"unused_days"`
+	Pinned       []string `json:"pinned,omitempty"`
+}
+
+// Image 是 containerd 中的一个镜像名及其指向的 manifest 或 index
+type Image struct {
+	Name      string
+	Digest    string
+	Labels    map[string]string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// ImageStore 列出和删除镜像,由 containerd 的镜像和容器服务实现
+type ImageStore interface {
+	Images(ctx context.Context) ([]Image, error)
+	// InUse 返回容器引用的镜像:镜像名、name@digest、manifest digest 或镜像 ID(sha256:<config digest>)
+	InUse(ctx context.Context) ([]string, error)
+	Delete(ctx context.Context, name string) error
+}
+
+// Decision 是对指向同一 digest 的所有镜像名的处理结果,删除失败时 Error 非空
+type Decision struct {
+	Digest   string    `json:"digest"`
+	Names    []string  `json:"names"`
+	Action   string    `json:"action"`
+	Reason   string    `json:"reason"`
+	LastUsed time.Time `json:"last_used"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Report 是一次规则检查的结果,DryRun 为 true 时只报告而不删除
+type Report struct {
+	Time    time.Time  `json:"time"`
+	DryRun  bool       `json:"dry_run"`
+	Policy  Policy     `json:"policy"`
+	Kept    int        `json:"kept"`
+	Deleted int        `json:"deleted"`
+	Failed  int        `json:"failed"`
+	Images  []Decision `json:"images"`
+}
+
+// Engine 周期性按 Policy 检查镜像,并记录各镜像最近一次被容器使用的时间
+type Engine struct {
+	store     ImageStore
+	statePath string
+
+	// runMu 串行化检查
+	runMu    sync.Mutex
+	mu       sync.Mutex
+	policy   Policy
+	lastUsed map[string]time.Time
+	last     *Report
+}
+
+type engineState struct {
+	LastUsed map[string]time.Time `json:"last_used"`
+}
+
+// NewEngine 创建保留策略引擎,statePath 保存各 digest 最近一次被使用的时间,文件不存在或损坏时重新记录
+func NewEngine(store ImageStore, policy Policy, statePath string) *Engine {
+	e := &Engine{store: store, statePath: statePath, policy: policy, lastUsed: make(map[string]time.Time)}
+
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.L.WithError(err).Warnf("failed to read retention state %s", statePath)
+		}
+		return e
+	}
+	var state engineState
+	if err := json.Unmarshal(data, &state); err != nil {
+		log.L.Warnf("ignoring corrupt retention state %s", statePath)
+		return e
+	}
+	for dgst, t := range state.LastUsed {
+		e.lastUsed[dgst] = t
+	}
+	return e
+}
+
+// SetPolicy 替换保留规则,对下一次检查生效
+func (e *Engine) SetPolicy(p Policy) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.policy = p
+}
+
+// LastReport 返回最近一次检查的结果,尚未检查过时返回 nil
+func (e *Engine) LastReport() *Report {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.last
+}
+
+// Run 每 interval 检查一次,直到 ctx 取消
+func (e *Engine) Run(ctx context.Context, interval time.Duration, dryRun bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		report, err := e.Evaluate(ctx, dryRun)
+		if err != nil {
+			log.L.WithError(err).Warn("image retention check failed")
+			continue
+		}
+		if report.Deleted > 0 || report.Failed > 0 {
+			log.L.Infof("image retention deleted %d images, %d failed", report.Deleted, report.Failed)
+		}
+	}
+}
+
+// unit 是指向同一 digest 的镜像名,CRI 为同一镜像同时保存标签、name@digest 和 sha256:<id> 三种名字
+type unit struct {
+	digest  string
+	names   []string
+	labels  map[string]string
+	updated time.Time
+}
+
+// inUseDigest 把容器引用的镜像解析为镜像的 digest。标签被移到新镜像后按名字只能找到新镜像,
+// 调用方还应传入镜像 ID 或 digest 以找到容器实际使用的镜像
+func inUseDigest(ref string, byName map[string]string, units map[string]*unit) (string, bool) {
+	if dgst, ok := byName[ref]; ok {
+		return dgst, true
+	}
+	if i := strings.LastIndex(ref, "@"); i >= 0 {
+		ref = ref[i+1:]
+	}
+	if units[ref] != nil {
+		return ref, true
+	}
+	// CRI 为每个镜像保存 sha256:<镜像 ID> 的名字
+	dgst, ok := byName[ref]
+	return dgst, ok
+}
+
+// Evaluate 按当前规则检查所有镜像,dryRun 为 false 时删除被选中的镜像的所有名字
+func (e *Engine) Evaluate(ctx context.Context, dryRun bool) (*Report, error) {
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+
+	images, err := e.store.Images(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+	inUse, err := e.store.InUse(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	now := time.Now()
+
+	units := make(map[string]*unit)
+	byName := make(map[string]string, len(images))
+	for _, img := range images {
+		u := units[img.Digest]
+		if u == nil {
+			u = &unit{digest: img.Digest, labels: make(map[string]string)}
+			units[img.Digest] = u
+		}
+		u.names = append(u.names, img.Name)
+		for k, v := range img.Labels {
+			u.labels[k] = v
+		}
+		for _, t := range []time.Time{img.CreatedAt, img.UpdatedAt} {
+			if t.After(u.updated) {
+				u.updated = t
+			}
+		}
+		byName[img.Name] = img.Digest
+	}
+	used := make(map[string]bool)
+	for _, ref := range inUse {
+		if dgst, ok := inUseDigest(ref, byName, units); ok {
+			used[dgst] = true
+		}
+	}
+
+	e.mu.Lock()
+	policy := e.policy
+	for dgst := range used {
+		e.lastUsed[dgst] = now
+	}
+	for dgst := range e.lastUsed {
+		if units[dgst] == nil {
+			delete(e.lastUsed, dgst)
+		}
+	}
+	lastUsed := make(map[string]time.Time, len(e.lastUsed))
+	for k, v := range e.lastUsed {
+		lastUsed[k] = v
+	}
+	e.mu.Unlock()
+	e.saveState(lastUsed)
+
+	outdated := outdatedTags(units, policy.KeepLastTags)
+	report := &Report{Time: now, DryRun: dryRun, Policy: policy, Images: []Decision{}}
+	digests := make([]string, 0, len(units))
+	for dgst := range units {
+		digests = append(digests, dgst)
+	}
+	sort.Strings(digests)
+
+	for _, dgst := range digests {
+		u := units[dgst]
+		sort.Strings(u.names)
+		d := Decision{Digest: dgst, Names: u.names, Action: ActionKeep, LastUsed: lastUsed[dgst]}
+		idle := now.Sub(u.updated)
+		if d.LastUsed.After(u.updated) {
+			idle = now.Sub(d.LastUsed)
+		}
+
+		switch {
+		case used[dgst]:
+			d.Reason = "in use by a container"
+		case pinned(u, policy.Pinned):
+			d.Reason = "pinned"
+		case outdated[dgst] != "":
+			d.Action = ActionDelete
+			d.Reason = fmt.Sprintf("older than the last %d tags of %s", policy.KeepLastTags, outdated[dgst])
+		case policy.UnusedDays > 0 && idle > time.Duration(policy.UnusedDays)*24*time.Hour:
+			d.Action = ActionDelete
+			d.Reason = fmt.Sprintf("unused for %d days", int(idle.Hours()/24))
+		default:
+			d.Reason = "retained"
+		}
+
+		if d.Action == ActionDelete && !dryRun {
+			for _, name := range u.names {
+				if err := e.store.Delete(ctx, name); err != nil {
+					d.Error = err.Error()
+					log.L.WithError(err).Warnf("failed to delete image %s", name)
+					break
+				}
+			}
+			if d.Error == "" {
+				log.L.Infof("deleted image %s (%v): %s", dgst, u.names, d.Reason)
+			}
+		}
+
+		switch {
+		case d.Action == ActionKeep:
+			report.Kept++
+		case d.Error != "":
+			report.Failed++
+		default:
+			report.Deleted++
+		}
+		report.Images = append(report.Images, d)
+	}
+
+	e.mu.Lock()
+	e.last = report
+	e.mu.Unlock()
+	return report, nil
+}
+
+// outdatedTags 返回每个仓库最近 keep 个标签之外的镜像及其所属仓库。镜像在多个仓库中带标签时,
+// 只有在每个仓库中都不属于最近的标签才返回;没有标签(只有 name@digest)的镜像不受该规则影响
+func outdatedTags(units map[string]*unit, keep int) map[string]string {
+	outdated := make(map[string]string)
+	if keep <= 0 {
+		return outdated
+	}
+
+	repos := make(map[string][]*unit)
+	for _, u := range units {
+		seen := make(map[string]bool)
+		for _, name := range u.names {
+			// CRI 以 sha256:<id> 保存的镜像 ID 不是镜像引用
+			if strings.HasPrefix(name, "sha256:") {
+				continue
+			}
+			named, err := refdocker.ParseNormalizedNamed(name)
+			if err != nil {
+				continue
+			}
+			if _, ok := named.(refdocker.Tagged); !ok || seen[named.Name()] {
+				continue
+			}
+			seen[named.Name()] = true
+			repos[named.Name()] = append(repos[named.Name()], u)
+		}
+	}
+
+	recent := make(map[string]bool)
+	for repo, list := range repos {
+		sort.Slice(list, func(i, j int) bool {
+			if !list[i].updated.Equal(list[j].updated) {
+				return list[i].updated.After(list[j].updated)
+			}
+			return list[i].digest < list[j].digest
+		})
+		for i, u := range list {
+			if i < keep {
+				recent[u.digest] = true
+			} else if outdated[u.digest] == "" {
+				outdated[u.digest] = repo
+			}
+		}
+	}
+	for dgst := range recent {
+		delete(outdated, dgst)
+	}
+	return outdated
+}
+
+// pinned 判断镜像是否带保留标签或名字匹配 patterns
+func pinned(u *unit, patterns []string) bool {
+	if u.labels[LabelRetain] == "true" || u.labels[LabelCRIPinned] == "pinned" {
+		return true
+	}
+	for _, name := range u.names {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (e *Engine) saveState(lastUsed map[string]time.Time) {
+	data, err := json.Marshal(engineState{LastUsed: lastUsed})
+	if err != nil {
+		return
+	}
+	tmp := e.statePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		log.L.WithError(err).Warnf("failed to write retention state %s", e.statePath)
+		return
+	}
+	if err := os.Rename(tmp, e.statePath); err != nil {
+		log.L.WithError(err).Warnf("failed to write retention state %s", e.statePath)
+	}
+}
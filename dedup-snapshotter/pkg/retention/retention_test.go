@@ -0,0 +1,143 @@
+package retention
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+type fakeStore struct {
+	images  []Image
+	inUse   []string
+	deleted []string
+}
+
+func (s *fakeStore) Images(ctx context.Context) ([]Image, error) {
+	var left []Image
+	for _, img := range s.images {
+		if !contains(s.deleted, img.Name) {
+			left = append(left, img)
+		}
+	}
+	return left, nil
+}
+
+func (s *fakeStore) InUse(ctx context.Context) ([]string, error) {
+	return s.inUse, nil
+}
+
+func (s *fakeStore) Delete(ctx context.Context, name string) error {
+	s.deleted = append(s.deleted, name)
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// TestRetentionPolicy 验证保留最近 N 个标签、删除长期未使用的镜像、固定和使用中的镜像不被删除,以及 dry-run 只报告
+func TestRetentionPolicy(t *testing.T) {
+	now := time.Now()
+	days := func(n int) time.Time { return now.Add(-time.Duration(n) * 24 * time.Hour) }
+	store := &fakeStore{
+		images: []Image{
+			{Name: "docker.io/library/app:v3", Digest: "sha256:a3", CreatedAt: days(1)},
+			{Name: "docker.io/library/app@sha256:a3", Digest: "sha256:a3", CreatedAt: days(1)},
+			{Name: "docker.io/library/app:v2", Digest: "sha256:a2", CreatedAt: days(2)},
+			{Name: "docker.io/library/app:v1", Digest: "sha256:a1", CreatedAt: days(3)},
+			{Name: "sha256:c1", Digest: "sha256:a1", CreatedAt: days(3)},
+			{Name: "docker.io/library/app:v0", Digest: "sha256:a0", CreatedAt: days(4)},
+			{Name: "docker.io/library/old:1", Digest: "sha256:o1", CreatedAt: days(40)},
+			{Name: "docker.io/library/busy:1", Digest: "sha256:b1", CreatedAt: days(40)},
+			{Name: "registry.k8s.io/pause:3.9", Digest: "sha256:p1", CreatedAt: days(90)},
+			{Name: "docker.io/library/cri-pinned:1", Digest: "sha256:k1", CreatedAt: days(90), Labels: map[string]string{LabelCRIPinned: "pinned"}},
+		},
+		inUse: []string{"docker.io/library/app:v0", "docker.io/library/busy:1"},
+	}
+	statePath := filepath.Join(t.TempDir(), "retention.json")
+	policy := Policy{KeepLastTags: 2, UnusedDays: 30, Pinned: []string{"registry.k8s.io/pause:*"}}
+	e := NewEngine(store, policy, statePath)
+
+	report, err := e.Evaluate(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions := make(map[string]string)
+	for _, d := range report.Images {
+		actions[d.Digest] = d.Action
+	}
+	want := map[string]string{
+		"sha256:a3": ActionKeep,
+		"sha256:a2": ActionKeep,
+		"sha256:a1": ActionDelete,
+		"sha256:a0": ActionKeep,
+		"sha256:o1": ActionDelete,
+		"sha256:b1": ActionKeep,
+		"sha256:p1": ActionKeep,
+		"sha256:k1": ActionKeep,
+	}
+	for dgst, action := range want {
+		if actions[dgst] != action {
+			t.Errorf("expected %s for %s, got %s (%+v)", action, dgst, actions[dgst], report.Images)
+		}
+	}
+	if !report.DryRun || report.Deleted != 2 || len(store.deleted) != 0 {
+		t.Fatalf("dry run must only report, got %+v, deleted %v", report, store.deleted)
+	}
+	t.Logf("✓ dry-run 报告删除 %d 个镜像,保留 %d 个", report.Deleted, report.Kept)
+
+	// busy:1 不再被容器引用,但按记录的最近使用时间未超过 30 天
+	store.inUse = []string{"docker.io/library/app:v0"}
+	e = NewEngine(store, policy, statePath)
+	report, err = e.Evaluate(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(store.deleted)
+	expected := []string{"docker.io/library/app:v1", "docker.io/library/old:1", "sha256:c1"}
+	if len(store.deleted) != len(expected) {
+		t.Fatalf("expected %v to be deleted, got %v", expected, store.deleted)
+	}
+	for i := range expected {
+		if store.deleted[i] != expected[i] {
+			t.Fatalf("expected %v to be deleted, got %v", expected, store.deleted)
+		}
+	}
+	if e.LastReport() != report || report.Deleted != 2 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	t.Logf("✓ 删除超出标签数和长期未使用的镜像的所有名字: %v", store.deleted)
+}
+
+// TestRetentionInUseByID 验证标签被移到新镜像后,按镜像 ID 或 digest 仍能识别容器使用的旧镜像
+func TestRetentionInUseByID(t *testing.T) {
+	old := time.Now().Add(-60 * 24 * time.Hour)
+	store := &fakeStore{
+		images: []Image{
+			// app:latest 已指向新镜像,旧镜像只剩 CRI 的镜像 ID 名字
+			{Name: "docker.io/library/app:latest", Digest: "sha256:new", CreatedAt: old},
+			{Name: "sha256:id-old", Digest: "sha256:old", CreatedAt: old},
+			{Name: "sha256:id-other", Digest: "sha256:bydigest", CreatedAt: old},
+		},
+		inUse: []string{"docker.io/library/app:latest", "sha256:id-old", "docker.io/library/other@sha256:bydigest"},
+	}
+	e := NewEngine(store, Policy{UnusedDays: 30}, filepath.Join(t.TempDir(), "retention.json"))
+
+	report, err := e.Evaluate(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range report.Images {
+		if d.Action != ActionKeep {
+			t.Errorf("expected %s to be kept as in use, got %s (%s)", d.Digest, d.Action, d.Reason)
+		}
+	}
+	t.Logf("✓ 按镜像 ID 和 digest 识别使用中的镜像")
+}
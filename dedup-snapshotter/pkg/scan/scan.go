@@ -0,0 +1,168 @@
+// Package scan 在转换后的层对外提供服务前调用漏洞扫描器,按策略得出放行、告警或阻止的结论。
+//
+// 扫描器输出 Trivy 的 JSON 报告:trivy 方式以客户端模式连接 Trivy server 扫描层目录,
+// exec 方式执行任意命令(层目录作为最后一个参数),命令需打印同样格式的报告
+package scan
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Outcome 是扫描结论
+type Outcome string
+
+const (
+	Allow Outcome = "allow"
+	Warn  Outcome = "warn"
+	Block Outcome = "block"
+)
+
+// ParseOutcome 解析配置中的结论,空值为 Warn
+func ParseOutcome(s string) (Outcome, error) {
+	switch o := Outcome(strings.ToLower(s)); o {
+	case "":
+		return Warn, nil
+	case Allow, Warn, Block:
+		return o, nil
+	default:
+		return "", fmt.Errorf("invalid scan outcome %q: must be allow, warn or block", s)
+	}
+}
+
+// Finding 是报告中的一个漏洞
+type Finding struct {
+	ID       string `json:"id"`
+	Package  string `json:"package,omitempty"`
+	Severity string `json:"severity"`
+}
+
+// Report 是一次扫描发现的漏洞
+type Report struct {
+	Findings []Finding `json:"findings"`
+}
+
+// Scanner 扫描层的文件系统目录
+type Scanner interface {
+	Scan(ctx context.Context, dir string) (*Report, error)
+}
+
+// commandScanner 执行命令并解析其标准输出中的 Trivy JSON 报告
+type commandScanner struct {
+	command []string
+	timeout time.Duration
+}
+
+// NewExecScanner 创建执行 command 的扫描器,层目录追加为最后一个参数
+func NewExecScanner(command []string, timeout time.Duration) Scanner {
+	return &commandScanner{command: command, timeout: timeout}
+}
+
+// NewTrivyScanner 创建以客户端模式连接 Trivy server 的扫描器,binary 为 trivy 可执行文件
+func NewTrivyScanner(binary, server string, timeout time.Duration) Scanner {
+	return &commandScanner{
+		command: []string{binary, "rootfs", "--server", server, "--format", "json", "--quiet", "--scanners", "vuln"},
+		timeout: timeout,
+	}
+}
+
+func (s *commandScanner) Scan(ctx context.Context, dir string) (*Report, error) {
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	args := append(append([]string(nil), s.command[1:]...), dir)
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.command[0], args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("scanner %s failed: %w: %s", s.command[0], err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return ParseTrivyReport(out)
+}
+
+// ParseTrivyReport 解析 Trivy 的 JSON 报告
+func ParseTrivyReport(data []byte) (*Report, error) {
+	var raw struct {
+		Results []struct {
+			Vulnerabilities []struct {
+				VulnerabilityID string
+				PkgName         string
+				Severity        string
+			}
+		}
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid scanner report: %w", err)
+	}
+
+	report := &Report{Findings: []Finding{}}
+	for _, result := range raw.Results {
+		for _, v := range result.Vulnerabilities {
+			report.Findings = append(report.Findings, Finding{
+				ID:       v.VulnerabilityID,
+				Package:  v.PkgName,
+				Severity: strings.ToUpper(v.Severity),
+			})
+		}
+	}
+	return report, nil
+}
+
+// Policy 把报告映射为结论:出现 Block 中的严重级别时阻止,出现 Warn 中的级别时告警,
+// 扫描失败时按 OnError 处理
+type Policy struct {
+	Warn    []string
+	Block   []string
+	OnError Outcome
+}
+
+// Verdict 是层的扫描结论,Severities 为各严重级别的漏洞数
+type Verdict struct {
+	Layer      string         `json:"layer"`
+	Outcome    Outcome        `json:"outcome"`
+	Severities map[string]int `json:"severities,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	ScannedAt  time.Time      `json:"scanned_at"`
+}
+
+// Evaluate 根据扫描结果得出层的结论
+func (p Policy) Evaluate(layer string, report *Report, scanErr error) *Verdict {
+	v := &Verdict{Layer: layer, Outcome: Allow, ScannedAt: time.Now()}
+	if scanErr != nil {
+		v.Outcome = p.OnError
+		v.Error = scanErr.Error()
+		return v
+	}
+
+	v.Severities = make(map[string]int)
+	for _, f := range report.Findings {
+		v.Severities[f.Severity]++
+	}
+	for severity := range v.Severities {
+		switch {
+		case containsFold(p.Block, severity):
+			v.Outcome = Block
+		case containsFold(p.Warn, severity) && v.Outcome == Allow:
+			v.Outcome = Warn
+		}
+	}
+	return v
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package scan
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+const trivyReport = `{"Results":[{"Target":"layer","Vulnerabilities":[
+{"VulnerabilityID":"CVE-2024-0001","PkgName":"openssl","Severity":"CRITICAL"},
+{"VulnerabilityID":"CVE-2024-0002","PkgName":"zlib","Severity":"high"}]}]}`
+
+// TestPolicyEvaluate 验证按严重级别得出放行、告警和阻止,扫描失败时按 OnError 处理
+func TestPolicyEvaluate(t *testing.T) {
+	report, err := ParseTrivyReport([]byte(trivyReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Findings) != 2 || report.Findings[1].Severity != "HIGH" {
+		t.Fatalf("unexpected findings: %+v", report.Findings)
+	}
+
+	policy := Policy{Warn: []string{"HIGH"}, Block: []string{"CRITICAL"}, OnError: Warn}
+	if v := policy.Evaluate("1", report, nil); v.Outcome != Block || v.Severities["CRITICAL"] != 1 {
+		t.Errorf("expected block, got %+v", v)
+	}
+	if v := policy.Evaluate("1", &Report{Findings: report.Findings[1:]}, nil); v.Outcome != Warn {
+		t.Errorf("expected warn, got %+v", v)
+	}
+	if v := policy.Evaluate("1", &Report{}, nil); v.Outcome != Allow {
+		t.Errorf("expected allow, got %+v", v)
+	}
+	if v := policy.Evaluate("1", nil, errors.New("scanner down")); v.Outcome != Warn || v.Error == "" {
+		t.Errorf("expected on_error outcome, got %+v", v)
+	}
+	t.Logf("✓ 扫描报告按策略得出结论")
+}
+
+// TestExecScanner 验证 exec 扫描器把层目录作为最后一个参数并解析输出
+func TestExecScanner(t *testing.T) {
+	dir := t.TempDir()
+	s := NewExecScanner([]string{"sh", "-c", `test -d "$1" && printf '%s' '` + trivyReport + `'`, "scanner"}, time.Minute)
+	report, err := s.Scan(context.Background(), dir)
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if len(report.Findings) != 2 {
+		t.Errorf("expected 2 findings, got %d", len(report.Findings))
+	}
+
+	if _, err := NewExecScanner([]string{"false"}, time.Minute).Scan(context.Background(), dir); err == nil {
+		t.Error("expected failing scanner to return an error")
+	}
+	t.Logf("✓ exec 扫描器发现 %d 个漏洞", len(report.Findings))
+}
@@ -0,0 +1,246 @@
+// Package signing 为转换产物(EROFS 镜像、chunk 清单)生成和校验与 cosign 兼容的签名。
+//
+// 本地产物的签名以 base64 写在同目录的 <文件>.sig 中,格式与 cosign sign-blob 相同,
+// 可以用 cosign verify-blob --key <公钥> --signature <文件>.sig <文件> 校验。
+// 镜像仓库中的产物使用 cosign 的 simple signing 载荷,签名存放在 sha256-<hex>.sig 标签
+// 或以 OCI referrer 形式挂在被签名的 digest 上。
+// 只支持 cosign 默认的 ECDSA P-256 密钥
+package signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	digest "github.com/opencontainers/go-digest"
+)
+
+const (
+	// AnnotationSignature 是 cosign 签名层上存放 base64 签名的注解
+	AnnotationSignature = "dev.cosignproject.cosign/signature"
+	// MediaTypeSimpleSigning 是 cosign 签名载荷的媒体类型
+	MediaTypeSimpleSigning = "application/vnd.dev.cosign.simplesigning.v1+json"
+	// ArtifactTypeSignature 是 cosign 以 OCI referrer 方式存放签名时的 artifactType
+	ArtifactTypeSignature = "application/vnd.dev.cosign.artifact.sig.v1+json"
+	// SignatureExt 是本地产物旁签名文件的后缀
+	SignatureExt = ".sig"
+
+	simpleSigningType = "cosign container image signature"
+)
+
+var (
+	// ErrNoSignature 表示产物没有签名
+	ErrNoSignature = errors.New("no signature found")
+	// ErrUntrusted 表示签名无法用任何受信任的公钥校验
+	ErrUntrusted = errors.New("signature not made by a trusted key")
+)
+
+// SimpleSigning 是 cosign 签名载荷,Critical.Image.DockerManifestDigest 为被签名的 digest
+type SimpleSigning struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]interface{} `json:"optional"`
+}
+
+// SignatureTag 返回 cosign 存放 dgst 签名的标签
+func SignatureTag(dgst digest.Digest) string {
+	return fmt.Sprintf("%s-%s%s", dgst.Algorithm(), dgst.Encoded(), SignatureExt)
+}
+
+type Signer struct {
+	key *ecdsa.PrivateKey
+}
+
+// LoadSigner 读取未加密的 PEM 私钥(PKCS#8 或 SEC1)。
+// cosign generate-key-pair 生成的是加密私钥,需要先导出为 PKCS#8
+func LoadSigner(path string) (*Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block in %s", path)
+	}
+
+	var key interface{}
+	switch {
+	case block.Type == "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case block.Type == "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case strings.HasPrefix(block.Type, "ENCRYPTED"):
+		return nil, fmt.Errorf("%s is an encrypted key, export it as unencrypted PKCS#8", path)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q in %s", block.Type, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok || ecKey.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("%s is not an ECDSA P-256 key", path)
+	}
+	return &Signer{key: ecKey}, nil
+}
+
+// PublicKeyPEM 返回公钥,可直接作为 cosign 的 --key 或 signing.trusted_keys 使用
+func (s *Signer) PublicKeyPEM() ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(&s.key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// SignBlob 对数据签名,返回 base64 编码的 ASN.1 签名
+func (s *Signer) SignBlob(data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	sig, err := ecdsa.SignASN1(rand.Reader, s.key, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// SignFile 对文件签名并写入 <path>.sig
+func (s *Signer) SignFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	sig, err := s.SignBlob(data)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + SignatureExt + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(sig), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path+SignatureExt)
+}
+
+// SignDigest 生成发布到镜像仓库用的 simple signing 载荷及其签名,
+// 载荷作为 MediaTypeSimpleSigning 层、签名作为 AnnotationSignature 注解推送即可被 cosign verify 识别
+func (s *Signer) SignDigest(ref string, dgst digest.Digest) ([]byte, string, error) {
+	var payload SimpleSigning
+	payload.Critical.Identity.DockerReference = ref
+	payload.Critical.Image.DockerManifestDigest = dgst.String()
+	payload.Critical.Type = simpleSigningType
+
+	data, err := json.Marshal(&payload)
+	if err != nil {
+		return nil, "", err
+	}
+	sig, err := s.SignBlob(data)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, sig, nil
+}
+
+// Verifier 持有受信任的公钥,任一公钥校验通过即可
+type Verifier struct {
+	keys []*ecdsa.PublicKey
+}
+
+func LoadVerifier(paths []string) (*Verifier, error) {
+	v := &Verifier{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := parsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted key %s: %w", path, err)
+		}
+		v.keys = append(v.keys, key)
+	}
+	if len(v.keys) == 0 {
+		return nil, fmt.Errorf("no trusted keys configured")
+	}
+	return v, nil
+}
+
+func parsePublicKey(data []byte) (*ecdsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("no PUBLIC KEY PEM block")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ecKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("not an ECDSA public key")
+	}
+	return ecKey, nil
+}
+
+// VerifyBlob 校验 SignBlob 生成的签名
+func (v *Verifier) VerifyBlob(data []byte, signature string) error {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(signature))
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	for _, key := range v.keys {
+		if ecdsa.VerifyASN1(key, sum[:], sig) {
+			return nil
+		}
+	}
+	return ErrUntrusted
+}
+
+// VerifyFile 用 <path>.sig 校验文件,签名文件不存在时返回 ErrNoSignature
+func (v *Verifier) VerifyFile(path string) error {
+	sig, err := os.ReadFile(path + SignatureExt)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrNoSignature
+		}
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return v.VerifyBlob(data, string(sig))
+}
+
+// VerifyPayload 校验 simple signing 载荷的签名,并确认载荷签的正是 dgst
+func (v *Verifier) VerifyPayload(payload []byte, signature string, dgst digest.Digest) error {
+	if err := v.VerifyBlob(payload, signature); err != nil {
+		return err
+	}
+	var ss SimpleSigning
+	if err := json.Unmarshal(payload, &ss); err != nil {
+		return fmt.Errorf("invalid signature payload: %w", err)
+	}
+	if ss.Critical.Type != simpleSigningType {
+		return fmt.Errorf("unexpected signature payload type %q", ss.Critical.Type)
+	}
+	if ss.Critical.Image.DockerManifestDigest != dgst.String() {
+		return fmt.Errorf("signature is for %s, not %s", ss.Critical.Image.DockerManifestDigest, dgst)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	digest "github.com/opencontainers/go-digest"
+)
+
+func writeKeyPair(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "signing.key")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := LoadSigner(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := signer.PublicKeyPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubPath := filepath.Join(dir, "signing.pub")
+	if err := os.WriteFile(pubPath, pub, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return keyPath, pubPath
+}
+
+// TestSignAndVerify 验证本地产物签名和仓库载荷签名的校验,包括篡改和 digest 不符
+func TestSignAndVerify(t *testing.T) {
+	dir := t.TempDir()
+	keyPath, pubPath := writeKeyPair(t, dir)
+	_, otherPub := writeKeyPair(t, t.TempDir())
+
+	signer, err := LoadSigner(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := LoadVerifier([]string{otherPub, pubPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	artifact := filepath.Join(dir, "layer.erofs")
+	os.WriteFile(artifact, []byte("erofs image"), 0644)
+	if err := verifier.VerifyFile(artifact); !errors.Is(err, ErrNoSignature) {
+		t.Fatalf("expected ErrNoSignature, got %v", err)
+	}
+	if err := signer.SignFile(artifact); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.VerifyFile(artifact); err != nil {
+		t.Fatalf("expected valid signature: %v", err)
+	}
+	os.WriteFile(artifact, []byte("tampered"), 0644)
+	if err := verifier.VerifyFile(artifact); !errors.Is(err, ErrUntrusted) {
+		t.Fatalf("expected ErrUntrusted for tampered artifact, got %v", err)
+	}
+
+	dgst := digest.FromString("chunk manifest")
+	payload, sig, err := signer.SignDigest("registry.example.com/app", dgst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.VerifyPayload(payload, sig, dgst); err != nil {
+		t.Fatalf("expected valid payload signature: %v", err)
+	}
+	if err := verifier.VerifyPayload(payload, sig, digest.FromString("other")); err == nil {
+		t.Fatal("expected signature for another digest to be rejected")
+	}
+	t.Logf("✓ 签名标签 %s", SignatureTag(dgst))
+}
@@ -0,0 +1,195 @@
+// Package slowlog 记录超过阈值的慢操作。操作运行到阈值仍未结束时立即输出一次包含
+// goroutine 栈和队列状态的详细日志,结束时再记录总耗时,从而区分慢操作和卡死。
+// 日志按操作类型限流,被丢弃的条数在下一条日志中报告。未配置时 Start 只做一次原子读
+package slowlog
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/containerd/log"
+)
+
+// 操作类型
+const (
+	OpPrepare    = "prepare"
+	OpMount      = "mount"
+	OpConversion = "conversion"
+	OpDownload   = "download"
+)
+
+// Config 中 Thresholds 为各操作类型的阈值,未列出或为 0 的类型不记录;
+// MaxPerMinute 是每种操作每分钟最多输出的详细日志条数;StackBytes 是栈转储的最大字节数
+type Config struct {
+	Thresholds   map[string]time.Duration
+	MaxPerMinute int
+	StackBytes   int
+}
+
+type limiter struct {
+	window     time.Time
+	count      int
+	suppressed int64
+}
+
+type logger struct {
+	cfg      Config
+	mu       sync.Mutex
+	limiters map[string]*limiter
+}
+
+var (
+	current atomic.Pointer[logger]
+
+	statesMu sync.Mutex
+	states   = make(map[string]func() interface{})
+)
+
+// Configure 启用慢操作日志,可重复调用以更新阈值
+func Configure(cfg Config) {
+	if cfg.MaxPerMinute <= 0 {
+		cfg.MaxPerMinute = 10
+	}
+	if cfg.StackBytes <= 0 {
+		cfg.StackBytes = 64 * 1024
+	}
+	current.Store(&logger{cfg: cfg, limiters: make(map[string]*limiter)})
+}
+
+// Disable 关闭慢操作日志,已开始的操作不再输出
+func Disable() {
+	current.Store(nil)
+}
+
+// RegisterState 注册慢操作日志中附带的状态,如队列深度。fn 为 nil 时移除
+func RegisterState(name string, fn func() interface{}) {
+	statesMu.Lock()
+	defer statesMu.Unlock()
+	if fn == nil {
+		delete(states, name)
+		return
+	}
+	states[name] = fn
+}
+
+func snapshotStates() map[string]interface{} {
+	statesMu.Lock()
+	fns := make(map[string]func() interface{}, len(states))
+	for name, fn := range states {
+		fns[name] = fn
+	}
+	statesMu.Unlock()
+
+	out := make(map[string]interface{}, len(fns))
+	for name, fn := range fns {
+		out[name] = fn()
+	}
+	return out
+}
+
+// allow 按操作类型限流,返回是否输出以及此前被丢弃的条数
+func (l *logger) allow(op string, now time.Time) (bool, int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lim, ok := l.limiters[op]
+	if !ok {
+		lim = &limiter{}
+		l.limiters[op] = lim
+	}
+	if now.Sub(lim.window) >= time.Minute {
+		lim.window, lim.count = now, 0
+	}
+	if lim.count >= l.cfg.MaxPerMinute {
+		lim.suppressed++
+		return false, 0
+	}
+	lim.count++
+	suppressed := lim.suppressed
+	lim.suppressed = 0
+	return true, suppressed
+}
+
+// Op 是一次被跟踪的操作,nil 表示不跟踪,其方法可以安全调用
+type Op struct {
+	l         *logger
+	name      string
+	fields    log.Fields
+	threshold time.Duration
+	start     time.Time
+	timer     *time.Timer
+	stalled   atomic.Bool
+	// detailed 表示阈值处的详细日志已输出(未被限流)
+	detailed atomic.Bool
+}
+
+// Start 开始跟踪一次操作,调用方在操作结束时调用 Done。fields 为附加在日志中的上下文
+func Start(op string, fields log.Fields) *Op {
+	l := current.Load()
+	if l == nil {
+		return nil
+	}
+	threshold := l.cfg.Thresholds[op]
+	if threshold <= 0 {
+		return nil
+	}
+
+	o := &Op{l: l, name: op, fields: fields, threshold: threshold, start: time.Now()}
+	o.timer = time.AfterFunc(threshold, o.stall)
+	return o
+}
+
+// stall 在操作运行到阈值仍未结束时输出详细日志
+func (o *Op) stall() {
+	o.stalled.Store(true)
+	ok, suppressed := o.l.allow(o.name, time.Now())
+	if !ok {
+		return
+	}
+	o.detailed.Store(true)
+
+	buf := make([]byte, o.l.cfg.StackBytes)
+	buf = buf[:runtime.Stack(buf, true)]
+	o.entry(suppressed).
+		WithField("elapsed", time.Since(o.start)).
+		WithField("state", snapshotStates()).
+		WithField("goroutines", runtime.NumGoroutine()).
+		WithField("stack", string(buf)).
+		Warnf("%s still running after %s", o.name, o.threshold)
+}
+
+// Done 结束跟踪,超过阈值时记录总耗时和结果
+func (o *Op) Done(err error) {
+	if o == nil {
+		return
+	}
+	o.timer.Stop()
+	elapsed := time.Since(o.start)
+	if elapsed < o.threshold {
+		return
+	}
+	ok, suppressed := o.l.allow(o.name, time.Now())
+	if !ok {
+		return
+	}
+
+	entry := o.entry(suppressed).WithField("duration", elapsed).WithField("stalled", o.stalled.Load())
+	if err != nil {
+		entry = entry.WithError(err)
+	}
+	if !o.detailed.Load() {
+		// 阈值处的详细日志没有输出时在这里补上状态
+		entry = entry.WithField("state", snapshotStates())
+	}
+	entry.Warnf("slow %s took %s (threshold %s)", o.name, elapsed.Round(time.Millisecond), o.threshold)
+}
+
+func (o *Op) entry(suppressed int64) *log.Entry {
+	entry := log.L.WithFields(o.fields).WithField("op", o.name).WithField("threshold", o.threshold)
+	if suppressed > 0 {
+		entry = entry.WithField("suppressed", suppressed)
+	}
+	return entry
+}
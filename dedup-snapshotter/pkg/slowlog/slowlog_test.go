@@ -0,0 +1,90 @@
+package slowlog
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerd/log"
+)
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// TestSlowOperationLogging 验证慢操作在阈值处输出带栈和状态的日志、结束时记录耗时,
+// 快操作不记录,超过每分钟上限的日志被丢弃
+func TestSlowOperationLogging(t *testing.T) {
+	out := &syncBuffer{}
+	logger := log.L.Logger
+	savedOut, savedFormatter := logger.Out, logger.Formatter
+	logger.SetOutput(out)
+	if err := log.SetFormat(log.JSONFormat); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		logger.SetOutput(savedOut)
+		logger.SetFormatter(savedFormatter)
+		Disable()
+		RegisterState("queue_depth", nil)
+	}()
+
+	if op := Start(OpPrepare, nil); op != nil {
+		t.Fatal("expected no tracking before Configure")
+	}
+
+	Configure(Config{Thresholds: map[string]time.Duration{OpPrepare: 20 * time.Millisecond}, MaxPerMinute: 2})
+	RegisterState("queue_depth", func() interface{} { return 7 })
+
+	Start(OpPrepare, log.Fields{"key": "fast"}).Done(nil)
+	if Start(OpMount, nil) != nil {
+		t.Error("operations without a threshold must not be tracked")
+	}
+	if out.String() != "" {
+		t.Fatalf("fast operation must not be logged: %s", out)
+	}
+
+	op := Start(OpPrepare, log.Fields{"key": "slow"})
+	time.Sleep(60 * time.Millisecond)
+	op.Done(errors.New("boom"))
+
+	logs := out.String()
+	if !strings.Contains(logs, "prepare still running after 20ms") || !strings.Contains(logs, `"stack":"goroutine`) ||
+		!strings.Contains(logs, `"queue_depth":7`) {
+		t.Fatalf("expected detailed stall log, got %s", logs)
+	}
+	if !strings.Contains(logs, "slow prepare took") || !strings.Contains(logs, `"error":"boom"`) || !strings.Contains(logs, `"key":"slow"`) {
+		t.Fatalf("expected completion log, got %s", logs)
+	}
+	t.Logf("✓ 阈值处输出栈和队列状态,结束时记录耗时")
+
+	for i := 0; i < 3; i++ {
+		op := Start(OpPrepare, nil)
+		time.Sleep(30 * time.Millisecond)
+		op.Done(nil)
+	}
+	if n := strings.Count(out.String(), `"op":"prepare"`); n != 2 {
+		t.Errorf("expected logs to be capped at 2 per minute, got %d", n)
+	}
+	limiter := current.Load().limiters[OpPrepare]
+	if limiter.suppressed == 0 {
+		t.Error("expected suppressed logs to be counted")
+	}
+	t.Logf("✓ 超过上限的 %d 条日志被丢弃", limiter.suppressed)
+}
@@ -0,0 +1,58 @@
+package snapshotter
+
+import (
+	"context"
+	"sync"
+
+	"github.com/containerd/log"
+)
+
+// conversionCall 是进行中的一次层转换,等待者在 done 关闭后读取 err
+type conversionCall struct {
+	done    chan struct{}
+	err     error
+	waiters int
+}
+
+// conversions 保证同一层同时只有一次转换,并发的 Prepare/Commit/Apply 合并到进行中的转换上,
+// 避免重复构建争用 staging 和镜像路径
+type conversions struct {
+	mu    sync.Mutex
+	calls map[string]*conversionCall
+}
+
+// do 执行 snapID 的转换。已有进行中的转换时等待其完成并返回其结果,此时 collapsed 为 true。
+// 等待者的 ctx 结束时提前返回,不影响进行中的转换
+func (c *conversions) do(ctx context.Context, snapID string, fn func() error) (collapsed bool, err error) {
+	c.mu.Lock()
+	if c.calls == nil {
+		c.calls = make(map[string]*conversionCall)
+	}
+	if call, ok := c.calls[snapID]; ok {
+		call.waiters++
+		c.mu.Unlock()
+		log.G(ctx).Debugf("conversion of layer %s already in progress, waiting", snapID)
+		select {
+		case <-call.done:
+			return true, call.err
+		case <-ctx.Done():
+			return true, ctx.Err()
+		}
+	}
+	call := &conversionCall{done: make(chan struct{})}
+	c.calls[snapID] = call
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.calls, snapID)
+		waiters := call.waiters
+		c.mu.Unlock()
+		close(call.done)
+		if waiters > 0 {
+			log.G(ctx).Infof("conversion of layer %s collapsed %d concurrent requests", snapID, waiters)
+		}
+	}()
+	call.err = fn()
+	return false, call.err
+}
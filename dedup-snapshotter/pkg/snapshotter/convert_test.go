@@ -0,0 +1,78 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// TestConversionsCollapse 验证同一层的并发转换只执行一次,等待者拿到同一结果
+func TestConversionsCollapse(t *testing.T) {
+	var c conversions
+	var runs, collapsedCount int32
+	release := make(chan struct{})
+	started := make(chan struct{})
+	errBuild := errors.New("build failed")
+
+	var wg sync.WaitGroup
+	results := make([]error, 4)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, results[0] = c.do(context.Background(), "layer", func() error {
+			atomic.AddInt32(&runs, 1)
+			close(started)
+			<-release
+			return errBuild
+		})
+	}()
+	<-started
+
+	for i := 1; i < len(results); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			collapsed, err := c.do(context.Background(), "layer", func() error {
+				atomic.AddInt32(&runs, 1)
+				return nil
+			})
+			if collapsed {
+				atomic.AddInt32(&collapsedCount, 1)
+			}
+			results[i] = err
+		}(i)
+	}
+	for {
+		c.mu.Lock()
+		waiters := c.calls["layer"].waiters
+		c.mu.Unlock()
+		if waiters == len(results)-1 {
+			break
+		}
+		runtime.Gosched()
+	}
+	close(release)
+	wg.Wait()
+
+	if runs != 1 {
+		t.Errorf("conversion ran %d times, want 1", runs)
+	}
+	if collapsedCount != int32(len(results)-1) {
+		t.Errorf("collapsed %d conversions, want %d", collapsedCount, len(results)-1)
+	}
+	for i, err := range results {
+		if !errors.Is(err, errBuild) {
+			t.Errorf("result %d: got %v, want %v", i, err, errBuild)
+		}
+	}
+
+	// 进行中的转换结束后,新的调用重新执行
+	collapsed, err := c.do(context.Background(), "layer", func() error { return nil })
+	if collapsed || err != nil {
+		t.Errorf("expected fresh conversion, got collapsed=%v err=%v", collapsed, err)
+	}
+	t.Logf("✓ %d 个并发转换合并为 1 次", collapsedCount+1)
+}
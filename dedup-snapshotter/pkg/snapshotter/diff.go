@@ -0,0 +1,438 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd/archive"
+	"github.com/containerd/containerd/archive/compression"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/diff"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/labels"
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/pkg/epoch"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/storage"
+	"github.com/containerd/continuity/fs"
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/opid"
+	dedupStorage "github.com/opencloudos/dedup-snapshotter/pkg/storage"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/sys/unix"
+)
+
+// Differ 实现 containerd 的 diff.Applier 和 diff.Comparer。层直接解包到本快照服务的 upperdir 并
+// 立即切分转换为 EROFS;生成 diff 时只遍历 upperdir,不挂载比较整个文件系统。
+// 不是本快照服务返回的挂载时返回 ErrNotImplemented,containerd 会交给下一个 differ
+type Differ struct {
+	sn      *Snapshotter
+	content content.Store
+}
+
+// NewDiffer 创建 Differ,content 为 containerd 的 content 服务
+func NewDiffer(sn *Snapshotter, content content.Store) *Differ {
+	return &Differ{sn: sn, content: content}
+}
+
+var errNotOurs = fmt.Errorf("mounts are not managed by dedup snapshotter: %w", errdefs.ErrNotImplemented)
+
+// Apply 把层解包到快照的 upperdir,返回的描述符摘要为未压缩层的 diff ID
+func (d *Differ) Apply(ctx context.Context, desc ocispec.Descriptor, mounts []mount.Mount, opts ...diff.ApplyOpt) (ocispec.Descriptor, error) {
+	ctx = opid.Start(ctx, "diff_apply")
+	var config diff.ApplyConfig
+	for _, opt := range opts {
+		if err := opt(ctx, desc, &config); err != nil {
+			return ocispec.Descriptor{}, err
+		}
+	}
+	if len(config.ProcessorPayloads) > 0 {
+		return ocispec.Descriptor{}, fmt.Errorf("stream processors not supported: %w", errdefs.ErrNotImplemented)
+	}
+	if _, err := images.DiffCompression(ctx, desc.MediaType); err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("unsupported media type %s: %w", desc.MediaType, errdefs.ErrNotImplemented)
+	}
+
+	om, ok := parseOverlayMount(mounts)
+	if !ok {
+		return ocispec.Descriptor{}, errNotOurs
+	}
+	id, ok := d.sn.storage.SnapshotForUpper(om.upper)
+	if !ok {
+		return ocispec.Descriptor{}, errNotOurs
+	}
+
+	start := time.Now()
+	ctx = withNamespace(ctx)
+	ra, err := d.content.ReaderAt(ctx, desc)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("failed to get reader from content store: %w", err)
+	}
+	defer ra.Close()
+
+	ds, err := compression.DecompressStream(content.NewReader(ra))
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	defer ds.Close()
+
+	digester := digest.Canonical.Digester()
+	rc := &countingReader{r: io.TeeReader(ds, digester.Hash())}
+	applyOpts := []archive.ApplyOpt{archive.WithConvertWhiteout(archive.OverlayConvertWhiteout)}
+	if len(om.lowers) > 0 {
+		applyOpts = append(applyOpts, archive.WithParents(om.lowers))
+	}
+	if _, err := archive.Apply(ctx, om.upper, rc, applyOpts...); err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("failed to apply layer %s: %w", desc.Digest, err)
+	}
+	// 读完 tar 结尾的填充,diff ID 需覆盖整个未压缩流
+	if _, err := io.Copy(io.Discard, rc); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	strategy := d.sn.mountStrategyOf(ctx, id)
+	if strategy == dedupStorage.MountStrategyErofs {
+		// 增量切分已算好的大文件哈希在转换时复用,之后的 Commit 不再转换
+		d.sn.storage.StopIncrementalChunking(id)
+		if err := d.sn.autoConvertLayer(ctx, id, nil); err != nil {
+			log.G(ctx).WithError(err).Warnf("convert applied layer %s failed, will use fallback", id)
+		}
+	}
+	d.sn.observe("apply", len(om.lowers), strategy, start)
+
+	log.G(ctx).Debugf("applied layer %s to snapshot %s in %s", desc.Digest, id, time.Since(start))
+	return ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayer,
+		Size:      rc.n,
+		Digest:    digester.Digest(),
+	}, nil
+}
+
+// Compare 把 upper 相对 lower 的变化写入 content store。只处理 lower 正是 upper 父链的情况
+// (父快照的 view 或无父快照),此时变化即 upperdir 的内容
+func (d *Differ) Compare(ctx context.Context, lower, upper []mount.Mount, opts ...diff.Opt) (ocispec.Descriptor, error) {
+	ctx = opid.Start(ctx, "diff_compare")
+	var config diff.Config
+	for _, opt := range opts {
+		if err := opt(&config); err != nil {
+			return ocispec.Descriptor{}, err
+		}
+	}
+	if tm := epoch.FromContext(ctx); tm != nil && config.SourceDateEpoch == nil {
+		config.SourceDateEpoch = tm
+	}
+
+	um, ok := parseOverlayMount(upper)
+	if !ok {
+		return ocispec.Descriptor{}, errNotOurs
+	}
+	if _, ok := d.sn.storage.SnapshotForUpper(um.upper); !ok || !isParentView(lower, um.lowers) {
+		return ocispec.Descriptor{}, errNotOurs
+	}
+
+	compressed := true
+	if config.Compressor != nil {
+		if config.MediaType == "" {
+			return ocispec.Descriptor{}, errors.New("media type must be explicitly specified when using custom compressor")
+		}
+	} else {
+		if config.MediaType == "" {
+			config.MediaType = ocispec.MediaTypeImageLayerGzip
+		}
+		switch config.MediaType {
+		case ocispec.MediaTypeImageLayer:
+			compressed = false
+		case ocispec.MediaTypeImageLayerGzip:
+			config.Compressor = func(w io.Writer, _ string) (io.WriteCloser, error) {
+				return compression.CompressStream(w, compression.Gzip)
+			}
+		default:
+			return ocispec.Descriptor{}, fmt.Errorf("unsupported diff media type: %v: %w", config.MediaType, errdefs.ErrNotImplemented)
+		}
+	}
+
+	var cwOpts []archive.ChangeWriterOpt
+	if config.SourceDateEpoch != nil {
+		cwOpts = append(cwOpts, archive.WithModTimeUpperBound(*config.SourceDateEpoch), archive.WithWhiteoutTime(*config.SourceDateEpoch))
+	}
+
+	ctx = withNamespace(ctx)
+	newReference := config.Reference == ""
+	if newReference {
+		config.Reference = fmt.Sprintf("dedup-diff-%d", time.Now().UnixNano())
+	}
+	w, err := d.content.Writer(ctx, content.WithRef(config.Reference), content.WithDescriptor(ocispec.Descriptor{MediaType: config.MediaType}))
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("failed to open writer: %w", err)
+	}
+	committed := false
+	defer func() {
+		if committed {
+			return
+		}
+		w.Close()
+		if newReference {
+			if err := d.content.Abort(ctx, config.Reference); err != nil {
+				log.G(ctx).WithError(err).WithField("ref", config.Reference).Warn("failed to delete diff upload")
+			}
+		}
+	}()
+	if !newReference {
+		if err := w.Truncate(0); err != nil {
+			return ocispec.Descriptor{}, err
+		}
+	}
+
+	if config.Labels == nil {
+		config.Labels = map[string]string{}
+	}
+	if compressed {
+		cw, err := config.Compressor(w, config.MediaType)
+		if err != nil {
+			return ocispec.Descriptor{}, fmt.Errorf("failed to get compressed stream: %w", err)
+		}
+		dgstr := digest.SHA256.Digester()
+		err = writeUpperDiff(ctx, io.MultiWriter(cw, dgstr.Hash()), um.upper, um.lowers, cwOpts...)
+		cw.Close()
+		if err != nil {
+			return ocispec.Descriptor{}, fmt.Errorf("failed to write compressed diff: %w", err)
+		}
+		config.Labels[labels.LabelUncompressed] = dgstr.Digest().String()
+	} else {
+		dgstr := digest.SHA256.Digester()
+		if err := writeUpperDiff(ctx, io.MultiWriter(w, dgstr.Hash()), um.upper, um.lowers, cwOpts...); err != nil {
+			return ocispec.Descriptor{}, fmt.Errorf("failed to write diff: %w", err)
+		}
+		config.Labels[labels.LabelUncompressed] = dgstr.Digest().String()
+	}
+
+	dgst := w.Digest()
+	if err := w.Commit(ctx, 0, dgst, content.WithLabels(config.Labels)); err != nil && !errdefs.IsAlreadyExists(err) {
+		return ocispec.Descriptor{}, fmt.Errorf("failed to commit: %w", err)
+	}
+	committed = true
+
+	info, err := d.content.Info(ctx, dgst)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("failed to get info from content store: %w", err)
+	}
+	// 已存在的 blob 可能缺少未压缩摘要标签
+	if _, ok := info.Labels[labels.LabelUncompressed]; !ok {
+		if info.Labels == nil {
+			info.Labels = map[string]string{}
+		}
+		info.Labels[labels.LabelUncompressed] = config.Labels[labels.LabelUncompressed]
+		if _, err := d.content.Update(ctx, info, "labels."+labels.LabelUncompressed); err != nil {
+			return ocispec.Descriptor{}, fmt.Errorf("error setting uncompressed label: %w", err)
+		}
+	}
+
+	return ocispec.Descriptor{
+		MediaType: config.MediaType,
+		Size:      info.Size,
+		Digest:    info.Digest,
+	}, nil
+}
+
+// withNamespace 把 gRPC 请求中的 containerd 命名空间带到对 content 服务的调用上
+func withNamespace(ctx context.Context) context.Context {
+	if ns, ok := namespaces.Namespace(ctx); ok {
+		return namespaces.WithNamespace(ctx, ns)
+	}
+	return ctx
+}
+
+// mountStrategyOf 返回快照 id 的挂载方式,查不到时按默认的 EROFS 处理
+func (s *Snapshotter) mountStrategyOf(ctx context.Context, id string) string {
+	ctx, t, err := s.ms.TransactionContext(ctx, false)
+	if err != nil {
+		return dedupStorage.MountStrategyErofs
+	}
+	defer t.Rollback()
+
+	strategy := dedupStorage.MountStrategyErofs
+	errFound := errors.New("found")
+	storage.WalkInfo(ctx, func(ctx context.Context, info snapshots.Info) error {
+		sid, _, _, err := storage.GetInfo(ctx, info.Name)
+		if err != nil || sid != id {
+			return nil
+		}
+		if v, err := dedupStorage.ParseMountStrategy(info.Labels); err == nil {
+			strategy = v
+		}
+		return errFound
+	})
+	return strategy
+}
+
+type overlayMount struct {
+	upper  string
+	lowers []string
+}
+
+// parseOverlayMount 解析单个 overlay 挂载的 upperdir 和 lowerdir
+func parseOverlayMount(mounts []mount.Mount) (overlayMount, bool) {
+	var om overlayMount
+	if len(mounts) != 1 || mounts[0].Type != "overlay" {
+		return om, false
+	}
+	for _, opt := range mounts[0].Options {
+		if v, ok := strings.CutPrefix(opt, "upperdir="); ok {
+			om.upper = v
+		} else if v, ok := strings.CutPrefix(opt, "lowerdir="); ok {
+			om.lowers = strings.Split(v, ":")
+		}
+	}
+	return om, om.upper != ""
+}
+
+// isParentView 判断 lower 是否为 lowerdir 相同且 upperdir 为空的 overlay(即父快照的 view)
+func isParentView(lower []mount.Mount, lowers []string) bool {
+	lm, ok := parseOverlayMount(lower)
+	if !ok || !slices.Equal(lm.lowers, lowers) {
+		return false
+	}
+	entries, err := os.ReadDir(lm.upper)
+	return err == nil && len(entries) == 0
+}
+
+// writeUpperDiff 把 overlay upperdir 转换为 OCI 层:whiteout 设备转为 .wh. 文件,
+// 不透明目录中下层存在而上层没有的条目逐个写入 whiteout
+func writeUpperDiff(ctx context.Context, w io.Writer, upper string, lowers []string, opts ...archive.ChangeWriterOpt) error {
+	cw := archive.NewChangeWriter(w, upper, opts...)
+	err := filepath.Walk(upper, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(upper, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		p := string(filepath.Separator) + rel
+
+		if isWhiteout(fi) {
+			return cw.HandleChange(fs.ChangeKindDelete, p, nil, nil)
+		}
+		if !fi.IsDir() {
+			if v, err := overlayXattr(path, "metacopy"); err != nil || v != "" {
+				return unsupportedUpper(path, "metacopy", err)
+			}
+			return cw.HandleChange(fs.ChangeKindModify, p, fi, nil)
+		}
+
+		if v, err := overlayXattr(path, "redirect"); err != nil || v != "" {
+			return unsupportedUpper(path, "redirect", err)
+		}
+		if err := cw.HandleChange(fs.ChangeKindModify, p, fi, nil); err != nil {
+			return err
+		}
+		opaque, err := overlayXattr(path, "opaque")
+		if err != nil || opaque != "y" {
+			return err
+		}
+		hidden, err := hiddenEntries(path, rel, lowers)
+		if err != nil {
+			return err
+		}
+		for _, name := range hidden {
+			if err := cw.HandleChange(fs.ChangeKindDelete, filepath.Join(p, name), nil, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return cw.Close()
+}
+
+// hiddenEntries 返回不透明目录 rel 在下层中存在、在上层 dir 中不存在的条目
+func hiddenEntries(dir, rel string, lowers []string) ([]string, error) {
+	present := make(map[string]bool)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		present[e.Name()] = true
+	}
+
+	var hidden []string
+	for _, lower := range lowers {
+		entries, err := os.ReadDir(filepath.Join(lower, rel))
+		if err != nil {
+			if os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR) {
+				continue
+			}
+			return nil, err
+		}
+		for _, e := range entries {
+			if !present[e.Name()] {
+				present[e.Name()] = true
+				hidden = append(hidden, e.Name())
+			}
+		}
+	}
+	slices.Sort(hidden)
+	return hidden, nil
+}
+
+func isWhiteout(fi os.FileInfo) bool {
+	if fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && st.Rdev == 0
+}
+
+// overlayXattr 读取 trusted.overlay.<name>,不存在时返回空串
+func overlayXattr(path, name string) (string, error) {
+	attr := "trusted.overlay." + name
+	size, err := unix.Lgetxattr(path, attr, nil)
+	if err == unix.ENODATA || err == unix.ENOTSUP {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, size)
+	n, err := unix.Lgetxattr(path, attr, buf)
+	if err != nil {
+		return "", err
+	}
+	return string(buf[:n]), nil
+}
+
+// unsupportedUpper 报告 upperdir 使用了无法直接转换的 overlay 特性,由 containerd 回退到挂载比较
+func unsupportedUpper(path, feature string, err error) error {
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%s uses overlay %s: %w", path, feature, errdefs.ErrNotImplemented)
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
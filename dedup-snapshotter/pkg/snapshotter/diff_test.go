@@ -0,0 +1,95 @@
+package snapshotter
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/containerd/containerd/mount"
+	"golang.org/x/sys/unix"
+)
+
+// TestWriteUpperDiff 验证 upperdir 转换为 OCI 层:whiteout 设备和不透明目录转为 .wh. 条目
+func TestWriteUpperDiff(t *testing.T) {
+	upper, lower := t.TempDir(), t.TempDir()
+
+	os.MkdirAll(filepath.Join(lower, "etc", "conf.d"), 0755)
+	os.WriteFile(filepath.Join(lower, "etc", "conf.d", "old.conf"), []byte("old"), 0644)
+	os.WriteFile(filepath.Join(lower, "etc", "conf.d", "kept.conf"), []byte("lower"), 0644)
+	os.WriteFile(filepath.Join(lower, "removed"), []byte("x"), 0644)
+
+	os.MkdirAll(filepath.Join(upper, "etc", "conf.d"), 0755)
+	os.WriteFile(filepath.Join(upper, "etc", "conf.d", "kept.conf"), []byte("upper"), 0644)
+	os.WriteFile(filepath.Join(upper, "app"), []byte("binary"), 0755)
+	if err := unix.Mknod(filepath.Join(upper, "removed"), unix.S_IFCHR, 0); err != nil {
+		t.Skipf("cannot create whiteout device: %v", err)
+	}
+	if err := unix.Lsetxattr(filepath.Join(upper, "etc", "conf.d"), "trusted.overlay.opaque", []byte("y"), 0); err != nil {
+		t.Skipf("cannot set trusted xattr: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeUpperDiff(context.Background(), &buf, upper, []string{lower}); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+
+	for _, want := range []string{"app", "etc/conf.d/kept.conf", "etc/conf.d/.wh.old.conf", ".wh.removed"} {
+		if !slices.Contains(names, want) {
+			t.Errorf("missing %s in diff %v", want, names)
+		}
+	}
+	if slices.Contains(names, "etc/conf.d/.wh.kept.conf") {
+		t.Errorf("file present in upper should not be whited out: %v", names)
+	}
+	t.Logf("✓ diff 条目 %v", names)
+}
+
+// TestIsParentView 验证只有 lowerdir 相同且 upperdir 为空的 view 才能直接用 upperdir 生成 diff
+func TestIsParentView(t *testing.T) {
+	empty, dirty := t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(dirty, "f"), nil, 0644)
+
+	view := func(upper string, lowers string) []mount.Mount {
+		opts := []string{"upperdir=" + upper, "workdir=/w"}
+		if lowers != "" {
+			opts = append(opts, "lowerdir="+lowers)
+		}
+		return []mount.Mount{{Type: "overlay", Source: "overlay", Options: opts}}
+	}
+
+	cases := []struct {
+		lower  []mount.Mount
+		lowers []string
+		want   bool
+	}{
+		{view(empty, "/a:/b"), []string{"/a", "/b"}, true},
+		{view(empty, ""), nil, true},
+		{view(dirty, "/a:/b"), []string{"/a", "/b"}, false},
+		{view(empty, "/a"), []string{"/a", "/b"}, false},
+		{[]mount.Mount{{Type: "bind", Source: empty}}, nil, false},
+	}
+	for i, c := range cases {
+		if got := isParentView(c.lower, c.lowers); got != c.want {
+			t.Errorf("case %d: got %v, want %v", i, got, c.want)
+		}
+	}
+	t.Logf("✓ 父链 view 判断正确")
+}
@@ -0,0 +1,181 @@
+package snapshotter
+
+import (
+	"context"
+	"strings"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/storage"
+	"github.com/opencloudos/dedup-snapshotter/pkg/accounting"
+	dedupStorage "github.com/opencloudos/dedup-snapshotter/pkg/storage"
+)
+
+// usageGroups 是按镜像和命名空间划分的快照 ID 组
+type usageGroups struct {
+	// images[i] 的父链为 groups[i];holders 为容器快照的父链
+	images  []string
+	groups  [][]string
+	holders [][]string
+	// namespaces[i] 中的快照 ID 为 nsGroups[i],已去重
+	namespaces []string
+	nsGroups   [][]string
+}
+
+// collectUsageGroups 遍历快照元数据,把快照划分为镜像和命名空间。镜像为不是其他已提交快照父快照的
+// 已提交快照及其父链
+func (s *Snapshotter) collectUsageGroups(ctx context.Context) (*usageGroups, error) {
+	ctx, t, err := s.ms.TransactionContext(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	defer t.Rollback()
+
+	infos := make(map[string]snapshots.Info)
+	ids := make(map[string]string)
+	var names []string
+	err = storage.WalkInfo(ctx, func(ctx context.Context, info snapshots.Info) error {
+		id, _, _, err := storage.GetInfo(ctx, info.Name)
+		if err != nil {
+			return err
+		}
+		infos[info.Name] = info
+		ids[info.Name] = id
+		names = append(names, info.Name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	chain := func(name string) []string {
+		var chain []string
+		for name != "" {
+			info, ok := infos[name]
+			if !ok {
+				break
+			}
+			chain = append(chain, ids[name])
+			name = info.Parent
+		}
+		return chain
+	}
+
+	hasCommittedChild := make(map[string]bool)
+	for _, info := range infos {
+		if info.Kind == snapshots.KindCommitted && info.Parent != "" {
+			hasCommittedChild[info.Parent] = true
+		}
+	}
+
+	g := &usageGroups{}
+	namespaces := make(map[string][]string)
+	for _, name := range names {
+		ns, _, _ := strings.Cut(name, "/")
+		if _, ok := namespaces[ns]; !ok {
+			g.namespaces = append(g.namespaces, ns)
+		}
+		namespaces[ns] = append(namespaces[ns], chain(name)...)
+
+		info := infos[name]
+		switch {
+		case info.Kind != snapshots.KindCommitted:
+			g.holders = append(g.holders, chain(name))
+		case !hasCommittedChild[name]:
+			g.images = append(g.images, name)
+			g.groups = append(g.groups, chain(name))
+		}
+	}
+
+	g.nsGroups = make([][]string, len(g.namespaces))
+	for i, ns := range g.namespaces {
+		seen := make(map[string]bool)
+		for _, id := range namespaces[ns] {
+			if !seen[id] {
+				seen[id] = true
+				g.nsGroups[i] = append(g.nsGroups[i], id)
+			}
+		}
+	}
+	return g, nil
+}
+
+// DedupUsage 按镜像和命名空间统计独占和共享的 chunk 空间。
+// 容器(active/view 快照)使用的父链不会因删除镜像而释放,计为共享
+func (s *Snapshotter) DedupUsage(ctx context.Context) (*dedupStorage.UsageReport, error) {
+	g, err := s.collectUsageGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &dedupStorage.UsageReport{Images: []dedupStorage.UsageEntry{}, Namespaces: []dedupStorage.UsageEntry{}}
+	usage, err := s.storage.GroupUsage(append(g.groups, g.holders...))
+	if err != nil {
+		return nil, err
+	}
+	for i, name := range g.images {
+		ns, _, _ := strings.Cut(name, "/")
+		report.Images = append(report.Images, dedupStorage.UsageEntry{Name: name, Namespace: ns, Layers: len(g.groups[i]), GroupUsage: usage[i]})
+	}
+
+	usage, err = s.storage.GroupUsage(g.nsGroups)
+	if err != nil {
+		return nil, err
+	}
+	for i, ns := range g.namespaces {
+		report.Namespaces = append(report.Namespaces, dedupStorage.UsageEntry{Name: ns, Layers: len(g.nsGroups[i]), GroupUsage: usage[i]})
+	}
+
+	dedupStorage.SortUsage(report.Images)
+	dedupStorage.SortUsage(report.Namespaces)
+	return report, nil
+}
+
+// Chargeback 按镜像和命名空间汇总统计窗口内下载和从缓存提供的字节数,以及当前独占的空间。
+// previous 为 true 时报告上一个已关闭的窗口
+func (s *Snapshotter) Chargeback(ctx context.Context, previous bool) (*dedupStorage.ChargebackReport, error) {
+	window, err := s.storage.AccountingWindow(previous)
+	if err != nil {
+		return nil, err
+	}
+	g, err := s.collectUsageGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// 未启用 EROFS 时没有 chunk 引用,独占空间记为 0
+	imageUsage, _ := s.storage.GroupUsage(append(g.groups, g.holders...))
+	nsUsage, _ := s.storage.GroupUsage(g.nsGroups)
+
+	report := &dedupStorage.ChargebackReport{
+		Start:      window.Start,
+		End:        window.End,
+		Images:     []dedupStorage.ChargebackEntry{},
+		Namespaces: []dedupStorage.ChargebackEntry{},
+	}
+	for i, name := range g.images {
+		ns, _, _ := strings.Cut(name, "/")
+		traffic := s.storage.LayerTraffic(window, g.groups[i])
+		entry := dedupStorage.ChargebackEntry{Name: name, Namespace: ns, Layers: len(g.groups[i]), DownloadedBytes: traffic.DownloadedBytes, CacheServedBytes: traffic.CacheServedBytes}
+		if imageUsage != nil {
+			entry.ExclusiveBytes = imageUsage[i].ExclusiveSize
+		}
+		report.Images = append(report.Images, entry)
+	}
+	for i, ns := range g.namespaces {
+		traffic := s.storage.LayerTraffic(window, g.nsGroups[i])
+		entry := dedupStorage.ChargebackEntry{Name: ns, Layers: len(g.nsGroups[i]), DownloadedBytes: traffic.DownloadedBytes, CacheServedBytes: traffic.CacheServedBytes}
+		if nsUsage != nil {
+			entry.ExclusiveBytes = nsUsage[i].ExclusiveSize
+		}
+		report.Namespaces = append(report.Namespaces, entry)
+	}
+
+	dedupStorage.SortChargeback(report.Images)
+	dedupStorage.SortChargeback(report.Namespaces)
+	return report, nil
+}
+
+// ResetChargeback 关闭进行中的统计窗口并开始新窗口
+func (s *Snapshotter) ResetChargeback() (*accounting.Window, error) {
+	return s.storage.ResetAccounting()
+}
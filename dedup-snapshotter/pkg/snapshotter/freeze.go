@@ -0,0 +1,316 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/storage"
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/erofs"
+)
+
+// FreezeOptions 控制快照的静默方式:FSFreeze 为 true 时在写回 upperdir 后冻结容器的 overlay 挂载,
+// Timeout 为保持冻结的时间,为 0 时使用 timeouts.freeze
+type FreezeOptions struct {
+	FSFreeze bool
+	Timeout  time.Duration
+}
+
+// FrozenSnapshot 是为备份静默的活动快照,备份工具在 ExpiresAt 前复制 UpperDir 后解冻
+type FrozenSnapshot struct {
+	Key        string    `json:"key"`
+	ID         string    `json:"id"`
+	UpperDir   string    `json:"upper_dir"`
+	MountPoint string    `json:"mount_point,omitempty"`
+	FSFrozen   bool      `json:"fs_frozen"`
+	FrozenAt   time.Time `json:"frozen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type freeze struct {
+	info  FrozenSnapshot
+	timer *time.Timer
+}
+
+// freezes 记录已冻结的快照,key 为快照服务中的完整 key;值为 nil 表示正在静默
+type freezes struct {
+	mu     sync.Mutex
+	frozen map[string]*freeze
+}
+
+// FreezeSnapshot 静默活动快照:写回 upperdir 所在文件系统的脏数据,按需冻结 overlay 挂载,
+// 到期后自动解冻。key 可以是完整 key,也可以是 containerd 中的快照 key(通常为容器 ID)
+func (s *Snapshotter) FreezeSnapshot(ctx context.Context, key string, opts FreezeOptions) (*FrozenSnapshot, error) {
+	maxTimeout := time.Duration(s.configs.Get().Timeouts.Freeze) * time.Second
+	if opts.Timeout < 0 || opts.Timeout > maxTimeout {
+		return nil, fmt.Errorf("freeze timeout must be between 0 and %s: %w", maxTimeout, errdefs.ErrInvalidArgument)
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = maxTimeout
+	}
+
+	name, id, err := s.resolveActive(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	s.freezes.mu.Lock()
+	if s.freezes.frozen == nil {
+		s.freezes.frozen = make(map[string]*freeze)
+	}
+	if _, ok := s.freezes.frozen[name]; ok {
+		s.freezes.mu.Unlock()
+		return nil, fmt.Errorf("snapshot %s is already frozen: %w", name, errdefs.ErrAlreadyExists)
+	}
+	s.freezes.frozen[name] = nil
+	s.freezes.mu.Unlock()
+
+	info := FrozenSnapshot{
+		Key:      name,
+		ID:       id,
+		UpperDir: filepath.Join(s.storage.GetSnapshotPath(id), "fs"),
+	}
+	mountPoint, err := s.quiesce(info.UpperDir, opts.FSFreeze)
+	if err != nil {
+		s.freezes.mu.Lock()
+		delete(s.freezes.frozen, name)
+		s.freezes.mu.Unlock()
+		return nil, err
+	}
+	info.MountPoint, info.FSFrozen = mountPoint, mountPoint != ""
+	info.FrozenAt = time.Now()
+	info.ExpiresAt = info.FrozenAt.Add(opts.Timeout)
+
+	f := &freeze{info: info}
+	s.freezes.mu.Lock()
+	if s.freezes.frozen == nil {
+		// 静默期间快照服务已关闭
+		s.freezes.mu.Unlock()
+		thaw(info)
+		return nil, fmt.Errorf("snapshotter is closing: %w", errdefs.ErrUnavailable)
+	}
+	s.freezes.frozen[name] = f
+	f.timer = time.AfterFunc(opts.Timeout, func() { s.expireFreeze(name, f) })
+	s.freezes.mu.Unlock()
+
+	log.G(ctx).Infof("froze snapshot %s for %s (fsfreeze: %v)", name, opts.Timeout, info.FSFrozen)
+	return &info, nil
+}
+
+// ThawSnapshot 解冻快照,返回解冻前的冻结信息
+func (s *Snapshotter) ThawSnapshot(ctx context.Context, key string) (*FrozenSnapshot, error) {
+	s.freezes.mu.Lock()
+	name, f := s.lookupFreeze(key)
+	if f == nil {
+		s.freezes.mu.Unlock()
+		return nil, fmt.Errorf("snapshot %s is not frozen: %w", key, errdefs.ErrNotFound)
+	}
+	delete(s.freezes.frozen, name)
+	f.timer.Stop()
+	s.freezes.mu.Unlock()
+
+	if err := thaw(f.info); err != nil {
+		return nil, err
+	}
+	log.G(ctx).Infof("thawed snapshot %s", name)
+	return &f.info, nil
+}
+
+// FrozenSnapshots 列出已冻结的快照,按 key 排序
+func (s *Snapshotter) FrozenSnapshots() []FrozenSnapshot {
+	s.freezes.mu.Lock()
+	defer s.freezes.mu.Unlock()
+
+	result := make([]FrozenSnapshot, 0, len(s.freezes.frozen))
+	for _, f := range s.freezes.frozen {
+		if f != nil {
+			result = append(result, f.info)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result
+}
+
+// lookupFreeze 按完整 key 或唯一匹配的 containerd 快照 key 查找冻结记录,调用方持有 freezes.mu
+func (s *Snapshotter) lookupFreeze(key string) (string, *freeze) {
+	if f := s.freezes.frozen[key]; f != nil {
+		return key, f
+	}
+	var name string
+	var match *freeze
+	for n, f := range s.freezes.frozen {
+		if f != nil && strings.HasSuffix(n, "/"+key) {
+			if match != nil {
+				return "", nil
+			}
+			name, match = n, f
+		}
+	}
+	return name, match
+}
+
+// expireFreeze 在冻结到期后解冻快照并记入审计日志
+func (s *Snapshotter) expireFreeze(name string, f *freeze) {
+	s.freezes.mu.Lock()
+	if s.freezes.frozen[name] != f {
+		s.freezes.mu.Unlock()
+		return
+	}
+	delete(s.freezes.frozen, name)
+	s.freezes.mu.Unlock()
+
+	start := time.Now()
+	err := thaw(f.info)
+	if err != nil {
+		log.L.WithError(err).Errorf("failed to thaw expired freeze of snapshot %s", name)
+	} else {
+		log.L.Warnf("freeze of snapshot %s expired, thawed", name)
+	}
+	if s.auditLogger != nil {
+		result := "expired"
+		if err != nil {
+			result = "failure"
+		}
+		s.auditLogger.LogOperation(context.Background(), "snapshot_thaw", name, "system", os.Getpid(), f.info, result, err, time.Since(start))
+	}
+}
+
+// thawBeforeRemove 在删除快照前解冻它,避免卸载阻塞在冻结的挂载上
+func (s *Snapshotter) thawBeforeRemove(ctx context.Context, key string) {
+	s.freezes.mu.Lock()
+	f := s.freezes.frozen[key]
+	if f == nil {
+		s.freezes.mu.Unlock()
+		return
+	}
+	delete(s.freezes.frozen, key)
+	f.timer.Stop()
+	s.freezes.mu.Unlock()
+
+	if err := thaw(f.info); err != nil {
+		log.G(ctx).WithError(err).Errorf("failed to thaw snapshot %s before removal", key)
+		return
+	}
+	log.G(ctx).Warnf("thawed frozen snapshot %s before removal", key)
+}
+
+// thawAll 解冻全部快照,在关闭时调用
+func (s *Snapshotter) thawAll() {
+	s.freezes.mu.Lock()
+	frozen := s.freezes.frozen
+	s.freezes.frozen = nil
+	s.freezes.mu.Unlock()
+
+	for name, f := range frozen {
+		if f == nil {
+			continue
+		}
+		f.timer.Stop()
+		if err := thaw(f.info); err != nil {
+			log.L.WithError(err).Errorf("failed to thaw snapshot %s", name)
+		}
+	}
+}
+
+// quiesce 写回 upperDir 的脏数据,fsfreeze 为 true 时冻结其 overlay 挂载并返回挂载点。
+// 超过 timeouts.mount 时返回错误,之后才完成的冻结会立即解冻
+func (s *Snapshotter) quiesce(upperDir string, fsfreeze bool) (string, error) {
+	type result struct {
+		mountPoint string
+		err        error
+	}
+	done := make(chan result, 1)
+	go func() {
+		if err := erofs.SyncDir(upperDir); err != nil || !fsfreeze {
+			done <- result{err: err}
+			return
+		}
+		mountPoint, err := erofs.FindOverlayMount(upperDir)
+		switch {
+		case err != nil:
+		case mountPoint == "":
+			err = fmt.Errorf("upperdir %s is not mounted: %w", upperDir, errdefs.ErrFailedPrecondition)
+		default:
+			err = erofs.FreezeFS(mountPoint)
+			if errors.Is(err, erofs.ErrFreezeUnsupported) {
+				err = fmt.Errorf("%v: %w", err, errdefs.ErrNotImplemented)
+			}
+		}
+		if err != nil {
+			mountPoint = ""
+		}
+		done <- result{mountPoint: mountPoint, err: err}
+	}()
+
+	timeout := time.Duration(s.configs.Get().Timeouts.Mount) * time.Second
+	select {
+	case r := <-done:
+		return r.mountPoint, r.err
+	case <-time.After(timeout):
+		go func() {
+			if r := <-done; r.mountPoint != "" {
+				if err := erofs.ThawFS(r.mountPoint); err != nil {
+					log.L.WithError(err).Errorf("failed to thaw %s after timed out freeze", r.mountPoint)
+				}
+			}
+		}()
+		return "", fmt.Errorf("quiescing %s timed out after %s: %w", upperDir, timeout, context.DeadlineExceeded)
+	}
+}
+
+func thaw(info FrozenSnapshot) error {
+	if !info.FSFrozen {
+		return nil
+	}
+	return erofs.ThawFS(info.MountPoint)
+}
+
+// resolveActive 解析活动快照的完整 key 和 ID。不是完整 key 时按 "<namespace>/<n>/<key>" 的后缀唯一匹配
+func (s *Snapshotter) resolveActive(ctx context.Context, key string) (string, string, error) {
+	ctx, t, err := s.ms.TransactionContext(ctx, false)
+	if err != nil {
+		return "", "", err
+	}
+	defer t.Rollback()
+
+	if id, info, _, err := storage.GetInfo(ctx, key); err == nil {
+		if info.Kind != snapshots.KindActive {
+			return "", "", fmt.Errorf("snapshot %s is not active: %w", key, errdefs.ErrFailedPrecondition)
+		}
+		return key, id, nil
+	} else if !errdefs.IsNotFound(err) {
+		return "", "", err
+	}
+
+	var matches []string
+	err = storage.WalkInfo(ctx, func(ctx context.Context, info snapshots.Info) error {
+		if info.Kind == snapshots.KindActive && strings.HasSuffix(info.Name, "/"+key) {
+			matches = append(matches, info.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+	switch len(matches) {
+	case 0:
+		return "", "", fmt.Errorf("active snapshot %s: %w", key, errdefs.ErrNotFound)
+	case 1:
+	default:
+		return "", "", fmt.Errorf("snapshot key %s is ambiguous, use one of %s: %w", key, strings.Join(matches, ", "), errdefs.ErrInvalidArgument)
+	}
+	id, _, _, err := storage.GetInfo(ctx, matches[0])
+	if err != nil {
+		return "", "", err
+	}
+	return matches[0], id, nil
+}
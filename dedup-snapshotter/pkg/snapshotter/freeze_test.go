@@ -0,0 +1,68 @@
+package snapshotter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+)
+
+// TestFreezeSnapshot 验证按容器 key 冻结快照、重复冻结被拒绝、到期自动解冻和手动解冻
+func TestFreezeSnapshot(t *testing.T) {
+	root := t.TempDir()
+	s, err := NewSnapshotterWithConfig(root, config.DefaultConfig(root), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	if _, err := s.Prepare(ctx, "default/1/ctr", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	frozen, err := s.FreezeSnapshot(ctx, "ctr", FreezeOptions{Timeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frozen.Key != "default/1/ctr" || frozen.FSFrozen || frozen.UpperDir == "" {
+		t.Fatalf("unexpected freeze: %+v", frozen)
+	}
+	if _, err := s.FreezeSnapshot(ctx, "default/1/ctr", FreezeOptions{}); !errdefs.IsAlreadyExists(err) {
+		t.Fatalf("expected already frozen, got %v", err)
+	}
+	if n := len(s.FrozenSnapshots()); n != 1 {
+		t.Fatalf("expected 1 frozen snapshot, got %d", n)
+	}
+	t.Logf("✓ 按容器 key 冻结快照")
+
+	time.Sleep(300 * time.Millisecond)
+	if n := len(s.FrozenSnapshots()); n != 0 {
+		t.Fatalf("expected freeze to expire, got %d frozen", n)
+	}
+	t.Logf("✓ 到期自动解冻")
+
+	if _, err := s.FreezeSnapshot(ctx, "ctr", FreezeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ThawSnapshot(ctx, "ctr"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ThawSnapshot(ctx, "ctr"); !errdefs.IsNotFound(err) {
+		t.Fatalf("expected not frozen, got %v", err)
+	}
+	t.Logf("✓ 手动解冻")
+
+	if _, err := s.FreezeSnapshot(ctx, "ctr", FreezeOptions{Timeout: time.Hour}); !errdefs.IsInvalidArgument(err) {
+		t.Errorf("expected timeout above timeouts.freeze to be rejected, got %v", err)
+	}
+	if _, err := s.FreezeSnapshot(ctx, "ctr", FreezeOptions{FSFreeze: true}); !errdefs.IsFailedPrecondition(err) {
+		t.Errorf("expected fsfreeze of unmounted snapshot to fail, got %v", err)
+	}
+	if _, err := s.FreezeSnapshot(ctx, "missing", FreezeOptions{}); !errdefs.IsNotFound(err) {
+		t.Errorf("expected missing snapshot, got %v", err)
+	}
+	t.Logf("✓ 无效请求被拒绝")
+}
@@ -0,0 +1,70 @@
+package snapshotter
+
+import (
+	"strings"
+
+	"github.com/containerd/containerd/filters"
+	"github.com/containerd/containerd/snapshots"
+	dedupStorage "github.com/opencloudos/dedup-snapshotter/pkg/storage"
+)
+
+// withDedupLabels 把去重状态标签合并到快照信息中,不修改原有的 Labels map
+func (s *Snapshotter) withDedupLabels(id string, info snapshots.Info) snapshots.Info {
+	labels := make(map[string]string, len(info.Labels)+4)
+	for k, v := range info.Labels {
+		labels[k] = v
+	}
+	for k, v := range s.storage.SnapshotLabels(id) {
+		labels[k] = v
+	}
+	if strategy, err := dedupStorage.ParseMountStrategy(info.Labels); err == nil {
+		labels[dedupStorage.LabelMount] = strategy
+	}
+	info.Labels = labels
+	return info
+}
+
+// stripDedupLabels 去掉客户端回写的去重标签,这些标签每次读取时重新生成
+func stripDedupLabels(info snapshots.Info) snapshots.Info {
+	if len(info.Labels) == 0 {
+		return info
+	}
+	labels := make(map[string]string, len(info.Labels))
+	for k, v := range info.Labels {
+		if !dedupStorage.IsDedupLabel(k) {
+			labels[k] = v
+		}
+	}
+	info.Labels = labels
+	return info
+}
+
+// adaptInfo 与 containerd 快照元数据的过滤字段保持一致,使去重标签也可用于 Walk 过滤
+func adaptInfo(info snapshots.Info) filters.Adaptor {
+	return filters.AdapterFunc(func(fieldpath []string) (string, bool) {
+		if len(fieldpath) == 0 {
+			return "", false
+		}
+
+		switch fieldpath[0] {
+		case "kind":
+			switch info.Kind {
+			case snapshots.KindActive:
+				return "active", true
+			case snapshots.KindView:
+				return "view", true
+			case snapshots.KindCommitted:
+				return "committed", true
+			}
+		case "name":
+			return info.Name, true
+		case "parent":
+			return info.Parent, true
+		case "labels":
+			v, ok := info.Labels[strings.Join(fieldpath[1:], ".")]
+			return v, ok
+		}
+
+		return "", false
+	})
+}
@@ -0,0 +1,47 @@
+package snapshotter
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/storage"
+	dedupStorage "github.com/opencloudos/dedup-snapshotter/pkg/storage"
+)
+
+// snapshotIDs 返回元数据中全部快照的 ID
+func (s *Snapshotter) snapshotIDs(ctx context.Context) (map[string]bool, error) {
+	ctx, t, err := s.ms.TransactionContext(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	defer t.Rollback()
+
+	ids := make(map[string]bool)
+	err = storage.WalkInfo(ctx, func(ctx context.Context, info snapshots.Info) error {
+		id, _, _, err := storage.GetInfo(ctx, info.Name)
+		if err != nil {
+			return err
+		}
+		ids[id] = true
+		return nil
+	})
+	return ids, err
+}
+
+// LeakedMounts 列出不被元数据中任何快照引用的挂载和 loop 设备
+func (s *Snapshotter) LeakedMounts(ctx context.Context) ([]dedupStorage.LeakedMount, error) {
+	live, err := s.snapshotIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.storage.LeakedMounts(live)
+}
+
+// CleanupLeakedMounts 重新对照元数据,强制卸载 paths 中仍然泄漏的挂载并解除 loop 设备
+func (s *Snapshotter) CleanupLeakedMounts(ctx context.Context, paths []string) ([]dedupStorage.LeakedMount, error) {
+	live, err := s.snapshotIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.storage.CleanupLeakedMounts(ctx, live, paths)
+}
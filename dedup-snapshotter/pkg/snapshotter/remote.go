@@ -0,0 +1,72 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/storage"
+	"github.com/containerd/log"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// containerd 解包镜像时在 Prepare 的标签中带上目标快照名、层 digest 和镜像引用
+// (CRI 需开启 snapshot annotations)
+const (
+	targetRefLabel   = "containerd.io/snapshot.ref"
+	layerDigestLabel = "containerd.io/snapshot/cri.layer-digest"
+	imageRefLabel    = "containerd.io/snapshot/cri.image-ref"
+)
+
+// prepareRemote 处理已由 dedup-cri 物化的层:直接提交目标快照并返回 ErrAlreadyExists,
+// containerd 据此跳过该层的下载和解包。返回 false 表示不是远程快照,按普通 Prepare 处理
+func (s *Snapshotter) prepareRemote(ctx context.Context, key, parent string, opts ...snapshots.Opt) (bool, error) {
+	var base snapshots.Info
+	for _, opt := range opts {
+		if err := opt(&base); err != nil {
+			// 交给普通 Prepare 报告选项错误
+			return false, nil
+		}
+	}
+
+	target := base.Labels[targetRefLabel]
+	dgst, err := digest.Parse(base.Labels[layerDigestLabel])
+	if target == "" || err != nil {
+		return false, nil
+	}
+	layerID := dgst.Encoded()
+	if !s.storage.HasLayer(layerID) {
+		return false, nil
+	}
+
+	ctx, t, err := s.ms.TransactionContext(ctx, true)
+	if err != nil {
+		return true, err
+	}
+	defer func() {
+		if err != nil {
+			t.Rollback()
+		}
+	}()
+
+	snap, err := storage.CreateSnapshot(ctx, snapshots.KindActive, key, parent, opts...)
+	if err != nil {
+		return true, err
+	}
+	if err = s.storage.Prepare(ctx, snap.ID, snap.ParentIDs); err != nil {
+		return true, err
+	}
+	if err = s.storage.UseLayerImage(snap.ID, layerID); err != nil {
+		return true, err
+	}
+	if _, err = storage.CommitActive(ctx, key, target, snapshots.Usage{}, opts...); err != nil {
+		return true, err
+	}
+	if err = t.Commit(); err != nil {
+		return true, err
+	}
+
+	log.G(ctx).Infof("layer %s already materialized, committed snapshot %s", dgst, target)
+	return true, fmt.Errorf("target snapshot %q: %w", target, errdefs.ErrAlreadyExists)
+}
@@ -5,23 +5,39 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/filters"
 	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/namespaces"
 	"github.com/containerd/containerd/snapshots"
 	"github.com/containerd/containerd/snapshots/storage"
 	"github.com/containerd/log"
 	"github.com/opencloudos/dedup-snapshotter/pkg/audit"
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+	"github.com/opencloudos/dedup-snapshotter/pkg/metrics"
+	"github.com/opencloudos/dedup-snapshotter/pkg/opid"
+	"github.com/opencloudos/dedup-snapshotter/pkg/scan"
+	"github.com/opencloudos/dedup-snapshotter/pkg/slowlog"
 	dedupStorage "github.com/opencloudos/dedup-snapshotter/pkg/storage"
 )
 
 type Snapshotter struct {
 	ms             *storage.MetaStore
 	storage        *dedupStorage.DedupStore
+	configs        *config.Source
 	root           string
 	activeMounts   map[string]bool
 	activeMountsMu sync.RWMutex
 	auditLogger    *audit.AuditLogger
+	metrics        *metrics.Metrics
+	conversions    conversions
+	freezes        freezes
+	// cancel 在 Close 时中止启动时的快照恢复和 chunk 校验等后台遍历
+	cancel context.CancelFunc
 }
 
 func NewSnapshotter(root string) (snapshots.Snapshotter, error) {
@@ -29,52 +45,121 @@ func NewSnapshotter(root string) (snapshots.Snapshotter, error) {
 }
 
 func NewSnapshotterWithAudit(root string, auditLogger *audit.AuditLogger) (snapshots.Snapshotter, error) {
-	ms, err := storage.NewMetaStore(root)
+	return NewSnapshotterWithMetrics(root, auditLogger, nil)
+}
+
+func NewSnapshotterWithMetrics(root string, auditLogger *audit.AuditLogger, m *metrics.Metrics) (*Snapshotter, error) {
+	return NewSnapshotterWithConfig(root, config.DefaultConfig(root), auditLogger, m)
+}
+
+func NewSnapshotterWithConfig(root string, cfg *config.Config, auditLogger *audit.AuditLogger, m *metrics.Metrics) (*Snapshotter, error) {
+	return NewSnapshotterWithSource(root, config.NewSource(cfg), auditLogger, m)
+}
+
+// NewSnapshotterWithSource 创建使用共享配置源的快照服务,API 和配置 watcher 的更新对存储立即可见
+func NewSnapshotterWithSource(root string, source *config.Source, auditLogger *audit.AuditLogger, m *metrics.Metrics) (*Snapshotter, error) {
+	cfg := source.Get()
+	dedupStore, err := dedupStorage.NewDedupStoreWithSource(root, source)
 	if err != nil {
 		return nil, err
 	}
 
-	dedupStore, err := dedupStorage.NewDedupStore(root)
+	// 不可变存储的快照元数据库位于 writable_dir,首次启动时从预制的元数据库复制
+	metaPath := filepath.Join(root, dedupStorage.MetadataFile)
+	if cfg.Store.Immutable {
+		if metaPath, err = dedupStorage.ImmutableMetadataPath(root, cfg.Store.WritableDir); err != nil {
+			dedupStore.Close()
+			return nil, err
+		}
+	}
+	ms, err := storage.NewMetaStore(metaPath)
 	if err != nil {
+		dedupStore.Close()
 		return nil, err
 	}
 
-	ctx := context.Background()
+	if m != nil {
+		dedupStore.SetMetrics(m)
+	}
+	if auditLogger != nil {
+		dedupStore.OnFscacheFallback(func(e dedupStorage.FallbackEvent) {
+			result := "success"
+			var err error
+			if e.Error != "" {
+				result, err = "failure", fmt.Errorf("%s", e.Error)
+			}
+			auditLogger.LogOperation(context.Background(), "fscache_"+e.Action, e.Image, "dedupd", os.Getpid(), e, result, err, 0)
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
 	if err := dedupStore.RecoverSnapshots(ctx); err != nil {
 		log.L.WithError(err).Warn("snapshot recovery failed")
 	}
 
-	if err := dedupStore.VerifyChunks(ctx); err != nil {
+	if cfg.Recovery.Background {
+		go func() {
+			if err := dedupStore.VerifyChunks(ctx); err != nil {
+				log.L.WithError(err).Warn("chunk verification failed")
+			}
+		}()
+	} else if err := dedupStore.VerifyChunks(ctx); err != nil {
 		log.L.WithError(err).Warn("chunk verification failed")
 	}
 
 	return &Snapshotter{
 		ms:           ms,
 		storage:      dedupStore,
+		configs:      source,
 		root:         root,
 		activeMounts: make(map[string]bool),
 		auditLogger:  auditLogger,
+		metrics:      m,
+		cancel:       cancel,
 	}, nil
 }
 
+func (s *Snapshotter) Store() *dedupStorage.DedupStore {
+	return s.storage
+}
+
 func (s *Snapshotter) Stat(ctx context.Context, key string) (snapshots.Info, error) {
+	ctx = opid.Start(ctx, "stat")
 	ctx, t, err := s.ms.TransactionContext(ctx, false)
 	if err != nil {
 		return snapshots.Info{}, err
 	}
 	defer t.Rollback()
 
-	_, info, _, err := storage.GetInfo(ctx, key)
-	return info, err
+	id, info, _, err := storage.GetInfo(ctx, key)
+	if err != nil {
+		return snapshots.Info{}, err
+	}
+	return s.withDedupLabels(id, info), nil
 }
 
 func (s *Snapshotter) Update(ctx context.Context, info snapshots.Info, fieldpaths ...string) (snapshots.Info, error) {
+	ctx = opid.Start(ctx, "update")
 	ctx, t, err := s.ms.TransactionContext(ctx, true)
 	if err != nil {
 		return snapshots.Info{}, err
 	}
 
-	info, err = storage.UpdateInfo(ctx, info, fieldpaths...)
+	info, err = storage.UpdateInfo(ctx, stripDedupLabels(info), fieldpaths...)
+	if err != nil {
+		t.Rollback()
+		return snapshots.Info{}, err
+	}
+	if _, err := dedupStorage.ParseMountStrategy(info.Labels); err != nil {
+		t.Rollback()
+		return snapshots.Info{}, fmt.Errorf("%v: %w", err, errdefs.ErrInvalidArgument)
+	}
+	if _, err := dedupStorage.ParseTraceProfile(info.Labels); err != nil {
+		t.Rollback()
+		return snapshots.Info{}, fmt.Errorf("%v: %w", err, errdefs.ErrInvalidArgument)
+	}
+
+	id, _, _, err := storage.GetInfo(ctx, info.Name)
 	if err != nil {
 		t.Rollback()
 		return snapshots.Info{}, err
@@ -84,10 +169,11 @@ func (s *Snapshotter) Update(ctx context.Context, info snapshots.Info, fieldpath
 		return snapshots.Info{}, err
 	}
 
-	return info, nil
+	return s.withDedupLabels(id, info), nil
 }
 
 func (s *Snapshotter) Usage(ctx context.Context, key string) (snapshots.Usage, error) {
+	ctx = opid.Start(ctx, "usage")
 	ctx, t, err := s.ms.TransactionContext(ctx, false)
 	if err != nil {
 		return snapshots.Usage{}, err
@@ -100,6 +186,8 @@ func (s *Snapshotter) Usage(ctx context.Context, key string) (snapshots.Usage, e
 	}
 
 	if info.Kind == snapshots.KindActive {
+		ctx, cancel := context.WithTimeout(ctx, time.Duration(s.configs.Get().Timeouts.Usage)*time.Second)
+		defer cancel()
 		du, err := s.storage.DiskUsage(ctx, id)
 		if err != nil {
 			return snapshots.Usage{}, err
@@ -110,7 +198,17 @@ func (s *Snapshotter) Usage(ctx context.Context, key string) (snapshots.Usage, e
 	return usage, nil
 }
 
-func (s *Snapshotter) Mounts(ctx context.Context, key string) ([]mount.Mount, error) {
+func (s *Snapshotter) Mounts(ctx context.Context, key string) (_ []mount.Mount, err error) {
+	ctx = opid.Start(ctx, "mounts")
+	start := time.Now()
+	depth := 0
+	strategy := dedupStorage.MountStrategyErofs
+	defer func() {
+		if err == nil {
+			s.observe("mounts", depth, strategy, start)
+		}
+	}()
+
 	s.activeMountsMu.Lock()
 	s.activeMounts[key] = true
 	s.activeMountsMu.Unlock()
@@ -125,11 +223,28 @@ func (s *Snapshotter) Mounts(ctx context.Context, key string) ([]mount.Mount, er
 	if err != nil {
 		return nil, err
 	}
+	depth = len(snap.ParentIDs)
 
-	return s.mounts(snap)
+	_, info, _, err := storage.GetInfo(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if strategy, err = dedupStorage.ParseMountStrategy(info.Labels); err != nil {
+		return nil, err
+	}
+	opts, err := dedupStorage.ParseMountOptions(info.Labels)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkScanVerdicts(snap.Kind, key, snap.ParentIDs); err != nil {
+		return nil, err
+	}
+
+	return s.mounts(ctx, snap, strategy, opts)
 }
 
 func (s *Snapshotter) Prepare(ctx context.Context, key, parent string, opts ...snapshots.Opt) (mounts []mount.Mount, err error) {
+	ctx = opid.Start(ctx, "prepare")
 	if s.auditLogger != nil {
 		ctx = audit.StartAudit(ctx, "prepare_snapshot", key, "containerd", os.Getpid(), map[string]interface{}{
 			"parent": parent,
@@ -143,14 +258,22 @@ func (s *Snapshotter) Prepare(ctx context.Context, key, parent string, opts ...s
 			audit.FinishAudit(ctx, s.auditLogger, result, err)
 		}()
 	}
+	op := slowlog.Start(slowlog.OpPrepare, log.Fields{"key": key, "parent": parent, opid.Field: opid.FromContext(ctx)})
+	defer func() { op.Done(err) }()
+
+	if remote, err := s.prepareRemote(ctx, key, parent, opts...); remote {
+		return nil, err
+	}
 	return s.createSnapshot(ctx, snapshots.KindActive, key, parent, opts...)
 }
 
 func (s *Snapshotter) View(ctx context.Context, key, parent string, opts ...snapshots.Opt) ([]mount.Mount, error) {
+	ctx = opid.Start(ctx, "view")
 	return s.createSnapshot(ctx, snapshots.KindView, key, parent, opts...)
 }
 
 func (s *Snapshotter) Commit(ctx context.Context, name, key string, opts ...snapshots.Opt) (err error) {
+	ctx = opid.Start(ctx, "commit")
 	if s.auditLogger != nil {
 		ctx = audit.StartAudit(ctx, "commit_snapshot", key, "containerd", os.Getpid(), map[string]interface{}{
 			"name": name,
@@ -189,10 +312,25 @@ func (s *Snapshotter) Commit(ctx context.Context, name, key string, opts ...snap
 		return err
 	}
 
-	return t.Commit()
+	if err := t.Commit(); err != nil {
+		return err
+	}
+
+	if err := s.storage.RecordChainID(id, name); err != nil {
+		log.G(ctx).WithError(err).Warnf("failed to record chain id of snapshot %s", id)
+	}
+
+	// 增量切分过的快照在提交后立即转换,大文件的哈希已在写入期间算好
+	if s.storage.StopIncrementalChunking(id) {
+		if err := s.autoConvertLayer(ctx, id, nil); err != nil {
+			log.G(ctx).WithError(err).Warnf("convert committed snapshot %s failed, will use fallback", id)
+		}
+	}
+	return nil
 }
 
 func (s *Snapshotter) Remove(ctx context.Context, key string) (err error) {
+	ctx = opid.Start(ctx, "remove")
 	if s.auditLogger != nil {
 		ctx = audit.StartAudit(ctx, "remove_snapshot", key, "containerd", os.Getpid(), map[string]interface{}{
 			"key": key,
@@ -209,11 +347,12 @@ func (s *Snapshotter) Remove(ctx context.Context, key string) (err error) {
 	s.activeMountsMu.Lock()
 	if s.activeMounts[key] {
 		s.activeMountsMu.Unlock()
-		log.L.Infof("snapshot %s is actively mounted, deferring removal", key)
+		log.G(ctx).Infof("snapshot %s is actively mounted, deferring removal", key)
 		return nil
 	}
 	delete(s.activeMounts, key)
 	s.activeMountsMu.Unlock()
+	s.thawBeforeRemove(ctx, key)
 
 	ctx, t, err := s.ms.TransactionContext(ctx, true)
 	if err != nil {
@@ -233,25 +372,88 @@ func (s *Snapshotter) Remove(ctx context.Context, key string) (err error) {
 	if err := s.storage.Remove(ctx, id); err != nil {
 		return err
 	}
+	if s.metrics != nil {
+		s.metrics.ForgetImage(id)
+	}
 
 	return t.Commit()
 }
 
 func (s *Snapshotter) Walk(ctx context.Context, fn snapshots.WalkFunc, fs ...string) error {
+	ctx = opid.Start(ctx, "walk")
 	ctx, t, err := s.ms.TransactionContext(ctx, false)
 	if err != nil {
 		return err
 	}
 	defer t.Rollback()
 
-	return storage.WalkInfo(ctx, fn, fs...)
+	// 去重标签不在元数据中,先补全标签再过滤
+	filter, err := filters.ParseAll(fs...)
+	if err != nil {
+		return err
+	}
+
+	return storage.WalkInfo(ctx, func(ctx context.Context, info snapshots.Info) error {
+		// 元数据库很大时遍历耗时较长,RPC 取消后不再继续
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		id, _, _, err := storage.GetInfo(ctx, info.Name)
+		if err != nil {
+			return err
+		}
+		info = s.withDedupLabels(id, info)
+		if !filter.Match(adaptInfo(info)) {
+			return nil
+		}
+		return fn(ctx, info)
+	})
 }
 
 func (s *Snapshotter) Close() error {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.thawAll()
 	return s.ms.Close()
 }
 
-func (s *Snapshotter) createSnapshot(ctx context.Context, kind snapshots.Kind, key, parent string, opts ...snapshots.Opt) ([]mount.Mount, error) {
+func (s *Snapshotter) createSnapshot(ctx context.Context, kind snapshots.Kind, key, parent string, opts ...snapshots.Opt) (_ []mount.Mount, err error) {
+	start := time.Now()
+	depth := 0
+	strategy := dedupStorage.MountStrategyErofs
+	var top string
+	defer func() {
+		if err == nil {
+			op := "prepare"
+			if kind == snapshots.KindView {
+				op = "view"
+			}
+			s.observe(op, depth, strategy, start)
+			if kind == snapshots.KindActive && top != "" && !strings.HasPrefix(key, "extract-") {
+				s.countContainerMount(ctx, top, strategy)
+			}
+		}
+	}()
+
+	var base snapshots.Info
+	for _, opt := range opts {
+		if err := opt(&base); err != nil {
+			return nil, err
+		}
+	}
+	if strategy, err = dedupStorage.ParseMountStrategy(base.Labels); err != nil {
+		return nil, fmt.Errorf("%v: %w", err, errdefs.ErrInvalidArgument)
+	}
+	profile, err := dedupStorage.ParseTraceProfile(base.Labels)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", err, errdefs.ErrInvalidArgument)
+	}
+	mountOpts, err := dedupStorage.ParseMountOptions(base.Labels)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", err, errdefs.ErrInvalidArgument)
+	}
+
 	ctx, t, err := s.ms.TransactionContext(ctx, true)
 	if err != nil {
 		return nil, err
@@ -266,31 +468,117 @@ func (s *Snapshotter) createSnapshot(ctx context.Context, kind snapshots.Kind, k
 	if err != nil {
 		return nil, err
 	}
+	depth = len(snap.ParentIDs)
+	if depth > 0 {
+		top = snap.ParentIDs[0]
+	}
+	if s.metrics != nil {
+		ns, _ := namespaces.Namespace(ctx)
+		s.metrics.SetImageLabels(snap.ID, base.Labels[imageRefLabel], ns)
+	}
+	if err := s.checkScanVerdicts(kind, key, snap.ParentIDs); err != nil {
+		return nil, err
+	}
+
+	// 未指定 trace 配置时沿用父快照上的标签
+	if profile == "" && parent != "" && kind == snapshots.KindActive {
+		if _, pinfo, _, err := storage.GetInfo(ctx, parent); err == nil {
+			profile, _ = dedupStorage.ParseTraceProfile(pinfo.Labels)
+		}
+	}
 
 	// 准备快照存储
 	if err := s.storage.Prepare(ctx, snap.ID, snap.ParentIDs); err != nil {
 		return nil, err
 	}
 
-	// 检查并自动转换层(如果需要)
-	// 当 containerd 拉取镜像时,会为每一层调用 Prepare
-	// 我们在这里检测是否是新层,如果是则自动转换为 EROFS
-	if err := s.autoConvertLayer(ctx, snap.ID, snap.ParentIDs); err != nil {
-		log.L.WithError(err).Warnf("auto-convert layer %s failed, will use fallback", snap.ID)
+	// 选择 overlay 挂载的快照不转换为 EROFS,也不做增量切分
+	if strategy == dedupStorage.MountStrategyErofs {
+		// 检查并自动转换层(如果需要)
+		// 当 containerd 拉取镜像时,会为每一层调用 Prepare
+		// 我们在这里检测是否是新层,如果是则自动转换为 EROFS
+		if err := s.autoConvertLayer(ctx, snap.ID, snap.ParentIDs); err != nil {
+			log.G(ctx).WithError(err).Warnf("auto-convert layer %s failed, will use fallback", snap.ID)
+		}
+
+		if kind == snapshots.KindActive {
+			s.storage.StartIncrementalChunking(snap.ID)
+		}
 	}
 
 	if err := t.Commit(); err != nil {
 		return nil, err
 	}
 
-	return s.mounts(snap)
+	mounts, err := s.mounts(ctx, snap, strategy, mountOpts)
+	// 解包镜像层时 containerd 也会创建活动快照,键以 extract- 开头,不是容器
+	if err == nil && kind == snapshots.KindActive && len(snap.ParentIDs) > 0 && !strings.HasPrefix(key, "extract-") {
+		s.storage.BeginStartupTrace(key, snap.ParentIDs)
+	}
+	// 预取在 Prepare 返回后继续进行
+	if err == nil && kind == snapshots.KindActive && profile != "" && len(snap.ParentIDs) > 0 {
+		go s.storage.PrefetchTraceProfile(context.WithoutCancel(ctx), profile, snap.ParentIDs)
+	}
+	return mounts, err
+}
+
+// observe 记录操作耗时,标签为父链深度和挂载方式
+func (s *Snapshotter) observe(operation string, depth int, strategy string, start time.Time) {
+	if s.metrics == nil {
+		return
+	}
+	s.metrics.ObserveOperation(operation, depth, s.mountType(depth, strategy), time.Since(start))
 }
 
-// autoConvertLayer 自动检测并转换新层为 EROFS 格式
+// mountType 返回父链深度为 depth、挂载方式为 strategy 的快照实际使用的挂载类型
+func (s *Snapshotter) mountType(depth int, strategy string) string {
+	if depth > 0 && strategy == dedupStorage.MountStrategyErofs {
+		return s.storage.MountStrategy()
+	}
+	return dedupStorage.MountTypeOverlay
+}
+
+// countContainerMount 按镜像、namespace 和挂载类型统计容器根文件系统,镜像取自最上层的父快照
+func (s *Snapshotter) countContainerMount(ctx context.Context, top, strategy string) {
+	if s.metrics == nil {
+		return
+	}
+	labels := s.metrics.ImageLabels(top)
+	if ns, ok := namespaces.Namespace(ctx); ok {
+		labels[metrics.LabelNamespace] = ns
+	}
+	labels[metrics.LabelMountType] = s.mountType(1, strategy)
+	s.metrics.AddCounter("container_mounts", labels, 1)
+}
+
+// observeConversion 记录层转换耗时,带上层所属的镜像和 namespace
+func (s *Snapshotter) observeConversion(snapID string, start time.Time) {
+	if s.metrics != nil {
+		s.metrics.ObserveHistogram("layer_conversion_latency", s.metrics.ImageLabels(snapID), time.Since(start))
+	}
+}
+
+// autoConvertLayer 自动检测并转换新层为 EROFS 格式,同一层的并发调用合并为一次转换
 func (s *Snapshotter) autoConvertLayer(ctx context.Context, snapID string, parentIDs []string) error {
+	collapsed, err := s.conversions.do(ctx, snapID, func() error {
+		return s.convertLayer(ctx, snapID)
+	})
+	if collapsed && s.metrics != nil {
+		s.metrics.IncConversionCollapsed()
+	}
+	return err
+}
+
+func (s *Snapshotter) convertLayer(ctx context.Context, snapID string) error {
+	// 不可变存储只使用预制的镜像
+	if s.storage.Immutable() {
+		log.G(ctx).Debugf("store is immutable, skip conversion of layer %s", snapID)
+		return nil
+	}
+
 	// 检查是否已经有 EROFS 镜像
 	if s.storage.HasErofsImage(snapID) {
-		log.L.Debugf("layer %s already has erofs image, skip conversion", snapID)
+		log.G(ctx).Debugf("layer %s already has erofs image, skip conversion", snapID)
 		return nil
 	}
 
@@ -305,45 +593,59 @@ func (s *Snapshotter) autoConvertLayer(ctx context.Context, snapID string, paren
 	}
 
 	// 有内容,说明是新层,自动转换为 EROFS
-	log.L.Infof("detected new layer %s, auto-converting to EROFS", snapID)
+	log.G(ctx).Infof("detected new layer %s, auto-converting to EROFS", snapID)
+	start := time.Now()
 
 	if err := s.storage.BuildErofsImage(ctx, fsPath, snapID); err != nil {
 		return fmt.Errorf("failed to build erofs for layer %s: %w", snapID, err)
 	}
 
+	// 扫描结论为阻止的层不注册到 fscache,容器挂载时被拒绝
+	if s.scanLayer(ctx, snapID, fsPath) == scan.Block {
+		return nil
+	}
+
 	// 注册到 fscache
 	if err := s.registerLayerToFscache(ctx, snapID, fsPath); err != nil {
-		log.L.WithError(err).Warnf("failed to register layer %s to fscache", snapID)
+		log.G(ctx).WithError(err).Warnf("failed to register layer %s to fscache", snapID)
 	}
 
-	log.L.Infof("successfully auto-converted layer %s to EROFS", snapID)
+	s.observeConversion(snapID, start)
+	log.G(ctx).Infof("successfully auto-converted layer %s to EROFS", snapID)
 	return nil
 }
 
-// registerLayerToFscache 注册层到 fscache
-func (s *Snapshotter) registerLayerToFscache(ctx context.Context, layerID string, sourceDir string) error {
-	manifestPath := filepath.Join(s.root, "manifests", layerID+".manifest")
-	if err := os.MkdirAll(filepath.Dir(manifestPath), 0755); err != nil {
-		return err
+// scanLayer 扫描转换后的层并把结论记入审计日志,未配置扫描器时视为放行
+func (s *Snapshotter) scanLayer(ctx context.Context, snapID, fsPath string) scan.Outcome {
+	start := time.Now()
+	verdict := s.storage.ScanLayer(ctx, snapID, fsPath)
+	if verdict == nil {
+		return scan.Allow
 	}
-
-	// 生成简单的文件清单
-	file, err := os.Create(manifestPath)
-	if err != nil {
-		return err
+	if s.auditLogger != nil {
+		var err error
+		if verdict.Error != "" {
+			err = fmt.Errorf("%s", verdict.Error)
+		}
+		s.auditLogger.LogOperation(ctx, "layer_scan", snapID, "scanner", os.Getpid(), verdict, string(verdict.Outcome), err, time.Since(start))
 	}
-	defer file.Close()
+	return verdict.Outcome
+}
 
-	// 遍历生成清单
-	filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
-		if err == nil && info.Mode().IsRegular() {
-			relPath, _ := filepath.Rel(sourceDir, path)
-			fmt.Fprintf(file, "%s\t%d\n", relPath, info.Size())
-		}
+// checkScanVerdicts 拒绝容器和只读视图挂载被漏洞扫描阻止的层,解包镜像层的快照不受限制
+func (s *Snapshotter) checkScanVerdicts(kind snapshots.Kind, key string, parentIDs []string) error {
+	if kind == snapshots.KindActive && strings.HasPrefix(key, "extract-") {
 		return nil
-	})
+	}
+	if err := s.storage.CheckScanVerdicts(parentIDs); err != nil {
+		return fmt.Errorf("%v: %w", err, errdefs.ErrFailedPrecondition)
+	}
+	return nil
+}
 
-	return s.storage.RegisterImageForFscache(ctx, layerID, manifestPath)
+// registerLayerToFscache 注册层到 fscache
+func (s *Snapshotter) registerLayerToFscache(ctx context.Context, layerID string, sourceDir string) error {
+	return s.storage.RegisterDirForFscache(ctx, layerID, sourceDir)
 }
 
 // isDirEmpty 检查目录是否为空
@@ -358,12 +660,18 @@ func isDirEmpty(path string) (bool, error) {
 	return len(entries) == 0, nil
 }
 
-func (s *Snapshotter) mounts(snap storage.Snapshot) ([]mount.Mount, error) {
-	mounts, err := s.storage.Mounts(snap.ID, snap.ParentIDs)
+func (s *Snapshotter) mounts(ctx context.Context, snap storage.Snapshot, strategy string, opts dedupStorage.MountOptions) ([]mount.Mount, error) {
+	var mounts []mount.Mount
+	var err error
+	if strategy == dedupStorage.MountStrategyOverlay {
+		mounts, err = s.storage.MountsOverlay(ctx, snap.ID, snap.ParentIDs, opts)
+	} else {
+		mounts, err = s.storage.Mounts(ctx, snap.ID, snap.ParentIDs, opts)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	log.L.Debugf("mounts for snapshot %s: %+v", snap.ID, mounts)
+	log.G(ctx).Debugf("%s mounts for snapshot %s: %+v", strategy, snap.ID, mounts)
 	return mounts, nil
 }
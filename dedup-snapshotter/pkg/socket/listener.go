@@ -0,0 +1,120 @@
+package socket
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/audit"
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+	"golang.org/x/sys/unix"
+)
+
+// Listen 创建快照服务的 unix socket,设置权限和属主,并只接受 AllowedUIDs 中的对端连接
+func Listen(address string, cfg config.SocketConfig, auditLogger *audit.AuditLogger) (net.Listener, error) {
+	mode, err := strconv.ParseUint(cfg.Mode, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid socket mode %q: %w", cfg.Mode, err)
+	}
+
+	// umask 是进程级的,修改它会影响其他 goroutine 同时创建的文件,因此在 bind 后立即设置属主和权限。
+	// 这之间的连接仍要通过下面的 UID 校验
+	l, err := net.Listen("unix", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", address, err)
+	}
+
+	if cfg.UID >= 0 || cfg.GID >= 0 {
+		if err := os.Chown(address, cfg.UID, cfg.GID); err != nil {
+			l.Close()
+			return nil, fmt.Errorf("failed to chown socket %s: %w", address, err)
+		}
+	}
+
+	if err := os.Chmod(address, os.FileMode(mode)); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("failed to chmod socket %s: %w", address, err)
+	}
+
+	allowed := make(map[uint32]bool, len(cfg.AllowedUIDs))
+	for _, uid := range cfg.AllowedUIDs {
+		allowed[uint32(uid)] = true
+	}
+
+	log.L.Infof("socket %s created with mode %s, allowed uids %v", address, cfg.Mode, cfg.AllowedUIDs)
+	return &peerCredListener{
+		Listener:    l,
+		address:     address,
+		allowed:     allowed,
+		auditLogger: auditLogger,
+	}, nil
+}
+
+// peerCredListener 通过 SO_PEERCRED 校验对端 UID,拒绝的连接直接关闭并记录审计日志
+type peerCredListener struct {
+	net.Listener
+	address     string
+	allowed     map[uint32]bool
+	auditLogger *audit.AuditLogger
+}
+
+func (l *peerCredListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		cred, err := peerCred(conn)
+		if err == nil && l.allowed[cred.Uid] {
+			return conn, nil
+		}
+
+		l.reject(cred, err)
+		conn.Close()
+	}
+}
+
+func (l *peerCredListener) reject(cred *unix.Ucred, credErr error) {
+	user := "unknown"
+	pid := 0
+	if cred != nil {
+		user = strconv.FormatUint(uint64(cred.Uid), 10)
+		pid = int(cred.Pid)
+	}
+
+	err := credErr
+	if err == nil {
+		err = fmt.Errorf("uid %d not allowed", cred.Uid)
+	}
+	log.L.WithError(err).Warnf("rejected connection on %s from uid=%s pid=%d", l.address, user, pid)
+
+	if l.auditLogger != nil {
+		ctx := audit.StartAudit(context.Background(), "socket_connect", l.address, user, pid, nil)
+		audit.FinishAudit(ctx, l.auditLogger, "denied", err)
+	}
+}
+
+func peerCred(conn net.Conn) (*unix.Ucred, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, fmt.Errorf("not a unix connection")
+	}
+
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var cred *unix.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil {
+		return nil, err
+	}
+	return cred, credErr
+}
@@ -0,0 +1,71 @@
+package socket
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+)
+
+// TestPeerCredListener 验证 socket 权限设置以及按对端 UID 接受或拒绝连接
+func TestPeerCredListener(t *testing.T) {
+	uid := os.Getuid()
+
+	for _, tc := range []struct {
+		name    string
+		allowed []int
+		accept  bool
+	}{
+		{"allowed", []int{uid}, true},
+		{"rejected", []int{uid + 1}, false},
+	} {
+		address := filepath.Join(t.TempDir(), "snapshotter.sock")
+		l, err := Listen(address, config.SocketConfig{Mode: "0600", UID: -1, GID: -1, AllowedUIDs: tc.allowed}, nil)
+		if err != nil {
+			t.Fatalf("%s: failed to listen: %v", tc.name, err)
+		}
+
+		info, err := os.Stat(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != 0600 {
+			t.Errorf("%s: expected socket mode 0600, got %o", tc.name, perm)
+		}
+
+		accepted := make(chan bool, 1)
+		go func() {
+			conn, err := l.Accept()
+			if err == nil {
+				conn.Close()
+			}
+			accepted <- err == nil
+		}()
+
+		conn, err := net.Dial("unix", address)
+		if err != nil {
+			t.Fatalf("%s: failed to dial: %v", tc.name, err)
+		}
+
+		select {
+		case ok := <-accepted:
+			if !ok || !tc.accept {
+				t.Errorf("%s: unexpected accept result %v", tc.name, ok)
+			}
+		case <-time.After(200 * time.Millisecond):
+			if tc.accept {
+				t.Errorf("%s: connection was not accepted", tc.name)
+			}
+			// 被拒绝的连接不会返回给调用方,关闭监听后 Accept 才会退出
+			l.Close()
+			<-accepted
+		}
+
+		conn.Close()
+		l.Close()
+		t.Logf("✓ uid %d %s", uid, tc.name)
+	}
+}
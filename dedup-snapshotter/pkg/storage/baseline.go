@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/erofs"
+)
+
+// baselinesDir 是 root 下保存去重统计基线的目录,每个基线一个 JSON 文件
+const baselinesDir = "baselines"
+
+// ErrBaselineNotFound 表示指定的基线不存在
+var ErrBaselineNotFound = errors.New("baseline not found")
+
+var baselineName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,63}$`)
+
+// DedupSnapshot 是某一时刻整个存储的去重统计。LogicalBytes 为去重前的引用总量,
+// StoredBytes 为 chunk 存储实际占用,ImageBytes 为 EROFS 镜像占用;
+// Settings 记录采集时影响去重效果的配置,便于对照启用了哪些功能
+type DedupSnapshot struct {
+	Name         string            `json:"name,omitempty"`
+	Time         time.Time         `json:"time"`
+	Settings     map[string]string `json:"settings"`
+	Chunks       int64             `json:"chunks"`
+	LogicalBytes int64             `json:"logical_bytes"`
+	StoredBytes  int64             `json:"stored_bytes"`
+	ZeroBytes    int64             `json:"zero_bytes"`
+	ImageBytes   int64             `json:"image_bytes"`
+	DedupRatio   float64           `json:"dedup_ratio"`
+	Tiers        []erofs.TierStats `json:"tiers"`
+}
+
+// TierDelta 是一个 chunk 分层相对基线的变化
+type TierDelta struct {
+	Tier             string  `json:"tier"`
+	LogicalBytes     int64   `json:"logical_bytes"`
+	StoredBytes      int64   `json:"stored_bytes"`
+	DedupRatioChange float64 `json:"dedup_ratio_change"`
+}
+
+// BaselineReport 对比当前统计与基线。SavedBytes 是基线之后新增的数据在基线去重率下
+// 本应占用、而实际少占用的字节数,负数表示新功能使去重变差;Changed 列出与基线不同的配置
+type BaselineReport struct {
+	Baseline         DedupSnapshot     `json:"baseline"`
+	Current          DedupSnapshot     `json:"current"`
+	LogicalBytes     int64             `json:"logical_bytes"`
+	StoredBytes      int64             `json:"stored_bytes"`
+	ZeroBytes        int64             `json:"zero_bytes"`
+	ImageBytes       int64             `json:"image_bytes"`
+	DedupRatioChange float64           `json:"dedup_ratio_change"`
+	SavedBytes       int64             `json:"saved_bytes"`
+	Changed          map[string]string `json:"changed"`
+	Tiers            []TierDelta       `json:"tiers"`
+}
+
+// ValidateBaselineName 检查基线名
+func ValidateBaselineName(name string) error {
+	if !baselineName.MatchString(name) {
+		return fmt.Errorf("invalid baseline name %q: must match %s", name, baselineName)
+	}
+	return nil
+}
+
+// DedupStats 采集当前整个存储的去重统计
+func (d *DedupStore) DedupStats() (*DedupSnapshot, error) {
+	if d.erofsBuilder == nil {
+		return nil, fmt.Errorf("erofs not enabled")
+	}
+	tiers, err := d.erofsBuilder.TierStats()
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect chunk tier stats: %w", err)
+	}
+	zero, err := d.erofsBuilder.ZeroBytes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect zero chunk stats: %w", err)
+	}
+
+	cfg := d.cfg()
+	snap := &DedupSnapshot{
+		Time: time.Now().UTC(),
+		Settings: map[string]string{
+			"chunk_size":   strconv.FormatInt(cfg.ChunkSize, 10),
+			"small_chunks": strconv.FormatBool(cfg.EnableSmallChunks),
+		},
+		ZeroBytes:  zero,
+		ImageBytes: getDirSize(d.imagesDir),
+		Tiers:      tiers,
+	}
+	if snap.Tiers == nil {
+		snap.Tiers = []erofs.TierStats{}
+	}
+	for _, t := range tiers {
+		snap.Chunks += t.Chunks
+		snap.LogicalBytes += t.LogicalSize
+		snap.StoredBytes += t.StoredSize
+	}
+	snap.DedupRatio = dedupRatio(snap.LogicalBytes, snap.StoredBytes)
+	return snap, nil
+}
+
+func dedupRatio(logical, stored int64) float64 {
+	if logical <= 0 {
+		return 0
+	}
+	return float64(logical-stored) / float64(logical) * 100
+}
+
+func (d *DedupStore) baselinePath(name string) (string, error) {
+	if err := ValidateBaselineName(name); err != nil {
+		return "", err
+	}
+	return filepath.Join(d.root, baselinesDir, name+".json"), nil
+}
+
+// RecordBaseline 采集当前统计并保存为基线,同名基线被覆盖
+func (d *DedupStore) RecordBaseline(name string) (*DedupSnapshot, error) {
+	if err := d.checkWritable(); err != nil {
+		return nil, err
+	}
+	path, err := d.baselinePath(name)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := d.DedupStats()
+	if err != nil {
+		return nil, err
+	}
+	snap.Name = name
+
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	log.L.Infof("recorded dedup baseline %s: %d logical bytes, %d stored bytes", name, snap.LogicalBytes, snap.StoredBytes)
+	return snap, nil
+}
+
+// Baseline 读取基线
+func (d *DedupStore) Baseline(name string) (*DedupSnapshot, error) {
+	path, err := d.baselinePath(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s: %w", name, ErrBaselineNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snap DedupSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("invalid baseline %s: %w", name, err)
+	}
+	return &snap, nil
+}
+
+// ListBaselines 列出所有基线,按记录时间排序
+func (d *DedupStore) ListBaselines() ([]DedupSnapshot, error) {
+	entries, err := os.ReadDir(filepath.Join(d.root, baselinesDir))
+	if os.IsNotExist(err) {
+		return []DedupSnapshot{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	baselines := []DedupSnapshot{}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		snap, err := d.Baseline(name)
+		if err != nil {
+			log.L.WithError(err).Warnf("skipping unreadable baseline %s", name)
+			continue
+		}
+		baselines = append(baselines, *snap)
+	}
+	sort.Slice(baselines, func(i, j int) bool { return baselines[i].Time.Before(baselines[j].Time) })
+	return baselines, nil
+}
+
+// CompareBaseline 对比当前统计与基线
+func (d *DedupStore) CompareBaseline(name string) (*BaselineReport, error) {
+	base, err := d.Baseline(name)
+	if err != nil {
+		return nil, err
+	}
+	current, err := d.DedupStats()
+	if err != nil {
+		return nil, err
+	}
+	return compareSnapshots(base, current), nil
+}
+
+func compareSnapshots(base, current *DedupSnapshot) *BaselineReport {
+	report := &BaselineReport{
+		Baseline:         *base,
+		Current:          *current,
+		LogicalBytes:     current.LogicalBytes - base.LogicalBytes,
+		StoredBytes:      current.StoredBytes - base.StoredBytes,
+		ZeroBytes:        current.ZeroBytes - base.ZeroBytes,
+		ImageBytes:       current.ImageBytes - base.ImageBytes,
+		DedupRatioChange: current.DedupRatio - base.DedupRatio,
+		Changed:          map[string]string{},
+		Tiers:            []TierDelta{},
+	}
+	if report.LogicalBytes > 0 {
+		expected := report.LogicalBytes - int64(float64(report.LogicalBytes)*base.DedupRatio/100)
+		report.SavedBytes = expected - report.StoredBytes
+	}
+	for key, value := range current.Settings {
+		if base.Settings[key] != value {
+			report.Changed[key] = value
+		}
+	}
+
+	byTier := make(map[string]*TierDelta)
+	var order []string
+	add := func(t erofs.TierStats, sign int64) {
+		delta, ok := byTier[t.Tier]
+		if !ok {
+			delta = &TierDelta{Tier: t.Tier}
+			byTier[t.Tier] = delta
+			order = append(order, t.Tier)
+		}
+		delta.LogicalBytes += sign * t.LogicalSize
+		delta.StoredBytes += sign * t.StoredSize
+		delta.DedupRatioChange += float64(sign) * t.DedupRatio
+	}
+	for _, t := range base.Tiers {
+		add(t, -1)
+	}
+	for _, t := range current.Tiers {
+		add(t, 1)
+	}
+	sort.Strings(order)
+	for _, tier := range order {
+		report.Tiers = append(report.Tiers, *byTier[tier])
+	}
+	return report
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/erofs"
+)
+
+// TestDedupBaseline 验证基线的记录、列出和查找,以及相对基线的增量和节省字节数
+func TestDedupBaseline(t *testing.T) {
+	store, err := NewDedupStoreWithOptions(t.TempDir(), true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.RecordBaseline("../escape"); err == nil {
+		t.Error("expected invalid baseline name to be rejected")
+	}
+	if _, err := store.RecordBaseline("before-cdc"); err != nil {
+		t.Fatal(err)
+	}
+	baselines, err := store.ListBaselines()
+	if err != nil || len(baselines) != 1 || baselines[0].Name != "before-cdc" || baselines[0].Settings["small_chunks"] == "" {
+		t.Fatalf("unexpected baselines %+v (%v)", baselines, err)
+	}
+	if _, err := store.CompareBaseline("before-cdc"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CompareBaseline("missing"); !errors.Is(err, ErrBaselineNotFound) {
+		t.Errorf("expected ErrBaselineNotFound, got %v", err)
+	}
+	t.Logf("✓ 记录并列出基线")
+
+	// 基线去重率 50%,之后新增 1000 字节逻辑数据只多占 300 字节,比按基线去重率少占 200 字节
+	base := &DedupSnapshot{
+		LogicalBytes: 1000, StoredBytes: 500, DedupRatio: 50,
+		Settings: map[string]string{"small_chunks": "false"},
+		Tiers:    []erofs.TierStats{{Tier: erofs.ChunkTierLarge, LogicalSize: 1000, StoredSize: 500, DedupRatio: 50}},
+	}
+	current := &DedupSnapshot{
+		LogicalBytes: 2000, StoredBytes: 800, DedupRatio: 60,
+		Settings: map[string]string{"small_chunks": "true"},
+		Tiers: []erofs.TierStats{
+			{Tier: erofs.ChunkTierLarge, LogicalSize: 1200, StoredSize: 600, DedupRatio: 50},
+			{Tier: erofs.ChunkTierSmall, LogicalSize: 800, StoredSize: 200, DedupRatio: 75},
+		},
+	}
+	report := compareSnapshots(base, current)
+	if report.LogicalBytes != 1000 || report.StoredBytes != 300 || report.SavedBytes != 200 || report.DedupRatioChange != 10 {
+		t.Errorf("unexpected deltas %+v", report)
+	}
+	if report.Changed["small_chunks"] != "true" || len(report.Changed) != 1 {
+		t.Errorf("expected small_chunks change, got %v", report.Changed)
+	}
+	if len(report.Tiers) != 2 || report.Tiers[1].Tier != erofs.ChunkTierSmall || report.Tiers[1].StoredBytes != 200 {
+		t.Errorf("unexpected tier deltas %+v", report.Tiers)
+	}
+	t.Logf("✓ 启用小块分层后节省 %d 字节,去重率提高 %.0f 个百分点", report.SavedBytes, report.DedupRatioChange)
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/accounting"
+)
+
+// ChargebackEntry 是镜像或命名空间在统计窗口内的流量,以及当前独占的存储空间。
+// 多个命名空间共用的层在每个命名空间中都计入
+type ChargebackEntry struct {
+	Name             string `json:"name"`
+	Namespace        string `json:"namespace,omitempty"`
+	Layers           int    `json:"layers"`
+	DownloadedBytes  int64  `json:"downloaded_bytes"`
+	CacheServedBytes int64  `json:"cache_served_bytes"`
+	ExclusiveBytes   int64  `json:"exclusive_bytes"`
+}
+
+// ChargebackReport 是一个统计窗口的流量分摊报告,进行中的窗口 End 为零值
+type ChargebackReport struct {
+	Start      time.Time         `json:"start"`
+	End        time.Time         `json:"end,omitempty"`
+	Images     []ChargebackEntry `json:"images"`
+	Namespaces []ChargebackEntry `json:"namespaces"`
+}
+
+// SortChargeback 按下载量从大到小排列
+func SortChargeback(entries []ChargebackEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].DownloadedBytes != entries[j].DownloadedBytes {
+			return entries[i].DownloadedBytes > entries[j].DownloadedBytes
+		}
+		return entries[i].Name < entries[j].Name
+	})
+}
+
+// AccountingWindow 返回进行中的统计窗口,previous 为 true 时返回上一个已关闭的窗口
+func (d *DedupStore) AccountingWindow(previous bool) (*accounting.Window, error) {
+	if d.ledger == nil {
+		return nil, fmt.Errorf("accounting not enabled")
+	}
+	if previous {
+		w := d.ledger.Previous()
+		if w == nil {
+			return nil, fmt.Errorf("no closed accounting window yet")
+		}
+		return w, nil
+	}
+	w := d.ledger.Current()
+	return &w, nil
+}
+
+// ResetAccounting 关闭进行中的统计窗口并开始新窗口,返回关闭的窗口
+func (d *DedupStore) ResetAccounting() (*accounting.Window, error) {
+	if d.ledger == nil {
+		return nil, fmt.Errorf("accounting not enabled")
+	}
+	w := d.ledger.Reset()
+	return &w, nil
+}
+
+// LayerTraffic 汇总窗口内一组快照的流量。快照的流量可能记在快照 ID(fscache 按需读取)
+// 或镜像键(拉取物化的层)下,两者都计入,共用同一镜像键的快照只计一次
+func (d *DedupStore) LayerTraffic(w *accounting.Window, ids []string) accounting.Counters {
+	var total accounting.Counters
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		for _, key := range []string{id, d.imageKey(id)} {
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			c := w.Layers[key]
+			total.DownloadedBytes += c.DownloadedBytes
+			total.CacheServedBytes += c.CacheServedBytes
+		}
+	}
+	return total
+}
@@ -0,0 +1,579 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/reference"
+	refdocker "github.com/containerd/containerd/reference/docker"
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/background"
+	"github.com/opencloudos/dedup-snapshotter/pkg/erofs"
+	"github.com/opencloudos/dedup-snapshotter/pkg/jobs"
+	"github.com/opencloudos/dedup-snapshotter/pkg/metrics"
+	"github.com/opencloudos/dedup-snapshotter/pkg/slowlog"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	JobStateQueued    = "queued"
+	JobStateRunning   = "running"
+	JobStateCompleted = "completed"
+	JobStateFailed    = "failed"
+)
+
+const (
+	// finishedJobTTL 是已结束的转换任务在队列中保留的时间,之后只能通过任务管理器查询
+	finishedJobTTL = 24 * time.Hour
+	// maxFinishedJobs 是队列中保留的已结束任务数上限,超出时先淘汰最早结束的任务
+	maxFinishedJobs = 1000
+)
+
+// ConversionJob 描述一次异步转换任务,Source 和 ImageRef 二选一;
+// Relayout 为 true 时按记录的访问顺序重建已有镜像,Pull 为 true 时从镜像仓库拉取 ImageRef 并物化,
+// Import 为 true 时从 Source 指向的 OCI layout 目录或镜像 tar 包导入。
+// 排队的任务按 Priority、镜像的拉取热度、提交时间的顺序执行
+type ConversionJob struct {
+	ID         string    `json:"id"`
+	Source     string    `json:"source,omitempty"`
+	ImageRef   string    `json:"image_ref,omitempty"`
+	Relayout   bool      `json:"relayout,omitempty"`
+	Pull       bool      `json:"pull,omitempty"`
+	Import     bool      `json:"import,omitempty"`
+	ImageID    string    `json:"image_id,omitempty"`
+	Priority   int       `json:"priority,omitempty"`
+	State      string    `json:"state"`
+	Progress   float64   `json:"progress"`
+	Error      string    `json:"error,omitempty"`
+	Attempts   int       `json:"attempts,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	StartedAt  time.Time `json:"started_at,omitempty"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+
+	// saved 是上次写入任务管理器的时间
+	saved time.Time
+}
+
+// ConversionQueue 在后台 worker 中把目录或 content store 中的镜像转换为 EROFS。
+// pending 中每个任务对应 ready 中的一个令牌,worker 取得令牌后从 pending 中取优先级最高的任务
+type ConversionQueue struct {
+	store       *DedupStore
+	contentRoot string
+	queueSize   int
+	ready       chan struct{}
+	mu          sync.RWMutex
+	jobs        map[string]*ConversionJob
+	// finishedTTL 和 maxFinished 限制 jobs 中保留的已结束任务,见 pruneLocked
+	finishedTTL time.Duration
+	maxFinished int
+	pending     []*ConversionJob
+	demand      map[string]*pullDemand
+	journal     *jobs.Manager
+	wg          sync.WaitGroup
+	ctx         context.Context
+	cancel      context.CancelFunc
+}
+
+func NewConversionQueue(store *DedupStore, contentRoot string, workers, queueSize int) *ConversionQueue {
+	// mkfs.erofs 等外部命令从 ctx 取得后台优先级
+	ctx, cancel := context.WithCancel(background.WithController(context.Background(), store.background))
+
+	q := &ConversionQueue{
+		store:       store,
+		contentRoot: contentRoot,
+		queueSize:   queueSize,
+		ready:       make(chan struct{}, queueSize),
+		jobs:        make(map[string]*ConversionJob),
+		finishedTTL: finishedJobTTL,
+		maxFinished: maxFinishedJobs,
+		demand:      make(map[string]*pullDemand),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		id := i
+		store.background.Go(func() { q.worker(id) })
+	}
+
+	return q
+}
+
+// ErrSourceNotAllowed 表示转换、导入或推送规划的本地来源不在 conversion.source_dirs 之下
+var ErrSourceNotAllowed = errors.New("source is outside conversion.source_dirs")
+
+// checkSource 解析 path 的符号链接,确认结果位于 conversion.source_dirs 的某个目录之下,返回解析后的路径
+func (d *DedupStore) checkSource(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("source %s must be an absolute path", path)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("invalid source: %w", err)
+	}
+	var dirs []string
+	if cfg := d.cfg(); cfg != nil {
+		dirs = cfg.Conversion.SourceDirs
+	}
+	for _, dir := range dirs {
+		root, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(root, resolved)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("%s: %w", path, ErrSourceNotAllowed)
+}
+
+// SubmitDirectory 提交一个源目录的转换任务,imageID 为空时使用任务 ID
+func (q *ConversionQueue) SubmitDirectory(sourceDir, imageID string) (*ConversionJob, error) {
+	if imageID != "" {
+		if err := erofs.ValidateImageID(imageID); err != nil {
+			return nil, err
+		}
+	}
+	sourceDir, err := q.store.checkSource(sourceDir)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(sourceDir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source directory: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("source %s is not a directory", sourceDir)
+	}
+
+	job := q.newJob()
+	job.Source = sourceDir
+	job.ImageID = imageID
+	if job.ImageID == "" {
+		job.ImageID = job.ID
+	}
+
+	return q.enqueue(job)
+}
+
+// SubmitImageRef 提交一个按 digest 固定的镜像引用(name@sha256:...)的转换任务,
+// 层数据从 containerd content store 中读取
+func (q *ConversionQueue) SubmitImageRef(ref string) (*ConversionJob, error) {
+	spec, err := reference.Parse(ref)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image reference: %w", err)
+	}
+	if spec.Digest() == "" {
+		return nil, fmt.Errorf("image reference %s must be pinned by digest", ref)
+	}
+
+	job := q.newJob()
+	job.ImageRef = ref
+	job.ImageID = spec.Digest().Encoded()
+	job.Priority = q.annotatedPriority(spec.Digest())
+
+	return q.enqueue(job)
+}
+
+// SubmitPull 提交一个从镜像仓库拉取并物化镜像的任务。同一引用已有排队或运行中的拉取任务时
+// 直接返回该任务,镜像仓库重发的通知不会重复拉取
+func (q *ConversionQueue) SubmitPull(ref string) (*ConversionJob, error) {
+	named, err := refdocker.ParseDockerRef(ref)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image reference %q: %w", ref, err)
+	}
+	ref = named.String()
+
+	q.mu.RLock()
+	for _, job := range q.jobs {
+		if job.Pull && job.ImageRef == ref && (job.State == JobStateQueued || job.State == JobStateRunning) {
+			copied := *job
+			q.mu.RUnlock()
+			return &copied, nil
+		}
+	}
+	q.mu.RUnlock()
+
+	job := q.newJob()
+	job.Pull = true
+	job.ImageRef = ref
+	if digested, ok := named.(refdocker.Digested); ok {
+		job.ImageID = digested.Digest().Encoded()
+	}
+
+	return q.enqueue(job)
+}
+
+// SubmitImport 提交一个从 OCI layout 目录、OCI 归档或 docker save tar 包导入镜像的任务
+func (q *ConversionQueue) SubmitImport(path string) (*ConversionJob, error) {
+	if !filepath.IsAbs(path) {
+		return nil, fmt.Errorf("import path %s must be absolute", path)
+	}
+	path, err := q.store.checkSource(path)
+	if err != nil {
+		return nil, err
+	}
+
+	job := q.newJob()
+	job.Import = true
+	job.Source = path
+
+	return q.enqueue(job)
+}
+
+// SubmitRelayout 提交一个重排任务,order 非空时先替换镜像记录的访问顺序
+func (q *ConversionQueue) SubmitRelayout(imageID string, order []string) (*ConversionJob, error) {
+	if imageID == "" {
+		return nil, fmt.Errorf("image_id is required")
+	}
+	if err := erofs.ValidateImageID(imageID); err != nil {
+		return nil, err
+	}
+	if len(order) > 0 {
+		if err := q.store.SaveAccessOrder(imageID, order); err != nil {
+			return nil, fmt.Errorf("failed to save access order: %w", err)
+		}
+	}
+
+	job := q.newJob()
+	job.Relayout = true
+	job.ImageID = imageID
+
+	return q.enqueue(job)
+}
+
+func (q *ConversionQueue) GetJob(id string) (*ConversionJob, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	copied := *job
+	return &copied, true
+}
+
+func (q *ConversionQueue) ListJobs() []*ConversionJob {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	jobs := make([]*ConversionJob, 0, len(q.jobs))
+	for _, job := range q.jobs {
+		copied := *job
+		jobs = append(jobs, &copied)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+	})
+	return jobs
+}
+
+// Depth 返回排队等待 worker 的任务数
+func (q *ConversionQueue) Depth() int {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return len(q.pending)
+}
+
+func (q *ConversionQueue) Close() {
+	q.cancel()
+	q.wg.Wait()
+}
+
+func (q *ConversionQueue) newJob() *ConversionJob {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+
+	return &ConversionJob{
+		ID:        hex.EncodeToString(buf),
+		State:     JobStateQueued,
+		CreatedAt: time.Now(),
+	}
+}
+
+// Saturated 报告队列是否已满,新的转换请求会被拒绝
+func (q *ConversionQueue) Saturated() bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return len(q.pending) >= q.queueSize
+}
+
+func (q *ConversionQueue) enqueue(job *ConversionJob) (*ConversionJob, error) {
+	if err := q.store.checkDiskSpace(); err != nil {
+		return nil, err
+	}
+
+	q.mu.Lock()
+	if len(q.pending) >= q.queueSize {
+		q.mu.Unlock()
+		return nil, fmt.Errorf("conversion queue full")
+	}
+	q.pruneLocked(time.Now())
+	q.jobs[job.ID] = job
+	q.pending = append(q.pending, job)
+	journal := q.journal
+	copied := *job
+	q.mu.Unlock()
+	q.ready <- struct{}{}
+
+	recordConversion(journal, &copied)
+	log.L.Infof("queued conversion job %s for image %s (priority %d)", job.ID, job.ImageID, job.Priority)
+	return &copied, nil
+}
+
+func (q *ConversionQueue) worker(id int) {
+	defer q.wg.Done()
+
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case <-q.ready:
+			q.run(q.next())
+		}
+	}
+}
+
+func (q *ConversionQueue) run(job *ConversionJob) {
+	q.update(job, func(j *ConversionJob) {
+		j.State = JobStateRunning
+		j.StartedAt = time.Now()
+		j.Attempts++
+	})
+
+	start := time.Now()
+	op := slowlog.Start(slowlog.OpConversion, log.Fields{"job": job.ID, "image": job.ImageID, "ref": job.ImageRef})
+	var err error
+	switch {
+	case job.Relayout:
+		err = q.store.RelayoutImage(q.ctx, job.ImageID)
+	case job.Pull:
+		_, err = q.store.PullImage(q.ctx, job.ImageRef)
+	case job.Import:
+		_, err = q.store.ImportImages(q.ctx, job.Source, func(done, total int) {
+			q.update(job, func(j *ConversionJob) {
+				j.Progress = float64(done) / float64(total) * 100
+			})
+		})
+	case job.ImageRef != "":
+		err = q.convertImageRef(job)
+	default:
+		err = q.convertDirectory(job)
+	}
+
+	op.Done(err)
+	if m := q.store.metrics; m != nil && err == nil && job.ImageRef != "" {
+		m.ObserveHistogram("image_conversion_latency", metrics.Labels{metrics.LabelImage: metrics.ImageLabel(job.ImageRef)}, time.Since(start))
+	}
+
+	if err != nil && q.ctx.Err() != nil {
+		// 进程退出中断的任务保持运行中,下次启动时由任务管理器重新排队
+		log.L.Infof("conversion job %s interrupted by shutdown", job.ID)
+		return
+	}
+
+	retry := err != nil && job.Attempts < jobs.DefaultMaxAttempts
+	q.update(job, func(j *ConversionJob) {
+		if err != nil {
+			j.Error = err.Error()
+		}
+		switch {
+		case retry:
+			j.State = JobStateQueued
+		case err != nil:
+			j.State = JobStateFailed
+			j.FinishedAt = time.Now()
+		default:
+			j.State = JobStateCompleted
+			j.FinishedAt = time.Now()
+			j.Progress = 100
+		}
+	})
+
+	switch {
+	case retry:
+		delay := conversionRetryDelay * time.Duration(job.Attempts)
+		q.logf(job.ID, "attempt %d failed: %v, retrying in %s", job.Attempts, err, delay)
+		log.L.WithError(err).Warnf("conversion job %s failed, retrying in %s", job.ID, delay)
+		time.AfterFunc(delay, func() { q.requeue(job) })
+	case err != nil:
+		q.logf(job.ID, "attempt %d failed: %v, giving up", job.Attempts, err)
+		log.L.WithError(err).Warnf("conversion job %s failed", job.ID)
+	default:
+		q.logf(job.ID, "completed")
+		log.L.Infof("conversion job %s completed", job.ID)
+	}
+}
+
+func (q *ConversionQueue) update(job *ConversionJob, fn func(*ConversionJob)) {
+	q.mu.Lock()
+	state := job.State
+	fn(job)
+	// 状态变化立即持久化,进度最多每秒持久化一次
+	journal := q.journal
+	persist := journal != nil && (job.State != state || time.Since(job.saved) >= time.Second)
+	if persist {
+		job.saved = time.Now()
+	}
+	if job.State != state && jobFinished(job) {
+		q.pruneLocked(time.Now())
+	}
+	copied := *job
+	q.mu.Unlock()
+
+	if persist {
+		recordConversion(journal, &copied)
+	}
+}
+
+// pruneLocked 淘汰结束超过 finishedTTL 的任务,并把保留的已结束任务数限制在 maxFinished 以内。
+// 排队和运行中的任务不受影响。调用方持有 q.mu
+func (q *ConversionQueue) pruneLocked(now time.Time) {
+	var kept []*ConversionJob
+	for id, job := range q.jobs {
+		if !jobFinished(job) {
+			continue
+		}
+		if now.Sub(job.FinishedAt) > q.finishedTTL {
+			delete(q.jobs, id)
+			continue
+		}
+		kept = append(kept, job)
+	}
+	if len(kept) <= q.maxFinished {
+		return
+	}
+	sort.Slice(kept, func(i, j int) bool {
+		return kept[i].FinishedAt.Before(kept[j].FinishedAt)
+	})
+	for _, job := range kept[:len(kept)-q.maxFinished] {
+		delete(q.jobs, job.ID)
+	}
+}
+
+func jobFinished(job *ConversionJob) bool {
+	return job.State == JobStateCompleted || job.State == JobStateFailed
+}
+
+func (q *ConversionQueue) convertDirectory(job *ConversionJob) error {
+	if !q.store.useErofs || q.store.erofsBuilder == nil {
+		return fmt.Errorf("erofs not enabled")
+	}
+
+	total := getDirSize(job.Source)
+	imagePath, err := q.store.erofsBuilder.BuildImageOrdered(q.ctx, job.Source, job.ImageID, q.store.accessOrder(job.ImageID), func(processed int64) {
+		if total <= 0 {
+			return
+		}
+		q.update(job, func(j *ConversionJob) {
+			// 最后的 mkfs.erofs 阶段占剩余的进度
+			j.Progress = float64(processed) / float64(total) * 90
+		})
+	})
+	if err == nil {
+		q.store.signArtifact(imagePath)
+		q.store.updateTierMetrics()
+	}
+	return err
+}
+
+func (q *ConversionQueue) convertImageRef(job *ConversionJob) error {
+	if _, err := os.Stat(q.contentRoot); err != nil {
+		return fmt.Errorf("content store not available: %w", err)
+	}
+
+	cs, err := local.NewStore(q.contentRoot)
+	if err != nil {
+		return fmt.Errorf("failed to open content store: %w", err)
+	}
+
+	spec, err := reference.Parse(job.ImageRef)
+	if err != nil {
+		return err
+	}
+
+	ctx := q.ctx
+	info, err := cs.Info(ctx, spec.Digest())
+	if err != nil {
+		return fmt.Errorf("image %s not found in content store: %w", job.ImageRef, err)
+	}
+
+	mediaType, err := detectMediaType(ctx, cs, ocispec.Descriptor{Digest: info.Digest, Size: info.Size})
+	if err != nil {
+		return err
+	}
+
+	manifest, err := images.Manifest(ctx, cs, ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    info.Digest,
+		Size:      info.Size,
+	}, platforms.Default())
+	if err != nil {
+		return fmt.Errorf("failed to resolve manifest: %w", err)
+	}
+
+	parent := ""
+	for i, layer := range manifest.Layers {
+		layerID := layer.Digest.Encoded()
+
+		ra, err := cs.ReaderAt(ctx, layer)
+		if err != nil {
+			return fmt.Errorf("layer %s not found in content store: %w", layer.Digest, err)
+		}
+
+		err = q.store.ApplyLayer(ctx, layerID, content.NewReader(ra), parent)
+		ra.Close()
+		if err != nil {
+			return fmt.Errorf("failed to convert layer %s: %w", layer.Digest, err)
+		}
+
+		parent = layerID
+		q.update(job, func(j *ConversionJob) {
+			j.Progress = float64(i+1) / float64(len(manifest.Layers)) * 100
+		})
+	}
+
+	return nil
+}
+
+// detectMediaType 读取 blob 判断是 index 还是 manifest,content store 本身不记录媒体类型
+func detectMediaType(ctx context.Context, cs content.Provider, desc ocispec.Descriptor) (string, error) {
+	data, err := content.ReadBlob(ctx, cs, desc)
+	if err != nil {
+		return "", err
+	}
+
+	var probe struct {
+		MediaType string            `json:"mediaType"`
+		Manifests []json.RawMessage `json:"manifests"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return "", fmt.Errorf("failed to parse %s: %w", desc.Digest, err)
+	}
+
+	if probe.MediaType != "" {
+		return probe.MediaType, nil
+	}
+	if probe.Manifests != nil {
+		return ocispec.MediaTypeImageIndex, nil
+	}
+	return ocispec.MediaTypeImageManifest, nil
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// allowSourceDirs 把 dirs 设为允许转换和导入的本地目录
+func allowSourceDirs(store *DedupStore, dirs ...string) {
+	cfg := *store.cfg()
+	cfg.Conversion.SourceDirs = dirs
+	store.configs.Update(&cfg)
+}
+
+// TestConversionSourceValidation 验证转换和重排拒绝可拼出路径穿越的镜像 ID,
+// 转换、导入和推送规划的本地来源必须在解析符号链接后位于 conversion.source_dirs 之下
+func TestConversionSourceValidation(t *testing.T) {
+	store, err := NewDedupStoreWithErofs(t.TempDir(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	q := NewConversionQueue(store, t.TempDir(), 0, 10)
+	defer q.Close()
+
+	allowed, outside := t.TempDir(), t.TempDir()
+	source := filepath.Join(allowed, "layer")
+	if err := os.Mkdir(source, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := q.SubmitDirectory(source, "img"); !errors.Is(err, ErrSourceNotAllowed) {
+		t.Fatalf("expected local sources to be refused without source_dirs, got %v", err)
+	}
+	allowSourceDirs(store, allowed)
+
+	for _, id := range []string{"../../../../etc", "a/b", "..", ".hidden"} {
+		if _, err := q.SubmitDirectory(source, id); err == nil {
+			t.Fatalf("expected image id %q to be rejected", id)
+		}
+		if _, err := q.SubmitRelayout(id, nil); err == nil {
+			t.Fatalf("expected relayout of image id %q to be rejected", id)
+		}
+	}
+	t.Logf("✓ 含路径分隔符或 .. 的镜像 ID 被拒绝")
+
+	escape := filepath.Join(allowed, "escape")
+	if err := os.Symlink(outside, escape); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{outside, escape, filepath.Join(allowed, "..")} {
+		if _, err := q.SubmitDirectory(dir, "img"); !errors.Is(err, ErrSourceNotAllowed) {
+			t.Fatalf("expected %s to be outside source_dirs, got %v", dir, err)
+		}
+	}
+	if _, err := q.SubmitImport(filepath.Join(outside, "image.tar")); err == nil {
+		t.Fatal("expected import outside source_dirs to be rejected")
+	}
+	layer := filepath.Join(outside, "layer.tar")
+	if err := os.WriteFile(layer, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.PlanPush(context.Background(), layer); !errors.Is(err, ErrSourceNotAllowed) {
+		t.Fatalf("expected push plan outside source_dirs to be rejected, got %v", err)
+	}
+
+	job, err := q.SubmitDirectory(source, "sha256-abc.flat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Source != source {
+		t.Fatalf("expected resolved source %s, got %s", source, job.Source)
+	}
+	t.Logf("✓ 本地来源限制在 source_dirs 之下,符号链接逃逸被拒绝")
+}
+
+// TestConversionQueuePrunesFinishedJobs 验证已结束的任务超过保留时间或数量上限后被淘汰,
+// 排队和运行中的任务始终保留
+func TestConversionQueuePrunesFinishedJobs(t *testing.T) {
+	store, err := NewDedupStoreWithErofs(t.TempDir(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	q := NewConversionQueue(store, t.TempDir(), 0, 10)
+	defer q.Close()
+	q.maxFinished = 2
+
+	now := time.Now()
+	add := func(id, state string, finishedAt time.Time) {
+		q.jobs[id] = &ConversionJob{ID: id, State: state, FinishedAt: finishedAt}
+	}
+	add("expired", JobStateCompleted, now.Add(-2*finishedJobTTL))
+	add("oldest", JobStateFailed, now.Add(-3*time.Hour))
+	add("older", JobStateCompleted, now.Add(-2*time.Hour))
+	add("newest", JobStateCompleted, now.Add(-time.Hour))
+	add("queued", JobStateQueued, time.Time{})
+	add("running", JobStateRunning, time.Time{})
+
+	q.mu.Lock()
+	q.pruneLocked(now)
+	q.mu.Unlock()
+
+	var ids []string
+	for _, job := range q.ListJobs() {
+		ids = append(ids, job.ID)
+	}
+	sort.Strings(ids)
+	if want := []string{"newest", "older", "queued", "running"}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("expected jobs %v to remain, got %v", want, ids)
+	}
+	t.Logf("✓ 过期和超出上限的已结束任务被淘汰,未结束任务保留")
+}
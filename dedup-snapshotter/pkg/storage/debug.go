@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"sort"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/bufpool"
+	"github.com/opencloudos/dedup-snapshotter/pkg/chunkcache"
+	"github.com/opencloudos/dedup-snapshotter/pkg/erofs"
+	"github.com/opencloudos/dedup-snapshotter/pkg/fscache"
+	"github.com/opencloudos/dedup-snapshotter/pkg/metrics"
+)
+
+// DebugState 是存储内部状态的快照,用于在不挂调试器的情况下排查挂起
+type DebugState struct {
+	Mounts               []*erofs.MountPoint       `json:"mounts"`
+	ConversionQueueDepth int                       `json:"conversion_queue_depth"`
+	ActiveJobs           []*ConversionJob          `json:"active_jobs"`
+	Prefetch             []*fscache.PrefetchStatus `json:"prefetch"`
+	IncrementalPending   int                       `json:"incremental_pending"`
+	ScratchReserved      int64                     `json:"scratch_reserved_bytes"`
+	ChunkCache           *chunkcache.Stats         `json:"chunk_cache,omitempty"`
+	IndexCache           *chunkcache.MetaStats     `json:"index_cache,omitempty"`
+	ChunkIndexCache      *chunkcache.MetaStats     `json:"chunk_index_cache,omitempty"`
+	DiskWatch            *metrics.DiskWatchStats   `json:"disk_watch,omitempty"`
+	BufferPool           bufpool.Stats             `json:"buffer_pool"`
+}
+
+// DebugState 汇总挂载表、进行中的转换任务、预取任务和各队列深度
+func (d *DedupStore) DebugState() *DebugState {
+	state := &DebugState{
+		Mounts:     []*erofs.MountPoint{},
+		ActiveJobs: []*ConversionJob{},
+		Prefetch:   d.PrefetchStatuses(),
+		BufferPool: bufpool.Default().Stats(),
+	}
+	if d.mountManager != nil {
+		for _, mp := range d.mountManager.GetStats() {
+			state.Mounts = append(state.Mounts, mp)
+		}
+		sort.Slice(state.Mounts, func(i, j int) bool { return state.Mounts[i].ID < state.Mounts[j].ID })
+	}
+	if d.conversions != nil {
+		state.ConversionQueueDepth = d.conversions.Depth()
+		for _, job := range d.conversions.ListJobs() {
+			if job.State == JobStateQueued || job.State == JobStateRunning {
+				state.ActiveJobs = append(state.ActiveJobs, job)
+			}
+		}
+	}
+	if d.incremental != nil {
+		state.IncrementalPending = d.incremental.Pending()
+	}
+	if d.scratch != nil {
+		state.ScratchReserved = d.scratch.reservedBytes()
+	}
+	if d.readCache != nil {
+		stats := d.readCache.Stats()
+		state.ChunkCache = &stats
+	}
+	if d.indexDB != nil {
+		stats := d.indexDB.RefCacheStats()
+		state.IndexCache = &stats
+	}
+	if d.erofsBuilder != nil {
+		stats := d.erofsBuilder.IndexCacheStats()
+		state.ChunkIndexCache = &stats
+	}
+	state.DiskWatch = d.DiskWatchStats()
+	return state
+}
@@ -5,24 +5,58 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/containerd/containerd/mount"
 	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/internal/lazy"
+	"github.com/opencloudos/dedup-snapshotter/pkg/accounting"
+	"github.com/opencloudos/dedup-snapshotter/pkg/background"
+	"github.com/opencloudos/dedup-snapshotter/pkg/bufpool"
+	"github.com/opencloudos/dedup-snapshotter/pkg/chunkcache"
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+	"github.com/opencloudos/dedup-snapshotter/pkg/durability"
 	"github.com/opencloudos/dedup-snapshotter/pkg/erofs"
 	"github.com/opencloudos/dedup-snapshotter/pkg/fscache"
+	"github.com/opencloudos/dedup-snapshotter/pkg/jobs"
+	"github.com/opencloudos/dedup-snapshotter/pkg/layout"
+	"github.com/opencloudos/dedup-snapshotter/pkg/maintenance"
 	"github.com/opencloudos/dedup-snapshotter/pkg/memory"
+	"github.com/opencloudos/dedup-snapshotter/pkg/metrics"
+	"github.com/opencloudos/dedup-snapshotter/pkg/scan"
+	"github.com/opencloudos/dedup-snapshotter/pkg/signing"
+	"github.com/opencloudos/dedup-snapshotter/pkg/storelock"
+	"github.com/opencloudos/dedup-snapshotter/pkg/transport"
+	"golang.org/x/sys/unix"
 )
 
 const (
 	ChunkSize = 4 * 1024 * 1024
+
+	MountTypeFscache = "fscache"
+	MountTypeLoop    = "loop"
+	MountTypeMixed   = "mixed"
+	MountTypeOverlay = "overlay"
+
+	// BackendLocal 是本地 chunk 存储在后端指标中的名称
+	BackendLocal = "local"
+
+	// indexRecoveryWait 是所有者在崩溃恢复前等待只读读者分离的时间
+	indexRecoveryWait = 10 * time.Second
 )
 
+// ErrReadOnlyStore 表示存储以只读方式附着,不能修改
+var ErrReadOnlyStore = errors.New("store is attached read-only")
+
 type DedupStore struct {
 	root          string
 	chunksDir     string
@@ -32,11 +66,59 @@ type DedupStore struct {
 	chunkCache    sync.Map
 	erofsBuilder  *erofs.Builder
 	mountManager  *erofs.MountManager
+	binds         *erofs.BindManager
 	memDedup      *memory.MemoryDeduplicator
+	memScanner    *memory.FileScanner
+	memReclaimer  *memory.Reclaimer
 	dedupDaemon   *fscache.DedupDaemon
 	layerProcessor *LayerProcessor
+	conversions   *ConversionQueue
+	// jobs 持久化转换、预取和卷 GC 任务,由 SetJobManager 设置,见 jobs.go
+	jobs          *jobs.Manager
+	// background 降低转换、chunk 校验和内存去重扫描的优先级
+	background    *background.Controller
+	// maintenance 把卷 GC、chunk 后台校验和扁平化限制在维护窗口内全速运行,为空时不限制
+	maintenance   *maintenance.Scheduler
+	// durable 把新 chunk 冗余保存到多个后端并用于修复,未启用时为空,见 durability.go
+	durable       *durability.Store
+	// scratch 是层解压使用的临时空间,见 scratch.go
+	scratch       *scratchSpace
+	incremental   *IncrementalChunker
+	metrics       *metrics.Metrics
+	// transport 标识并计量发往镜像仓库和镜像服务的请求
+	transport     *transport.Transport
+	// negative 记录镜像仓库中确认不存在的 blob 和 referrer,与 dedupd 共用
+	negative      *fscache.NegativeCache
+	// readCache 是 erofs 构建器和 dedupd 共用的内存 chunk 缓存,为空时不缓存
+	readCache     *chunkcache.Cache
+	// configs 是与 API 和配置 watcher 共用的配置源,用 cfg() 读取当前配置
+	configs       *config.Source
+	flattenMu     sync.Mutex
+	flattening    map[string]bool
+	warmed        sync.Map
 	useErofs      bool
 	useFscache    bool
+	// immutable 为 true 时 root 中预制的内容只读,新快照位于 bakedSnapsDir 之外的 snapsDir,见 immutable.go
+	immutable     bool
+	bakedSnapsDir string
+	storeLock     *storelock.Lock
+	// signer 为转换产物签名,verifier 校验镜像仓库中发布的产物,见 signing.go
+	signer        *signing.Signer
+	verifier      *signing.Verifier
+	requireSignature bool
+	// startupTracer 记录容器冷启动,见 startup.go
+	startupTracer *fscache.StartupTracer
+	// scanner 在转换后的层提供服务前扫描漏洞,为空时不扫描,见 scan.go
+	scanner       scan.Scanner
+	scanPolicy    scan.Policy
+	// ledger 按层记录下载和复用的字节数,供流量分摊,见 chargeback.go
+	ledger        *accounting.Ledger
+	// fallback 记录 fscache 读取持续失败而改用 loop 挂载的镜像,见 fallback.go
+	fallback      fscacheFallback
+	// mountRecords 记录各快照每层实际使用的挂载方式和回退原因,见 mountchain.go
+	mountRecords  mountRecords
+	// diskWatch 在存储磁盘将满时逐级暂停预取、淘汰缓存并拒绝转换,只读存储为空,见 diskwatch.go
+	diskWatch     *diskWatch
 }
 
 type ChunkInfo struct {
@@ -53,7 +135,51 @@ func NewDedupStoreWithErofs(root string, useErofs bool) (*DedupStore, error) {
 	return NewDedupStoreWithOptions(root, useErofs, false)
 }
 
+func NewDedupStoreWithConfig(root string, cfg *config.Config) (*DedupStore, error) {
+	return NewDedupStoreWithSource(root, config.NewSource(cfg))
+}
+
+// NewDedupStoreWithSource 创建使用共享配置源的存储,运行期间读取的都是配置源上的最新配置
+func NewDedupStoreWithSource(root string, source *config.Source) (*DedupStore, error) {
+	return newDedupStore(root, true, true, source, false)
+}
+
 func NewDedupStoreWithOptions(root string, useErofs bool, useFscache bool) (*DedupStore, error) {
+	return newDedupStore(root, useErofs, useFscache, config.NewSource(config.DefaultConfig(root)), false)
+}
+
+// newDedupStore 打开存储,rechunk 为 true 时由 Rechunk 调用,跳过切分参数的兼容检查
+func newDedupStore(root string, useErofs bool, useFscache bool, source *config.Source, rechunk bool) (_ *DedupStore, err error) {
+	cfg := source.Get()
+	if cfg.Store.ReadOnly {
+		return newReadOnlyStore(root, source)
+	}
+	if cfg.Store.Immutable {
+		return newImmutableStore(root, source)
+	}
+
+	storeLock, err := storelock.Acquire(root, storelock.Store, filepath.Base(os.Args[0]))
+	if err != nil {
+		return nil, fmt.Errorf("cannot own store %s (set store.read_only to attach read-only): %w", root, err)
+	}
+	defer func() {
+		if err != nil {
+			storeLock.Release()
+		}
+	}()
+
+	// 格式迁移只由存储所有者执行,不兼容时拒绝启动而不是改动新版本写入的数据
+	report, err := layout.Upgrade(root)
+	if err != nil {
+		return nil, fmt.Errorf("root %s: %w", root, err)
+	}
+	log.L.Infof("root %s at format generation %d", root, report.Generation)
+	if !rechunk {
+		if err := layout.EnsureFingerprint(root, StoreFingerprint(cfg)); err != nil {
+			return nil, fmt.Errorf("root %s: %w", root, err)
+		}
+	}
+
 	chunksDir := filepath.Join(root, "chunks")
 	snapsDir := filepath.Join(root, "snapshots")
 	imagesDir := filepath.Join(root, "images")
@@ -67,11 +193,26 @@ func NewDedupStoreWithOptions(root string, useErofs bool, useFscache bool) (*Ded
 	if err := os.MkdirAll(imagesDir, 0755); err != nil {
 		return nil, err
 	}
+	if cfg.Encryption.Enabled {
+		if err := setupEncryption(cfg.Encryption, root, chunksDir, snapsDir); err != nil {
+			return nil, err
+		}
+	}
 
-	indexDB, err := NewIndexDB(filepath.Join(root, "index.db"))
+	indexPath := filepath.Join(root, "index.db")
+	var indexDB *IndexDB
+	err = storeLock.WithoutReaders(indexRecoveryWait, func() error {
+		indexDB, err = NewIndexDB(indexPath)
+		return err
+	})
+	if errors.Is(err, storelock.ErrReadersAttached) {
+		log.L.Warn("read-only readers attached to store, opening index without recovery")
+		indexDB, err = openIndexDB(indexPath, false)
+	}
 	if err != nil {
 		return nil, err
 	}
+	indexDB.SetRefCache(chunkcache.NewMetaCache(cfg.ChunkCache.IndexEntries))
 
 	store := &DedupStore{
 		root:       root,
@@ -79,12 +220,55 @@ func NewDedupStoreWithOptions(root string, useErofs bool, useFscache bool) (*Ded
 		snapsDir:   snapsDir,
 		imagesDir:  imagesDir,
 		indexDB:    indexDB,
+		configs:    source,
+		flattening: make(map[string]bool),
 		useErofs:   useErofs,
 		useFscache: useFscache,
+		storeLock:  storeLock,
+		transport:  transport.New(nil, cfg.RegistryClient.UserAgent, cfg.RegistryClient.TraceHeaders),
+	}
+	if err := store.loadSigningKeys(cfg.Signing); err != nil {
+		return nil, err
 	}
 
+	scratchDir := root
+	if cfg.Scratch.Dir != "" {
+		scratchDir = cfg.Scratch.Dir
+	}
+	if err := os.MkdirAll(scratchDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create scratch dir: %w", err)
+	}
+	negative, err := fscache.OpenNegativeCache(root, time.Duration(cfg.Dedupd.NegativeCacheTTL)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	store.negative = negative
+	if cfg.BufferPool.HugePageBuffers > 0 {
+		if err := bufpool.Default().EnableHugePages(cfg.BufferPool.HugePageBuffers); err != nil {
+			log.L.WithError(err).Warn("chunk buffer pool falls back to regular pages")
+		}
+	}
+	if !cfg.ChunkCache.Disabled {
+		store.readCache = chunkcache.New(int64(cfg.ChunkCache.MaxMB) << 20)
+	}
+	if !cfg.Accounting.Disabled {
+		store.ledger = accounting.Open(filepath.Join(root, "accounting.json"))
+	}
+
+	store.scratch = newScratchSpace(scratchDir, int64(cfg.Scratch.MaxMB)<<20, int64(cfg.Scratch.MinFreeMB)<<20)
+	store.diskWatch = newDiskWatch(root, scratchDir)
+
 	// 初始化层处理器
 	store.layerProcessor = NewLayerProcessor(store)
+	store.background = background.New(cfg.Background)
+	store.maintenance, err = maintenance.New(cfg.Maintenance)
+	if err != nil {
+		return nil, fmt.Errorf("invalid maintenance config: %w", err)
+	}
+	source.Subscribe(func(oldConfig, newConfig *config.Config) error {
+		return store.maintenance.SetConfig(newConfig.Maintenance)
+	})
+	store.conversions = NewConversionQueue(store, lazy.DefaultContentStoreRoot, cfg.Conversion.Workers, cfg.Conversion.QueueSize)
 
 	if useErofs {
 		builder, err := erofs.NewBuilder(root)
@@ -92,12 +276,56 @@ func NewDedupStoreWithOptions(root string, useErofs bool, useFscache bool) (*Ded
 			return nil, fmt.Errorf("failed to create erofs builder: %w", err)
 		}
 		store.erofsBuilder = builder
+		builder.SetHealHandler(store.handleHeal)
+		builder.SetSmallChunkTier(cfg.EnableSmallChunks)
+		builder.SetBuildTimeout(time.Duration(cfg.Timeouts.Build) * time.Second)
+		builder.SetScratchDir(scratchDir)
+		builder.SetChunkCache(store.readCache)
+		builder.SetIndexCache(chunkcache.NewMetaCache(cfg.ChunkCache.IndexEntries))
+		builder.SetImageVerification(cfg.Recovery.VerifyMode == config.VerifyModeFull)
+		if err := store.setupDurability(cfg.Durability, builder); err != nil {
+			return nil, fmt.Errorf("failed to set up durability tier: %w", err)
+		}
+		if err := store.recoverBuilds(); err != nil {
+			return nil, err
+		}
+
+		if cfg.IncrementalChunk.Enabled {
+			quiet := time.Duration(cfg.IncrementalChunk.QuietPeriod) * time.Second
+			incremental, err := NewIncrementalChunker(builder, quiet)
+			if err != nil {
+				log.L.WithError(err).Warn("failed to start incremental chunker")
+			} else {
+				store.incremental = incremental
+			}
+		}
 
 		mountManager, err := erofs.NewMountManager(root)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create mount manager: %w", err)
 		}
 		store.mountManager = mountManager
+		mountManager.SetCommandTimeout(time.Duration(cfg.Timeouts.Mount) * time.Second)
+		if err := mountManager.SetMountNamespace(cfg.MountNamespace); err != nil {
+			return nil, fmt.Errorf("failed to configure mount namespace: %w", err)
+		}
+		mountManager.DetectOverlayLimits(context.Background(), cfg.Overlay.MaxLowerDirs, cfg.Overlay.MaxOptionBytes)
+		mountManager.SetLowerFlattener(builder)
+		if cfg.SELinux.LowerContext != "" {
+			if erofs.SELinuxEnabled() {
+				mountManager.SetLowerLabel(cfg.SELinux.LowerContext)
+			} else {
+				log.L.Warn("SELinux disabled, ignoring selinux.lower_context")
+			}
+		}
+
+		binds, err := erofs.NewBindManagerWithOptions(mountManager, root, cfg.BindMounts.Propagation, erofs.KubeletPodAlive(cfg.BindMounts.KubeletPodsDir))
+		if err != nil {
+			log.L.WithError(err).Warn("bind mount helper disabled")
+		} else {
+			binds.SetTargetDirs(cfg.BindMounts.TargetDirs)
+			store.binds = binds
+		}
 
 		if useFscache {
 			dedupDaemon, err := fscache.NewDedupDaemon(root, "", 4)
@@ -106,6 +334,22 @@ func NewDedupStoreWithOptions(root string, useErofs bool, useFscache bool) (*Ded
 			} else {
 				store.dedupDaemon = dedupDaemon
 				log.L.Info("dedupd daemon initialized for fscache support")
+
+				dedupDaemon.SetTransport(store.transport)
+				dedupDaemon.SetNegativeCache(store.negative)
+				dedupDaemon.SetChunkCache(store.readCache)
+				dedupDaemon.SetLedger(store.ledger)
+				dedupDaemon.UseMirrors(cfg.Dedupd.Mirrors)
+				if err := dedupDaemon.UseContentStore(lazy.DefaultContentStoreRoot); err != nil {
+					log.L.WithError(err).Debug("content store read-through not enabled")
+				}
+				builder.SetChunkFetcher(dedupDaemon.FetchChunk)
+				dedupDaemon.SetChunkLookup(builder.HasChunk)
+				dedupDaemon.SetOnDemandLimits(int64(cfg.Dedupd.OnDemandRateMB)<<20, int64(cfg.Dedupd.OnDemandImageRateMB)<<20, int64(cfg.Dedupd.OnDemandBurstMB)<<20)
+				dedupDaemon.OnEviction(store.handleEviction)
+				dedupDaemon.SetReadFailureThreshold(cfg.Dedupd.FallbackFailures)
+				dedupDaemon.OnReadFailure(store.handleReadFailure)
+				go dedupDaemon.RunVolumeGC(time.Duration(cfg.Dedupd.VolumeGCInterval)*time.Second, store.volumeInUse, store.maintenance.Throttle)
 			}
 		}
 
@@ -114,23 +358,202 @@ func NewDedupStoreWithOptions(root string, useErofs bool, useFscache bool) (*Ded
 			return nil, fmt.Errorf("failed to create memory deduplicator: %w", err)
 		}
 		store.memDedup = memDedup
+		store.memScanner = memory.NewFileScannerWithOptions(memDedup, cfg.MemDedup.Workers, cfg.MemDedup.FilesPerSecond, store.background)
 
 		if err := memDedup.EnableKSM(); err != nil {
 			log.L.Warnf("failed to enable KSM: %v", err)
 		}
+
+		if cfg.MemDedup.Reclaim != config.ReclaimOff {
+			reclaimer, err := memory.NewReclaimer(cfg.MemDedup.Reclaim, time.Duration(cfg.MemDedup.ReclaimInterval)*time.Second, cfg.MemDedup.ReclaimColdAfter)
+			if err != nil {
+				log.L.WithError(err).Warn("cold page reclaim disabled")
+			} else {
+				store.memReclaimer = reclaimer
+				reclaimer.Start()
+			}
+		}
 	}
+	store.setupStartupTracer(cfg.StartupTrace)
+	store.setupScanner(cfg.Scan)
+	if store.ledger != nil {
+		store.ledger.Start(time.Duration(cfg.Accounting.ResetInterval) * time.Hour)
+	}
+	store.startDiskWatch()
 
 	return store, nil
 }
 
+// newReadOnlyStore 附着到其他进程拥有的 root:只读打开索引,不启动构建、挂载和 fscache
+func newReadOnlyStore(root string, source *config.Source) (*DedupStore, error) {
+	cfg := source.Get()
+	report, err := layout.Check(root)
+	if err != nil {
+		return nil, err
+	}
+	if !report.Compatible() {
+		return nil, fmt.Errorf("root %s: %w: %s", root, layout.ErrIncompatible, strings.Join(report.Problems, "; "))
+	}
+	if _, err := layout.CheckFingerprint(root, StoreFingerprint(cfg)); err != nil {
+		return nil, fmt.Errorf("root %s: %w", root, err)
+	}
+
+	storeLock, err := storelock.AttachReadOnly(root, storelock.Store)
+	if err != nil {
+		return nil, err
+	}
+
+	indexDB, err := NewIndexDBReadOnly(filepath.Join(root, "index.db"))
+	if err != nil {
+		storeLock.Release()
+		return nil, err
+	}
+
+	if holder, _ := storelock.ReadHolder(root, storelock.Store); holder != nil {
+		log.L.Infof("attached to store %s read-only (owner: %s, pid %d)", root, holder.Role, holder.PID)
+	} else {
+		log.L.Infof("attached to store %s read-only (no owner running)", root)
+	}
+
+	return &DedupStore{
+		root:       root,
+		chunksDir:  filepath.Join(root, "chunks"),
+		snapsDir:   filepath.Join(root, "snapshots"),
+		imagesDir:  filepath.Join(root, "images"),
+		indexDB:    indexDB,
+		configs:    source,
+		flattening: make(map[string]bool),
+		storeLock:  storeLock,
+		transport:  transport.New(nil, cfg.RegistryClient.UserAgent, cfg.RegistryClient.TraceHeaders),
+	}, nil
+}
+
+// cfg 返回当前配置,返回的配置只读
+func (d *DedupStore) cfg() *config.Config {
+	return d.configs.Get()
+}
+
+// Maintenance 返回维护窗口调度器,只读或不可变存储返回 nil
+func (d *DedupStore) Maintenance() *maintenance.Scheduler {
+	return d.maintenance
+}
+
+// ReadOnly 报告存储是否以只读方式附着
+func (d *DedupStore) ReadOnly() bool {
+	return d.storeLock != nil && d.storeLock.ReadOnly()
+}
+
+func (d *DedupStore) checkWritable() error {
+	if d.ReadOnly() {
+		return ErrReadOnlyStore
+	}
+	if d.immutable {
+		return ErrImmutableStore
+	}
+	return nil
+}
+
+func (d *DedupStore) SetMetrics(m *metrics.Metrics) {
+	d.metrics = m
+	d.transport.SetMetrics(m)
+	d.readCache.SetMetrics(m)
+	if d.dedupDaemon != nil {
+		d.dedupDaemon.SetMetrics(m)
+	}
+	if d.erofsBuilder != nil {
+		d.erofsBuilder.SetChunkStoreObserver(func(op erofs.ChunkStoreOp) {
+			m.ObserveBackendOp(metrics.BackendOp{
+				Backend:  BackendLocal,
+				Kind:     BackendLocal,
+				Op:       op.Op,
+				Bytes:    op.Bytes,
+				NotFound: op.NotFound,
+				Err:      op.Err,
+				Duration: op.Duration,
+			})
+		})
+	}
+	if d.durable != nil {
+		d.observeDurability(m)
+	}
+	if d.memReclaimer != nil {
+		d.memReclaimer.SetMetrics(m)
+	}
+	if d.diskWatch != nil {
+		m.SetDiskWatchCollector(d.DiskWatchStats)
+	}
+	d.updateTierMetrics()
+}
+
+// updateTierMetrics 在镜像构建后刷新各 chunk 分层的去重收益
+func (d *DedupStore) updateTierMetrics() {
+	if d.metrics == nil || d.erofsBuilder == nil {
+		return
+	}
+
+	stats, err := d.erofsBuilder.TierStats()
+	if err != nil {
+		log.L.WithError(err).Debug("failed to collect chunk tier stats")
+		return
+	}
+
+	tiers := make([]metrics.ChunkTierStats, 0, len(stats))
+	for _, s := range stats {
+		tiers = append(tiers, metrics.ChunkTierStats{
+			Tier:         s.Tier,
+			StoredBytes:  s.StoredSize,
+			LogicalBytes: s.LogicalSize,
+			DedupRatio:   s.DedupRatio,
+		})
+	}
+	d.metrics.UpdateChunkTiers(tiers)
+}
+
+// handleHeal 记录 EROFS 构建时损坏 chunk 的修复结果
+func (d *DedupStore) handleHeal(event erofs.HealEvent) {
+	if event.Error != "" {
+		log.L.Errorf("failed to heal chunk %s: %s", event.Hash, event.Error)
+		if d.metrics != nil {
+			d.metrics.IncChunkHealFailure()
+		}
+		return
+	}
+
+	log.L.Warnf("healed corrupt chunk %s from %s", event.Hash, event.Source)
+	if d.metrics != nil {
+		d.metrics.IncChunkHealed()
+	}
+}
+
+func (d *DedupStore) ConversionQueue() *ConversionQueue {
+	return d.conversions
+}
+
+// BindManager 在未启用 EROFS 时返回 nil
+func (d *DedupStore) BindManager() *erofs.BindManager {
+	return d.binds
+}
+
+// MountStrategy 返回父层 EROFS 镜像优先使用的挂载方式
+func (d *DedupStore) MountStrategy() string {
+	if d.useFscache && d.dedupDaemon != nil {
+		return MountTypeFscache
+	}
+	return MountTypeLoop
+}
+
 func (d *DedupStore) DiskUsage(ctx context.Context, id string) (UsageInfo, error) {
-	snapPath := filepath.Join(d.snapsDir, id)
+	snapPath := d.snapshotDir(id)
 
 	var size int64
 	err := filepath.Walk(snapPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
+		// 大目录的遍历可能很久,调用方取消或超时后立即放弃
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		if !info.IsDir() {
 			size += info.Size()
 		}
@@ -144,6 +567,14 @@ func (d *DedupStore) DiskUsage(ctx context.Context, id string) (UsageInfo, error
 }
 
 func (d *DedupStore) Prepare(ctx context.Context, id string, parents []string) error {
+	if err := d.checkSnapshotWritable(id); err != nil {
+		return err
+	}
+	// 新快照不应有镜像,清除同 ID 旧快照残留的映射和镜像
+	if !d.immutable {
+		d.forgetImage(id)
+	}
+
 	snapPath := filepath.Join(d.snapsDir, id)
 	if err := os.MkdirAll(snapPath, 0755); err != nil {
 		return err
@@ -158,58 +589,79 @@ func (d *DedupStore) Prepare(ctx context.Context, id string, parents []string) e
 	}
 
 	if err := d.writeMetadata(metadataPath, metadata); err != nil {
-		log.L.WithError(err).Warnf("failed to write metadata for snapshot %s", id)
+		log.G(ctx).WithError(err).Warnf("failed to write metadata for snapshot %s", id)
 	}
 
-	log.L.Debugf("prepared snapshot %s with parents %v", id, parents)
+	log.G(ctx).Debugf("prepared snapshot %s with parents %v", id, parents)
 	return nil
 }
 
-func (d *DedupStore) Mounts(id string, parents []string) ([]mount.Mount, error) {
+// StartIncrementalChunking 开始在后台预切分活动快照 upperdir 中写入的大文件
+func (d *DedupStore) StartIncrementalChunking(id string) {
+	if d.incremental == nil {
+		return
+	}
+	upperDir := filepath.Join(d.snapsDir, id, "fs")
+	if err := d.incremental.Watch(id, upperDir); err != nil {
+		log.L.WithError(err).Warnf("failed to watch upperdir of %s", id)
+	}
+}
+
+// StopIncrementalChunking 在 Commit 时停止监视,返回该快照是否启用过增量切分
+func (d *DedupStore) StopIncrementalChunking(id string) bool {
+	if d.incremental == nil {
+		return false
+	}
+	return d.incremental.Unwatch(id)
+}
+
+// Mounts 以父快照的 EROFS 镜像作为 lowerdir 挂载,opts 指定用户命名空间映射和 SELinux 上下文
+func (d *DedupStore) Mounts(ctx context.Context, id string, parents []string, opts MountOptions) ([]mount.Mount, error) {
 	if !d.useErofs || d.mountManager == nil {
 		return nil, fmt.Errorf("erofs is required: useErofs=%v, mountManager=%v", d.useErofs, d.mountManager != nil)
 	}
-	return d.mountsWithErofs(id, parents)
+	return d.mountsWithErofs(ctx, id, parents, opts)
 }
 
-func (d *DedupStore) mountsWithErofs(id string, parents []string) ([]mount.Mount, error) {
+func (d *DedupStore) mountsWithErofs(ctx context.Context, id string, parents []string, opts MountOptions) ([]mount.Mount, error) {
 	var lowerDirs []string
-
-	for _, parent := range parents {
-		imagePath := filepath.Join(d.imagesDir, parent+erofs.ErofsImageExt)
-		if _, err := os.Stat(imagePath); err != nil {
-			return nil, fmt.Errorf("erofs image not found for parent %s: %w", parent, err)
-		}
-
-		var mountPath string
-		var err error
-
-		if d.useFscache && d.dedupDaemon != nil {
-			fsid := parent
-			domain := "dedup-snapshotter"
-			mountPath, err = d.mountManager.MountErofsWithFscache(parent, fsid, domain)
-			if err != nil {
-				log.L.Warnf("fscache mount failed, falling back to loop mount: %v", err)
-				mountPath, err = d.mountManager.MountErofs(parent, imagePath)
-			}
+	var layers []LayerMount
+	start := time.Now()
+	mountType := ""
+
+	mountParents := parents
+	if flatPath, ok := d.flattenedImage(parents); ok {
+		flatID := erofs.FlattenedImageID(parents[0])
+		mountPath, err := d.mountManager.MountErofs(ctx, flatID, flatPath)
+		if err != nil {
+			log.G(ctx).WithError(err).Warnf("failed to mount flattened image %s, using full parent chain", flatID)
 		} else {
-			mountPath, err = d.mountManager.MountErofs(parent, imagePath)
+			lowerDirs = append(lowerDirs, mountPath)
+			layers = append(layers, LayerMount{Layer: flatID, Type: MountTypeLoop, Path: mountPath})
+			mountType = MountTypeLoop
+			mountParents = nil
+			go d.warmMetadata(flatID, flatPath, false)
 		}
+	}
 
+	for _, parent := range mountParents {
+		lm, err := d.mountLayer(ctx, parent)
 		if err != nil {
-			return nil, fmt.Errorf("failed to mount erofs image %s: %w", parent, err)
+			return nil, err
 		}
-		lowerDirs = append(lowerDirs, mountPath)
+		layers = append(layers, lm)
+		mountType = mergeMountType(mountType, lm.Type)
+		lowerDirs = append(lowerDirs, lm.Path)
+		if lm.Type == MountTypeDir {
+			continue
+		}
+		go d.warmMetadata(parent, d.imagePath(parent), lm.Type == MountTypeFscache)
 
-		if d.memDedup != nil {
-			go func(path string) {
-				filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
-					if err == nil && info.Mode().IsRegular() && info.Size() > 0 {
-						d.memDedup.DeduplicateFile(p)
-					}
-					return nil
-				})
-			}(mountPath)
+		if d.memScanner != nil {
+			d.memScanner.Scan(parent, lm.Path)
+		}
+		if d.memReclaimer != nil {
+			d.memReclaimer.Track(parent, lm.Path)
 		}
 	}
 
@@ -217,37 +669,263 @@ func (d *DedupStore) mountsWithErofs(id string, parents []string) ([]mount.Mount
 	workDir := filepath.Join(snapPath, "work")
 	upperDir := filepath.Join(snapPath, "fs")
 
-	return d.mountManager.CreateOverlayMounts(id, lowerDirs, upperDir, workDir)
+	if mountParents != nil && d.shouldFlatten(parents) {
+		go d.flattenChain(parents)
+	}
+
+	lowerDirs, err := d.mapLayers(ctx, id, lowerDirs, upperDir, opts.IDMap)
+	if err != nil {
+		return nil, err
+	}
+
+	mounts, err := d.mountManager.CreateOverlayMounts(ctx, id, lowerDirs, upperDir, workDir, d.mountLabel(ctx, id, opts))
+	if err != nil {
+		return nil, err
+	}
+	if mountType == "" {
+		mountType = MountTypeOverlay
+	}
+	d.recordMount(id, MountStrategyErofs, mountType, layers)
+	if d.metrics != nil {
+		d.metrics.ObserveOperation("mount", len(parents), mountType, time.Since(start))
+	}
+	return mounts, nil
+}
+
+// warmMetadata 在挂载后立即预取镜像的超级块、inode 和目录块,
+// 保证容器启动时的 ls/stat 不会在 trace 预取完成前阻塞在网络上
+func (d *DedupStore) warmMetadata(imageID, imagePath string, fscacheBacked bool) {
+	if _, loaded := d.warmed.LoadOrStore(imageID, true); loaded {
+		return
+	}
+
+	extents, err := erofs.MetadataExtents(imagePath)
+	if err != nil {
+		d.warmed.Delete(imageID)
+		log.L.WithError(err).Debugf("failed to parse erofs metadata of %s", imageID)
+		return
+	}
+
+	if fscacheBacked && d.dedupDaemon != nil {
+		warmed, err := d.dedupDaemon.WarmImageRanges(imageID, imagePath, erofs.ChunkOffsets(extents, erofs.ChunkSize), erofs.ChunkSize)
+		if err != nil {
+			d.warmed.Delete(imageID)
+			log.L.WithError(err).Debugf("failed to warm metadata of %s", imageID)
+		} else {
+			log.L.Debugf("warmed %d bytes of metadata of %s", warmed, imageID)
+		}
+		return
+	}
+
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	var total int64
+	for _, ext := range extents {
+		unix.Fadvise(int(f.Fd()), ext.Offset, ext.Length, unix.FADV_WILLNEED)
+		total += ext.Length
+	}
+	log.L.Debugf("read ahead %d bytes of metadata in %d extents of %s", total, len(extents), imageID)
+}
+
+// handleEviction 在挂载中镜像 blob 的缓存对象被内核淘汰后重新预热元数据
+func (d *DedupStore) handleEviction(e fscache.EvictionEvent) {
+	if e.Key != fscache.ImageObjectKey(e.Volume) {
+		return
+	}
+	d.warmed.Delete(e.Volume)
+	if d.dedupDaemon.IsMounted(e.Volume) {
+		go d.warmMetadata(e.Volume, d.imagePath(e.Volume), true)
+	}
+}
+
+// mergeMountType 汇总一条父链上各层实际使用的挂载方式
+func mergeMountType(current, next string) string {
+	if current == "" || current == next {
+		return next
+	}
+	return MountTypeMixed
+}
+
+// flattenedImage 返回以 parents[0] 为顶层的父链已构建好的扁平化镜像
+func (d *DedupStore) flattenedImage(parents []string) (string, bool) {
+	if len(parents) < 2 {
+		return "", false
+	}
+	imagePath := filepath.Join(d.imagesDir, erofs.FlattenedImageID(parents[0])+erofs.ErofsImageExt)
+	if _, err := os.Stat(imagePath); err != nil {
+		return "", false
+	}
+	return imagePath, true
+}
+
+func (d *DedupStore) shouldFlatten(parents []string) bool {
+	cfg := d.cfg()
+	if cfg == nil || !cfg.Flatten.Enabled || d.erofsBuilder == nil {
+		return false
+	}
+	if len(parents) <= cfg.Flatten.Threshold && len(parents) <= d.mountManager.OverlayLimits().MaxLowerDirs {
+		return false
+	}
+
+	d.flattenMu.Lock()
+	defer d.flattenMu.Unlock()
+
+	if d.flattening[parents[0]] {
+		return false
+	}
+	d.flattening[parents[0]] = true
+	return true
+}
+
+// flattenChain 在后台把父链合并为单个 EROFS 镜像,之后的挂载将只使用这个镜像作为 lowerdir
+func (d *DedupStore) flattenChain(parents []string) {
+	top := parents[0]
+	defer func() {
+		d.flattenMu.Lock()
+		delete(d.flattening, top)
+		d.flattenMu.Unlock()
+	}()
+
+	ctx := context.Background()
+	// 超过 overlay 层数上限的父链必须扁平化才能挂载,不等待维护窗口
+	if cfg := d.cfg(); cfg != nil && len(parents) <= d.mountManager.OverlayLimits().MaxLowerDirs && !d.maintenance.Active() {
+		log.L.Infof("deferring flattening of %s until the next maintenance window", top)
+		if err := d.maintenance.Wait(ctx); err != nil {
+			return
+		}
+	}
+	start := time.Now()
+	log.L.Infof("flattening parent chain of %s (%d layers)", top, len(parents))
+
+	var lowerDirs []string
+	var mounted []string
+	defer func() {
+		for _, parent := range mounted {
+			if err := d.mountManager.Unmount(parent); err != nil {
+				log.L.WithError(err).Warnf("failed to release mount %s after flattening", parent)
+			}
+		}
+	}()
+
+	for _, parent := range parents {
+		imagePath := d.imagePath(parent)
+		mountPath, err := d.mountManager.MountErofs(ctx, parent, imagePath)
+		if err != nil {
+			log.L.WithError(err).Warnf("flattening %s aborted: failed to mount parent %s", top, parent)
+			return
+		}
+		mounted = append(mounted, parent)
+		lowerDirs = append(lowerDirs, mountPath)
+	}
+
+	if _, err := d.erofsBuilder.BuildFlattenedImage(ctx, lowerDirs, erofs.FlattenedImageID(top)); err != nil {
+		log.L.WithError(err).Warnf("failed to flatten parent chain of %s", top)
+		return
+	}
+
+	if d.metrics != nil {
+		d.metrics.AddBuildTime(time.Since(start))
+	}
+	log.L.Infof("flattened parent chain of %s in %v", top, time.Since(start))
 }
 
 func (d *DedupStore) Remove(ctx context.Context, id string) error {
+	if err := d.checkSnapshotWritable(id); err != nil {
+		return err
+	}
+	if d.memScanner != nil {
+		d.memScanner.Cancel(id)
+	}
+	if d.memReclaimer != nil {
+		d.memReclaimer.Untrack(id)
+	}
 	if d.useErofs && d.mountManager != nil {
 		if err := d.mountManager.Unmount(id); err != nil {
-			log.L.WithError(err).Warnf("failed to unmount %s", id)
+			log.G(ctx).WithError(err).Warnf("failed to unmount %s", id)
 		}
 	}
 
+	if d.mountManager != nil {
+		d.mountManager.ReleaseSnapshot(id)
+	}
+	if d.dedupDaemon != nil {
+		d.dedupDaemon.SetMounted(id, false)
+	}
+	d.warmed.Delete(id)
+	d.mountRecords.forget(id)
+	if d.immutable {
+		return os.RemoveAll(filepath.Join(d.snapsDir, id))
+	}
+	d.forgetImage(id)
+	if d.incremental != nil {
+		d.incremental.Forget(id, filepath.Join(d.snapsDir, id, "fs"))
+	}
+
+	flatID := erofs.FlattenedImageID(id)
+	flatPath := filepath.Join(d.imagesDir, flatID+erofs.ErofsImageExt)
+	if _, err := os.Stat(flatPath); err == nil {
+		if d.mountManager != nil {
+			if _, mounted := d.mountManager.GetMountPath(flatID); mounted {
+				if err := d.mountManager.Unmount(flatID); err != nil {
+					log.G(ctx).WithError(err).Warnf("failed to unmount flattened image %s", flatID)
+				}
+			}
+		}
+		if err := os.Remove(flatPath); err != nil {
+			log.G(ctx).WithError(err).Warnf("failed to remove flattened image %s", flatPath)
+		}
+	}
+	if d.mountManager != nil && d.erofsBuilder != nil {
+		d.mountManager.PruneFoldedLowers(d.imagesDir, d.removeImage)
+	}
+
 	snapPath := filepath.Join(d.snapsDir, id)
 	return os.RemoveAll(snapPath)
 }
 
 func (d *DedupStore) BuildErofsImage(ctx context.Context, sourceDir, imageID string) error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	if err := d.checkDiskSpace(); err != nil {
+		return err
+	}
 	if !d.useErofs || d.erofsBuilder == nil {
 		return fmt.Errorf("erofs not enabled")
 	}
 
-	imagePath, err := d.erofsBuilder.BuildImage(ctx, sourceDir, imageID)
+	imagePath, err := d.erofsBuilder.BuildImageOrdered(ctx, sourceDir, d.imageKey(imageID), d.accessOrder(imageID), nil)
 	if err != nil {
 		return err
 	}
 
-	log.L.Infof("built erofs image for %s at %s", imageID, imagePath)
+	d.signArtifact(imagePath)
+	log.G(ctx).Infof("built erofs image for %s at %s", imageID, imagePath)
+	d.updateTierMetrics()
 	return nil
 }
 
 func (d *DedupStore) Close() error {
 	var errs []error
 
+	if d.diskWatch != nil {
+		d.diskWatch.stop()
+	}
+
+	if d.conversions != nil {
+		d.conversions.Close()
+	}
+
+	if d.incremental != nil {
+		if err := d.incremental.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
 	if d.erofsBuilder != nil {
 		if err := d.erofsBuilder.Close(); err != nil {
 			errs = append(errs, err)
@@ -266,12 +944,33 @@ func (d *DedupStore) Close() error {
 		}
 	}
 
+	if d.memScanner != nil {
+		d.memScanner.Close()
+	}
+
+	if d.memReclaimer != nil {
+		d.memReclaimer.Close()
+	}
+
 	if d.memDedup != nil {
 		if err := d.memDedup.Close(); err != nil {
 			errs = append(errs, err)
 		}
 	}
 
+	d.background.Close()
+
+	if d.ledger != nil {
+		d.ledger.Close()
+	}
+
+	if d.ReadOnly() || d.immutable {
+		d.indexDB.Close()
+	}
+	if d.storeLock != nil {
+		d.storeLock.Release()
+	}
+
 	if len(errs) > 0 {
 		return fmt.Errorf("cleanup errors: %v", errs)
 	}
@@ -280,11 +979,63 @@ func (d *DedupStore) Close() error {
 }
 
 func (d *DedupStore) StartPrefetch(ctx context.Context, imageID string, traceFile string) error {
+	return d.StartPrefetchWithFilter(ctx, imageID, traceFile, nil)
+}
+
+// StartPrefetchWithFilter 按 trace 预取镜像,filter 为 nil 时使用 prefetch.policy_file 中为镜像定义的过滤
+func (d *DedupStore) StartPrefetchWithFilter(ctx context.Context, imageID string, traceFile string, filter *fscache.PrefetchFilter) error {
 	if !d.useFscache || d.dedupDaemon == nil {
 		return fmt.Errorf("fscache not enabled")
 	}
 
-	return d.dedupDaemon.StartPrefetch(ctx, imageID, traceFile)
+	if filter == nil {
+		var err error
+		if filter, err = d.prefetchPolicyFilter(imageID); err != nil {
+			return err
+		}
+	}
+	return d.dedupDaemon.StartPrefetchWithFilter(ctx, imageID, traceFile, filter)
+}
+
+// prefetchPolicyFilter 读取预取策略文件中适用于镜像的过滤,未配置策略文件时返回 nil
+func (d *DedupStore) prefetchPolicyFilter(imageID string) (*fscache.PrefetchFilter, error) {
+	file := d.cfg().Prefetch.PolicyFile
+	if file == "" {
+		return nil, nil
+	}
+	policy, err := fscache.LoadPrefetchPolicy(file)
+	if err != nil {
+		return nil, err
+	}
+	return policy.For(imageID), nil
+}
+
+// PrefetchStatuses 返回进行中的预取任务,未启用 fscache 时为空
+func (d *DedupStore) PrefetchStatuses() []*fscache.PrefetchStatus {
+	if !d.useFscache || d.dedupDaemon == nil {
+		return nil
+	}
+	return d.dedupDaemon.PrefetchStatuses()
+}
+
+// NegativeCacheStats 返回负查找缓存的命中统计,只读附着时为空
+func (d *DedupStore) NegativeCacheStats() *fscache.NegativeCacheStats {
+	if d.negative == nil {
+		return nil
+	}
+	stats := d.negative.Stats()
+	return &stats
+}
+
+// CleanupFscacheVolumes 立即清理不再对应镜像或快照的 fscache 卷,返回删除的卷数
+func (d *DedupStore) CleanupFscacheVolumes(ctx context.Context) (int, error) {
+	if err := d.checkWritable(); err != nil {
+		return 0, err
+	}
+	if !d.useFscache || d.dedupDaemon == nil {
+		return 0, fmt.Errorf("fscache not enabled")
+	}
+	return d.dedupDaemon.CleanupVolumes(ctx, d.volumeInUse)
 }
 
 func (d *DedupStore) RegisterImageForFscache(ctx context.Context, imageID string, manifestPath string) error {
@@ -295,24 +1046,38 @@ func (d *DedupStore) RegisterImageForFscache(ctx context.Context, imageID string
 	return d.dedupDaemon.RegisterImage(ctx, imageID, manifestPath)
 }
 
+// RegisterDirForFscache 为从目录转换的层生成清单并注册到 fscache
+func (d *DedupStore) RegisterDirForFscache(ctx context.Context, layerID string, sourceDir string) error {
+	if !d.useFscache || d.dedupDaemon == nil {
+		return fmt.Errorf("fscache not enabled")
+	}
+
+	manifestPath := d.layerProcessor.generateManifestPath(layerID)
+	if err := d.layerProcessor.generateDirManifest(layerID, sourceDir, manifestPath); err != nil {
+		return fmt.Errorf("failed to generate manifest: %w", err)
+	}
+
+	return d.dedupDaemon.RegisterImage(ctx, layerID, manifestPath)
+}
+
 func (d *DedupStore) WriteFile(ctx context.Context, path string, data io.Reader) error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
 	chunks, err := d.chunkData(data)
 	if err != nil {
 		return err
 	}
 
-	for _, chunk := range chunks {
-		if err := d.storeChunk(ctx, chunk); err != nil {
-			return err
-		}
-	}
-
+	// 引用计数由 IndexFile 按文件中 chunk 的出现次数记录
 	return d.indexDB.IndexFile(path, chunks)
 }
 
 func (d *DedupStore) chunkData(data io.Reader) ([]ChunkInfo, error) {
 	var chunks []ChunkInfo
-	buf := make([]byte, ChunkSize)
+	pooled := bufpool.Default().Get()
+	defer bufpool.Default().Put(pooled)
+	buf := pooled.B[:ChunkSize]
 
 	for {
 		n, err := io.ReadFull(data, buf)
@@ -325,6 +1090,9 @@ func (d *DedupStore) chunkData(data io.Reader) ([]ChunkInfo, error) {
 
 		hash := sha256.Sum256(buf[:n])
 		hashStr := hex.EncodeToString(hash[:])
+		if err := d.storeChunk(hashStr, buf[:n]); err != nil {
+			return nil, err
+		}
 
 		chunks = append(chunks, ChunkInfo{
 			Hash: hashStr,
@@ -339,13 +1107,34 @@ func (d *DedupStore) chunkData(data io.Reader) ([]ChunkInfo, error) {
 	return chunks, nil
 }
 
-func (d *DedupStore) storeChunk(ctx context.Context, chunk ChunkInfo) error {
-	chunkPath := filepath.Join(d.chunksDir, chunk.Hash)
-
+// storeChunk 按内容哈希把 chunk 写入 chunks 目录,已存在时跳过。
+// 先写临时文件再重命名,并发写入同一 chunk 时不会留下不完整的文件
+func (d *DedupStore) storeChunk(hash string, data []byte) error {
+	chunkPath := filepath.Join(d.chunksDir, hash)
 	if _, err := os.Stat(chunkPath); err == nil {
-		return d.indexDB.IncrementRefCount(chunk.Hash)
+		return nil
+	}
+	tmp, err := os.CreateTemp(d.chunksDir, "."+hash+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to create chunk file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write chunk %s: %w", hash, err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write chunk %s: %w", hash, err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), chunkPath); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to store chunk %s: %w", hash, err)
 	}
-
 	return nil
 }
 
@@ -400,75 +1189,165 @@ func (d *DedupStore) VerifySnapshot(id string) error {
 	return nil
 }
 
+// RecoverSnapshots 并行校验所有快照的元数据和文件系统目录
 func (d *DedupStore) RecoverSnapshots(ctx context.Context) error {
-	log.L.Info("starting snapshot recovery")
+	log.G(ctx).Info("starting snapshot recovery")
+	start := time.Now()
 
 	entries, err := os.ReadDir(d.snapsDir)
 	if err != nil {
 		return fmt.Errorf("failed to read snapshots directory: %w", err)
 	}
 
-	recoveredCount := 0
+	var ids []string
 	for _, entry := range entries {
-		if !entry.IsDir() {
-			continue
+		if entry.IsDir() {
+			ids = append(ids, entry.Name())
 		}
+	}
 
-		id := entry.Name()
+	var recoveredCount int64
+	err = forEachParallel(ctx, ids, d.cfg().Recovery.Workers, nil, func(id string) {
 		if err := d.VerifySnapshot(id); err != nil {
-			log.L.WithError(err).Warnf("snapshot %s verification failed, skipping", id)
-			continue
+			log.G(ctx).WithError(err).Warnf("snapshot %s verification failed, skipping", id)
+			return
 		}
+		atomic.AddInt64(&recoveredCount, 1)
+	})
 
-		recoveredCount++
-	}
-
-	log.L.Infof("recovered %d snapshots", recoveredCount)
-	return nil
+	log.G(ctx).Infof("recovered %d of %d snapshots in %v", recoveredCount, len(ids), time.Since(start))
+	return err
 }
 
+// VerifyChunks 按配置的校验深度检查 chunk 文件:
+// none 跳过,quick 只检查文件存在且非空,full 重新计算哈希并与文件名比对
 func (d *DedupStore) VerifyChunks(ctx context.Context) error {
-	log.L.Info("verifying chunk files")
+	mode := d.cfg().Recovery.VerifyMode
+	if mode == config.VerifyModeNone {
+		log.G(ctx).Info("chunk verification disabled")
+		return nil
+	}
+
+	log.G(ctx).Infof("verifying chunk files (%s)", mode)
+	start := time.Now()
 
 	entries, err := os.ReadDir(d.chunksDir)
 	if err != nil {
 		return fmt.Errorf("failed to read chunks directory: %w", err)
 	}
 
-	verifiedCount := 0
-	missingCount := 0
-
+	var hashes []string
 	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
+		if !entry.IsDir() {
+			hashes = append(hashes, entry.Name())
 		}
+	}
 
-		chunkHash := entry.Name()
+	// 后台校验在维护窗口外节流,阻塞启动的校验不受限制
+	var throttle *maintenance.Scheduler
+	if d.cfg().Recovery.Background {
+		throttle = d.maintenance
+	}
+
+	var verifiedCount, missingCount, corruptCount int64
+	err = forEachParallel(ctx, hashes, d.cfg().Recovery.Workers, d.background, func(chunkHash string) {
+		defer throttle.Throttle(ctx)
 		chunkPath := filepath.Join(d.chunksDir, chunkHash)
 
 		info, err := os.Stat(chunkPath)
 		if err != nil {
-			missingCount++
-			log.L.WithError(err).Warnf("chunk file %s missing or inaccessible", chunkHash)
-			continue
+			atomic.AddInt64(&missingCount, 1)
+			log.G(ctx).WithError(err).Warnf("chunk file %s missing or inaccessible", chunkHash)
+			return
 		}
 
 		if info.Size() == 0 {
-			missingCount++
-			log.L.Warnf("chunk file %s is empty", chunkHash)
-			continue
+			atomic.AddInt64(&missingCount, 1)
+			log.G(ctx).Warnf("chunk file %s is empty", chunkHash)
+			return
 		}
 
-		verifiedCount++
+		if mode == config.VerifyModeFull {
+			if err := verifyChunkHash(chunkPath, chunkHash); err != nil {
+				atomic.AddInt64(&corruptCount, 1)
+				log.G(ctx).WithError(err).Warnf("chunk file %s is corrupt", chunkHash)
+				return
+			}
+		}
+
+		atomic.AddInt64(&verifiedCount, 1)
+	})
+
+	log.G(ctx).Infof("chunk verification: %d verified, %d missing or invalid, %d corrupt in %v",
+		verifiedCount, missingCount, corruptCount, time.Since(start))
+	return err
+}
+
+func verifyChunkHash(path, expected string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
 	}
+	defer f.Close()
 
-	log.L.Infof("chunk verification: %d verified, %d missing or invalid", verifiedCount, missingCount)
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != expected {
+		return fmt.Errorf("hash mismatch: got %s", actual)
+	}
 	return nil
 }
 
+// forEachParallel 用 workers 个协程处理 items,ctx 取消后不再分发新任务,
+// 已分发但未开始的任务也被跳过;bg 非 nil 时协程以后台优先级运行
+func forEachParallel(ctx context.Context, items []string, workers int, bg *background.Controller, fn func(item string)) error {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		bg.Go(func() {
+			defer wg.Done()
+			for item := range work {
+				if ctx.Err() == nil {
+					fn(item)
+				}
+			}
+		})
+	}
+
+	var err error
+	for _, item := range items {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		select {
+		case work <- item:
+			continue
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		break
+	}
+	close(work)
+	wg.Wait()
+	return err
+}
+
 // ApplyLayer 应用一个 OCI 层到快照系统
 // 这个方法会被 containerd 在镜像拉取时调用
 func (d *DedupStore) ApplyLayer(ctx context.Context, layerID string, layerData io.Reader, parentID string) error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	if err := d.checkDiskSpace(); err != nil {
+		return err
+	}
 	if d.layerProcessor == nil {
 		return fmt.Errorf("layer processor not initialized")
 	}
@@ -494,12 +1373,11 @@ func (d *DedupStore) GetLayerMetadata(layerID string) (*LayerMetadata, error) {
 
 // HasErofsImage 检查是否已经有 EROFS 镜像
 func (d *DedupStore) HasErofsImage(imageID string) bool {
-	imagePath := filepath.Join(d.imagesDir, imageID+".erofs")
-	_, err := os.Stat(imagePath)
+	_, err := os.Stat(d.imagePath(imageID))
 	return err == nil
 }
 
 // GetSnapshotPath 获取快照路径
 func (d *DedupStore) GetSnapshotPath(snapID string) string {
-	return filepath.Join(d.snapsDir, snapID)
+	return d.snapshotDir(snapID)
 }
@@ -5,9 +5,12 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -61,12 +64,7 @@ func TestChunkLevelDeduplication(t *testing.T) {
 		t.Fatalf("failed to read chunks dir: %v", err)
 	}
 
-	uniqueChunks := 0
-	for _, chunk := range chunks {
-		if !chunk.IsDir() {
-			uniqueChunks++
-		}
-	}
+	uniqueChunks := countNonDirEntries(chunks)
 
 	// 关键断言: 3个文件共24MB数据,但只应该存储3个唯一的4MB块
 	if uniqueChunks != 3 {
@@ -122,13 +120,12 @@ func TestBlockBoundaryFixedSize(t *testing.T) {
 
 	uniqueChunks := countNonDirEntries(chunks)
 
-	// 预期: 3个唯一块
-	// - fileA块1 (4MB零)
-	// - fileA块2 (4MB零) = fileB块2 (共享)
+	// 预期: 2个唯一块
+	// - fileA块1 (4MB零) = fileA块2 = fileB块2 (共享)
 	// - fileB块1 (1MB随机+3MB零)
-	if uniqueChunks != 3 {
-		t.Errorf("Expected 3 unique chunks for fixed-size chunking, got %d", uniqueChunks)
-		t.Logf("固定块大小验证失败: 应该是3个块(证明固定4MB切分)")
+	if uniqueChunks != 2 {
+		t.Errorf("Expected 2 unique chunks for fixed-size chunking, got %d", uniqueChunks)
+		t.Logf("固定块大小验证失败: 应该是2个块(证明固定4MB切分)")
 	} else {
 		t.Logf("✓ 固定块大小验证通过: 使用固定4MB切分,而非内容感知分块")
 	}
@@ -207,8 +204,8 @@ func TestMetadataPreservation(t *testing.T) {
 	}
 
 	// 验证内容相同(通过哈希)
-	hashA := hashFile(t, fileAPath)
-	hashB := hashFile(t, fileBPath)
+	hashA := hashTestFile(t, fileAPath)
+	hashB := hashTestFile(t, fileBPath)
 	if hashA != hashB {
 		t.Errorf("File contents should be identical")
 	} else {
@@ -229,17 +226,17 @@ func TestDeduplicationRatio(t *testing.T) {
 
 	ctx := context.Background()
 
-	// 创建共享块
-	sharedBlock := bytes.Repeat([]byte("SHARED"), 4*1024*1024)
+	// 创建共享块,正好一个 4MB 块
+	sharedBlock := bytes.Repeat([]byte("SHARED"), 1024*1024)[:4*1024*1024]
 
 	// 创建10个文件,每个文件都包含这个共享块
 	for i := 0; i < 10; i++ {
 		fileData := append([]byte{}, sharedBlock...)
 		// 每个文件再加一个唯一块
-		uniqueBlock := bytes.Repeat([]byte(string('A'+i)), 4*1024*1024)
+		uniqueBlock := bytes.Repeat([]byte(string(rune('A'+i))), 4*1024*1024)
 		fileData = append(fileData, uniqueBlock...)
 
-		fileName := filepath.Join("test", string('A'+i))
+		fileName := filepath.Join("test", string(rune('A'+i)))
 		if err := store.WriteFile(ctx, fileName, bytes.NewReader(fileData)); err != nil {
 			t.Fatalf("failed to write file %s: %v", fileName, err)
 		}
@@ -296,14 +293,14 @@ func TestConcurrentDeduplication(t *testing.T) {
 
 	ctx := context.Background()
 
-	// 相同数据块
-	sharedData := bytes.Repeat([]byte("CONCURRENT"), 4*1024*1024)
+	// 相同数据块,正好一个 4MB 块
+	sharedData := bytes.Repeat([]byte("CONCURRENT"), 1024*1024)[:4*1024*1024]
 
 	// 并发写入多个文件
 	done := make(chan error, 5)
 	for i := 0; i < 5; i++ {
 		go func(id int) {
-			fileName := filepath.Join("concurrent", string('A'+id))
+			fileName := filepath.Join("concurrent", string(rune('A'+id)))
 			done <- store.WriteFile(ctx, fileName, bytes.NewReader(sharedData))
 		}(i)
 	}
@@ -358,7 +355,7 @@ func countNonDirEntries(entries []os.DirEntry) int {
 	return count
 }
 
-func hashFile(t *testing.T, path string) string {
+func hashTestFile(t *testing.T, path string) string {
 	f, err := os.Open(path)
 	if err != nil {
 		t.Fatalf("failed to open file for hashing: %v", err)
@@ -372,3 +369,47 @@ func hashFile(t *testing.T, path string) string {
 
 	return hex.EncodeToString(h.Sum(nil))
 }
+
+// TestWalkCancellation 验证 ctx 取消后磁盘占用统计、快照恢复和 chunk 校验立即返回
+func TestWalkCancellation(t *testing.T) {
+	tmpDir := t.TempDir()
+	store, err := NewDedupStoreWithErofs(tmpDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	fsDir := filepath.Join(store.snapsDir, "snap", "fs")
+	if err := os.MkdirAll(fsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		os.WriteFile(filepath.Join(fsDir, fmt.Sprintf("file-%d", i)), []byte("data"), 0644)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := store.DiskUsage(ctx, "snap"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected DiskUsage to stop on cancelled ctx, got %v", err)
+	}
+	if err := store.RecoverSnapshots(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected RecoverSnapshots to stop on cancelled ctx, got %v", err)
+	}
+	if usage, err := store.DiskUsage(context.Background(), "snap"); err != nil || usage.Size != 400 {
+		t.Errorf("unexpected usage %+v (%v)", usage, err)
+	}
+	t.Logf("✓ 取消后遍历立即返回")
+
+	var processed int64
+	items := make([]string, 1000)
+	ctx, cancel = context.WithCancel(context.Background())
+	err = forEachParallel(ctx, items, 4, nil, func(string) {
+		if atomic.AddInt64(&processed, 1) == 10 {
+			cancel()
+		}
+	})
+	if !errors.Is(err, context.Canceled) || processed >= int64(len(items)) {
+		t.Errorf("expected dispatch to stop after cancel, processed %d (%v)", processed, err)
+	}
+	t.Logf("✓ 取消后只处理了 %d/%d 项", processed, len(items))
+}
@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+	"github.com/opencloudos/dedup-snapshotter/pkg/metrics"
+	"golang.org/x/sys/unix"
+)
+
+// ErrDiskFull 表示存储所在文件系统的可用空间低于 disk_watch.refuse_percent,新的转换被拒绝
+var ErrDiskFull = errors.New("store filesystem nearly full")
+
+// 磁盘水位等级,可用空间越少等级越高,每一级同时执行前面各级的处置
+const (
+	diskLevelOK = iota
+	diskLevelPause
+	diskLevelEvict
+	diskLevelGC
+	diskLevelRefuse
+)
+
+var diskLevelNames = [...]string{"ok", "pause_prefetch", "evict", "gc", "refuse"}
+
+// diskUsage 是受监控文件系统中可用空间比例最低的一个
+type diskUsage struct {
+	path    string
+	free    int64
+	percent float64
+}
+
+// diskWatch 是磁盘空间看门狗的状态,root 和临时空间在不同文件系统上时按可用比例较低的一个判断
+type diskWatch struct {
+	paths []string
+	// statfs 返回 path 所在文件系统的可用和总字节数,测试中替换
+	statfs func(path string) (free, total int64, err error)
+
+	mu    sync.Mutex
+	stats metrics.DiskWatchStats
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newDiskWatch(paths ...string) *diskWatch {
+	w := &diskWatch{statfs: statfsFree}
+	for _, p := range paths {
+		dup := false
+		for _, q := range w.paths {
+			dup = dup || p == q
+		}
+		if !dup {
+			w.paths = append(w.paths, p)
+		}
+	}
+	w.stats.LevelName = diskLevelNames[diskLevelOK]
+	return w
+}
+
+func statfsFree(path string) (int64, int64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, 0, fmt.Errorf("failed to stat filesystem of %s: %w", path, err)
+	}
+	return int64(st.Bavail) * int64(st.Bsize), int64(st.Blocks) * int64(st.Bsize), nil
+}
+
+// measure 返回各受监控文件系统中可用比例最低的一个
+func (w *diskWatch) measure() (diskUsage, error) {
+	var worst diskUsage
+	for i, path := range w.paths {
+		free, total, err := w.statfs(path)
+		if err != nil {
+			return diskUsage{}, err
+		}
+		percent := 100.0
+		if total > 0 {
+			percent = float64(free) / float64(total) * 100
+		}
+		if i == 0 || percent < worst.percent {
+			worst = diskUsage{path: path, free: free, percent: percent}
+		}
+	}
+	return worst, nil
+}
+
+// diskLevel 返回可用比例对应的水位等级
+func diskLevel(cfg config.DiskWatchConfig, percent float64) int {
+	switch {
+	case cfg.Disabled:
+		return diskLevelOK
+	case percent < cfg.RefusePercent:
+		return diskLevelRefuse
+	case percent < cfg.GCPercent:
+		return diskLevelGC
+	case percent < cfg.EvictPercent:
+		return diskLevelEvict
+	case percent < cfg.PausePercent:
+		return diskLevelPause
+	}
+	return diskLevelOK
+}
+
+// Stats 返回看门狗当前的状态
+func (w *diskWatch) Stats() *metrics.DiskWatchStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stats := w.stats
+	return &stats
+}
+
+// startDiskWatch 启动看门狗,每 disk_watch.interval 秒检查一次,间隔和阈值的修改在下一次检查时生效
+func (d *DedupStore) startDiskWatch() {
+	w := d.diskWatch
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	w.done = make(chan struct{})
+	go func() {
+		defer close(w.done)
+		for {
+			d.checkDisk(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Duration(d.cfg().DiskWatch.Interval) * time.Second):
+			}
+		}
+	}()
+}
+
+func (w *diskWatch) stop() {
+	if w.cancel != nil {
+		w.cancel()
+		<-w.done
+	}
+}
+
+// checkDisk 检查一次可用空间并逐级处置:清空未挂载镜像的 fscache 卷、清理孤儿卷,每步之后重新测量,
+// 按最终的水位暂停预取或拒绝新的转换。空间恢复后恢复预取、解除拒绝
+func (d *DedupStore) checkDisk(ctx context.Context) {
+	w := d.diskWatch
+	cfg := d.cfg().DiskWatch
+	usage, err := w.measure()
+	if err != nil {
+		log.G(ctx).WithError(err).Warn("disk watch check failed")
+		return
+	}
+
+	var evicted, gcRuns int64
+	if diskLevel(cfg, usage.percent) >= diskLevelEvict && d.dedupDaemon != nil {
+		n, err := d.dedupDaemon.EvictColdVolumes(ctx)
+		if err != nil {
+			log.G(ctx).WithError(err).Warn("failed to evict cold fscache volumes")
+		}
+		if n > 0 {
+			evicted = int64(n)
+			log.G(ctx).Warnf("disk space low (%.1f%% free on %s), emptied %d cold fscache volumes", usage.percent, usage.path, n)
+			if u, err := w.measure(); err == nil {
+				usage = u
+			}
+		}
+	}
+	if diskLevel(cfg, usage.percent) >= diskLevelGC && d.dedupDaemon != nil {
+		gcRuns = 1
+		n, err := d.dedupDaemon.CleanupVolumes(ctx, d.volumeInUse)
+		if err != nil {
+			log.G(ctx).WithError(err).Warn("fscache volume gc failed")
+		}
+		if n > 0 {
+			log.G(ctx).Warnf("disk space low (%.1f%% free on %s), removed %d orphan fscache volumes", usage.percent, usage.path, n)
+			if u, err := w.measure(); err == nil {
+				usage = u
+			}
+		}
+	}
+	level := diskLevel(cfg, usage.percent)
+
+	w.mu.Lock()
+	prev := w.stats.Level
+	w.stats.Level = level
+	w.stats.LevelName = diskLevelNames[level]
+	w.stats.Path = usage.path
+	w.stats.FreePercent = usage.percent
+	w.stats.FreeBytes = usage.free
+	w.stats.VolumesEvicted += evicted
+	w.stats.GCRuns += gcRuns
+	paused := level >= diskLevelPause
+	pauseChanged := paused != w.stats.PrefetchPaused
+	w.stats.PrefetchPaused = paused
+	w.mu.Unlock()
+
+	if pauseChanged && d.dedupDaemon != nil {
+		d.dedupDaemon.PausePrefetch(paused)
+	}
+	switch {
+	case level > prev:
+		log.G(ctx).Warnf("disk space low: %.1f%% (%d MB) free on %s, watchdog level %s", usage.percent, usage.free>>20, usage.path, diskLevelNames[level])
+	case level < prev:
+		log.G(ctx).Infof("disk space recovered: %.1f%% free on %s, watchdog level %s", usage.percent, usage.path, diskLevelNames[level])
+	}
+}
+
+// checkDiskSpace 在看门狗处于拒绝等级时返回 ErrDiskFull,转换开始前调用
+func (d *DedupStore) checkDiskSpace() error {
+	w := d.diskWatch
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stats.Level < diskLevelRefuse {
+		return nil
+	}
+	w.stats.ConversionsRefused++
+	return fmt.Errorf("%w: %.1f%% (%d MB) free on %s is below disk_watch.refuse_percent, new conversions are refused until space is freed",
+		ErrDiskFull, w.stats.FreePercent, w.stats.FreeBytes>>20, w.stats.Path)
+}
+
+// DiskWatchStats 返回磁盘空间看门狗的状态,只读存储返回 nil
+func (d *DedupStore) DiskWatchStats() *metrics.DiskWatchStats {
+	if d.diskWatch == nil {
+		return nil
+	}
+	return d.diskWatch.Stats()
+}
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+)
+
+// TestDiskWatchLevels 验证可用空间逐级下降时看门狗依次暂停预取、淘汰、GC,最后拒绝新的转换,
+// 空间恢复后解除拒绝
+func TestDiskWatchLevels(t *testing.T) {
+	store, err := NewDedupStoreWithErofs(t.TempDir(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	allowSourceDirs(store, os.TempDir())
+
+	// 停止后台检查,改用假的文件系统统计手动触发
+	w := store.diskWatch
+	w.stop()
+	free := int64(50)
+	w.statfs = func(string) (int64, int64, error) { return free, 100, nil }
+
+	for _, tc := range []struct {
+		free   int64
+		level  string
+		paused bool
+	}{
+		{50, "ok", false},
+		{12, "pause_prefetch", true},
+		{8, "evict", true},
+		{6, "gc", true},
+		{3, "refuse", true},
+	} {
+		free = tc.free
+		store.checkDisk(context.Background())
+		stats := store.DiskWatchStats()
+		if stats.LevelName != tc.level || stats.PrefetchPaused != tc.paused || stats.FreePercent != float64(tc.free) {
+			t.Fatalf("at %d%% free expected level %s (paused=%v), got %+v", tc.free, tc.level, tc.paused, stats)
+		}
+	}
+	t.Logf("✓ 可用空间下降时水位依次升到 refuse")
+
+	if _, err := store.ConversionQueue().SubmitDirectory(t.TempDir(), "img"); !errors.Is(err, ErrDiskFull) {
+		t.Fatalf("expected conversion to be refused, got %v", err)
+	}
+	err = store.BuildErofsImage(context.Background(), t.TempDir(), "img")
+	if !errors.Is(err, ErrDiskFull) {
+		t.Fatalf("expected build to be refused, got %v", err)
+	}
+	if n := store.DiskWatchStats().ConversionsRefused; n != 2 {
+		t.Errorf("expected 2 refused conversions, got %d", n)
+	}
+	t.Logf("✓ 磁盘将满时拒绝转换: %v", err)
+
+	free = 40
+	store.checkDisk(context.Background())
+	if stats := store.DiskWatchStats(); stats.Level != diskLevelOK || stats.PrefetchPaused {
+		t.Fatalf("expected watchdog to recover, got %+v", stats)
+	}
+	if err := store.checkDiskSpace(); err != nil {
+		t.Fatalf("expected conversions to be accepted after recovery, got %v", err)
+	}
+	t.Logf("✓ 空间恢复后恢复预取并接受转换")
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/erofs"
+)
+
+// UsageEntry 是去重感知 du 报告中的一行,ExclusiveSize 为删除该镜像或命名空间后实际可释放的字节数
+type UsageEntry struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	Layers    int    `json:"layers"`
+	erofs.GroupUsage
+}
+
+// UsageReport 按镜像(已提交的叶子快照及其父链)和命名空间统计 chunk 空间
+type UsageReport struct {
+	Images     []UsageEntry `json:"images"`
+	Namespaces []UsageEntry `json:"namespaces"`
+}
+
+// SortUsage 按可释放空间从大到小排列
+func SortUsage(entries []UsageEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].ExclusiveSize != entries[j].ExclusiveSize {
+			return entries[i].ExclusiveSize > entries[j].ExclusiveSize
+		}
+		return entries[i].Name < entries[j].Name
+	})
+}
+
+// GroupUsage 计算每组快照的独占和共享 chunk 空间,组内快照按镜像键和扁平化镜像映射到 EROFS 镜像
+func (d *DedupStore) GroupUsage(groups [][]string) ([]erofs.GroupUsage, error) {
+	if d.erofsBuilder == nil {
+		return nil, fmt.Errorf("erofs not enabled")
+	}
+
+	images := make([][]string, len(groups))
+	for i, ids := range groups {
+		for _, id := range ids {
+			images[i] = append(images[i], d.imageKey(id), erofs.FlattenedImageID(id))
+		}
+	}
+	return d.erofsBuilder.GroupUsage(images)
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+	"github.com/opencloudos/dedup-snapshotter/pkg/durability"
+	"github.com/opencloudos/dedup-snapshotter/pkg/erofs"
+	"github.com/opencloudos/dedup-snapshotter/pkg/jobs"
+	"github.com/opencloudos/dedup-snapshotter/pkg/metrics"
+)
+
+// BackendDurable 是持久化层后端在后端指标中的类型
+const BackendDurable = "durable"
+
+// setupDurability 按配置创建持久化层:新 chunk 写入本地后同步保存到各后端,
+// 修复损坏 chunk 时先于镜像仓库从持久化层读取。后端配置只在启动时读取
+func (d *DedupStore) setupDurability(cfg config.DurabilityConfig, builder *erofs.Builder) error {
+	if cfg.Mode == config.DurabilityOff {
+		return nil
+	}
+	store, err := durability.New(cfg)
+	if err != nil {
+		return err
+	}
+	d.durable = store
+	builder.SetChunkReplicator(d.replicateChunk)
+	builder.SetDurableFetcher(store.Get)
+
+	status := store.Status()
+	for _, b := range status.Backends {
+		if !b.Available {
+			log.L.Warnf("durability backend %s unavailable: %s", b.Name, b.Error)
+		}
+	}
+	log.L.Infof("chunk durability tier enabled (%s) across %d backends", cfg.Mode, len(status.Backends))
+	return nil
+}
+
+// replicateChunk 把新写入的 chunk 保存到持久化层。失败不影响构建,只记录日志,缺失的冗余由修复任务补齐
+func (d *DedupStore) replicateChunk(hash string, data []byte) {
+	if err := d.durable.Put(context.Background(), hash, data); err != nil {
+		log.L.WithError(err).Warnf("failed to store chunk %s in durability tier", hash)
+	}
+}
+
+// observeDurability 把持久化层的后端读写计入后端指标
+func (d *DedupStore) observeDurability(m *metrics.Metrics) {
+	d.durable.SetObserver(func(op durability.Op) {
+		m.ObserveBackendOp(metrics.BackendOp{
+			Backend:  op.Backend,
+			Kind:     BackendDurable,
+			Op:       op.Op,
+			Bytes:    op.Bytes,
+			NotFound: op.NotFound,
+			Err:      op.Err,
+			Duration: op.Duration,
+		})
+	})
+}
+
+// DurabilityStatus 返回持久化层的配置和后端状态,未启用时返回 nil
+func (d *DedupStore) DurabilityStatus() *durability.Status {
+	if d.durable == nil {
+		return nil
+	}
+	status := d.durable.Status()
+	return &status
+}
+
+// SubmitDurabilityRepair 提交一个补齐本地 chunk 在持久化层中缺失副本或分片的任务
+func (d *DedupStore) SubmitDurabilityRepair() (*jobs.Job, error) {
+	if d.jobs == nil {
+		return nil, fmt.Errorf("job manager not available")
+	}
+	if err := d.checkWritable(); err != nil {
+		return nil, err
+	}
+	if d.durable == nil {
+		return nil, fmt.Errorf("durability tier not enabled")
+	}
+	return d.jobs.Submit(JobTypeDurabilityRepair, d.durable.Mode(), nil)
+}
+
+// runDurabilityRepairJob 检查每个本地 chunk 的冗余,补写丢失后端上的副本或分片。
+// 本地 chunk 损坏时从持久化层读回,在维护窗口外节流
+func (d *DedupStore) runDurabilityRepairJob(ctx context.Context, job *jobs.Job, r *jobs.Reporter) error {
+	if d.durable == nil || d.erofsBuilder == nil {
+		return fmt.Errorf("durability tier not enabled")
+	}
+	hashes, err := d.erofsBuilder.ListChunks()
+	if err != nil {
+		return fmt.Errorf("failed to list chunks: %w", err)
+	}
+
+	start := time.Now()
+	var checked, repaired, failed int64
+	err = forEachParallel(ctx, hashes, d.cfg().Recovery.Workers, d.background, func(hash string) {
+		defer func() {
+			if n := atomic.AddInt64(&checked, 1); n%100 == 0 || int(n) == len(hashes) {
+				r.Progress(float64(n) * 100 / float64(len(hashes)))
+			}
+			d.maintenance.Throttle(ctx)
+		}()
+
+		data, err := d.erofsBuilder.ReadChunk(hash)
+		if err != nil {
+			if data, err = d.durable.Get(ctx, hash); err != nil {
+				atomic.AddInt64(&failed, 1)
+				log.G(ctx).WithError(err).Warnf("chunk %s unreadable locally and in durability tier", hash)
+				return
+			}
+		}
+		n, err := d.durable.Ensure(ctx, hash, data)
+		atomic.AddInt64(&repaired, int64(n))
+		if err != nil {
+			atomic.AddInt64(&failed, 1)
+			log.G(ctx).WithError(err).Warnf("failed to repair chunk %s in durability tier", hash)
+		}
+	})
+	r.Logf("checked %d chunks in %v: %d objects repaired, %d chunks failed", checked, time.Since(start).Round(time.Millisecond), repaired, failed)
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d chunks could not be fully replicated", failed)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+	"github.com/opencloudos/dedup-snapshotter/pkg/fscrypt"
+)
+
+// setupEncryption 把主密钥加入文件系统 keyring,并为 dirs 设置 fscrypt 策略。
+// 目录已用同一密钥加密时只加入密钥,因此每次启动都要能取得密钥
+func setupEncryption(cfg config.EncryptionConfig, root string, dirs ...string) error {
+	if err := fscrypt.CheckSupport(root); err != nil {
+		return fmt.Errorf("encryption: %w", err)
+	}
+
+	key, err := fscrypt.LoadKey(context.Background(), fscrypt.KeySource{
+		File:    cfg.KeyFile,
+		Keyring: cfg.KeyringKey,
+		Command: cfg.KMSCommand,
+	})
+	if err != nil {
+		return fmt.Errorf("encryption: %w", err)
+	}
+	defer fscrypt.Wipe(key)
+
+	id, err := fscrypt.AddKey(root, key)
+	if err != nil {
+		return fmt.Errorf("encryption: %w", err)
+	}
+	for _, dir := range dirs {
+		if err := fscrypt.SetPolicy(dir, id); err != nil {
+			return fmt.Errorf("encryption: %w", err)
+		}
+	}
+	log.L.Infof("encryption enabled with key %s for %v", id, dirs)
+	return nil
+}
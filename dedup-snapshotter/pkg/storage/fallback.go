@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/fscache"
+)
+
+// fscache 回退事件的动作
+const (
+	FallbackActionLoop    = "fallback"
+	FallbackActionRestore = "restore"
+)
+
+// FallbackEvent 记录镜像在 fscache 和本地 EROFS loop 挂载之间的切换。
+// Remounted 表示已挂载的镜像被原地换成了 loop 挂载,Until 为冷却结束时间
+type FallbackEvent struct {
+	Image     string    `json:"image"`
+	Action    string    `json:"action"`
+	Failures  int       `json:"failures,omitempty"`
+	Until     time.Time `json:"until,omitempty"`
+	Remounted bool      `json:"remounted"`
+	Reason    string    `json:"reason,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// fscacheFallback 记录按需读取持续失败、冷却期内改用 loop 挂载的镜像
+type fscacheFallback struct {
+	mu       sync.Mutex
+	until    map[string]time.Time
+	observer func(FallbackEvent)
+}
+
+func (f *fscacheFallback) notify(e FallbackEvent) {
+	f.mu.Lock()
+	fn := f.observer
+	f.mu.Unlock()
+	if fn != nil {
+		fn(e)
+	}
+}
+
+// OnFscacheFallback 设置镜像回退到 loop 挂载和恢复 fscache 时的回调,用于记录审计事件
+func (d *DedupStore) OnFscacheFallback(fn func(FallbackEvent)) {
+	d.fallback.mu.Lock()
+	defer d.fallback.mu.Unlock()
+	d.fallback.observer = fn
+}
+
+// FscacheDegraded 返回镜像是否处于回退冷却期,冷却期内挂载使用本地 EROFS 镜像。
+// 冷却期结束后首次查询恢复 fscache 并产生 restore 事件
+func (d *DedupStore) FscacheDegraded(imageID string) bool {
+	d.fallback.mu.Lock()
+	until, ok := d.fallback.until[imageID]
+	expired := ok && time.Now().After(until)
+	if expired {
+		delete(d.fallback.until, imageID)
+	}
+	d.fallback.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+	if expired {
+		log.L.Infof("fscache cooldown of %s expired, retrying fscache", imageID)
+		d.fallback.notify(FallbackEvent{Image: imageID, Action: FallbackActionRestore, Reason: "cooldown expired"})
+		return false
+	}
+	return true
+}
+
+// handleReadFailure 在镜像的按需读取持续失败时进入冷却期,并把已有的 fscache 挂载换成 loop 挂载
+func (d *DedupStore) handleReadFailure(e fscache.ReadFailureEvent) {
+	until := time.Now().Add(time.Duration(d.cfg().Dedupd.FallbackCooldown) * time.Second)
+	d.fallback.mu.Lock()
+	if d.fallback.until == nil {
+		d.fallback.until = make(map[string]time.Time)
+	}
+	_, degraded := d.fallback.until[e.Volume]
+	d.fallback.until[e.Volume] = until
+	d.fallback.mu.Unlock()
+	if degraded {
+		return
+	}
+
+	go func() {
+		event := FallbackEvent{Image: e.Volume, Action: FallbackActionLoop, Failures: e.Failures, Until: until}
+		if e.Err != nil {
+			event.Reason = e.Err.Error()
+		}
+		remounted, err := d.fallbackToLoop(context.Background(), e.Volume)
+		event.Remounted = remounted
+		if err != nil {
+			event.Error = err.Error()
+			log.L.WithError(err).Warnf("failed to switch %s to loop mount", e.Volume)
+		}
+		d.fallback.notify(event)
+	}()
+}
+
+// fallbackToLoop 确保本地 EROFS 镜像可用后,把镜像的 fscache 挂载换成 loop 挂载。
+// 本地镜像缺失时从快照目录重建,丢失的 chunk 经 chunk fetcher 从镜像仓库取回。
+// 镜像未挂载时只需等待下次挂载改用 loop,返回 false
+func (d *DedupStore) fallbackToLoop(ctx context.Context, imageID string) (bool, error) {
+	if d.mountManager == nil {
+		return false, nil
+	}
+	imagePath := d.imagePath(imageID)
+	if _, err := os.Stat(imagePath); err != nil {
+		if err := d.BuildErofsImage(ctx, filepath.Join(d.snapsDir, imageID, "fs"), imageID); err != nil {
+			return false, fmt.Errorf("failed to rebuild local erofs image: %w", err)
+		}
+	}
+	if _, mounted := d.mountManager.GetMountPath(imageID); !mounted {
+		return false, nil
+	}
+	if err := d.mountManager.RemountLoop(ctx, imageID, imagePath); err != nil {
+		return false, err
+	}
+	if d.dedupDaemon != nil {
+		d.dedupDaemon.SetMounted(imageID, false)
+	}
+	return true, nil
+}
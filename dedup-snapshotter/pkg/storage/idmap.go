@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/erofs"
+)
+
+// mapLayers 为用户命名空间的快照准备 overlay 各层:lowerdir 换成按 idmap 映射属主的克隆挂载,
+// 层数据不需要 chown 或复制;upperdir 只把根目录属主改为容器 root 对应的主机 ID。
+// upperdir 不做 idmap,因为以主机 root 挂载的 overlay 需要在其中创建 workdir,
+// 而主机 root 在容器映射中没有对应 ID。内核或文件系统不支持 idmapped 挂载时
+// 沿用原 lowerdir,层内文件在容器中显示为 nobody
+func (d *DedupStore) mapLayers(ctx context.Context, id string, lowerDirs []string, upperDir string, idmap *erofs.IDMap) ([]string, error) {
+	// 未启用时 containerd 自行以 chown 复制的方式重映射父层
+	if idmap == nil || !d.cfg().Overlay.IDMappedMounts {
+		return lowerDirs, nil
+	}
+
+	uid, gid, err := idmap.RootPair()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(upperDir, 0755); err != nil {
+		return nil, err
+	}
+	if err := os.Lchown(upperDir, uid, gid); err != nil {
+		return nil, fmt.Errorf("failed to chown upperdir of %s: %w", id, err)
+	}
+
+	if len(lowerDirs) == 0 {
+		return lowerDirs, nil
+	}
+	if !d.mountManager.IDMapSupported() {
+		log.G(ctx).Warnf("snapshot %s: idmapped mounts unavailable, lower layers are not remapped", id)
+		return lowerDirs, nil
+	}
+	mapped, err := d.mountManager.MountIDMapped(ctx, id, lowerDirs, idmap)
+	if err != nil {
+		log.G(ctx).WithError(err).Warnf("snapshot %s: failed to create idmapped lowerdirs, lower layers are not remapped", id)
+		return lowerDirs, nil
+	}
+	return mapped, nil
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/erofs"
+	"github.com/opencloudos/dedup-snapshotter/pkg/signing"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// SetImageKey 记录快照使用的 EROFS 镜像键,同一快照 ID 的旧记录被覆盖
+func (i *IndexDB) SetImageKey(snapshotID, key string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	_, err := i.db.Exec("INSERT OR REPLACE INTO image_keys (snapshot_id, image_key) VALUES (?, ?)", snapshotID, key)
+	return err
+}
+
+// ImageKey 返回快照映射的镜像键,没有映射时返回空字符串
+func (i *IndexDB) ImageKey(snapshotID string) (string, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	var key string
+	err := i.db.QueryRow("SELECT image_key FROM image_keys WHERE snapshot_id = ?", snapshotID).Scan(&key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return key, err
+}
+
+// ImageKeySnapshots 返回映射到镜像键 key 的快照
+func (i *IndexDB) ImageKeySnapshots(key string) ([]string, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	rows, err := i.db.Query("SELECT snapshot_id FROM image_keys WHERE image_key = ? ORDER BY snapshot_id", key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// DeleteImageKey 删除快照的映射,返回原镜像键和仍引用该键的快照数
+func (i *IndexDB) DeleteImageKey(snapshotID string) (string, int, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	tx, err := i.db.Begin()
+	if err != nil {
+		return "", 0, err
+	}
+	defer tx.Rollback()
+
+	var key string
+	err = tx.QueryRow("SELECT image_key FROM image_keys WHERE snapshot_id = ?", snapshotID).Scan(&key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", 0, nil
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM image_keys WHERE snapshot_id = ?", snapshotID); err != nil {
+		return "", 0, err
+	}
+
+	var remaining int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM image_keys WHERE image_key = ?", key).Scan(&remaining); err != nil {
+		return "", 0, err
+	}
+
+	return key, remaining, tx.Commit()
+}
+
+// chainImageKey 从提交名中解析 chain ID 作为镜像键。
+// containerd 传给后端的名字形如 <namespace>/<n>/<chainID>,不是摘要时返回空字符串
+func chainImageKey(name string) string {
+	dgst, err := digest.Parse(name[strings.LastIndex(name, "/")+1:])
+	if err != nil {
+		return ""
+	}
+	return dgst.Algorithm().String() + "-" + dgst.Encoded()
+}
+
+// imageKey 返回快照的镜像键;没有 chain ID 映射的快照沿用快照 ID
+func (d *DedupStore) imageKey(id string) string {
+	key, err := d.indexDB.ImageKey(id)
+	if err != nil {
+		log.L.WithError(err).Warnf("failed to look up image key of %s", id)
+	}
+	if key == "" {
+		return id
+	}
+	return key
+}
+
+func (d *DedupStore) imagePath(id string) string {
+	return filepath.Join(d.imagesDir, d.imageKey(id)+erofs.ErofsImageExt)
+}
+
+// RecordChainID 在快照提交时按 chain ID 建立镜像映射,重新拉取的相同层直接复用已构建的镜像
+func (d *DedupStore) RecordChainID(id, name string) error {
+	key := chainImageKey(name)
+	if key == "" {
+		return nil
+	}
+	if err := d.indexDB.SetImageKey(id, key); err != nil {
+		return err
+	}
+
+	// 快照 ID 下可能已经按旧方式构建过镜像,迁移到内容键下
+	legacyPath := filepath.Join(d.imagesDir, id+erofs.ErofsImageExt)
+	keyPath := filepath.Join(d.imagesDir, key+erofs.ErofsImageExt)
+	if _, err := os.Stat(keyPath); err == nil {
+		d.removeImage(id)
+		log.L.Debugf("snapshot %s reuses erofs image %s", id, key)
+	} else if _, err := os.Stat(legacyPath); err == nil {
+		if err := os.Rename(legacyPath, keyPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forgetImage 解除快照与镜像的关联,没有其他快照引用时删除镜像文件。
+// 新建快照时也会调用,防止 ID 被复用时误用上一个同 ID 快照留下的镜像
+// volumeInUse 判断名为 name 的 fscache 卷是否仍对应存在的镜像或快照
+func (d *DedupStore) volumeInUse(name string) bool {
+	for _, path := range []string{
+		filepath.Join(d.imagesDir, name+erofs.ErofsImageExt),
+		d.imagePath(name),
+		filepath.Join(d.snapsDir, name),
+	} {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *DedupStore) forgetImage(id string) {
+	key, remaining, err := d.indexDB.DeleteImageKey(id)
+	if err != nil {
+		log.L.WithError(err).Warnf("failed to delete image key of %s", id)
+		return
+	}
+	if key != "" && remaining == 0 {
+		d.removeImage(key)
+	}
+	d.removeImage(id)
+	os.Remove(d.scanVerdictPath(id))
+}
+
+func (d *DedupStore) removeImage(key string) {
+	if d.dedupDaemon != nil {
+		if err := d.dedupDaemon.UnregisterImage(context.Background(), key); err != nil {
+			log.L.WithError(err).Warnf("failed to unregister fscache volume %s", key)
+		}
+	}
+	os.Remove(filepath.Join(d.imagesDir, key+erofs.ErofsImageExt+signing.SignatureExt))
+	if d.erofsBuilder != nil {
+		if err := d.erofsBuilder.RemoveImage(key); err != nil {
+			log.L.WithError(err).Warnf("failed to remove erofs image %s", key)
+		}
+		return
+	}
+	if err := os.Remove(filepath.Join(d.imagesDir, key+erofs.ErofsImageExt)); err != nil && !os.IsNotExist(err) {
+		log.L.WithError(err).Warnf("failed to remove erofs image %s", key)
+	}
+}
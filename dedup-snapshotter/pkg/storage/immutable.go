@@ -0,0 +1,227 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/background"
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+	"github.com/opencloudos/dedup-snapshotter/pkg/erofs"
+	"github.com/opencloudos/dedup-snapshotter/pkg/layout"
+	"github.com/opencloudos/dedup-snapshotter/pkg/memory"
+	"github.com/opencloudos/dedup-snapshotter/pkg/storelock"
+	"github.com/opencloudos/dedup-snapshotter/pkg/transport"
+	"golang.org/x/sys/unix"
+)
+
+// ErrImmutableStore 表示存储以不可变方式运行,root 中预制的 chunk、镜像和索引不能修改
+var ErrImmutableStore = errors.New("store is immutable")
+
+// MetadataFile 是快照元数据库的文件名
+const MetadataFile = "metadata.db"
+
+// immutableRequired 是不可变存储的 root 中必须预制的内容
+var immutableRequired = []string{"chunks", "images", "index.db"}
+
+// ValidateImmutableRoot 检查 root 中的预制内容是否齐全、格式是否兼容,以及 writableDir 是否可写
+func ValidateImmutableRoot(root, writableDir string) error {
+	report, err := layout.Check(root)
+	if err != nil {
+		return err
+	}
+	if !report.Compatible() {
+		return fmt.Errorf("root %s: %w: %s", root, layout.ErrIncompatible, strings.Join(report.Problems, "; "))
+	}
+	for _, name := range immutableRequired {
+		if _, err := os.Stat(filepath.Join(root, name)); err != nil {
+			return fmt.Errorf("immutable store %s is missing pre-baked %s: %w", root, name, err)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Join(writableDir, "snapshots"), 0700); err != nil {
+		return fmt.Errorf("writable dir %s: %w", writableDir, err)
+	}
+	probe, err := os.CreateTemp(writableDir, ".probe-*")
+	if err != nil {
+		return fmt.Errorf("writable dir %s is not writable: %w", writableDir, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	var st unix.Statfs_t
+	if err := unix.Statfs(root, &st); err == nil && st.Flags&unix.ST_RDONLY == 0 {
+		log.L.Warnf("immutable store root %s is mounted read-write, it will not be modified", root)
+	}
+	return nil
+}
+
+// ImmutableMetadataPath 返回不可变存储的快照元数据库路径。元数据库位于 writableDir 中,
+// 首次启动时从 root 复制预制的元数据库,之后在其上记录容器快照
+func ImmutableMetadataPath(root, writableDir string) (string, error) {
+	path := filepath.Join(writableDir, MetadataFile)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	src, err := os.Open(filepath.Join(root, MetadataFile))
+	if os.IsNotExist(err) {
+		return path, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to open pre-baked snapshot metadata: %w", err)
+	}
+	defer src.Close()
+
+	tmp := path + ".tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return "", fmt.Errorf("failed to copy pre-baked snapshot metadata: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", err
+	}
+	log.L.Infof("seeded snapshot metadata %s from %s", path, root)
+	return path, nil
+}
+
+// newImmutableStore 以不可变方式打开预制的 root:索引以 immutable 方式只读打开,不启动构建、
+// 转换和 fscache,镜像以 loop 方式挂载;新快照的目录、挂载点和锁文件都放在 writable_dir 中
+func newImmutableStore(root string, source *config.Source) (_ *DedupStore, err error) {
+	cfg := source.Get()
+	writableDir := cfg.Store.WritableDir
+	if err := ValidateImmutableRoot(root, writableDir); err != nil {
+		return nil, err
+	}
+	if _, err := layout.CheckFingerprint(root, StoreFingerprint(cfg)); err != nil {
+		return nil, fmt.Errorf("root %s: %w", root, err)
+	}
+
+	storeLock, err := storelock.Acquire(writableDir, storelock.Store, filepath.Base(os.Args[0]))
+	if err != nil {
+		return nil, fmt.Errorf("cannot own writable dir %s: %w", writableDir, err)
+	}
+	defer func() {
+		if err != nil {
+			storeLock.Release()
+		}
+	}()
+
+	indexDB, err := openIndexDBImmutable(filepath.Join(root, "index.db"))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			indexDB.Close()
+		}
+	}()
+
+	store := &DedupStore{
+		root:          root,
+		chunksDir:     filepath.Join(root, "chunks"),
+		snapsDir:      filepath.Join(writableDir, "snapshots"),
+		bakedSnapsDir: filepath.Join(root, "snapshots"),
+		imagesDir:     filepath.Join(root, "images"),
+		indexDB:       indexDB,
+		configs:       source,
+		flattening:    make(map[string]bool),
+		useErofs:      true,
+		immutable:     true,
+		storeLock:     storeLock,
+		transport:     transport.New(nil, cfg.RegistryClient.UserAgent, cfg.RegistryClient.TraceHeaders),
+		background:    background.New(cfg.Background),
+	}
+
+	mountManager, err := erofs.NewMountManager(writableDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mount manager: %w", err)
+	}
+	store.mountManager = mountManager
+	mountManager.SetCommandTimeout(time.Duration(cfg.Timeouts.Mount) * time.Second)
+	if err := mountManager.SetMountNamespace(cfg.MountNamespace); err != nil {
+		return nil, fmt.Errorf("failed to configure mount namespace: %w", err)
+	}
+	mountManager.DetectOverlayLimits(context.Background(), cfg.Overlay.MaxLowerDirs, cfg.Overlay.MaxOptionBytes)
+
+	memDedup, err := memory.NewMemoryDeduplicator(writableDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create memory deduplicator: %w", err)
+	}
+	store.memDedup = memDedup
+	store.memScanner = memory.NewFileScannerWithOptions(memDedup, cfg.MemDedup.Workers, cfg.MemDedup.FilesPerSecond, store.background)
+	if err := memDedup.EnableKSM(); err != nil {
+		log.L.Warnf("failed to enable KSM: %v", err)
+	}
+	store.setupStartupTracer(cfg.StartupTrace)
+
+	log.L.Infof("opened immutable store %s, writable state in %s", root, writableDir)
+	return store, nil
+}
+
+// openIndexDBImmutable 以 immutable 方式打开索引,SQLite 不创建 -wal/-shm 文件,可位于只读文件系统上
+func openIndexDBImmutable(path string) (*IndexDB, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro&immutable=1")
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open pre-baked index: %w", err)
+	}
+	return &IndexDB{db: db, path: path}, nil
+}
+
+// Immutable 报告存储是否以不可变方式运行
+func (d *DedupStore) Immutable() bool {
+	return d.immutable
+}
+
+// snapshotDir 返回快照目录。不可变存储中新快照位于 writable_dir,预制的快照位于 root
+func (d *DedupStore) snapshotDir(id string) string {
+	if d.bakedSnapsDir != "" {
+		baked := filepath.Join(d.bakedSnapsDir, id)
+		if _, err := os.Stat(baked); err == nil {
+			return baked
+		}
+	}
+	return filepath.Join(d.snapsDir, id)
+}
+
+// isBaked 报告快照是否为不可变存储中预制的快照
+func (d *DedupStore) isBaked(id string) bool {
+	if d.bakedSnapsDir == "" {
+		return false
+	}
+	if _, err := os.Stat(filepath.Join(d.bakedSnapsDir, id)); err == nil {
+		return true
+	}
+	return d.HasErofsImage(id)
+}
+
+// checkSnapshotWritable 检查能否创建和删除快照。不可变存储只允许修改 writable_dir 中的快照
+func (d *DedupStore) checkSnapshotWritable(id string) error {
+	if d.ReadOnly() {
+		return ErrReadOnlyStore
+	}
+	if d.immutable && d.isBaked(id) {
+		return fmt.Errorf("snapshot %s is pre-baked: %w", id, ErrImmutableStore)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+)
+
+// TestImmutableStore 验证不可变存储只在 writable_dir 中创建和删除快照,其他写操作返回 ErrImmutableStore
+func TestImmutableStore(t *testing.T) {
+	root, writable := t.TempDir(), t.TempDir()
+
+	// 预制存储:由普通模式创建 chunk、镜像目录和索引
+	baked, err := NewDedupStoreWithOptions(root, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	baked.Close()
+	os.MkdirAll(filepath.Join(root, "snapshots", "1", "fs"), 0755)
+	os.WriteFile(filepath.Join(root, MetadataFile), []byte("baked"), 0644)
+
+	cfg := config.DefaultConfig(root)
+	cfg.EnableFscache = false
+	cfg.Store.Immutable = true
+	cfg.Store.WritableDir = writable
+	store, err := NewDedupStoreWithConfig(root, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	if err := store.Prepare(ctx, "2", []string{"1"}); err != nil {
+		t.Fatalf("prepare in writable dir failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(writable, "snapshots", "2")); err != nil {
+		t.Errorf("new snapshot not created in writable dir: %v", err)
+	}
+	if got := store.GetSnapshotPath("1"); got != filepath.Join(root, "snapshots", "1") {
+		t.Errorf("baked snapshot path = %s", got)
+	}
+
+	if err := store.Remove(ctx, "1"); !errors.Is(err, ErrImmutableStore) {
+		t.Errorf("removing baked snapshot: got %v, want ErrImmutableStore", err)
+	}
+	if err := store.BuildErofsImage(ctx, t.TempDir(), "2"); !errors.Is(err, ErrImmutableStore) {
+		t.Errorf("build: got %v, want ErrImmutableStore", err)
+	}
+	if err := store.Remove(ctx, "2"); err != nil {
+		t.Errorf("removing container snapshot: %v", err)
+	}
+
+	path, err := ImmutableMetadataPath(root, writable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "baked" {
+		t.Errorf("metadata not seeded from root: %q", data)
+	}
+	t.Logf("✓ 不可变存储只修改 %s", writable)
+}
+
+// TestValidateImmutableRoot 验证缺少预制内容时拒绝启动
+func TestValidateImmutableRoot(t *testing.T) {
+	root := t.TempDir()
+	baked, err := NewDedupStoreWithOptions(root, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	baked.Close()
+	os.RemoveAll(filepath.Join(root, "images"))
+
+	if err := ValidateImmutableRoot(root, t.TempDir()); err == nil {
+		t.Error("expected error for missing images dir")
+	}
+	t.Logf("✓ 缺少预制镜像目录时拒绝启动")
+}
@@ -0,0 +1,354 @@
+package storage
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/containerd/archive/compression"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/log"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ImportedImage 是导入的一个镜像,Layers 为按顺序排列的层 digest
+type ImportedImage struct {
+	Name   string   `json:"name,omitempty"`
+	Digest string   `json:"digest,omitempty"`
+	Layers []string `json:"layers"`
+}
+
+// ImportResult 汇总一次导入。CachedLayers 是已物化而跳过的层,Bytes 是新物化的层 blob 总大小
+type ImportResult struct {
+	Source       string          `json:"source"`
+	Images       []ImportedImage `json:"images"`
+	Layers       int             `json:"layers"`
+	CachedLayers int             `json:"cached_layers"`
+	Bytes        int64           `json:"bytes"`
+}
+
+// importLayer 是待导入层的描述符及其 blob 文件
+type importLayer struct {
+	desc ocispec.Descriptor
+	path string
+}
+
+type importImage struct {
+	ImportedImage
+	layers []importLayer
+}
+
+// ImportImages 从 OCI layout 目录、OCI 归档或 docker save 生成的 tar 包导入镜像:校验每个层 blob 的
+// digest 后物化为 EROFS 镜像并记录 chunk 清单,离线环境可以不经镜像仓库预置节点。
+// 多平台镜像只导入当前平台,progress 在每个层处理完后调用
+func (d *DedupStore) ImportImages(ctx context.Context, path string, progress func(done, total int)) (*ImportResult, error) {
+	if err := d.checkWritable(); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := path
+	if !info.IsDir() {
+		if dir, err = d.extractImportArchive(path, info.Size()); err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+	}
+
+	imgs, err := resolveImport(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	total := 0
+	for _, img := range imgs {
+		total += len(img.layers)
+	}
+
+	result := &ImportResult{Source: path, Images: []ImportedImage{}}
+	done := 0
+	seen := make(map[digest.Digest]bool)
+	for _, img := range imgs {
+		parent := ""
+		for _, layer := range img.layers {
+			layerID := layer.desc.Digest.Encoded()
+			if !seen[layer.desc.Digest] {
+				seen[layer.desc.Digest] = true
+				if err := verifyBlob(layer.path, layer.desc); err != nil {
+					return nil, err
+				}
+				if d.HasLayer(layerID) {
+					result.CachedLayers++
+				} else {
+					result.Layers++
+					result.Bytes += layer.desc.Size
+				}
+				if err := d.convertLayerBlob(ctx, layer.desc, layer.path, parent); err != nil {
+					return nil, err
+				}
+			}
+			parent = layerID
+			done++
+			if progress != nil {
+				progress(done, total)
+			}
+		}
+		result.Images = append(result.Images, img.ImportedImage)
+	}
+
+	log.G(ctx).Infof("imported %d images from %s: %d layers converted, %d already present",
+		len(result.Images), path, result.Layers, result.CachedLayers)
+	return result, nil
+}
+
+// extractImportArchive 把 tar 包解到临时空间,返回解出的目录。
+// 只解出普通文件和目录,拒绝指向目录之外的路径
+func (d *DedupStore) extractImportArchive(path string, size int64) (string, error) {
+	base := d.root
+	if d.scratch != nil {
+		if err := d.scratch.checkFree(size); err != nil {
+			return "", err
+		}
+		base = d.scratch.dir
+	}
+	tempDir := filepath.Join(base, "temp")
+	if err := os.MkdirAll(tempDir, 0700); err != nil {
+		return "", err
+	}
+	dir, err := os.MkdirTemp(tempDir, "import-")
+	if err != nil {
+		return "", err
+	}
+
+	if err := extractTar(path, dir); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to extract %s: %w", path, err)
+	}
+	return dir, nil
+}
+
+func extractTar(path, dir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// docker save 的输出可能被压缩后拷贝
+	r, err := compression.DecompressStream(f)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.Clean(hdr.Name)
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("invalid path %q in archive", hdr.Name)
+		}
+		target := filepath.Join(dir, name)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(out, tr)
+			if closeErr := out.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// resolveImport 解析目录中的镜像:有 index.json 时按 OCI layout 处理,否则按 docker save 的 manifest.json 处理
+func resolveImport(ctx context.Context, dir string) ([]importImage, error) {
+	if _, err := os.Stat(filepath.Join(dir, "index.json")); err == nil {
+		return resolveOCILayout(ctx, dir)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "manifest.json")); err == nil {
+		return resolveDockerArchive(dir)
+	}
+	return nil, fmt.Errorf("%s is neither an OCI layout nor a docker save archive", dir)
+}
+
+func resolveOCILayout(ctx context.Context, dir string) ([]importImage, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		return nil, err
+	}
+	var index ocispec.Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("invalid index.json: %w", err)
+	}
+
+	provider := layoutProvider(dir)
+	var imgs []importImage
+	for _, desc := range index.Manifests {
+		manifest, err := images.Manifest(ctx, provider, desc, platforms.Default())
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve manifest %s: %w", desc.Digest, err)
+		}
+
+		name := desc.Annotations[images.AnnotationImageName]
+		if name == "" {
+			name = desc.Annotations[ocispec.AnnotationRefName]
+		}
+		img := importImage{ImportedImage: ImportedImage{Name: name, Digest: desc.Digest.String(), Layers: []string{}}}
+		for _, layer := range manifest.Layers {
+			path, err := provider.blobPath(layer.Digest)
+			if err != nil {
+				return nil, err
+			}
+			img.layers = append(img.layers, importLayer{desc: layer, path: path})
+			img.Layers = append(img.Layers, layer.Digest.String())
+		}
+		imgs = append(imgs, img)
+	}
+	return imgs, nil
+}
+
+// dockerArchiveManifest 是 docker save 输出中 manifest.json 的一项,Layers 为未压缩层 tar 的相对路径
+type dockerArchiveManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+func resolveDockerArchive(dir string) ([]importImage, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+	var manifests []dockerArchiveManifest
+	if err := json.Unmarshal(data, &manifests); err != nil {
+		return nil, fmt.Errorf("invalid manifest.json: %w", err)
+	}
+
+	// 同一个层文件可能被多个镜像引用,digest 只计算一次
+	digests := make(map[string]ocispec.Descriptor)
+	var imgs []importImage
+	for _, m := range manifests {
+		img := importImage{ImportedImage: ImportedImage{Layers: []string{}}}
+		if len(m.RepoTags) > 0 {
+			img.Name = m.RepoTags[0]
+		}
+		for _, name := range m.Layers {
+			if !filepath.IsLocal(name) {
+				return nil, fmt.Errorf("invalid layer path %q in manifest.json", name)
+			}
+			path := filepath.Join(dir, name)
+			desc, ok := digests[name]
+			if !ok {
+				if desc, err = describeFile(path); err != nil {
+					return nil, err
+				}
+				digests[name] = desc
+			}
+			img.layers = append(img.layers, importLayer{desc: desc, path: path})
+			img.Layers = append(img.Layers, desc.Digest.String())
+		}
+		imgs = append(imgs, img)
+	}
+	return imgs, nil
+}
+
+// describeFile 计算未压缩层文件的描述符
+func describeFile(path string) (ocispec.Descriptor, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	defer f.Close()
+
+	digester := digest.Canonical.Digester()
+	n, err := io.Copy(digester.Hash(), f)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	return ocispec.Descriptor{MediaType: images.MediaTypeDockerSchema2Layer, Digest: digester.Digest(), Size: n}, nil
+}
+
+// verifyBlob 校验 blob 文件的大小和 digest,发现介质损坏或被篡改的层
+func verifyBlob(path string, desc ocispec.Descriptor) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	verifier := desc.Digest.Verifier()
+	n, err := io.Copy(verifier, f)
+	if err != nil {
+		return err
+	}
+	if n != desc.Size || !verifier.Verified() {
+		return fmt.Errorf("blob %s does not match its descriptor", desc.Digest)
+	}
+	return nil
+}
+
+// layoutProvider 按 OCI layout 的 blobs/<algorithm>/<encoded> 读取 blob,只读访问,适用于只读介质
+type layoutProvider string
+
+func (p layoutProvider) blobPath(dgst digest.Digest) (string, error) {
+	if err := dgst.Validate(); err != nil {
+		return "", err
+	}
+	return filepath.Join(string(p), "blobs", dgst.Algorithm().String(), dgst.Encoded()), nil
+}
+
+func (p layoutProvider) ReaderAt(ctx context.Context, desc ocispec.Descriptor) (content.ReaderAt, error) {
+	path, err := p.blobPath(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &fileReaderAt{File: f, size: info.Size()}, nil
+}
+
+type fileReaderAt struct {
+	*os.File
+	size int64
+}
+
+func (f *fileReaderAt) Size() int64 {
+	return f.size
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func layerTar(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, data := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(data))
+	}
+	tw.Close()
+	return buf.Bytes()
+}
+
+// TestImportImages 验证解析 OCI layout 目录和 docker save tar 包中的镜像,
+// 拒绝越界路径,blob 与描述符不符时导入失败
+func TestImportImages(t *testing.T) {
+	tmpDir := t.TempDir()
+	store, err := NewDedupStoreWithErofs(filepath.Join(tmpDir, "root"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	base := layerTar(t, map[string]string{"etc/os-release": "ID=test\n"})
+	app := layerTar(t, map[string]string{"app/bin": strings.Repeat("x", 8192)})
+
+	// OCI layout:两个镜像共享 base 层
+	layout := filepath.Join(tmpDir, "layout")
+	writeBlob := func(data []byte, mediaType string) ocispec.Descriptor {
+		desc := ocispec.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(data), Size: int64(len(data))}
+		dir := filepath.Join(layout, "blobs", "sha256")
+		os.MkdirAll(dir, 0755)
+		if err := os.WriteFile(filepath.Join(dir, desc.Digest.Encoded()), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return desc
+	}
+	config := writeBlob([]byte("{}"), ocispec.MediaTypeImageConfig)
+	baseDesc := writeBlob(base, ocispec.MediaTypeImageLayer)
+	appDesc := writeBlob(app, ocispec.MediaTypeImageLayer)
+	platform := platforms.DefaultSpec()
+	var index ocispec.Index
+	index.SchemaVersion = 2
+	for name, layers := range map[string][]ocispec.Descriptor{
+		"example.com/base:1": {baseDesc},
+		"example.com/app:1":  {baseDesc, appDesc},
+	} {
+		m := ocispec.Manifest{MediaType: ocispec.MediaTypeImageManifest, Config: config, Layers: layers}
+		m.SchemaVersion = 2
+		data, _ := json.Marshal(m)
+		desc := writeBlob(data, ocispec.MediaTypeImageManifest)
+		desc.Platform = &platform
+		desc.Annotations = map[string]string{images.AnnotationImageName: name}
+		index.Manifests = append(index.Manifests, desc)
+	}
+	data, _ := json.Marshal(index)
+	os.WriteFile(filepath.Join(layout, "index.json"), data, 0644)
+	os.WriteFile(filepath.Join(layout, ocispec.ImageLayoutFile), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644)
+
+	imgs, err := resolveImport(ctx, layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers := map[string]string{}
+	for _, img := range imgs {
+		for _, l := range img.layers {
+			if err := verifyBlob(l.path, l.desc); err != nil {
+				t.Fatal(err)
+			}
+			layers[l.desc.Digest.String()] = img.Name
+		}
+	}
+	if len(imgs) != 2 || len(layers) != 2 {
+		t.Fatalf("unexpected OCI layout images: %+v", imgs)
+	}
+	t.Logf("✓ 解析 OCI layout 中的 %d 个镜像和 %d 个不同的层", len(imgs), len(layers))
+
+	// docker save 格式:层为未压缩 tar,路径记录在 manifest.json 中
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	add := func(name string, data []byte) {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg})
+		tw.Write(data)
+	}
+	add("abc/layer.tar", app)
+	manifest, _ := json.Marshal([]dockerArchiveManifest{{Config: "config.json", RepoTags: []string{"app:saved"}, Layers: []string{"abc/layer.tar"}}})
+	add("manifest.json", manifest)
+	tw.Close()
+	archivePath := filepath.Join(tmpDir, "image.tar")
+	os.WriteFile(archivePath, archive.Bytes(), 0644)
+
+	dir, err := store.extractImportArchive(archivePath, int64(archive.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	imgs, err = resolveImport(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imgs) != 1 || imgs[0].Name != "app:saved" || imgs[0].layers[0].desc.Digest != appDesc.Digest {
+		t.Fatalf("unexpected docker archive images: %+v", imgs)
+	}
+	t.Logf("✓ 解析 docker save tar 包,层 digest 与 OCI 中一致")
+
+	archive.Reset()
+	tw = tar.NewWriter(&archive)
+	add("../escape", []byte("x"))
+	tw.Close()
+	os.WriteFile(archivePath, archive.Bytes(), 0644)
+	if _, err := store.extractImportArchive(archivePath, int64(archive.Len())); err == nil {
+		t.Error("expected archive entries outside the target to be rejected")
+	}
+
+	// 两个镜像的第一层都是 base,导入在物化前校验失败
+	os.WriteFile(filepath.Join(layout, "blobs", "sha256", baseDesc.Digest.Encoded()), []byte("corrupt"), 0644)
+	if _, err := store.ImportImages(ctx, layout, nil); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("expected corrupt blob to fail the import, got %v", err)
+	}
+	t.Logf("✓ 损坏的 blob 导致导入失败")
+}
@@ -0,0 +1,227 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/fsnotify/fsnotify"
+	"github.com/opencloudos/dedup-snapshotter/pkg/erofs"
+)
+
+// IncrementalChunker 监视活动快照的 upperdir,文件停止写入 quiet 时长后在后台
+// 切分大文件,使 Commit 时的 EROFS 转换只需复用已计算好的 chunk。
+type IncrementalChunker struct {
+	builder *erofs.Builder
+	watcher *fsnotify.Watcher
+	quiet   time.Duration
+	work    chan string
+	mu      sync.Mutex
+	roots   map[string]string
+	pending map[string]time.Time
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func NewIncrementalChunker(builder *erofs.Builder, quiet time.Duration) (*IncrementalChunker, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if quiet <= 0 {
+		quiet = 5 * time.Second
+	}
+
+	c := &IncrementalChunker{
+		builder: builder,
+		watcher: watcher,
+		quiet:   quiet,
+		work:    make(chan string, 100),
+		roots:   make(map[string]string),
+		pending: make(map[string]time.Time),
+		done:    make(chan struct{}),
+	}
+
+	c.wg.Add(2)
+	go c.eventLoop()
+	go c.chunkLoop()
+	return c, nil
+}
+
+// Watch 开始监视快照 id 的 upperdir,目录中已有的文件也会被切分
+func (c *IncrementalChunker) Watch(id, upperDir string) error {
+	if err := os.MkdirAll(upperDir, 0755); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.roots[id] = filepath.Clean(upperDir)
+	c.mu.Unlock()
+
+	return c.addTree(upperDir)
+}
+
+// Unwatch 停止监视快照 id,返回该快照是否处于监视中。已切分的结果保留到 Forget。
+func (c *IncrementalChunker) Unwatch(id string) bool {
+	c.mu.Lock()
+	root, ok := c.roots[id]
+	if !ok {
+		c.mu.Unlock()
+		return false
+	}
+	delete(c.roots, id)
+	for path := range c.pending {
+		if isUnder(path, root) {
+			delete(c.pending, path)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, dir := range c.watcher.WatchList() {
+		if dir == root || isUnder(dir, root) {
+			c.watcher.Remove(dir)
+		}
+	}
+	return true
+}
+
+// Pending 返回等待切分的文件数
+func (c *IncrementalChunker) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
+// Forget 停止监视并丢弃快照 id 的预切分结果
+func (c *IncrementalChunker) Forget(id, upperDir string) {
+	c.Unwatch(id)
+	c.builder.ForgetPrechunked(upperDir)
+}
+
+func (c *IncrementalChunker) Close() error {
+	close(c.done)
+	err := c.watcher.Close()
+	c.wg.Wait()
+	return err
+}
+
+func (c *IncrementalChunker) addTree(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// 遍历期间被删除的文件忽略即可
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return c.watcher.Add(path)
+		}
+		if info.Mode().IsRegular() && info.Size() >= erofs.ChunkSize {
+			c.touch(path)
+		}
+		return nil
+	})
+}
+
+func (c *IncrementalChunker) touch(path string) {
+	c.mu.Lock()
+	c.pending[path] = time.Now()
+	c.mu.Unlock()
+}
+
+func (c *IncrementalChunker) eventLoop() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case event, ok := <-c.watcher.Events:
+			if !ok {
+				return
+			}
+			c.handleEvent(event)
+		case err, ok := <-c.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.L.WithError(err).Warn("incremental chunker watch error")
+		case <-ticker.C:
+			c.dispatch()
+		}
+	}
+}
+
+func (c *IncrementalChunker) handleEvent(event fsnotify.Event) {
+	switch {
+	case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+		c.mu.Lock()
+		delete(c.pending, event.Name)
+		c.mu.Unlock()
+	case event.Op&fsnotify.Create != 0:
+		info, err := os.Lstat(event.Name)
+		if err != nil {
+			return
+		}
+		if info.IsDir() {
+			if err := c.addTree(event.Name); err != nil {
+				log.L.WithError(err).Debugf("failed to watch %s", event.Name)
+			}
+			return
+		}
+		if info.Mode().IsRegular() {
+			c.touch(event.Name)
+		}
+	case event.Op&fsnotify.Write != 0:
+		c.touch(event.Name)
+	}
+}
+
+// dispatch 把已静默 quiet 时长的文件交给切分协程,队列满时留到下一轮
+func (c *IncrementalChunker) dispatch() {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for path, last := range c.pending {
+		if now.Sub(last) < c.quiet {
+			continue
+		}
+		select {
+		case c.work <- path:
+			delete(c.pending, path)
+		default:
+			return
+		}
+	}
+}
+
+func (c *IncrementalChunker) chunkLoop() {
+	defer c.wg.Done()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case path := <-c.work:
+			start := time.Now()
+			if err := c.builder.PrechunkFile(path); err != nil {
+				log.L.WithError(err).Debugf("failed to prechunk %s", path)
+				continue
+			}
+			log.L.Debugf("prechunked %s in %v", path, time.Since(start))
+		}
+	}
+}
+
+func isUnder(path, root string) bool {
+	return strings.HasPrefix(path, root+string(filepath.Separator))
+}
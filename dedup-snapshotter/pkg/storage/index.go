@@ -4,10 +4,14 @@ import (
 	"database/sql"
 	"fmt"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/internal/faultinject"
+	"github.com/opencloudos/dedup-snapshotter/pkg/chunkcache"
+	"github.com/opencloudos/dedup-snapshotter/pkg/refcount"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -16,12 +20,34 @@ type IndexDB struct {
 	mu       sync.RWMutex
 	path     string
 	lockFile string
+	// refs 缓存 chunk 的引用计数,写操作成功后在 mu 内同步更新
+	refs *chunkcache.MetaCache
 }
 
 func NewIndexDB(path string) (*IndexDB, error) {
+	return openIndexDB(path, true)
+}
+
+// NewIndexDBReadOnly 以只读方式打开其他进程拥有的索引,不做崩溃恢复和完整性重建
+func NewIndexDBReadOnly(path string) (*IndexDB, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open index read-only: %w", err)
+	}
+	return &IndexDB{db: db, path: path}, nil
+}
+
+// openIndexDB 打开索引,allowRecovery 为 false 时跳过崩溃恢复和重建(有只读读者附着时不能替换数据库文件)
+func openIndexDB(path string, allowRecovery bool) (*IndexDB, error) {
 	lockFile := path + ".lock"
 
-	if err := checkAndRecover(path, lockFile); err != nil {
+	if !allowRecovery {
+		log.L.Warn("skipping index crash recovery")
+	} else if err := checkAndRecover(path, lockFile); err != nil {
 		log.L.WithError(err).Warn("crash recovery check failed, attempting recovery")
 		if err := recoverDatabase(path); err != nil {
 			return nil, fmt.Errorf("database recovery failed: %w", err)
@@ -43,11 +69,18 @@ func NewIndexDB(path string) (*IndexDB, error) {
 		return nil, err
 	}
 
+	if err := idx.migrate(); err != nil {
+		return nil, err
+	}
+
 	if err := idx.createLockFile(); err != nil {
 		return nil, err
 	}
 
 	if err := idx.verifyIntegrity(); err != nil {
+		if !allowRecovery {
+			return nil, fmt.Errorf("database integrity check failed and readers are attached: %w", err)
+		}
 		log.L.WithError(err).Warn("database integrity check failed, attempting rebuild")
 		if err := idx.rebuild(); err != nil {
 			return nil, fmt.Errorf("database rebuild failed: %w", err)
@@ -70,73 +103,200 @@ func (i *IndexDB) init() error {
 		chunks TEXT
 	);
 
+	CREATE TABLE IF NOT EXISTS image_keys (
+		snapshot_id TEXT PRIMARY KEY,
+		image_key TEXT NOT NULL
+	);
+
 	CREATE INDEX IF NOT EXISTS idx_chunks_hash ON chunks(hash);
 	CREATE INDEX IF NOT EXISTS idx_files_path ON files(path);
+	CREATE INDEX IF NOT EXISTS idx_image_keys_key ON image_keys(image_key);
 	`
 
 	_, err := i.db.Exec(schema)
 	return err
 }
 
+// refCountSchemaVersion 是引用计数语义的版本,低于该版本的索引在打开时按 files 表重新计数一次
+const refCountSchemaVersion = 1
+
+// migrate 修正旧版本的引用计数:旧版 IndexFile 插入新 chunk 时已计一次引用再加一,计数偏大
+func (i *IndexDB) migrate() error {
+	var version int
+	if err := i.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version >= refCountSchemaVersion {
+		return nil
+	}
+
+	tx, err := i.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := recountRefs(tx); err != nil {
+		return fmt.Errorf("failed to migrate ref counts: %w", err)
+	}
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", refCountSchemaVersion)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// IndexFile 记录文件 path 由 chunks 组成,chunk 每出现一次计一次引用。path 已索引时先释放原有的引用,
+// 重复索引同一文件不会累加计数。chunk 插入和计数调整在同一事务中完成
 func (i *IndexDB) IndexFile(path string, chunks []ChunkInfo) error {
 	i.mu.Lock()
 	defer i.mu.Unlock()
 
+	if err := faultinject.Inject(faultinject.SQLiteBusy); err != nil {
+		return err
+	}
+
 	tx, err := i.db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	var chunkHashes string
-	for idx, chunk := range chunks {
-		if idx > 0 {
-			chunkHashes += ","
-		}
-		chunkHashes += chunk.Hash
+	deltas := make(map[string]int64)
+	var previous string
+	err = tx.QueryRow("SELECT chunks FROM files WHERE path = ?", path).Scan(&previous)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	for _, hash := range parseChunkHashes(previous) {
+		deltas[hash]--
+	}
 
-		_, err := tx.Exec("INSERT OR IGNORE INTO chunks (hash, size) VALUES (?, ?)", chunk.Hash, chunk.Size)
+	hashes := make([]string, len(chunks))
+	for idx, chunk := range chunks {
+		hashes[idx] = chunk.Hash
+		_, err := tx.Exec("INSERT OR IGNORE INTO chunks (hash, size, ref_count) VALUES (?, ?, 0)", chunk.Hash, chunk.Size)
 		if err != nil {
 			return err
 		}
-
-		_, err = tx.Exec("UPDATE chunks SET ref_count = ref_count + 1 WHERE hash = ?", chunk.Hash)
-		if err != nil {
-			return err
+		deltas[chunk.Hash]++
+	}
+	for hash, delta := range deltas {
+		if delta == 0 {
+			delete(deltas, hash)
 		}
 	}
 
-	_, err = tx.Exec("INSERT OR REPLACE INTO files (path, chunks) VALUES (?, ?)", path, chunkHashes)
+	counts, err := refcount.Adjust(tx, deltas)
 	if err != nil {
 		return err
 	}
 
-	return tx.Commit()
+	_, err = tx.Exec("INSERT OR REPLACE INTO files (path, chunks) VALUES (?, ?)", path, strings.Join(hashes, ","))
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	i.cacheRefs(counts)
+	return nil
 }
 
-func (i *IndexDB) IncrementRefCount(hash string) error {
+// SetRefCache 设置引用计数缓存,nil 表示每次查找都查询数据库
+func (i *IndexDB) SetRefCache(refs *chunkcache.MetaCache) {
 	i.mu.Lock()
 	defer i.mu.Unlock()
+	i.refs = refs
+}
 
-	_, err := i.db.Exec("UPDATE chunks SET ref_count = ref_count + 1 WHERE hash = ?", hash)
-	return err
+// RefCacheStats 返回引用计数缓存的统计
+func (i *IndexDB) RefCacheStats() chunkcache.MetaStats {
+	return i.refs.Stats()
 }
 
-func (i *IndexDB) DecrementRefCount(hash string) error {
+// AdjustRefCounts 在一个事务中按 deltas 增减已索引 chunk 的引用计数,
+// 任一 chunk 未索引或计数将为负时整体不生效,错误包装 refcount.ErrUnknownChunk 或 refcount.ErrUnderflow
+func (i *IndexDB) AdjustRefCounts(deltas map[string]int64) error {
 	i.mu.Lock()
 	defer i.mu.Unlock()
 
-	_, err := i.db.Exec("UPDATE chunks SET ref_count = ref_count - 1 WHERE hash = ?", hash)
-	return err
+	if err := faultinject.Inject(faultinject.SQLiteBusy); err != nil {
+		return err
+	}
+
+	tx, err := i.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	counts, err := refcount.Adjust(tx, deltas)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	i.cacheRefs(counts)
+	return nil
 }
 
-func (i *IndexDB) GetChunkRefCount(hash string) (int64, error) {
+// GetRefCount 返回 chunk 被已索引文件引用的次数,chunk 未索引时返回包装 refcount.ErrUnknownChunk 的错误
+func (i *IndexDB) GetRefCount(hash string) (int64, error) {
 	i.mu.RLock()
 	defer i.mu.RUnlock()
 
+	if meta, ok := i.refs.Get(hash); ok {
+		return meta.RefCount, nil
+	}
+
 	var count int64
 	err := i.db.QueryRow("SELECT ref_count FROM chunks WHERE hash = ?", hash).Scan(&count)
-	return count, err
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("chunk %s: %w", hash, refcount.ErrUnknownChunk)
+	}
+	if err != nil {
+		return 0, err
+	}
+	i.refs.Put(hash, chunkcache.ChunkMeta{RefCount: count})
+	return count, nil
+}
+
+// GetRefCounts 批量返回 chunk 的引用计数,缓存未命中的 chunk 合并查询,未索引的 chunk 不在结果中
+func (i *IndexDB) GetRefCounts(hashes []string) (map[string]int64, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	counts := make(map[string]int64, len(hashes))
+	var misses []string
+	for _, hash := range hashes {
+		if meta, ok := i.refs.Get(hash); ok {
+			counts[hash] = meta.RefCount
+		} else {
+			misses = append(misses, hash)
+		}
+	}
+	if len(misses) == 0 {
+		return counts, nil
+	}
+
+	found, err := refcount.Get(i.db, misses)
+	if err != nil {
+		return nil, err
+	}
+	for hash, count := range found {
+		counts[hash] = count
+		i.refs.Put(hash, chunkcache.ChunkMeta{RefCount: count})
+	}
+	return counts, nil
+}
+
+// cacheRefs 在写事务提交后更新缓存中的引用计数,调用方须持有 mu
+func (i *IndexDB) cacheRefs(counts map[string]int64) {
+	for hash, count := range counts {
+		i.refs.Put(hash, chunkcache.ChunkMeta{RefCount: count})
+	}
 }
 
 func (i *IndexDB) Close() error {
@@ -258,6 +418,26 @@ func (i *IndexDB) rebuild() error {
 		return fmt.Errorf("failed to clean invalid chunks: %w", err)
 	}
 
+	if err := recountRefs(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit rebuild: %w", err)
+	}
+	i.refs.Purge()
+
+	_, err = i.db.Exec("VACUUM")
+	if err != nil {
+		log.L.WithError(err).Warn("VACUUM failed")
+	}
+
+	log.L.Info("database rebuild completed successfully")
+	return nil
+}
+
+// recountRefs 按 files 表重新计算每个 chunk 被引用的次数,不再被任何文件引用的 chunk 计为 0
+func recountRefs(tx *sql.Tx) error {
 	rows, err := tx.Query("SELECT path, chunks FROM files")
 	if err != nil {
 		return fmt.Errorf("failed to query files: %w", err)
@@ -271,34 +451,23 @@ func (i *IndexDB) rebuild() error {
 			log.L.WithError(err).Warnf("failed to scan file row")
 			continue
 		}
-
-		if chunks == "" {
-			continue
-		}
-
-		chunkHashes := parseChunkHashes(chunks)
-		for _, hash := range chunkHashes {
+		for _, hash := range parseChunkHashes(chunks) {
 			refCounts[hash]++
 		}
 	}
-
-	for hash, count := range refCounts {
-		_, err = tx.Exec("UPDATE chunks SET ref_count = ? WHERE hash = ?", count, hash)
-		if err != nil {
-			log.L.WithError(err).Warnf("failed to update ref count for chunk %s", hash)
-		}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read files: %w", err)
 	}
+	rows.Close()
 
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit rebuild: %w", err)
+	if _, err := tx.Exec("UPDATE chunks SET ref_count = 0"); err != nil {
+		return fmt.Errorf("failed to reset ref counts: %w", err)
 	}
-
-	_, err = i.db.Exec("VACUUM")
-	if err != nil {
-		log.L.WithError(err).Warn("VACUUM failed")
+	for hash, count := range refCounts {
+		if _, err := tx.Exec("UPDATE chunks SET ref_count = ? WHERE hash = ?", count, hash); err != nil {
+			return fmt.Errorf("failed to update ref count for chunk %s: %w", hash, err)
+		}
 	}
-
-	log.L.Info("database rebuild completed successfully")
 	return nil
 }
 
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/chunkcache"
+	"github.com/opencloudos/dedup-snapshotter/pkg/refcount"
+)
+
+// TestIndexRefCounts 验证引用计数等于 chunk 在已索引文件中的出现次数,重新索引同一文件不会累加,
+// 批量查询与缓存一致,调整失败时整体回滚
+func TestIndexRefCounts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.db")
+	db, err := NewIndexDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetRefCache(chunkcache.NewMetaCache(16))
+
+	if err := db.IndexFile("a", []ChunkInfo{{Hash: "x", Size: 4}, {Hash: "y", Size: 4}, {Hash: "x", Size: 4}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.IndexFile("b", []ChunkInfo{{Hash: "y", Size: 4}}); err != nil {
+		t.Fatal(err)
+	}
+	checkRefs := func(want map[string]int64) {
+		t.Helper()
+		hashes := []string{"x", "y", "z", "missing"}
+		got, err := db.GetRefCounts(hashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("expected ref counts %v, got %v", want, got)
+		}
+		for hash, n := range want {
+			if got[hash] != n {
+				t.Fatalf("expected ref counts %v, got %v", want, got)
+			}
+			if single, err := db.GetRefCount(hash); err != nil || single != n {
+				t.Fatalf("GetRefCount(%s) = %d, %v, want %d", hash, single, err, n)
+			}
+		}
+	}
+	checkRefs(map[string]int64{"x": 2, "y": 2})
+	if _, err := db.GetRefCount("missing"); !errors.Is(err, refcount.ErrUnknownChunk) {
+		t.Fatalf("expected ErrUnknownChunk, got %v", err)
+	}
+	t.Logf("✓ 新 chunk 从 0 开始计数,每次出现计一次引用")
+
+	// 重新索引文件 a 时先释放原有引用
+	if err := db.IndexFile("a", []ChunkInfo{{Hash: "z", Size: 4}}); err != nil {
+		t.Fatal(err)
+	}
+	checkRefs(map[string]int64{"x": 0, "y": 1, "z": 1})
+	t.Logf("✓ 重新索引文件不累加引用")
+
+	if err := db.AdjustRefCounts(map[string]int64{"y": 1, "z": -2}); !errors.Is(err, refcount.ErrUnderflow) {
+		t.Fatalf("expected ErrUnderflow, got %v", err)
+	}
+	if err := db.AdjustRefCounts(map[string]int64{"y": 1, "missing": 1}); !errors.Is(err, refcount.ErrUnknownChunk) {
+		t.Fatalf("expected ErrUnknownChunk, got %v", err)
+	}
+	checkRefs(map[string]int64{"x": 0, "y": 1, "z": 1})
+	if err := db.AdjustRefCounts(map[string]int64{"y": 2, "z": -1}); err != nil {
+		t.Fatal(err)
+	}
+	checkRefs(map[string]int64{"x": 0, "y": 3, "z": 0})
+	t.Logf("✓ 调整失败时整体回滚,成功后缓存与数据库一致")
+
+	// 旧版本的偏大计数在打开时按 files 表重新计算
+	if _, err := db.db.Exec("UPDATE chunks SET ref_count = 7; PRAGMA user_version = 0"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	db, err = NewIndexDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	checkRefs(map[string]int64{"x": 0, "y": 1, "z": 1})
+	t.Logf("✓ 打开旧索引时重新计数")
+}
@@ -0,0 +1,240 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/fscache"
+	"github.com/opencloudos/dedup-snapshotter/pkg/jobs"
+)
+
+// 存储在任务管理器中的任务类型。转换任务由 ConversionQueue 按优先级自行调度,任务管理器只记录状态
+const (
+	JobTypeConversion = "conversion"
+	JobTypePrefetch   = "prefetch"
+	JobTypeVolumeGC   = "volume_gc"
+	// JobTypeDurabilityRepair 补齐持久化层中缺失的 chunk 副本或分片,见 durability.go
+	JobTypeDurabilityRepair = "durability_repair"
+)
+
+// conversionRetryDelay 是转换失败后第一次重试前的等待,之后按执行次数倍增
+const conversionRetryDelay = 30 * time.Second
+
+// PrefetchJobParams 是预取任务的参数
+type PrefetchJobParams struct {
+	ImageID   string                  `json:"image_id"`
+	TraceFile string                  `json:"trace_file"`
+	Filter    *fscache.PrefetchFilter `json:"filter,omitempty"`
+}
+
+// SetJobManager 把预取、卷 GC 和持久化层修复注册为任务类型,转换任务的状态写入 m,
+// 并恢复上次进程退出时排队中或被中断的转换任务。须在 m.Start 之前调用
+func (d *DedupStore) SetJobManager(m *jobs.Manager) error {
+	d.jobs = m
+	m.Register(JobTypePrefetch, d.runPrefetchJob, jobs.Options{Workers: 4})
+	m.Register(JobTypeVolumeGC, d.runVolumeGCJob, jobs.Options{})
+	m.Register(JobTypeDurabilityRepair, d.runDurabilityRepairJob, jobs.Options{})
+	if d.conversions != nil {
+		return d.conversions.setJournal(m)
+	}
+	return nil
+}
+
+// Jobs 返回任务管理器,未设置时为 nil
+func (d *DedupStore) Jobs() *jobs.Manager {
+	return d.jobs
+}
+
+// SubmitPrefetch 提交一个预取任务,任务在预取完成后结束。filter 为 nil 时使用 prefetch.policy_file 中的过滤
+func (d *DedupStore) SubmitPrefetch(imageID, traceFile string, filter *fscache.PrefetchFilter) (*jobs.Job, error) {
+	if d.jobs == nil {
+		return nil, fmt.Errorf("job manager not available")
+	}
+	if !d.useFscache || d.dedupDaemon == nil {
+		return nil, fmt.Errorf("fscache not enabled")
+	}
+	if _, err := os.Stat(traceFile); err != nil {
+		return nil, fmt.Errorf("invalid trace file: %w", err)
+	}
+	return d.jobs.Submit(JobTypePrefetch, imageID, PrefetchJobParams{ImageID: imageID, TraceFile: traceFile, Filter: filter})
+}
+
+// SubmitVolumeGC 提交一个清理孤儿 fscache 卷的任务
+func (d *DedupStore) SubmitVolumeGC() (*jobs.Job, error) {
+	if d.jobs == nil {
+		return nil, fmt.Errorf("job manager not available")
+	}
+	if err := d.checkWritable(); err != nil {
+		return nil, err
+	}
+	return d.jobs.Submit(JobTypeVolumeGC, "fscache", nil)
+}
+
+// runPrefetchJob 启动预取并等待其结束,期间把预取进度写入任务
+func (d *DedupStore) runPrefetchJob(ctx context.Context, job *jobs.Job, r *jobs.Reporter) error {
+	var params PrefetchJobParams
+	if err := json.Unmarshal(job.Params, &params); err != nil {
+		return fmt.Errorf("invalid prefetch params: %w", err)
+	}
+	if err := d.StartPrefetchWithFilter(ctx, params.ImageID, params.TraceFile, params.Filter); err != nil {
+		return err
+	}
+	r.Logf("started prefetch of %s from %s", params.ImageID, params.TraceFile)
+
+	var last *fscache.PrefetchStatus
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		var status *fscache.PrefetchStatus
+		for _, s := range d.PrefetchStatuses() {
+			if s.ImageID == params.ImageID {
+				status = s
+			}
+		}
+		if status == nil {
+			break
+		}
+		last = status
+		r.Progress(status.Progress)
+	}
+	if last != nil {
+		r.Logf("prefetched %d of %d chunks, %d already present, %d filtered out",
+			last.Completed, last.TotalEntries, last.Skipped, last.Filtered)
+	}
+	return nil
+}
+
+func (d *DedupStore) runVolumeGCJob(ctx context.Context, job *jobs.Job, r *jobs.Reporter) error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	if !d.useFscache || d.dedupDaemon == nil {
+		return fmt.Errorf("fscache not enabled")
+	}
+	removed, err := d.dedupDaemon.CleanupVolumesWithThrottle(ctx, d.volumeInUse, d.maintenance.Throttle)
+	if err != nil {
+		return err
+	}
+	r.Logf("removed %d orphan fscache volumes", removed)
+	return nil
+}
+
+// recordConversion 把转换任务的状态写入任务管理器,journal 为 nil 时不记录
+func recordConversion(journal *jobs.Manager, job *ConversionJob) {
+	if journal == nil {
+		return
+	}
+	params, err := json.Marshal(job)
+	if err != nil {
+		return
+	}
+	target := job.ImageID
+	if target == "" {
+		target = job.ImageRef + job.Source
+	}
+	err = journal.Save(&jobs.Job{
+		ID:          job.ID,
+		Type:        JobTypeConversion,
+		Target:      target,
+		State:       job.State,
+		Progress:    job.Progress,
+		Params:      params,
+		Error:       job.Error,
+		Attempts:    job.Attempts,
+		MaxAttempts: jobs.DefaultMaxAttempts,
+		CreatedAt:   job.CreatedAt,
+		StartedAt:   job.StartedAt,
+		FinishedAt:  job.FinishedAt,
+	})
+	if err != nil {
+		log.L.WithError(err).Warnf("failed to record conversion job %s", job.ID)
+	}
+}
+
+// logf 追加一条转换任务的日志
+func (q *ConversionQueue) logf(id, format string, args ...interface{}) {
+	q.mu.RLock()
+	journal := q.journal
+	q.mu.RUnlock()
+	if journal != nil {
+		journal.Logf(id, format, args...)
+	}
+}
+
+// setJournal 设置记录转换任务的任务管理器,并重新排队其中未结束的转换任务
+func (q *ConversionQueue) setJournal(m *jobs.Manager) error {
+	queued, err := m.List(jobs.Filter{Type: JobTypeConversion, State: jobs.StateQueued})
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	q.journal = m
+	var restored []*ConversionJob
+	var dropped []ConversionJob
+	// List 从新到旧返回,按提交顺序恢复
+	for i := len(queued) - 1; i >= 0; i-- {
+		rec := queued[i]
+		var job ConversionJob
+		if err := json.Unmarshal(rec.Params, &job); err != nil || job.ID != rec.ID {
+			log.L.Warnf("dropping conversion job %s with invalid params", rec.ID)
+			continue
+		}
+		if _, exists := q.jobs[job.ID]; exists {
+			continue
+		}
+		job.State = JobStateQueued
+		job.Attempts = rec.Attempts
+		job.Error = rec.Error
+		if len(q.pending) >= q.queueSize {
+			job.State = JobStateFailed
+			job.Error = "conversion queue full after restart"
+			job.FinishedAt = time.Now()
+			dropped = append(dropped, job)
+			continue
+		}
+		q.jobs[job.ID] = &job
+		q.pending = append(q.pending, &job)
+		restored = append(restored, &job)
+	}
+	q.mu.Unlock()
+
+	for _, job := range dropped {
+		recordConversion(m, &job)
+	}
+	for _, job := range restored {
+		q.ready <- struct{}{}
+		m.Logf(job.ID, "requeued after restart")
+		log.L.Infof("requeued conversion job %s for image %s after restart", job.ID, job.ImageID)
+	}
+	return nil
+}
+
+// requeue 在重试等待结束后把失败的任务放回队列
+func (q *ConversionQueue) requeue(job *ConversionJob) {
+	if q.ctx.Err() != nil {
+		return
+	}
+	q.mu.Lock()
+	if len(q.pending) >= q.queueSize {
+		q.mu.Unlock()
+		q.update(job, func(j *ConversionJob) {
+			j.State = JobStateFailed
+			j.FinishedAt = time.Now()
+		})
+		q.logf(job.ID, "conversion queue full, not retried")
+		return
+	}
+	q.pending = append(q.pending, job)
+	q.mu.Unlock()
+	q.ready <- struct{}{}
+}
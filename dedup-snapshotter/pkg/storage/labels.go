@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 快照标签,由 Stat/Walk 动态生成,不写入元数据
+const (
+	LabelPrefix         = "containerd.io/snapshot/dedup."
+	LabelErofsBacked    = LabelPrefix + "erofs"
+	LabelChunkCount     = LabelPrefix + "chunks"
+	LabelExclusiveBytes = LabelPrefix + "exclusive-bytes"
+	LabelMount          = LabelPrefix + "mount"
+)
+
+// LabelMountStrategy 由客户端在 Prepare 时设置,选择快照的挂载方式,随快照元数据保存。
+// 取值为 MountStrategyErofs(默认)或 MountStrategyOverlay
+const LabelMountStrategy = "containerd.io/snapshot/dedup-mount-strategy"
+
+const (
+	MountStrategyErofs   = "erofs"
+	MountStrategyOverlay = "overlay"
+)
+
+// ParseMountStrategy 返回快照标签选择的挂载方式,未设置时为 MountStrategyErofs
+func ParseMountStrategy(labels map[string]string) (string, error) {
+	switch v := labels[LabelMountStrategy]; v {
+	case "", MountStrategyErofs:
+		return MountStrategyErofs, nil
+	case MountStrategyOverlay:
+		return MountStrategyOverlay, nil
+	default:
+		return "", fmt.Errorf("invalid %s %q: must be %s or %s", LabelMountStrategy, v, MountStrategyErofs, MountStrategyOverlay)
+	}
+}
+
+// SnapshotLabels 返回描述快照去重状态的标签;未转换为 EROFS 的快照只带 erofs=false
+func (d *DedupStore) SnapshotLabels(id string) map[string]string {
+	labels := map[string]string{
+		LabelErofsBacked: strconv.FormatBool(d.HasErofsImage(id)),
+	}
+	if labels[LabelErofsBacked] != "true" || d.erofsBuilder == nil {
+		return labels
+	}
+
+	stats, err := d.erofsBuilder.GetChunkStats(d.imageKey(id))
+	if err != nil {
+		return labels
+	}
+	labels[LabelChunkCount] = strconv.FormatInt(stats.TotalChunks, 10)
+	labels[LabelExclusiveBytes] = strconv.FormatInt(stats.ExclusiveSize, 10)
+	return labels
+}
+
+// IsDedupLabel 判断标签是否由 SnapshotLabels 生成
+func IsDedupLabel(key string) bool {
+	return strings.HasPrefix(key, LabelPrefix)
+}
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"archive/tar"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
@@ -9,10 +10,13 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/containerd/containerd/archive"
 	"github.com/containerd/containerd/archive/compression"
 	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/bufpool"
+	"github.com/opencloudos/dedup-snapshotter/pkg/fscache"
 )
 
 // LayerProcessor 处理 OCI 镜像层
@@ -29,10 +33,15 @@ func NewLayerProcessor(store *DedupStore) *LayerProcessor {
 
 // ProcessLayer 处理一个镜像层:解压 → 去重 → 转 EROFS → 注册 fscache
 func (lp *LayerProcessor) ProcessLayer(ctx context.Context, layerID string, layerData io.Reader, parent string) error {
-	log.L.Infof("processing layer %s (parent: %s)", layerID, parent)
+	log.G(ctx).Infof("processing layer %s (parent: %s)", layerID, parent)
+
+	scratch := lp.store.scratch
+	if err := scratch.checkFree(0); err != nil {
+		return err
+	}
 
 	// 1. 计算层的哈希作为唯一标识
-	digest, tempFile, err := lp.saveLayerToTemp(layerID, layerData)
+	digest, tempFile, err := lp.saveLayerToTemp(layerID, scratch.guard(layerData))
 	if err != nil {
 		return fmt.Errorf("failed to save layer: %w", err)
 	}
@@ -40,12 +49,23 @@ func (lp *LayerProcessor) ProcessLayer(ctx context.Context, layerID string, laye
 
 	// 2. 检查是否已处理过此层(根据内容哈希)
 	if lp.isLayerProcessed(digest) {
-		log.L.Infof("layer %s already processed (digest: %s)", layerID, digest[:12])
+		log.G(ctx).Infof("layer %s already processed (digest: %s)", layerID, digest[:12])
 		return nil
 	}
 
-	// 3. 解压层到临时目录
-	extractDir := filepath.Join(lp.store.root, "extract", layerID)
+	// 3. 按压缩层大小预留临时空间,预算用尽时等待其他层完成
+	info, err := os.Stat(tempFile)
+	if err != nil {
+		return err
+	}
+	release, err := scratch.reserve(ctx, info.Size()*scratchExpansion)
+	if err != nil {
+		return fmt.Errorf("failed to reserve scratch space for layer %s: %w", layerID, err)
+	}
+	defer release()
+
+	// 解压层到临时目录
+	extractDir := filepath.Join(scratch.dir, "extract", layerID)
 	if err := os.MkdirAll(extractDir, 0755); err != nil {
 		return err
 	}
@@ -57,14 +77,14 @@ func (lp *LayerProcessor) ProcessLayer(ctx context.Context, layerID string, laye
 	}
 	defer file.Close()
 
-	if err := extractLayer(file, extractDir); err != nil {
+	if err := extractLayer(scratch.guard(file), extractDir); err != nil {
 		return fmt.Errorf("failed to extract layer: %w", err)
 	}
 
 	// 4. 如果有父层,合并文件系统
 	if parent != "" {
 		if err := lp.mergeWithParent(ctx, extractDir, parent); err != nil {
-			log.L.WithError(err).Warnf("failed to merge with parent %s", parent)
+			log.G(ctx).WithError(err).Warnf("failed to merge with parent %s", parent)
 		}
 	}
 
@@ -78,7 +98,7 @@ func (lp *LayerProcessor) ProcessLayer(ctx context.Context, layerID string, laye
 		LayerID:      layerID,
 		Digest:       digest,
 		Parent:       parent,
-		ErofsImage:   filepath.Join(lp.store.imagesDir, layerID+".erofs"),
+		ErofsImage:   lp.store.imagePath(layerID),
 		Size:         getDirSize(extractDir),
 		FileCount:    countFiles(extractDir),
 	}
@@ -90,22 +110,28 @@ func (lp *LayerProcessor) ProcessLayer(ctx context.Context, layerID string, laye
 	// 7. 注册到 fscache (如果启用)
 	if lp.store.useFscache && lp.store.dedupDaemon != nil {
 		manifestPath := lp.generateManifestPath(layerID)
-		if err := lp.generateLayerManifest(extractDir, manifestPath); err != nil {
-			log.L.WithError(err).Warnf("failed to generate manifest for %s", layerID)
+		if lp.hasPublishedManifest(manifestPath, digest) {
+			log.G(ctx).Infof("using published chunk manifest for layer %s", layerID)
+		} else if err := lp.generateLayerManifest(layerID, digest, tempFile, manifestPath); err != nil {
+			log.G(ctx).WithError(err).Warnf("failed to generate manifest for %s", layerID)
+			manifestPath = ""
 		} else {
+			lp.store.signArtifact(manifestPath)
+		}
+		if manifestPath != "" {
 			if err := lp.store.RegisterImageForFscache(ctx, layerID, manifestPath); err != nil {
-				log.L.WithError(err).Warnf("failed to register layer %s to fscache", layerID)
+				log.G(ctx).WithError(err).Warnf("failed to register layer %s to fscache", layerID)
 			}
 		}
 	}
 
-	log.L.Infof("successfully processed layer %s", layerID)
+	log.G(ctx).Infof("successfully processed layer %s", layerID)
 	return nil
 }
 
 // saveLayerToTemp 保存层数据到临时文件并计算哈希
 func (lp *LayerProcessor) saveLayerToTemp(layerID string, data io.Reader) (string, string, error) {
-	tempFile := filepath.Join(lp.store.root, "temp", layerID+".tar.gz")
+	tempFile := filepath.Join(lp.store.scratch.dir, "temp", layerID+".tar.gz")
 	if err := os.MkdirAll(filepath.Dir(tempFile), 0755); err != nil {
 		return "", "", err
 	}
@@ -137,35 +163,160 @@ func (lp *LayerProcessor) isLayerProcessed(digest string) bool {
 // mergeWithParent 合并父层的文件系统
 func (lp *LayerProcessor) mergeWithParent(ctx context.Context, currentDir, parentID string) error {
 	// 简化版本:实际应该挂载父层的 EROFS 并复制文件
-	log.L.Debugf("merging layer with parent %s", parentID)
+	log.G(ctx).Debugf("merging layer with parent %s", parentID)
 	return nil
 }
 
-// generateLayerManifest 生成层的元数据清单用于 fscache
-func (lp *LayerProcessor) generateLayerManifest(sourceDir, manifestPath string) error {
-	if err := os.MkdirAll(filepath.Dir(manifestPath), 0755); err != nil {
+// generateLayerManifest 从层 tar 流生成 fscache 清单:每个普通文件按 ChunkSize 切分,
+// 记录 chunk 哈希、文件内偏移以及在未压缩 tar 流中的偏移
+func (lp *LayerProcessor) generateLayerManifest(layerID, digest, layerPath, manifestPath string) error {
+	file, err := os.Open(layerPath)
+	if err != nil {
 		return err
 	}
+	defer file.Close()
 
-	file, err := os.Create(manifestPath)
+	decompressed, err := compression.DecompressStream(file)
 	if err != nil {
+		return fmt.Errorf("failed to decompress layer: %w", err)
+	}
+	defer decompressed.Close()
+
+	diffHasher := sha256.New()
+	stream := &countingReader{r: io.TeeReader(decompressed, diffHasher)}
+	tr := tar.NewReader(stream)
+
+	manifest := &fscache.LayerManifest{
+		Version:    fscache.LayerManifestVersion,
+		LayerID:    layerID,
+		Digest:     "sha256:" + digest,
+		Compressed: decompressed.GetCompression() != compression.Uncompressed,
+		ChunkSize:  ChunkSize,
+	}
+
+	pooled := bufpool.Default().Get()
+	defer bufpool.Default().Put(pooled)
+	buf := pooled.B[:ChunkSize]
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read layer tar: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg || strings.HasPrefix(filepath.Base(hdr.Name), ".wh.") {
+			continue
+		}
+
+		// tar.Reader 不做预读,Next 返回后计数即为文件数据在流中的起始偏移
+		dataStart := stream.n
+		entry := &fscache.FileEntry{
+			Path: filepath.Clean("/" + hdr.Name),
+			Size: hdr.Size,
+		}
+
+		if entry.Chunks, err = chunkRefs(tr, hdr.Size, dataStart, buf); err != nil {
+			return fmt.Errorf("failed to read %s: %w", hdr.Name, err)
+		}
+		manifest.Files = append(manifest.Files, entry)
+	}
+
+	// 读完 tar 结尾的填充块,使 DiffID 覆盖整个未压缩流
+	if _, err := io.Copy(io.Discard, stream); err != nil {
 		return err
 	}
-	defer file.Close()
+	manifest.DiffID = "sha256:" + hex.EncodeToString(diffHasher.Sum(nil))
+
+	return fscache.WriteLayerManifest(manifestPath, manifest)
+}
+
+// generateDirManifest 为直接从目录转换的层生成清单。这类层没有 blob,
+// BlobOffset 为按遍历顺序拼接所有普通文件内容后的偏移。
+func (lp *LayerProcessor) generateDirManifest(layerID, sourceDir, manifestPath string) error {
+	manifest := &fscache.LayerManifest{
+		Version:   fscache.LayerManifestVersion,
+		LayerID:   layerID,
+		ChunkSize: ChunkSize,
+	}
+
+	pooled := bufpool.Default().Get()
+	defer bufpool.Default().Put(pooled)
+	buf := pooled.B[:ChunkSize]
+	var blobOffset int64
+	err := filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
 
-	// 遍历目录生成文件清单
-	return filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
+		relPath, err := filepath.Rel(sourceDir, path)
 		if err != nil {
 			return err
 		}
-		if !info.IsDir() && info.Mode().IsRegular() {
-			relPath, _ := filepath.Rel(sourceDir, path)
-			// 格式: 相对路径 \t 文件大小 \t SHA256
-			hash, _ := hashFile(path)
-			fmt.Fprintf(file, "%s\t%d\t%s\n", relPath, info.Size(), hash)
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		entry := &fscache.FileEntry{
+			Path: filepath.Clean("/" + relPath),
+			Size: info.Size(),
+		}
+		if entry.Chunks, err = chunkRefs(file, info.Size(), blobOffset, buf); err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
 		}
+		manifest.Files = append(manifest.Files, entry)
+		blobOffset += info.Size()
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	return fscache.WriteLayerManifest(manifestPath, manifest)
+}
+
+// chunkRefs 把长度为 size 的文件内容按 ChunkSize 切分,blobStart 为文件数据在 blob 中的起始偏移
+func chunkRefs(r io.Reader, size, blobStart int64, buf []byte) ([]*fscache.ChunkRef, error) {
+	var refs []*fscache.ChunkRef
+	for offset := int64(0); offset < size; {
+		n, err := io.ReadFull(r, buf[:min(int64(len(buf)), size-offset)])
+		if err != nil {
+			return nil, err
+		}
+
+		sum := sha256.Sum256(buf[:n])
+		refs = append(refs, &fscache.ChunkRef{
+			Hash:       hex.EncodeToString(sum[:]),
+			FileOffset: offset,
+			BlobOffset: blobStart + offset,
+			Size:       int64(n),
+		})
+		offset += int64(n)
+	}
+	return refs, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// hasPublishedManifest 判断是否已导入与该层 blob 对应的外部清单,见 DedupStore.ImportChunkManifest
+func (lp *LayerProcessor) hasPublishedManifest(manifestPath, digest string) bool {
+	manifest, err := fscache.LoadLayerManifest(manifestPath)
+	return err == nil && manifest.Digest == "sha256:"+digest
 }
 
 // generateManifestPath 生成清单文件路径
@@ -247,19 +398,3 @@ func countFiles(path string) int {
 	})
 	return count
 }
-
-// hashFile 计算文件哈希
-func hashFile(path string) (string, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return "", err
-	}
-	defer file.Close()
-
-	hasher := sha256.New()
-	if _, err := io.Copy(hasher, file); err != nil {
-		return "", err
-	}
-
-	return hex.EncodeToString(hasher.Sum(nil)), nil
-}
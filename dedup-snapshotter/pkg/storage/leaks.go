@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/erofs"
+)
+
+// 泄漏条目的类型
+const (
+	LeakKindMount = "mount"
+	LeakKindLoop  = "loop"
+)
+
+// LeakedMount 是不再被任何快照引用的挂载或 loop 设备,通常是崩溃后遗留的。
+// Path 为挂载点或 loop 设备,Snapshot 为按路径推断出的、已不在元数据中的所属快照
+type LeakedMount struct {
+	Kind     string `json:"kind"`
+	Path     string `json:"path"`
+	FSType   string `json:"fstype,omitempty"`
+	Source   string `json:"source,omitempty"`
+	Snapshot string `json:"snapshot,omitempty"`
+	Reason   string `json:"reason"`
+}
+
+// LeakedMounts 对照 live(元数据中全部快照的 ID)找出泄漏的挂载和 loop 设备
+func (d *DedupStore) LeakedMounts(live map[string]bool) ([]LeakedMount, error) {
+	if d.mountManager == nil {
+		return nil, fmt.Errorf("erofs not enabled")
+	}
+	mounts, err := d.mountManager.HostMounts()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mounts: %w", err)
+	}
+	loops, err := erofs.LoopDevices()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list loop devices: %w", err)
+	}
+	return d.findLeaks(mounts, loops, live), nil
+}
+
+// findLeaks 依次判断:upperdir 属于已删除快照的 overlay;MountsDir 下既未被本进程登记、
+// 名称不是现存快照、也不是其他未泄漏挂载的 lowerdir 的挂载;后端是存储中的镜像但不是任何未泄漏挂载来源的 loop 设备。
+// 泄漏挂载的 lowerdir 不算引用,合并层和其下的层挂载随之一起判为泄漏
+func (d *DedupStore) findLeaks(mounts []erofs.HostMount, loops map[string]string, live map[string]bool) []LeakedMount {
+	mountsDir := d.mountManager.MountsDir()
+	leaked := make(map[string]*LeakedMount)
+
+	for _, m := range mounts {
+		if m.UpperDir == "" {
+			continue
+		}
+		rel, err := filepath.Rel(d.snapsDir, m.UpperDir)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		id := strings.Split(rel, string(filepath.Separator))[0]
+		if !live[id] {
+			leaked[m.Path] = &LeakedMount{Kind: LeakKindMount, Path: m.Path, FSType: m.FSType, Source: m.Source, Snapshot: id,
+				Reason: fmt.Sprintf("upperdir belongs to snapshot %s which no longer exists", id)}
+		}
+	}
+
+	for changed := true; changed; {
+		changed = false
+		referenced := make(map[string]bool)
+		for _, m := range mounts {
+			if leaked[m.Path] == nil {
+				for _, dir := range m.LowerDirs {
+					referenced[dir] = true
+				}
+			}
+		}
+		for _, m := range mounts {
+			if filepath.Dir(m.Path) != mountsDir || leaked[m.Path] != nil {
+				continue
+			}
+			name := filepath.Base(m.Path)
+			if live[name] || referenced[m.Path] || d.mountManager.Tracked(m.Path) {
+				continue
+			}
+			leak := &LeakedMount{Kind: LeakKindMount, Path: m.Path, FSType: m.FSType, Source: m.Source,
+				Reason: "not referenced by any snapshot or mount"}
+			if !erofs.DerivedMount(name) {
+				leak.Snapshot = name
+				leak.Reason = fmt.Sprintf("layer of snapshot %s which no longer exists, not referenced by any mount", name)
+			}
+			leaked[m.Path] = leak
+			changed = true
+		}
+	}
+
+	sources := make(map[string]bool)
+	for _, m := range mounts {
+		if leaked[m.Path] == nil {
+			sources[m.Source] = true
+		}
+	}
+	prefix := filepath.Clean(d.root) + string(filepath.Separator)
+	for dev, backing := range loops {
+		if !strings.HasPrefix(backing, prefix) || sources[dev] || d.mountManager.Tracked(dev) {
+			continue
+		}
+		leaked[dev] = &LeakedMount{Kind: LeakKindLoop, Path: dev, Source: backing, Reason: "backing image is not mounted"}
+	}
+
+	leaks := make([]LeakedMount, 0, len(leaked))
+	for _, l := range leaked {
+		leaks = append(leaks, *l)
+	}
+	// 先卸载容器的 overlay,再卸载底部合并层和其下的层挂载,最后解除 loop 设备
+	order := func(l LeakedMount) int {
+		switch {
+		case l.Kind == LeakKindLoop:
+			return 3
+		case filepath.Dir(l.Path) != mountsDir:
+			return 0
+		case erofs.DerivedMount(filepath.Base(l.Path)):
+			return 1
+		}
+		return 2
+	}
+	sort.Slice(leaks, func(i, j int) bool {
+		ri, rj := order(leaks[i]), order(leaks[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return leaks[i].Path < leaks[j].Path
+	})
+	return leaks
+}
+
+// CleanupLeakedMounts 重新检查后强制卸载 paths 中仍然泄漏的挂载、解除泄漏的 loop 设备,
+// 已不再泄漏的路径跳过,返回实际清理的条目
+func (d *DedupStore) CleanupLeakedMounts(ctx context.Context, live map[string]bool, paths []string) ([]LeakedMount, error) {
+	if err := d.checkWritable(); err != nil {
+		return nil, err
+	}
+	leaks, err := d.LeakedMounts(live)
+	if err != nil {
+		return nil, err
+	}
+	want := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		want[p] = true
+	}
+
+	var cleaned []LeakedMount
+	var errs []error
+	for _, l := range leaks {
+		if !want[l.Path] {
+			continue
+		}
+		if l.Kind == LeakKindLoop {
+			err = d.mountManager.DetachLoop(ctx, l.Path)
+		} else {
+			err = d.mountManager.ForceUnmount(ctx, l.Path)
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		log.G(ctx).Warnf("cleaned up leaked %s %s (%s)", l.Kind, l.Path, l.Reason)
+		cleaned = append(cleaned, l)
+	}
+	if len(errs) > 0 {
+		return cleaned, fmt.Errorf("cleanup errors: %v", errs)
+	}
+	return cleaned, nil
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/erofs"
+)
+
+// TestFindLeakedMounts 验证已删除快照的 overlay、只被它引用的合并层和层挂载、
+// 以及后端镜像未挂载的 loop 设备被判为泄漏,现存快照的挂载不受影响
+func TestFindLeakedMounts(t *testing.T) {
+	root := t.TempDir()
+	store, err := NewDedupStoreWithErofs(root, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if store.mountManager == nil {
+		t.Skip("erofs mount manager not available")
+	}
+
+	mountsDir := store.mountManager.MountsDir()
+	layer := func(name string) string { return filepath.Join(mountsDir, name) }
+	image := filepath.Join(root, "erofs", "img.erofs")
+	mounts := []erofs.HostMount{
+		// 已删除快照 7 的容器 overlay,下层是合并层 merged-x,合并层下层是快照 3 的层挂载
+		{Path: "/run/containers/c1/rootfs", FSType: "overlay", Source: "overlay",
+			LowerDirs: []string{layer("merged-x")}, UpperDir: filepath.Join(store.snapsDir, "7", "fs")},
+		{Path: layer("merged-x"), FSType: "overlay", Source: "overlay", LowerDirs: []string{layer("3")}},
+		{Path: layer("3"), FSType: "erofs", Source: "/dev/loop1"},
+		// 现存快照 5 的 overlay 和它引用的快照 2 的层挂载
+		{Path: "/run/containers/c2/rootfs", FSType: "overlay", Source: "overlay",
+			LowerDirs: []string{layer("2")}, UpperDir: filepath.Join(store.snapsDir, "5", "fs")},
+		{Path: layer("2"), FSType: "erofs", Source: "/dev/loop2"},
+		{Path: "/", FSType: "ext4", Source: "/dev/vda1"},
+	}
+	loops := map[string]string{
+		"/dev/loop1": image,
+		"/dev/loop2": image,
+		"/dev/loop3": image,
+		"/dev/loop4": "/var/lib/other/disk.img",
+	}
+	live := map[string]bool{"5": true}
+
+	leaks := store.findLeaks(mounts, loops, live)
+	want := []string{"/run/containers/c1/rootfs", layer("merged-x"), layer("3"), "/dev/loop1", "/dev/loop3"}
+	if len(leaks) != len(want) {
+		t.Fatalf("expected %d leaks, got %+v", len(want), leaks)
+	}
+	for i, l := range leaks {
+		if l.Path != want[i] {
+			t.Fatalf("leak %d: expected %s, got %+v", i, want[i], l)
+		}
+	}
+	if leaks[0].Snapshot != "7" || leaks[2].Snapshot != "3" || leaks[3].Kind != LeakKindLoop {
+		t.Errorf("unexpected leak details: %+v", leaks)
+	}
+	t.Logf("✓ 泄漏的挂载按 overlay、合并层、层挂载、loop 设备的顺序列出")
+
+	// 快照 7 仍然存在时整条挂载链都不算泄漏
+	live["7"] = true
+	leaks = store.findLeaks(mounts, loops, live)
+	if len(leaks) != 1 || leaks[0].Path != "/dev/loop3" {
+		t.Fatalf("expected only /dev/loop3 to leak, got %+v", leaks)
+	}
+	t.Logf("✓ 现存快照引用的挂载链不被判为泄漏")
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/log"
+)
+
+// MountTypeDir 表示直接以父快照的解包目录作为 lowerdir,是层挂载回退链的最后一环
+const MountTypeDir = "dir"
+
+// 层挂载回退的原因代码,作为指标标签;具体错误见 MountFallback.Error
+const (
+	FallbackReasonDegraded    = "degraded"
+	FallbackReasonMountFailed = "mount_failed"
+	FallbackReasonNoImage     = "no_image"
+)
+
+// MountFallback 记录一次放弃的挂载方式及原因
+type MountFallback struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+	Error  string `json:"error,omitempty"`
+}
+
+// LayerMount 是一个父层实际使用的挂载方式和路径,Fallbacks 按尝试顺序列出之前放弃的方式
+type LayerMount struct {
+	Layer     string          `json:"layer"`
+	Type      string          `json:"type"`
+	Path      string          `json:"path"`
+	Fallbacks []MountFallback `json:"fallbacks,omitempty"`
+}
+
+// MountRecord 是快照最近一次挂载的记录,Type 汇总各层的挂载方式
+type MountRecord struct {
+	Snapshot string       `json:"snapshot"`
+	Strategy string       `json:"strategy"`
+	Type     string       `json:"type"`
+	Layers   []LayerMount `json:"layers"`
+	Time     time.Time    `json:"time"`
+}
+
+// mountRecords 保存各快照最近一次挂载的记录,快照删除时移除
+type mountRecords struct {
+	mu      sync.Mutex
+	records map[string]*MountRecord
+}
+
+func (m *mountRecords) set(r *MountRecord) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.records == nil {
+		m.records = make(map[string]*MountRecord)
+	}
+	m.records[r.Snapshot] = r
+}
+
+func (m *mountRecords) forget(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.records, id)
+}
+
+// MountRecords 返回各快照最近一次挂载使用的方式和回退原因,按快照 ID 排序
+func (d *DedupStore) MountRecords() []*MountRecord {
+	d.mountRecords.mu.Lock()
+	defer d.mountRecords.mu.Unlock()
+	records := make([]*MountRecord, 0, len(d.mountRecords.records))
+	for _, r := range d.mountRecords.records {
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Snapshot < records[j].Snapshot })
+	return records
+}
+
+// MountRecord 返回快照最近一次挂载的记录
+func (d *DedupStore) MountRecord(id string) (*MountRecord, bool) {
+	d.mountRecords.mu.Lock()
+	defer d.mountRecords.mu.Unlock()
+	r, ok := d.mountRecords.records[id]
+	return r, ok
+}
+
+// recordMount 保存快照的挂载记录,并按回退的起止方式和原因计入指标
+func (d *DedupStore) recordMount(id, strategy, mountType string, layers []LayerMount) {
+	d.mountRecords.set(&MountRecord{
+		Snapshot: id,
+		Strategy: strategy,
+		Type:     mountType,
+		Layers:   layers,
+		Time:     time.Now(),
+	})
+	if d.metrics == nil {
+		return
+	}
+	for _, l := range layers {
+		for i, f := range l.Fallbacks {
+			to := l.Type
+			if i+1 < len(l.Fallbacks) {
+				to = l.Fallbacks[i+1].Type
+			}
+			d.metrics.IncMountFallback(f.Type, to, f.Reason)
+		}
+	}
+}
+
+// mountLayer 按 fscache → loop EROFS → 解包目录的顺序挂载父层,前一种不可用时回退到下一种并记录原因。
+// 未启用 fscache 时从 loop 开始,不算回退;不可变存储没有预置镜像时直接报错
+func (d *DedupStore) mountLayer(ctx context.Context, parent string) (LayerMount, error) {
+	lm := LayerMount{Layer: parent}
+	fallback := func(typ, reason string, err error) {
+		f := MountFallback{Type: typ, Reason: reason}
+		if err != nil {
+			f.Error = err.Error()
+		}
+		lm.Fallbacks = append(lm.Fallbacks, f)
+		log.G(ctx).WithError(err).Warnf("layer %s: %s mount unavailable (%s), falling back", parent, typ, reason)
+	}
+
+	imagePath := d.imagePath(parent)
+	if _, err := os.Stat(imagePath); err != nil {
+		if d.immutable {
+			return lm, fmt.Errorf("parent %s has no pre-baked erofs image and %w", parent, ErrImmutableStore)
+		}
+		fallback(d.MountStrategy(), FallbackReasonNoImage, err)
+	} else {
+		if d.useFscache && d.dedupDaemon != nil {
+			if d.FscacheDegraded(parent) {
+				fallback(MountTypeFscache, FallbackReasonDegraded, nil)
+			} else if path, err := d.mountManager.MountErofsWithFscache(ctx, parent, parent, "dedup-snapshotter"); err != nil {
+				fallback(MountTypeFscache, FallbackReasonMountFailed, err)
+			} else {
+				d.dedupDaemon.SetMounted(parent, true)
+				lm.Type, lm.Path = MountTypeFscache, path
+				return lm, nil
+			}
+		}
+
+		path, err := d.mountManager.MountErofs(ctx, parent, imagePath)
+		if err == nil {
+			lm.Type, lm.Path = MountTypeLoop, path
+			return lm, nil
+		}
+		fallback(MountTypeLoop, FallbackReasonMountFailed, err)
+	}
+
+	dir := filepath.Join(d.snapshotDir(parent), "fs")
+	if _, err := os.Stat(dir); err != nil {
+		reasons := make([]string, 0, len(lm.Fallbacks))
+		for _, f := range lm.Fallbacks {
+			reason := f.Error
+			if reason == "" {
+				reason = f.Reason
+			}
+			reasons = append(reasons, f.Type+": "+reason)
+		}
+		return lm, fmt.Errorf("no usable mount for parent %s (%s), and no extracted layer: %w", parent, strings.Join(reasons, "; "), err)
+	}
+	lm.Type, lm.Path = MountTypeDir, dir
+	return lm, nil
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/metrics"
+)
+
+// TestMountFallbackChain 验证父层没有 EROFS 镜像或镜像无法挂载时回退到解包目录,
+// 挂载记录列出每层使用的方式和原因,回退计入指标,快照删除后记录被移除
+func TestMountFallbackChain(t *testing.T) {
+	store, err := NewDedupStoreWithErofs(filepath.Join(t.TempDir(), "root"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	m := metrics.NewMetrics()
+	store.SetMetrics(m)
+	ctx := context.Background()
+
+	for _, id := range []string{"1", "2", "3"} {
+		if err := store.Prepare(ctx, id, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{"1", "2"} {
+		if err := os.MkdirAll(filepath.Join(store.snapsDir, id, "fs"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(store.snapsDir, id, "fs", "layer-"+id), []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 父层 2 的镜像损坏,loop 挂载失败
+	if err := os.MkdirAll(filepath.Dir(store.imagePath("2")), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(store.imagePath("2"), []byte("not an erofs image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mounts, err := store.Mounts(ctx, "3", []string{"2", "1"}, MountOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := strings.Join(mounts[0].Options, ",")
+	if !strings.Contains(opts, filepath.Join(store.snapsDir, "1", "fs")) || !strings.Contains(opts, filepath.Join(store.snapsDir, "2", "fs")) {
+		t.Fatalf("expected extracted layers as lowerdirs: %s", opts)
+	}
+
+	record, ok := store.MountRecord("3")
+	if !ok || record.Type != MountTypeDir || len(record.Layers) != 2 {
+		t.Fatalf("unexpected mount record: %+v", record)
+	}
+	for _, l := range record.Layers {
+		if l.Type != MountTypeDir || len(l.Fallbacks) != 1 {
+			t.Fatalf("expected layer %s to fall back to its extracted dir once: %+v", l.Layer, l)
+		}
+	}
+	if f := record.Layers[0].Fallbacks[0]; f.Type != MountTypeLoop || f.Reason != FallbackReasonMountFailed || f.Error == "" {
+		t.Errorf("expected failed loop mount for corrupt image of 2: %+v", f)
+	}
+	if f := record.Layers[1].Fallbacks[0]; f.Type != MountTypeLoop || f.Reason != FallbackReasonNoImage {
+		t.Errorf("expected missing image for 1: %+v", f)
+	}
+	t.Logf("✓ 挂载记录: %+v", record.Layers)
+
+	fallbacks := m.GetSnapshot().MountFallbacks
+	if len(fallbacks) != 2 || fallbacks[0].To != MountTypeDir || fallbacks[0].Count != 1 || fallbacks[1].Count != 1 {
+		t.Errorf("unexpected fallback metrics: %+v", fallbacks)
+	}
+	t.Logf("✓ 回退指标: %+v", fallbacks)
+
+	if err := store.Remove(ctx, "3"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.MountRecord("3"); ok {
+		t.Error("expected mount record to be removed with the snapshot")
+	}
+}
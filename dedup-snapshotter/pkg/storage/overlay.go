@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/mount"
+)
+
+// MountsOverlay 直接以父快照的解包目录作为 lowerdir 挂载,不使用 EROFS 镜像链,
+// 适合大量写入的负载。由远程物化、没有解包目录的父快照不能以这种方式挂载
+func (d *DedupStore) MountsOverlay(ctx context.Context, id string, parents []string, opts MountOptions) ([]mount.Mount, error) {
+	if d.mountManager == nil {
+		return nil, fmt.Errorf("mount manager not initialized")
+	}
+	start := time.Now()
+
+	lowerDirs := make([]string, 0, len(parents))
+	layers := make([]LayerMount, 0, len(parents))
+	for _, parent := range parents {
+		dir := filepath.Join(d.snapshotDir(parent), "fs")
+		if _, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("parent %s has no layer content for overlay mount: %w", parent, err)
+		}
+		lowerDirs = append(lowerDirs, dir)
+		layers = append(layers, LayerMount{Layer: parent, Type: MountTypeDir, Path: dir})
+	}
+
+	snapPath := filepath.Join(d.snapsDir, id)
+	upperDir := filepath.Join(snapPath, "fs")
+	lowerDirs, err := d.mapLayers(ctx, id, lowerDirs, upperDir, opts.IDMap)
+	if err != nil {
+		return nil, err
+	}
+	mounts, err := d.mountManager.CreateOverlayMounts(ctx, id, lowerDirs, upperDir, filepath.Join(snapPath, "work"), d.mountLabel(ctx, id, opts))
+	if err != nil {
+		return nil, err
+	}
+	d.recordMount(id, MountStrategyOverlay, MountTypeOverlay, layers)
+	if d.metrics != nil {
+		d.metrics.ObserveOperation("mount", len(parents), MountTypeOverlay, time.Since(start))
+	}
+	return mounts, nil
+}
+
+// SnapshotForUpper 由 overlay 挂载的 upperdir 反查快照 ID,不是本存储的快照目录时返回 false
+func (d *DedupStore) SnapshotForUpper(upperDir string) (string, bool) {
+	rel, err := filepath.Rel(d.snapsDir, filepath.Clean(upperDir))
+	if err != nil {
+		return "", false
+	}
+	id, dir := filepath.Split(rel)
+	id = filepath.Clean(id)
+	if dir != "fs" || id == "." || strings.Contains(id, string(filepath.Separator)) || strings.HasPrefix(id, "..") {
+		return "", false
+	}
+	return id, true
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+)
+
+// warmupStateFile 是 root 下记录缓存预热结果的文件,存在时节点不再是新节点,启动时不再预热
+const warmupStateFile = "warmup.json"
+
+// maxPopularityFeedBytes 限制热度列表的大小
+const maxPopularityFeedBytes = 16 << 20
+
+// ErrWarmupNotRun 表示节点上尚未进行过缓存预热
+var ErrWarmupNotRun = errors.New("cache warm-up has not run on this node")
+
+// PopularityFeed 是集群范围的镜像热度列表,Deployments 为镜像在集群中的部署次数
+type PopularityFeed struct {
+	GeneratedAt time.Time      `json:"generated_at"`
+	Images      []PopularImage `json:"images"`
+}
+
+// PopularImage 是热度列表中的一个镜像,Ref 最好按 digest 固定
+type PopularImage struct {
+	Ref         string `json:"ref"`
+	Deployments int64  `json:"deployments"`
+}
+
+// WarmupImage 是一个镜像的预热结果,成功提交时 JobID 为拉取任务 ID,否则 Error 说明原因
+type WarmupImage struct {
+	Ref         string `json:"ref"`
+	Deployments int64  `json:"deployments"`
+	JobID       string `json:"job_id,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// WarmupStatus 记录一次缓存预热:从 Feed 中取部署最多的镜像,以低优先级提交拉取任务
+type WarmupStatus struct {
+	Feed          string        `json:"feed"`
+	FeedGenerated time.Time     `json:"feed_generated_at"`
+	Time          time.Time     `json:"time"`
+	Images        []WarmupImage `json:"images"`
+}
+
+// LoadPopularityFeed 读取本地 JSON 文件或 http(s) URL 上的热度列表
+func (d *DedupStore) LoadPopularityFeed(ctx context.Context, source string) (*PopularityFeed, error) {
+	var r io.Reader
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := d.transport.Client(time.Minute).Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch popularity feed: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to fetch popularity feed: %s", resp.Status)
+		}
+		r = resp.Body
+	} else {
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open popularity feed: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var feed PopularityFeed
+	if err := json.NewDecoder(io.LimitReader(r, maxPopularityFeedBytes)).Decode(&feed); err != nil {
+		return nil, fmt.Errorf("invalid popularity feed %s: %w", source, err)
+	}
+	return &feed, nil
+}
+
+// WarmupStatus 返回节点最近一次缓存预热的结果
+func (d *DedupStore) WarmupStatus() (*WarmupStatus, error) {
+	data, err := os.ReadFile(filepath.Join(d.root, warmupStateFile))
+	if os.IsNotExist(err) {
+		return nil, ErrWarmupNotRun
+	}
+	if err != nil {
+		return nil, err
+	}
+	var status WarmupStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		return nil, fmt.Errorf("invalid warm-up state: %w", err)
+	}
+	return &status, nil
+}
+
+// WarmFromPopularity 从 warmup.feed 读取热度列表,为部署最多的 warmup.top_n 个镜像以低优先级提交拉取任务,
+// 使新节点尽快达到稳定的缓存命中率。force 为 false 且节点已预热过时直接返回上次的结果
+func (d *DedupStore) WarmFromPopularity(ctx context.Context, force bool) (*WarmupStatus, error) {
+	if err := d.checkWritable(); err != nil {
+		return nil, err
+	}
+	if d.conversions == nil {
+		return nil, fmt.Errorf("conversion queue not available")
+	}
+	if !force {
+		if status, err := d.WarmupStatus(); err == nil {
+			return status, nil
+		}
+	}
+	var cfg config.WarmupConfig
+	if c := d.cfg(); c != nil {
+		cfg = c.Warmup
+	}
+	if cfg.Feed == "" {
+		return nil, fmt.Errorf("warmup.feed not configured")
+	}
+
+	feed, err := d.LoadPopularityFeed(ctx, cfg.Feed)
+	if err != nil {
+		return nil, err
+	}
+	images := append([]PopularImage(nil), feed.Images...)
+	sort.SliceStable(images, func(i, j int) bool { return images[i].Deployments > images[j].Deployments })
+	if len(images) > cfg.TopN {
+		images = images[:cfg.TopN]
+	}
+
+	status := &WarmupStatus{Feed: cfg.Feed, FeedGenerated: feed.GeneratedAt, Time: time.Now(), Images: []WarmupImage{}}
+	for _, img := range images {
+		result := WarmupImage{Ref: img.Ref, Deployments: img.Deployments}
+		job, err := d.conversions.SubmitPull(img.Ref)
+		if err != nil {
+			result.Error = err.Error()
+			log.G(ctx).WithError(err).Warnf("failed to warm cache with %s", img.Ref)
+		} else {
+			// 预热不应挡住节点上实际调度的镜像,已在排队的同一镜像保持原优先级
+			if !job.CreatedAt.Before(status.Time) {
+				d.conversions.SetPriority(job.ID, PriorityLow)
+			}
+			result.JobID = job.ID
+		}
+		status.Images = append(status.Images, result)
+	}
+
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(d.root, warmupStateFile)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to record warm-up: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return nil, fmt.Errorf("failed to record warm-up: %w", err)
+	}
+	log.G(ctx).Infof("warming cache with %d popular images from %s", len(status.Images), cfg.Feed)
+	return status, nil
+}
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+)
+
+// TestWarmFromPopularity 验证新节点从热度列表中为部署最多的镜像以低优先级提交拉取任务,且只预热一次
+func TestWarmFromPopularity(t *testing.T) {
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"generated_at": "2026-10-01T00:00:00Z", "images": [
+			{"ref": "docker.io/library/rare:1", "deployments": 2},
+			{"ref": "docker.io/library/web:1", "deployments": 900},
+			{"ref": "not a reference", "deployments": 500},
+			{"ref": "docker.io/library/api:1", "deployments": 300}
+		]}`))
+	}))
+	defer feed.Close()
+
+	root := t.TempDir()
+	cfg := config.DefaultConfig(root)
+	cfg.EnableErofs = false
+	cfg.EnableFscache = false
+	cfg.Warmup = config.WarmupConfig{Enabled: true, Feed: feed.URL, TopN: 3}
+	store, err := NewDedupStoreWithConfig(root, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	// 不启动 worker,任务留在队列中
+	store.conversions.Close()
+	store.conversions = NewConversionQueue(store, t.TempDir(), 0, 10)
+
+	ctx := context.Background()
+	if _, err := store.WarmupStatus(); !errors.Is(err, ErrWarmupNotRun) {
+		t.Fatalf("expected a new node to be unwarmed, got %v", err)
+	}
+	status, err := store.WarmFromPopularity(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Images) != 3 || status.Images[0].Ref != "docker.io/library/web:1" || status.Images[2].Ref != "docker.io/library/api:1" {
+		t.Fatalf("expected the 3 most deployed images, got %+v", status.Images)
+	}
+	if status.Images[1].Error == "" || status.Images[0].JobID == "" {
+		t.Fatalf("expected an invalid reference to be reported, got %+v", status.Images)
+	}
+	job, ok := store.conversions.GetJob(status.Images[0].JobID)
+	if !ok || !job.Pull || job.Priority != PriorityLow {
+		t.Fatalf("expected a low priority pull job, got %+v", job)
+	}
+	t.Logf("✓ 按部署次数提交 %d 个低优先级拉取任务", store.conversions.Depth())
+
+	again, err := store.WarmFromPopularity(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.Time.Equal(status.Time) || store.conversions.Depth() != 2 {
+		t.Fatalf("expected an already warmed node to be left alone, got %+v", again)
+	}
+	t.Logf("✓ 已预热的节点重启后不再重复预热")
+}
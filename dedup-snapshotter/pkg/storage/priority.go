@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	refdocker "github.com/containerd/containerd/reference/docker"
+	"github.com/containerd/log"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// AnnotationConversionPriority 是镜像 index 或 manifest 上的转换优先级提示,取值见 ParseConversionPriority
+const AnnotationConversionPriority = "containerd.io/snapshot/dedup.conversion-priority"
+
+// 转换任务的优先级,数值越大越先执行
+const (
+	PriorityLow    = -1
+	PriorityNormal = 0
+	PriorityHigh   = 1
+)
+
+// pullDemandHalfLife 是镜像拉取热度的半衰期。imagePullPolicy 为 Always 的镜像每次调度都经 CRI 拉取一次,
+// 热度随之累积,调度稀少的镜像热度逐渐衰减
+const pullDemandHalfLife = time.Hour
+
+// ParseConversionPriority 解析 low、normal、high 形式的优先级,空值为 normal
+func ParseConversionPriority(v string) (int, error) {
+	switch v {
+	case "low":
+		return PriorityLow, nil
+	case "", "normal":
+		return PriorityNormal, nil
+	case "high":
+		return PriorityHigh, nil
+	}
+	return 0, fmt.Errorf("invalid conversion priority %q: must be low, normal or high", v)
+}
+
+// pullDemand 是一个仓库按半衰期衰减的拉取次数
+type pullDemand struct {
+	score float64
+	at    time.Time
+}
+
+func (p *pullDemand) value(now time.Time) float64 {
+	return p.score * math.Exp2(-now.Sub(p.at).Seconds()/pullDemandHalfLife.Seconds())
+}
+
+// repository 返回镜像引用规范化后的仓库名,不是镜像引用时返回空
+func repository(ref string) string {
+	named, err := refdocker.ParseNormalizedNamed(ref)
+	if err != nil {
+		return ""
+	}
+	return named.Name()
+}
+
+// NotePull 记录一次经 CRI 或 API 的镜像拉取,提高同一仓库排队任务的热度,
+// priority 高于排队任务的优先级时一并提升
+func (q *ConversionQueue) NotePull(ref string, priority int) {
+	repo := repository(ref)
+	if repo == "" {
+		return
+	}
+	now := time.Now()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	d := q.demand[repo]
+	if d == nil {
+		d = &pullDemand{}
+		q.demand[repo] = d
+	}
+	d.score = d.value(now) + 1
+	d.at = now
+	for _, job := range q.pending {
+		if job.Priority < priority && repository(job.ImageRef) == repo {
+			job.Priority = priority
+		}
+	}
+	// 热度衰减到可以忽略的仓库不再保留
+	for r, d := range q.demand {
+		if d.value(now) < 0.01 {
+			delete(q.demand, r)
+		}
+	}
+}
+
+// SetPriority 修改任务的优先级,只影响仍在排队的任务
+func (q *ConversionQueue) SetPriority(id string, priority int) (*ConversionJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	if job.State == JobStateQueued {
+		job.Priority = priority
+	}
+	copied := *job
+	return &copied, true
+}
+
+// next 从排队任务中取出优先级最高的一个:先比较 Priority,再比较仓库的拉取热度,最后按提交顺序
+func (q *ConversionQueue) next() *ConversionJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	demand := func(job *ConversionJob) float64 {
+		if d := q.demand[repository(job.ImageRef)]; d != nil {
+			return d.value(now)
+		}
+		return 0
+	}
+	best := 0
+	for i := 1; i < len(q.pending); i++ {
+		a, b := q.pending[i], q.pending[best]
+		if a.Priority != b.Priority {
+			if a.Priority > b.Priority {
+				best = i
+			}
+			continue
+		}
+		if da, db := demand(a), demand(b); da != db {
+			if da > db {
+				best = i
+			}
+			continue
+		}
+		if a.CreatedAt.Before(b.CreatedAt) {
+			best = i
+		}
+	}
+	job := q.pending[best]
+	q.pending = append(q.pending[:best], q.pending[best+1:]...)
+	return job
+}
+
+// annotatedPriority 返回 content store 中镜像 index 或 manifest 上 AnnotationConversionPriority 给出的优先级,
+// 镜像不在 content store 中或没有该注解时为 normal
+func (q *ConversionQueue) annotatedPriority(dgst digest.Digest) int {
+	if _, err := os.Stat(q.contentRoot); err != nil {
+		return PriorityNormal
+	}
+	cs, err := local.NewStore(q.contentRoot)
+	if err != nil {
+		return PriorityNormal
+	}
+	ctx := context.Background()
+	info, err := cs.Info(ctx, dgst)
+	if err != nil {
+		return PriorityNormal
+	}
+	data, err := content.ReadBlob(ctx, cs, ocispec.Descriptor{Digest: info.Digest, Size: info.Size})
+	if err != nil {
+		return PriorityNormal
+	}
+	var probe struct {
+		Annotations map[string]string `json:"annotations"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return PriorityNormal
+	}
+	v, ok := probe.Annotations[AnnotationConversionPriority]
+	if !ok {
+		return PriorityNormal
+	}
+	priority, err := ParseConversionPriority(v)
+	if err != nil {
+		log.L.WithError(err).Warnf("ignoring %s on %s", AnnotationConversionPriority, dgst)
+		return PriorityNormal
+	}
+	return priority
+}
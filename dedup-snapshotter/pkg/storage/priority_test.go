@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"os"
+	"testing"
+)
+
+// TestConversionPriority 验证排队的转换任务按优先级、仓库拉取热度、提交顺序执行,
+// CRI 拉取时的优先级提示提升同一仓库排队中的任务
+func TestConversionPriority(t *testing.T) {
+	store, err := NewDedupStoreWithErofs(t.TempDir(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	allowSourceDirs(store, os.TempDir())
+
+	// 不启动 worker,手动取出任务检查顺序
+	q := NewConversionQueue(store, t.TempDir(), 0, 10)
+	defer q.Close()
+
+	submit := func(ref string) *ConversionJob {
+		job, err := q.SubmitPull(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return job
+	}
+	batch := submit("docker.io/library/batch:latest")
+	web := submit("docker.io/library/web:latest")
+	api := submit("docker.io/library/api:latest")
+	dir, err := q.SubmitDirectory(t.TempDir(), "local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := q.SetPriority(dir.ID, PriorityLow); !ok {
+		t.Fatal("expected directory job to exist")
+	}
+
+	// web 被频繁调度,api 的 Pod 带有 high 优先级提示
+	for i := 0; i < 3; i++ {
+		q.NotePull("web:latest", PriorityNormal)
+	}
+	q.NotePull("docker.io/library/api:v2", PriorityHigh)
+	if q.Depth() != 4 {
+		t.Fatalf("expected 4 queued jobs, got %d", q.Depth())
+	}
+
+	want := []string{api.ID, web.ID, batch.ID, dir.ID}
+	for i, id := range want {
+		job := q.next()
+		if job.ID != id {
+			t.Fatalf("job %d: expected %s, got %s (%s, priority %d)", i, id, job.ID, job.ImageRef, job.Priority)
+		}
+	}
+	if q.Depth() != 0 {
+		t.Fatalf("expected empty queue, got %d", q.Depth())
+	}
+	t.Logf("✓ 任务按优先级提示、拉取热度、提交顺序取出")
+
+	if _, err := ParseConversionPriority("urgent"); err == nil {
+		t.Error("expected invalid priority to be rejected")
+	}
+}
@@ -0,0 +1,564 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/reference"
+	refdocker "github.com/containerd/containerd/reference/docker"
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/fscache"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// AnnotationChunkManifest 是层描述符上的注解,值为同一仓库中该层 chunk 清单
+// (fscache.MediaTypeLayerManifest)的 digest,用于不支持 referrers API 的仓库。
+// 未压缩层的清单中 BlobOffset 即 blob 内偏移,可以按范围只下载本地缺少的部分
+const AnnotationChunkManifest = "containerd.io/snapshot/dedup.chunk-manifest"
+
+// LayerPullStats 记录单个层的拉取情况
+type LayerPullStats struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+	// Cached 表示该层已物化过,没有下载任何数据
+	Cached       bool  `json:"cached"`
+	Chunks       int   `json:"chunks"`
+	ReusedChunks int   `json:"reused_chunks"`
+	FetchedBytes int64 `json:"fetched_bytes"`
+	ReusedBytes  int64 `json:"reused_bytes"`
+}
+
+// PullResult 汇总一次拉取的结果
+type PullResult struct {
+	Ref          string           `json:"ref"`
+	Digest       string           `json:"digest"`
+	Layers       []LayerPullStats `json:"layers"`
+	FetchedBytes int64            `json:"fetched_bytes"`
+	ReusedBytes  int64            `json:"reused_bytes"`
+}
+
+// PullImage 从镜像仓库拉取镜像并逐层物化为 EROFS 镜像。已物化的层直接跳过;
+// 带 chunk 清单的未压缩层用本地已有 chunk 拼出层 tar,只按范围下载缺少的部分。
+// 物化后的层在 containerd 解包时由 Snapshotter 直接提交,不再重复下载
+func (d *DedupStore) PullImage(ctx context.Context, ref string) (*PullResult, error) {
+	if err := d.checkWritable(); err != nil {
+		return nil, err
+	}
+	img, err := d.resolveRemoteImage(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	published := d.publishedChunkManifests(ctx, img.registry, img.provider, img.desc.Digest)
+
+	result := &PullResult{Ref: img.name, Digest: img.desc.Digest.String(), Layers: []LayerPullStats{}}
+	parent := ""
+	for _, layer := range img.manifest.Layers {
+		layerID := layer.Digest.Encoded()
+		stats := LayerPullStats{Digest: layer.Digest.String(), Size: layer.Size}
+
+		if d.HasLayer(layerID) {
+			stats.Cached = true
+			d.ledger.AddCacheServed(layerID, layer.Size)
+		} else if err := d.pullLayer(ctx, img.provider, img.registry, layer, layerID, parent, published[layer.Digest.String()], &stats); err != nil {
+			return nil, fmt.Errorf("failed to pull layer %s: %w", layer.Digest, err)
+		}
+
+		d.ledger.AddDownloaded(layerID, stats.FetchedBytes)
+		d.ledger.AddCacheServed(layerID, stats.ReusedBytes)
+		result.FetchedBytes += stats.FetchedBytes
+		result.ReusedBytes += stats.ReusedBytes
+		result.Layers = append(result.Layers, stats)
+		parent = layerID
+	}
+
+	log.G(ctx).Infof("pulled %s: fetched %d bytes, reused %d bytes", img.name, result.FetchedBytes, result.ReusedBytes)
+	return result, nil
+}
+
+// remoteImage 是在镜像仓库中解析出的当前平台镜像
+type remoteImage struct {
+	name     string
+	desc     ocispec.Descriptor
+	manifest ocispec.Manifest
+	provider *fetchProvider
+	registry *registryClient
+}
+
+func (d *DedupStore) resolveRemoteImage(ctx context.Context, ref string) (*remoteImage, error) {
+	named, err := refdocker.ParseDockerRef(ref)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image reference %q: %w", ref, err)
+	}
+
+	authorizer := docker.NewDockerAuthorizer()
+	hosts := docker.ConfigureDefaultRegistries(
+		docker.WithAuthorizer(authorizer),
+		docker.WithPlainHTTP(docker.MatchLocalhost),
+		docker.WithClient(d.transport.Client(0)),
+	)
+	resolver := docker.NewResolver(docker.ResolverOptions{Hosts: hosts})
+
+	name, desc, err := resolver.Resolve(ctx, named.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", named, err)
+	}
+	fetcher, err := resolver.Fetcher(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	provider := &fetchProvider{fetcher: fetcher}
+
+	manifest, err := images.Manifest(ctx, provider, desc, platforms.Default())
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve manifest of %s: %w", name, err)
+	}
+	registry, err := newRegistryClient(hosts, name)
+	if err != nil {
+		return nil, err
+	}
+	return &remoteImage{name: name, desc: desc, manifest: manifest, provider: provider, registry: registry}, nil
+}
+
+// HasLayer 判断层是否已物化:元数据和 EROFS 镜像都存在
+func (d *DedupStore) HasLayer(layerID string) bool {
+	if !d.HasErofsImage(layerID) {
+		return false
+	}
+	_, err := d.GetLayerMetadata(layerID)
+	return err == nil
+}
+
+// UseLayerImage 让快照直接使用已物化层的 EROFS 镜像
+func (d *DedupStore) UseLayerImage(id, layerID string) error {
+	return d.indexDB.SetImageKey(id, layerID)
+}
+
+func (d *DedupStore) pullLayer(ctx context.Context, provider *fetchProvider, registry *registryClient, layer ocispec.Descriptor, layerID, parent string, chunks *fscache.LayerManifest, stats *LayerPullStats) error {
+	if chunks == nil {
+		chunks = d.layerChunkManifest(ctx, provider, registry, layer)
+	}
+	if chunks != nil {
+		if err := checkChunkManifest(ctx, layer, chunks); err != nil {
+			log.G(ctx).WithError(err).Warnf("ignoring chunk manifest of %s", layer.Digest)
+			chunks = nil
+		}
+	}
+	if chunks != nil {
+		// 预先写入清单,注册 fscache 时不必再切分层数据
+		if err := d.ImportChunkManifest(layerID, chunks); err != nil {
+			log.G(ctx).WithError(err).Warnf("failed to import chunk manifest of %s", layer.Digest)
+		}
+		if !chunks.Compressed {
+			return d.materializeLayer(ctx, layer, layerID, parent, func(w io.Writer) error {
+				return d.assembleLayer(ctx, registry, layer, chunks, w, stats)
+			})
+		}
+	}
+
+	return d.materializeLayer(ctx, layer, layerID, parent, func(w io.Writer) error {
+		rc, err := provider.fetcher.Fetch(ctx, layer)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+
+		n, err := io.Copy(w, rc)
+		stats.FetchedBytes += n
+		return err
+	})
+}
+
+// layerChunkManifest 读取层注解指向的 chunk 清单,不可用时返回 nil,调用方回退到整层下载
+func (d *DedupStore) layerChunkManifest(ctx context.Context, provider *fetchProvider, registry *registryClient, layer ocispec.Descriptor) *fscache.LayerManifest {
+	value, ok := layer.Annotations[AnnotationChunkManifest]
+	if !ok {
+		return nil
+	}
+	dgst, err := digest.Parse(value)
+	if err != nil {
+		log.G(ctx).WithError(err).Warnf("invalid chunk manifest annotation on %s", layer.Digest)
+		return nil
+	}
+	if !d.acceptPublished(ctx, registry, provider, dgst) {
+		return nil
+	}
+
+	data, err := content.ReadBlob(ctx, provider, ocispec.Descriptor{Digest: dgst, MediaType: fscache.MediaTypeLayerManifest})
+	if err != nil {
+		log.G(ctx).WithError(err).Warnf("failed to fetch chunk manifest of %s", layer.Digest)
+		return nil
+	}
+	manifest, err := fscache.ParseLayerManifest(data)
+	if err != nil {
+		log.G(ctx).WithError(err).Warnf("ignoring chunk manifest of %s", layer.Digest)
+		return nil
+	}
+	return manifest
+}
+
+// publishedChunkManifests 查找构建系统作为 referrer 发布在 subject 上的 chunk 清单,
+// 返回层 digest 到清单的映射。查找失败只影响拉取效率,因此只记录日志
+func (d *DedupStore) publishedChunkManifests(ctx context.Context, registry *registryClient, provider *fetchProvider, subject digest.Digest) map[string]*fscache.LayerManifest {
+	// 只存在于本地的镜像没有发布的清单,在 TTL 内不再重复查询
+	key := "referrers:" + subject.String()
+	if d.negative != nil && d.negative.Lookup(registry.host.Host, registry.repo, key) {
+		return nil
+	}
+	artifacts, err := registry.referrers(ctx, subject, fscache.ArtifactTypeChunkManifest)
+	if err != nil {
+		log.G(ctx).WithError(err).Debugf("failed to list chunk manifest referrers of %s", subject)
+		return nil
+	}
+	if len(artifacts) == 0 && d.negative != nil {
+		d.negative.Add(registry.host.Host, registry.repo, key)
+	}
+
+	manifests := make(map[string]*fscache.LayerManifest)
+	for _, artifact := range artifacts {
+		if !d.acceptPublished(ctx, registry, provider, artifact.Digest) {
+			continue
+		}
+		data, err := content.ReadBlob(ctx, provider, artifact)
+		if err != nil {
+			log.G(ctx).WithError(err).Warnf("failed to fetch chunk manifest artifact %s", artifact.Digest)
+			continue
+		}
+		var m ocispec.Manifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			log.G(ctx).WithError(err).Warnf("invalid chunk manifest artifact %s", artifact.Digest)
+			continue
+		}
+
+		for _, blob := range m.Layers {
+			if blob.MediaType != fscache.MediaTypeLayerManifest {
+				continue
+			}
+			data, err := content.ReadBlob(ctx, provider, blob)
+			if err != nil {
+				log.G(ctx).WithError(err).Warnf("failed to fetch chunk manifest %s", blob.Digest)
+				continue
+			}
+			layer, err := fscache.ParseLayerManifest(data)
+			if err != nil {
+				log.G(ctx).WithError(err).Warnf("ignoring chunk manifest %s", blob.Digest)
+				continue
+			}
+			manifests[layer.Digest] = layer
+		}
+	}
+
+	if len(manifests) > 0 {
+		log.G(ctx).Infof("found published chunk manifests for %d layers of %s", len(manifests), subject)
+	}
+	return manifests
+}
+
+// checkChunkManifest 确认清单描述的正是该层
+func checkChunkManifest(ctx context.Context, layer ocispec.Descriptor, manifest *fscache.LayerManifest) error {
+	if manifest.Digest != layer.Digest.String() {
+		return fmt.Errorf("manifest describes layer %s", manifest.Digest)
+	}
+	compression, err := images.DiffCompression(ctx, layer.MediaType)
+	if err != nil {
+		return err
+	}
+	if manifest.Compressed != (compression != "") {
+		return fmt.Errorf("manifest compression does not match media type %s", layer.MediaType)
+	}
+	return nil
+}
+
+// ImportChunkManifest 保存外部发布的层清单,LayerProcessor 处理该层时直接使用而不再切分
+func (d *DedupStore) ImportChunkManifest(layerID string, manifest *fscache.LayerManifest) error {
+	if err := manifest.Validate(); err != nil {
+		return err
+	}
+	manifest.LayerID = layerID
+	return fscache.WriteLayerManifest(d.layerProcessor.generateManifestPath(layerID), manifest)
+}
+
+// ConvertProxiedLayer 物化经拉取代理下载的层 blob 并记录其 chunk 清单,blobPath 为已校验 digest 的 blob。
+// 已物化的层只补写缺少的清单,节点随后可以按清单从共享存储懒加载该层
+func (d *DedupStore) ConvertProxiedLayer(ctx context.Context, layer ocispec.Descriptor, blobPath string) error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	return d.convertLayerBlob(ctx, layer, blobPath, "")
+}
+
+// convertLayerBlob 物化本地的层 blob 并补写缺少的 chunk 清单
+func (d *DedupStore) convertLayerBlob(ctx context.Context, layer ocispec.Descriptor, blobPath, parent string) error {
+	layerID := layer.Digest.Encoded()
+
+	if !d.HasLayer(layerID) {
+		f, err := os.Open(blobPath)
+		if err != nil {
+			return err
+		}
+		err = d.ApplyLayer(ctx, layerID, f, parent)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to convert layer %s: %w", layer.Digest, err)
+		}
+	}
+
+	manifestPath := d.layerProcessor.generateManifestPath(layerID)
+	if d.layerProcessor.hasPublishedManifest(manifestPath, layer.Digest.Encoded()) {
+		return nil
+	}
+	if err := d.layerProcessor.generateLayerManifest(layerID, layer.Digest.Encoded(), blobPath, manifestPath); err != nil {
+		return fmt.Errorf("failed to generate chunk manifest of %s: %w", layer.Digest, err)
+	}
+	d.signArtifact(manifestPath)
+	return nil
+}
+
+// assembleLayer 按 blob 偏移顺序拼出层 tar:本地有的 chunk 直接读取,
+// 缺少的 chunk 与 tar 头等间隙合并成连续区间后按范围下载
+func (d *DedupStore) assembleLayer(ctx context.Context, registry *registryClient, layer ocispec.Descriptor, manifest *fscache.LayerManifest, w io.Writer, stats *LayerPullStats) error {
+	var refs []*fscache.ChunkRef
+	for _, file := range manifest.Files {
+		refs = append(refs, file.Chunks...)
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].BlobOffset < refs[j].BlobOffset })
+	stats.Chunks = len(refs)
+
+	var cursor int64
+	missingStart := int64(-1)
+	flush := func(end int64) error {
+		if missingStart < 0 || end <= missingStart {
+			missingStart = -1
+			return nil
+		}
+		if err := registry.fetchRange(ctx, layer.Digest, missingStart, end-missingStart, w); err != nil {
+			return err
+		}
+		stats.FetchedBytes += end - missingStart
+		missingStart = -1
+		return nil
+	}
+
+	for _, ref := range refs {
+		if ref.BlobOffset < cursor || ref.BlobOffset+ref.Size > layer.Size {
+			return fmt.Errorf("chunk manifest does not match layer: chunk %s at %d", ref.Hash, ref.BlobOffset)
+		}
+		if ref.BlobOffset > cursor && missingStart < 0 {
+			missingStart = cursor
+		}
+
+		var data []byte
+		if d.erofsBuilder != nil {
+			data, _ = d.erofsBuilder.ReadChunk(ref.Hash)
+		}
+		if int64(len(data)) != ref.Size {
+			if missingStart < 0 {
+				missingStart = ref.BlobOffset
+			}
+		} else {
+			if err := flush(ref.BlobOffset); err != nil {
+				return err
+			}
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+			stats.ReusedChunks++
+			stats.ReusedBytes += ref.Size
+		}
+		cursor = ref.BlobOffset + ref.Size
+	}
+
+	if cursor < layer.Size && missingStart < 0 {
+		missingStart = cursor
+	}
+	return flush(layer.Size)
+}
+
+// materializeLayer 把 write 写出的层数据落盘并校验 digest,然后作为新层处理
+func (d *DedupStore) materializeLayer(ctx context.Context, layer ocispec.Descriptor, layerID, parent string, write func(io.Writer) error) error {
+	tmp, err := os.CreateTemp(d.root, "pull-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	verifier := layer.Digest.Verifier()
+	if err := write(io.MultiWriter(tmp, verifier)); err != nil {
+		return err
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("layer data does not match digest %s", layer.Digest)
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return d.ApplyLayer(ctx, layerID, tmp, parent)
+}
+
+// fetchProvider 把 remotes.Fetcher 适配为 content.Provider,只用于读取清单这类小 blob
+type fetchProvider struct {
+	fetcher remotes.Fetcher
+}
+
+func (p *fetchProvider) ReaderAt(ctx context.Context, desc ocispec.Descriptor) (content.ReaderAt, error) {
+	rc, err := p.fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	// 签名只覆盖 digest,内容必须与 digest 一致
+	if err := desc.Digest.Validate(); err != nil {
+		return nil, err
+	}
+	if desc.Digest.Algorithm().FromBytes(data) != desc.Digest {
+		return nil, fmt.Errorf("content of %s does not match its digest", desc.Digest)
+	}
+	return &bytesReaderAt{Reader: bytes.NewReader(data)}, nil
+}
+
+type bytesReaderAt struct {
+	*bytes.Reader
+}
+
+func (b *bytesReaderAt) Close() error {
+	return nil
+}
+
+// registryClient 直接访问仓库 API(范围读取 blob、查询 referrer),复用 resolver 的认证状态
+type registryClient struct {
+	host docker.RegistryHost
+	repo string
+}
+
+func newRegistryClient(hosts docker.RegistryHosts, name string) (*registryClient, error) {
+	spec, err := reference.Parse(name)
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := hosts(spec.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	for _, host := range candidates {
+		if host.Capabilities&docker.HostCapabilityPull != 0 {
+			return &registryClient{
+				host: host,
+				repo: strings.TrimPrefix(spec.Locator, spec.Hostname()+"/"),
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("no pull host configured for %s", spec.Hostname())
+}
+
+// get 发送 GET 请求,收到 401 时让 authorizer 处理认证质询后重试一次
+func (r *registryClient) get(ctx context.Context, path string, header http.Header) (*http.Response, error) {
+	endpoint := fmt.Sprintf("%s://%s%s/%s/%s", r.host.Scheme, r.host.Host, r.host.Path, r.repo, path)
+	client := r.host.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		if r.host.Authorizer != nil {
+			if err := r.host.Authorizer.Authorize(ctx, req); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("registry request failed: %w", err)
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 && r.host.Authorizer != nil {
+			err := r.host.Authorizer.AddResponses(ctx, []*http.Response{resp})
+			resp.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return resp, nil
+	}
+}
+
+// fetchRange 把 blob 中 [offset, offset+size) 写入 w
+func (r *registryClient) fetchRange(ctx context.Context, dgst digest.Digest, offset, size int64, w io.Writer) error {
+	resp, err := r.get(ctx, "blobs/"+dgst.String(), http.Header{
+		"Range": {fmt.Sprintf("bytes=%d-%d", offset, offset+size-1)},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("range request for %s returned %s", dgst, resp.Status)
+	}
+	n, err := io.Copy(w, io.LimitReader(resp.Body, size))
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("short range read for %s: %d of %d bytes", dgst, n, size)
+	}
+	return nil
+}
+
+// referrers 按 OCI distribution 的 referrers API 列出 subject 上指定类型的 artifact,
+// 仓库不支持该 API 时返回空列表
+func (r *registryClient) referrers(ctx context.Context, subject digest.Digest, artifactType string) ([]ocispec.Descriptor, error) {
+	resp, err := r.get(ctx, "referrers/"+subject.String()+"?artifactType="+url.QueryEscape(artifactType), http.Header{
+		"Accept": {ocispec.MediaTypeImageIndex},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("referrers request for %s returned %s", subject, resp.Status)
+	}
+
+	var index ocispec.Index
+	if err := json.NewDecoder(resp.Body).Decode(&index); err != nil {
+		return nil, fmt.Errorf("invalid referrers response: %w", err)
+	}
+	// 部分仓库忽略 artifactType 过滤参数,这里再筛一次
+	var descs []ocispec.Descriptor
+	for _, desc := range index.Manifests {
+		if desc.ArtifactType == artifactType {
+			descs = append(descs, desc)
+		}
+	}
+	return descs, nil
+}
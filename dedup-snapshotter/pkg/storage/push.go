@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/fscache"
+)
+
+// 推送计划中 chunk 区间的来源
+const (
+	PushSourceRegistry = "registry"
+	PushSourceLocal    = "local"
+	PushSourceMissing  = "missing"
+)
+
+// PushRange 是未压缩层流(DiffID)中连续的一段,Offset/Size 按流内偏移计算。
+// registry 来源的区间附带已有该数据的层 blob,推送工具可以据此跨仓库挂载或跳过
+type PushRange struct {
+	Offset      int64  `json:"offset"`
+	Size        int64  `json:"size"`
+	Source      string `json:"source"`
+	LayerDigest string `json:"layer_digest,omitempty"`
+	Chunks      int    `json:"chunks"`
+}
+
+// PushPlan 描述本地构建的层有多少数据已存在于镜像仓库或共享存储中
+type PushPlan struct {
+	Digest    string `json:"digest"`
+	DiffID    string `json:"diff_id"`
+	Size      int64  `json:"size"`
+	ChunkSize int64  `json:"chunk_size"`
+	// MountFrom 是已注册的、包含完全相同层 blob 的镜像,非空时可直接跨仓库挂载
+	MountFrom     []string    `json:"mount_from,omitempty"`
+	Ranges        []PushRange `json:"ranges"`
+	RegistryBytes int64       `json:"registry_bytes"`
+	LocalBytes    int64       `json:"local_bytes"`
+	MissingBytes  int64       `json:"missing_bytes"`
+}
+
+// PlanPush 按与拉取相同的方式切分层 tar,逐个 chunk 查询镜像仓库清单和本地 chunk 存储,
+// 相邻且来源相同的 chunk 合并为一个区间。layerPath 必须位于 conversion.source_dirs 之下
+func (d *DedupStore) PlanPush(ctx context.Context, layerPath string) (*PushPlan, error) {
+	layerPath, err := d.checkSource(layerPath)
+	if err != nil {
+		return nil, err
+	}
+	digest, size, err := fileDigest(layerPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read layer: %w", err)
+	}
+
+	tmp, err := os.MkdirTemp(d.root, "push-plan-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	manifestPath := filepath.Join(tmp, "layer.manifest")
+	if err := d.layerProcessor.generateLayerManifest(digest, digest, layerPath, manifestPath); err != nil {
+		return nil, err
+	}
+	manifest, err := fscache.LoadLayerManifest(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &PushPlan{
+		Digest:    manifest.Digest,
+		DiffID:    manifest.DiffID,
+		Size:      size,
+		ChunkSize: manifest.ChunkSize,
+		Ranges:    []PushRange{},
+	}
+	if d.dedupDaemon != nil {
+		plan.MountFrom = d.dedupDaemon.ImagesWithLayer(manifest.Digest)
+	}
+
+	for _, file := range manifest.Files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for _, chunk := range file.Chunks {
+			source, layerDigest := d.locateChunk(chunk.Hash)
+			plan.addRange(PushRange{
+				Offset:      chunk.BlobOffset,
+				Size:        chunk.Size,
+				Source:      source,
+				LayerDigest: layerDigest,
+				Chunks:      1,
+			})
+		}
+	}
+
+	return plan, nil
+}
+
+func (d *DedupStore) locateChunk(hash string) (string, string) {
+	if d.dedupDaemon != nil {
+		if loc, ok := d.dedupDaemon.LocateChunk(hash); ok && loc.LayerDigest != "" {
+			return PushSourceRegistry, loc.LayerDigest
+		}
+	}
+	if d.erofsBuilder != nil && d.erofsBuilder.HasChunk(hash) {
+		return PushSourceLocal, ""
+	}
+	return PushSourceMissing, ""
+}
+
+func (p *PushPlan) addRange(r PushRange) {
+	switch r.Source {
+	case PushSourceRegistry:
+		p.RegistryBytes += r.Size
+	case PushSourceLocal:
+		p.LocalBytes += r.Size
+	default:
+		p.MissingBytes += r.Size
+	}
+
+	if n := len(p.Ranges); n > 0 {
+		last := &p.Ranges[n-1]
+		if last.Source == r.Source && last.LayerDigest == r.LayerDigest && last.Offset+last.Size == r.Offset {
+			last.Size += r.Size
+			last.Chunks++
+			return
+		}
+	}
+	p.Ranges = append(p.Ranges, r)
+}
+
+func fileDigest(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
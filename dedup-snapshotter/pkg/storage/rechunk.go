@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+	"github.com/opencloudos/dedup-snapshotter/pkg/erofs"
+	"github.com/opencloudos/dedup-snapshotter/pkg/layout"
+)
+
+// StoreFingerprint 返回本程序在配置 cfg 下的 chunk 切分参数
+func StoreFingerprint(cfg *config.Config) *layout.Fingerprint {
+	return &layout.Fingerprint{
+		ChunkSize:   erofs.ChunkSize,
+		Hash:        erofs.ChunkHash,
+		Compression: erofs.Compression,
+		SmallChunks: cfg.EnableSmallChunks,
+		CDC: layout.CDCParams{
+			Min:      erofs.SmallChunkMin,
+			Avg:      erofs.SmallChunkAvg,
+			Max:      erofs.SmallChunkMax,
+			Mask:     fmt.Sprintf("%#x", erofs.CDCMask),
+			GearSeed: fmt.Sprintf("%#x", erofs.GearSeed),
+		},
+	}
+}
+
+// RechunkReport 是一次重新切分的结果
+type RechunkReport struct {
+	// From 是迁移前的指纹,旧存储没有指纹时为空
+	From *layout.Fingerprint `json:"from,omitempty"`
+	To   *layout.Fingerprint `json:"to"`
+	// Unchanged 表示存储已与本程序兼容,没有重建任何镜像
+	Unchanged bool     `json:"unchanged,omitempty"`
+	Rebuilt   []string `json:"rebuilt,omitempty"`
+	// Flattened 是删除的扁平化镜像,下次使用时按当前参数重新生成
+	Flattened    []string          `json:"flattened,omitempty"`
+	Failed       map[string]string `json:"failed,omitempty"`
+	PrunedChunks int               `json:"pruned_chunks"`
+	PrunedBytes  int64             `json:"pruned_bytes"`
+}
+
+func (r *RechunkReport) String() string {
+	if r.Unchanged {
+		return "chunk store already matches this binary's chunking parameters"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "rebuilt %d images, dropped %d flattened images, pruned %d chunks (%d bytes)\n",
+		len(r.Rebuilt), len(r.Flattened), r.PrunedChunks, r.PrunedBytes)
+	for id, err := range r.Failed {
+		fmt.Fprintf(&b, "  failed: %s: %s\n", id, err)
+	}
+	if len(r.Failed) > 0 {
+		b.WriteString("chunks not pruned and fingerprint not updated, run again after fixing the failures")
+	} else {
+		b.WriteString("fingerprint updated")
+	}
+	return b.String()
+}
+
+// Rechunk 在快照服务停止时把 root 迁移到本程序的切分参数:挂载每个 EROFS 镜像,
+// 按新参数重新切分并重建,删除扁平化镜像,全部成功后删除不再被引用的 chunk 并更新指纹
+func Rechunk(ctx context.Context, root string, source *config.Source) (*RechunkReport, error) {
+	store, err := newDedupStore(root, true, false, source, true)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+	return store.rechunk(ctx)
+}
+
+func (d *DedupStore) rechunk(ctx context.Context) (*RechunkReport, error) {
+	if err := d.checkWritable(); err != nil {
+		return nil, err
+	}
+	if d.erofsBuilder == nil || d.mountManager == nil {
+		return nil, fmt.Errorf("erofs not enabled")
+	}
+	report := &RechunkReport{To: StoreFingerprint(d.cfg())}
+	disk, err := layout.ReadFingerprint(d.root)
+	if err != nil {
+		return nil, err
+	}
+	report.From = disk
+	if disk == nil || len(report.To.Mismatches(disk)) == 0 {
+		report.Unchanged = true
+		return report, layout.EnsureFingerprint(d.root, report.To)
+	}
+
+	entries, err := os.ReadDir(d.imagesDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, erofs.ErofsImageExt) {
+			continue
+		}
+		key := strings.TrimSuffix(name, erofs.ErofsImageExt)
+		if strings.HasSuffix(key, erofs.FlattenedSuffix) {
+			if err := os.Remove(filepath.Join(d.imagesDir, name)); err != nil {
+				return nil, err
+			}
+			report.Flattened = append(report.Flattened, key)
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := d.rechunkImage(ctx, key); err != nil {
+			log.G(ctx).WithError(err).Errorf("failed to rechunk image %s", key)
+			if report.Failed == nil {
+				report.Failed = make(map[string]string)
+			}
+			report.Failed[key] = err.Error()
+			continue
+		}
+		report.Rebuilt = append(report.Rebuilt, key)
+	}
+
+	// 失败的镜像可能已释放 chunk 引用而未重建,此时清理会删掉它仍在使用的 chunk
+	if len(report.Failed) > 0 {
+		return report, nil
+	}
+	report.PrunedChunks, report.PrunedBytes, err = d.erofsBuilder.PruneChunks()
+	if err != nil {
+		return report, fmt.Errorf("failed to prune unreferenced chunks: %w", err)
+	}
+	if err := layout.WriteFingerprint(d.root, report.To); err != nil {
+		return report, fmt.Errorf("failed to write %s: %w", layout.FingerprintFile, err)
+	}
+	return report, nil
+}
+
+// rechunkImage 挂载镜像 key 作为内容来源重建该镜像,沿用映射到它的快照记录的访问顺序
+func (d *DedupStore) rechunkImage(ctx context.Context, key string) error {
+	var order []string
+	ids, err := d.indexDB.ImageKeySnapshots(key)
+	if err != nil {
+		return err
+	}
+	for _, id := range append([]string{key}, ids...) {
+		if order = d.accessOrder(id); len(order) > 0 {
+			break
+		}
+	}
+
+	mountPath, err := d.mountManager.MountErofs(ctx, key, filepath.Join(d.imagesDir, key+erofs.ErofsImageExt))
+	if err != nil {
+		return fmt.Errorf("failed to mount %s for rechunk: %w", key, err)
+	}
+	defer func() {
+		if err := d.mountManager.Unmount(key); err != nil {
+			log.G(ctx).WithError(err).Warnf("failed to unmount %s after rechunk", key)
+		}
+	}()
+
+	imagePath, err := d.erofsBuilder.Rechunk(ctx, mountPath, key, order)
+	if err != nil {
+		return err
+	}
+	d.signArtifact(imagePath)
+	log.G(ctx).Infof("rechunked erofs image %s", key)
+	return nil
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+	"github.com/opencloudos/dedup-snapshotter/pkg/erofs"
+	"github.com/opencloudos/dedup-snapshotter/pkg/layout"
+)
+
+// TestRechunkKeepsChunksOfFailedImages 验证有镜像重建失败时不清理 chunk:
+// 失败镜像的引用可能已释放,它的 chunk 在索引中没有引用但仍被镜像使用
+func TestRechunkKeepsChunksOfFailedImages(t *testing.T) {
+	root := t.TempDir()
+	source := config.NewSource(config.DefaultConfig(root))
+
+	// 旧参数写入的存储
+	old := StoreFingerprint(source.Get())
+	old.ChunkSize = 1 << 20
+	if err := layout.WriteFingerprint(root, old); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := newDedupStore(root, true, false, source, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 超级块完整但无法挂载的镜像,以及它使用的、索引中已没有引用的 chunk
+	img := make([]byte, 4096)
+	binary.LittleEndian.PutUint32(img[1024:], 0xE0F5E1E2)
+	img[1024+12] = 12
+	binary.LittleEndian.PutUint32(img[1024+36:], 1)
+	if err := os.WriteFile(filepath.Join(store.imagesDir, "broken"+erofs.ErofsImageExt), img, 0644); err != nil {
+		t.Fatal(err)
+	}
+	chunk := filepath.Join(root, "erofs-chunks", "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+	if err := os.WriteFile(chunk, []byte("chunk data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	report, err := Rechunk(context.Background(), root, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := report.Failed["broken"]; !ok {
+		t.Fatalf("expected broken image to fail, got %+v", report)
+	}
+	if report.PrunedChunks != 0 {
+		t.Fatalf("expected no chunks pruned, got %d", report.PrunedChunks)
+	}
+	if _, err := os.Stat(chunk); err != nil {
+		t.Fatalf("chunk of failed image was removed: %v", err)
+	}
+	if disk, _ := layout.ReadFingerprint(root); disk.ChunkSize != old.ChunkSize {
+		t.Fatal("fingerprint must not be updated after a failed rechunk")
+	}
+	t.Logf("✓ 有镜像失败时保留 chunk 且不更新指纹")
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/erofs"
+	"github.com/opencloudos/dedup-snapshotter/pkg/fscache"
+)
+
+// accessOrderPath 返回镜像访问顺序文件的路径,与预取 trace 放在同一目录
+func (d *DedupStore) accessOrderPath(imageID string) string {
+	return filepath.Join(d.traceDir(), imageID+erofs.AccessOrderExt)
+}
+
+// traceDir 返回当前配置的 trace 目录,未配置时为空
+func (d *DedupStore) traceDir() string {
+	if cfg := d.cfg(); cfg != nil {
+		return cfg.Prefetch.TraceDir
+	}
+	return ""
+}
+
+// TracePath 返回 trace 目录中 ID 为 traceID 的 trace 文件路径。ID 是 trace 目录下的文件名,
+// 不能包含路径分隔符或以 "." 开头,API 只能读取该目录中的 trace
+func (d *DedupStore) TracePath(traceID string) (string, error) {
+	if d.traceDir() == "" {
+		return "", fmt.Errorf("prefetch.trace_dir not configured")
+	}
+	if err := erofs.ValidateImageID(traceID); err != nil {
+		return "", fmt.Errorf("invalid trace id %q", traceID)
+	}
+	return filepath.Join(d.traceDir(), traceID), nil
+}
+
+// accessOrder 返回镜像记录的文件访问顺序,没有记录时返回 nil,构建按路径排列
+func (d *DedupStore) accessOrder(imageID string) []string {
+	if d.traceDir() == "" {
+		return nil
+	}
+	order, err := erofs.LoadAccessOrder(d.accessOrderPath(imageID))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.L.WithError(err).Warnf("ignoring access order of %s", imageID)
+		}
+		return nil
+	}
+	return order
+}
+
+// SaveAccessOrder 记录镜像的文件访问顺序,之后的构建和重排都按此顺序排列数据
+func (d *DedupStore) SaveAccessOrder(imageID string, order []string) error {
+	if d.traceDir() == "" {
+		return fmt.Errorf("prefetch.trace_dir not configured")
+	}
+	if err := os.MkdirAll(d.traceDir(), 0755); err != nil {
+		return err
+	}
+
+	path := d.accessOrderPath(imageID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(order, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// MergeTraces 合并镜像多次运行的 trace,traceIDs 为 trace 目录中的 trace ID。
+// 生成的预取计划写入 trace 目录下的 <镜像>.plan,其 ID 可作为预取的 trace
+func (d *DedupStore) MergeTraces(imageID string, traceIDs []string, minFrequency float64) (*fscache.PrefetchPlan, error) {
+	if err := erofs.ValidateImageID(imageID); err != nil {
+		return nil, err
+	}
+	traceFiles := make([]string, 0, len(traceIDs))
+	for _, id := range traceIDs {
+		path, err := d.TracePath(id)
+		if err != nil {
+			return nil, err
+		}
+		traceFiles = append(traceFiles, path)
+	}
+	runs, err := fscache.LoadTraces(traceFiles)
+	if err != nil {
+		return nil, err
+	}
+	plan := fscache.MergeTraces(runs, minFrequency)
+	if len(plan.Chunks) == 0 {
+		return nil, fmt.Errorf("no chunks left after merging %d traces", len(runs))
+	}
+	if err := os.MkdirAll(d.traceDir(), 0755); err != nil {
+		return nil, err
+	}
+	if err := plan.WriteTrace(filepath.Join(d.traceDir(), imageID+".plan")); err != nil {
+		return nil, fmt.Errorf("failed to write prefetch plan: %w", err)
+	}
+	plan.Trace = imageID + ".plan"
+	log.L.Infof("merged %d traces of %s into a prefetch plan of %d chunks (%d dropped)", len(runs), imageID, len(plan.Chunks), plan.Dropped)
+	return plan, nil
+}
+
+// RelayoutImage 按记录的访问顺序重建镜像。快照目录仍在时从中读取内容,否则临时挂载现有镜像
+func (d *DedupStore) RelayoutImage(ctx context.Context, imageID string) error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	if !d.useErofs || d.erofsBuilder == nil || d.mountManager == nil {
+		return fmt.Errorf("erofs not enabled")
+	}
+
+	order := d.accessOrder(imageID)
+	if len(order) == 0 {
+		return fmt.Errorf("no access order recorded for %s", imageID)
+	}
+
+	key := d.imageKey(imageID)
+	source := filepath.Join(d.snapsDir, imageID, "fs")
+	if entries, err := os.ReadDir(source); err != nil || len(entries) == 0 {
+		mountPath, err := d.mountManager.MountErofs(ctx, key, d.imagePath(imageID))
+		if err != nil {
+			return fmt.Errorf("failed to mount %s for relayout: %w", imageID, err)
+		}
+		defer func() {
+			if err := d.mountManager.Unmount(key); err != nil {
+				log.G(ctx).WithError(err).Warnf("failed to unmount %s after relayout", key)
+			}
+		}()
+		source = mountPath
+	}
+
+	imagePath, err := d.erofsBuilder.Relayout(ctx, source, key, order)
+	if err != nil {
+		return err
+	}
+	d.signArtifact(imagePath)
+	return nil
+}
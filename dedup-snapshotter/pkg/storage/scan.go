@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+	"github.com/opencloudos/dedup-snapshotter/pkg/scan"
+)
+
+// ErrLayerBlocked 表示层的漏洞扫描结论为阻止,不能挂载给容器
+var ErrLayerBlocked = errors.New("layer blocked by vulnerability scan")
+
+// scanVerdictExt 是层扫描结论文件的扩展名,与 EROFS 镜像放在同一目录
+const scanVerdictExt = ".scan.json"
+
+// setupScanner 按配置创建漏洞扫描器,未配置扫描器时不扫描
+func (d *DedupStore) setupScanner(cfg config.ScanConfig) {
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	switch cfg.Scanner {
+	case config.ScannerTrivy:
+		d.scanner = scan.NewTrivyScanner(cfg.TrivyBinary, cfg.TrivyServer, timeout)
+	case config.ScannerExec:
+		d.scanner = scan.NewExecScanner(cfg.Command, timeout)
+	default:
+		return
+	}
+	onError, _ := scan.ParseOutcome(cfg.OnError)
+	d.scanPolicy = scan.Policy{Warn: cfg.WarnSeverities, Block: cfg.BlockSeverities, OnError: onError}
+	log.L.Infof("scanning converted layers with %s scanner", cfg.Scanner)
+}
+
+func (d *DedupStore) scanVerdictPath(id string) string {
+	return filepath.Join(d.imagesDir, id+scanVerdictExt)
+}
+
+// ScanLayer 扫描转换后的层目录并保存结论,未配置扫描器时返回 nil
+func (d *DedupStore) ScanLayer(ctx context.Context, id, dir string) *scan.Verdict {
+	if d.scanner == nil {
+		return nil
+	}
+
+	report, err := d.scanner.Scan(ctx, dir)
+	verdict := d.scanPolicy.Evaluate(id, report, err)
+	if err != nil {
+		log.G(ctx).WithError(err).Warnf("scan of layer %s failed, treating as %s", id, verdict.Outcome)
+	}
+
+	data, err := json.Marshal(verdict)
+	if err == nil {
+		err = os.WriteFile(d.scanVerdictPath(id), data, 0644)
+	}
+	if err != nil {
+		log.G(ctx).WithError(err).Warnf("failed to save scan verdict of layer %s", id)
+	}
+
+	switch verdict.Outcome {
+	case scan.Block:
+		log.G(ctx).Warnf("layer %s blocked by vulnerability scan: %v", id, verdict.Severities)
+	case scan.Warn:
+		log.G(ctx).Warnf("layer %s has vulnerabilities: %v", id, verdict.Severities)
+	}
+	return verdict
+}
+
+// ScanVerdict 返回层保存的扫描结论,未扫描时返回 nil
+func (d *DedupStore) ScanVerdict(id string) *scan.Verdict {
+	data, err := os.ReadFile(d.scanVerdictPath(id))
+	if err != nil {
+		return nil
+	}
+	var verdict scan.Verdict
+	if err := json.Unmarshal(data, &verdict); err != nil {
+		log.L.WithError(err).Warnf("ignoring corrupt scan verdict of layer %s", id)
+		return nil
+	}
+	return &verdict
+}
+
+// CheckScanVerdicts 在挂载时检查各层的扫描结论,任一层被阻止时返回 ErrLayerBlocked
+func (d *DedupStore) CheckScanVerdicts(ids []string) error {
+	for _, id := range ids {
+		if v := d.ScanVerdict(id); v != nil && v.Outcome == scan.Block {
+			return fmt.Errorf("layer %s: %w (%v)", id, ErrLayerBlocked, v.Severities)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/erofs"
+	"github.com/opencloudos/dedup-snapshotter/pkg/signing"
+	"golang.org/x/sys/unix"
+)
+
+// ErrScratchFull 表示临时空间所在文件系统的可用空间低于下限,转换被中止
+var ErrScratchFull = errors.New("scratch space low")
+
+const (
+	// scratchExpansion 是层解压和 EROFS 暂存相对压缩层大小的预估放大倍数
+	scratchExpansion = 4
+	// scratchCheckInterval 是写入临时空间时检查可用空间的间隔字节数
+	scratchCheckInterval = 64 << 20
+)
+
+// scratchSpace 管理层解压使用的临时空间:按预估大小预留字节预算,
+// 预算用尽时后续解压等待,文件系统可用空间低于 minFree 时直接失败
+type scratchSpace struct {
+	dir      string
+	maxBytes int64
+	minFree  int64
+
+	mu       sync.Mutex
+	reserved int64
+	// released 在每次释放预算时关闭并替换,用于唤醒等待者
+	released chan struct{}
+}
+
+func newScratchSpace(dir string, maxBytes, minFree int64) *scratchSpace {
+	return &scratchSpace{
+		dir:      dir,
+		maxBytes: maxBytes,
+		minFree:  minFree,
+		released: make(chan struct{}),
+	}
+}
+
+// reserve 预留 n 字节预算,超出上限时等待其他解压释放,返回的函数释放预留。
+// 没有其他解压进行时,超出整个预算的单个层也会放行,避免永远等待
+func (s *scratchSpace) reserve(ctx context.Context, n int64) (func(), error) {
+	for {
+		s.mu.Lock()
+		if s.maxBytes <= 0 || s.reserved == 0 || s.reserved+n <= s.maxBytes {
+			s.reserved += n
+			s.mu.Unlock()
+			break
+		}
+		wait := s.released
+		s.mu.Unlock()
+
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	if err := s.checkFree(n); err != nil {
+		s.release(n)
+		return nil, err
+	}
+
+	var once sync.Once
+	return func() { once.Do(func() { s.release(n) }) }, nil
+}
+
+func (s *scratchSpace) release(n int64) {
+	s.mu.Lock()
+	s.reserved -= n
+	close(s.released)
+	s.released = make(chan struct{})
+	s.mu.Unlock()
+}
+
+// reservedBytes 返回当前预留的字节数
+func (s *scratchSpace) reservedBytes() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reserved
+}
+
+// checkFree 检查写入 n 字节后文件系统的可用空间是否仍不低于 minFree
+func (s *scratchSpace) checkFree(n int64) error {
+	if s.minFree <= 0 {
+		return nil
+	}
+	var st unix.Statfs_t
+	if err := unix.Statfs(s.dir, &st); err != nil {
+		return fmt.Errorf("failed to stat scratch dir %s: %w", s.dir, err)
+	}
+	avail := int64(st.Bavail) * int64(st.Bsize)
+	if avail-n < s.minFree {
+		return fmt.Errorf("%w: %s has %d MB available, need %d MB plus %d MB reserve",
+			ErrScratchFull, s.dir, avail>>20, n>>20, s.minFree>>20)
+	}
+	return nil
+}
+
+// guard 包装 r,每读出 scratchCheckInterval 字节检查一次可用空间,
+// 使写入临时空间的解压在磁盘将满时尽早失败
+func (s *scratchSpace) guard(r io.Reader) io.Reader {
+	return &scratchGuard{r: r, s: s}
+}
+
+type scratchGuard struct {
+	r       io.Reader
+	s       *scratchSpace
+	pending int64
+}
+
+func (g *scratchGuard) Read(p []byte) (int, error) {
+	n, err := g.r.Read(p)
+	g.pending += int64(n)
+	if g.pending >= scratchCheckInterval {
+		g.pending = 0
+		if ferr := g.s.checkFree(0); ferr != nil {
+			return n, ferr
+		}
+	}
+	return n, err
+}
+
+// recoverBuilds 在启动时清理上次运行中断的转换:层解压和下载的临时文件、未完成的 EROFS 构建,
+// 以及不完整的镜像及其签名。中断的转换不会自动重试,再次拉取或提交转换时重新构建
+func (d *DedupStore) recoverBuilds() error {
+	for _, name := range []string{"extract", "temp"} {
+		if err := os.RemoveAll(filepath.Join(d.scratch.dir, name)); err != nil {
+			return fmt.Errorf("failed to clean scratch dir: %w", err)
+		}
+	}
+
+	report, err := d.erofsBuilder.RecoverBuilds()
+	if err != nil {
+		return fmt.Errorf("failed to recover interrupted builds: %w", err)
+	}
+	for _, key := range report.Invalid {
+		os.Remove(filepath.Join(d.imagesDir, key+erofs.ErofsImageExt+signing.SignatureExt))
+	}
+	if n := len(report.Interrupted) + len(report.Invalid); n > 0 {
+		log.L.Warnf("cleaned up %d interrupted builds and %d incomplete images", len(report.Interrupted), len(report.Invalid))
+	}
+	return nil
+}
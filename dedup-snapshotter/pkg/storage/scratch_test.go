@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestScratchSpaceBackpressure 验证预算用尽时后续预留等待释放,可用空间不足时直接失败
+func TestScratchSpaceBackpressure(t *testing.T) {
+	s := newScratchSpace(t.TempDir(), 100, 0)
+
+	release, err := s.reserve(context.Background(), 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := s.reserve(ctx, 40); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected reservation to wait, got %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		r, err := s.reserve(context.Background(), 40)
+		if err == nil {
+			r()
+		}
+		done <- err
+	}()
+	release()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if n := s.reservedBytes(); n != 0 {
+		t.Errorf("expected all reservations released, got %d", n)
+	}
+
+	full := newScratchSpace(t.TempDir(), 0, 1<<62)
+	if _, err := full.reserve(context.Background(), 1); !errors.Is(err, ErrScratchFull) {
+		t.Fatalf("expected ErrScratchFull, got %v", err)
+	}
+	t.Logf("✓ 临时空间预算用尽时等待,可用空间不足时返回 %v", ErrScratchFull)
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/erofs"
+)
+
+// LabelSELinuxContext 由客户端在 Prepare 时设置,指定容器 rootfs 的 SELinux 上下文,
+// 如 CRI 为 Pod 分配的 system_u:object_r:container_file_t:s0:c1,c2,随快照元数据保存。
+// 未设置时使用 selinux.mount_context
+const LabelSELinuxContext = "containerd.io/snapshot/dedup-selinux-context"
+
+// MountOptions 是快照标签指定的挂载参数
+type MountOptions struct {
+	// IDMap 非 nil 时为用户命名空间容器映射各层属主
+	IDMap *erofs.IDMap
+	// MountLabel 非空时作为 overlay 的 SELinux context= 选项
+	MountLabel string
+}
+
+// ParseMountOptions 解析快照标签中的用户命名空间映射和 SELinux 上下文
+func ParseMountOptions(labels map[string]string) (MountOptions, error) {
+	idmap, err := erofs.ParseIDMap(labels)
+	if err != nil {
+		return MountOptions{}, err
+	}
+	opts := MountOptions{IDMap: idmap, MountLabel: labels[LabelSELinuxContext]}
+	if opts.MountLabel != "" {
+		if err := erofs.ValidateSELinuxLabel(opts.MountLabel); err != nil {
+			return MountOptions{}, fmt.Errorf("invalid %s: %w", LabelSELinuxContext, err)
+		}
+	}
+	return opts, nil
+}
+
+// mountLabel 返回快照 overlay 挂载使用的 SELinux 上下文。主机未启用 SELinux 时内核不接受
+// context= 选项,返回空。AppArmor 按路径约束进程,挂载不需要额外处理
+func (d *DedupStore) mountLabel(ctx context.Context, id string, opts MountOptions) string {
+	label := opts.MountLabel
+	if label == "" {
+		label = d.cfg().SELinux.MountContext
+	}
+	if label == "" {
+		return ""
+	}
+	if !erofs.SELinuxEnabled() {
+		log.G(ctx).Debugf("snapshot %s: SELinux disabled, ignoring mount context %s", id, label)
+		return ""
+	}
+	return label
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+	"github.com/opencloudos/dedup-snapshotter/pkg/signing"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func (d *DedupStore) loadSigningKeys(cfg config.SigningConfig) error {
+	if cfg.Key != "" {
+		signer, err := signing.LoadSigner(cfg.Key)
+		if err != nil {
+			return fmt.Errorf("failed to load signing key: %w", err)
+		}
+		d.signer = signer
+		log.L.Infof("signing converted artifacts with %s", cfg.Key)
+	}
+	if len(cfg.TrustedKeys) > 0 {
+		verifier, err := signing.LoadVerifier(cfg.TrustedKeys)
+		if err != nil {
+			return fmt.Errorf("failed to load trusted keys: %w", err)
+		}
+		d.verifier = verifier
+		d.requireSignature = cfg.Require
+	}
+	return nil
+}
+
+// signArtifact 为本地产物写入 cosign sign-blob 格式的 .sig,未配置私钥时什么也不做。
+// 签名失败不影响产物本身,只是消费方无法校验
+func (d *DedupStore) signArtifact(path string) {
+	if d.signer == nil {
+		return
+	}
+	if err := d.signer.SignFile(path); err != nil {
+		log.L.WithError(err).Warnf("failed to sign %s", path)
+	}
+}
+
+// acceptPublished 按签名策略决定是否使用镜像仓库中发布的产物:
+// 没有受信任公钥时全部接受;签名无效时拒绝;没有签名时只在 signing.require 未开启时接受
+func (d *DedupStore) acceptPublished(ctx context.Context, registry *registryClient, provider *fetchProvider, dgst digest.Digest) bool {
+	if d.verifier == nil {
+		return true
+	}
+
+	err := d.verifyPublished(ctx, registry, provider, dgst)
+	switch {
+	case err == nil:
+		log.G(ctx).Debugf("verified signature of %s", dgst)
+		return true
+	case errors.Is(err, signing.ErrNoSignature) && !d.requireSignature:
+		log.G(ctx).Debugf("%s is not signed, accepting it", dgst)
+		return true
+	default:
+		log.G(ctx).WithError(err).Warnf("rejecting published artifact %s", dgst)
+		return false
+	}
+}
+
+// verifyPublished 查找 dgst 的 cosign 签名(referrer 或 sha256-<hex>.sig 标签),
+// 任一签名由受信任公钥签出且载荷指向 dgst 即通过
+func (d *DedupStore) verifyPublished(ctx context.Context, registry *registryClient, provider *fetchProvider, dgst digest.Digest) error {
+	var manifests []ocispec.Manifest
+
+	descs, err := registry.referrers(ctx, dgst, signing.ArtifactTypeSignature)
+	if err != nil {
+		log.G(ctx).WithError(err).Debugf("failed to list signature referrers of %s", dgst)
+	}
+	for _, desc := range descs {
+		data, err := content.ReadBlob(ctx, provider, desc)
+		if err != nil {
+			log.G(ctx).WithError(err).Debugf("failed to fetch signature %s", desc.Digest)
+			continue
+		}
+		var m ocispec.Manifest
+		if err := json.Unmarshal(data, &m); err == nil {
+			manifests = append(manifests, m)
+		}
+	}
+
+	if m, err := registry.manifest(ctx, signing.SignatureTag(dgst)); err != nil {
+		log.G(ctx).WithError(err).Debugf("failed to fetch signature tag of %s", dgst)
+	} else if m != nil {
+		manifests = append(manifests, *m)
+	}
+
+	lastErr := signing.ErrNoSignature
+	for _, m := range manifests {
+		for _, layer := range m.Layers {
+			sig := layer.Annotations[signing.AnnotationSignature]
+			if layer.MediaType != signing.MediaTypeSimpleSigning || sig == "" {
+				continue
+			}
+			payload, err := content.ReadBlob(ctx, provider, layer)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			if lastErr = d.verifier.VerifyPayload(payload, sig, dgst); lastErr == nil {
+				return nil
+			}
+		}
+	}
+	return lastErr
+}
+
+// manifest 按标签读取镜像清单,标签不存在时返回 nil
+func (r *registryClient) manifest(ctx context.Context, tag string) (*ocispec.Manifest, error) {
+	resp, err := r.get(ctx, "manifests/"+tag, http.Header{
+		"Accept": {ocispec.MediaTypeImageManifest, images.MediaTypeDockerSchema2Manifest},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("manifest request for %s returned %s", tag, resp.Status)
+	}
+
+	var m ocispec.Manifest
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %w", tag, err)
+	}
+	return &m, nil
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+	"github.com/opencloudos/dedup-snapshotter/pkg/fscache"
+	"github.com/opencloudos/dedup-snapshotter/pkg/metrics"
+)
+
+func (d *DedupStore) setupStartupTracer(cfg config.StartupTraceConfig) {
+	if !cfg.Enabled {
+		return
+	}
+	d.startupTracer = fscache.NewStartupTracerWithOptions(time.Duration(cfg.Window)*time.Second, cfg.History)
+	d.startupTracer.OnFinish(d.observeStartup)
+	if d.dedupDaemon != nil {
+		d.dedupDaemon.SetTracer(d.startupTracer)
+	}
+	log.L.Infof("container startup tracing enabled (window=%ds)", cfg.Window)
+}
+
+// BeginStartupTrace 在活动快照挂载后开始追踪冷启动,key 为快照键(CRI 下即容器 ID)。
+// 未开启 fscache 时只能测得冷启动总耗时
+func (d *DedupStore) BeginStartupTrace(key string, parents []string) {
+	if d.startupTracer == nil {
+		return
+	}
+	var coverage float64
+	if d.useFscache && d.dedupDaemon != nil {
+		coverage = d.dedupDaemon.CachedFraction(parents)
+	}
+	d.startupTracer.Begin(key, parents, coverage)
+}
+
+// MarkContainerStarted 在容器 ENTRYPOINT 启动后结束追踪
+func (d *DedupStore) MarkContainerStarted(key string) (fscache.StartupTrace, error) {
+	if d.startupTracer == nil {
+		return fscache.StartupTrace{}, fmt.Errorf("startup tracing not enabled")
+	}
+	trace, ok := d.startupTracer.MarkStarted(key)
+	if !ok {
+		return fscache.StartupTrace{}, fmt.Errorf("no active startup trace for %s", key)
+	}
+	return trace, nil
+}
+
+// StartupTraces 返回活动和已结束的冷启动追踪,未开启时返回 nil
+func (d *DedupStore) StartupTraces() []fscache.StartupTrace {
+	if d.startupTracer == nil {
+		return nil
+	}
+	return d.startupTracer.Traces()
+}
+
+// observeStartup 把结束的追踪按预取覆盖率分桶记入直方图,便于对比预取对冷启动的影响
+func (d *DedupStore) observeStartup(trace fscache.StartupTrace) {
+	log.L.Infof("startup trace %s: started=%v cold_start=%v first_read=%v faults=%d fetched=%d coverage=%.0f%%",
+		trace.Key, trace.Started, trace.ColdStart, trace.FirstRead, trace.Faults, trace.BytesFetched, trace.PrefetchCoverage*100)
+
+	if d.metrics == nil {
+		return
+	}
+	labels := metrics.Labels{"coverage": coverageBucket(trace.PrefetchCoverage)}
+	if trace.Started {
+		d.metrics.ObserveHistogram("container_cold_start_latency", labels, trace.ColdStart)
+	}
+	if trace.Faults > 0 {
+		d.metrics.ObserveHistogram("container_first_read_latency", labels, trace.FirstRead)
+	}
+}
+
+func coverageBucket(coverage float64) string {
+	switch {
+	case coverage >= 1:
+		return "100"
+	case coverage >= 0.75:
+		return "75-100"
+	case coverage >= 0.5:
+		return "50-75"
+	case coverage >= 0.25:
+		return "25-50"
+	default:
+		return "0-25"
+	}
+}
@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/erofs"
+)
+
+// LabelTraceProfile 由客户端在 Prepare 时设置,选择容器启动时预取所用的 trace 配置,
+// 如同一镜像的 web-startup 与 batch-startup。未设置时沿用父快照上的标签
+const LabelTraceProfile = "containerd.io/snapshot/dedup-trace-profile"
+
+// traceProfileExt 是 trace 配置中每个镜像 trace 文件的扩展名
+const traceProfileExt = ".trace"
+
+// ErrTraceProfileNotFound 表示 trace 配置或其中的镜像 trace 不存在
+var ErrTraceProfileNotFound = errors.New("trace profile not found")
+
+var traceProfileName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,63}$`)
+
+// TraceProfile 是一个 trace 配置及其包含 trace 的镜像
+type TraceProfile struct {
+	Name   string   `json:"name"`
+	Images []string `json:"images"`
+}
+
+// ValidateTraceProfileName 检查 trace 配置名
+func ValidateTraceProfileName(name string) error {
+	if !traceProfileName.MatchString(name) {
+		return fmt.Errorf("invalid trace profile name %q: must match %s", name, traceProfileName)
+	}
+	return nil
+}
+
+// ParseTraceProfile 返回快照标签选择的 trace 配置,未设置时为空
+func ParseTraceProfile(labels map[string]string) (string, error) {
+	v := labels[LabelTraceProfile]
+	if v == "" {
+		return "", nil
+	}
+	if err := ValidateTraceProfileName(v); err != nil {
+		return "", fmt.Errorf("invalid %s: %w", LabelTraceProfile, err)
+	}
+	return v, nil
+}
+
+// traceProfilesDir 返回 trace 配置的根目录,每个配置是其下的一个子目录
+func (d *DedupStore) traceProfilesDir() (string, error) {
+	dir := d.traceDir()
+	if dir == "" {
+		return "", fmt.Errorf("prefetch.trace_dir not configured")
+	}
+	return filepath.Join(dir, "profiles"), nil
+}
+
+// traceProfilePath 返回 trace 配置中镜像 trace 文件的路径
+func (d *DedupStore) traceProfilePath(profile, imageID string) (string, error) {
+	if err := ValidateTraceProfileName(profile); err != nil {
+		return "", err
+	}
+	if err := erofs.ValidateImageID(imageID); err != nil {
+		return "", err
+	}
+	dir, err := d.traceProfilesDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, profile, imageID+traceProfileExt), nil
+}
+
+// ListTraceProfiles 列出所有 trace 配置,按名称排序
+func (d *DedupStore) ListTraceProfiles() ([]TraceProfile, error) {
+	dir, err := d.traceProfilesDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []TraceProfile{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := []TraceProfile{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		profile := TraceProfile{Name: entry.Name(), Images: []string{}}
+		for _, f := range files {
+			if imageID, ok := strings.CutSuffix(f.Name(), traceProfileExt); ok && !f.IsDir() {
+				profile.Images = append(profile.Images, imageID)
+			}
+		}
+		sort.Strings(profile.Images)
+		profiles = append(profiles, profile)
+	}
+	return profiles, nil
+}
+
+// PutTraceProfile 保存 trace 配置中镜像的 trace,每行一个 chunk 哈希,已有时覆盖
+func (d *DedupStore) PutTraceProfile(profile, imageID string, chunks []string) error {
+	path, err := d.traceProfilePath(profile, imageID)
+	if err != nil {
+		return err
+	}
+	if len(chunks) == 0 {
+		return fmt.Errorf("trace of %s in profile %s has no chunks", imageID, profile)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(chunks, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	log.L.Infof("saved trace of %s in profile %s (%d chunks)", imageID, profile, len(chunks))
+	return nil
+}
+
+// DeleteTraceProfile 删除 trace 配置中镜像的 trace,imageID 为空时删除整个配置
+func (d *DedupStore) DeleteTraceProfile(profile, imageID string) error {
+	if imageID == "" {
+		if err := ValidateTraceProfileName(profile); err != nil {
+			return err
+		}
+		dir, err := d.traceProfilesDir()
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, profile)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return fmt.Errorf("%s: %w", profile, ErrTraceProfileNotFound)
+		}
+		return os.RemoveAll(path)
+	}
+
+	path, err := d.traceProfilePath(profile, imageID)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s/%s: %w", profile, imageID, ErrTraceProfileNotFound)
+		}
+		return err
+	}
+	return nil
+}
+
+// PrefetchTraceProfile 按 trace 配置预取快照的各父镜像,没有 trace 的镜像跳过,
+// 返回启动的预取任务数。预取失败只记录日志,不影响快照创建
+func (d *DedupStore) PrefetchTraceProfile(ctx context.Context, profile string, parentIDs []string) int {
+	if !d.useFscache || d.dedupDaemon == nil {
+		log.G(ctx).Debugf("ignoring trace profile %s: fscache not enabled", profile)
+		return 0
+	}
+
+	started := 0
+	for _, id := range parentIDs {
+		path, err := d.traceProfilePath(profile, id)
+		if err != nil {
+			log.G(ctx).WithError(err).Warnf("ignoring trace profile %s", profile)
+			return started
+		}
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if err := d.StartPrefetch(ctx, id, path); err != nil {
+			log.G(ctx).WithError(err).Debugf("prefetch of %s with profile %s not started", id, profile)
+			continue
+		}
+		started++
+	}
+	if started > 0 {
+		log.G(ctx).Infof("started %d prefetches with trace profile %s", started, profile)
+	}
+	return started
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+)
+
+// TestTraceProfiles 验证 trace 配置的保存、列出、删除和标签校验
+func TestTraceProfiles(t *testing.T) {
+	cfg := config.DefaultConfig(t.TempDir())
+	cfg.Prefetch.TraceDir = t.TempDir()
+	d := &DedupStore{configs: config.NewSource(cfg)}
+
+	if err := d.PutTraceProfile("web-startup", "12", []string{"aa", "bb"}); err != nil {
+		t.Fatalf("PutTraceProfile: %v", err)
+	}
+	if err := d.PutTraceProfile("batch-startup", "12", []string{"cc"}); err != nil {
+		t.Fatalf("PutTraceProfile: %v", err)
+	}
+	if err := d.PutTraceProfile("../escape", "12", []string{"aa"}); err == nil {
+		t.Error("expected invalid profile name to be rejected")
+	}
+	for _, id := range []string{"../12", "", "-12", "12 34", "12\n"} {
+		if err := d.PutTraceProfile("web-startup", id, []string{"aa"}); err == nil {
+			t.Errorf("expected invalid image id %q to be rejected", id)
+		}
+	}
+
+	profiles, err := d.ListTraceProfiles()
+	if err != nil {
+		t.Fatalf("ListTraceProfiles: %v", err)
+	}
+	if len(profiles) != 2 || profiles[0].Name != "batch-startup" || len(profiles[1].Images) != 1 {
+		t.Fatalf("unexpected profiles: %+v", profiles)
+	}
+
+	if err := d.DeleteTraceProfile("web-startup", "12"); err != nil {
+		t.Fatalf("DeleteTraceProfile: %v", err)
+	}
+	if err := d.DeleteTraceProfile("web-startup", "12"); !errors.Is(err, ErrTraceProfileNotFound) {
+		t.Errorf("expected ErrTraceProfileNotFound, got %v", err)
+	}
+	if err := d.DeleteTraceProfile("batch-startup", ""); err != nil {
+		t.Fatalf("DeleteTraceProfile: %v", err)
+	}
+	if profiles, _ := d.ListTraceProfiles(); len(profiles) != 1 || len(profiles[0].Images) != 0 {
+		t.Errorf("unexpected profiles after delete: %+v", profiles)
+	}
+
+	if _, err := ParseTraceProfile(map[string]string{LabelTraceProfile: "a/b"}); err == nil {
+		t.Error("expected invalid label to be rejected")
+	}
+	if p, err := ParseTraceProfile(map[string]string{LabelTraceProfile: "web-startup"}); err != nil || p != "web-startup" {
+		t.Errorf("ParseTraceProfile: %q %v", p, err)
+	}
+	t.Logf("✓ trace 配置按名称保存在 trace 目录下")
+}
+
+// TestMergeTracesByID 验证合并 trace 只读取 trace 目录中的 trace ID,拒绝路径
+func TestMergeTracesByID(t *testing.T) {
+	cfg := config.DefaultConfig(t.TempDir())
+	cfg.Prefetch.TraceDir = t.TempDir()
+	d := &DedupStore{configs: config.NewSource(cfg)}
+
+	outside := filepath.Join(t.TempDir(), "secret")
+	for path, data := range map[string]string{
+		filepath.Join(cfg.Prefetch.TraceDir, "run-1"): "aa\nbb\n",
+		filepath.Join(cfg.Prefetch.TraceDir, "run-2"): "aa\n",
+		outside: "cc\n",
+	} {
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, id := range []string{outside, "../secret", ".hidden", ""} {
+		if _, err := d.MergeTraces("app", []string{"run-1", id}, 0); err == nil {
+			t.Errorf("expected trace id %q to be rejected", id)
+		}
+	}
+	if _, err := d.MergeTraces("../app", []string{"run-1"}, 0); err == nil {
+		t.Error("expected invalid image id to be rejected")
+	}
+
+	plan, err := d.MergeTraces("app", []string{"run-1", "run-2"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Runs != 2 || plan.Trace != "app.plan" {
+		t.Fatalf("unexpected plan: %+v", plan)
+	}
+	if path, err := d.TracePath(plan.Trace); err != nil || path != plan.Path {
+		t.Errorf("plan trace %q resolves to %q (%v), expected %q", plan.Trace, path, err, plan.Path)
+	}
+	t.Logf("✓ trace 按 ID 从 trace 目录读取,合并结果可按 ID 预取")
+}
@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/containerd/log"
+	"github.com/opencloudos/dedup-snapshotter/pkg/fscache"
+)
+
+// UpgradeLayer 是目标镜像的一个层在升级计划中的情况。Shared 表示源镜像中有完全相同的层,
+// Cached 表示该层已在本地物化;Planned 表示有未压缩层的 chunk 清单,差异按 chunk 计算,
+// 否则未共享的层按整层大小计入 DeltaBytes
+type UpgradeLayer struct {
+	Digest     string `json:"digest"`
+	Size       int64  `json:"size"`
+	Shared     bool   `json:"shared"`
+	Cached     bool   `json:"cached"`
+	Planned    bool   `json:"planned"`
+	Chunks     int    `json:"chunks"`
+	NewChunks  int    `json:"new_chunks"`
+	DeltaBytes int64  `json:"delta_bytes"`
+}
+
+// UpgradePlan 对比节点上运行的镜像 From 与将要调度的 To 的 chunk 清单。
+// Chunks/TotalBytes 为 To 中不重复的 chunk,NewChunks/DeltaBytes 为 From 中没有、需要下载的部分;
+// Pull 是执行预取时的实际下载情况,只计算差异时为空
+type UpgradePlan struct {
+	From       string         `json:"from"`
+	To         string         `json:"to"`
+	FromDigest string         `json:"from_digest"`
+	ToDigest   string         `json:"to_digest"`
+	Layers     []UpgradeLayer `json:"layers"`
+	Chunks     int            `json:"chunks"`
+	NewChunks  int            `json:"new_chunks"`
+	TotalBytes int64          `json:"total_bytes"`
+	DeltaBytes int64          `json:"delta_bytes"`
+	Pull       *PullResult    `json:"pull,omitempty"`
+}
+
+// PlanUpgrade 计算从 from 升级到 to 需要下载的 chunk,不下载任何层数据
+func (d *DedupStore) PlanUpgrade(ctx context.Context, from, to string) (*UpgradePlan, error) {
+	if d.erofsBuilder == nil {
+		return nil, fmt.Errorf("erofs not enabled")
+	}
+	src, err := d.resolveRemoteImage(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := d.resolveRemoteImage(ctx, to)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := d.compareImageChunks(src, dst, d.imageChunkManifests(ctx, src), d.imageChunkManifests(ctx, dst))
+	log.G(ctx).Infof("upgrade %s -> %s: %d of %d chunks are new, %d delta bytes",
+		plan.From, plan.To, plan.NewChunks, plan.Chunks, plan.DeltaBytes)
+	return plan, nil
+}
+
+// compareImageChunks 按层 digest 到 chunk 清单的映射计算 dst 相对 src 的差异
+func (d *DedupStore) compareImageChunks(src, dst *remoteImage, srcManifests, dstManifests map[string]*fscache.LayerManifest) *UpgradePlan {
+	srcLayers := make(map[string]bool)
+	srcChunks := make(map[string]bool)
+	for digest, manifest := range srcManifests {
+		srcLayers[digest] = true
+		if manifest == nil {
+			continue
+		}
+		for _, file := range manifest.Files {
+			for _, chunk := range file.Chunks {
+				srcChunks[chunk.Hash] = true
+			}
+		}
+	}
+
+	plan := &UpgradePlan{
+		From:       src.name,
+		To:         dst.name,
+		FromDigest: src.desc.Digest.String(),
+		ToDigest:   dst.desc.Digest.String(),
+		Layers:     []UpgradeLayer{},
+	}
+	seen := make(map[string]bool)
+	for _, layer := range dst.manifest.Layers {
+		manifest := dstManifests[layer.Digest.String()]
+		ul := UpgradeLayer{
+			Digest:  layer.Digest.String(),
+			Size:    layer.Size,
+			Shared:  srcLayers[layer.Digest.String()],
+			Cached:  d.HasLayer(layer.Digest.Encoded()),
+			Planned: manifest != nil && !manifest.Compressed,
+		}
+
+		if manifest != nil {
+			for _, file := range manifest.Files {
+				for _, chunk := range file.Chunks {
+					ul.Chunks++
+					if seen[chunk.Hash] {
+						continue
+					}
+					seen[chunk.Hash] = true
+					plan.Chunks++
+					plan.TotalBytes += chunk.Size
+					if ul.Shared || srcChunks[chunk.Hash] {
+						continue
+					}
+					ul.NewChunks++
+					if ul.Planned && !ul.Cached {
+						ul.DeltaBytes += chunk.Size
+					}
+				}
+			}
+		} else {
+			plan.TotalBytes += layer.Size
+		}
+		// 没有可用的未压缩清单时,只能整层下载
+		if !ul.Planned && !ul.Shared && !ul.Cached {
+			ul.DeltaBytes = layer.Size
+		}
+
+		plan.NewChunks += ul.NewChunks
+		plan.DeltaBytes += ul.DeltaBytes
+		plan.Layers = append(plan.Layers, ul)
+	}
+	return plan
+}
+
+// UpgradeImage 计算升级差异后预取目标镜像:本地已有的 chunk 直接复用,只按范围下载新增的 chunk,
+// 调度到节点时各层已物化。压缩层不能按范围读取,实际下载量可能高于 DeltaBytes
+func (d *DedupStore) UpgradeImage(ctx context.Context, from, to string) (*UpgradePlan, error) {
+	if err := d.checkWritable(); err != nil {
+		return nil, err
+	}
+	plan, err := d.PlanUpgrade(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	result, err := d.PullImage(ctx, to)
+	if err != nil {
+		return nil, err
+	}
+	plan.Pull = result
+	log.G(ctx).Infof("prefetched %s for upgrade from %s: fetched %d bytes, planned %d delta bytes",
+		plan.To, plan.From, result.FetchedBytes, plan.DeltaBytes)
+	return plan, nil
+}
+
+// imageChunkManifests 返回镜像每个层的 chunk 清单:优先使用本地记录的清单,
+// 其次是发布在镜像仓库中的清单,都没有时为 nil
+func (d *DedupStore) imageChunkManifests(ctx context.Context, img *remoteImage) map[string]*fscache.LayerManifest {
+	published := d.publishedChunkManifests(ctx, img.registry, img.provider, img.desc.Digest)
+
+	manifests := make(map[string]*fscache.LayerManifest, len(img.manifest.Layers))
+	for _, layer := range img.manifest.Layers {
+		manifest := d.localChunkManifest(layer.Digest.Encoded())
+		if manifest == nil {
+			manifest = published[layer.Digest.String()]
+		}
+		if manifest == nil {
+			manifest = d.layerChunkManifest(ctx, img.provider, img.registry, layer)
+		}
+		if manifest != nil {
+			if err := checkChunkManifest(ctx, layer, manifest); err != nil {
+				log.G(ctx).WithError(err).Warnf("ignoring chunk manifest of %s", layer.Digest)
+				manifest = nil
+			}
+		}
+		manifests[layer.Digest.String()] = manifest
+	}
+	return manifests
+}
+
+func (d *DedupStore) localChunkManifest(layerID string) *fscache.LayerManifest {
+	path := d.layerProcessor.generateManifestPath(layerID)
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+	manifest, err := fscache.LoadLayerManifest(path)
+	if err != nil {
+		log.L.WithError(err).Warnf("failed to load chunk manifest of %s", layerID)
+		return nil
+	}
+	return manifest
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/fscache"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// TestCompareImageChunks 验证升级差异只计入源镜像中没有的 chunk:共享层不计入,
+// 目标镜像内重复的 chunk 只计一次,没有清单的新层按整层大小计入
+func TestCompareImageChunks(t *testing.T) {
+	store, err := NewDedupStoreWithErofs(filepath.Join(t.TempDir(), "root"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	layer := func(name string, size int64) ocispec.Descriptor {
+		return ocispec.Descriptor{Digest: digest.FromString(name), Size: size}
+	}
+	chunks := func(l ocispec.Descriptor, hashes ...string) *fscache.LayerManifest {
+		m := &fscache.LayerManifest{Digest: l.Digest.String(), Files: []*fscache.FileEntry{{Path: "f"}}}
+		for _, h := range hashes {
+			m.Files[0].Chunks = append(m.Files[0].Chunks, &fscache.ChunkRef{Hash: h, Size: 100})
+		}
+		return m
+	}
+	image := func(name string, layers ...ocispec.Descriptor) *remoteImage {
+		return &remoteImage{name: name, desc: layer(name, 0), manifest: ocispec.Manifest{Layers: layers}}
+	}
+
+	base := layer("base", 1000)
+	appV1 := layer("app-v1", 500)
+	appV2 := layer("app-v2", 500)
+	extra := layer("extra", 300)
+	v1 := image("app:v1", base, appV1)
+	v2 := image("app:v2", base, appV2, extra)
+
+	plan := store.compareImageChunks(v1, v2,
+		map[string]*fscache.LayerManifest{
+			base.Digest.String():  nil,
+			appV1.Digest.String(): chunks(appV1, "a", "b", "c"),
+		},
+		map[string]*fscache.LayerManifest{
+			base.Digest.String():  chunks(base, "x", "y"),
+			appV2.Digest.String(): chunks(appV2, "a", "b", "d", "d"),
+			extra.Digest.String(): nil,
+		})
+
+	if !plan.Layers[0].Shared || plan.Layers[0].DeltaBytes != 0 || plan.Layers[0].NewChunks != 0 {
+		t.Errorf("expected shared base layer to need nothing: %+v", plan.Layers[0])
+	}
+	if l := plan.Layers[1]; !l.Planned || l.Chunks != 4 || l.NewChunks != 1 || l.DeltaBytes != 100 {
+		t.Errorf("expected only chunk d to be new in app-v2: %+v", l)
+	}
+	if l := plan.Layers[2]; l.Planned || l.DeltaBytes != 300 {
+		t.Errorf("expected layer without manifest to count whole: %+v", l)
+	}
+	if plan.Chunks != 5 || plan.NewChunks != 1 || plan.DeltaBytes != 400 || plan.TotalBytes != 800 {
+		t.Fatalf("unexpected totals: chunks=%d new=%d delta=%d total=%d", plan.Chunks, plan.NewChunks, plan.DeltaBytes, plan.TotalBytes)
+	}
+	t.Logf("✓ 升级只需下载 %d 字节(共 %d 字节)", plan.DeltaBytes, plan.TotalBytes)
+
+	// 压缩层不能按范围下载,有清单也按整层计入
+	compressed := chunks(appV2, "a", "d")
+	compressed.Compressed = true
+	plan = store.compareImageChunks(v1, image("app:v2", appV2), map[string]*fscache.LayerManifest{appV1.Digest.String(): chunks(appV1, "a")},
+		map[string]*fscache.LayerManifest{appV2.Digest.String(): compressed})
+	if plan.DeltaBytes != 500 || plan.NewChunks != 1 {
+		t.Errorf("expected compressed layer to be downloaded whole: %+v", plan.Layers[0])
+	}
+	t.Logf("✓ 压缩层按整层大小计入差异")
+}
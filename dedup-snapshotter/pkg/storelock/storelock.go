@@ -0,0 +1,192 @@
+// Package storelock 协调共享同一 root 的多个进程(快照服务、dedupd、只读工具)。
+//
+// 每个受保护的子系统 <name> 在 root 下有三个文件:
+//   - <name>.lock:所有者在整个生命周期持有 flock 排他锁,第二个所有者立即失败而不是与之并发写入
+//   - <name>.owner:所有者写入的 JSON(pid、角色、启动时间),供其他进程报告冲突
+//   - <name>.readers:只读附着方持有 flock 共享锁;所有者在替换数据文件(例如索引恢复)前
+//     需取得其排他锁,确保没有读者正在使用
+//
+// flock 在进程退出时由内核释放,崩溃不会留下需要手工清理的锁
+package storelock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// 受保护的子系统
+const (
+	// Store 保护 index.db、chunks 和 EROFS 镜像
+	Store = "store"
+	// Fscache 保护 cachefiles 缓存目录,同一时间只能有一个按需读取守护进程
+	Fscache = "fscache"
+)
+
+var (
+	// ErrBusy 表示子系统已被其他进程持有
+	ErrBusy = errors.New("already owned by another process")
+	// ErrReadersAttached 表示仍有只读附着方,所有者不能替换数据文件
+	ErrReadersAttached = errors.New("read-only readers attached")
+)
+
+// Holder 描述子系统当前的所有者
+type Holder struct {
+	PID   int       `json:"pid"`
+	Role  string    `json:"role"`
+	Since time.Time `json:"since"`
+}
+
+// BusyError 在子系统已被持有时返回,包含所有者信息
+type BusyError struct {
+	Name   string
+	Holder *Holder
+}
+
+func (e *BusyError) Error() string {
+	if e.Holder == nil {
+		return fmt.Sprintf("%s %v", e.Name, ErrBusy)
+	}
+	return fmt.Sprintf("%s %v (%s, pid %d, since %s)", e.Name, ErrBusy,
+		e.Holder.Role, e.Holder.PID, e.Holder.Since.Format(time.RFC3339))
+}
+
+func (e *BusyError) Unwrap() error {
+	return ErrBusy
+}
+
+type Lock struct {
+	root     string
+	name     string
+	readOnly bool
+	file     *os.File
+}
+
+// Acquire 以所有者身份持有子系统,已被其他进程持有时返回 *BusyError
+func Acquire(root, name, role string) (*Lock, error) {
+	f, err := openLockFile(root, name+".lock")
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		f.Close()
+		if err == unix.EWOULDBLOCK {
+			holder, _ := ReadHolder(root, name)
+			return nil, &BusyError{Name: name, Holder: holder}
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", name, err)
+	}
+
+	l := &Lock{root: root, name: name, file: f}
+	if err := l.writeHolder(role); err != nil {
+		l.Release()
+		return nil, err
+	}
+	return l, nil
+}
+
+// AttachReadOnly 以只读方式附着子系统,与所有者共存,但阻止所有者替换数据文件
+func AttachReadOnly(root, name string) (*Lock, error) {
+	f, err := openLockFile(root, name+".readers")
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_SH|unix.LOCK_NB); err != nil {
+		f.Close()
+		if err == unix.EWOULDBLOCK {
+			return nil, fmt.Errorf("%s is under maintenance by its owner, retry later", name)
+		}
+		return nil, fmt.Errorf("failed to attach to %s: %w", name, err)
+	}
+	return &Lock{root: root, name: name, readOnly: true, file: f}, nil
+}
+
+// ReadHolder 读取子系统当前所有者的信息,没有所有者记录时返回 nil
+func ReadHolder(root, name string) (*Holder, error) {
+	data, err := os.ReadFile(filepath.Join(root, name+".owner"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var h Holder
+	if err := json.Unmarshal(data, &h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+func (l *Lock) ReadOnly() bool {
+	return l.readOnly
+}
+
+// WithoutReaders 在没有只读附着方时执行 fn,期间新的附着方会被拒绝。
+// 最多等待 timeout,仍有附着方时返回 ErrReadersAttached
+func (l *Lock) WithoutReaders(timeout time.Duration, fn func() error) error {
+	if l.readOnly {
+		return fmt.Errorf("%s is attached read-only", l.name)
+	}
+
+	f, err := openLockFile(l.root, l.name+".readers")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if err != unix.EWOULDBLOCK {
+			return fmt.Errorf("failed to lock %s readers: %w", l.name, err)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s: %w", l.name, ErrReadersAttached)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	defer unix.Flock(int(f.Fd()), unix.LOCK_UN)
+
+	return fn()
+}
+
+// Release 释放锁,所有者同时删除自己的所有者记录
+func (l *Lock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	if !l.readOnly {
+		os.Remove(filepath.Join(l.root, l.name+".owner"))
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+func (l *Lock) writeHolder(role string) error {
+	data, err := json.Marshal(&Holder{PID: os.Getpid(), Role: role, Since: time.Now()})
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(l.root, l.name+".owner")
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func openLockFile(root, name string) (*os.File, error) {
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(filepath.Join(root, name), os.O_RDWR|os.O_CREATE, 0644)
+}
@@ -0,0 +1,60 @@
+package storelock
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestOwnerAndReaders 验证第二个所有者被拒绝,只读附着与所有者共存并阻止数据文件替换
+func TestOwnerAndReaders(t *testing.T) {
+	root := t.TempDir()
+
+	owner, err := Acquire(root, Store, "snapshotter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer owner.Release()
+
+	_, err = Acquire(root, Store, "dedupd")
+	var busy *BusyError
+	if !errors.As(err, &busy) || !errors.Is(err, ErrBusy) {
+		t.Fatalf("expected BusyError, got %v", err)
+	}
+	if busy.Holder == nil || busy.Holder.Role != "snapshotter" {
+		t.Fatalf("expected holder snapshotter, got %+v", busy.Holder)
+	}
+
+	reader, err := AttachReadOnly(root, Store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reader.ReadOnly() {
+		t.Fatal("expected read-only lock")
+	}
+
+	ran := false
+	err = owner.WithoutReaders(200*time.Millisecond, func() error {
+		ran = true
+		return nil
+	})
+	if !errors.Is(err, ErrReadersAttached) || ran {
+		t.Fatalf("expected ErrReadersAttached, got %v (ran=%v)", err, ran)
+	}
+
+	reader.Release()
+	if err := owner.WithoutReaders(time.Second, func() error {
+		ran = true
+		return nil
+	}); err != nil || !ran {
+		t.Fatalf("expected maintenance to run after readers detached, got %v", err)
+	}
+
+	owner.Release()
+	next, err := Acquire(root, Store, "dedupd")
+	if err != nil {
+		t.Fatalf("expected ownership to be free after release: %v", err)
+	}
+	next.Release()
+	t.Logf("✓ 所有者冲突报告 %s", busy.Error())
+}
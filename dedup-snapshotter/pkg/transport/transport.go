@@ -0,0 +1,117 @@
+// Package transport 为访问镜像仓库和镜像服务的 HTTP 请求附加 User-Agent 和可选的
+// traceparent 头,并按仓库记录请求数、失败数、读取字节数和响应延迟,
+// 让仓库运维方和链路追踪能够把负载归因到 dedup 节点
+package transport
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/metrics"
+)
+
+// TraceParentHeader 是 W3C Trace Context 的请求头
+const TraceParentHeader = "traceparent"
+
+// Transport 是带标识和计量的 http.RoundTripper,零值不可用,需通过 New 创建
+type Transport struct {
+	base         http.RoundTripper
+	userAgent    string
+	traceHeaders bool
+
+	mu      sync.RWMutex
+	metrics *metrics.Metrics
+}
+
+// New 包装 base,为空时使用 http.DefaultTransport
+func New(base http.RoundTripper, userAgent string, traceHeaders bool) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{
+		base:         base,
+		userAgent:    userAgent,
+		traceHeaders: traceHeaders,
+	}
+}
+
+// SetMetrics 设置记录仓库请求指标的 Metrics,可在创建后设置
+func (t *Transport) SetMetrics(m *metrics.Metrics) {
+	t.mu.Lock()
+	t.metrics = m
+	t.mu.Unlock()
+}
+
+// Client 返回使用该 Transport 的 http.Client
+func (t *Transport) Client(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: t, Timeout: timeout}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrip 不能修改调用方的请求
+	req = req.Clone(req.Context())
+	if t.userAgent != "" {
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	if t.traceHeaders && req.Header.Get(TraceParentHeader) == "" {
+		req.Header.Set(TraceParentHeader, newTraceParent())
+	}
+
+	t.mu.RLock()
+	m := t.metrics
+	t.mu.RUnlock()
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	if m == nil {
+		return resp, err
+	}
+
+	registry := req.URL.Host
+	failed := err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	m.ObserveRegistryRequest(registry, failed, time.Since(start))
+	if err == nil && resp.Body != nil {
+		resp.Body = &countingBody{ReadCloser: resp.Body, metrics: m, registry: registry}
+	}
+	return resp, err
+}
+
+// countingBody 在响应体读完或关闭时把读取的字节数计入指标
+type countingBody struct {
+	io.ReadCloser
+	metrics  *metrics.Metrics
+	registry string
+	n        int64
+	once     sync.Once
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if err == io.EOF {
+		b.flush()
+	}
+	return n, err
+}
+
+func (b *countingBody) Close() error {
+	b.flush()
+	return b.ReadCloser.Close()
+}
+
+func (b *countingBody) flush() {
+	b.once.Do(func() {
+		b.metrics.AddRegistryBytes(b.registry, b.n)
+	})
+}
+
+// newTraceParent 生成新的 traceparent:版本 00、随机 trace-id 和 parent-id、sampled 标志
+func newTraceParent() string {
+	var id [24]byte
+	rand.Read(id[:])
+	return "00-" + hex.EncodeToString(id[:16]) + "-" + hex.EncodeToString(id[16:]) + "-01"
+}
@@ -0,0 +1,66 @@
+package transport
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/metrics"
+)
+
+// TestTransportTagsAndMeters 验证请求带上 User-Agent 和 traceparent,并按仓库记录请求数、失败数和字节数
+func TestTransportTagsAndMeters(t *testing.T) {
+	var traceParents []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ua := r.Header.Get("User-Agent"); ua != "dedup-test/1.0" {
+			t.Errorf("unexpected User-Agent %q", ua)
+		}
+		traceParents = append(traceParents, r.Header.Get(TraceParentHeader))
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("0123456789"))
+	}))
+	defer ts.Close()
+
+	m := metrics.NewMetrics()
+	tr := New(nil, "dedup-test/1.0", true)
+	tr.SetMetrics(m)
+	client := tr.Client(0)
+
+	for _, path := range []string{"/blob", "/fail"} {
+		resp, err := client.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	for _, tp := range traceParents {
+		if parts := strings.Split(tp, "-"); len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+			t.Errorf("malformed traceparent %q", tp)
+		}
+	}
+	if len(traceParents) != 2 || traceParents[0] == traceParents[1] {
+		t.Errorf("expected distinct traceparents, got %v", traceParents)
+	}
+
+	u, _ := url.Parse(ts.URL)
+	snapshot := m.GetSnapshot()
+	if len(snapshot.Registries) != 1 {
+		t.Fatalf("expected one registry, got %+v", snapshot.Registries)
+	}
+	stats := snapshot.Registries[0]
+	if stats.Registry != u.Host || stats.Requests != 2 || stats.Errors != 1 || stats.Bytes != 10 {
+		t.Errorf("unexpected registry stats %+v", stats)
+	}
+	if len(snapshot.Histograms) != 1 || snapshot.Histograms[0].Count != 2 {
+		t.Errorf("expected registry latency histogram, got %+v", snapshot.Histograms)
+	}
+	t.Logf("✓ 仓库请求已标识,统计 %+v", stats)
+}
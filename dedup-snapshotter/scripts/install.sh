@@ -28,7 +28,7 @@ if [ ! -f "$CONFIG_DIR/config.json" ]; then
 {
   "root": "$DATA_DIR",
   "enable_erofs": true,
-  "enable_lazy": true,
+  "enable_fscache": true,
   "enable_mem_dedup": true,
   "registry": "",
   "chunk_size": 4194304,
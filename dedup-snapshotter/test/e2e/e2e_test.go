@@ -0,0 +1,98 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/client"
+)
+
+// TestPullAndRun 验证 ctr 经 gRPC 代理快照器拉取并运行 busybox 和 nginx:
+// 去重统计记录了拉取的数据,启用 fscache 时容器启动只按需读取镜像的一部分,
+// 重新拉取已有的镜像不再占用新的存储
+func TestPullAndRun(t *testing.T) {
+	h := newHarness(t)
+	ctx := context.Background()
+
+	busybox := envOr("E2E_BUSYBOX", "docker.io/library/busybox:1.36")
+	nginx := envOr("E2E_NGINX", "docker.io/library/nginx:1.25-alpine")
+
+	h.mustCtr("image", "pull", "--snapshotter", snapshotterName, busybox)
+	base, err := h.api.RecordBaseline(ctx, "e2e-busybox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base.Chunks == 0 || base.LogicalBytes == 0 || base.StoredBytes > base.LogicalBytes {
+		t.Fatalf("unexpected dedup stats after pulling busybox: %+v", base)
+	}
+	t.Logf("✓ 拉取 busybox 后存储中有 %d 个 chunk,%d 字节", base.Chunks, base.StoredBytes)
+
+	out := h.mustCtr("run", "--rm", "--snapshotter", snapshotterName, busybox, "e2e-busybox", "echo", "hello from dedup")
+	if !strings.Contains(out, "hello from dedup") {
+		t.Fatalf("unexpected busybox output: %q", out)
+	}
+	t.Logf("✓ busybox 容器在 dedup 快照上运行")
+
+	if h.fscache {
+		trace := h.startupTrace(ctx, "e2e-busybox")
+		if trace == nil {
+			t.Fatal("no startup trace recorded for e2e-busybox")
+		}
+		if read := trace.FaultBytes + trace.BytesFetched; read >= base.LogicalBytes {
+			t.Errorf("expected lazy loading to read less than the image (%d bytes), read %d", base.LogicalBytes, read)
+		}
+		t.Logf("✓ 容器启动按需读取 %d 字节,镜像共 %d 字节", trace.FaultBytes+trace.BytesFetched, base.LogicalBytes)
+	} else {
+		t.Log("fscache unavailable, skipping lazy loading checks")
+	}
+
+	h.mustCtr("image", "pull", "--snapshotter", snapshotterName, nginx)
+	out = h.mustCtr("run", "--rm", "--snapshotter", snapshotterName, nginx, "e2e-nginx", "nginx", "-v")
+	if !strings.Contains(out, "nginx version") {
+		t.Fatalf("unexpected nginx output: %q", out)
+	}
+	report, err := h.api.CompareBaseline(ctx, "e2e-busybox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.LogicalBytes <= 0 || report.StoredBytes > report.LogicalBytes {
+		t.Fatalf("unexpected dedup stats after pulling nginx: %+v", report)
+	}
+	t.Logf("✓ nginx 新增 %d 字节数据,实际存储 %d 字节", report.LogicalBytes, report.StoredBytes)
+
+	if _, err := h.api.RecordBaseline(ctx, "e2e-all"); err != nil {
+		t.Fatal(err)
+	}
+	h.mustCtr("image", "rm", "--sync", busybox)
+	h.mustCtr("image", "pull", "--snapshotter", snapshotterName, busybox)
+	report, err = h.api.CompareBaseline(ctx, "e2e-all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.StoredBytes > 0 {
+		t.Errorf("expected re-pulled busybox to reuse stored chunks, stored %d new bytes", report.StoredBytes)
+	}
+	t.Logf("✓ 重新拉取 busybox 没有新增 chunk 存储")
+}
+
+// startupTrace 等待快照键为 key 的冷启动追踪出现
+func (h *harness) startupTrace(ctx context.Context, key string) *client.StartupTrace {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		traces, err := h.api.StartupTraces(ctx)
+		if err != nil {
+			h.t.Fatal(err)
+		}
+		for i := range traces {
+			if traces[i].Key == key {
+				return &traces[i]
+			}
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return nil
+}
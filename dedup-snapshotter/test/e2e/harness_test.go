@@ -0,0 +1,247 @@
+//go:build e2e
+
+// Package e2e 启动真实的 containerd 和 dedup-snapshotter,通过 ctr 走完整的 gRPC 代理快照器路径。
+// 需要 root、containerd、ctr、mkfs.erofs 和能访问 Docker Hub 的网络,通常在特权容器或 VM 中运行:
+//
+//	make e2e            # 在当前机器上运行
+//	make e2e-container  # 在特权容器中运行
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/opencloudos/dedup-snapshotter/pkg/client"
+	"github.com/opencloudos/dedup-snapshotter/pkg/config"
+)
+
+const (
+	snapshotterName = "dedup"
+	namespace       = "e2e"
+)
+
+// harness 是一组独立的 containerd 和 dedup-snapshotter 进程,所有状态放在临时目录中
+type harness struct {
+	t        *testing.T
+	dir      string
+	ctrBin   string
+	address  string
+	api      *client.Client
+	fscache  bool
+	procs    []*process
+	logFiles []string
+}
+
+type process struct {
+	name string
+	cmd  *exec.Cmd
+	done chan struct{}
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func newHarness(t *testing.T) *harness {
+	if os.Geteuid() != 0 {
+		t.Skip("e2e tests require root")
+	}
+	containerdBin := lookPath(t, envOr("E2E_CONTAINERD", "containerd"))
+	ctrBin := lookPath(t, envOr("E2E_CTR", "ctr"))
+	lookPath(t, "mkfs.erofs")
+
+	// unix socket 路径有长度限制,不使用 t.TempDir
+	dir, err := os.MkdirTemp("", "dedup-e2e-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &harness{t: t, dir: dir, ctrBin: ctrBin, address: filepath.Join(dir, "containerd.sock")}
+	t.Cleanup(h.stop)
+
+	// 未显式关闭时,内核支持 cachefiles 按需模式才启用 fscache
+	_, err = os.Stat("/dev/cachefiles")
+	h.fscache = os.Getenv("E2E_FSCACHE") != "0" && err == nil
+
+	h.startSnapshotter()
+	h.startContainerd(containerdBin)
+	return h
+}
+
+func lookPath(t *testing.T, name string) string {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		t.Skipf("%s not found: %v", name, err)
+	}
+	return path
+}
+
+func (h *harness) startSnapshotter() {
+	bin := os.Getenv("E2E_SNAPSHOTTER")
+	if bin == "" {
+		bin = filepath.Join(h.dir, "dedup-snapshotter")
+		out, err := exec.Command("go", "build", "-o", bin, "../../cmd").CombinedOutput()
+		if err != nil {
+			h.t.Fatalf("failed to build snapshotter: %v\n%s", err, out)
+		}
+	}
+
+	root := filepath.Join(h.dir, "snapshotter")
+	cfg := config.DefaultConfig(root)
+	cfg.EnableErofs = true
+	cfg.EnableFscache = h.fscache
+	cfg.EnableMemDedup = false
+	cfg.KSM.Enabled = false
+	cfg.StartupTrace.Enabled = true
+	configPath := filepath.Join(h.dir, "snapshotter.json")
+	if err := cfg.Save(configPath); err != nil {
+		h.t.Fatal(err)
+	}
+
+	apiAddress := freeAddress(h.t)
+	socket := filepath.Join(h.dir, "snapshotter.sock")
+	h.start("snapshotter", exec.Command(bin), "ADDRESS="+socket, "ROOT="+root, "CONFIG="+configPath, "API_ADDRESS="+apiAddress)
+	h.waitFor("snapshotter socket", func() bool { return exists(socket) })
+
+	h.api = client.New(apiAddress)
+	h.waitFor("snapshotter API", func() bool {
+		_, err := h.api.Health(context.Background())
+		return err == nil
+	})
+}
+
+func (h *harness) startContainerd(bin string) {
+	configPath := filepath.Join(h.dir, "containerd.toml")
+	data := fmt.Sprintf(`version = 2
+root = %q
+state = %q
+
+[grpc]
+  address = %q
+
+[proxy_plugins.%s]
+  type = "snapshot"
+  address = %q
+`, filepath.Join(h.dir, "containerd", "root"), filepath.Join(h.dir, "containerd", "state"), h.address,
+		snapshotterName, filepath.Join(h.dir, "snapshotter.sock"))
+	if err := os.WriteFile(configPath, []byte(data), 0644); err != nil {
+		h.t.Fatal(err)
+	}
+
+	h.start("containerd", exec.Command(bin, "--config", configPath))
+	h.waitFor("containerd", func() bool {
+		_, err := h.ctr("version")
+		return err == nil
+	})
+}
+
+// start 启动进程,输出写入日志文件,测试失败时打印日志末尾
+func (h *harness) start(name string, cmd *exec.Cmd, env ...string) {
+	logPath := filepath.Join(h.dir, name+".log")
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	cmd.Stdout, cmd.Stderr = logFile, logFile
+	cmd.Env = append(os.Environ(), env...)
+	if err := cmd.Start(); err != nil {
+		h.t.Fatalf("failed to start %s: %v", name, err)
+	}
+	p := &process{name: name, cmd: cmd, done: make(chan struct{})}
+	go func() {
+		cmd.Wait()
+		logFile.Close()
+		close(p.done)
+	}()
+	h.procs = append(h.procs, p)
+	h.logFiles = append(h.logFiles, logPath)
+}
+
+func (h *harness) waitFor(what string, ready func() bool) {
+	deadline := time.Now().Add(time.Minute)
+	for !ready() {
+		if time.Now().After(deadline) {
+			h.t.Fatalf("timed out waiting for %s", what)
+		}
+		for _, p := range h.procs {
+			select {
+			case <-p.done:
+				h.t.Fatalf("%s exited while waiting for %s", p.name, what)
+			default:
+			}
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// stop 先停 containerd 再停快照器,快照器退出时卸载全部挂载
+func (h *harness) stop() {
+	for i := len(h.procs) - 1; i >= 0; i-- {
+		p := h.procs[i]
+		p.cmd.Process.Signal(syscall.SIGTERM)
+		select {
+		case <-p.done:
+		case <-time.After(30 * time.Second):
+			p.cmd.Process.Kill()
+			<-p.done
+		}
+	}
+
+	if h.t.Failed() {
+		for _, path := range h.logFiles {
+			data, _ := os.ReadFile(path)
+			if len(data) > 8192 {
+				data = data[len(data)-8192:]
+			}
+			h.t.Logf("=== %s ===\n%s", filepath.Base(path), data)
+		}
+	}
+	if os.Getenv("E2E_KEEP") == "" {
+		exec.Command("umount", "-R", "-l", h.dir).Run()
+		os.RemoveAll(h.dir)
+	}
+}
+
+// ctr 在测试命名空间中执行 ctr 命令,返回合并后的输出
+func (h *harness) ctr(args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, h.ctrBin, append([]string{"--address", h.address, "--namespace", namespace}, args...)...)
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	err := cmd.Run()
+	return strings.TrimSpace(out.String()), err
+}
+
+func (h *harness) mustCtr(args ...string) string {
+	out, err := h.ctr(args...)
+	if err != nil {
+		h.t.Fatalf("ctr %s failed: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return out
+}
+
+func freeAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}